@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+// sourceTypes are what --from accepts, by the extension files of that type
+// are found by.
+var sourceTypes = map[string]string{"cbr": ".cbr", "cb7": ".cb7", "cbt": ".cbt", "pdf": ".pdf"}
+
+// defaultSources leaves pdfs out, plenty of them in a library aren't comics.
+var defaultSources = []string{"cbr", "cb7", "cbt"}
+
+// sourceExtensions turns the types given to --from into the extensions
+// convert picks up.
+func sourceExtensions(from []string) (map[string]bool, error) {
+	exts := map[string]bool{}
+	for _, name := range from {
+		ext, ok := sourceTypes[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, errors.Errorf("unknown source type %q, expected cbr, cb7, cbt or pdf", name)
+		}
+		exts[ext] = true
+	}
+	return exts, nil
+}
+
+// parseExtensions turns --extensions into the suffixes they match, longest
+// first so cbr.bak wins over bak.
+func parseExtensions(extensions []string) ([]string, error) {
+	suffixes := []string{}
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.Trim(strings.TrimSpace(ext), "."))
+		if ext == "" || strings.ContainsAny(ext, `/\`) {
+			return nil, errors.Errorf("invalid extension %q", ext)
+		}
+		if ext == "cbz" {
+			return nil, errors.New("cbz is what gets written, it can't be converted")
+		}
+		suffixes = append(suffixes, "."+ext)
+	}
+	sort.SliceStable(suffixes, func(i, j int) bool { return len(suffixes[i]) > len(suffixes[j]) })
+	return suffixes, nil
+}
+
+// isSource reports whether name is one of the types being converted.
+func (c *converter) isSource(name string) bool {
+	exts := c.sources
+	if exts == nil {
+		exts, _ = sourceExtensions(defaultSources)
+	}
+	return exts[strings.ToLower(filepath.Ext(name))] || c.extraExtension(name) != ""
+}
+
+// extraExtension is the --extensions suffix name ends in, if any. What those
+// files are is told from their contents alone.
+func (c *converter) extraExtension(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range c.extensions {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return name[len(name)-len(ext):]
+		}
+	}
+	return ""
+}
+
+// converts reports whether file gets converted, a source or a wrapped cbz.
+// Of a split rar only the first volume does, standing for the whole set,
+// even named .rar.
+func (c *converter) converts(file string) bool {
+	if firstVolume(c.fs, file) != "" {
+		return false
+	}
+	if c.isSource(file) || (strings.ToLower(filepath.Ext(file)) == ".cbz" && isWrapped(c.fs, file)) {
+		return true
+	}
+	return strings.EqualFold(filepath.Ext(file), ".rar") && c.isSource(".cbr") && rarVolumes(c.fs, file) != nil
+}
+
+// sourceArchive is a cbr or cbz opened for reading its entries.
+type sourceArchive struct {
+	fs.FS
+	file   hackpadfs.File
+	format archiver.Archival
+	stream *io.SectionReader
+}
+
+func (a *sourceArchive) Close() error {
+	return a.file.Close()
+}
+
+// openArchive identifies the archive at name and opens it as a filesystem,
+// whatever format it turns out to really be.
+func openArchive(ctx context.Context, fsys hackpadfs.FS, name string) (*sourceArchive, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "stating file")
+	}
+	if info.IsDir() {
+		return nil, errors.New("is a directory")
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening archive")
+	}
+
+	format, _, err := archiver.Identify(name, file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "unable to identify")
+	}
+
+	archival, ok := format.(archiver.Archival)
+	if !ok {
+		file.Close()
+		return nil, errors.Errorf("%s is not an archive", format.Name())
+	}
+
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		file.Close()
+		return nil, errors.New("filesystem doesn't support random access reads")
+	}
+
+	stream := io.NewSectionReader(readerAt, 0, info.Size())
+	return &sourceArchive{
+		FS:     archiver.ArchiveFS{Stream: stream, Format: archival, Context: ctx},
+		file:   file,
+		format: archival,
+		stream: stream,
+	}, nil
+}
+
+// pages returns the names of the entries that are pages, in archive order.
+func (a *sourceArchive) pages(filter cbr2cbz.EntryFilter) ([]string, error) {
+	pages := []string{}
+	err := fs.WalkDir(a, ".", func(name string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !de.IsDir() && filter.Classify(name) == cbr2cbz.EntryPage {
+			pages = append(pages, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "walking archive")
+	}
+	sort.Strings(pages)
+	return pages, nil
+}
+
+// openEntries opens file and lists the entries engine packs from it, cbzs
+// the way optimize does and everything else the way convert does. The file
+// is left open for the entries to be read from.
+func openEntries(ctx context.Context, fsys hackpadfs.FS, engine *cbr2cbz.Converter, file string) (fs.File, []archiver.File, error) {
+	src, err := fsys.Open(pathToFsPath(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := src.Stat()
+	if err != nil {
+		src.Close()
+		return nil, nil, err
+	}
+	reader, ok := src.(io.ReaderAt)
+	if !ok {
+		src.Close()
+		return nil, nil, errors.New("filesystem doesn't support random access reads")
+	}
+
+	var entries []archiver.File
+	format, _, err := archiver.Identify("", io.NewSectionReader(reader, 0, info.Size()))
+	if _, isZip := format.(archiver.Zip); err == nil && isZip {
+		entries, err = engine.ZipEntries(ctx, file, reader, info.Size(), &cbr2cbz.Progress{})
+	} else {
+		entries, err = engine.Entries(ctx, file, reader, info.Size(), &cbr2cbz.Progress{})
+	}
+	if err != nil {
+		src.Close()
+		return nil, nil, err
+	}
+	return src, entries, nil
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// copyAttributes gives cbzFile the modification time and permissions of
+// cbrFile, so media scanners see it as added when the original was. Either
+// is skipped on filesystems that don't have it, failing otherwise is only
+// a warning.
+func (c *converter) copyAttributes(cbrFile string, cbzFile string) {
+	if !c.preserveAttrs {
+		return
+	}
+	info, err := hackpadfs.Stat(c.fs, pathToFsPath(cbrFile))
+	if err == nil && info.Mode().Perm() != 0 {
+		err = hackpadfs.Chmod(c.fs, pathToFsPath(cbzFile), info.Mode().Perm())
+	}
+	if err == nil || errors.Is(err, hackpadfs.ErrNotImplemented) {
+		err = hackpadfs.Chtimes(c.fs, pathToFsPath(cbzFile), time.Now(), info.ModTime())
+	}
+	if err != nil && !errors.Is(err, hackpadfs.ErrNotImplemented) {
+		c.warn(cbr2cbz.CodeAttributesNotCopied, cbrFile, "Unable to copy the modification time and permissions of %s to %s: %s", cbrFile, cbzFile, err.Error())
+	}
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	stateFileName string
+	resumeBatch   bool
+)
+
+// batchHeader is the first line of a state file, the batch as it was
+// found.
+type batchHeader struct {
+	Paths []string `json:"paths"`
+	// Fingerprint is of the conversion options, resuming with others would
+	// mix two kinds of output
+	Fingerprint string            `json:"fingerprint"`
+	Files       []string          `json:"files"`
+	Roots       map[string]string `json:"roots"`
+}
+
+// batchRecord is a line for each file that finished, one way or another.
+type batchRecord struct {
+	File   string `json:"file"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchState is the state file of a running batch. Lines are only ever
+// appended, so a batch of any size costs a line per file and whatever was
+// written before a crash is still readable.
+type batchState struct {
+	file *os.File
+}
+
+// defaultStatePath is the state file of a batch over paths when
+// --state-file isn't given: one for each set of paths under the user cache
+// dir, so runs over other libraries have their own and nothing is left
+// where cbr2cbz was run.
+func defaultStatePath(paths []string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(sortedCopy(paths), "\n")))
+	return filepath.Join(dir, "cbr2cbz", "state", hex.EncodeToString(sum[:8])+".json")
+}
+
+// createBatchState starts the state file at name over for a new batch.
+func createBatchState(name string, header batchHeader) (*batchState, error) {
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating state file directory")
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating state file")
+	}
+	s := &batchState{file: f}
+	err = s.write(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// appendBatchState carries on with the state file at name.
+func appendBatchState(name string) (*batchState, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening state file")
+	}
+	return &batchState{file: f}, nil
+}
+
+// loadBatchState reads the state file at name, returning the batch and
+// which of its files are done. A last line cut off by a crash is ignored.
+func loadBatchState(name string) (*batchHeader, map[string]batchRecord, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, errors.Errorf("no batch to resume, %s doesn't exist", name)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "opening state file")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<30)
+	if !scanner.Scan() {
+		return nil, nil, errors.Errorf("%s is empty", name)
+	}
+	header := &batchHeader{}
+	err = json.Unmarshal(scanner.Bytes(), header)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parsing %s", name)
+	}
+	records := map[string]batchRecord{}
+	for scanner.Scan() {
+		record := batchRecord{}
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			break
+		}
+		records[record.File] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.Wrapf(err, "reading %s", name)
+	}
+	return header, records, nil
+}
+
+func (s *batchState) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "encoding state")
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	return errors.Wrap(err, "writing state file")
+}
+
+// record notes a file as done. Failing to is only logged, the batch
+// matters more than being able to resume it.
+func (c *converter) record(record batchRecord) {
+	if c.state == nil {
+		return
+	}
+	err := c.state.write(record)
+	if err != nil {
+		c.logger.Printf("%s, resuming will redo %s\n", err.Error(), record.File)
+	}
+}
+
+// startBatchState writes the state file for the files just found, or for a
+// resumed batch keeps adding to it.
+func (c *converter) startBatchState() error {
+	if c.statePath == "" {
+		return nil
+	}
+	var err error
+	if c.resume {
+		c.state, err = appendBatchState(c.statePath)
+		return err
+	}
+	c.state, err = createBatchState(c.statePath, batchHeader{
+		Paths:       c.paths,
+		Fingerprint: c.packer().Options().Fingerprint(),
+		Files:       c.cbrFiles,
+		Roots:       c.roots,
+	})
+	return err
+}
+
+// finishBatchState closes the state file, removing it when there is
+// nothing left to resume.
+func (c *converter) finishBatchState(complete bool) {
+	if c.state == nil {
+		return
+	}
+	c.state.file.Close()
+	c.state = nil
+	if complete {
+		os.Remove(c.statePath)
+	}
+}
+
+// resumeFiles picks up the batch in the state file instead of looking for
+// files again, leaving out those that were converted. Failed files are
+// tried again.
+func (c *converter) resumeFiles(paths []string) error {
+	header, records, err := loadBatchState(c.statePath)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(header.Paths, paths) {
+		return errors.Errorf("%s is a batch of %v, not %v", c.statePath, header.Paths, paths)
+	}
+	if fingerprint := c.packer().Options().Fingerprint(); header.Fingerprint != fingerprint {
+		return errors.Errorf("the conversion options changed since the batch started (%s, now %s)", header.Fingerprint, fingerprint)
+	}
+
+	c.roots = header.Roots
+	if c.roots == nil {
+		c.roots = map[string]string{}
+	}
+	c.cbrFiles = []string{}
+	c.cbrSize = 0
+	retrying := 0
+	for _, file := range header.Files {
+		switch records[file].Status {
+		case "converted":
+			continue
+		case "failed":
+			retrying++
+		}
+		info, err := fs.Stat(c.fs, pathToFsPath(file))
+		if errors.Is(err, fs.ErrNotExist) {
+			// dealt with some other way since
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "getting cbr file stats")
+		}
+		c.cbrFiles = append(c.cbrFiles, file)
+		c.cbrSize += uint64(info.Size())
+	}
+	c.logger.Printf("Resuming %s, %d of %d files left, %d of them failed before\n", c.statePath, len(c.cbrFiles), len(header.Files), retrying)
+	c.allFiles, c.allSize = c.cbrFiles, c.cbrSize
+	return nil
+}
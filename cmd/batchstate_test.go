@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_convertResume(t *testing.T) {
+	t.Run("interrupted", func(t *testing.T) {
+		fsys, err := setupFS(t, filenameBytes{
+			"library/a.cbr": realCBRContents,
+			"library/b.cbr": realCBRContents,
+		})
+		require.NoError(t, err)
+		statePath := filepath.Join(t.TempDir(), "state.json")
+
+		// a batch that got through a.cbr before it was stopped
+		c := &converter{fs: fsys, logger: testLogger{t}}
+		state, err := createBatchState(statePath, batchHeader{
+			Paths:       []string{"/library"},
+			Fingerprint: c.packer().Options().Fingerprint(),
+			Files:       []string{"/library/a.cbr", "/library/b.cbr"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, state.write(batchRecord{File: "/library/a.cbr", Status: "converted", Output: "/library/a.cbz"}))
+		require.NoError(t, state.file.Close())
+
+		c.statePath, c.resume = statePath, true
+		require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+		require.Equal(t, []string{"/library/b.cbz"}, c.converted)
+		// a.cbr wasn't looked at again
+		_, err = hackpadfs.Stat(fsys, "library/a.cbr")
+		require.NoError(t, err)
+		_, err = os.Stat(statePath)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("failed files are retried", func(t *testing.T) {
+		fsys, err := setupFS(t, filenameBytes{
+			"library/a.cbr": realCBRContents,
+			"library/b.cbr": []byte("not yet downloaded"),
+		})
+		require.NoError(t, err)
+		statePath := filepath.Join(t.TempDir(), "state.json")
+
+		c := &converter{fs: fsys, logger: testLogger{t}, statePath: statePath}
+		require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+		require.Len(t, c.failed, 1)
+		header, records, err := loadBatchState(statePath)
+		require.NoError(t, err)
+		require.Equal(t, []string{"/library/a.cbr", "/library/b.cbr"}, header.Files)
+		require.Equal(t, "converted", records["/library/a.cbr"].Status)
+		require.Equal(t, "failed", records["/library/b.cbr"].Status)
+
+		require.NoError(t, hackpadfs.WriteFullFile(fsys, "library/b.cbr", realCBRContents, 0o644))
+		c.resume = true
+		require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+		require.Equal(t, []string{"/library/b.cbz"}, c.converted)
+		require.Empty(t, c.failed)
+	})
+
+	t.Run("other batch", func(t *testing.T) {
+		fsys, err := setupFS(t, filenameBytes{"library/a.cbr": realCBRContents})
+		require.NoError(t, err)
+		statePath := filepath.Join(t.TempDir(), "state.json")
+
+		c := &converter{fs: fsys, logger: testLogger{t}, statePath: statePath, resume: true}
+		require.ErrorContains(t, c.runConvert(context.Background(), []string{"/library"}), "no batch to resume")
+
+		state, err := createBatchState(statePath, batchHeader{Paths: []string{"/elsewhere"}})
+		require.NoError(t, err)
+		require.NoError(t, state.file.Close())
+		require.ErrorContains(t, c.runConvert(context.Background(), []string{"/library"}), "is a batch of [/elsewhere]")
+	})
+}
+
+func Test_defaultStatePath(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	cache, err := os.UserCacheDir()
+	require.NoError(t, err)
+
+	a := defaultStatePath([]string{"/comics", "/manga"})
+	require.Equal(t, filepath.Join(cache, "cbr2cbz", "state"), filepath.Dir(a))
+	require.Equal(t, a, defaultStatePath([]string{"/manga", "/comics"}), "the same set of paths")
+	require.NotEqual(t, a, defaultStatePath([]string{"/comics"}))
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !darwin
+
+package cmd
+
+import "os/exec"
+
+// openBrowser opens url in the default browser.
+func openBrowser(url string) error {
+	return exec.Command("xdg-open", url).Start()
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+var (
+	writeChecksums    bool
+	checksumsFileName string
+)
+
+// checksumsSuffix replaces the .cbz of a conversion's output for its
+// checksums.
+const checksumsSuffix = ".sha256.json"
+
+// pageChecksum is the SHA-256 of the contents of one page.
+type pageChecksum struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// checksumManifest records a conversion page by page, so it can be shown
+// later that the cbz holds the same images as what it was converted from.
+type checksumManifest struct {
+	Source       string         `json:"source"`
+	SourceSHA256 string         `json:"source_sha256"`
+	SourcePages  []pageChecksum `json:"source_pages"`
+	Output       string         `json:"output"`
+	OutputSHA256 string         `json:"output_sha256"`
+	OutputPages  []pageChecksum `json:"output_pages"`
+	// Lossless is whether the output has exactly the pages of the source,
+	// going by their contents rather than their names
+	Lossless bool `json:"lossless"`
+}
+
+// checksumsFile is the batch-level manifest --checksums-file writes.
+type checksumsFile struct {
+	Version string             `json:"version"`
+	Files   []checksumManifest `json:"files"`
+}
+
+// checksumsPath is where the checksums of cbzFile go.
+func checksumsPath(cbzFile string) string {
+	return strings.TrimSuffix(cbzFile, filepath.Ext(cbzFile)) + checksumsSuffix
+}
+
+// checksumming is whether conversions need their pages hashed.
+func (c *converter) checksumming() bool {
+	return c.checksums || c.checksumsPath != ""
+}
+
+// pageSums hashes every page of file, in the order they are packed.
+func pageSums(ctx context.Context, fsys hackpadfs.FS, file string, entries cbr2cbz.EntryFilter) ([]pageChecksum, error) {
+	archive, err := openArchive(ctx, fsys, pathToFsPath(file))
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	pages, err := archive.pages(entries)
+	if err != nil {
+		return nil, err
+	}
+	sums := make([]pageChecksum, 0, len(pages))
+	for _, page := range pages {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		f, err := archive.Open(page)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening %s", page)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", page)
+		}
+		sums = append(sums, pageChecksum{Name: page, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	return sums, nil
+}
+
+// samePages is whether a and b hold the same contents, however named or
+// ordered.
+func samePages(a []pageChecksum, b []pageChecksum) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, page := range a {
+		counts[page.SHA256]++
+	}
+	for _, page := range b {
+		counts[page.SHA256]--
+		if counts[page.SHA256] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pageFilter picks the entries hashed as pages, the ones packed as pages
+// with the options of this run.
+func (c *converter) pageFilter() cbr2cbz.EntryFilter {
+	return cbr2cbz.NewEntryFilter(c.packer().Options().ImageExtensions, nil)
+}
+
+// addChecksums hashes the pages of cbzFile, converted from cbrFile whose
+// checksum was sourceSum and pages sourcePages, writing them next to it
+// with --checksums and keeping them for --checksums-file. Conversions split
+// into chapters made more than one output and get none.
+func (c *converter) addChecksums(ctx context.Context, cbrFile string, cbzFile string, sourceSum string, sourcePages []pageChecksum) error {
+	if info, err := hackpadfs.Stat(c.fs, pathToFsPath(cbzFile)); err != nil || info.IsDir() {
+		return nil
+	}
+	outputSum, err := fileSum(c.fs, pathToFsPath(cbzFile))
+	if err != nil {
+		return err
+	}
+	outputPages, err := pageSums(ctx, c.fs, cbzFile, c.pageFilter())
+	if err != nil {
+		return err
+	}
+	manifest := checksumManifest{
+		Source:       cbrFile,
+		SourceSHA256: sourceSum,
+		SourcePages:  sourcePages,
+		Output:       cbzFile,
+		OutputSHA256: outputSum,
+		OutputPages:  outputPages,
+		Lossless:     samePages(sourcePages, outputPages),
+	}
+
+	if c.checksumsPath != "" {
+		c.checksumsMu.Lock()
+		c.manifests = append(c.manifests, manifest)
+		c.checksumsMu.Unlock()
+	}
+	if !c.checksums {
+		return nil
+	}
+	// next to the cbz, the folder is wherever it goes
+	manifest.Source, manifest.Output = filepath.Base(cbrFile), filepath.Base(cbzFile)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return hackpadfs.WriteFullFile(c.fs, pathToFsPath(checksumsPath(cbzFile)), append(data, '\n'), 0644)
+}
+
+// writeChecksumsFile writes the checksums of every file converted to
+// c.checksumsPath.
+func (c *converter) writeChecksumsFile() error {
+	if c.checksumsPath == "" {
+		return nil
+	}
+	manifests := append([]checksumManifest{}, c.manifests...)
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Source < manifests[j].Source })
+
+	data, err := json.MarshalIndent(checksumsFile{Version: buildVersion, Files: manifests}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(c.checksumsPath, append(data, '\n'), 0o644)
+	if err != nil {
+		return errors.Wrap(err, "writing checksums")
+	}
+	c.logger.Printf("Checksums have been written to %s\n", c.checksumsPath)
+	return nil
+}
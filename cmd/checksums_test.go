@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checksums(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga 001.cbt": makeTar(t, map[string]string{"001.jpg": "page one", "002.jpg": "page two", "notes.txt": "not a page"}),
+		"library/Saga 002.cbt": makeTar(t, map[string]string{"001.jpg": "page one"}),
+	})
+	require.NoError(t, err)
+
+	batch := filepath.Join(t.TempDir(), "checksums.json")
+	c := &converter{fs: fsys, logger: testLogger{t}, checksums: true, checksumsPath: batch}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.converted, 2)
+
+	data, err := fs.ReadFile(fsys, "library/Saga 001.sha256.json")
+	require.NoError(t, err)
+	manifest := checksumManifest{}
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Equal(t, "Saga 001.cbt", manifest.Source)
+	require.Equal(t, "Saga 001.cbz", manifest.Output)
+	output, err := fileSum(fsys, "library/Saga 001.cbz")
+	require.NoError(t, err)
+	require.Equal(t, output, manifest.OutputSHA256)
+	require.Len(t, manifest.SourcePages, 2)
+	require.Len(t, manifest.OutputPages, 2)
+	require.Equal(t, manifest.SourcePages[0].SHA256, manifest.OutputPages[0].SHA256)
+	require.True(t, manifest.Lossless)
+
+	data, err = os.ReadFile(batch)
+	require.NoError(t, err)
+	all := checksumsFile{}
+	require.NoError(t, json.Unmarshal(data, &all))
+	require.Len(t, all.Files, 2)
+	require.Equal(t, "/library/Saga 001.cbt", all.Files[0].Source)
+	require.Equal(t, "/library/Saga 002.cbz", all.Files[1].Output)
+}
+
+func Test_samePages(t *testing.T) {
+	a := []pageChecksum{{Name: "1.jpg", SHA256: "aa"}, {Name: "2.jpg", SHA256: "bb"}}
+	require.True(t, samePages(a, []pageChecksum{{Name: "b.jpg", SHA256: "bb"}, {Name: "a.jpg", SHA256: "aa"}}))
+	require.False(t, samePages(a, []pageChecksum{{Name: "1.jpg", SHA256: "aa"}, {Name: "2.jpg", SHA256: "aa"}}))
+	require.False(t, samePages(a, a[:1]))
+}
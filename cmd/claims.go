@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+)
+
+// errClaimed means another instance is already working on a file.
+var errClaimed = errors.New("claimed by another instance")
+
+// claimStore lets several instances share a library without converting the
+// same file twice. Each file being worked on has a lease file in dir,
+// usually on the same share as the library. Leases expire unless renewed so
+// a crashed instance doesn't hold files forever.
+type claimStore struct {
+	fs    hackpadfs.FS
+	dir   string
+	owner string
+	ttl   time.Duration
+}
+
+type lease struct {
+	Owner   string    `json:"owner"`
+	File    string    `json:"file"`
+	Expires time.Time `json:"expires"`
+}
+
+func newClaimStore(fsys hackpadfs.FS, dir string, ttl time.Duration) (*claimStore, error) {
+	err := hackpadfs.MkdirAll(fsys, pathToFsPath(dir), 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating claim directory")
+	}
+
+	host, _ := os.Hostname()
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+
+	return &claimStore{
+		fs:    fsys,
+		dir:   pathToFsPath(dir),
+		owner: fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(nonce)),
+		ttl:   ttl,
+	}, nil
+}
+
+func (s *claimStore) leasePath(file string) string {
+	sum := sha256.Sum256([]byte(file))
+	return path.Join(s.dir, hex.EncodeToString(sum[:16])+".lease")
+}
+
+// claim takes the lease on file, returning errClaimed if another live
+// instance holds it. The returned release function must be called when done,
+// and the lease is renewed in the background until then.
+func (s *claimStore) claim(ctx context.Context, file string) (release func(), err error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	name := s.leasePath(file)
+	for attempt := 0; attempt < 2; attempt++ {
+		err = s.create(name, file)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		stolen, err := s.stealExpired(name)
+		if err != nil {
+			return nil, err
+		}
+		if !stolen {
+			return nil, errClaimed
+		}
+	}
+	if err != nil {
+		return nil, errClaimed
+	}
+
+	done := make(chan struct{})
+	go s.renew(ctx, name, file, done)
+
+	return func() {
+		close(done)
+		// only our own, the lease may have expired and been taken over
+		if l, err := s.read(name); err == nil && l.Owner == s.owner {
+			hackpadfs.Remove(s.fs, name)
+		}
+	}, nil
+}
+
+func (s *claimStore) create(name string, file string) error {
+	// O_EXCL makes this atomic on real filesystems, not every hackpadfs
+	// backend honours it though
+	if _, err := hackpadfs.Stat(s.fs, name); err == nil {
+		return fs.ErrExist
+	}
+
+	f, err := hackpadfs.OpenFile(s.fs, name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(lease{Owner: s.owner, File: file, Expires: time.Now().Add(s.ttl)})
+	if err != nil {
+		return err
+	}
+	_, err = hackpadfs.WriteFile(f, data)
+	return errors.Wrap(err, "writing lease")
+}
+
+// stealExpired removes the lease at name if it has expired. It is moved
+// aside first so only one instance can win, and put back if it turns out
+// someone renewed or replaced it in the meantime.
+func (s *claimStore) stealExpired(name string) (bool, error) {
+	current, err := s.read(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// still being written, or left half written by an instance that
+		// crashed, so it expires a ttl after it was last written
+		info, statErr := hackpadfs.Stat(s.fs, name)
+		if errors.Is(statErr, fs.ErrNotExist) {
+			return true, nil
+		}
+		if statErr != nil {
+			return false, errors.Wrap(statErr, "checking lease")
+		}
+		current, err = lease{Expires: info.ModTime().Add(s.ttl)}, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if time.Now().Before(current.Expires) {
+		return false, nil
+	}
+
+	tomb := name + "." + s.ownerSlug() + ".expired"
+	err = hackpadfs.Rename(s.fs, name, tomb)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "taking over expired lease")
+	}
+
+	taken, err := s.read(tomb)
+	if err == nil && (taken.Owner != current.Owner || current.Owner != "" && !taken.Expires.Equal(current.Expires)) {
+		// not the lease we looked at, hand it back
+		hackpadfs.Rename(s.fs, tomb, name)
+		return false, nil
+	}
+	hackpadfs.Remove(s.fs, tomb)
+	return true, nil
+}
+
+func (s *claimStore) ownerSlug() string {
+	sum := sha256.Sum256([]byte(s.owner))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (s *claimStore) read(name string) (lease, error) {
+	l := lease{}
+	data, err := hackpadfs.ReadFile(s.fs, name)
+	if err != nil {
+		return l, err
+	}
+	err = json.Unmarshal(data, &l)
+	return l, errors.Wrap(err, "parsing lease")
+}
+
+// renew pushes the lease's expiry out every third of the ttl until done,
+// or until the lease turns out to be someone else's, having expired and
+// been taken over.
+func (s *claimStore) renew(ctx context.Context, name string, file string, done chan struct{}) {
+	ticker := time.NewTicker(s.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.renewOnce(name, file) {
+				return
+			}
+		}
+	}
+}
+
+// renewOnce rewrites our lease at name with a new expiry, reporting false
+// if it isn't ours any more. The new lease is renamed into place so nobody
+// ever reads it half written.
+func (s *claimStore) renewOnce(name string, file string) bool {
+	current, err := s.read(name)
+	if err != nil || current.Owner != s.owner {
+		return false
+	}
+	data, err := json.Marshal(lease{Owner: s.owner, File: file, Expires: time.Now().Add(s.ttl)})
+	if err != nil {
+		return true
+	}
+	tmp := tempName(name)
+	if err := hackpadfs.WriteFullFile(s.fs, tmp, data, 0644); err != nil {
+		hackpadfs.Remove(s.fs, tmp)
+		return true
+	}
+	if err := hackpadfs.Rename(s.fs, tmp, name); err != nil {
+		hackpadfs.Remove(s.fs, tmp)
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	memfs "github.com/hack-pad/hackpadfs/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimStore(t *testing.T) {
+	fsys, err := memfs.NewFS()
+	require.NoError(t, err)
+
+	a, err := newClaimStore(fsys, "/claims", time.Minute)
+	require.NoError(t, err)
+	b, err := newClaimStore(fsys, "/claims", time.Minute)
+	require.NoError(t, err)
+	require.NotEqual(t, a.owner, b.owner)
+
+	ctx := context.Background()
+	release, err := a.claim(ctx, "/comics/a.cbr")
+	require.NoError(t, err)
+
+	_, err = b.claim(ctx, "/comics/a.cbr")
+	require.ErrorIs(t, err, errClaimed)
+
+	other, err := b.claim(ctx, "/comics/b.cbr")
+	require.NoError(t, err)
+	other()
+
+	release()
+	release, err = b.claim(ctx, "/comics/a.cbr")
+	require.NoError(t, err)
+	release()
+}
+
+func TestClaimStoreExpiredLease(t *testing.T) {
+	fsys, err := memfs.NewFS()
+	require.NoError(t, err)
+
+	a, err := newClaimStore(fsys, "/claims", time.Minute)
+	require.NoError(t, err)
+
+	// a lease left behind by an instance that died
+	data, err := json.Marshal(lease{Owner: "gone", File: "/a.cbr", Expires: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, a.leasePath("/a.cbr"), data, 0644))
+
+	release, err := a.claim(context.Background(), "/a.cbr")
+	require.NoError(t, err)
+	defer release()
+
+	l, err := a.read(a.leasePath("/a.cbr"))
+	require.NoError(t, err)
+	require.Equal(t, a.owner, l.Owner)
+}
+
+func TestNilClaimStore(t *testing.T) {
+	var s *claimStore
+	release, err := s.claim(context.Background(), "/a.cbr")
+	require.NoError(t, err)
+	release()
+}
+
+func TestClaimStoreUnparseableLease(t *testing.T) {
+	fsys, err := memfs.NewFS()
+	require.NoError(t, err)
+
+	a, err := newClaimStore(fsys, "/claims", time.Minute)
+	require.NoError(t, err)
+	name := a.leasePath("/a.cbr")
+
+	// another instance is between creating the lease and writing it
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, name, nil, 0644))
+	_, err = a.claim(context.Background(), "/a.cbr")
+	require.ErrorIs(t, err, errClaimed)
+
+	// or died there a while ago
+	old := time.Now().Add(-2 * time.Minute)
+	require.NoError(t, hackpadfs.Chtimes(fsys, name, old, old))
+	release, err := a.claim(context.Background(), "/a.cbr")
+	require.NoError(t, err)
+	release()
+}
+
+func TestClaimStoreTakenOver(t *testing.T) {
+	fsys, err := memfs.NewFS()
+	require.NoError(t, err)
+
+	a, err := newClaimStore(fsys, "/claims", time.Minute)
+	require.NoError(t, err)
+	b, err := newClaimStore(fsys, "/claims", time.Minute)
+	require.NoError(t, err)
+	name := a.leasePath("/a.cbr")
+
+	release, err := a.claim(context.Background(), "/a.cbr")
+	require.NoError(t, err)
+
+	// a's lease expired and b took it over
+	data, err := json.Marshal(lease{Owner: b.owner, File: "/a.cbr", Expires: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, name, data, 0644))
+
+	require.False(t, a.renewOnce(name, "/a.cbr"))
+	release()
+	l, err := a.read(name)
+	require.NoError(t, err)
+	require.Equal(t, b.owner, l.Owner, "neither renewing nor releasing touches b's lease")
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// errorCode is the code reported for a failed conversion.
+func errorCode(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, errClaimed):
+		return cbr2cbz.CodeClaimed
+	case errors.Is(err, errOutputSkipped):
+		return cbr2cbz.CodeOutputSkipped
+	case errors.Is(err, errOutputExists):
+		return cbr2cbz.CodeOutputExists
+	case errors.Is(err, errCrashed):
+		return cbr2cbz.CodeCrashed
+	case errors.Is(err, errReadOnlySource):
+		return cbr2cbz.CodeReadOnlySource
+	case errors.Is(err, errPagesMismatch):
+		return cbr2cbz.CodePagesMismatch
+	case errors.Is(err, cbr2cbz.ErrNotArchive):
+		return cbr2cbz.CodeNotArchive
+	case errors.Is(err, cbr2cbz.ErrDecompressionLimit):
+		return cbr2cbz.CodeDecompressionLimit
+	case errors.Is(err, errStalled):
+		return cbr2cbz.CodeStalled
+	case errors.Is(err, errFileTimeout):
+		return cbr2cbz.CodeFileTimeout
+	case errors.Is(err, errPreHook):
+		return cbr2cbz.CodePreHookFailed
+	case errors.Is(err, errPasswordRequired), errors.Is(err, errWrongPassword):
+		return cbr2cbz.CodePassword
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return cbr2cbz.CodeCanceled
+	case isFileLimitError(err):
+		return cbr2cbz.CodeOutOfFiles
+	case isChecksumError(err):
+		return cbr2cbz.CodeCRCMismatch
+	case isIOError(err):
+		return cbr2cbz.CodeIOError
+	}
+	return cbr2cbz.CodeUnknown
+}
+
+// isChecksumError matches zip's checksum error, and rar and 7z ones which
+// aren't exported so only their message can be checked.
+func isChecksumError(err error) bool {
+	if errors.Is(err, zip.ErrChecksum) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "checksum") || strings.Contains(msg, " crc")
+}
+
+// warn logs a warning about cbrFile prefixed with its code and emits it as a
+// warning event.
+func (c *converter) warn(code string, cbrFile string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	c.logFor(cbrFile).Printf("[%s] %s\n", code, msg)
+	// roots is only looked up with events on, watch adds to it while files
+	// convert and never turns them on
+	if c.events != nil {
+		c.events.emit(logEvent{Action: "warning", Code: code, File: cbrFile, Library: c.roots[cbrFile], Error: msg})
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"syscall"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_errorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"not an archive", cbr2cbz.ErrNotArchive, cbr2cbz.CodeNotArchive},
+		{"zip checksum", errors.Wrap(zip.ErrChecksum, "reading 001.jpg"), cbr2cbz.CodeCRCMismatch},
+		{"rar checksum", errors.New("rardecode: bad file checksum"), cbr2cbz.CodeCRCMismatch},
+		{"decompression limit", errors.Wrap(cbr2cbz.ErrDecompressionLimit, "001.jpg"), cbr2cbz.CodeDecompressionLimit},
+		{"stalled", errors.Wrapf(errStalled, "no data read or written for %s", "1m"), cbr2cbz.CodeStalled},
+		{"out of files", errors.Wrap(syscall.EMFILE, "open"), cbr2cbz.CodeOutOfFiles},
+		{"io error", errors.Wrap(syscall.EIO, "read"), cbr2cbz.CodeIOError},
+		{"password", errPasswordRequired, cbr2cbz.CodePassword},
+		{"canceled", context.Canceled, cbr2cbz.CodeCanceled},
+		{"claimed", errClaimed, cbr2cbz.CodeClaimed},
+		{"crashed", &crashError{value: "boom"}, cbr2cbz.CodeCrashed},
+		{"pre-hook", errors.Wrap(errPreHook, "exit status 1: no space"), cbr2cbz.CodePreHookFailed},
+		{"read-only source", errors.Wrap(errReadOnlySource, "can't write to library"), cbr2cbz.CodeReadOnlySource},
+		{"unknown", errors.New("something else"), cbr2cbz.CodeUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, errorCode(tt.err))
+		})
+	}
+}
+
+func Test_warn(t *testing.T) {
+	out := &bytes.Buffer{}
+	c := &converter{logger: testLogger{t}, events: newEventLog(out)}
+	c.warn(cbr2cbz.CodeJunkRemoved, "/library/a.cbr", "Dropping %s from %s, it is junk", "Thumbs.db", "/library/a.cbr")
+
+	var e logEvent
+	require.NoError(t, json.Unmarshal(out.Bytes(), &e))
+	require.Equal(t, "warning", e.Action)
+	require.Equal(t, cbr2cbz.CodeJunkRemoved, e.Code)
+	require.Equal(t, "/library/a.cbr", e.File)
+	require.Equal(t, "Dropping Thumbs.db from /library/a.cbr, it is junk", e.Error)
+}
@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	archiveDeleteLocal bool
+	archiveDryRun      bool
+)
+
+// archiveManifestName is where archive records what is in dst.
+const archiveManifestName = ".cbr2cbz-archive.json"
+
+// archiveCmd represents the archive command
+var archiveCmd = &cobra.Command{
+	Use:   "archive <src> <dst>",
+	Short: "Copies converted cbz files to cold storage, checking every copy",
+	Long: `Copies every cbz under src into dst, keeping the layout, for putting a converted
+library away on another disk, a bucket or a NAS. dst can be a local directory or an
+s3://, sftp:// or webdav(s):// url.
+
+Each copy is read back and its SHA-256 compared against the original before it
+takes its final name, and the checksums go into ` + archiveManifestName + ` in
+dst. Running it again only copies what is new or changed. With --delete-local the
+local cbz is removed once its copy is verified.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		if isRemotePath(args[0]) {
+			logger.Fatal("src has to be a local directory")
+		}
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		src, err := localDir(fsys, args[0], false)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		dst, err := openArchiveDestination(fsys, args[1])
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		a := &coldArchiver{
+			src:         src,
+			dst:         dst,
+			logger:      logger,
+			deleteLocal: archiveDeleteLocal,
+			dryRun:      archiveDryRun,
+		}
+		err = a.run(cmd.Context())
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+
+	addRemoteFlags(archiveCmd)
+	archiveCmd.Flags().BoolVar(&archiveDeleteLocal, "delete-local", false, "remove each cbz from src once its copy in dst is verified")
+	archiveCmd.Flags().BoolVarP(&archiveDryRun, "dry-run", "n", false, "only log what would be archived")
+}
+
+// openArchiveDestination is the directory dst names on the local disk or a
+// remote, created if it isn't there yet.
+func openArchiveDestination(local hackpadfs.FS, dst string) (hackpadfs.FS, error) {
+	if isRemotePath(dst) {
+		server, key, err := splitRemotePath(dst)
+		if err != nil {
+			return nil, err
+		}
+		fsys, err := openRemote(server)
+		if err != nil {
+			return nil, err
+		}
+		dir := strings.TrimPrefix(key, "/")
+		if dir == "" {
+			return fsys, nil
+		}
+		err = hackpadfs.MkdirAll(fsys, dir, 0755)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating destination")
+		}
+		return &remoteSub{fs: fsys, dir: dir}, nil
+	}
+
+	return localDir(local, dst, true)
+}
+
+// archiveManifest records each file archive copied, keyed by its path in
+// dst.
+type archiveManifest struct {
+	Files map[string]archiveEntry `json:"files"`
+}
+
+// archiveEntry is a verified copy in dst.
+type archiveEntry struct {
+	Size int64 `json:"size"`
+	// ModTime is that of the original when it was copied
+	ModTime  time.Time `json:"mod_time"`
+	SHA256   string    `json:"sha256"`
+	Archived time.Time `json:"archived"`
+}
+
+// coldArchiver copies the cbz files in src to dst, verifying each copy.
+type coldArchiver struct {
+	src         hackpadfs.FS
+	dst         hackpadfs.FS
+	logger      logger
+	deleteLocal bool
+	dryRun      bool
+}
+
+func (a *coldArchiver) run(ctx context.Context) error {
+	manifest, err := a.readManifest()
+	if err != nil {
+		return err
+	}
+	files, err := a.files()
+	if err != nil {
+		return err
+	}
+
+	var copied, current, removed, failed int
+	for _, name := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		info, err := fs.Stat(a.src, name)
+		if err != nil {
+			return errors.Wrapf(err, "stating %s", name)
+		}
+		entry, ok := manifest.Files[name]
+		upToDate := ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime())
+		if upToDate {
+			if dstInfo, err := fs.Stat(a.dst, name); err != nil || dstInfo.Size() != entry.Size {
+				upToDate = false
+			}
+		}
+
+		if a.dryRun {
+			if !upToDate {
+				a.logger.Printf("Would archive %s\n", name)
+				copied++
+			} else {
+				current++
+			}
+			if a.deleteLocal {
+				a.logger.Printf("Would remove %s\n", name)
+			}
+			continue
+		}
+
+		if upToDate {
+			current++
+		} else {
+			entry, err = a.archive(name, info)
+			if err != nil {
+				a.logger.Printf("[%s] Unable to archive %s - Skipping...%s\n", errorCode(err), name, err.Error())
+				failed++
+				continue
+			}
+			manifest.Files[name] = entry
+			copied++
+			a.logger.Printf("Archived %s (sha256 %s)\n", name, entry.SHA256)
+		}
+
+		if a.deleteLocal {
+			err := a.removeLocal(name, entry)
+			if err != nil {
+				a.logger.Printf("[%s] Not removing %s...%s\n", errorCode(err), name, err.Error())
+				failed++
+				continue
+			}
+			removed++
+		}
+	}
+
+	if !a.dryRun {
+		err = a.writeManifest(manifest)
+		if err != nil {
+			return err
+		}
+	}
+	a.logger.Printf("Archived %d files, %d already archived, %d removed locally, %d failed\n", copied, current, removed, failed)
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+// files is every cbz under src.
+func (a *coldArchiver) files() ([]string, error) {
+	files := []string{}
+	err := fs.WalkDir(a.src, ".", func(name string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !de.IsDir() && strings.EqualFold(path.Ext(name), ".cbz") && !strings.HasPrefix(de.Name(), ".") {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "finding cbz files")
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// archive copies name into dst under a temporary name, reads the copy back
+// and only renames it into place when its checksum matches.
+func (a *coldArchiver) archive(name string, info fs.FileInfo) (archiveEntry, error) {
+	err := hackpadfs.MkdirAll(a.dst, path.Dir(name), 0755)
+	if err != nil {
+		return archiveEntry{}, errors.Wrap(err, "creating directory")
+	}
+	part := tempName(name)
+	sum, err := copyWithSum(a.src, name, a.dst, part)
+	if err != nil {
+		return archiveEntry{}, errors.Wrap(err, "copying")
+	}
+
+	copySum, err := fileSum(a.dst, part)
+	if err == nil && copySum != sum {
+		err = errors.Errorf("checksum of the copy is %s, expected %s", copySum, sum)
+	}
+	if err != nil {
+		removeIfExists(a.dst, part)
+		return archiveEntry{}, errors.Wrap(err, "verifying copy")
+	}
+	err = hackpadfs.Rename(a.dst, part, name)
+	if err != nil {
+		removeIfExists(a.dst, part)
+		return archiveEntry{}, errors.Wrap(err, "finishing copy")
+	}
+	return archiveEntry{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum, Archived: time.Now().UTC()}, nil
+}
+
+// removeLocal removes name from src, after checking it is still what was
+// archived.
+func (a *coldArchiver) removeLocal(name string, entry archiveEntry) error {
+	sum, err := fileSum(a.src, name)
+	if err != nil {
+		return err
+	}
+	if sum != entry.SHA256 {
+		return errors.New("it changed since it was archived")
+	}
+	return hackpadfs.Remove(a.src, name)
+}
+
+// copyWithSum copies name in src to target in dst, returning the SHA-256
+// of what was read.
+func copyWithSum(src hackpadfs.FS, name string, dst hackpadfs.FS, target string) (string, error) {
+	in, err := src.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := hackpadfs.Create(dst, target)
+	if err != nil {
+		return "", err
+	}
+	w, ok := out.(io.Writer)
+	if !ok {
+		out.Close()
+		return "", errors.New("destination isn't a writable filesystem")
+	}
+	h := sha256.New()
+	_, err = io.Copy(w, io.TeeReader(in, h))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		hackpadfs.Remove(dst, target)
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileSum is the SHA-256 of name in fsys.
+func fileSum(fsys hackpadfs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %s", name)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (a *coldArchiver) readManifest() (*archiveManifest, error) {
+	manifest := &archiveManifest{Files: map[string]archiveEntry{}}
+	data, err := fs.ReadFile(a.dst, archiveManifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading "+archiveManifestName)
+	}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing "+archiveManifestName)
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]archiveEntry{}
+	}
+	return manifest, nil
+}
+
+func (a *coldArchiver) writeManifest(manifest *archiveManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding "+archiveManifestName)
+	}
+	f, err := hackpadfs.Create(a.dst, archiveManifestName)
+	if err != nil {
+		return errors.Wrap(err, "writing "+archiveManifestName)
+	}
+	_, err = hackpadfs.WriteFile(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "writing "+archiveManifestName)
+}
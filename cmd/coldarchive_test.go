@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	memfs "github.com/hack-pad/hackpadfs/mem"
+	"github.com/stretchr/testify/require"
+)
+
+// corruptFS flips the first byte of everything written to it.
+type corruptFS struct {
+	*memfs.FS
+}
+
+func (c corruptFS) OpenFile(name string, flag int, perm hackpadfs.FileMode) (hackpadfs.File, error) {
+	f, err := c.FS.OpenFile(name, flag, perm)
+	if err != nil || flag&(hackpadfs.FlagWriteOnly|hackpadfs.FlagReadWrite) == 0 {
+		return f, err
+	}
+	return &corruptFile{File: f}, nil
+}
+
+type corruptFile struct {
+	hackpadfs.File
+	written bool
+}
+
+func (f *corruptFile) Write(p []byte) (int, error) {
+	q := append([]byte{}, p...)
+	if !f.written && len(q) > 0 {
+		q[0] ^= 0xff
+		f.written = true
+	}
+	return hackpadfs.WriteFile(f.File, q)
+}
+
+func readArchiveManifest(t *testing.T, fsys hackpadfs.FS) archiveManifest {
+	t.Helper()
+	data, err := fs.ReadFile(fsys, archiveManifestName)
+	require.NoError(t, err)
+	manifest := archiveManifest{}
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	return manifest
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func Test_coldArchiver(t *testing.T) {
+	library := filenameBytes{
+		"Saga/001.cbz":   []byte("issue one"),
+		"Saga/002.cbz":   []byte("issue two"),
+		"Saga/cover.jpg": []byte("cover"),
+	}
+
+	t.Run("copies and verifies", func(t *testing.T) {
+		src, err := setupFS(t, library)
+		require.NoError(t, err)
+		dst, err := setupFS(t, filenameBytes{})
+		require.NoError(t, err)
+
+		a := &coldArchiver{src: src, dst: dst, logger: testLogger{t}}
+		require.NoError(t, a.run(context.Background()))
+		require.Equal(t, []string{"Saga/001.cbz", "Saga/002.cbz"}, filesIn(t, dst, archiveManifestName))
+		manifest := readArchiveManifest(t, dst)
+		require.Equal(t, sha256Hex([]byte("issue one")), manifest.Files["Saga/001.cbz"].SHA256)
+		require.Equal(t, int64(9), manifest.Files["Saga/002.cbz"].Size)
+
+		// only what changed is copied again
+		unchanged := manifest.Files["Saga/002.cbz"]
+		later := time.Now().Add(time.Hour)
+		require.NoError(t, hackpadfs.WriteFullFile(src, "Saga/001.cbz", []byte("issue one, fixed"), 0o644))
+		require.NoError(t, hackpadfs.Chtimes(src, "Saga/001.cbz", later, later))
+		require.NoError(t, a.run(context.Background()))
+		manifest = readArchiveManifest(t, dst)
+		require.Equal(t, sha256Hex([]byte("issue one, fixed")), manifest.Files["Saga/001.cbz"].SHA256)
+		require.Equal(t, unchanged, manifest.Files["Saga/002.cbz"])
+
+		data, err := fs.ReadFile(dst, "Saga/001.cbz")
+		require.NoError(t, err)
+		require.Equal(t, []byte("issue one, fixed"), data)
+	})
+
+	t.Run("delete local", func(t *testing.T) {
+		src, err := setupFS(t, library)
+		require.NoError(t, err)
+		dst, err := setupFS(t, filenameBytes{})
+		require.NoError(t, err)
+
+		a := &coldArchiver{src: src, dst: dst, logger: testLogger{t}, deleteLocal: true}
+		require.NoError(t, a.run(context.Background()))
+		require.Equal(t, []string{"Saga/cover.jpg"}, filesIn(t, src, ""))
+		require.Equal(t, []string{"Saga/001.cbz", "Saga/002.cbz"}, filesIn(t, dst, archiveManifestName))
+	})
+
+	t.Run("bad copy", func(t *testing.T) {
+		src, err := setupFS(t, library)
+		require.NoError(t, err)
+		mem, err := memfs.NewFS()
+		require.NoError(t, err)
+		dst := corruptFS{mem}
+
+		a := &coldArchiver{src: src, dst: dst, logger: testLogger{t}, deleteLocal: true}
+		require.ErrorContains(t, a.run(context.Background()), "2 files failed")
+		// nothing half right is left behind and the originals stay
+		require.Equal(t, []string{archiveManifestName}, filesIn(t, mem, ""))
+		require.Len(t, filesIn(t, src, ""), 3)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		src, err := setupFS(t, library)
+		require.NoError(t, err)
+		dst, err := setupFS(t, filenameBytes{})
+		require.NoError(t, err)
+
+		a := &coldArchiver{src: src, dst: dst, logger: testLogger{t}, deleteLocal: true, dryRun: true}
+		require.NoError(t, a.run(context.Background()))
+		require.Empty(t, filesIn(t, dst, ""))
+		require.Len(t, filesIn(t, src, ""), 3)
+	})
+}
+
+func Test_coldArchiver_s3(t *testing.T) {
+	src, err := setupFS(t, filenameBytes{"Saga/001.cbz": []byte("issue one")})
+	require.NoError(t, err)
+	server, fsys := newFakeS3(t, "cold", filenameBytes{})
+	dst := &remoteSub{fs: fsys, dir: "comics"}
+
+	a := &coldArchiver{src: src, dst: dst, logger: testLogger{t}}
+	require.NoError(t, a.run(context.Background()))
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	require.Equal(t, []byte("issue one"), server.objects["comics/Saga/001.cbz"])
+	require.Contains(t, server.objects, "comics/"+archiveManifestName)
+}
+
+// filesIn is every file in fsys except skip.
+func filesIn(t *testing.T, fsys hackpadfs.FS, skip string) []string {
+	t.Helper()
+	files := []string{}
+	err := fs.WalkDir(fsys, ".", func(name string, de fs.DirEntry, err error) error {
+		if err == nil && !de.IsDir() && name != skip {
+			files = append(files, name)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/spf13/cobra"
+)
+
+// archiveExtensions are what the commands reading comics complete.
+var archiveExtensions = map[string]bool{".cbr": true, ".cbz": true, ".cb7": true, ".cbt": true}
+
+// completePaths lists what toComplete could become: the directories in the
+// directory it is in, and the files there keep accepts. Hidden ones only
+// once a . is typed.
+func completePaths(toComplete string, keep func(name string) bool) ([]string, cobra.ShellCompDirective) {
+	dir, prefix := filepath.Split(toComplete)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(expandHome(readDir))
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	completions := []string{}
+	directive := cobra.ShellCompDirectiveNoFileComp
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			info, err := os.Stat(filepath.Join(expandHome(readDir), name))
+			isDir = err == nil && info.IsDir()
+		}
+		switch {
+		case isDir:
+			// no space after it, so the next tab goes on into it
+			completions = append(completions, dir+name+string(filepath.Separator))
+			directive |= cobra.ShellCompDirectiveNoSpace
+		case keep != nil && keep(name):
+			completions = append(completions, dir+name)
+		}
+	}
+	return completions, directive
+}
+
+// completeDirs completes directories only, for flags and arguments naming
+// one.
+func completeDirs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completePaths(toComplete, nil)
+}
+
+// completeArchives completes directories and comic archives.
+func completeArchives(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completePaths(toComplete, func(name string) bool {
+		return archiveExtensions[strings.ToLower(filepath.Ext(name))]
+	})
+}
+
+// completeSources completes directories and the files convert would pick
+// up going by --from and --extensions, and cbz files, which it fixes.
+func completeSources(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	sources, err := sourceExtensions(convertFrom)
+	if err != nil {
+		sources, _ = sourceExtensions(defaultSources)
+	}
+	sources[".cbz"] = true
+	extensions, _ := parseExtensions(convertExtensions)
+	c := &converter{sources: sources, extensions: extensions}
+	return completePaths(toComplete, c.isSource)
+}
+
+// completeImageProfiles completes the names of the built in image profiles.
+func completeImageProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names := []string{}
+	for name := range cbr2cbz.ImageProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completePresets completes the names of the --preset bundles.
+func completePresets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return strings.Split(presetNames(), ", "), cobra.ShellCompDirectiveNoFileComp
+}
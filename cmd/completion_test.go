@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_completePaths(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	for _, name := range []string{"Saga 001.cbr", "Saga 002.cbz", "Saga.nfo", "Saga 003.cbr.bak", ".hidden.cbr"} {
+		require.NoError(t, os.WriteFile(dir+name, nil, 0644))
+	}
+	require.NoError(t, os.Mkdir(dir+"Saga extras", 0755))
+
+	completions, directive := completeArchives(nil, nil, dir+"Saga")
+	require.Equal(t, []string{dir + "Saga 001.cbr", dir + "Saga 002.cbz", dir + "Saga extras" + string(filepath.Separator)}, completions)
+	require.Equal(t, cobra.ShellCompDirectiveNoFileComp|cobra.ShellCompDirectiveNoSpace, directive)
+
+	completions, _ = completeDirs(nil, nil, dir)
+	require.Equal(t, []string{dir + "Saga extras" + string(filepath.Separator)}, completions)
+
+	completions, _ = completeArchives(nil, nil, dir+".")
+	require.Equal(t, []string{dir + ".hidden.cbr"}, completions, "hidden files once a . is typed")
+
+	completions, directive = completeArchives(nil, nil, dir+"Saga 001")
+	require.Equal(t, []string{dir + "Saga 001.cbr"}, completions)
+	require.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive, "a space after a file")
+}
+
+func Test_completeSources(t *testing.T) {
+	oldFrom, oldExtensions := convertFrom, convertExtensions
+	defer func() { convertFrom, convertExtensions = oldFrom, oldExtensions }()
+
+	dir := t.TempDir() + string(filepath.Separator)
+	for _, name := range []string{"a.cbr", "b.cbz", "c.pdf", "d.cbr.bak", "e.cb7"} {
+		require.NoError(t, os.WriteFile(dir+name, nil, 0644))
+	}
+
+	convertFrom, convertExtensions = []string{"cbr", "pdf"}, []string{"cbr.bak"}
+	completions, _ := completeSources(nil, nil, dir)
+	require.Equal(t, []string{dir + "a.cbr", dir + "b.cbz", dir + "c.pdf", dir + "d.cbr.bak"}, completions)
+}
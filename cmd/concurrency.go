@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// rampUpAfter is how many clean conversions in a row it takes before a
+// worker that was shed after an IO error is added back.
+const rampUpAfter = 5
+
+// adaptiveLimiter bounds how many conversions run at once. It starts at max
+// and backs off when conversions fail with IO errors (typical of an
+// overloaded network share), then ramps back up once things look healthy.
+type adaptiveLimiter struct {
+	max    int
+	logger logger
+
+	mu        sync.Mutex
+	limit     int
+	active    int
+	successes int
+	freed     chan struct{}
+}
+
+func newAdaptiveLimiter(max int, logger logger) *adaptiveLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &adaptiveLimiter{
+		max:    max,
+		logger: logger,
+		limit:  max,
+		freed:  make(chan struct{}),
+	}
+}
+
+// acquire blocks until a worker slot is free, or the context is done.
+func (l *adaptiveLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		freed := l.freed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release frees a worker slot and adjusts the limit based on how the
+// conversion that held it went.
+func (l *adaptiveLimiter) release(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+
+	switch {
+	case isIOError(err):
+		l.successes = 0
+		if l.limit > 1 {
+			l.limit /= 2
+			l.logger.Printf("[%s] IO error detected, reducing concurrency to %d\n", cbr2cbz.CodeConcurrencyReduced, l.limit)
+		}
+	case err == nil:
+		l.successes++
+		if l.successes >= rampUpAfter && l.limit < l.max {
+			l.successes = 0
+			l.limit++
+			l.logger.Printf("IO looks healthy again, increasing concurrency to %d\n", l.limit)
+		}
+	}
+
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// isIOError reports whether err looks like a storage or network hiccup
+// rather than a problem with the archive itself. Running out of file
+// descriptors counts too, fewer workers is the fix for that as well.
+func isIOError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if os.IsTimeout(err) || errors.Is(err, errStalled) || isFileLimitError(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EIO, syscall.ETIMEDOUT, syscall.ECONNRESET, syscall.ESTALE, syscall.EAGAIN, syscall.EBUSY:
+			return true
+		}
+	}
+
+	return false
+}
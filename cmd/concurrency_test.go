@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_adaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter(4, testLogger{t})
+	ioErr := &fs.PathError{Op: "read", Path: "test.cbr", Err: syscall.EIO}
+
+	require.NoError(t, l.acquire(context.Background()))
+	l.release(errors.Wrap(ioErr, "reading"))
+	require.Equal(t, 2, l.limit, "halves on io error")
+
+	require.NoError(t, l.acquire(context.Background()))
+	l.release(errors.New("not a rar file"))
+	require.Equal(t, 2, l.limit, "archive errors don't count against the share")
+
+	for i := 0; i < rampUpAfter; i++ {
+		require.NoError(t, l.acquire(context.Background()))
+		l.release(nil)
+	}
+	require.Equal(t, 3, l.limit, "ramps back up after clean conversions")
+}
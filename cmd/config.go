@@ -0,0 +1,609 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configFileName string
+	configProfile  string
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Works with the config file",
+	Long: `Works with the config file.
+
+The config file is YAML, each key is the name of a convert, watch or meta lookup flag
+and "paths" lists the directories convert works on when none are given, e.g.
+
+  paths:
+    - ~/Comics
+  jobs: 4
+  keep-files: ["*.nfo", ComicInfo.xml]
+  passwords:
+    ~/Comics/Locked: secret
+    "Saga *.cbz": another
+  profiles:
+    eink:
+      recompress: jpeg:80
+      max-width: 1072
+    archive:
+      keep: true
+      page-order: archive
+
+Flags and environment variables take precedence over the profile --config-profile
+picks, which takes precedence over the rest of the config file, which takes
+precedence over the preset it or --preset picks.`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks the config file and prints the effective configuration",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(configFileName, cmd.Flags().Changed("config"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if cfg == nil {
+			fmt.Fprintln(os.Stderr, "no config file, showing defaults")
+			cfg = &configFile{}
+		}
+
+		sets := configFlagSets()
+		problems := cfg.validate(sets...)
+		if len(problems) == 0 {
+			if err := cfg.apply(sets...); err != nil {
+				problems = append(problems, err)
+			}
+			if err := applyPreset(sets...); err != nil {
+				problems = append(problems, err)
+			}
+			problems = append(problems, checkConflicts(sets...)...)
+		}
+		if len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintln(os.Stderr, p)
+			}
+			os.Exit(1)
+		}
+
+		out, err := yaml.Marshal(effectiveConfig(cfg, sets...))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+
+	rootCmd.PersistentFlags().StringVar(&configFileName, "config", defaultConfigPath(), "config file")
+	rootCmd.PersistentFlags().StringVar(&configProfile, "config-profile", "", "use this profile of the config file's profiles on top of the rest of it")
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "config.yaml")
+}
+
+// configFlagSets are the flags the config file may set.
+func configFlagSets() []*pflag.FlagSet {
+	return []*pflag.FlagSet{rootCmd.PersistentFlags(), convertCmd.Flags(), watchCmd.Flags(), metaLookupCmd.Flags()}
+}
+
+// configFile is a parsed config file. Values are kept as YAML nodes so
+// problems can be reported against the line they came from.
+type configFile struct {
+	path      string
+	paths     []string
+	passwords []passwordRule
+	values    []configValue
+	// profiles are named sets of values, one of them applied over values
+	profiles map[string][]configValue
+}
+
+type configValue struct {
+	key  string
+	node *yaml.Node
+}
+
+// loadConfig reads the config file at name. A missing file is only an error
+// if it was asked for explicitly.
+func loadConfig(name string, explicit bool) (*configFile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading config")
+	}
+
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", name)
+	}
+
+	cfg := &configFile{path: name}
+	if len(doc.Content) == 0 {
+		return cfg, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.Errorf("%s:%d: expected a mapping of option names to values", name, root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		if key == "paths" {
+			cfg.paths, err = nodeStrings(value)
+			if err != nil {
+				return nil, errors.Errorf("%s:%d: paths: %s", name, value.Line, err)
+			}
+			for i, p := range cfg.paths {
+				cfg.paths[i] = expandHome(p)
+			}
+			continue
+		}
+		if key == "profiles" {
+			cfg.profiles, err = nodeProfiles(value)
+			if err != nil {
+				return nil, errors.Errorf("%s:%d: profiles: %s", name, value.Line, err)
+			}
+			continue
+		}
+		if key == "passwords" {
+			cfg.passwords, err = nodePasswords(value)
+			if err != nil {
+				return nil, errors.Errorf("%s:%d: passwords: %s", name, value.Line, err)
+			}
+			continue
+		}
+		cfg.values = append(cfg.values, configValue{key: key, node: value})
+	}
+	return cfg, nil
+}
+
+// validate reports unknown keys and values that don't fit their flag's type.
+func (cfg *configFile) validate(sets ...*pflag.FlagSet) []error {
+	problems := []error{}
+	values := cfg.values
+	for _, name := range cfg.profileNames() {
+		values = append(values, cfg.profiles[name]...)
+	}
+	for _, v := range values {
+		f := lookupFlag(v.key, sets...)
+		if f == nil {
+			problems = append(problems, errors.Errorf("%s:%d: unknown key %q", cfg.path, v.node.Line, v.key))
+			continue
+		}
+		if err := checkConfigValue(f, v.node); err != nil {
+			problems = append(problems, errors.Errorf("%s:%d: %s: %s", cfg.path, v.node.Line, v.key, err))
+		}
+	}
+	if configProfile != "" && cfg.profiles[configProfile] == nil {
+		problems = append(problems, cfg.unknownProfile())
+	}
+	return problems
+}
+
+// profileNames are the config file's profiles, sorted.
+func (cfg *configFile) profileNames() []string {
+	names := make([]string, 0, len(cfg.profiles))
+	for name := range cfg.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (cfg *configFile) unknownProfile() error {
+	if len(cfg.profiles) == 0 {
+		return errors.Errorf("unknown profile %q, %s has no profiles", configProfile, cfg.path)
+	}
+	return errors.Errorf("unknown profile %q, %s has %s", configProfile, cfg.path, strings.Join(cfg.profileNames(), ", "))
+}
+
+// apply sets every flag from the profile --config-profile picks and then
+// the rest of the config file that wasn't already given on the command
+// line.
+func (cfg *configFile) apply(sets ...*pflag.FlagSet) error {
+	if configProfile != "" {
+		profile, ok := cfg.profiles[configProfile]
+		if !ok {
+			return cfg.unknownProfile()
+		}
+		if err := cfg.applyValues(profile, "profile", sets...); err != nil {
+			return err
+		}
+	}
+	return cfg.applyValues(cfg.values, "config", sets...)
+}
+
+// applyValues sets the flags of values not set already, noting source as
+// where they came from.
+func (cfg *configFile) applyValues(values []configValue, source string, sets ...*pflag.FlagSet) error {
+	for _, v := range values {
+		f := lookupFlag(v.key, sets...)
+		if f == nil {
+			return errors.Errorf("%s:%d: unknown key %q", cfg.path, v.node.Line, v.key)
+		}
+		if f.Changed {
+			continue
+		}
+
+		var err error
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			var values []string
+			values, err = nodeStrings(v.node)
+			if err == nil {
+				err = slice.Replace(values)
+			}
+		} else {
+			err = f.Value.Set(v.node.Value)
+		}
+		if err != nil {
+			return errors.Errorf("%s:%d: %s: %s", cfg.path, v.node.Line, v.key, err)
+		}
+		f.Changed = true
+		optionSources[f.Name] = source
+	}
+	return nil
+}
+
+// applyConfigFile loads the config file, if there is one, into the flags
+// before a command runs.
+func applyConfigFile(cmd *cobra.Command) (*configFile, error) {
+	cfg, err := loadConfig(configFileName, cmd.Flags().Changed("config"))
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		if configProfile != "" {
+			return nil, errors.Errorf("--config-profile %s needs a config file, there is none at %s", configProfile, configFileName)
+		}
+		return nil, nil
+	}
+
+	sets := configFlagSets()
+	if problems := cfg.validate(sets...); len(problems) > 0 {
+		return nil, errors.Wrap(problems[0], "invalid config, see cbr2cbz config validate")
+	}
+	return cfg, cfg.apply(sets...)
+}
+
+func lookupFlag(name string, sets ...*pflag.FlagSet) *pflag.Flag {
+	for _, set := range sets {
+		if f := set.Lookup(name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func checkConfigValue(f *pflag.Flag, node *yaml.Node) error {
+	if _, ok := f.Value.(pflag.SliceValue); ok {
+		_, err := nodeStrings(node)
+		return err
+	}
+	if node.Kind != yaml.ScalarNode {
+		return errors.Errorf("expected a single %s", f.Value.Type())
+	}
+
+	var err error
+	switch f.Value.Type() {
+	case "bool":
+		_, err = strconv.ParseBool(node.Value)
+	case "int":
+		_, err = strconv.Atoi(node.Value)
+	case "float64":
+		_, err = strconv.ParseFloat(node.Value, 64)
+	case "duration":
+		_, err = time.ParseDuration(node.Value)
+	}
+	if err != nil {
+		return errors.Errorf("expected %s, got %q", f.Value.Type(), node.Value)
+	}
+	return nil
+}
+
+// nodeStrings accepts either a single scalar or a sequence of them.
+func nodeStrings(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		values := []string{}
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, errors.Errorf("line %d: expected a list of values", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		return values, nil
+	}
+	return nil, errors.New("expected a value or a list of values")
+}
+
+// nodePasswords reads a mapping of archive patterns to their passwords,
+// keeping the order they were written in.
+func nodePasswords(node *yaml.Node) ([]passwordRule, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.New("expected a mapping of paths or patterns to passwords")
+	}
+	rules := []passwordRule{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		pattern, password := node.Content[i], node.Content[i+1]
+		if password.Kind != yaml.ScalarNode {
+			return nil, errors.Errorf("line %d: expected a password", password.Line)
+		}
+		rules = append(rules, passwordRule{pattern: expandHome(pattern.Value), password: password.Value})
+	}
+	return rules, nil
+}
+
+// nodeProfiles reads a mapping of profile names to mappings of option names
+// to values.
+func nodeProfiles(node *yaml.Node) (map[string][]configValue, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.New("expected a mapping of profile names to options")
+	}
+	profiles := map[string][]configValue{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, options := node.Content[i], node.Content[i+1]
+		if options.Kind != yaml.MappingNode {
+			return nil, errors.Errorf("line %d: %s: expected a mapping of option names to values", options.Line, name.Value)
+		}
+		values := []configValue{}
+		for j := 0; j+1 < len(options.Content); j += 2 {
+			values = append(values, configValue{key: options.Content[j].Value, node: options.Content[j+1]})
+		}
+		profiles[name.Value] = values
+	}
+	return profiles, nil
+}
+
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, strings.TrimPrefix(p, "~"))
+}
+
+// configRule checks one combination of options, get returns a flag's value.
+type configRule func(get func(name string) string) error
+
+var configRules = []configRule{
+	func(get func(string) string) error {
+		if n, _ := strconv.Atoi(get("jobs")); n < 1 {
+			return errors.New("jobs must be at least 1")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if n, _ := strconv.Atoi(get("prefetch")); n < 0 {
+			return errors.New("prefetch can't be negative")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		for _, name := range []string{"scratch-budget", "max-entry-size", "log-max-size"} {
+			if v := get(name); v != "" {
+				if _, err := humanize.ParseBytes(v); err != nil {
+					return errors.Errorf("%s: %q is not a size", name, v)
+				}
+			}
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if from := strings.Trim(get("from"), "[]"); from != "" {
+			_, err := sourceExtensions(strings.Split(from, ","))
+			return errors.Wrap(err, "from")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if format := get("log-format"); format != "" && !logFormats[format] {
+			return errors.Errorf("log-format: %q isn't one of text or json", format)
+		}
+		if get("log-format") == "json" && get("progress") == "true" {
+			return errors.New("progress can't be combined with log-format json")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if format := get("progress-format"); format != "" && !progressFormats[format] {
+			return errors.Errorf("progress-format: %q isn't one of text or ndjson", format)
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if order := get("page-order"); order != "" && !cbr2cbz.PageOrders[order] {
+			return errors.Errorf("page-order: %q isn't one of natural, byte, folder or archive", order)
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if v := get("compression"); v != "" && !cbr2cbz.Compressions[v] {
+			return errors.Errorf("compression: %q isn't one of store, fastest, default or best", v)
+		}
+		if v := get("entry-encoding"); v != "" {
+			if _, ok := cbr2cbz.EntryEncodings[v]; !ok {
+				return errors.Errorf("entry-encoding: %q isn't auto or an encoding like shift-jis or cp437", v)
+			}
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if v := get("size-units"); v != "" && !SizeUnits[v] {
+			return errors.Errorf("size-units: %q isn't one of si or binary", v)
+		}
+		if v := get("duration-format"); v != "" && !DurationFormats[v] {
+			return errors.Errorf("duration-format: %q isn't one of human or exact", v)
+		}
+		if v := get("locale"); v != "" {
+			if _, err := localeTag(v); err != nil {
+				return errors.Errorf("locale: %q isn't a locale like de or en-US", v)
+			}
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if v := get("on-exists"); v != "" && !OnExistsPolicies[v] {
+			return errors.Errorf("on-exists: %q isn't one of overwrite, skip, rename or error", v)
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if v := get("recompress"); v != "" {
+			_, err := parseRecompress(v)
+			return errors.Wrap(err, "recompress")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		mode := get("entry-errors")
+		if mode != "" && !entryErrorModes[mode] {
+			return errors.Errorf("entry-errors: %q isn't one of fail or skip", mode)
+		}
+		if get("placeholder-pages") == "true" && mode != "skip" {
+			return errors.New("placeholder-pages needs entry-errors skip")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if v := get("bwlimit"); v != "" {
+			if _, err := humanize.ParseBytes(strings.TrimSuffix(v, "/s")); err != nil {
+				return errors.Errorf("bwlimit: %q is not a size", v)
+			}
+		}
+		if level := get("io-nice"); !IONiceLevels[level] {
+			return errors.Errorf("io-nice: %q isn't one of low or idle", level)
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if get("quiet") == "true" && (get("verbose") == "true" || get("debug") == "true") {
+			return errors.New("quiet can't be combined with verbose or debug")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if get("trash") == "true" && get("backup-dir") != "" {
+			return errors.New("trash can't be combined with backup-dir")
+		}
+		if get("keep") == "true" && (get("trash") == "true" || get("backup-dir") != "") {
+			return errors.New("keep can't be combined with trash or backup-dir")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if get("split-chapters") == "true" && get("sandbox") == "true" {
+			return errors.New("split-chapters can't be combined with sandbox")
+		}
+		return nil
+	},
+	func(get func(string) string) error {
+		if ttl, _ := time.ParseDuration(get("lease-ttl")); get("claim-dir") != "" && ttl <= 0 {
+			return errors.New("claim-dir needs a positive lease-ttl")
+		}
+		return nil
+	},
+}
+
+// checkConflicts runs every configRule against the resolved flags.
+func checkConflicts(sets ...*pflag.FlagSet) []error {
+	get := func(name string) string {
+		if f := lookupFlag(name, sets...); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+	problems := []error{}
+	for _, rule := range configRules {
+		if err := rule(get); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// effectiveConfig is every option config can set with its resolved value, in
+// the same shape as the config file.
+func effectiveConfig(cfg *configFile, sets ...*pflag.FlagSet) map[string]interface{} {
+	out := map[string]interface{}{}
+	if len(cfg.paths) > 0 {
+		out["paths"] = cfg.paths
+	}
+	if len(cfg.passwords) > 0 {
+		redacted := map[string]string{}
+		for _, rule := range cfg.passwords {
+			redacted[rule.pattern] = "********"
+		}
+		out["passwords"] = redacted
+	}
+	for _, set := range sets {
+		set.VisitAll(func(f *pflag.Flag) {
+			if f.Name == "config" || f.Name == "help" {
+				return
+			}
+			out[f.Name] = flagValue(f)
+		})
+	}
+	return out
+}
+
+// secretFlags are shown as ******** wherever the options are printed.
+var secretFlags = map[string]bool{"comicvine-api-key": true, "metron-password": true, "komga-api-key": true, "kavita-api-key": true, "ntfy-token": true, "pushover-user": true, "pushover-token": true}
+
+func flagValue(f *pflag.Flag) interface{} {
+	if secretFlags[f.Name] && f.Value.String() != "" {
+		return "********"
+	}
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		return slice.GetSlice()
+	}
+	switch f.Value.Type() {
+	case "bool":
+		v, _ := strconv.ParseBool(f.Value.String())
+		return v
+	case "int":
+		v, _ := strconv.Atoi(f.Value.String())
+		return v
+	case "float64":
+		v, _ := strconv.ParseFloat(f.Value.String(), 64)
+		return v
+	}
+	return f.Value.String()
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfigFlags() *pflag.FlagSet {
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	set.Int("jobs", 1, "")
+	set.Bool("sandbox", false, "")
+	set.Duration("lease-ttl", time.Minute, "")
+	set.String("claim-dir", "", "")
+	set.StringSlice("keep-files", []string{"ComicInfo.xml"}, "")
+	return set
+}
+
+func writeConfig(t *testing.T, contents string) string {
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(name, []byte(contents), 0644))
+	return name
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		problems []string
+	}{
+		{name: "valid", contents: "jobs: 4\nsandbox: true\nkeep-files: ['*.nfo']\n"},
+		{name: "single value for a list", contents: "keep-files: '*.nfo'\n"},
+		{name: "unknown key", contents: "jobs: 4\nturbo: true\n", problems: []string{`config.yaml:2: unknown key "turbo"`}},
+		{name: "type error", contents: "jobs: lots\n", problems: []string{`config.yaml:1: jobs: expected int, got "lots"`}},
+		{name: "list for a single value", contents: "sandbox: [true]\n", problems: []string{"config.yaml:1: sandbox: expected a single bool"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(writeConfig(t, tt.contents), true)
+			require.NoError(t, err)
+
+			problems := []string{}
+			for _, p := range cfg.validate(testConfigFlags()) {
+				problems = append(problems, filepath.Base(p.Error()))
+			}
+			if tt.problems == nil {
+				tt.problems = []string{}
+			}
+			require.Equal(t, tt.problems, problems)
+		})
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, "paths: [~/Comics, /srv/comics]\njobs: 4\nsandbox: true\nkeep-files: ['*.nfo', '*.txt']\n"), true)
+	require.NoError(t, err)
+
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(home, "Comics"), "/srv/comics"}, cfg.paths)
+
+	set := testConfigFlags()
+	require.NoError(t, set.Parse([]string{"--jobs", "2"}))
+	require.NoError(t, cfg.apply(set))
+
+	effective := effectiveConfig(cfg, set)
+	require.Equal(t, 2, effective["jobs"], "flags win over the config file")
+	require.Equal(t, true, effective["sandbox"])
+	require.Equal(t, []string{"*.nfo", "*.txt"}, effective["keep-files"])
+}
+
+func TestConfigProfiles(t *testing.T) {
+	old := configProfile
+	defer func() { configProfile = old }()
+
+	cfg, err := loadConfig(writeConfig(t, "jobs: 4\nsandbox: true\nprofiles:\n  eink:\n    jobs: 2\n    keep-files: ['*.jpg']\n  archive:\n    sandbox: false\n"), true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"archive", "eink"}, cfg.profileNames())
+
+	configProfile = "eink"
+	require.Empty(t, cfg.validate(testConfigFlags()))
+	set := testConfigFlags()
+	require.NoError(t, set.Parse([]string{"--keep-files", "*.nfo"}))
+	require.NoError(t, cfg.apply(set))
+	effective := effectiveConfig(cfg, set)
+	require.Equal(t, 2, effective["jobs"], "the profile wins over the rest of the config file")
+	require.Equal(t, true, effective["sandbox"])
+	require.Equal(t, []string{"*.nfo"}, effective["keep-files"], "flags win over the profile")
+	require.Equal(t, "profile", optionSources["jobs"])
+
+	configProfile = "kindle"
+	require.EqualError(t, cfg.apply(testConfigFlags()), `unknown profile "kindle", `+cfg.path+" has archive, eink")
+	require.Len(t, cfg.validate(testConfigFlags()), 1)
+
+	configProfile = ""
+	cfg, err = loadConfig(writeConfig(t, "profiles:\n  eink:\n    turbo: true\n"), true)
+	require.NoError(t, err)
+	problems := cfg.validate(testConfigFlags())
+	require.Len(t, problems, 1)
+	require.Equal(t, `config.yaml:3: unknown key "turbo"`, filepath.Base(problems[0].Error()))
+}
+
+func TestConfigConflicts(t *testing.T) {
+	set := testConfigFlags()
+	require.Empty(t, checkConflicts(set))
+
+	require.NoError(t, set.Parse([]string{"--jobs", "0", "--claim-dir", "/claims", "--lease-ttl", "0s"}))
+	require.Len(t, checkConflicts(set), 2)
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "missing.yaml")
+
+	cfg, err := loadConfig(name, false)
+	require.NoError(t, err)
+	require.Nil(t, cfg)
+
+	_, err = loadConfig(name, true)
+	require.Error(t, err)
+}
+
+func TestEffectiveOptions(t *testing.T) {
+	optionSources = map[string]string{}
+	t.Cleanup(func() { optionSources = map[string]string{} })
+
+	cfg, err := loadConfig(writeConfig(t, "sandbox: true\n"), true)
+	require.NoError(t, err)
+
+	set := testConfigFlags()
+	require.NoError(t, set.Parse([]string{"--jobs", "2"}))
+	require.NoError(t, cfg.apply(set))
+	t.Setenv("CBR2CBZ_CLAIM_DIR", "/claims")
+	require.NoError(t, applyEnvFlags(set))
+
+	sources := map[string]string{}
+	for _, o := range effectiveOptions(set) {
+		sources[o.Name] = o.Source
+	}
+	require.Equal(t, map[string]string{
+		"claim-dir":  "env",
+		"jobs":       "flag",
+		"keep-files": "default",
+		"lease-ttl":  "default",
+		"sandbox":    "config",
+	}, sources)
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/pkg/errors"
+)
+
+// errOutsideRoot is returned for any path that resolves outside --root.
+var errOutsideRoot = errors.New("outside of --root")
+
+// newLocalFS returns the filesystem commands work against: the whole local
+// disk, or only what's under --root if it was given, paced by --bwlimit.
+func newLocalFS() (hackpadfs.FS, error) {
+	if rootDir == "" {
+		return throttleFS(hackpados.NewFS()), nil
+	}
+	fsys, err := newConfinedFS(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	return throttleFS(fsys), nil
+}
+
+// confinedFS is the local filesystem with every path taken relative to root.
+// Paths are checked after resolving symlinks so a link can't be used to
+// reach anything outside root either.
+type confinedFS struct {
+	base *hackpados.FS
+	root string
+}
+
+func newConfinedFS(root string) (*confinedFS, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving --root")
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving --root")
+	}
+
+	var base hackpadfs.FS = hackpados.NewFS()
+	volume := filepath.VolumeName(real)
+	if volume != "" {
+		base, err = hackpados.NewFS().SubVolume(volume)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening --root volume")
+		}
+	}
+
+	sub := strings.Trim(filepath.ToSlash(strings.TrimPrefix(real, volume)), "/")
+	if sub != "" {
+		base, err = base.(*hackpados.FS).Sub(sub)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening --root")
+		}
+	}
+
+	return &confinedFS{base: base.(*hackpados.FS), root: real}, nil
+}
+
+// check makes sure name, once symlinks are resolved, is still inside root.
+// Names that don't exist yet are checked by their closest existing parent.
+func (c *confinedFS) check(name string) error {
+	osPath, err := c.base.ToOSPath(name)
+	if err != nil {
+		return err
+	}
+
+	rest := ""
+	resolved, err := filepath.EvalSymlinks(osPath)
+	for err != nil && errors.Is(err, fs.ErrNotExist) {
+		parent := filepath.Dir(osPath)
+		if parent == osPath {
+			break
+		}
+		rest = filepath.Join(filepath.Base(osPath), rest)
+		osPath = parent
+		resolved, err = filepath.EvalSymlinks(osPath)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "resolve", Path: name, Err: err}
+	}
+
+	rel, err := filepath.Rel(c.root, filepath.Join(resolved, rest))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &fs.PathError{Op: "open", Path: name, Err: errOutsideRoot}
+	}
+	return nil
+}
+
+func (c *confinedFS) ToOSPath(name string) (string, error) {
+	if err := c.check(name); err != nil {
+		return "", err
+	}
+	return c.base.ToOSPath(name)
+}
+
+func (c *confinedFS) Open(name string) (hackpadfs.File, error) {
+	if err := c.check(name); err != nil {
+		return nil, err
+	}
+	return c.base.Open(name)
+}
+
+func (c *confinedFS) OpenFile(name string, flag int, perm hackpadfs.FileMode) (hackpadfs.File, error) {
+	if err := c.check(name); err != nil {
+		return nil, err
+	}
+	return c.base.OpenFile(name, flag, perm)
+}
+
+func (c *confinedFS) Mkdir(name string, perm hackpadfs.FileMode) error {
+	if err := c.check(name); err != nil {
+		return err
+	}
+	return c.base.Mkdir(name, perm)
+}
+
+func (c *confinedFS) MkdirAll(name string, perm hackpadfs.FileMode) error {
+	if err := c.check(name); err != nil {
+		return err
+	}
+	return c.base.MkdirAll(name, perm)
+}
+
+func (c *confinedFS) Remove(name string) error {
+	if err := c.check(name); err != nil {
+		return err
+	}
+	return c.base.Remove(name)
+}
+
+func (c *confinedFS) Rename(oldName, newName string) error {
+	if err := c.check(oldName); err != nil {
+		return err
+	}
+	if err := c.check(newName); err != nil {
+		return err
+	}
+	return c.base.Rename(oldName, newName)
+}
+
+func (c *confinedFS) Stat(name string) (hackpadfs.FileInfo, error) {
+	if err := c.check(name); err != nil {
+		return nil, err
+	}
+	return c.base.Stat(name)
+}
+
+func (c *confinedFS) Lstat(name string) (hackpadfs.FileInfo, error) {
+	// the link itself has to live inside root, where it points is checked
+	// whenever it's followed
+	if err := c.check(path.Dir(name)); err != nil {
+		return nil, err
+	}
+	return c.base.Lstat(name)
+}
+
+func (c *confinedFS) Chmod(name string, mode hackpadfs.FileMode) error {
+	if err := c.check(name); err != nil {
+		return err
+	}
+	return c.base.Chmod(name, mode)
+}
+
+func (c *confinedFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := c.check(name); err != nil {
+		return err
+	}
+	return c.base.Chtimes(name, atime, mtime)
+}
+
+func (c *confinedFS) ReadDir(name string) ([]hackpadfs.DirEntry, error) {
+	if err := c.check(name); err != nil {
+		return nil, err
+	}
+	return c.base.ReadDir(name)
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_confinedFS(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "comics"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "comics", "test.cbr"), realCBRContents, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.cbr"), realCBRContents, 0644))
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skip("can't create symlinks here:", err)
+	}
+
+	fsys, err := newConfinedFS(root)
+	require.NoError(t, err)
+
+	_, err = fs.Stat(fsys, "comics/test.cbr")
+	require.NoError(t, err, "inputs are relative to root")
+
+	_, err = fs.Stat(fsys, "escape/secret.cbr")
+	require.ErrorIs(t, err, errOutsideRoot, "symlinks can't leave root")
+
+	require.ErrorIs(t, fsys.check("escape/new.cbz"), errOutsideRoot, "new files under a symlink can't leave root")
+	require.NoError(t, fsys.check("comics/new.cbz"))
+}
@@ -6,53 +6,586 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/hack-pad/hackpadfs"
-	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
 	"github.com/mholt/archiver/v4"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-var logFileName = "cbr2cbz.log"
+var (
+	logFileName       = "cbr2cbz.log"
+	scratchBudgetFlag string
+	jobs              int
+	heartbeat         time.Duration
+	stallTimeout      time.Duration
+	imageExtensions   []string
+	keepFiles         []string
+	bookmarks         bool
+	markCover         bool
+	generateInfo      bool
+	coverFirst        bool
+	writeSeries       bool
+	maxEntrySize      string
+	maxExpansion      float64
+	sandbox           bool
+	claimDir          string
+	keepOriginal      bool
+	outputDir         string
+	renameFormat      string
+	onExists          string
+	maxDepth          int
+	leaseTTL          time.Duration
+	showProgress      bool
+	showTUI           bool
+	convertFrom       []string
+	convertExtensions []string
+	splitChapters     bool
+	pageOrder         string
+	zipCompression    string
+	entryEncoding     string
+	recompress        string
+	normalizeFormat   string
+	convertImages     cbr2cbz.ImageOptions
+	padNumbers        bool
+	entryErrors       string
+	placeholderPages  bool
+	verifyOutputs     bool
+	preserveAttrs     bool
+	stripJunk         bool
+	stripComments     bool
+	dropDuplicates    bool
+	flatten           bool
+	stripWrappers     bool
+	recurseArchives   bool
+	prefetchAhead     int
+	renumber          bool
+	logFormat         string
+	progressFormat    string
+	progressSocket    string
+	qaSample          float64
+	readingListFile   string
+	convertThumbsDir  string
+)
 
 // convertCmd represents the convert command
 var convertCmd = &cobra.Command{
 	Use:   "convert",
 	Short: "Converts one or more files",
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Converts one or more files, or every cbr, cb7 and cbt under the given directories.
+Comic PDFs are converted too with --from pdf.
+
+Paths can be s3://bucket/prefix, sftp://user@host/path or webdav(s)://host/path
+urls too, the cbz files are written back next to the originals.
+
+http(s) urls are downloaded and converted, the cbz files going to --output-dir
+or the working directory. A #sha256=<hex> at the end of one is checked against
+the download, and with --download-dir a download that broke off is carried on
+from the next time.
+
+With --reading-list only the comics on a ComicRack .cbl or a text file with a
+"Series #number" per line are converted, in the order of the list.
+
+Progress is written to --state-file as files finish. After an interruption,
+the same command with --resume continues where it left off. --stop-after and
+--stop-at end a batch cleanly, for runs that have to be done by morning: no
+new files are started, those in progress finish and the state is kept for
+--resume. Interrupting a batch (Ctrl-C or SIGTERM) does the same, a second
+interrupt aborts the files in progress too, keeping their originals.
+
+A - reads the paths from stdin, one per line, or NUL separated with -0 for
+find -print0.
+
+Without arguments the paths from the config file are used.
+
+Exits with 0 when every file converted, 1 on errors stopping the batch, 2 when
+some files failed, 3 when there was nothing to convert and 4 when --stop-after,
+--stop-at or an interrupt left files for later.`,
+	Args:              cobra.ArbitraryArgs,
+	ValidArgsFunction: completeSources,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := log.Default()
 
-		logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_RDWR, 0666)
+		if len(args) == 0 && loadedConfig != nil {
+			args = loadedConfig.paths
+		}
+		args, err := readPathArgs(args, os.Stdin, nulDelimited)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if len(args) == 0 {
+			logger.Fatal("nothing to convert, pass some paths or set paths in the config file")
+		}
+
+		logFile, err := openLogFile(time.Now())
 		if err != nil {
 			panic(err)
 		}
-		mw := io.MultiWriter(os.Stdout, logFile)
+		var stdout io.Writer = os.Stdout
+		var display *batchDisplay
+		var tui *batchTUI
+		var events *eventLog
+		if progressFormat == "ndjson" && progressSocket != "" {
+			conn, err := dialProgressSocket(progressSocket)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			defer conn.Close()
+			events = newEventLog(conn)
+		}
+		switch {
+		case logFormat == "json", progressFormat == "ndjson" && progressSocket == "":
+			// stdout is for the events alone, the human log moves to stderr
+			stdout = os.Stderr
+			events = newEventLog(os.Stdout)
+		case showTUI:
+			if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+				logger.Fatal("--tui needs to be run in a terminal")
+			}
+			tui = newBatchTUI(newTerminal(os.Stdout), os.Stdin, terminalSize(os.Stdout))
+			stdout = tui
+		case showProgress:
+			display = newBatchDisplay(newTerminal(os.Stdout))
+			stdout = display
+		}
+		if events != nil {
+			events.progress = progressFormat == "ndjson"
+		}
+		mw := io.MultiWriter(consoleOutput(stdout), logFileOutput(logFile))
 		logger.SetOutput(mw)
 
-		fsys := hackpados.NewFS()
-
-		c := &converter{
-			fs:     fsys,
-			logger: logger,
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
 		}
-
-		err = c.runConvert(cmd.Context(), args)
+		args, removeDownloads, err := c.downloadURLs(cmd.Context(), args, downloadDir)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		args, err = c.useRemote(args)
 		if err != nil {
 			logger.Fatal(err)
 		}
+		args, err = c.localInputs(args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		c.settings = effectiveOptions(cmd.Flags())
+		c.statePath, c.resume = stateFileName, resumeBatch
+		if !cmd.Flags().Changed("state-file") {
+			c.statePath = defaultStatePath(args)
+		}
+		c.historyPath = historyFileName
+		c.crashDir = crashDir
+		c.reportPath = reportFileName
+		c.snapshotCommand, c.rollbackCommand = snapshotCommand, rollbackCommand
+		if telemetryEnabled {
+			if telemetryURL == "" {
+				logger.Fatal("--telemetry has nowhere to report to, this build has no --telemetry-url built in")
+			}
+			c.telemetryURL = telemetryURL
+		}
+		if c.resume && c.statePath == "" {
+			logger.Fatal("--resume needs a --state-file")
+		}
+		c.display = display
+		c.tui = tui
+		c.events = events
+
+		signals, stopSignals := interruptSignals()
+		defer stopSignals()
+		ctx, stop := c.interruptible(cmd.Context(), signals)
+		defer stop()
+		err = c.runConvert(ctx, args)
+		if err != nil {
+			logger.Println(err)
+		}
+		removeDownloads()
+		if code := c.exitCode(err); code != exitOK {
+			os.Exit(code)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(convertCmd)
 
-	convertCmd.Flags().StringVar(&logFileName, "log-file", "cbr2cbz.log", "log file")
+	convertCmd.Flags().StringVar(&presetName, "preset", "", "start from a bundle of settings: "+presetNames()+"; flags, environment and config file still take precedence")
+	convertCmd.Flags().StringVar(&logFileName, "log-file", "cbr2cbz.log", "log file, {date} and {time} in the name are filled in for a log per batch (e.g. cbr2cbz-{date}.log)")
+	convertCmd.Flags().BoolVar(&logAppend, "log-append", false, "add to the log file instead of starting it afresh")
+	convertCmd.Flags().StringVar(&logMaxSize, "log-max-size", "", "move the log file aside to .1, .2, ... once it grows past this size (e.g. 10MB)")
+	convertCmd.Flags().IntVar(&logKeep, "log-keep", 5, "how many logs moved aside by --log-max-size to keep")
+	convertCmd.Flags().StringVar(&logFormat, "log-format", "text", "text, or json for one event per file on stdout, with the human log moved to stderr")
+	convertCmd.Flags().StringVar(&progressFormat, "progress-format", "text", "text, or ndjson for the events of --log-format json plus one as each file starts and each page is packed, on stdout or --progress-socket")
+	convertCmd.Flags().StringVar(&progressSocket, "progress-socket", "", "unix socket, or host:port for TCP, to connect to and write --progress-format ndjson events to instead of stdout")
+	convertCmd.Flags().StringVar(&scratchBudgetFlag, "scratch-budget", "", "maximum temporary space in-flight conversions may use (e.g. 20GB), unlimited if unset")
+	convertCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "number of files to convert in parallel, reduced automatically while IO errors persist")
+	convertCmd.Flags().IntVar(&prefetchAhead, "prefetch", 0, "read this many upcoming files ahead of the workers, one at a time, so they are cached locally when their turn comes (for network shares)")
+	convertCmd.Flags().StringVar(&stateFileName, "state-file", "", "file recording the progress of the batch so it can be resumed, removed once every file converted; one for each set of paths under the user cache dir when not given, empty to disable")
+	convertCmd.Flags().BoolVar(&resumeBatch, "resume", false, "carry on with the interrupted batch in --state-file, skipping what it converted and the search for files")
+	convertCmd.Flags().StringVar(&snapshotCommand, "snapshot-command", "", "command run before a batch that removes originals to snapshot the library, {name} replaced by the snapshot's name (e.g. 'zfs snapshot tank/comics@{name}'); the batch doesn't start if it fails")
+	convertCmd.Flags().StringVar(&preHookCommand, "pre-hook", "", "command run before each file, with CBR2CBZ_SOURCE, CBR2CBZ_DESTINATION and CBR2CBZ_SOURCE_SIZE set; the file fails if it does")
+	convertCmd.Flags().StringVar(&postHookCommand, "post-hook", "", "command run after each file, with CBR2CBZ_STATUS (convert, fail or skip), CBR2CBZ_DESTINATION_SIZE, CBR2CBZ_DURATION, CBR2CBZ_CODE and CBR2CBZ_ERROR set too, to tag, upload or refresh a library")
+	convertCmd.Flags().StringVar(&rollbackCommand, "snapshot-rollback-command", "", "command undoing the batch from its snapshot, {name} replaced too (e.g. 'zfs rollback -r tank/comics@{name}'), logged and recorded in the report, never run")
+	convertCmd.Flags().StringVar(&reportFileName, "report", "", "write the source, destination, sizes, compression ratio, duration and error of each file here, as CSV if it ends in .csv and JSON otherwise")
+	convertCmd.Flags().StringVar(&historyFileName, "history-file", defaultHistoryPath(), "file the totals of each run are added to for stats history, empty to disable")
+	convertCmd.Flags().BoolVar(&incremental, "incremental", false, "leave out files converted by an earlier run that haven't changed since, going by their size and modification time in --incremental-cache")
+	convertCmd.Flags().StringVar(&incrementalCacheName, "incremental-cache", defaultIncrementalCachePath(), "file --incremental records the files converted in")
+	convertCmd.Flags().IntVar(&retries, "retries", 2, "times to try a file again after a transient error, like a network share dropping out or a locked file, before it counts as failed")
+	convertCmd.Flags().DurationVar(&retryDelay, "retry-delay", 5*time.Second, "wait before the first retry, doubling for each one after")
+	convertCmd.Flags().BoolVar(&failFast, "fail-fast", false, "stop the batch at the first file that fails, same as --max-failures 1")
+	convertCmd.Flags().IntVar(&maxFailures, "max-failures", 0, "stop the batch once this many files failed, letting those in progress finish; 0 for no limit")
+	convertCmd.Flags().DurationVar(&stopAfter, "stop-after", 0, "stop starting files once the batch has run this long (e.g. 6h), letting those in progress finish; --resume carries on")
+	convertCmd.Flags().StringVar(&stopAt, "stop-at", "", "stop starting files at this time of day (e.g. 07:00), letting those in progress finish; --resume carries on")
+	convertCmd.Flags().StringVar(&komgaURL, "komga-url", "", "Komga server to ask to scan the libraries holding the new cbz files after a batch, e.g. http://nas:25600")
+	convertCmd.Flags().StringVar(&komgaAPIKey, "komga-api-key", "", "API key for --komga-url")
+	convertCmd.Flags().StringVar(&kavitaURL, "kavita-url", "", "Kavita server to ask to scan the folders of the new cbz files after a batch, e.g. http://nas:5000")
+	convertCmd.Flags().StringVar(&kavitaAPIKey, "kavita-api-key", "", "API key for --kavita-url")
+	convertCmd.Flags().StringSliceVar(&libraryPathMap, "library-path-map", nil, "local=server prefixes turning local folders into the paths Komga or Kavita see them as, e.g. /mnt/nas/Comics=/comics")
+	convertCmd.Flags().StringVar(&webhookURL, "webhook-url", "", "POST a JSON notification here as each file and each batch finishes, with the totals and failed files, for chat or home automation")
+	convertCmd.Flags().BoolVar(&notifyDesktop, "notify-desktop", false, "show a desktop notification with the totals and failed files when a batch finishes")
+	convertCmd.Flags().StringVar(&ntfyURL, "ntfy-url", "", "ntfy topic to publish the totals and failed files to when a batch finishes, e.g. https://ntfy.sh/my-comics")
+	convertCmd.Flags().StringVar(&ntfyToken, "ntfy-token", "", "access token for --ntfy-url, for protected topics")
+	convertCmd.Flags().StringVar(&pushoverUser, "pushover-user", "", "Pushover user key to send the totals and failed files to when a batch finishes, with --pushover-token")
+	convertCmd.Flags().StringVar(&pushoverToken, "pushover-token", "", "Pushover application token for --pushover-user")
+	convertCmd.Flags().StringVar(&crashDir, "crash-dir", defaultCrashDir(), "where a report is saved when reading an archive crashes, for report-crash; empty to not save one")
+	convertCmd.Flags().BoolVar(&telemetryEnabled, "telemetry", false, "after each run, send the version, OS and counts of formats and error codes (no file names or sizes) to help decide what to support next; off unless given")
+	convertCmd.Flags().StringVar(&telemetryURL, "telemetry-url", defaultTelemetryURL, "where --telemetry sends its report")
+	convertCmd.Flags().IntVar(&maxDepth, "max-depth", 0, "how many levels of folders to look in under the directories given, 1 for only the files directly in them, 0 for no limit")
+	convertCmd.Flags().StringSliceVar(&includePatterns, "include", nil, "only convert files under the directories given matching one of these globs, ** matches any number of folders (e.g. 'Marvel/**')")
+	convertCmd.Flags().StringSliceVar(&excludePatterns, "exclude", nil, "leave out files under the directories given matching any of these globs (e.g. '**/Manga/**'), patterns without a / match file names")
+	convertCmd.Flags().StringVar(&minSizeFlag, "min-size", "", "leave out files under the directories given smaller than this (e.g. 100KB), to skip broken archives")
+	convertCmd.Flags().StringVar(&maxSizeFlag, "max-size", "", "leave out files under the directories given bigger than this (e.g. 2GB)")
+	convertCmd.Flags().StringVar(&newerThanFlag, "newer-than", "", "only convert files under the directories given modified after this, an age (36h, 7d, 2w) or a date (2024-03-01)")
+	convertCmd.Flags().StringVar(&olderThanFlag, "older-than", "", "only convert files under the directories given modified before this, an age or a date")
+	convertCmd.Flags().BoolVarP(&nulDelimited, "null", "0", false, "paths read from stdin for - are separated by NULs instead of newlines, as find -print0 writes them")
+	convertCmd.Flags().StringVar(&readingListFile, "reading-list", "", "only convert the comics on this .cbl or text reading list, in its order")
+	convertCmd.Flags().BoolVar(&showProgress, "progress", false, "show a progress bar for the batch and the files being converted")
+	convertCmd.Flags().BoolVar(&showTUI, "tui", false, "show the queue, the files being converted, failures and totals full screen, with keys to pause, skip and retry")
+	convertCmd.Flags().DurationVar(&heartbeat, "heartbeat", time.Minute, "log a progress line this often while a single file is converting, 0 to disable")
+	convertCmd.Flags().StringSliceVar(&convertFrom, "from", defaultSources, "types of file to convert: cbr, cb7, cbt and pdf (pages are taken from the images embedded in each page)")
+	convertCmd.Flags().StringSliceVar(&convertExtensions, "extensions", nil, "more extensions of files to convert, e.g. rar or cbr.bak for misnamed archives; what they are is told from their contents")
+	convertCmd.Flags().StringSliceVar(&imageExtensions, "image-extensions", cbr2cbz.DefaultImageExtensions, "extensions of entries packed as pages, anything else not matched by --keep-files is dropped")
+	convertCmd.Flags().StringVar(&zipCompression, "compression", "default", "how entries are compressed: store (quickest, pages are compressed already), fastest, default or best")
+	convertCmd.Flags().StringVar(&entryEncoding, "entry-encoding", "auto", "what entry names that aren't UTF-8 are read as, e.g. shift-jis, gbk, big5 or cp437; auto guesses for each archive")
+	convertCmd.Flags().StringVar(&pageOrder, "page-order", cbr2cbz.DefaultPageOrder, "order entries go into the cbz: natural (page2 before page10), byte, folder (folder by folder, then by name) or archive (as stored in the source)")
+	convertCmd.Flags().StringVar(&recompress, "recompress", "", "re-encode every page while packing as jpeg, png, webp or avif, optionally with a quality (e.g. jpeg:85)")
+	convertCmd.Flags().StringVar(&normalizeFormat, "normalize-format", "", "re-encode the pages that aren't jpeg, png or webp, whichever is given, as that one, leaving the rest alone; optionally with a quality (e.g. jpeg:90)")
+	convertCmd.Flags().IntVar(&convertImages.MaxWidth, "max-width", 0, "downscale pages wider than this, re-encoding them in their own format unless --recompress is given")
+	convertCmd.Flags().IntVar(&convertImages.MaxHeight, "max-height", 0, "downscale pages taller than this")
+	convertCmd.Flags().BoolVar(&convertImages.AutoRotate, "auto-rotate", false, "turn pages shot sideways upright by their EXIF orientation, re-encoding them in their own format unless --recompress is given")
+	convertCmd.Flags().BoolVar(&flatten, "flatten", false, "move every entry to the top of the cbz, keeping the names of chapter folders in the page names")
+	convertCmd.Flags().BoolVar(&stripWrappers, "strip-wrapper-folders", false, "take out the folder everything is in and folders holding only a folder of the same name, keeping chapter folders")
+	convertCmd.Flags().BoolVar(&recurseArchives, "recurse-archives", false, "unpack zips, rars and other archives inside the archive into a folder each, with --split-chapters one cbz each")
+	convertCmd.Flags().BoolVar(&renumber, "renumber", false, "rename pages to their position in the cbz (001.jpg, 002.jpg, ...), replacing whatever they were called")
+	convertCmd.Flags().BoolVar(&padNumbers, "pad-numbers", false, "zero-pad the numbers in page names (2.jpg to 02.jpg) so they sort in reading order, leaving the rest of the name alone")
+	convertCmd.Flags().StringSliceVar(&keepFiles, "keep-files", cbr2cbz.DefaultKeepFiles, "glob patterns of non-image entries to keep in the cbz (e.g. *.nfo)")
+	convertCmd.Flags().BoolVar(&dropDuplicates, "drop-duplicate-pages", false, "leave out pages with exactly the contents of an earlier page, like a credits page or cover scanned in twice")
+	convertCmd.Flags().BoolVar(&stripComments, "strip-comments", false, "leave the archive comment, often release group or scanner notes, out of the cbz instead of carrying it over")
+	convertCmd.Flags().BoolVar(&stripJunk, "strip-junk", false, "drop Thumbs.db, .DS_Store, desktop.ini, __MACOSX/ and empty files from the cbz")
+	convertCmd.Flags().BoolVar(&bookmarks, "bookmarks", false, "detect chapters from folders or names like ch01 and bookmark them in ComicInfo.xml")
+	convertCmd.Flags().BoolVar(&generateInfo, "generate-comicinfo", false, "add a ComicInfo.xml with series, number, volume and year guessed from the file name and the page list, if the archive has none")
+	convertCmd.Flags().BoolVar(&markCover, "mark-cover", false, "guess the cover page and mark it as FrontCover in ComicInfo.xml")
+	convertCmd.Flags().BoolVar(&splitChapters, "split-chapters", false, "write one cbz per internal chapter folder into a directory named after the archive, instead of a single cbz")
+	convertCmd.Flags().BoolVar(&coverFirst, "cover-first", false, "move the guessed cover page to the front of the cbz")
+	convertCmd.Flags().BoolVar(&writeSeries, "series-json", false, "create or fill in a Mylar style series.json in each folder with converted files")
+	convertCmd.Flags().StringVar(&convertThumbsDir, "thumbs-dir", "", "write a thumbnail of the cover of each cbz into this cache directory, named after its SHA-256, for gallery UIs")
+	addThumbFlags(convertCmd)
+	convertCmd.Flags().BoolVar(&writeReceipts, "receipts", false, "write a .converted.json receipt next to each cbz with the source's name and checksum, the options, duration and whether it was verified")
+	convertCmd.Flags().BoolVar(&writeChecksums, "checksums", false, "write a .sha256.json next to each cbz with the SHA-256 of it, its source and every page of both, showing whether the pages came through unchanged")
+	convertCmd.Flags().StringVar(&checksumsFileName, "checksums-file", "", "write the SHA-256 of every cbz converted, its source and every page of both to this file, as JSON")
+	convertCmd.Flags().StringVar(&entryErrors, "entry-errors", "fail", "what to do with entries that can't be read: fail the archive, or skip them and convert the rest")
+	convertCmd.Flags().BoolVar(&placeholderPages, "placeholder-pages", false, "with --entry-errors skip, put a page saying it was unreadable in place of each skipped page so page counts and spreads line up")
+	convertCmd.Flags().StringVar(&maxEntrySize, "max-entry-size", "2GB", "abort archives with any entry decompressing to more than this, empty to disable")
+	convertCmd.Flags().Float64Var(&maxExpansion, "max-expansion", 20, "abort archives decompressing to more than this multiple of their size, 0 to disable")
+	convertCmd.Flags().BoolVar(&sandbox, "sandbox", false, "unpack and repack each archive in a separate, restricted process, under a syscall filter on linux amd64 and arm64")
+	convertCmd.Flags().StringVar(&externalUnrar, "external-unrar", "", "unrar or 7z binary to unpack rars the built in reader fails on (newer RAR5 features, odd compression methods) with, instead of failing them")
+	convertCmd.Flags().Float64Var(&qaSample, "qa-sample", 0, "after the batch, decode every page of this percentage of the converted files, picked at random, and compare them against the originals that were kept")
+	convertCmd.Flags().BoolVar(&verifyOutputs, "verify", true, "read back every cbz and check its CRCs before deleting the original, while the next file converts")
+	convertCmd.Flags().BoolVar(&preserveAttrs, "preserve-attributes", true, "give each cbz the modification time and permissions of its original, so it isn't listed as recently added")
+	convertCmd.Flags().BoolVar(&keepOriginal, "keep", false, "keep the original cbr after a successful conversion instead of deleting it")
+	convertCmd.Flags().BoolVar(&trashOriginals, "trash", false, "move the original cbr to the trash or recycle bin after a successful conversion instead of deleting it")
+	convertCmd.Flags().StringVar(&backupDir, "backup-dir", "", "move the original cbr into this directory after a successful conversion instead of deleting it")
+	convertCmd.Flags().StringVar(&quarantineDir, "quarantine-dir", "", "move files that fail to convert into this directory, each with a .error.txt note of why, to look into after the batch")
+	convertCmd.Flags().BoolVar(&quarantineLink, "quarantine-link", false, "hard link failed files into --quarantine-dir instead of moving them, leaving the library as it was (local disks only)")
+	convertCmd.Flags().StringVar(&retentionFileName, "retention-file", defaultRetentionPath(), "file the originals --trash and --backup-dir move aside are recorded in, for purge; empty to disable")
+	convertCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "write cbz files into this directory, mirroring the layout under each path given, instead of next to the cbr")
+	convertCmd.Flags().StringVar(&renameFormat, "rename", "", "name each cbz after the series, volume, issue, year and scan group parsed from the original's name, e.g. '{series} v{volume} #{issue:03}.cbz'")
+	convertCmd.Flags().StringVar(&onExists, "on-exists", "", "what to do when the cbz is already there: overwrite, skip, rename to 'name (2).cbz' or error; overwrite by default, rename with --rename")
+	addRemoteFlags(convertCmd)
+	convertCmd.Flags().StringVar(&claimDir, "claim-dir", "", "shared directory several instances use to claim files, so they can work on one library without duplicating work")
+	convertCmd.Flags().DurationVar(&leaseTTL, "lease-ttl", 10*time.Minute, "how long a claim lasts without being renewed before another instance may take it over")
+	convertCmd.Flags().DurationVar(&stallTimeout, "stall-timeout", 5*time.Minute, "abort a file if no data is read or written for this long, and leave it behind as failed if it is still stuck that long after, 0 to disable")
+	convertCmd.Flags().StringVar(&downloadDir, "download-dir", "", "keep the archives given as http(s) urls here, carrying on with those that broke off on the next run, instead of a temporary directory")
+	convertCmd.Flags().DurationVar(&fileTimeout, "file-timeout", 0, "give up on a file that takes longer than this altogether (e.g. 30m), so one pathological archive can't hold up the batch; 0 to disable")
+
+	for _, name := range []string{"output-dir", "backup-dir", "quarantine-dir", "claim-dir", "thumbs-dir", "crash-dir", "download-dir"} {
+		convertCmd.RegisterFlagCompletionFunc(name, completeDirs)
+	}
+	convertCmd.RegisterFlagCompletionFunc("preset", completePresets)
+}
+
+// newConverter builds a converter from the convert flags.
+func newConverter(logger logger) (*converter, error) {
+	fsys, err := newLocalFS()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &converter{
+		fs:              fsys,
+		logger:          logger,
+		jobs:            jobs,
+		heartbeat:       heartbeat,
+		stallTimeout:    stallTimeout,
+		fileTimeout:     fileTimeout,
+		seriesJSON:      writeSeries,
+		receipts:        writeReceipts,
+		checksums:       writeChecksums,
+		checksumsPath:   checksumsFileName,
+		keep:            keepOriginal,
+		trash:           trashOriginals,
+		backupDir:       backupDir,
+		retries:         retries,
+		retryDelay:      retryDelay,
+		quarantineDir:   quarantineDir,
+		quarantineLink:  quarantineLink,
+		retentionPath:   retentionFileName,
+		outputDir:       outputDir,
+		sandbox:         sandbox,
+		unrar:           externalUnrar,
+		splitChapters:   splitChapters,
+		verify:          verifyOutputs,
+		preserveAttrs:   preserveAttrs,
+		prefetch:        prefetchAhead,
+		qaSample:        qaSample,
+		preHookCommand:  preHookCommand,
+		postHookCommand: postHookCommand,
+	}
+	if fit := jobsForFileLimit(c.jobs, openFileLimit()); fit < c.jobs {
+		logger.Printf("[%s] Only %d open files allowed, running %d jobs instead of %d (raise it with ulimit -n)\n", cbr2cbz.CodeJobsCapped, openFileLimit(), fit, c.jobs)
+		c.jobs = fit
+	}
+	if !logFormats[logFormat] {
+		return nil, errors.Errorf("unknown --log-format %q", logFormat)
+	}
+	if logFormat == "json" && showProgress {
+		return nil, errors.New("--progress can't be combined with --log-format json")
+	}
+	if showTUI && (logFormat == "json" || showProgress) {
+		return nil, errors.New("--tui can't be combined with --progress or --log-format json")
+	}
+	if !progressFormats[progressFormat] {
+		return nil, errors.Errorf("unknown --progress-format %q", progressFormat)
+	}
+	if progressSocket != "" && progressFormat != "ndjson" {
+		return nil, errors.New("--progress-socket needs --progress-format ndjson")
+	}
+	if progressSocket != "" && logFormat == "json" {
+		return nil, errors.New("--progress-socket can't be combined with --log-format json, which writes the events to stdout")
+	}
+	if progressFormat == "ndjson" && progressSocket == "" && (showProgress || showTUI) {
+		return nil, errors.New("--progress-format ndjson writes to stdout, it can only be combined with --progress or --tui given a --progress-socket")
+	}
+	if qaSample < 0 || qaSample > 100 {
+		return nil, errors.Errorf("--qa-sample must be between 0 and 100, got %g", qaSample)
+	}
+	if !cbr2cbz.PageOrders[pageOrder] {
+		return nil, errors.Errorf("unknown --page-order %q", pageOrder)
+	}
+	if !cbr2cbz.Compressions[zipCompression] {
+		return nil, errors.Errorf("unknown --compression %q", zipCompression)
+	}
+	if _, ok := cbr2cbz.EntryEncodings[entryEncoding]; !ok {
+		return nil, errors.Errorf("unknown --entry-encoding %q", entryEncoding)
+	}
+	if sandbox && !sandboxSupported {
+		return nil, errors.Errorf("--sandbox isn't supported on %s", runtime.GOOS)
+	}
+	if splitChapters && sandbox {
+		return nil, errors.New("--split-chapters can't be combined with --sandbox")
+	}
+	if trashOriginals && backupDir != "" {
+		return nil, errors.New("--trash can't be combined with --backup-dir")
+	}
+	if retries < 0 || retryDelay < 0 {
+		return nil, errors.New("--retries and --retry-delay can't be negative")
+	}
+	if quarantineLink && quarantineDir == "" {
+		return nil, errors.New("--quarantine-link needs --quarantine-dir")
+	}
+	if keepOriginal && (trashOriginals || backupDir != "") {
+		return nil, errors.New("--keep can't be combined with --trash or --backup-dir")
+	}
+	if !entryErrorModes[entryErrors] {
+		return nil, errors.Errorf("unknown --entry-errors %q", entryErrors)
+	}
+	if placeholderPages && entryErrors != "skip" {
+		return nil, errors.New("--placeholder-pages needs --entry-errors skip")
+	}
+	images := convertImages
+	if recompress != "" {
+		opts, err := parseRecompress(recompress)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --recompress")
+		}
+		images.Format, images.Quality = opts.Format, opts.Quality
+	}
+	if normalizeFormat != "" {
+		if recompress != "" {
+			return nil, errors.New("--normalize-format can't be combined with --recompress, which re-encodes every page already")
+		}
+		opts, err := parseRecompress(normalizeFormat)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --normalize-format")
+		}
+		images.Normalize, images.Quality = opts.Format, opts.Quality
+	}
+
+	var limits cbr2cbz.Limits
+	if maxEntrySize != "" {
+		limits.MaxEntry, err = humanize.ParseBytes(maxEntrySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --max-entry-size")
+		}
+	}
+	limits.MaxRatio = maxExpansion
+
+	err = c.setOptions(cbr2cbz.Options{
+		ImageExtensions:    imageExtensions,
+		KeepFiles:          keepFiles,
+		Bookmarks:          bookmarks,
+		MarkCover:          markCover,
+		GenerateInfo:       generateInfo,
+		CoverFirst:         coverFirst,
+		PadNumbers:         padNumbers,
+		Renumber:           renumber,
+		StripJunk:          stripJunk,
+		StripComments:      stripComments,
+		DropDuplicatePages: dropDuplicates,
+		Flatten:            flatten,
+		StripWrappers:      stripWrappers,
+		RecurseArchives:    recurseArchives,
+		SkipBadEntries:     entryErrors == "skip",
+		Placeholders:       placeholderPages,
+		PageOrder:          pageOrder,
+		Compression:        zipCompression,
+		EntryEncoding:      entryEncoding,
+		Images:             &images,
+	}, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	c.sources, err = sourceExtensions(convertFrom)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing --from")
+	}
+	c.extensions, err = parseExtensions(convertExtensions)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing --extensions")
+	}
+
+	if scratchBudgetFlag != "" {
+		limit, err := humanize.ParseBytes(scratchBudgetFlag)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --scratch-budget")
+		}
+		c.scratch = newScratchBudget(limit)
+	}
+
+	if webhookURL != "" {
+		c.webhook, err = newWebhook(webhookURL, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.notifiers, err = newNotifiers()
+	if err != nil {
+		return nil, err
+	}
+
+	c.libraries, err = newLibraryScanner(komgaURL, komgaAPIKey, kavitaURL, kavitaAPIKey, libraryPathMap, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxDepth < 0 {
+		return nil, errors.Errorf("--max-depth can't be negative, got %d", maxDepth)
+	}
+	c.maxDepth = maxDepth
+	c.filter, err = newPathFilter(includePatterns, excludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	c.fileFilter, err = newFileFilter(minSizeFlag, maxSizeFlag, newerThanFlag, olderThanFlag, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	c.maxFailures = maxFailures
+	if failFast {
+		c.maxFailures = 1
+	}
+	c.stopAfter = stopAfter
+	if stopAt != "" {
+		c.stopAt, err = parseClock(stopAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --stop-at")
+		}
+	}
+
+	if renameFormat != "" {
+		c.rename, err = parseRenameTemplate(renameFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if onExists != "" && !OnExistsPolicies[onExists] {
+		return nil, errors.Errorf("unknown --on-exists %q, use overwrite, skip, rename or error", onExists)
+	}
+	c.onExists = onExists
+
+	if readingListFile != "" {
+		c.readingList, err = loadReadingList(readingListFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if incremental {
+		c.processed, err = loadProcessedCache(incrementalCacheName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if convertThumbsDir != "" {
+		c.thumbs, err = newThumbnailer(convertThumbsDir, thumbsSize, thumbsFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if claimDir != "" {
+		c.claims, err = newClaimStore(fsys, claimDir, leaseTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
 }
 
 type logger interface {
@@ -61,17 +594,167 @@ type logger interface {
 }
 
 type converter struct {
-	fs       hackpadfs.FS
-	logger   logger
-	cbrFiles []string
-	cbrSize  uint64
-	allFiles []string
-	allSize  uint64
+	fs           hackpadfs.FS
+	logger       logger
+	cbrFiles     []string
+	cbrSize      uint64
+	allFiles     []string
+	allSize      uint64
+	scratch      *scratchBudget
+	jobs         int
+	heartbeat    time.Duration
+	stallTimeout time.Duration
+	fileTimeout  time.Duration
+	// stopping is closed once the batch is interrupted, see interruptible
+	stopping <-chan struct{}
+	// engine packs each archive
+	engine     *cbr2cbz.Converter
+	seriesJSON bool
+	// receipts is whether a .converted.json goes next to each cbz
+	receipts bool
+	// checksums is whether a .sha256.json with the hash of every page goes
+	// next to each cbz, and checksumsPath is where the same for the whole
+	// batch is written
+	checksums     bool
+	checksumsPath string
+	// thumbs makes a thumbnail of each cbz, nil unless --thumbs-dir
+	thumbs  *thumbnailer
+	sandbox bool
+	// unrar is the binary rars the engine can't read are tried with, empty
+	// to fail them
+	unrar  string
+	claims *claimStore
+	keep   bool
+	// trash and backupDir are where originals go instead of being deleted
+	trash     bool
+	backupDir string
+	// retries is how many times a file is tried again after a transient
+	// error, retryDelay after the first time
+	retries    int
+	retryDelay time.Duration
+	// quarantineDir is where files that failed go, linked instead of moved
+	// with quarantineLink
+	quarantineDir  string
+	quarantineLink bool
+	// retentionPath is where the originals moved aside are recorded
+	retentionPath string
+	outputDir     string
+	// rename names outputs after their parsed source names, nil to keep the
+	// names. renamed is the name each source got and renamedTo the other way
+	// round
+	rename *renameTemplate
+	// onExists is what --on-exists says to do about a cbz already there,
+	// see existsPolicy
+	onExists  string
+	renamed   map[string]string
+	renamedTo map[string]string
+	renameMu  sync.Mutex
+	sources   map[string]bool
+	// extensions are the suffixes given to --extensions, with their dot
+	extensions  []string
+	readingList *readingList
+	// sections groups the lines about each file while several convert
+	sections   *logSections
+	filter     *pathFilter
+	fileFilter *fileFilter
+	// maxDepth is how many levels of folders are searched, 0 for all
+	maxDepth int
+	// readOnlyDirs is which folders of sources were found to be read-only
+	readOnlyDirs  map[string]bool
+	readOnlyMu    sync.Mutex
+	splitChapters bool
+	verify        bool
+	// preserveAttrs copies the modification time and permissions of each
+	// original to its cbz
+	preserveAttrs bool
+	prefetch      int
+	qaSample      float64
+	events        *eventLog
+	display       *batchDisplay
+	// tui is the full screen view --tui shows, nil without it
+	tui *batchTUI
+	// onStart, if set, is given the progress of each file as it starts
+	// converting
+	onStart func(cbrFile string, p *cbr2cbz.Progress)
+	// settings is every option this run was configured with, for the log
+	settings []effectiveOption
+	// statePath is the state file of the batch, resumed if resume is set
+	statePath string
+	resume    bool
+	// scanned is set when the files were found before runConvert, which
+	// then takes cbrFiles and allFiles as they are
+	scanned bool
+	state   *batchState
+	paths   []string
+	// historyPath is where the totals of each run are added
+	historyPath string
+	// processed is the files earlier runs converted, nil unless
+	// --incremental
+	processed *processedCache
+	// telemetryURL is where the run is reported, empty unless --telemetry
+	telemetryURL string
+	// webhook is told as each file and each batch finishes, nil unless
+	// --webhook-url
+	webhook *webhook
+	// notifiers are sent the summary of each batch, from --notify-desktop,
+	// --ntfy-url and --pushover-user
+	notifiers []notifier
+	// libraries is asked to scan the folders of the cbz files written, nil
+	// unless --komga-url or --kavita-url
+	libraries *libraryScanner
+	// crashDir is where a report is saved when reading a file panics
+	crashDir string
+	// maxFailures stops the batch once this many files failed, 0 never does
+	maxFailures int
+	// stopAfter and stopAt are when the batch stops starting files, see
+	// batchDeadline
+	stopAfter time.Duration
+	stopAt    *clockTime
+	// reportPath is where the results of each file are written, as JSON or CSV
+	reportPath string
+	// snapshotCommand takes a snapshot of the library before a batch, and
+	// rollbackCommand is what the report says undoes it, see takeSnapshot
+	snapshotCommand string
+	rollbackCommand string
+	// preHookCommand and postHookCommand run before and after each file,
+	// see preHook and postHook
+	preHookCommand  string
+	postHookCommand string
+	// roots maps each file found to the path it was found under, so its
+	// place in the tree can be mirrored into outputDir
+	roots map[string]string
+
+	// results of the last runConvert
+	converted []string
+	// origins is the file each of converted was converted from
+	origins map[string]string
+	// bytesIn and bytesOut are the sizes of converted before and after
+	bytesIn  int64
+	bytesOut int64
+	failed   map[string]error
+	duration time.Duration
+	// notStarted is how many files were left when the batch reached its
+	// deadline
+	notStarted int
+	// qa is the outcome of the --qa-sample checks, nil if there were none
+	qa *qaReport
+	// snapshot is the one taken before the batch, nil if none was
+	snapshot *snapshot
+	// manifests are the checksums of converted, for checksumsPath
+	manifests   []checksumManifest
+	checksumsMu sync.Mutex
+	// results is how each file tried went
+	results []fileResult
+	// rewrites are the entries renamed to safe paths of files still
+	// converting, see noteRewrite
+	rewrites   map[string][]rewrittenPath
+	rewritesMu sync.Mutex
 }
 
 func (c *converter) findFilesAndSize(_ context.Context, paths []string) error {
 	var err error
 	c.allFiles = []string{}
+	c.roots = map[string]string{}
 	for _, path := range paths {
 		stat, err := fs.Stat(c.fs, pathToFsPath(path))
 		if err != nil {
@@ -79,25 +762,55 @@ func (c *converter) findFilesAndSize(_ context.Context, paths []string) error {
 		}
 
 		if stat.IsDir() {
-			files, err := findFiles(c.fs, filepath.Join(path, "."))
+			files, err := findFilesDepth(c.fs, filepath.Join(path, "."), c.maxDepth)
 			if err != nil {
 				return errors.Wrap(err, "finding cbrs")
 			}
-			c.allFiles = append(c.allFiles, files...)
+			for _, file := range files {
+				c.roots[file] = path
+				if !c.filter.allows(c.relPath(file), file) {
+					delete(c.roots, file)
+					continue
+				}
+				if c.fileFilter != nil {
+					info, err := fs.Stat(c.fs, pathToFsPath(file))
+					if err != nil {
+						return errors.Wrap(err, "getting file stats")
+					}
+					if !c.fileFilter.allows(info) {
+						delete(c.roots, file)
+						continue
+					}
+				}
+				c.allFiles = append(c.allFiles, file)
+			}
 		} else {
 			c.allFiles = append(c.allFiles, path)
+			c.roots[path] = filepath.Dir(path)
 		}
 	}
 
 	c.cbrFiles = []string{}
 	for _, file := range c.allFiles {
-		if strings.ToLower(filepath.Ext(file)) == ".cbr" {
+		if c.converts(file) {
 			c.cbrFiles = append(c.cbrFiles, file)
 		}
 	}
 
+	if c.readingList != nil {
+		for _, book := range c.readingList.missing(c.cbrFiles) {
+			c.logger.Printf("%s is on the reading list but wasn't found\n", book)
+		}
+		c.cbrFiles = c.readingList.filter(c.cbrFiles)
+	}
+
+	err = c.skipUnchanged()
+	if err != nil {
+		return err
+	}
+
 	if len(c.cbrFiles) == 0 {
-		return errors.New("No files to convert!")
+		return errNoFiles
 	}
 
 	c.allSize, err = getFileSize(c.fs, "", c.allFiles...)
@@ -105,7 +818,7 @@ func (c *converter) findFilesAndSize(_ context.Context, paths []string) error {
 		return errors.Wrap(err, "getting non cbr file stats")
 	}
 
-	c.cbrSize, err = getFileSize(c.fs, ".cbr", c.cbrFiles...)
+	c.cbrSize, err = getFileSize(c.fs, "", c.cbrFiles...)
 	if err != nil {
 		return errors.Wrap(err, "getting cbr file stats")
 	}
@@ -114,63 +827,410 @@ func (c *converter) findFilesAndSize(_ context.Context, paths []string) error {
 }
 
 func (c *converter) runConvert(ctx context.Context, paths []string) error {
-	failedFiles := map[string]error{}
+	c.failed = map[string]error{}
+	c.converted = []string{}
+	c.origins = map[string]string{}
+	c.bytesIn, c.bytesOut = 0, 0
+	c.qa = nil
+	c.results = []fileResult{}
+	c.manifests = nil
+	c.notStarted = 0
+	c.packer()
 	startTime := time.Now()
+	deadline := c.batchDeadline(startTime)
+	defer func() { c.duration = time.Since(startTime) }()
 
-	err := c.findFilesAndSize(ctx, paths)
+	c.paths = paths
+	if c.resume {
+		err := c.resumeFiles(paths)
+		if err != nil {
+			return err
+		}
+		if len(c.cbrFiles) == 0 {
+			c.logger.Printf("Nothing left to convert in %s\n", c.statePath)
+			os.Remove(c.statePath)
+			return nil
+		}
+	} else if !c.scanned {
+		err := c.findFilesAndSize(ctx, paths)
+		if err != nil {
+			return errors.Wrap(err, "finding files and sizes")
+		}
+	}
+	err := c.takeSnapshot(ctx, startTime)
+	if err != nil {
+		return err
+	}
+	err = c.startBatchState()
 	if err != nil {
-		return errors.Wrap(err, "finding files and sizes")
+		return err
 	}
+	defer func() { c.finishBatchState(ctx.Err() == nil && len(c.failed) == 0 && c.notStarted == 0) }()
 
 	c.logger.Printf("CBR2CBZ Batch Log\n")
 	c.logger.Printf("Version %s\n", rootCmd.Version)
 	c.logger.Printf("You can check for script updates at https://github.com/halkeye/cbr2cbz (original bash version at https://git.zaks.web.za/thisiszeev/cbr2cbz)\n")
 	c.logger.Printf("Batch Start Date & Time: %s\n", time.Now().Format(time.RFC3339))
 	c.logger.Printf("\n")
-	c.logger.Printf("Considering %d files (%s)\n", len(c.allFiles), humanize.Bytes(c.allSize))
+	if len(c.settings) > 0 {
+		verbosef(c.logger, "Effective options:\n")
+		for _, o := range c.settings {
+			verbosef(c.logger, "  %s = %v (%s)\n", o.Name, o.Value, o.Source)
+		}
+		opts := c.packer().Options()
+		verbosef(c.logger, "Conversion options %s %s\n", opts.Fingerprint(), opts)
+		verbosef(c.logger, "\n")
+	}
+	c.logger.Printf("Considering %s files (%s)\n", formatCount(len(c.allFiles)), formatBytes(c.allSize))
 	c.logger.Printf("   of which...\n")
-	c.logger.Printf("Non CBR files: %d (%s)\n", len(c.allFiles)-len(c.cbrFiles), humanize.Bytes(c.allSize-c.cbrSize))
-	c.logger.Printf("CBR files: %d (%s)\n", len(c.cbrFiles), humanize.Bytes(c.cbrSize))
+	c.logger.Printf("Non CBR files: %s (%s)\n", formatCount(len(c.allFiles)-len(c.cbrFiles)), formatBytes(c.allSize-c.cbrSize))
+	c.logger.Printf("CBR files: %s (%s)\n", formatCount(len(c.cbrFiles)), formatBytes(c.cbrSize))
+	if c.jobs > 1 {
+		c.logger.Printf("Converting %d files at a time, the lines about each are written together once it is done\n", c.jobs)
+		c.sections = newLogSections(c.logger)
+		parent := c.logger
+		c.logger = c.sections
+		defer func() { c.logger, c.sections = parent, nil }()
+	}
 
-	for _, cbrFile := range c.cbrFiles {
-		size := strings.TrimSuffix(filepath.Base(cbrFile), filepath.Ext(cbrFile))
-		cbzFile := filepath.Join(filepath.Dir(cbrFile), size+".cbz")
+	stopDisplay := c.display.begin(len(c.cbrFiles), c.cbrSize, 200*time.Millisecond)
+	defer stopDisplay()
+	stopTUI := func() {}
+	if c.tui != nil {
+		var quit context.CancelFunc
+		ctx, quit = context.WithCancel(ctx)
+		defer quit()
+		stopTUI = c.tui.begin(c.cbrFiles, c.cbrSize, quit, 200*time.Millisecond)
+		defer stopTUI()
+	}
+
+	limiter := newAdaptiveLimiter(c.jobs, c.logger)
+	// outputs waiting on verification hold one of these instead of a job
+	// slot, so at most jobs of them pile up
+	verifySlots := make(chan struct{}, max(c.jobs, 1))
+	var (
+		wg        sync.WaitGroup
+		resultsMu sync.Mutex
+	)
 
-		err := c.convert(ctx, cbrFile, cbzFile)
+	var prefetch *prefetcher
+	if c.prefetch > 0 {
+		prefetch = newPrefetcher(c.fs, c.logger, c.cbrFiles, c.prefetch)
+		prefetchCtx, stopPrefetch := context.WithCancel(ctx)
+		defer stopPrefetch()
+		go prefetch.run(prefetchCtx)
+	}
 
+	for i := 0; ; i++ {
+		cbrFile, ok := c.nextFile(ctx, i)
+		if !ok {
+			break
+		}
+		cbzFile := c.cbzPath(cbrFile)
+
+		err := c.tui.waitWhilePaused(ctx)
 		if err != nil {
-			c.logger.Printf("Error Reading %s - Skipping...%s\n", cbrFile, err.Error())
-			failedFiles[cbrFile] = err
-			continue
+			break
+		}
+		err = limiter.acquire(ctx)
+		if err != nil {
+			break
 		}
+		resultsMu.Lock()
+		failures := len(c.failed)
+		resultsMu.Unlock()
+		if c.maxFailures > 0 && failures >= c.maxFailures {
+			limiter.release(nil)
+			c.logger.Printf("Stopping after %d failed files, %d were not tried\n", failures, max(len(c.cbrFiles)-i, 0))
+			break
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			limiter.release(nil)
+			c.notStarted = max(len(c.cbrFiles)-i, 0)
+			c.logger.Printf("Stopping at %s, %d files left to carry on with using --resume\n", deadline.Format("15:04"), c.notStarted)
+			break
+		}
+		if c.interrupted() {
+			limiter.release(nil)
+			c.notStarted = max(len(c.cbrFiles)-i, 0)
+			c.logger.Printf("Stopping, %d files left to carry on with using --resume\n", c.notStarted)
+			break
+		}
+		prefetch.start()
+
+		wg.Add(1)
+		c.sections.open(cbrFile)
+		go func() {
+			defer wg.Done()
+			defer c.sections.flush(cbrFile)
+			ctx, done := c.tui.fileContext(ctx, cbrFile)
+			defer done()
+			ctx, stopTimeout := c.withFileTimeout(ctx)
+			defer stopTimeout()
+
+			var bytesIn int64
+			info, err := hackpadfs.Stat(c.fs, pathToFsPath(cbrFile))
+			if err == nil {
+				bytesIn = info.Size()
+			}
+			started := time.Now()
+			verifying := false
+			err = c.preHook(ctx, cbrFile, cbzFile)
+			if err == nil {
+				err = c.withRetries(ctx, cbrFile, func(written func()) error {
+					return abandonIfStuck(ctx, c.stallTimeout, func(ctx context.Context, written func()) error {
+						return c.convertSafely(ctx, cbrFile, cbzFile, written)
+					}, written)
+				}, func() {
+					verifySlots <- struct{}{}
+					verifying = true
+					limiter.release(nil)
+				})
+			}
+			if err != nil && errors.Is(context.Cause(ctx), errFileTimeout) {
+				// not an IO error, nothing to slow down for
+				err = errors.Wrapf(errFileTimeout, "gave up after %s", c.fileTimeout)
+			}
+			if verifying {
+				<-verifySlots
+			} else {
+				limiter.release(err)
+			}
+			if err != nil && errors.Is(context.Cause(ctx), errSkipped) {
+				err = errSkipped
+			}
+			c.display.finish(cbrFile)
+			c.tui.finish(cbrFile, explainFileLimit(err))
+			event := c.fileEvent(cbrFile, cbzFile, bytesIn, time.Since(started), explainFileLimit(err))
+			c.events.emit(event)
+			c.webhook.send(fileWebhook(event))
+			c.postHook(ctx, event)
+			result := fileResultOf(event)
+			result.RewrittenPaths = c.takeRewrites(cbrFile)
+			if err == nil && c.reportPath != "" {
+				c.addPages(&result)
+			}
+
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			if _, retried := c.failed[cbrFile]; retried {
+				// a retry replaces how the file went before
+				c.results = slices.DeleteFunc(c.results, func(r fileResult) bool { return r.Source == cbrFile })
+				delete(c.failed, cbrFile)
+			}
+			c.results = append(c.results, result)
+			if isSkip(err) {
+				c.warn(errorCode(err), cbrFile, "Skipping %s, %s", cbrFile, err.Error())
+				if errors.Is(err, errOutputSkipped) {
+					c.remember(cbrFile, info)
+				}
+				return
+			}
+			if err != nil {
+				err = explainFileLimit(err)
+				c.logFor(cbrFile).Printf("[%s] Error Reading %s - Skipping...%s\n", errorCode(err), cbrFile, err.Error())
+				c.failed[cbrFile] = err
+				if ctx.Err() == nil {
+					c.record(batchRecord{File: cbrFile, Status: "failed", Error: err.Error()})
+				}
+				return
+			}
+			c.converted = append(c.converted, cbzFile)
+			c.origins[cbzFile] = cbrFile
+			c.bytesIn += event.BytesIn
+			c.bytesOut += event.BytesOut
+			c.record(batchRecord{File: cbrFile, Status: "converted", Output: cbzFile})
+			c.remember(cbrFile, info)
+		}()
 	}
+	wg.Wait()
+	stopDisplay()
+	stopTUI()
+	c.quarantineFailed(c.failed)
 
-	c.printStats(startTime, failedFiles)
+	if c.seriesJSON {
+		c.writeSeriesJSON(c.converted)
+	}
+	if c.qaSample > 0 {
+		c.qa = c.runQA(ctx, sampleFiles(c.converted, c.qaSample))
+	}
 
-	return nil
+	c.printStats(startTime, c.failed)
+	c.recordHistory(startTime)
+	c.sendTelemetry()
+	c.events.emit(logEvent{Action: "batch", Duration: time.Since(startTime).Seconds(), Converted: len(c.converted), Failed: len(c.failed)})
+	c.webhook.send(c.batchWebhook(startTime))
+	c.webhook.flush()
+	c.notifyBatch(startTime)
+	c.libraries.add(c.converted...)
+	c.libraries.refresh()
+
+	if err := c.writeChecksumsFile(); err != nil {
+		return err
+	}
+	return c.writeReport(startTime)
 }
 
+// nextFile is the file to start ith, the files found and then any --tui is
+// asked to retry.
+func (c *converter) nextFile(ctx context.Context, i int) (string, bool) {
+	if i < len(c.cbrFiles) {
+		return c.cbrFiles[i], true
+	}
+	return c.tui.nextRetry(ctx)
+}
+
+// cbzPath is where cbrFile gets converted to, next to it or at the same
+// place under outputDir as it was under the path it was found in, named by
+// --rename if set.
+func (c *converter) cbzPath(cbrFile string) string {
+	stem := strings.TrimSuffix(filepath.Base(cbrFile), filepath.Ext(cbrFile))
+	if ext := c.extraExtension(cbrFile); ext != "" {
+		stem = strings.TrimSuffix(filepath.Base(cbrFile), ext)
+	}
+	if volume := volumeStem(c.fs, cbrFile); volume != "" {
+		stem = volume
+	}
+	dir := filepath.Dir(cbrFile)
+	if c.outputDir != "" {
+		dir = filepath.Join(c.outputDir, path.Dir(c.relPath(cbrFile)))
+	}
+	if c.rename != nil {
+		return c.renamedPath(cbrFile, dir, stem)
+	}
+	if c.existsPolicy() == "rename" {
+		return c.numberedPath(cbrFile, filepath.Join(dir, stem))
+	}
+	return filepath.Join(dir, stem+".cbz")
+}
+
+// relPath is where cbrFile is under the path it was found in.
+func (c *converter) relPath(cbrFile string) string {
+	root := path.Clean(pathToFsPath(c.roots[cbrFile]))
+	rel := path.Clean(pathToFsPath(cbrFile))
+	if root != "." {
+		rel = strings.TrimPrefix(rel, root+"/")
+	}
+	return rel
+}
+
+// pathToFsPath is path as a name in a hackpadfs.FS, without the slashes
+// around it. Backslashes are taken for separators on Windows, where
+// filepath.Join and friends put them in.
 func pathToFsPath(path string) string {
-	return strings.TrimLeft(strings.TrimRight(path, "/"), "/")
+	return strings.Trim(filepath.ToSlash(path), "/")
 }
 
 func findFiles(fsys fs.FS, root string) ([]string, error) {
+	return findFilesDepth(fsys, root, 0)
+}
+
+// findFilesDepth is findFiles looking no more than maxDepth levels of
+// folders down, 1 being only the files in root. 0 looks everywhere.
+func findFilesDepth(fsys fs.FS, root string, maxDepth int) ([]string, error) {
 	var files []string
-	err := fs.WalkDir(fsys, pathToFsPath(root), func(path string, info fs.DirEntry, err error) error {
+	start := pathToFsPath(root)
+	err := fs.WalkDir(fsys, start, func(path string, info fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
 		if !info.IsDir() {
 			files = append(files, "/"+path)
+		} else if maxDepth > 0 && path != start && folderDepth(start, path) >= maxDepth {
+			return fs.SkipDir
 		}
 		return nil
 	})
 	return files, err
 }
 
-func (c *converter) convert(ctx context.Context, cbrFile string, cbzFile string) error {
-	c.logger.Printf("Converting: %s to %s\n", cbrFile, cbzFile)
+// folderDepth is how many levels below start dir is.
+func folderDepth(start string, dir string) int {
+	if start != "" && start != "." {
+		dir = strings.TrimPrefix(dir, start+"/")
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// convertWithScratch reserves the expected scratch space for cbrFile before
+// converting it, so a job only starts if it can fit within the budget.
+// written, if not nil, is called once the cbz is written and only its
+// verification is left, so the caller can start on the next file.
+func (c *converter) convertWithScratch(ctx context.Context, cbrFile string, cbzFile string, written func()) error {
+	release, err := c.claims.claim(ctx, cbrFile)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if c.claims != nil {
+		// another instance may have finished it since we listed the files
+		_, err = hackpadfs.Stat(c.fs, pathToFsPath(cbrFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			return errClaimed
+		}
+	}
+
+	size, err := getFileSize(c.fs, "", cbrFile)
+	if err != nil {
+		return errors.Wrap(err, "estimating scratch space")
+	}
+
+	need := estimateScratch(size)
+	err = c.scratch.acquire(ctx, need)
+	if err != nil {
+		return errors.Wrap(err, "waiting for scratch space")
+	}
+	debugf(c.logFor(cbrFile), "Reserved %s of scratch space for %s\n", formatBytes(need), cbrFile)
+	defer c.scratch.release(need)
+
+	if !c.receipts && !c.checksumming() {
+		err = c.convert(ctx, cbrFile, cbzFile, written)
+		if err == nil {
+			c.writeThumbnail(ctx, cbrFile, cbzFile)
+		}
+		return err
+	}
+	// the original may be gone once converted
+	sourceSum, err := fileSum(c.fs, pathToFsPath(cbrFile))
+	if err != nil {
+		return errors.Wrap(err, "hashing the source")
+	}
+	var sourcePages []pageChecksum
+	checksummed := c.checksumming()
+	if checksummed {
+		sourcePages, err = pageSums(ctx, c.fs, cbrFile, c.pageFilter())
+		if err != nil {
+			c.warn(cbr2cbz.CodeChecksumsFailed, cbrFile, "Unable to hash the pages of %s: %s", cbrFile, err.Error())
+			checksummed = false
+		}
+	}
+	started := time.Now()
+	err = c.convert(ctx, cbrFile, cbzFile, written)
+	if err != nil {
+		return err
+	}
+	if c.receipts {
+		if err := c.writeReceipt(cbrFile, cbzFile, sourceSum, int64(size), time.Since(started)); err != nil {
+			c.warn(cbr2cbz.CodeReceiptFailed, cbrFile, "Unable to write the receipt of %s: %s", cbzFile, err.Error())
+		}
+	}
+	if checksummed {
+		if err := c.addChecksums(ctx, cbrFile, cbzFile, sourceSum, sourcePages); err != nil {
+			c.warn(cbr2cbz.CodeChecksumsFailed, cbrFile, "Unable to hash the pages of %s: %s", cbzFile, err.Error())
+		}
+	}
+	c.writeThumbnail(ctx, cbrFile, cbzFile)
+	return nil
+}
+
+func (c *converter) convert(ctx context.Context, cbrFile string, cbzFile string, written func()) error {
+	err := c.checkOutput(cbrFile, cbzFile)
+	if err != nil {
+		return err
+	}
+	c.logFor(cbrFile).Printf("Converting: %s to %s\n", cbrFile, cbzFile)
 
 	info, err := fs.Stat(c.fs, pathToFsPath(cbrFile))
 	if err != nil {
@@ -181,104 +1241,297 @@ func (c *converter) convert(ctx context.Context, cbrFile string, cbzFile string)
 		return errors.New("is a directory")
 	}
 
+	err = c.checkSource(cbrFile)
+	if err != nil {
+		return err
+	}
+
 	file, err := c.fs.Open(pathToFsPath(cbrFile))
 	if err != nil {
 		return errors.Wrap(err, "trying to open cbr")
 	}
 	defer file.Close()
 
-	format, _, err := archiver.Identify(pathToFsPath(cbrFile), file)
+	// files picked up by --extensions are whatever their contents say, a
+	// zip named .rar included
+	identifyName := pathToFsPath(cbrFile)
+	if c.extraExtension(cbrFile) != "" {
+		identifyName = ""
+	}
+	format, _, err := archiver.Identify(identifyName, file)
 	if err != nil && !errors.Is(err, archiver.ErrNoMatch) {
 		return errors.Wrap(err, "unable to identify")
 	}
-
-	if _, ok := format.(archiver.Zip); ok {
-		// secret zip file pretending to be rar
-		hackpadfs.Rename(c.fs, pathToFsPath(cbrFile), pathToFsPath(cbzFile))
-		c.logger.Printf("Successfully Converted %s to %s...\n", cbrFile, cbzFile)
-		return nil
+	if format != nil {
+		debugf(c.logFor(cbrFile), "Identified %s as %s\n", cbrFile, format.Name())
 	}
 
-	if _, ok := format.(archiver.Rar); !ok {
-		return errors.New("not a rar file")
-	}
-
-	inputStream := io.NewSectionReader(file.(io.ReaderAt), 0, info.Size())
-	rarFS := archiver.ArchiveFS{Stream: inputStream, Format: archiver.Rar{}, Context: ctx}
+	// source is what actually gets read, cbrFile itself or the archive
+	// unwrapped from it
+	source := pathToFsPath(cbrFile)
+	if compression, ok := compressionLayer(format); ok {
+		if cbzFile == cbrFile && c.keep {
+			return errors.New("converted in place, so the original can't be kept")
+		}
+		wrapped := io.NewSectionReader(file.(io.ReaderAt), 0, info.Size())
+		source, err = c.unwrap(ctx, cbrFile, wrapped, info.Size(), compression)
+		if err != nil {
+			return err
+		}
+		defer removeIfExists(c.fs, source)
+		file.Close()
 
-	files := []archiver.File{}
+		file, err = c.fs.Open(source)
+		if err != nil {
+			return errors.Wrap(err, "trying to open unwrapped archive")
+		}
+		defer file.Close()
+		info, err = file.Stat()
+		if err != nil {
+			return errors.Wrap(err, "stating unwrapped archive")
+		}
+		// by contents only, the temp name still has the compression's extension
+		format, _, err = archiver.Identify("", file)
+		if err != nil && !errors.Is(err, archiver.ErrNoMatch) {
+			return errors.Wrap(err, "unable to identify unwrapped archive")
+		}
+	}
+	unwrapped := source != pathToFsPath(cbrFile)
 
-	err = fs.WalkDir(rarFS, ".", func(pathName string, de fs.DirEntry, err error) error {
+	// a zip pretending to be a rar is repacked like any other archive, so
+	// its entries are filtered and made safe, unless that changes nothing
+	_, isZip := format.(archiver.Zip)
+	if isZip && c.packer().ZipUnchanged(ctx, cbrFile, file.(io.ReaderAt), info.Size()) {
+		err = c.makeOutputDir(cbzFile)
 		if err != nil {
 			return err
 		}
+		// an unwrapped zip is copied too, its pages are compared with the
+		// cbz before the original goes
+		if c.keeps(cbrFile) || unwrapped {
+			err = copyFile(c.fs, source, pathToFsPath(cbzFile))
+		} else {
+			err = moveFile(c.fs, source, pathToFsPath(cbzFile))
+		}
+		if err != nil {
+			return errors.Wrap(err, "renaming zip to cbz")
+		}
+		if c.packer().Options().StripComments {
+			if err := stripZipComment(c.fs, pathToFsPath(cbzFile)); err != nil {
+				c.warn(cbr2cbz.CodeCommentDropped, cbrFile, "Unable to strip the comment of %s: %s", cbzFile, err.Error())
+			}
+		}
+		if unwrapped {
+			err = c.verifyOutput(ctx, cbrFile, cbzFile, file.(io.ReaderAt), info.Size())
+			if err != nil {
+				return err
+			}
+		}
+		c.logFor(cbrFile).Printf("Successfully Converted %s to %s...\n", cbrFile, cbzFile)
+		return nil
+	}
 
-		if de.IsDir() {
-			// nothing to do
-			return nil
+	if _, ok := cbr2cbz.SourceFormat(format); !ok && !isZip && !cbr2cbz.IsPDF(file.(io.ReaderAt)) {
+		return cbr2cbz.ErrNotArchive
+	}
+
+	src, size := file.(io.ReaderAt), info.Size()
+	if _, ok := format.(archiver.Rar); ok && !unwrapped {
+		if missing := missingVolume(c.fs, cbrFile); missing != "" {
+			return errors.Wrapf(io.ErrUnexpectedEOF, "%s of the split rar is missing", missing)
 		}
+		if volumes := rarVolumes(c.fs, cbrFile); volumes != nil {
+			if c.sandbox {
+				return errors.New("split rars can't be converted with --sandbox")
+			}
+			total, err := getFileSize(c.fs, "", volumes...)
+			if err != nil {
+				return errors.Wrap(err, "stating rar volumes")
+			}
+			size = int64(total)
+			src = &cbr2cbz.Volumes{ReaderAt: src, FS: c.fs, Name: source}
+		}
+	}
+
+	progress := &cbr2cbz.Progress{}
+	c.display.start(cbrFile, uint64(size), progress)
+	c.watchProgress(cbrFile, size, progress)
+	c.tui.start(cbrFile, uint64(size), progress)
+	if c.onStart != nil {
+		c.onStart(cbrFile, progress)
+	}
+	stopHeartbeat := c.startHeartbeat(cbrFile, progress, c.heartbeat)
+	defer stopHeartbeat()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stopStallWatch := watchForStall(progress, c.stallTimeout, cancelNoticing(ctx, cancel))
+	defer stopStallWatch()
 
-		info, err := de.Info()
+	if c.splitChapters {
+		split, err := c.convertChapters(ctx, cbrFile, cbzFile, src, size, progress)
 		if err != nil {
-			return errors.Wrap(err, "unable to look up file")
+			if errors.Is(context.Cause(ctx), errStalled) {
+				return errors.Wrapf(errStalled, "no data read or written for %s", c.stallTimeout)
+			}
+			return err
+		}
+		if split {
+			return c.removeOriginal(cbrFile, cbzFile)
 		}
-
-		files = append(files, archiver.File{
-			FileInfo:      info,
-			NameInArchive: pathName,
-			Open: func() (io.ReadCloser, error) {
-				return rarFS.Open(pathName)
-			},
-		})
-		return nil
-	})
-	if err != nil {
-		return errors.Wrap(err, "walking rar file")
 	}
 
 	// create the output file we'll write to
-	outFile, err := hackpadfs.Create(c.fs, pathToFsPath(cbzFile))
+	err = c.makeOutputDir(cbzFile)
+	if err != nil {
+		return err
+	}
+	// written under a temporary name so a crash or a full disk never leaves
+	// a truncated cbz where the finished one goes
+	partFile := tempName(pathToFsPath(cbzFile))
+	outFile, err := hackpadfs.Create(c.fs, partFile)
 	if err != nil {
 		return errors.Wrap(err, "unable to create zip")
 	}
-	defer outFile.Close()
+	defer func() { outFile.Close() }()
 
 	destFileWriter, ok := outFile.(io.Writer)
 	if !ok {
 		return errors.New("destination isn't a writable filesystem")
 	}
+	dst := countingWriter{Writer: destFileWriter, n: &progress.Written}
 
-	// create the archive
-	err = archiver.Zip{}.Archive(context.Background(), destFileWriter, files)
+	if c.sandbox {
+		err = c.repackInSandbox(ctx, source, dst)
+	} else {
+		err = c.packer().Repack(ctx, cbrFile, src, size, dst, progress)
+		if c.triesUnrar(ctx, format, err) {
+			c.warn(cbr2cbz.CodeExternalUnrar, cbrFile, "Unable to read %s, trying %s: %s", cbrFile, c.unrar, err.Error())
+			// starting over, whatever was packed before it failed goes
+			outFile.Close()
+			retry, createErr := hackpadfs.Create(c.fs, partFile)
+			if createErr != nil {
+				return errors.Wrap(createErr, "unable to create zip")
+			}
+			outFile = retry
+			progress.Written.Store(0)
+			dst = countingWriter{Writer: outFile.(io.Writer), n: &progress.Written}
+			err = c.repackWithUnrar(ctx, cbrFile, source, src, size, dst, progress)
+		}
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
 	if err != nil {
-		return errors.Wrap(err, "unable to archive zip")
+		outFile.Close()
+		if rmErr := hackpadfs.Remove(c.fs, partFile); rmErr != nil {
+			c.warn(cbr2cbz.CodePartialNotRemoved, cbrFile, "Unable to remove partial %s: %s", partFile, rmErr.Error())
+		}
+		if errors.Is(context.Cause(ctx), errStalled) {
+			return errors.Wrapf(errStalled, "no data read or written for %s", c.stallTimeout)
+		}
+		return err
+	}
+
+	err = outFile.Close()
+	if err == nil {
+		err = hackpadfs.Rename(c.fs, partFile, pathToFsPath(cbzFile))
+	}
+	if err != nil {
+		hackpadfs.Remove(c.fs, partFile)
+		return errors.Wrap(err, "finishing cbz")
+	}
+	c.copyAttributes(cbrFile, cbzFile)
+	stopStallWatch()
+	stopHeartbeat()
+	if written != nil {
+		written()
 	}
 
-	err = hackpadfs.Remove(c.fs, pathToFsPath(cbrFile))
+	err = c.verifyOutput(ctx, cbrFile, cbzFile, src, size)
 	if err != nil {
-		return errors.Wrap(err, "deleting old cbr")
+		return err
 	}
 
-	c.logger.Printf("Successfully Converted %s to %s...\n", cbrFile, cbzFile)
+	c.logFor(cbrFile).Printf("Successfully Converted %s to %s...\n", cbrFile, cbzFile)
+	opts := c.packer().Options()
+	verbosef(c.logFor(cbrFile), "Converted %s with options %s %s\n", cbzFile, opts.Fingerprint(), opts)
 
 	return nil
 }
 
+// verifyOutput reads cbzFile back and, if every entry checks out and it
+// holds the pages src, the original's contents, does, deletes the
+// original. A cbz that doesn't is left for a look, next to the original.
+func (c *converter) verifyOutput(ctx context.Context, cbrFile string, cbzFile string, src io.ReaderAt, size int64) error {
+	// every volume of a split rar goes, so its cbz is always checked first
+	if c.verify || rarVolumes(c.fs, cbrFile) != nil {
+		err := verifyZip(c.fs, pathToFsPath(cbzFile))
+		if err == nil && src != nil {
+			err = c.checkPages(ctx, cbrFile, cbzFile, src, size)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "verifying %s, keeping the original", cbzFile)
+		}
+	}
+	return c.removeOriginal(cbrFile, cbzFile)
+}
+
+// removeOriginal disposes of cbrFile after it was converted, unless it is
+// being kept or the conversion replaced it in place. An original that turns
+// out not to be removable is kept when the cbz went to outputDir, the work
+// is done by then.
+func (c *converter) removeOriginal(cbrFile string, cbzFile string) error {
+	if c.keeps(cbrFile) || cbrFile == cbzFile {
+		return nil
+	}
+	volumes := rarVolumes(c.fs, cbrFile)
+	if volumes == nil {
+		volumes = []string{cbrFile}
+	}
+	for _, volume := range volumes {
+		err := c.disposeOriginal(volume, cbzFile)
+		if err != nil && c.outputDir != "" && isReadOnlyError(err) {
+			c.warn(cbr2cbz.CodeSourceReadOnly, volume, "Unable to remove %s, keeping it: %s", volume, err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *converter) printStats(startTime time.Time, failedFiles map[string]error) {
-	runtime := humanize.RelTime(startTime, time.Now(), "", "")
+	runtime := formatDuration(time.Since(startTime))
 	c.logger.Println("Failed files:")
 
 	for filename, err := range failedFiles {
-		c.logger.Printf("\t%s\t%s", filename, err.Error())
+		c.logger.Printf("\t%s\t[%s] %s", filename, errorCode(err), err.Error())
 	}
 
 	if len(failedFiles) == 0 {
 		c.logger.Println("  none")
 	}
 
+	if c.bytesIn > 0 {
+		saved := c.bytesIn - c.bytesOut
+		if saved >= 0 {
+			c.logger.Printf("Space saved: %s (%.1f%%)\n", formatBytes(uint64(saved)), 100*float64(saved)/float64(c.bytesIn))
+		} else {
+			c.logger.Printf("Space gained: %s (%.1f%%)\n", formatBytes(uint64(-saved)), 100*float64(-saved)/float64(c.bytesIn))
+		}
+	}
+	if grown := grownFiles(c.results, grownFilesShown); len(grown) > 0 {
+		c.logger.Println("Files that grew the most:")
+		for _, g := range grown {
+			c.logger.Printf("\t%s\t%s to %s (+%s)\n", g.Source, formatBytes(uint64(g.BytesIn)), formatBytes(uint64(g.BytesOut)), formatBytes(uint64(g.Growth)))
+		}
+	}
+
 	c.logger.Println("Runtime:", runtime)
 
-	c.logger.Printf("A log file has been written to %s\n", logFileName)
+	c.logger.Printf("A log file has been written to %s\n", openedLogFile)
 }
 
 func getFileSize(fsys hackpadfs.FS, ext string, paths ...string) (uint64, error) {
@@ -298,3 +1551,78 @@ func getFileSize(fsys hackpadfs.FS, ext string, paths ...string) (uint64, error)
 	}
 	return size, nil
 }
+
+// makeOutputDir creates the directory cbzFile goes in when writing to
+// outputDir or into a --rename directory, next to the cbr it already exists.
+func (c *converter) makeOutputDir(cbzFile string) error {
+	if c.outputDir == "" && c.rename == nil {
+		return nil
+	}
+	err := hackpadfs.MkdirAll(c.fs, pathToFsPath(filepath.Dir(cbzFile)), 0755)
+	return errors.Wrap(err, "creating output directory")
+}
+
+// moveFile renames src to dst, copying it over when they are on different
+// devices. src is only removed once the copy is closed without error and
+// has its size; a copy that doesn't is removed instead.
+func moveFile(fsys hackpadfs.FS, src string, dst string) error {
+	err := hackpadfs.Rename(fsys, src, dst)
+	if err == nil {
+		return nil
+	}
+	if copyErr := copyFile(fsys, src, dst); copyErr != nil {
+		return errors.Wrapf(copyErr, "copying %s to %s after renaming failed (%s)", src, dst, err.Error())
+	}
+	in, err := hackpadfs.Stat(fsys, src)
+	if err != nil {
+		return errors.Wrap(err, "checking copy")
+	}
+	out, err := hackpadfs.Stat(fsys, dst)
+	if err == nil && out.Size() != in.Size() {
+		err = errors.Errorf("copy is %d bytes, not %d", out.Size(), in.Size())
+	}
+	if err != nil {
+		hackpadfs.Remove(fsys, dst)
+		return errors.Wrapf(err, "moving %s to %s", src, dst)
+	}
+	return hackpadfs.Remove(fsys, src)
+}
+
+// copyFile copies src to dst within fsys, replacing dst if it exists.
+// Filesystems that can copy without reading the data through us, like S3
+// buckets, do so. Otherwise the copy is written under a temporary name and
+// renamed into place, so a copy that fails leaves dst as it was.
+func copyFile(fsys hackpadfs.FS, src string, dst string) error {
+	if copier, ok := fsys.(interface{ Copy(src, dst string) error }); ok {
+		return copier.Copy(src, dst)
+	}
+
+	in, err := fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := tempName(dst)
+	out, err := hackpadfs.Create(fsys, tmp)
+	if err != nil {
+		return err
+	}
+	w, ok := out.(io.Writer)
+	if ok {
+		_, err = io.Copy(w, in)
+	} else {
+		err = errors.New("destination isn't a writable filesystem")
+	}
+	// a failed write may only show up on close, on NFS or sftp
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = hackpadfs.Rename(fsys, tmp, dst)
+	}
+	if err != nil {
+		hackpadfs.Remove(fsys, tmp)
+	}
+	return err
+}
@@ -1,16 +1,25 @@
 package cmd
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hack-pad/hackpadfs"
 	memfs "github.com/hack-pad/hackpadfs/mem"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/mholt/archiver/v4"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -19,6 +28,7 @@ import (
 var (
 	realCBRContents    []byte
 	notrealCBRContents []byte
+	realCBTContents    []byte
 )
 
 type filenameBytes map[string][]byte
@@ -33,6 +43,10 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	realCBTContents, err = os.ReadFile(absPathJoin("..", "fixtures", "test.cbt"))
+	if err != nil {
+		panic(err)
+	}
 }
 
 func setupFS(t *testing.T, fixtures filenameBytes) (hackpadfs.FS, error) {
@@ -85,11 +99,13 @@ func Test_runConvert(t *testing.T) {
 		cbrFiles []string
 	}
 	tests := []struct {
-		name     string
-		args     args
-		fileList []string
-		fixtures filenameBytes
-		wantErr  bool
+		name      string
+		args      args
+		fileList  []string
+		fixtures  filenameBytes
+		keep      bool
+		outputDir string
+		wantErr   bool
 	}{
 		{
 			name: "legit cbr",
@@ -109,6 +125,68 @@ func Test_runConvert(t *testing.T) {
 			fileList: []string{"is-zip.cbz"},
 			wantErr:  false,
 		},
+		{
+			name: "legit cbt",
+			args: args{cbrFiles: []string{"test.cbt"}},
+			fixtures: filenameBytes{
+				"test.cbt": realCBTContents,
+			},
+			fileList: []string{"test.cbz"},
+			wantErr:  false,
+		},
+		{
+			name: "recursive mixed formats",
+			args: args{cbrFiles: []string{"/library"}},
+			fixtures: filenameBytes{
+				"library/test.cbr":  realCBRContents,
+				"library/test1.cbt": realCBTContents,
+				"library/notes.txt": []byte("not a comic"),
+			},
+			fileList: []string{"library/notes.txt", "library/test.cbz", "library/test1.cbz"},
+			wantErr:  false,
+		},
+		{
+			name: "keep legit cbr",
+			args: args{cbrFiles: []string{"test.cbr"}},
+			fixtures: filenameBytes{
+				"test.cbr": realCBRContents,
+			},
+			keep:     true,
+			fileList: []string{"test.cbr", "test.cbz"},
+			wantErr:  false,
+		},
+		{
+			name: "keep is actually cbz",
+			args: args{cbrFiles: []string{"is-zip.cbr"}},
+			fixtures: filenameBytes{
+				"is-zip.cbr": notrealCBRContents,
+			},
+			keep:     true,
+			fileList: []string{"is-zip.cbr", "is-zip.cbz"},
+			wantErr:  false,
+		},
+		{
+			name: "output dir mirrors tree",
+			args: args{cbrFiles: []string{"/library"}},
+			fixtures: filenameBytes{
+				"library/a/test.cbr":    realCBRContents,
+				"library/b/c/test1.cbr": notrealCBRContents,
+			},
+			outputDir: "/converted",
+			fileList:  []string{"converted/a/test.cbz", "converted/b/c/test1.cbz"},
+			wantErr:   false,
+		},
+		{
+			name: "output dir single file",
+			args: args{cbrFiles: []string{"/library/a/test.cbr"}},
+			fixtures: filenameBytes{
+				"library/a/test.cbr": realCBRContents,
+			},
+			outputDir: "/converted",
+			keep:      true,
+			fileList:  []string{"library/a/test.cbr", "converted/test.cbz"},
+			wantErr:   false,
+		},
 		{
 			name: "recursive",
 			args: args{cbrFiles: []string{"."}},
@@ -145,8 +223,10 @@ func Test_runConvert(t *testing.T) {
 			require.NoError(t, err)
 
 			c := &converter{
-				fs:     fsys,
-				logger: testLogger{t},
+				fs:        fsys,
+				logger:    testLogger{t},
+				keep:      tt.keep,
+				outputDir: tt.outputDir,
 			}
 
 			err = c.runConvert(context.Background(), tt.args.cbrFiles)
@@ -258,3 +338,566 @@ func Test_findFilesAndSize(t *testing.T) {
 		})
 	}
 }
+
+func Test_findFilesAndSize_maxDepth(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"incoming/new.cbr":                  realCBRContents,
+		"incoming/Saga/Saga 001.cbr":        realCBRContents,
+		"incoming/Saga/Extras/Sketches.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+
+	for depth, want := range map[int][]string{
+		0: {"/incoming/new.cbr", "/incoming/Saga/Saga 001.cbr", "/incoming/Saga/Extras/Sketches.cbr"},
+		1: {"/incoming/new.cbr"},
+		2: {"/incoming/new.cbr", "/incoming/Saga/Saga 001.cbr"},
+	} {
+		c := &converter{fs: fsys, logger: testLogger{t}, maxDepth: depth}
+		require.NoError(t, c.findFilesAndSize(context.Background(), []string{"/incoming"}))
+		require.ElementsMatch(t, want, c.cbrFiles, "--max-depth %d", depth)
+	}
+}
+
+func Test_convertExtensions(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"incoming/Saga 001.rar":     realCBRContents,
+		"incoming/Saga 002.CBR.bak": realCBRContents,
+		"incoming/Saga 003.rar":     makeZip(t, map[string]string{"001.jpg": "page"}),
+		"incoming/notes.bak":        []byte("not a comic"),
+	})
+	require.NoError(t, err)
+
+	extensions, err := parseExtensions([]string{"rar", ".cbr.bak"})
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}, extensions: extensions}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/incoming"}))
+
+	files, err := findFiles(fsys, "/incoming")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"/incoming/Saga 001.cbz", "/incoming/Saga 002.cbz", "/incoming/Saga 003.cbz", "/incoming/notes.bak"}, files)
+
+	_, err = parseExtensions([]string{"cbz"})
+	require.Error(t, err)
+}
+
+func wrapIn(t *testing.T, compression archiver.Compressor, data []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w, err := compression.OpenWriter(buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Test_convertWrapped(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/gz.cbr":      wrapIn(t, archiver.Gz{}, realCBRContents),
+		"library/xz.cbt":      wrapIn(t, archiver.Xz{}, realCBTContents),
+		"library/wrapped.cbz": wrapIn(t, archiver.Gz{}, notrealCBRContents),
+		"library/plain.cbz":   notrealCBRContents,
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.ElementsMatch(t, []string{"/library/gz.cbr", "/library/xz.cbt", "/library/wrapped.cbz"}, c.cbrFiles)
+
+	entries, err := hackpadfs.ReadDir(fsys, "library")
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch(t, []string{"gz.cbz", "xz.cbz", "wrapped.cbz", "plain.cbz"}, names)
+
+	for _, name := range names {
+		_, f, err := openZip(fsys, "library/"+name)
+		require.NoError(t, err, name)
+		f.Close()
+	}
+
+	c = &converter{fs: fsys, logger: testLogger{t}, keep: true}
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "library/wrapped.cbz", wrapIn(t, archiver.Gz{}, notrealCBRContents), 0o644))
+	c.runConvert(context.Background(), []string{"/library/wrapped.cbz"})
+	require.Contains(t, c.failed, "/library/wrapped.cbz")
+}
+
+func Test_convertWrapped_verify(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/bad.cbr": wrapIn(t, archiver.Gz{}, makeCorruptZip(t)),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, verify: true}
+	c.runConvert(context.Background(), []string{"/library"})
+	require.Contains(t, c.failed, "/library/bad.cbr")
+	_, err = hackpadfs.Stat(fsys, "library/bad.cbr")
+	require.NoError(t, err, "the original is kept")
+}
+
+func Test_convertZipInDisguise(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga.cbr": makeZip(t, map[string]string{"../001.jpg": "page", "notes.txt": "notes"}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	r, file, err := openZip(fsys, "library/Saga.cbz")
+	require.NoError(t, err)
+	defer file.Close()
+	names := []string{}
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"001.jpg"}, names, "repacked like any other archive")
+}
+
+func Test_convertZipInDisguise_unchanged(t *testing.T) {
+	packed := makeZip(t, map[string]string{"001.jpg": "page"})
+	// what convert passes along when no option is given
+	defaults := cbr2cbz.Options{
+		ImageExtensions: cbr2cbz.DefaultImageExtensions,
+		KeepFiles:       cbr2cbz.DefaultKeepFiles,
+		PageOrder:       cbr2cbz.DefaultPageOrder,
+		Compression:     "default",
+		EntryEncoding:   "auto",
+		Images:          &cbr2cbz.ImageOptions{},
+	}
+	for name, tc := range map[string]struct {
+		opts    func(cbr2cbz.Options) cbr2cbz.Options
+		renamed bool
+	}{
+		"defaults": {func(o cbr2cbz.Options) cbr2cbz.Options { return o }, true},
+		"compression": {func(o cbr2cbz.Options) cbr2cbz.Options {
+			o.Compression = "store"
+			return o
+		}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys, err := setupFS(t, filenameBytes{"library/Saga.cbr": packed})
+			require.NoError(t, err)
+			c := &converter{fs: fsys, logger: testLogger{t}}
+			require.NoError(t, c.setOptions(tc.opts(defaults), cbr2cbz.Limits{}))
+			require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+			data, err := hackpadfs.ReadFile(fsys, "library/Saga.cbz")
+			require.NoError(t, err)
+			require.Equal(t, tc.renamed, bytes.Equal(packed, data))
+		})
+	}
+}
+
+func makeTar(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	for name, contents := range entries {
+		require.NoError(t, w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}))
+		_, err := w.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Test_convertChapters(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Webtoon.cbt": makeTar(t, map[string]string{
+			"Webtoon/Chapter 1/001.jpg": "one",
+			"Webtoon/Chapter 1/002.jpg": "two",
+			"Webtoon/Chapter 2/001.jpg": "three",
+			"Webtoon/ComicInfo.xml":     "<ComicInfo/>",
+		}),
+		"library/test.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, splitChapters: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	entries, err := hackpadfs.ReadDir(fsys, "library/Webtoon")
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"Chapter 1.cbz", "Chapter 2.cbz"}, names)
+
+	zr, f, err := openZip(fsys, "library/Webtoon/Chapter 1.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	require.Len(t, zr.File, 2)
+	require.Equal(t, "001.jpg", zr.File[0].Name)
+
+	_, err = hackpadfs.Stat(fsys, "library/Webtoon.cbt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	// no chapter folders, so a plain cbz
+	_, err = hackpadfs.Stat(fsys, "library/test.cbz")
+	require.NoError(t, err)
+}
+
+func Test_convertChapters_nested(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Omnibus.cbt": makeTar(t, map[string]string{
+			"Chapter 1.cbz": string(makeZip(t, map[string]string{"001.jpg": "one", "002.jpg": "two"})),
+			"Chapter 2.zip": string(makeZip(t, map[string]string{"001.jpg": "three"})),
+		}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, splitChapters: true}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{RecurseArchives: true}, cbr2cbz.Limits{}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+
+	entries, err := hackpadfs.ReadDir(fsys, "library/Omnibus")
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"Chapter 1.cbz", "Chapter 2.cbz"}, names)
+
+	zr, f, err := openZip(fsys, "library/Omnibus/Chapter 1.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	require.Len(t, zr.File, 2)
+}
+
+func Test_convertVerify(t *testing.T) {
+	t.Run("overlaps the next file", func(t *testing.T) {
+		fsys, err := setupFS(t, filenameBytes{
+			"library/a.cbr": realCBRContents,
+			"library/b.cbr": realCBRContents,
+			"library/c.cbt": realCBTContents,
+		})
+		require.NoError(t, err)
+
+		c := &converter{fs: fsys, logger: testLogger{t}, jobs: 1, verify: true}
+		require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+		require.Empty(t, c.failed)
+		require.Len(t, c.converted, 3)
+		for _, name := range []string{"a", "b", "c"} {
+			_, err = hackpadfs.Stat(fsys, "library/"+name+".cbz")
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("corrupt output keeps the original", func(t *testing.T) {
+		fsys, err := setupFS(t, filenameBytes{
+			"library/test.cbr": realCBRContents,
+			"library/test.cbz": makeCorruptZip(t),
+		})
+		require.NoError(t, err)
+
+		c := &converter{fs: fsys, logger: testLogger{t}, verify: true}
+		err = c.verifyOutput(context.Background(), "/library/test.cbr", "/library/test.cbz", nil, 0)
+		require.ErrorContains(t, err, "keeping the original")
+		_, err = hackpadfs.Stat(fsys, "library/test.cbr")
+		require.NoError(t, err)
+	})
+
+	t.Run("missing pages keep the original", func(t *testing.T) {
+		source := makeTar(t, map[string]string{"001.jpg": "page one", "002.jpg": "page two", "003.jpg": "page three"})
+		for name, tc := range map[string]struct {
+			cbz     map[string]string
+			wantErr string
+		}{
+			"same pages":      {cbz: map[string]string{"p001.jpg": "page one", "p002.jpg": "page two", "p003.jpg": "page three", "ComicInfo.xml": "<ComicInfo/>"}},
+			"a page missing":  {cbz: map[string]string{"001.jpg": "page one", "002.jpg": "page two"}, wantErr: "2 pages instead of 3"},
+			"a page too many": {cbz: map[string]string{"001.jpg": "page one", "002.jpg": "page two", "003.jpg": "page three", "004.jpg": "page one"}, wantErr: "4 pages instead of 3"},
+			"a page changed":  {cbz: map[string]string{"001.jpg": "page one", "002.jpg": "page two", "003.jpg": "page 3"}, wantErr: "003.jpg is 6 B"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				fsys, err := setupFS(t, filenameBytes{
+					"library/test.cbt": source,
+					"library/test.cbz": makeZip(t, tc.cbz),
+				})
+				require.NoError(t, err)
+
+				c := &converter{fs: fsys, logger: testLogger{t}, verify: true}
+				err = c.verifyOutput(context.Background(), "/library/test.cbt", "/library/test.cbz", bytes.NewReader(source), int64(len(source)))
+				_, statErr := hackpadfs.Stat(fsys, "library/test.cbt")
+				if tc.wantErr == "" {
+					require.NoError(t, err)
+					require.ErrorIs(t, statErr, hackpadfs.ErrNotExist)
+					return
+				}
+				require.ErrorIs(t, err, errPagesMismatch)
+				require.ErrorContains(t, err, tc.wantErr)
+				require.ErrorContains(t, err, "keeping the original")
+				require.Equal(t, cbr2cbz.CodePagesMismatch, errorCode(err))
+				require.NoError(t, statErr)
+			})
+		}
+	})
+}
+
+func Test_convertAttributes(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/test.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+	added := time.Date(2019, 6, 1, 20, 30, 0, 0, time.UTC)
+	require.NoError(t, hackpadfs.Chmod(fsys, "library/test.cbr", 0640))
+	require.NoError(t, hackpadfs.Chtimes(fsys, "library/test.cbr", added, added))
+
+	c := &converter{fs: fsys, logger: testLogger{t}, preserveAttrs: true, keep: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	info, err := hackpadfs.Stat(fsys, "library/test.cbz")
+	require.NoError(t, err)
+	require.True(t, added.Equal(info.ModTime()), info.ModTime())
+	require.Equal(t, hackpadfs.FileMode(0640), info.Mode().Perm())
+
+	r, _, err := openZip(fsys, "library/test.cbz")
+	require.NoError(t, err)
+	for _, f := range r.File {
+		require.True(t, f.Modified.Before(added), "%s keeps its time in the rar, %s", f.Name, f.Modified)
+	}
+}
+
+func Test_convertAtomic(t *testing.T) {
+	partFiles := func(t *testing.T, fsys hackpadfs.FS) []string {
+		t.Helper()
+		parts, err := fs.Glob(fsys, "library/.*.part")
+		require.NoError(t, err)
+		return parts
+	}
+
+	t.Run("finished", func(t *testing.T) {
+		fsys, err := setupFS(t, filenameBytes{"library/test.cbr": realCBRContents})
+		require.NoError(t, err)
+
+		c := &converter{fs: fsys, logger: testLogger{t}, verify: true}
+		require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+		require.NoError(t, verifyZip(fsys, "library/test.cbz"))
+		require.Empty(t, partFiles(t, fsys))
+	})
+
+	t.Run("failed part way", func(t *testing.T) {
+		fsys, err := setupFS(t, filenameBytes{
+			"library/test.cbr": realCBRContents,
+			"library/test.cbz": []byte("from before"),
+		})
+		require.NoError(t, err)
+
+		c := &converter{fs: fsys, logger: testLogger{t}}
+		require.NoError(t, c.setOptions(cbr2cbz.Options{KeepFiles: []string{"*.txt"}}, cbr2cbz.Limits{MaxEntry: 1}))
+		require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+		require.Len(t, c.failed, 1)
+
+		// neither original is touched and nothing half written is left
+		data, err := fs.ReadFile(fsys, "library/test.cbz")
+		require.NoError(t, err)
+		require.Equal(t, "from before", string(data))
+		_, err = hackpadfs.Stat(fsys, "library/test.cbr")
+		require.NoError(t, err)
+		require.Empty(t, partFiles(t, fsys))
+	})
+}
+
+func Test_convertStripJunk(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/test.cbt": makeTar(t, map[string]string{
+			"test/001.jpg":          "one",
+			"test/002.jpg":          "",
+			"test/Thumbs.db":        "thumbs",
+			"__MACOSX/test/._1.jpg": "fork",
+		}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{StripJunk: true}, cbr2cbz.Limits{}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	zr, f, err := openZip(fsys, "library/test.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	require.Len(t, zr.File, 1)
+	require.Equal(t, "test/001.jpg", zr.File[0].Name)
+}
+
+func Test_convertStripWrappers(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga.cbt": makeTar(t, map[string]string{
+			"Saga/Saga/001.jpg": "one",
+			"Saga/Saga/002.jpg": "two",
+		}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{StripWrappers: true}, cbr2cbz.Limits{}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	zr, f, err := openZip(fsys, "library/Saga.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	require.Len(t, zr.File, 2)
+	require.Equal(t, "001.jpg", zr.File[0].Name)
+	require.Equal(t, "002.jpg", zr.File[1].Name)
+}
+
+func Test_convertStripComments(t *testing.T) {
+	commented := &bytes.Buffer{}
+	zw := zip.NewWriter(commented)
+	require.NoError(t, zw.SetComment("Scanned by the TestGroup"))
+	w, err := zw.Create("test/001.jpg")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("one"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	for _, strip := range []bool{false, true} {
+		t.Run(strconv.FormatBool(strip), func(t *testing.T) {
+			fsys, err := setupFS(t, filenameBytes{"library/test.cbr": commented.Bytes()})
+			require.NoError(t, err)
+
+			c := &converter{fs: fsys, logger: testLogger{t}}
+			require.NoError(t, c.setOptions(cbr2cbz.Options{StripComments: strip}, cbr2cbz.Limits{}))
+			require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+			zr, f, err := openZip(fsys, "library/test.cbz")
+			require.NoError(t, err)
+			defer f.Close()
+			require.Len(t, zr.File, 1)
+			if strip {
+				require.Empty(t, zr.Comment)
+			} else {
+				require.Equal(t, "Scanned by the TestGroup", zr.Comment)
+			}
+		})
+	}
+}
+
+func Test_copyFile(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"lib/a.cbz":   []byte("new"),
+		"other/a.cbz": []byte("old"),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, copyFile(fsys, "lib/a.cbz", "other/a.cbz"))
+	data, err := hackpadfs.ReadFile(fsys, "other/a.cbz")
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+	entries, err := hackpadfs.ReadDir(fsys, "other")
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file is left behind")
+
+	require.Error(t, copyFile(fsys, "lib/missing.cbz", "other/a.cbz"))
+	data, err = hackpadfs.ReadFile(fsys, "other/a.cbz")
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+}
+
+// crossDeviceFS fails renames between directories, like between devices.
+type crossDeviceFS struct {
+	*memfs.FS
+}
+
+func (f crossDeviceFS) Rename(oldname, newname string) error {
+	if filepath.Dir(oldname) != filepath.Dir(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("invalid cross-device link")}
+	}
+	return f.FS.Rename(oldname, newname)
+}
+
+func Test_moveFile_crossDevice(t *testing.T) {
+	mem, err := memfs.NewFS()
+	require.NoError(t, err)
+	fsys := crossDeviceFS{mem}
+	require.NoError(t, hackpadfs.MkdirAll(fsys, "lib", 0o755))
+	require.NoError(t, hackpadfs.MkdirAll(fsys, "trash", 0o755))
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "lib/a.cbr", []byte("original"), 0o644))
+
+	require.NoError(t, moveFile(fsys, "lib/a.cbr", "trash/a.cbr"))
+	data, err := hackpadfs.ReadFile(fsys, "trash/a.cbr")
+	require.NoError(t, err)
+	require.Equal(t, "original", string(data))
+	_, err = hackpadfs.Stat(fsys, "lib/a.cbr")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// nowhere to copy it to, so it stays
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "lib/b.cbr", []byte("original"), 0o644))
+	err = moveFile(fsys, "lib/b.cbr", "missing/b.cbr")
+	require.ErrorIs(t, err, fs.ErrNotExist, "the copy's error, not the rename's")
+	_, err = hackpadfs.Stat(fsys, "lib/b.cbr")
+	require.NoError(t, err)
+}
+
+// hangingFS hands out files under hang/ whose reads block, ignoring any
+// cancellation, once the first few bytes are read, like a dead network
+// mount.
+type hangingFS struct {
+	*memfs.FS
+	release chan struct{}
+}
+
+func (f hangingFS) Open(name string) (fs.File, error) {
+	file, err := f.FS.Open(name)
+	if err != nil || !strings.HasPrefix(name, "hang/") {
+		return file, err
+	}
+	return &hangingFile{File: file, release: f.release}, nil
+}
+
+type hangingFile struct {
+	fs.File
+	release chan struct{}
+	read    int
+}
+
+func (f *hangingFile) Read(p []byte) (int, error) {
+	if f.read >= 512 {
+		<-f.release
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > 512-f.read {
+		p = p[:512-f.read]
+	}
+	n, err := f.File.Read(p)
+	f.read += n
+	return n, err
+}
+
+func (f *hangingFile) ReadAt(p []byte, off int64) (int, error) {
+	<-f.release
+	return 0, io.ErrUnexpectedEOF
+}
+
+func (f *hangingFile) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+func Test_runConvert_stuckRead(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"hang/stuck.cbr": realCBRContents,
+		"library/ok.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	c := &converter{fs: hangingFS{fsys.(*memfs.FS), release}, logger: log.New(io.Discard, "", 0), stallTimeout: 50 * time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		c.runConvert(context.Background(), []string{"/hang", "/library"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the batch waited on the stuck read")
+	}
+
+	require.ErrorIs(t, c.failed["/hang/stuck.cbr"], errStalled)
+	_, err = hackpadfs.Stat(fsys, "library/ok.cbz")
+	require.NoError(t, err, "the rest of the batch still converts")
+}
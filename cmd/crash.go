@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+var crashDir string
+
+// errCrashed is what a file that crashed the converter fails with.
+var errCrashed = errors.New("reading the archive crashed")
+
+const (
+	// crashHeaderSize is how much of the start of a file a crash report
+	// keeps, the archive's own headers and not much of the first page.
+	crashHeaderSize = 512
+	// crashMaxEntries caps the entry list of a crash report.
+	crashMaxEntries = 10000
+)
+
+// crashError is errCrashed with what the panic was and the report saved
+// for it.
+type crashError struct {
+	value any
+	id    string
+}
+
+func (e *crashError) Error() string {
+	msg := fmt.Sprintf("%s (%v)", errCrashed, e.value)
+	if e.id != "" {
+		msg += fmt.Sprintf(", cbr2cbz report-crash %s shares the report", e.id)
+	}
+	return msg
+}
+
+func (e *crashError) Is(target error) bool {
+	return target == errCrashed
+}
+
+// crashReport is what is saved when reading a file panics: where it
+// crashed, and the file's headers without any page contents. The file is
+// only known by its extension, size and checksum.
+type crashReport struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Version   string    `json:"version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	Panic     string    `json:"panic"`
+	Stack     string    `json:"stack"`
+	Extension string    `json:"extension"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Format    string    `json:"format,omitempty"`
+	// Header is the first crashHeaderSize bytes of the file
+	Header  []byte       `json:"header"`
+	Entries []crashEntry `json:"entries,omitempty"`
+	// EntriesError is why the entry list stops where it does, if it does
+	EntriesError string `json:"entries_error,omitempty"`
+}
+
+// crashEntry is the header of an entry in the archive.
+type crashEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func defaultCrashDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "crashes")
+}
+
+// convertSafely is convertWithScratch, failing cbrFile instead of the whole
+// batch if reading it panics. A report for reproducing the crash is saved
+// to crashDir.
+func (c *converter) convertSafely(ctx context.Context, cbrFile string, cbzFile string, written func()) (err error) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		stack := debug.Stack()
+		crashed := &crashError{value: value}
+		err = crashed
+		if c.crashDir == "" {
+			return
+		}
+		report, saveErr := c.saveCrash(cbrFile, value, stack)
+		if saveErr != nil {
+			c.logFor(cbrFile).Printf("Unable to save a crash report for %s: %s\n", cbrFile, saveErr.Error())
+			return
+		}
+		crashed.id = report.ID
+	}()
+	return c.convertWithScratch(ctx, cbrFile, cbzFile, written)
+}
+
+// saveCrash writes the crash report for reading cbrFile panicking with
+// value.
+func (c *converter) saveCrash(cbrFile string, value any, stack []byte) (*crashReport, error) {
+	report := &crashReport{
+		Time:      time.Now().UTC(),
+		Version:   buildVersion,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Panic:     fmt.Sprint(value),
+		Stack:     string(stack),
+		Extension: strings.ToLower(filepath.Ext(cbrFile)),
+	}
+
+	file, err := c.fs.Open(pathToFsPath(cbrFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening the file")
+	}
+	defer file.Close()
+	hash := sha256.New()
+	report.Size, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, errors.Wrap(err, "hashing the file")
+	}
+	report.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	report.ID = report.Time.Format("20060102-150405") + "-" + report.SHA256[:8]
+
+	reader, ok := file.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("the file can't be read at random")
+	}
+	header := make([]byte, crashHeaderSize)
+	n, err := reader.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "reading the header")
+	}
+	report.Header = header[:n]
+	report.listEntries(io.NewSectionReader(reader, 0, report.Size))
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding the crash report")
+	}
+	err = os.MkdirAll(c.crashDir, 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the crash directory")
+	}
+	err = os.WriteFile(filepath.Join(c.crashDir, report.ID+".json"), data, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing the crash report")
+	}
+	return report, nil
+}
+
+// listEntries fills in the format and entry headers of the archive in src,
+// as far as they can be read without crashing again.
+func (r *crashReport) listEntries(src *io.SectionReader) {
+	defer func() {
+		if value := recover(); value != nil {
+			r.EntriesError = fmt.Sprintf("crashed again: %v", value)
+		}
+	}()
+
+	format, _, err := archiver.Identify("", src)
+	if err != nil {
+		r.EntriesError = err.Error()
+		return
+	}
+	r.Format = strings.TrimPrefix(format.Name(), ".")
+	extractor, ok := format.(archiver.Extractor)
+	if !ok {
+		return
+	}
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		r.EntriesError = err.Error()
+		return
+	}
+	errEnough := errors.New("listed enough entries")
+	err = extractor.Extract(context.Background(), src, nil, func(_ context.Context, f archiver.File) error {
+		if len(r.Entries) == crashMaxEntries {
+			return errEnough
+		}
+		r.Entries = append(r.Entries, crashEntry{Name: f.NameInArchive, Size: f.Size(), Mode: f.Mode().String(), ModTime: f.ModTime()})
+		return nil
+	})
+	if err != nil {
+		r.EntriesError = err.Error()
+	}
+}
+
+// readCrashReport loads the report with id from dir.
+func readCrashReport(dir string, id string) (*crashReport, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, errors.Errorf("%q isn't a crash report id", id)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.Errorf("no crash report %s in %s", id, dir)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the crash report")
+	}
+	report := &crashReport{}
+	err = json.Unmarshal(data, report)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing crash report %s", id)
+	}
+	return report, nil
+}
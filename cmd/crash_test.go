@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/hack-pad/hackpadfs/mem"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// crashFS panics the first time the file named crash is opened, like a
+// parser tripping over a hostile archive.
+type crashFS struct {
+	*mem.FS
+	crash   string
+	crashed bool
+}
+
+func (c *crashFS) Open(name string) (hackpadfs.File, error) {
+	if name == c.crash && !c.crashed {
+		c.crashed = true
+		var entries []string
+		_ = entries[len(name)]
+	}
+	return c.FS.Open(name)
+}
+
+func Test_convertSafely(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr":       realCBRContents,
+		"library/hostile.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	c := &converter{fs: &crashFS{FS: fsys.(*mem.FS), crash: "library/hostile.cbr"}, logger: testLogger{t}, crashDir: dir}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	// the rest of the batch went on
+	require.Equal(t, []string{"/library/a.cbz"}, c.converted)
+	err = c.failed["/library/hostile.cbr"]
+	require.ErrorIs(t, err, errCrashed)
+	require.Equal(t, cbr2cbz.CodeCrashed, errorCode(err))
+
+	crashed := &crashError{}
+	require.True(t, errors.As(err, &crashed))
+	require.Contains(t, err.Error(), "cbr2cbz report-crash "+crashed.id)
+	report, err := readCrashReport(dir, crashed.id)
+	require.NoError(t, err)
+	require.Equal(t, ".cbr", report.Extension)
+	require.Equal(t, int64(len(realCBRContents)), report.Size)
+	require.Equal(t, realCBRContents[:len(report.Header)], report.Header)
+	require.Equal(t, "rar", report.Format)
+	require.NotEmpty(t, report.Entries)
+	require.Contains(t, report.Panic, "index out of range")
+	require.Contains(t, report.Stack, "crashFS")
+
+	out := &bytes.Buffer{}
+	require.NoError(t, listCrashReports(out, dir))
+	require.Contains(t, out.String(), crashed.id)
+
+	out.Reset()
+	require.NoError(t, shareCrashReport(out, dir, crashed.id))
+	require.Contains(t, out.String(), filepath.Join(dir, crashed.id+".json"))
+	var link *url.URL
+	for _, field := range strings.Fields(out.String()) {
+		if strings.HasPrefix(field, issuesURL) {
+			link, err = url.Parse(field)
+			require.NoError(t, err)
+		}
+	}
+	require.NotNil(t, link)
+	require.NoError(t, err)
+	require.Contains(t, link.Query().Get("title"), "Crash reading a .cbr file: runtime error")
+	require.Contains(t, link.Query().Get("body"), report.SHA256)
+	require.NotContains(t, out.String(), "hostile")
+
+	_, err = readCrashReport(dir, "../secrets")
+	require.ErrorContains(t, err, "isn't a crash report id")
+}
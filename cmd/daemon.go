@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonSchedule   string
+	daemonLockDir    string
+	daemonRunNow     bool
+	daemonHealthAddr string
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [dir...]",
+	Short: "Converts directories on a schedule",
+	Long: `Converts directories on a schedule, for when cron and a wrapper script around
+convert are more than it's worth.
+
+--schedule is a crontab style minute hour day month weekday, e.g. "0 3 * * *" for
+every night at 3, "*/30 * * * 1-5" for every half hour on weekdays, or @hourly,
+@daily, @weekly or @monthly. Times are local. A run still going when the next one is
+due makes it skip that one.
+
+Each run takes a lease in --lock-dir on the directories it converts, so a second
+daemon over the same directories, on this machine or another sharing the lock
+directory, skips its run rather than converting the same files at once. A crashed
+daemon's lease runs out after --lease-ttl.
+
+Takes all the convert flags, each run is a convert of its own with its own log when
+--log-file has {date} or {time} in it. Without arguments the paths from the config
+file are converted.
+
+With --health-addr, /livez, /healthz, /readyz and /status are served for container
+health checks, /status saying when the last run succeeded and failed.`,
+	Args:              cobra.ArbitraryArgs,
+	ValidArgsFunction: completeDirs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		if len(args) == 0 && loadedConfig != nil {
+			args = loadedConfig.paths
+		}
+		if len(args) == 0 {
+			logger.Fatal("nothing to convert, pass some directories or set paths in the config file")
+		}
+		sched, err := parseSchedule(daemonSchedule)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		// bad flags are better found now than at 3am
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		paths, err := c.localInputs(args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		d := &daemon{schedule: sched, logger: logger, lockName: strings.Join(sortedCopy(paths), "\n"), health: newHealthState(0)}
+		if daemonLockDir != "" {
+			d.lock, err = newClaimStore(hackpados.NewFS(), filepath.ToSlash(daemonLockDir), leaseTTL)
+			if err != nil {
+				logger.Fatal(err)
+			}
+		}
+		d.batch = func(ctx context.Context) error {
+			logFile, err := openLogFile(time.Now())
+			if err != nil {
+				return err
+			}
+			defer logFile.Close()
+			logger.SetOutput(io.MultiWriter(consoleOutput(os.Stdout), logFileOutput(logFile)))
+			defer logger.SetOutput(consoleOutput(os.Stdout))
+
+			c, err := newConverter(logger)
+			if err != nil {
+				return err
+			}
+			c.settings = effectiveOptions(cmd.Flags())
+			c.statePath = stateFileName
+			if !cmd.Flags().Changed("state-file") {
+				c.statePath = defaultStatePath(paths)
+			}
+			c.historyPath = historyFileName
+			c.crashDir = crashDir
+			c.reportPath = reportFileName
+			err = c.runConvert(ctx, paths)
+			if errors.Is(err, errNoFiles) {
+				return nil
+			}
+			return err
+		}
+
+		if daemonHealthAddr != "" {
+			mux := http.NewServeMux()
+			d.health.register(mux)
+			mux.HandleFunc("/status", d.health.statusHandler())
+			go func() {
+				logger.Println(http.ListenAndServe(daemonHealthAddr, mux))
+			}()
+		}
+		d.health.setReady(true, "")
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			d.health.drain()
+		}()
+		d.loop(ctx, time.Now, daemonRunNow)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().AddFlagSet(convertCmd.Flags())
+	daemonCmd.Flags().StringVar(&daemonSchedule, "schedule", "0 3 * * *", "when to convert, as minute hour day month weekday like crontab, or @hourly, @daily, @weekly or @monthly")
+	daemonCmd.Flags().StringVar(&daemonLockDir, "lock-dir", defaultLockDir(), "directory for the lease keeping two daemons from converting the same directories at once; empty to disable")
+	daemonCmd.Flags().BoolVar(&daemonRunNow, "now", false, "run once straight away instead of waiting for the first scheduled time")
+	daemonCmd.Flags().StringVar(&daemonHealthAddr, "health-addr", "", "serve /livez, /healthz, /readyz and /status on this address, e.g. :8080")
+	daemonCmd.RegisterFlagCompletionFunc("lock-dir", completeDirs)
+}
+
+func defaultLockDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "locks")
+}
+
+func sortedCopy(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// daemon runs batch each time schedule comes round.
+type daemon struct {
+	schedule *schedule
+	logger   logger
+	batch    func(ctx context.Context) error
+	// lock, when set, holds a lease named lockName while a batch runs
+	lock     *claimStore
+	lockName string
+	// wait sleeps until the given time, false if ctx ended first
+	wait func(ctx context.Context, until time.Time) bool
+	// health is told how each run went, may be nil
+	health *healthState
+}
+
+// loop runs batch on schedule until ctx is done, first straight away with
+// runNow.
+func (d *daemon) loop(ctx context.Context, now func() time.Time, runNow bool) {
+	wait := d.wait
+	if wait == nil {
+		wait = sleepUntil
+	}
+	if runNow {
+		d.runOnce(ctx)
+	}
+	for ctx.Err() == nil {
+		at := d.schedule.next(now())
+		if at.IsZero() {
+			d.logger.Printf("The schedule never comes round, stopping\n")
+			return
+		}
+		d.logger.Printf("Next run at %s\n", at.Format(time.RFC3339))
+		if !wait(ctx, at) {
+			break
+		}
+		d.runOnce(ctx)
+	}
+	d.logger.Printf("Stopped\n")
+}
+
+// runOnce runs batch unless another daemon holds the lease on the same
+// directories.
+func (d *daemon) runOnce(ctx context.Context) {
+	release, err := d.lock.claim(ctx, d.lockName)
+	if errors.Is(err, errClaimed) {
+		d.logger.Printf("Skipping this run, another one over the same directories is still going\n")
+		return
+	}
+	if err != nil {
+		d.logger.Printf("Skipping this run, unable to take the lease: %s\n", err.Error())
+		return
+	}
+	defer release()
+
+	d.health.setQueued(1)
+	defer d.health.setQueued(0)
+	err = d.batch(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	d.health.record("run", err)
+	if err != nil {
+		d.logger.Printf("Run failed: %s\n", err.Error())
+	}
+}
+
+func sleepUntil(ctx context.Context, until time.Time) bool {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
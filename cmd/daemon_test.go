@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	memfs "github.com/hack-pad/hackpadfs/mem"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_daemonLoop(t *testing.T) {
+	sched, err := parseSchedule("0 3 * * *")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	waited := []time.Time{}
+	runs := 0
+	d := &daemon{schedule: sched, logger: testLogger{t}}
+	d.wait = func(ctx context.Context, until time.Time) bool {
+		waited = append(waited, until)
+		clock = until
+		return true
+	}
+	d.batch = func(ctx context.Context) error {
+		runs++
+		// a run long enough to miss the next one
+		clock = clock.Add(25 * time.Hour)
+		if runs == 2 {
+			cancel()
+		}
+		return nil
+	}
+
+	d.loop(ctx, func() time.Time { return clock }, true)
+	require.Equal(t, 2, runs)
+	require.Equal(t, []time.Time{time.Date(2024, 5, 3, 3, 0, 0, 0, time.UTC)}, waited)
+}
+
+func Test_daemonLock(t *testing.T) {
+	fsys, err := memfs.NewFS()
+	require.NoError(t, err)
+	other, err := newClaimStore(fsys, "/locks", time.Minute)
+	require.NoError(t, err)
+	ours, err := newClaimStore(fsys, "/locks", time.Minute)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	runs := 0
+	d := &daemon{logger: testLogger{t}, lock: ours, lockName: "/comics", batch: func(ctx context.Context) error {
+		runs++
+		return nil
+	}}
+
+	release, err := other.claim(ctx, "/comics")
+	require.NoError(t, err)
+	d.runOnce(ctx)
+	require.Equal(t, 0, runs, "another daemon over the same directories is running")
+
+	release()
+	d.runOnce(ctx)
+	require.Equal(t, 1, runs)
+
+	d.lockName = "/manga"
+	release, err = other.claim(ctx, "/comics")
+	require.NoError(t, err)
+	defer release()
+	d.runOnce(ctx)
+	require.Equal(t, 2, runs, "one over other directories doesn't count")
+}
+
+func Test_daemonHealth(t *testing.T) {
+	d := &daemon{logger: testLogger{t}, health: newHealthState(0)}
+	d.batch = func(ctx context.Context) error {
+		require.Equal(t, 1, d.health.status().Queued)
+		return errors.New("disk full")
+	}
+	d.runOnce(context.Background())
+	status := d.health.status()
+	require.Equal(t, 0, status.Queued)
+	require.Equal(t, "run: disk full", status.LastError)
+	require.Nil(t, status.LastSuccess)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	stopAfter time.Duration
+	stopAt    string
+)
+
+// clockTime is a time of day, as given to --stop-at.
+type clockTime struct {
+	hour, minute int
+}
+
+func parseClock(s string) (*clockTime, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return nil, errors.Errorf("%q isn't a time of day like 07:00", s)
+	}
+	return &clockTime{hour: t.Hour(), minute: t.Minute()}, nil
+}
+
+// next is the first time after from that the clock shows t.
+func (t clockTime) next(from time.Time) time.Time {
+	at := time.Date(from.Year(), from.Month(), from.Day(), t.hour, t.minute, 0, 0, from.Location())
+	if !at.After(from) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
+
+// batchDeadline is when a batch started at start stops starting files, the
+// earlier of --stop-after and --stop-at, or zero if neither was given.
+func (c *converter) batchDeadline(start time.Time) time.Time {
+	var deadline time.Time
+	if c.stopAfter > 0 {
+		deadline = start.Add(c.stopAfter)
+	}
+	if c.stopAt != nil {
+		at := c.stopAt.next(start)
+		if deadline.IsZero() || at.Before(deadline) {
+			deadline = at
+		}
+	}
+	return deadline
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_clockTime_next(t *testing.T) {
+	from := time.Date(2024, 10, 14, 22, 30, 0, 0, time.Local)
+	tests := []struct {
+		clock string
+		want  time.Time
+	}{
+		{"07:00", time.Date(2024, 10, 15, 7, 0, 0, 0, time.Local)},
+		{"23:15", time.Date(2024, 10, 14, 23, 15, 0, 0, time.Local)},
+		{"22:30", time.Date(2024, 10, 15, 22, 30, 0, 0, time.Local)},
+		{"7:05", time.Date(2024, 10, 15, 7, 5, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clock, func(t *testing.T) {
+			clock, err := parseClock(tt.clock)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, clock.next(from))
+		})
+	}
+
+	_, err := parseClock("7am")
+	require.Error(t, err)
+}
+
+func Test_batchDeadline(t *testing.T) {
+	start := time.Date(2024, 10, 14, 22, 30, 0, 0, time.Local)
+	seven := &clockTime{hour: 7}
+
+	c := &converter{}
+	require.True(t, c.batchDeadline(start).IsZero())
+	c = &converter{stopAfter: 6 * time.Hour}
+	require.Equal(t, start.Add(6*time.Hour), c.batchDeadline(start))
+	c = &converter{stopAfter: 12 * time.Hour, stopAt: seven}
+	require.Equal(t, time.Date(2024, 10, 15, 7, 0, 0, 0, time.Local), c.batchDeadline(start), "the earlier one wins")
+	c = &converter{stopAfter: time.Hour, stopAt: seven}
+	require.Equal(t, start.Add(time.Hour), c.batchDeadline(start))
+}
+
+func Test_stopAtDeadline(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr": realCBRContents,
+		"library/b.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+	state := filepath.Join(t.TempDir(), "state.json")
+
+	// passed before the first file could start
+	c := &converter{fs: fsys, logger: testLogger{t}, jobs: 1, statePath: state, stopAfter: time.Nanosecond}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.converted)
+	require.Equal(t, 2, c.notStarted)
+	require.Equal(t, exitStopped, c.exitCode(nil))
+	require.Equal(t, 2, c.summary([]string{"/library"}, nil).NotStarted)
+	_, err = os.Stat(state)
+	require.NoError(t, err, "kept for --resume")
+
+	c = &converter{fs: fsys, logger: testLogger{t}, jobs: 1, statePath: state, resume: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.converted, 2)
+	require.Equal(t, exitOK, c.exitCode(nil))
+	_, err = os.Stat(state)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dedupeRemove bool
+	dedupeTrash  bool
+)
+
+// dedupeCmd represents the dedupe command
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe [paths...]",
+	Short: "Finds archives holding the same comic under different names or formats",
+	Long: `Finds cbz, cbr, cb7 and cbt files holding the same comic, going by the contents of
+their pages rather than the archive's bytes, so a cbr and the cbz converted from it
+match, whatever either is called. Page names and other entries, like ComicInfo.xml,
+don't count. Pages that were re-encoded don't match their originals.
+
+Each set of duplicates is listed with the one kept: a cbz over the other formats,
+then the first by path. --remove deletes the others, --trash moves them to the
+trash or recycle bin instead. Nothing is removed without either.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fsys, args, err = localArgs(fsys, args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		d := &deduper{fs: fsys, logger: logger, remove: dedupeRemove || dedupeTrash, trash: dedupeTrash}
+		err = d.run(cmd.Context(), args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dedupeCmd)
+
+	dedupeCmd.Flags().BoolVar(&dedupeRemove, "remove", false, "delete the duplicates, keeping one archive of each set")
+	dedupeCmd.Flags().BoolVar(&dedupeTrash, "trash", false, "move the duplicates to the trash or recycle bin, keeping one archive of each set")
+}
+
+type deduper struct {
+	fs      hackpadfs.FS
+	logger  logger
+	entries cbr2cbz.EntryFilter
+	remove  bool
+	trash   bool
+}
+
+// duplicateSet is archives with the same pages.
+type duplicateSet struct {
+	Pages      int
+	Keep       string
+	Duplicates []string
+}
+
+func (d *deduper) run(ctx context.Context, paths []string) error {
+	files, err := findArchives(d.fs, paths, "cbz, cbr, cb7 or cbt", verifiedExtensions...)
+	if err != nil {
+		return err
+	}
+
+	sets, err := d.find(ctx, files)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	duplicates := 0
+	for _, set := range sets {
+		d.logger.Printf("Same %d pages: keeping %s\n", set.Pages, set.Keep)
+		for _, dup := range set.Duplicates {
+			duplicates++
+			if !d.remove {
+				d.logger.Printf("  duplicate %s\n", dup)
+				continue
+			}
+			if pathToFsPath(filepath.Clean(dup)) == pathToFsPath(filepath.Clean(set.Keep)) {
+				// the copy being kept, never remove it
+				d.logger.Printf("  Error removing %s - Skipping...it is the copy being kept\n", dup)
+				failed++
+				continue
+			}
+			err := d.dispose(dup)
+			if err != nil {
+				d.logger.Printf("  Error removing %s - Skipping...%s\n", dup, err.Error())
+				failed++
+				continue
+			}
+			d.logger.Printf("  removed %s\n", dup)
+		}
+	}
+
+	d.logger.Printf("%d duplicates in %d sets among %d archives\n", duplicates, len(sets), len(files))
+	if failed > 0 {
+		return errors.Errorf("%d duplicates couldn't be removed", failed)
+	}
+	return nil
+}
+
+// find groups files by their pages, returning the groups of more than one
+// in the order of the archive kept. Archives that can't be read are logged
+// and left out.
+func (d *deduper) find(ctx context.Context, files []string) ([]duplicateSet, error) {
+	bySum := map[string][]string{}
+	pageCounts := map[string]int{}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if laterVolume(file) {
+			// read with the first volume, or not at all
+			continue
+		}
+		sum, pages, err := d.contentSum(ctx, file)
+		if err != nil {
+			d.logger.Printf("Error reading %s - Skipping...%s\n", file, err.Error())
+			continue
+		}
+		if pages == 0 {
+			continue
+		}
+		bySum[sum] = append(bySum[sum], file)
+		pageCounts[sum] = pages
+	}
+
+	sets := []duplicateSet{}
+	for sum, group := range bySum {
+		if len(group) < 2 {
+			continue
+		}
+		sortKeepFirst(group)
+		sets = append(sets, duplicateSet{Pages: pageCounts[sum], Keep: group[0], Duplicates: group[1:]})
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Keep < sets[j].Keep })
+	return sets, nil
+}
+
+// contentSum is the SHA-256 of the SHA-256 of every page of file, in sorted
+// order so neither page names nor the order they are stored in count. It
+// returns how many pages there are too.
+func (d *deduper) contentSum(ctx context.Context, file string) (string, int, error) {
+	pages, err := pageSums(ctx, d.fs, file, d.entries)
+	if err != nil {
+		return "", 0, err
+	}
+	sums := make([]string, 0, len(pages))
+	for _, page := range pages {
+		sums = append(sums, page.SHA256)
+	}
+	sort.Strings(sums)
+
+	h := sha256.New()
+	for _, sum := range sums {
+		io.WriteString(h, sum+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil)), len(pages), nil
+}
+
+// sortKeepFirst puts the archive of files worth keeping first, a cbz over
+// the other formats and then the first by path.
+func sortKeepFirst(files []string) {
+	isCBZ := func(file string) bool { return strings.EqualFold(filepath.Ext(file), ".cbz") }
+	sort.Slice(files, func(i, j int) bool {
+		if isCBZ(files[i]) != isCBZ(files[j]) {
+			return isCBZ(files[i])
+		}
+		return files[i] < files[j]
+	})
+}
+
+func (d *deduper) dispose(file string) error {
+	if !d.trash {
+		return hackpadfs.Remove(d.fs, pathToFsPath(file))
+	}
+	osFS, ok := d.fs.(interface{ ToOSPath(string) (string, error) })
+	if !ok {
+		return errors.New("--trash only works for files on a local disk")
+	}
+	osPath, err := osFS.ToOSPath(pathToFsPath(file))
+	if err != nil {
+		return errors.Wrap(err, "finding duplicate")
+	}
+	_, err = moveToTrash(osPath)
+	return err
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_deduper(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/Saga 001.cbz": makeZip(t, map[string]string{"001.jpg": "page one", "002.jpg": "page two", "ComicInfo.xml": "<ComicInfo/>"}),
+		// the same pages, named and packed differently
+		"comics/old/saga_1.cbt": makeTar(t, map[string]string{"a.jpg": "page two", "b.jpg": "page one"}),
+		"comics/other/Saga.cbz": makeZip(t, map[string]string{"p1.jpg": "page one", "p2.jpg": "page two"}),
+		"comics/Saga 002.cbz":   makeZip(t, map[string]string{"001.jpg": "page one", "002.jpg": "page three"}),
+		"comics/empty.cbz":      makeZip(t, map[string]string{"notes.txt": "no pages"}),
+		"comics/empty2.cbz":     makeZip(t, map[string]string{"notes.txt": "no pages"}),
+	})
+	require.NoError(t, err)
+
+	d := &deduper{fs: fsys, logger: testLogger{t}}
+	files, err := findArchives(fsys, []string{"/comics"}, "cbz", verifiedExtensions...)
+	require.NoError(t, err)
+	sets, err := d.find(context.Background(), files)
+	require.NoError(t, err)
+	require.Equal(t, []duplicateSet{{
+		Pages:      2,
+		Keep:       "/comics/Saga 001.cbz",
+		Duplicates: []string{"/comics/other/Saga.cbz", "/comics/old/saga_1.cbt"},
+	}}, sets)
+
+	// listed only
+	require.NoError(t, d.run(context.Background(), []string{"/comics"}))
+	_, err = hackpadfs.Stat(fsys, "comics/old/saga_1.cbt")
+	require.NoError(t, err)
+
+	d.remove = true
+	require.NoError(t, d.run(context.Background(), []string{"/comics"}))
+	for _, name := range []string{"comics/old/saga_1.cbt", "comics/other/Saga.cbz"} {
+		_, err = hackpadfs.Stat(fsys, name)
+		require.ErrorIs(t, err, hackpadfs.ErrNotExist, name)
+	}
+	for _, name := range []string{"comics/Saga 001.cbz", "comics/Saga 002.cbz", "comics/empty.cbz"} {
+		_, err = hackpadfs.Stat(fsys, name)
+		require.NoError(t, err, name)
+	}
+}
+
+func Test_sortKeepFirst(t *testing.T) {
+	files := []string{"/b/Saga.cbr", "/c/Saga.cbz", "/a/Saga.cbt", "/a/Saga.CBZ"}
+	sortKeepFirst(files)
+	require.Equal(t, []string{"/a/Saga.CBZ", "/c/Saga.cbz", "/a/Saga.cbt", "/b/Saga.cbr"}, files)
+}
+
+func Test_deduper_overlappingPaths(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"lib/sub/one.cbz": makeZip(t, map[string]string{"001.jpg": "page one"}),
+	})
+	require.NoError(t, err)
+
+	d := &deduper{fs: fsys, logger: testLogger{t}, remove: true}
+	require.NoError(t, d.run(context.Background(), []string{"/lib", "/lib/sub", "/lib/sub/", "/lib/sub/one.cbz"}))
+	_, err = hackpadfs.Stat(fsys, "lib/sub/one.cbz")
+	require.NoError(t, err, "the only copy is kept")
+}
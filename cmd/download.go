@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+var downloadDir string
+
+// errDownloadRestart is a download that has to start over, the server
+// having refused to carry on from where it stopped.
+var errDownloadRestart = errors.New("server can't resume the download, starting over")
+
+// errServerBusy is a server answering with an error that may be gone on
+// the next try, like 503 or 429.
+var errServerBusy = errors.New("server busy")
+
+// downloadClient gives up on servers that don't connect or answer, but not
+// on a long download, which --stall-timeout covers instead.
+var downloadClient = &http.Client{Transport: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	TLSHandshakeTimeout:   30 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+}}
+
+// downloadIndex is the file in a download directory listing what was
+// downloaded there, so only those files are ever used again.
+const downloadIndex = ".cbr2cbz-downloads.json"
+
+// downloadRecord is a file downloaded from a url, keyed by the url's sha256
+// in the download index so no api key in it ends up on disk.
+type downloadRecord struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// isURL reports whether p is an http or https url to download and convert.
+func isURL(p string) bool {
+	scheme, _, ok := strings.Cut(p, "://")
+	return ok && (strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https"))
+}
+
+// downloadURLs downloads the archives given as urls among args into dir, or
+// a temporary directory when dir is empty, returning args with the
+// downloaded files in their place and a func that removes what it
+// downloaded to a temporary directory. The cbz files go to --output-dir, or
+// the working directory when it isn't given.
+func (c *converter) downloadURLs(ctx context.Context, args []string, dir string) ([]string, func(), error) {
+	urls := 0
+	for _, arg := range args {
+		if isURL(arg) {
+			urls++
+		}
+	}
+	if urls == 0 {
+		return args, func() {}, nil
+	}
+	if urls != len(args) {
+		return nil, nil, errors.New("urls and other paths can't be mixed in one run")
+	}
+	if rootDir != "" {
+		return nil, nil, errors.New("--root can't be combined with urls")
+	}
+
+	cleanup := func() {}
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "cbr2cbz-download-")
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "creating download directory")
+		}
+		dir = tmp
+		cleanup = func() { os.RemoveAll(tmp) }
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, errors.Wrap(err, "creating --download-dir")
+	}
+	if c.outputDir == "" {
+		c.outputDir = "."
+	}
+
+	files := []string{}
+	taken := map[string]string{}
+	for _, arg := range args {
+		file, err := c.download(ctx, arg, dir)
+		if err != nil {
+			cleanup()
+			return nil, nil, errors.Wrapf(err, "downloading %s", redactURL(arg))
+		}
+		if other, ok := taken[file]; ok {
+			cleanup()
+			return nil, nil, errors.Errorf("%s and %s both download to %s", redactURL(other), redactURL(arg), file)
+		}
+		taken[file] = arg
+		files = append(files, file)
+	}
+	return files, cleanup, nil
+}
+
+// download fetches rawURL into dir, returning where it is. A #sha256=<hex>
+// fragment is checked against the file once it is there. What is left of a
+// download that broke off is kept in dir as .<key>.part, see downloadKey,
+// and carried on from by the same url only, on the next try or the next run
+// with the same --download-dir. A finished one is used again as long as it
+// is still there. A file in dir
+// that wasn't downloaded from rawURL is never used nor replaced, the
+// download gets a number added instead. Transient errors are tried again
+// like conversions are, see --retries.
+func (c *converter) download(ctx context.Context, rawURL string, dir string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing url")
+	}
+	sum, err := downloadChecksum(u.Fragment)
+	if err != nil {
+		return "", err
+	}
+	u.Fragment = ""
+	key := downloadKey(u)
+	if file, ok := downloadedEarlier(dir, key); ok {
+		c.logger.Printf("Using %s downloaded earlier\n", file)
+		return file, checkDownload(file, sum)
+	}
+	name := downloadName(u.Path)
+	file := filepath.Join(dir, name)
+
+	part := filepath.Join(dir, "."+key+".part")
+	c.logger.Printf("Downloading %s to %s\n", u.Redacted(), file)
+	delay := c.retryDelay
+	for attempt := 1; ; attempt++ {
+		var disposition string
+		disposition, err = c.fetch(ctx, u, part)
+		if err == nil {
+			if disposition != "" {
+				file = filepath.Join(dir, disposition)
+			}
+			break
+		}
+		if errors.Is(err, errDownloadRestart) {
+			err = os.Truncate(part, 0)
+			if err != nil {
+				return "", errors.Wrap(err, "starting the download over")
+			}
+			continue
+		}
+		if attempt > c.retries || !isTransientDownload(err) || ctx.Err() != nil {
+			return "", err
+		}
+		c.warn(cbr2cbz.CodeRetrying, rawURL, "Downloading %s failed, carrying on in %s (%d of %d): %s",
+			u.Redacted(), delay, attempt, c.retries, err.Error())
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+
+	err = checkDownload(part, sum)
+	if err != nil {
+		// whatever is wrong with it, the next try starts from nothing
+		os.Remove(part)
+		return "", err
+	}
+	file = freeDownloadName(file)
+	err = os.Rename(part, file)
+	if err != nil {
+		return "", errors.Wrap(err, "renaming download")
+	}
+	if info, err := os.Stat(file); err == nil {
+		c.logger.Printf("Downloaded %s (%s)\n", file, formatBytes(uint64(info.Size())))
+		if err := recordDownload(dir, key, downloadRecord{Name: filepath.Base(file), Size: info.Size()}); err != nil {
+			c.logger.Printf("Unable to record %s as downloaded, it will be downloaded again: %s\n", file, err.Error())
+		}
+	}
+	return file, nil
+}
+
+// downloadKey is what a url is recorded under in the download index.
+func downloadKey(u *url.URL) string {
+	sum := sha256.Sum256([]byte(u.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// readDownloads reads the download index of dir, empty if there is none.
+func readDownloads(dir string) (map[string]downloadRecord, error) {
+	records := map[string]downloadRecord{}
+	data, err := os.ReadFile(filepath.Join(dir, downloadIndex))
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return records, json.Unmarshal(data, &records)
+}
+
+// downloadedEarlier returns the file in dir the url recorded as key was
+// downloaded to, if it is still there as it was downloaded.
+func downloadedEarlier(dir string, key string) (string, bool) {
+	records, err := readDownloads(dir)
+	if err != nil {
+		return "", false
+	}
+	record, ok := records[key]
+	if !ok {
+		return "", false
+	}
+	file := filepath.Join(dir, record.Name)
+	info, err := os.Stat(file)
+	if err != nil || info.Size() != record.Size {
+		return "", false
+	}
+	return file, true
+}
+
+// recordDownload adds record to the download index of dir as key.
+func recordDownload(dir string, key string, record downloadRecord) error {
+	records, err := readDownloads(dir)
+	if err != nil {
+		records = map[string]downloadRecord{}
+	}
+	records[key] = record
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, downloadIndex), data, 0644)
+}
+
+// freeDownloadName returns file, or file with a number added if something
+// is there already.
+func freeDownloadName(file string) string {
+	ext := filepath.Ext(file)
+	stem := strings.TrimSuffix(file, ext)
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(file); errors.Is(err, os.ErrNotExist) {
+			return file
+		}
+		file = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+}
+
+// fetch gets u into part, carrying on from the end of what is there already
+// when the server allows it. It returns the file name the server gave the
+// download, if any.
+func (c *converter) fetch(ctx context.Context, u *url.URL, part string) (string, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", errors.Wrap(err, "opening download")
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", errors.Wrap(err, "opening download")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "building request")
+	}
+	req.Header.Set("User-Agent", "cbr2cbz/"+buildVersion)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "requesting")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return "", errDownloadRestart
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		return "", errDownloadRestart
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return "", errors.Wrapf(errServerBusy, "answered %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return "", errors.Errorf("answered %s", resp.Status)
+	case offset > 0:
+		// the whole file again, not what was missing
+		if err := f.Truncate(0); err != nil {
+			return "", errors.Wrap(err, "starting the download over")
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", errors.Wrap(err, "starting the download over")
+		}
+	}
+
+	body := io.Reader(resp.Body)
+	if c.stallTimeout > 0 {
+		idle := time.AfterFunc(c.stallTimeout, func() { cancel(errStalled) })
+		defer idle.Stop()
+		body = idleReader{r: resp.Body, timer: idle, timeout: c.stallTimeout}
+	}
+	_, err = io.Copy(f, body)
+	if errors.Is(context.Cause(ctx), errStalled) {
+		return "", errors.Wrapf(errStalled, "nothing received for %s", c.stallTimeout)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "downloading")
+	}
+	err = f.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "writing download")
+	}
+	return dispositionName(resp.Header.Get("Content-Disposition")), nil
+}
+
+// idleReader pushes timer back by timeout with every read that gets
+// something.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// isTransientDownload reports whether a download that failed with err may
+// get further on the next try: isTransient, a connection that broke off or
+// stalled or a busy server.
+func isTransientDownload(err error) bool {
+	return isTransient(err) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errServerBusy) || errors.Is(err, errStalled)
+}
+
+// downloadChecksum is the sha256 a url's fragment asks for, if any.
+func downloadChecksum(fragment string) (string, error) {
+	if fragment == "" {
+		return "", nil
+	}
+	algo, sum, _ := strings.Cut(fragment, "=")
+	if !strings.EqualFold(algo, "sha256") {
+		return "", errors.Errorf("unknown checksum #%s, give #sha256=<hex>", fragment)
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", errors.Errorf("#sha256=%s isn't a sha256 in hex", sum)
+	}
+	return strings.ToLower(sum), nil
+}
+
+// checkDownload checks that file has the sha256 sum, unless it is empty.
+func checkDownload(file string, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "checking download")
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return errors.Wrap(err, "checking download")
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return errors.Errorf("%s has sha256 %s, not %s", file, got, sum)
+	}
+	return nil
+}
+
+// downloadName is the file a url path downloads to, its last element made
+// safe to create anywhere.
+func downloadName(urlPath string) string {
+	name := path.Base(urlPath)
+	if name == "/" || name == "." {
+		return "download"
+	}
+	return cbr2cbz.SafeFileName(name)
+}
+
+// dispositionName is the file name a Content-Disposition header gives,
+// made safe like downloadName, or empty.
+func dispositionName(header string) string {
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil || params["filename"] == "" {
+		return ""
+	}
+	return downloadName(strings.ReplaceAll(params["filename"], `\`, "/"))
+}
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_downloadURLs(t *testing.T) {
+	archive := makeTar(t, map[string]string{"001.jpg": "page"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/get" {
+			w.Header().Set("Content-Disposition", `attachment; filename="Saga 002.cbt"`)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	dir, err = os.Getwd()
+	require.NoError(t, err)
+
+	c := &converter{fs: hackpados.NewFS(), logger: testLogger{t}}
+	files, removeDownloads, err := c.downloadURLs(context.Background(), []string{srv.URL + "/comics/Saga%20001.cbt", srv.URL + "/get?id=2"}, "")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "Saga 001.cbt", filepath.Base(files[0]))
+	require.Equal(t, "Saga 002.cbt", filepath.Base(files[1]))
+	require.Equal(t, ".", c.outputDir)
+
+	paths, err := c.localInputs(files)
+	require.NoError(t, err)
+	require.NoError(t, c.runConvert(context.Background(), paths))
+	removeDownloads()
+	for _, name := range []string{"Saga 001.cbz", "Saga 002.cbz"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+	}
+	_, err = os.Stat(filepath.Dir(files[0]))
+	require.ErrorIs(t, err, os.ErrNotExist, "the temporary directory is removed")
+
+	c = &converter{fs: hackpados.NewFS(), logger: testLogger{t}}
+	_, _, err = c.downloadURLs(context.Background(), []string{srv.URL + "/a.cbt", "local.cbr"}, "")
+	require.ErrorContains(t, err, "can't be mixed")
+}
+
+func Test_download_resume(t *testing.T) {
+	archive := makeTar(t, map[string]string{"001.jpg": "page", "002.jpg": "another page"})
+	var ranges atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	u, err := url.Parse(srv.URL + "/Saga.cbt")
+	require.NoError(t, err)
+	part := filepath.Join(dir, "."+downloadKey(u)+".part")
+	require.NoError(t, os.WriteFile(part, archive[:100], 0o644))
+	// left by another url of the same name, so not carried on from
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Saga.cbt.part"), []byte("other"), 0o644))
+
+	c := &converter{logger: testLogger{t}}
+	file, err := c.download(context.Background(), srv.URL+"/Saga.cbt", dir)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), ranges.Load())
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, archive, data)
+	_, err = os.Stat(part)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// there already, so not downloaded again
+	srv.Close()
+	again, err := c.download(context.Background(), srv.URL+"/Saga.cbt", dir)
+	require.NoError(t, err)
+	require.Equal(t, file, again)
+}
+
+func Test_download_checksum(t *testing.T) {
+	archive := makeTar(t, map[string]string{"001.jpg": "page"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(archive)
+
+	dir := t.TempDir()
+	c := &converter{logger: testLogger{t}}
+	_, err := c.download(context.Background(), srv.URL+"/good.cbt#sha256="+hex.EncodeToString(sum[:]), dir)
+	require.NoError(t, err)
+
+	_, err = c.download(context.Background(), srv.URL+"/bad.cbt#sha256="+hex.EncodeToString(make([]byte, 32)), dir)
+	require.ErrorContains(t, err, "has sha256")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{downloadIndex, "good.cbt"}, names, "nothing is left of the bad download")
+
+	_, err = c.download(context.Background(), srv.URL+"/a.cbt#md5=abc", dir)
+	require.ErrorContains(t, err, "unknown checksum")
+}
+
+func Test_download_retries(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/missing.cbt":
+			http.NotFound(w, r)
+		case requests.Add(1) == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("archive"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c := &converter{logger: testLogger{t}, retries: 1, retryDelay: time.Millisecond}
+	_, err := c.download(context.Background(), srv.URL+"/busy.cbt", dir)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), requests.Load())
+
+	_, err = c.download(context.Background(), srv.URL+"/missing.cbt", dir)
+	require.ErrorContains(t, err, "404")
+}
+
+func Test_download_names(t *testing.T) {
+	archive := makeTar(t, map[string]string{"001.jpg": "page"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/get" {
+			w.Header().Set("Content-Disposition", `attachment; filename="Saga 002.cbt"`)
+		}
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	// not downloaded by us, so neither used nor replaced
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Saga.cbt"), []byte("mine"), 0o644))
+
+	c := &converter{logger: testLogger{t}}
+	file, err := c.download(context.Background(), srv.URL+"/Saga.cbt", dir)
+	require.NoError(t, err)
+	require.Equal(t, "Saga (2).cbt", filepath.Base(file))
+	data, err := os.ReadFile(filepath.Join(dir, "Saga.cbt"))
+	require.NoError(t, err)
+	require.Equal(t, "mine", string(data))
+
+	named, err := c.download(context.Background(), srv.URL+"/get?id=2", dir)
+	require.NoError(t, err)
+	require.Equal(t, "Saga 002.cbt", filepath.Base(named))
+
+	// found again under the names they were saved as
+	srv.Close()
+	again, err := c.download(context.Background(), srv.URL+"/Saga.cbt", dir)
+	require.NoError(t, err)
+	require.Equal(t, file, again)
+	again, err = c.download(context.Background(), srv.URL+"/get?id=2", dir)
+	require.NoError(t, err)
+	require.Equal(t, named, again)
+}
+
+func Test_download_sameName(t *testing.T) {
+	serve := func(archive []byte, cut bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cut && r.Header.Get("Range") == "" {
+				// breaks off half way
+				w.Header().Set("Content-Length", fmt.Sprint(len(archive)))
+				w.Write(archive[:len(archive)/2])
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+		}))
+	}
+	first := makeTar(t, map[string]string{"001.jpg": "first"})
+	second := makeTar(t, map[string]string{"001.jpg": "second", "002.jpg": "more"})
+	a, b := serve(first, true), serve(second, false)
+	defer a.Close()
+	defer b.Close()
+
+	dir := t.TempDir()
+	c := &converter{logger: testLogger{t}}
+	_, err := c.download(context.Background(), a.URL+"/download/issue1.cbt", dir)
+	require.Error(t, err, "broke off, leaving a partial download")
+
+	file, err := c.download(context.Background(), b.URL+"/download/issue1.cbt", dir)
+	require.NoError(t, err)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, second, data, "not spliced onto the other url's partial download")
+}
+
+func Test_download_stalled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := &converter{logger: testLogger{t}, stallTimeout: 50 * time.Millisecond}
+	_, err := c.download(context.Background(), srv.URL+"/Saga.cbt", t.TempDir())
+	require.ErrorIs(t, err, errStalled)
+}
+
+func Test_dispositionName(t *testing.T) {
+	require.Equal(t, "Saga 001.cbr", dispositionName(`attachment; filename="Saga 001.cbr"`))
+	require.Equal(t, "evil.cbr", dispositionName(`attachment; filename="..\..\evil.cbr"`))
+	require.Equal(t, "_CON.cbr", dispositionName(`attachment; filename="CON.cbr"`))
+	require.Equal(t, "", dispositionName("inline"))
+	require.Equal(t, "download", downloadName("/"))
+}
@@ -0,0 +1,426 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	epubOut       string
+	epubDirection string
+	epubDevice    string
+)
+
+var exportEPUBCmd = &cobra.Command{
+	Use:   "epub [paths...]",
+	Short: "Writes a fixed-layout EPUB with one page per image for each cbz or cbr",
+	Long: `Writes a fixed-layout EPUB 3 with one page per image for each cbz or cbr, for
+e-readers that handle EPUB better than comic archives.
+
+Pages keep the order they have in the archive and each is shown whole, the size of
+its image. The title, series, writer and language come from ComicInfo.xml when the
+archive has one. Manga, or --direction rtl, reads right to left.
+
+--device kobo names the book .kepub.epub, which Kobo readers open with their faster
+reader; --device kindle adds the fixed-layout hints Kindle tools look for when
+converting it. The EPUB goes next to the archive unless --out is given; the archive
+itself is left alone.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		e, err := newEPUBExporter(epubOut, epubDirection, epubDevice)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		e.fs, err = newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		e.logger = logger
+
+		err = e.run(cmd.Context(), args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(exportEPUBCmd)
+
+	exportEPUBCmd.Flags().StringVar(&epubOut, "out", "", "directory to write the EPUBs to, next to each archive if unset")
+	exportEPUBCmd.Flags().StringVar(&epubDirection, "direction", "auto", "reading direction: ltr, rtl, or auto to read manga in ComicInfo.xml right to left")
+	exportEPUBCmd.Flags().StringVar(&epubDevice, "device", "", "e-reader to tailor the EPUB for: kobo or kindle")
+}
+
+type epubExporter struct {
+	fs      hackpadfs.FS
+	logger  logger
+	entries cbr2cbz.EntryFilter
+	outDir  string
+	// direction is ltr, rtl or auto
+	direction string
+	// device is kobo, kindle or empty for neither
+	device string
+}
+
+func newEPUBExporter(outDir string, direction string, device string) (*epubExporter, error) {
+	switch direction {
+	case "ltr", "rtl", "auto":
+	default:
+		return nil, errors.Errorf("invalid --direction %q, expected ltr, rtl or auto", direction)
+	}
+	switch device {
+	case "", "kobo", "kindle":
+	default:
+		return nil, errors.Errorf("invalid --device %q, expected kobo or kindle", device)
+	}
+	return &epubExporter{outDir: outDir, direction: direction, device: device}, nil
+}
+
+func (e *epubExporter) run(ctx context.Context, paths []string) error {
+	return exportArchives(ctx, e.fs, e.logger, e.outDir, paths, e.export)
+}
+
+// epubPath is where the EPUB for file goes.
+func (e *epubExporter) epubPath(file string) string {
+	if e.device == "kobo" {
+		return exportPath(file, e.outDir, ".kepub.epub")
+	}
+	return exportPath(file, e.outDir, ".epub")
+}
+
+// epubMediaTypes are the media types of the image formats pages can be in.
+var epubMediaTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+// epubPage is a page of the book, its image and the xhtml showing it.
+type epubPage struct {
+	Number    int
+	Image     string
+	MediaType string
+	Width     int
+	Height    int
+	Cover     bool
+}
+
+// XHTML is the name of the document showing the page.
+func (p epubPage) XHTML() string {
+	return fmt.Sprintf("pages/%04d.xhtml", p.Number)
+}
+
+// epubBook is what the package document is made from.
+type epubBook struct {
+	Identifier string
+	Title      string
+	Series     string
+	Number     string
+	Writer     string
+	Publisher  string
+	Language   string
+	Modified   string
+	RTL        bool
+	Kindle     bool
+	Pages      []epubPage
+}
+
+func (e *epubExporter) export(ctx context.Context, file string) error {
+	archive, err := openArchive(ctx, e.fs, pathToFsPath(file))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	pages, err := archive.pages(e.entries)
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return errors.New("no pages found")
+	}
+	sum, err := fileSum(e.fs, pathToFsPath(file))
+	if err != nil {
+		return err
+	}
+	info, err := hackpadfs.Stat(e.fs, pathToFsPath(file))
+	if err != nil {
+		return errors.Wrap(err, "stating archive")
+	}
+	book := e.book(archive, file, sum, info.ModTime())
+
+	out := e.epubPath(file)
+	tmp := tempName(out)
+	f, err := hackpadfs.Create(e.fs, tmp)
+	if err != nil {
+		return errors.Wrap(err, "creating epub")
+	}
+	w, ok := f.(io.Writer)
+	if !ok {
+		f.Close()
+		hackpadfs.Remove(e.fs, tmp)
+		return errors.New("destination isn't a writable filesystem")
+	}
+
+	err = e.write(ctx, w, archive, pages, book)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = hackpadfs.Rename(e.fs, tmp, out)
+	}
+	if err != nil {
+		hackpadfs.Remove(e.fs, tmp)
+		return errors.Wrap(err, "writing epub")
+	}
+
+	written, err := hackpadfs.Stat(e.fs, out)
+	if err != nil {
+		return errors.Wrap(err, "stating epub")
+	}
+	e.logger.Printf("Exported %s to %s, %d pages (%s)\n", file, out, len(pages), formatBytes(uint64(written.Size())))
+	return nil
+}
+
+// book fills in what the package document says about file from its
+// ComicInfo.xml, or its name when it has none.
+func (e *epubExporter) book(archive fs.FS, file string, sum string, modified time.Time) epubBook {
+	info := cbr2cbz.ComicInfoFromFilename(file)
+	if f, err := archive.Open(cbr2cbz.ComicInfoName); err == nil {
+		if parsed, err := cbr2cbz.ParseComicInfo(f); err == nil {
+			info = parsed
+		}
+		f.Close()
+	}
+
+	book := epubBook{
+		Identifier: "urn:sha256:" + sum,
+		Title:      info.Title,
+		Series:     info.Series,
+		Number:     info.Number,
+		Writer:     info.Writer,
+		Publisher:  info.Publisher,
+		Language:   info.LanguageISO,
+		Modified:   modified.UTC().Format("2006-01-02T15:04:05Z"),
+		RTL:        e.direction == "rtl" || (e.direction == "auto" && info.Manga == "YesAndRightToLeft"),
+		Kindle:     e.device == "kindle",
+	}
+	if book.Title == "" && book.Series != "" {
+		book.Title = strings.TrimSpace(book.Series + " " + book.Number)
+	}
+	if book.Title == "" {
+		book.Title = strings.TrimSuffix(path.Base(file), path.Ext(file))
+	}
+	if book.Language == "" {
+		book.Language = "en"
+	}
+	return book
+}
+
+// write writes the EPUB of pages, entries of archive, to w.
+func (e *epubExporter) write(ctx context.Context, w io.Writer, archive fs.FS, pages []string, book epubBook) error {
+	zw := zip.NewWriter(w)
+	// the mimetype comes first and uncompressed, so the file can be told
+	// apart by its first bytes
+	err := writeEPUBEntry(zw, "mimetype", zip.Store, []byte("application/epub+zip"))
+	if err != nil {
+		return err
+	}
+	err = writeEPUBEntry(zw, "META-INF/container.xml", zip.Deflate, []byte(epubContainer))
+	if err != nil {
+		return err
+	}
+
+	cover := cbr2cbz.CoverPage(pages)
+	for i, name := range pages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		data, err := fs.ReadFile(archive, name)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+		info, err := cbr2cbz.ReadPageInfo(name, bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+		mediaType, ok := epubMediaTypes[info.Format]
+		if !ok {
+			return errors.Errorf("%s is a %s, which EPUB readers can't show", name, info.Format)
+		}
+		ext := "." + info.Format
+		if info.Format == "jpeg" {
+			ext = ".jpg"
+		}
+		page := epubPage{
+			Number:    i + 1,
+			Image:     fmt.Sprintf("images/%04d%s", i+1, ext),
+			MediaType: mediaType,
+			Width:     info.Width,
+			Height:    info.Height,
+			Cover:     name == cover,
+		}
+		book.Pages = append(book.Pages, page)
+
+		// pages are compressed already
+		err = writeEPUBEntry(zw, "OEBPS/"+page.Image, zip.Store, data)
+		if err != nil {
+			return err
+		}
+		err = writeEPUBTemplate(zw, "OEBPS/"+page.XHTML(), "page", page)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeEPUBTemplate(zw, "OEBPS/nav.xhtml", "nav", book)
+	if err != nil {
+		return err
+	}
+	err = writeEPUBTemplate(zw, "OEBPS/content.opf", "opf", book)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeEPUBEntry(zw *zip.Writer, name string, method uint16, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	_, err = w.Write(data)
+	return errors.Wrapf(err, "writing %s", name)
+}
+
+func writeEPUBTemplate(zw *zip.Writer, name string, tmpl string, data any) error {
+	buf := &bytes.Buffer{}
+	err := epubTemplates.ExecuteTemplate(buf, tmpl, data)
+	if err != nil {
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	return writeEPUBEntry(zw, name, zip.Deflate, buf.Bytes())
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+var epubTemplates = template.Must(template.New("").Funcs(template.FuncMap{"x": xmlEscape}).Parse(`
+{{- define "opf" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">{{x .Identifier}}</dc:identifier>
+    <dc:title>{{x .Title}}</dc:title>
+    <dc:language>{{x .Language}}</dc:language>
+{{- if .Writer}}
+    <dc:creator>{{x .Writer}}</dc:creator>
+{{- end}}
+{{- if .Publisher}}
+    <dc:publisher>{{x .Publisher}}</dc:publisher>
+{{- end}}
+{{- if .Series}}
+    <meta property="belongs-to-collection" id="series">{{x .Series}}</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+{{- if .Number}}
+    <meta refines="#series" property="group-position">{{x .Number}}</meta>
+{{- end}}
+{{- end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">auto</meta>
+    <meta property="rendition:spread">landscape</meta>
+{{- range .Pages}}{{if .Cover}}
+    <meta name="cover" content="image-{{.Number}}"/>
+{{- end}}{{end}}
+{{- if .Kindle}}
+    <meta name="fixed-layout" content="true"/>
+    <meta name="book-type" content="comic"/>
+    <meta name="orientation-lock" content="none"/>
+    <meta name="region-mag" content="false"/>
+{{- with index .Pages 0}}
+    <meta name="original-resolution" content="{{.Width}}x{{.Height}}"/>
+{{- end}}
+{{- if .RTL}}
+    <meta name="primary-writing-mode" content="horizontal-rl"/>
+{{- end}}
+{{- end}}
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{{- range .Pages}}
+    <item id="image-{{.Number}}" href="{{.Image}}" media-type="{{.MediaType}}"{{if .Cover}} properties="cover-image"{{end}}/>
+    <item id="page-{{.Number}}" href="{{.XHTML}}" media-type="application/xhtml+xml"/>
+{{- end}}
+  </manifest>
+  <spine{{if .RTL}} page-progression-direction="rtl"{{end}}>
+{{- range .Pages}}
+    <itemref idref="page-{{.Number}}"/>
+{{- end}}
+  </spine>
+</package>
+{{end}}
+
+{{- define "nav" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{x .Title}}</title></head>
+<body>
+  <nav epub:type="toc">
+    <ol>
+      <li><a href="{{(index .Pages 0).XHTML}}">{{x .Title}}</a></li>
+    </ol>
+  </nav>
+</body>
+</html>
+{{end}}
+
+{{- define "page" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>Page {{.Number}}</title>
+  <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: 100%; height: 100%; }</style>
+</head>
+<body><img src="../{{.Image}}" alt="Page {{.Number}}"/></body>
+</html>
+{{end}}
+`))
+
+// xmlEscape escapes s for xml text and attributes.
+func xmlEscape(s string) string {
+	buf := &strings.Builder{}
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_epubExporter(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/saga.cbz": makeZip(t, map[string]string{
+			"002.png":       string(makePNG(t, 30, 20)),
+			"001.png":       string(makePNG(t, 20, 30)),
+			"ComicInfo.xml": "<ComicInfo><Series>Saga &amp; Co</Series><Number>1</Number><Manga>YesAndRightToLeft</Manga></ComicInfo>",
+		}),
+	})
+	require.NoError(t, err)
+
+	e, err := newEPUBExporter("/books", "auto", "kobo")
+	require.NoError(t, err)
+	e.fs, e.logger = fsys, testLogger{t}
+	require.NoError(t, e.run(context.Background(), []string{"/comics"}))
+
+	data, err := hackpadfs.ReadFile(fsys, "books/saga.kepub.epub")
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Equal(t, "mimetype", zr.File[0].Name)
+	require.Equal(t, zip.Store, zr.File[0].Method)
+
+	mimetype, err := fs.ReadFile(zr, "mimetype")
+	require.NoError(t, err)
+	require.Equal(t, "application/epub+zip", string(mimetype))
+	page, err := fs.ReadFile(zr, "OEBPS/pages/0001.xhtml")
+	require.NoError(t, err)
+	require.Contains(t, string(page), `content="width=20, height=30"`)
+	opf, err := fs.ReadFile(zr, "OEBPS/content.opf")
+	require.NoError(t, err)
+	require.Contains(t, string(opf), "<dc:title>Saga &amp; Co 1</dc:title>")
+	require.Contains(t, string(opf), `<spine page-progression-direction="rtl">`)
+	require.Contains(t, string(opf), `<item id="image-1" href="images/0001.png" media-type="image/png" properties="cover-image"/>`)
+	require.NotContains(t, string(opf), "fixed-layout")
+	_, err = fs.Stat(zr, "OEBPS/images/0002.png")
+	require.NoError(t, err)
+}
+
+func Test_newEPUBExporter(t *testing.T) {
+	_, err := newEPUBExporter("", "up", "")
+	require.Error(t, err)
+	_, err = newEPUBExporter("", "ltr", "nook")
+	require.Error(t, err)
+	e, err := newEPUBExporter("", "ltr", "")
+	require.NoError(t, err)
+	require.Equal(t, "comics/saga.epub", e.epubPath("/comics/saga.cbr"))
+}
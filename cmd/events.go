@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// logFormats are the values --log-format accepts.
+var logFormats = map[string]bool{"text": true, "json": true}
+
+// progressFormats are the values --progress-format accepts.
+var progressFormats = map[string]bool{"text": true, "ndjson": true}
+
+// logEvent is one line of --log-format json output.
+type logEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Code is the warning or error code, see codes.go
+	Code   string `json:"code,omitempty"`
+	File   string `json:"file,omitempty"`
+	Output string `json:"output,omitempty"`
+	// Library is the path File was found under, for telling apart the
+	// collections of a batch
+	Library string `json:"library,omitempty"`
+	// BytesIn and BytesOut are the sizes of the source and the cbz
+	BytesIn  int64 `json:"bytes_in,omitempty"`
+	BytesOut int64 `json:"bytes_out,omitempty"`
+	// Duration is in seconds
+	Duration  float64 `json:"duration,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	Converted int     `json:"converted,omitempty"`
+	Failed    int     `json:"failed,omitempty"`
+	// Entry is the entry of File a page event is about, Page how many of
+	// its Pages entries have been started on
+	Entry string `json:"entry,omitempty"`
+	Page  int    `json:"page,omitempty"`
+	Pages int    `json:"pages,omitempty"`
+}
+
+// eventLog writes logEvents as JSON lines. A nil eventLog drops them, so
+// callers don't have to check whether JSON output was asked for.
+type eventLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// progress adds start and page events for each file, for
+	// --progress-format ndjson
+	progress bool
+}
+
+func newEventLog(w io.Writer) *eventLog {
+	return &eventLog{enc: json.NewEncoder(w)}
+}
+
+func (l *eventLog) emit(e logEvent) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(e)
+}
+
+// dialProgressSocket connects to the --progress-socket at addr, host:port
+// for TCP and anything else a unix socket.
+func dialProgressSocket(addr string) (net.Conn, error) {
+	network := "unix"
+	if _, _, err := net.SplitHostPort(addr); err == nil && !strings.ContainsAny(addr, `/\`) {
+		network = "tcp"
+	}
+	conn, err := net.Dial(network, addr)
+	return conn, errors.Wrap(err, "connecting to --progress-socket")
+}
+
+// watchProgress emits a start event for cbrFile, and a page event as each
+// of its entries is started on, when progress events were asked for.
+func (c *converter) watchProgress(cbrFile string, size int64, p *cbr2cbz.Progress) {
+	if c.events == nil || !c.events.progress {
+		return
+	}
+	c.events.emit(logEvent{Action: "start", File: cbrFile, Library: c.roots[cbrFile], BytesIn: size})
+	p.OnEntry = func() {
+		c.events.emit(logEvent{Action: "page", File: cbrFile, Entry: p.Entry(), Page: int(p.Opened.Load()), Pages: int(p.Entries.Load())})
+	}
+}
+
+// fileEvent describes how converting cbrFile to cbzFile went. Claimed files
+// and ones whose cbz was kept are reported as skipped.
+func (c *converter) fileEvent(cbrFile string, cbzFile string, bytesIn int64, took time.Duration, err error) logEvent {
+	e := logEvent{File: cbrFile, Output: cbzFile, Library: c.roots[cbrFile], BytesIn: bytesIn, Duration: took.Seconds()}
+	switch {
+	case isSkip(err):
+		e.Action, e.Output = "skip", ""
+		e.Code, e.Error = errorCode(err), err.Error()
+	case err != nil:
+		e.Action, e.Output = "fail", ""
+		e.Code, e.Error = errorCode(err), err.Error()
+	default:
+		e.Action = "convert"
+		if info, statErr := hackpadfs.Stat(c.fs, pathToFsPath(cbzFile)); statErr == nil && !info.IsDir() {
+			e.BytesOut = info.Size()
+		}
+	}
+	return e
+}
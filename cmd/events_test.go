@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_eventLog(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/good.cbr":   realCBRContents,
+		"library/broken.cbr": []byte("not a rar"),
+	})
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	c := &converter{fs: fsys, logger: testLogger{t}, events: newEventLog(out)}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	events := map[string]logEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var e logEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &e), line)
+		events[e.Action+" "+e.File] = e
+	}
+	require.Len(t, events, 4)
+
+	good := events["convert /library/good.cbr"]
+	require.Equal(t, "/library/good.cbz", good.Output)
+	require.Equal(t, int64(len(realCBRContents)), good.BytesIn)
+	require.NotZero(t, good.BytesOut)
+	require.Empty(t, good.Error)
+	require.Equal(t, "/library", good.Library)
+
+	broken := events["fail /library/broken.cbr"]
+	require.NotEmpty(t, broken.Error)
+	require.Equal(t, cbr2cbz.CodeNotArchive, broken.Code)
+	require.Empty(t, broken.Output)
+	require.Equal(t, "/library", broken.Library)
+
+	dropped := events["warning /library/good.cbr"]
+	require.Equal(t, cbr2cbz.CodeEntryDropped, dropped.Code)
+	require.Contains(t, dropped.Error, "page1.txt")
+	require.Equal(t, "/library", dropped.Library)
+
+	batch := events["batch "]
+	require.Equal(t, 1, batch.Converted)
+	require.Equal(t, 1, batch.Failed)
+}
+
+func Test_eventLog_progress(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/book.cbt": makeTar(t, map[string]string{"001.jpg": "first", "002.jpg": "second"}),
+	})
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	events := newEventLog(out)
+	events.progress = true
+	c := &converter{fs: fsys, logger: testLogger{t}, events: events}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	actions := []string{}
+	pages := []logEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var e logEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &e), line)
+		actions = append(actions, e.Action)
+		if e.Action == "page" {
+			pages = append(pages, e)
+		}
+	}
+	require.Equal(t, []string{"start", "page", "page", "convert", "batch"}, actions)
+	require.Equal(t, "/library/book.cbt", pages[0].File)
+	require.Equal(t, "001.jpg", pages[0].Entry)
+	require.Equal(t, 1, pages[0].Page)
+	require.Equal(t, 2, pages[1].Page)
+	require.Equal(t, 2, pages[1].Pages)
+}
+
+func Test_dialProgressSocket(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "progress.sock")
+	l, err := net.Listen("unix", addr)
+	require.NoError(t, err)
+	defer l.Close()
+
+	conn, err := dialProgressSocket(addr)
+	require.NoError(t, err)
+	newEventLog(conn).emit(logEvent{Action: "batch", Converted: 1})
+	conn.Close()
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+	var e logEvent
+	require.NoError(t, json.NewDecoder(server).Decode(&e))
+	require.Equal(t, "batch", e.Action)
+
+	_, err = dialProgressSocket(filepath.Join(t.TempDir(), "missing.sock"))
+	require.ErrorContains(t, err, "--progress-socket")
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import "github.com/pkg/errors"
+
+// Exit codes of convert and oneshot, so automation can tell a clean run from
+// one that left files behind.
+const (
+	exitOK = 0
+	// exitFatal is for errors that stopped the batch, or kept it from
+	// starting
+	exitFatal = 1
+	// exitPartial is for batches where some files failed, or a --qa-sample
+	// check did
+	exitPartial = 2
+	// exitNothing is for paths without anything to convert
+	exitNothing = 3
+	// exitStopped is for batches that reached --stop-after or --stop-at,
+	// or were interrupted, with files left
+	exitStopped = 4
+)
+
+var (
+	failFast    bool
+	maxFailures int
+)
+
+// errNoFiles is returned when the paths have nothing to convert.
+var errNoFiles = errors.New("No files to convert!")
+
+// exitCode is what to exit with after runConvert returned err.
+func (c *converter) exitCode(err error) int {
+	switch {
+	case errors.Is(err, errNoFiles):
+		return exitNothing
+	case err != nil:
+		return exitFatal
+	case len(c.failed) > 0, c.qa != nil && c.qa.Failed > 0:
+		return exitPartial
+	case c.notStarted > 0:
+		return exitStopped
+	}
+	return exitOK
+}
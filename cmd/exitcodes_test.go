@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		failed map[string]error
+		qa     *qaReport
+		left   int
+		want   int
+	}{
+		{name: "clean", want: exitOK},
+		{name: "nothing to convert", err: errors.Wrap(errNoFiles, "finding files and sizes"), want: exitNothing},
+		{name: "fatal", err: errors.New("error looking up path"), want: exitFatal},
+		{name: "some failed", failed: map[string]error{"/library/a.cbr": errors.New("bad")}, want: exitPartial},
+		{name: "qa failed", qa: &qaReport{Failed: 1}, want: exitPartial},
+		{name: "stopped at deadline", left: 3, want: exitStopped},
+		{name: "failed and stopped", failed: map[string]error{"/library/a.cbr": errors.New("bad")}, left: 3, want: exitPartial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &converter{failed: tt.failed, qa: tt.qa, notStarted: tt.left}
+			require.Equal(t, tt.want, c.exitCode(tt.err))
+		})
+	}
+}
+
+func Test_maxFailures(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr": []byte("not yet downloaded"),
+		"library/b.cbr": []byte("not yet downloaded"),
+		"library/c.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, jobs: 1, maxFailures: 1}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.failed, 1)
+	require.Empty(t, c.converted)
+	require.Equal(t, exitPartial, c.exitCode(nil))
+
+	c.maxFailures = 2
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.failed, 2)
+	require.Empty(t, c.converted)
+
+	c.maxFailures = 0
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.failed, 2)
+	require.Equal(t, []string{"/library/c.cbz"}, c.converted)
+}
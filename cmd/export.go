@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportOut    string
+	exportImages cbr2cbz.ImageOptions
+	exportFrom   []string
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export <src> <dst>",
+	Short: "Fills a device with part of the library, or exports comics to other formats",
+	Long: `Copies as much of the library under src into dst as fits in --max-total, converting
+and re-encoding for the device with --profile, for loading an SD card before a trip.
+
+Comics go on newest first, or by name with --order name, with the --series given
+going first in reading order. --interactive lists the series and asks which to take.
+--reading-list takes only the comics on a ComicRack .cbl or a text file with a
+"Series #number" per line, in the order of the list.
+Anything that doesn't fit is left out. Running it again keeps what is already on
+dst and up to date; what an earlier export put there that wasn't picked this time
+counts towards the total, or is removed with --delete.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeDirs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		maxTotal, err := humanize.ParseBytes(exportMaxTotal)
+		if err != nil || maxTotal == 0 {
+			logger.Fatalf("invalid --max-total %q", exportMaxTotal)
+		}
+		if err := applyImageProfile(cmd, exportProfile, &exportImages); err != nil {
+			logger.Fatal(err)
+		}
+		var list *readingList
+		if exportReadingList != "" {
+			if len(exportSeries) > 0 {
+				logger.Fatal("--reading-list can't be combined with --series")
+			}
+			list, err = loadReadingList(exportReadingList)
+			if err != nil {
+				logger.Fatal(err)
+			}
+		}
+		sources, err := sourceExtensions(exportFrom)
+		if err != nil {
+			logger.Fatal(errors.Wrap(err, "parsing --from"))
+		}
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		src, err := localDir(fsys, args[0], false)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		dst, err := localDir(fsys, args[1], true)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		s, err := newSyncer(src, dst, logger, exportImages, sources)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		s.delete = exportDelete
+		s.dryRun = exportDryRun
+
+		e := &exportFiller{
+			syncer:      s,
+			maxTotal:    maxTotal,
+			order:       exportOrder,
+			series:      exportSeries,
+			interactive: exportInteractive,
+			list:        list,
+			in:          os.Stdin,
+			out:         os.Stdout,
+		}
+		err = e.run(cmd.Context())
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+var exportPDFCmd = &cobra.Command{
+	Use:   "pdf [paths...]",
+	Short: "Writes a PDF with one page per image for each cbz or cbr",
+	Long: `Writes a PDF with one page per image for each cbz or cbr, for devices and apps that only read PDF.
+
+Each page is the size of its image and pages keep the order they have in the archive.
+The PDF goes next to the archive unless --out is given; the archive itself is left alone.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		e := &pdfExporter{fs: fsys, logger: logger, outDir: exportOut}
+		err = e.run(cmd.Context(), args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportPDFCmd)
+
+	exportPDFCmd.Flags().StringVar(&exportOut, "out", "", "directory to write the PDFs to, next to each archive if unset")
+
+	addImageFlags(exportCmd, &exportImages)
+	exportCmd.Flags().StringVar(&exportMaxTotal, "max-total", "", "how much of dst to fill, e.g. 200GB")
+	exportCmd.Flags().StringVar(&exportProfile, "profile", "", "image profile of the device (kobo, kindle, tablet, eink)")
+	exportCmd.RegisterFlagCompletionFunc("profile", completeImageProfiles)
+	exportCmd.Flags().StringSliceVar(&exportFrom, "from", defaultSources, "types of file to convert: cbr, cb7, cbt and pdf")
+	exportCmd.Flags().StringVar(&exportOrder, "order", "recent", "which comics go first: recent or name")
+	exportCmd.Flags().StringSliceVar(&exportSeries, "series", nil, "series (folders) to put on first, in this order")
+	exportCmd.Flags().StringVar(&exportReadingList, "reading-list", "", "only export the comics on this .cbl or text reading list, in its order")
+	exportCmd.Flags().BoolVarP(&exportInteractive, "interactive", "i", false, "ask which series to export")
+	exportCmd.Flags().BoolVar(&exportDelete, "delete", false, "remove what earlier exports put on dst that wasn't picked this time")
+	exportCmd.Flags().BoolVarP(&exportDryRun, "dry-run", "n", false, "only log what would be exported, guessing sizes from the originals")
+	exportCmd.MarkFlagRequired("max-total")
+}
+
+type pdfExporter struct {
+	fs      hackpadfs.FS
+	logger  logger
+	entries cbr2cbz.EntryFilter
+	outDir  string
+}
+
+func (e *pdfExporter) run(ctx context.Context, paths []string) error {
+	return exportArchives(ctx, e.fs, e.logger, e.outDir, paths, e.export)
+}
+
+// exportArchives calls export with each cbz, cbr, cb7 and cbt under paths,
+// creating outDir first if given.
+func exportArchives(ctx context.Context, fsys hackpadfs.FS, logger logger, outDir string, paths []string, export func(ctx context.Context, file string) error) error {
+	files, err := findArchives(fsys, paths, "cbz or cbr", ".cbz", ".cbr", ".cb7", ".cbt")
+	if err != nil {
+		return err
+	}
+
+	if outDir != "" {
+		err = hackpadfs.MkdirAll(fsys, pathToFsPath(outDir), 0755)
+		if err != nil {
+			return errors.Wrap(err, "creating output directory")
+		}
+	}
+
+	failed := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := export(ctx, file)
+		if err != nil {
+			logger.Printf("Error exporting %s - Skipping...%s\n", file, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+// pdfPath is where the PDF for file goes.
+func (e *pdfExporter) pdfPath(file string) string {
+	return exportPath(file, e.outDir, ".pdf")
+}
+
+// exportPath is where file exported with the extension ext goes, next to
+// it unless outDir is given.
+func exportPath(file string, outDir string, ext string) string {
+	name := strings.TrimSuffix(path.Base(pathToFsPath(file)), path.Ext(file)) + ext
+	if outDir == "" {
+		return path.Join(path.Dir(pathToFsPath(file)), name)
+	}
+	return path.Join(pathToFsPath(outDir), name)
+}
+
+func (e *pdfExporter) export(ctx context.Context, file string) error {
+	archive, err := openArchive(ctx, e.fs, pathToFsPath(file))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	pages, err := archive.pages(e.entries)
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return errors.New("no pages found")
+	}
+
+	images := make([]io.Reader, len(pages))
+	for i, page := range pages {
+		images[i] = &lazyEntry{fs: archive, name: page}
+	}
+
+	out := e.pdfPath(file)
+	tmp := tempName(out)
+	f, err := hackpadfs.Create(e.fs, tmp)
+	if err != nil {
+		return errors.Wrap(err, "creating pdf")
+	}
+	w, ok := f.(io.Writer)
+	if !ok {
+		f.Close()
+		hackpadfs.Remove(e.fs, tmp)
+		return errors.New("destination isn't a writable filesystem")
+	}
+
+	// Pos full makes every page the size of its image
+	imp := pdfcpu.DefaultImportConfig()
+	imp.Pos = types.Full
+	err = api.ImportImages(nil, w, images, imp, nil)
+	for _, r := range images {
+		r.(*lazyEntry).Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = hackpadfs.Rename(e.fs, tmp, out)
+	}
+	if err != nil {
+		hackpadfs.Remove(e.fs, tmp)
+		return errors.Wrap(err, "writing pdf")
+	}
+
+	info, err := hackpadfs.Stat(e.fs, out)
+	if err != nil {
+		return errors.Wrap(err, "stating pdf")
+	}
+	e.logger.Printf("Exported %s to %s, %d pages (%s)\n", file, out, len(pages), formatBytes(uint64(info.Size())))
+	return nil
+}
+
+// lazyEntry opens an archive entry on the first read, so only the page
+// being added to the PDF is held open at a time.
+type lazyEntry struct {
+	fs   fs.FS
+	name string
+	f    fs.File
+	done bool
+}
+
+func (l *lazyEntry) Read(p []byte) (int, error) {
+	if l.done {
+		return 0, io.EOF
+	}
+	if l.f == nil {
+		f, err := l.fs.Open(l.name)
+		if err != nil {
+			return 0, errors.Wrapf(err, "opening %s", l.name)
+		}
+		l.f = f
+	}
+	n, err := l.f.Read(p)
+	if err == io.EOF {
+		l.done = true
+		l.Close()
+	}
+	return n, err
+}
+
+func (l *lazyEntry) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
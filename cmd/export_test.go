@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pdfExporter(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/saga.cbz": makeZip(t, map[string]string{
+			"002.png":       string(makePNG(t, 30, 20)),
+			"001.png":       string(makePNG(t, 20, 30)),
+			"ComicInfo.xml": "<ComicInfo/>",
+		}),
+	})
+	require.NoError(t, err)
+
+	e := &pdfExporter{fs: fsys, logger: testLogger{t}, outDir: "/pdfs"}
+	require.NoError(t, e.run(context.Background(), []string{"/comics"}))
+
+	data, err := hackpadfs.ReadFile(fsys, "pdfs/saga.pdf")
+	require.NoError(t, err)
+	conf := model.NewDefaultConfiguration()
+	doc, err := api.ReadValidateAndOptimize(bytes.NewReader(data), conf)
+	require.NoError(t, err)
+	require.Equal(t, 2, doc.PageCount)
+
+	dims, err := doc.PageDims()
+	require.NoError(t, err)
+	require.Equal(t, 20.0, dims[0].Width, "first page is 001.png")
+	require.Equal(t, 30.0, dims[1].Width)
+
+	_, err = hackpadfs.Stat(fsys, "comics/saga.cbz")
+	require.NoError(t, err)
+}
@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	exportMaxTotal    string
+	exportProfile     string
+	exportOrder       string
+	exportSeries      []string
+	exportInteractive bool
+	exportDelete      bool
+	exportDryRun      bool
+	exportReadingList string
+)
+
+// exportItem is one comic that could go onto the device.
+type exportItem struct {
+	name    string
+	target  string
+	action  syncAction
+	series  string
+	size    int64
+	modTime time.Time
+}
+
+// exportFiller fills dst with comics from src, in order, until maxTotal
+// bytes are used up. What it wrote is tracked in the same manifest sync
+// uses, so exporting again only redoes what changed.
+type exportFiller struct {
+	*syncer
+	maxTotal    uint64
+	order       string
+	series      []string
+	interactive bool
+	// list, if set, is the only comics to export and their order
+	list *readingList
+	in   io.Reader
+	out  io.Writer
+}
+
+// candidates is every comic under src, ordered by e.order with the series
+// in e.series first, or the ones on e.list in its order.
+func (e *exportFiller) candidates() ([]exportItem, error) {
+	files, err := e.sourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	if e.list != nil {
+		for _, book := range e.list.missing(files) {
+			e.logger.Printf("%s is on the reading list but not in the library\n", book)
+		}
+		files = e.list.filter(files)
+	}
+	items := []exportItem{}
+	for _, name := range files {
+		target, action := e.plan(name)
+		if action == syncCopy && !strings.EqualFold(path.Ext(name), ".cbz") {
+			// covers, nfo files and the like aren't worth the space
+			continue
+		}
+		info, err := fs.Stat(e.src, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stating %s", name)
+		}
+		items = append(items, exportItem{
+			name:    name,
+			target:  target,
+			action:  action,
+			series:  path.Dir(name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	switch {
+	case e.list != nil:
+		// already in reading order
+	case e.order == "recent":
+		sort.SliceStable(items, func(i, j int) bool { return items[i].modTime.After(items[j].modTime) })
+	case e.order == "name":
+		sort.SliceStable(items, func(i, j int) bool { return items[i].name < items[j].name })
+	default:
+		return nil, errors.Errorf("unknown --order %q, expected recent or name", e.order)
+	}
+
+	if len(e.series) > 0 {
+		rank := func(item exportItem) int {
+			for i, s := range e.series {
+				if strings.EqualFold(s, item.series) || strings.EqualFold(s, path.Base(item.series)) {
+					return i
+				}
+			}
+			return len(e.series)
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			ri, rj := rank(items[i]), rank(items[j])
+			if ri != rj {
+				return ri < rj
+			}
+			// a prioritised series is read in order
+			return ri < len(e.series) && items[i].name < items[j].name
+		})
+	}
+	return items, nil
+}
+
+// choose asks which series to export, listing them in the order items
+// has them in. The answer is a list of numbers and ranges, like 1,3-5, in
+// the order they should go on; nothing keeps every series.
+func (e *exportFiller) choose(items []exportItem) ([]exportItem, error) {
+	series := []string{}
+	sizes := map[string]int64{}
+	counts := map[string]int{}
+	for _, item := range items {
+		if counts[item.series] == 0 {
+			series = append(series, item.series)
+		}
+		counts[item.series]++
+		sizes[item.series] += item.size
+	}
+	for i, s := range series {
+		fmt.Fprintf(e.out, "%3d) %s, %d comics (%s)\n", i+1, s, counts[s], formatBytes(uint64(sizes[s])))
+	}
+	fmt.Fprintf(e.out, "Series to export, e.g. 1,3-5 [all]: ")
+	answer, err := bufio.NewReader(e.in).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+		return nil, errors.Wrap(err, "reading the answer")
+	}
+	picked, err := parseSelection(strings.TrimSpace(answer), len(series))
+	if err != nil {
+		return nil, err
+	}
+	if picked == nil {
+		return items, nil
+	}
+
+	chosen := []exportItem{}
+	for _, i := range picked {
+		for _, item := range items {
+			if item.series == series[i] {
+				chosen = append(chosen, item)
+			}
+		}
+	}
+	return chosen, nil
+}
+
+// parseSelection turns 1,3-5 into the indexes 0, 2, 3 and 4, out of n.
+// An empty answer is nil, everything.
+func parseSelection(answer string, n int) ([]int, error) {
+	if answer == "" {
+		return nil, nil
+	}
+	picked := []int{}
+	seen := map[int]bool{}
+	for _, part := range strings.Split(answer, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(from))
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if err != nil || first < 1 || last > n || first > last {
+			return nil, errors.Errorf("%q isn't a series number or range between 1 and %d", part, n)
+		}
+		for i := first - 1; i < last; i++ {
+			if !seen[i] {
+				seen[i] = true
+				picked = append(picked, i)
+			}
+		}
+	}
+	return picked, nil
+}
+
+func (e *exportFiller) run(ctx context.Context) error {
+	manifest, err := e.readManifest()
+	if err != nil {
+		return err
+	}
+	items, err := e.candidates()
+	if err != nil {
+		return err
+	}
+	if e.interactive {
+		items, err = e.choose(items)
+		if err != nil {
+			return err
+		}
+	}
+
+	selected := map[string]bool{}
+	for _, item := range items {
+		selected[item.target] = true
+	}
+
+	// earlier exports no longer picked go, or take up their space
+	var used uint64
+	targets := make([]string, 0, len(manifest.Files))
+	for target := range manifest.Files {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		if selected[target] {
+			continue
+		}
+		if !e.delete {
+			if info, err := fs.Stat(e.dst, target); err == nil {
+				used += uint64(info.Size())
+			}
+			continue
+		}
+		if e.dryRun {
+			e.logger.Printf("Would remove %s\n", target)
+			continue
+		}
+		err := removeIfExists(e.dst, target)
+		if err != nil {
+			return errors.Wrapf(err, "removing %s", target)
+		}
+		e.logger.Printf("Removed %s, it wasn't picked this time\n", target)
+		delete(manifest.Files, target)
+	}
+	if used >= e.maxTotal {
+		return errors.Errorf("earlier exports already use %s of %s, --delete makes room", formatBytes(used), formatBytes(e.maxTotal))
+	}
+
+	var exported, skipped, failed int
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		room := e.maxTotal - used
+		// copies end up as big as they are now
+		if item.action == syncCopy && uint64(item.size) > room && !e.dryRun {
+			err := e.leaveOut(manifest, item.target)
+			if err != nil {
+				return err
+			}
+			skipped++
+			continue
+		}
+		if e.dryRun {
+			// conversions are guessed at the size of the original
+			if uint64(item.size) > room {
+				skipped++
+				continue
+			}
+			e.logger.Printf("Would export %s\n", item.name)
+			used += uint64(item.size)
+			exported++
+			continue
+		}
+
+		_, err := e.syncOne(ctx, manifest, item.name, item.target, item.action)
+		if err != nil {
+			e.logger.Printf("[%s] Unable to export %s - Skipping...%s\n", errorCode(err), item.name, err.Error())
+			failed++
+			continue
+		}
+		info, err := fs.Stat(e.dst, item.target)
+		if err != nil {
+			return errors.Wrapf(err, "stating %s", item.target)
+		}
+		if uint64(info.Size()) > room {
+			err := e.leaveOut(manifest, item.target)
+			if err != nil {
+				return err
+			}
+			skipped++
+			continue
+		}
+		used += uint64(info.Size())
+		exported++
+	}
+
+	e.logger.Printf("Exported %d comics using %s of %s, %d didn't fit, %d failed\n",
+		exported, formatBytes(used), formatBytes(e.maxTotal), skipped, failed)
+	if !e.dryRun {
+		err = e.writeManifest(manifest)
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+// leaveOut removes target from dst when an earlier export wrote it, or it
+// was just written, and it doesn't fit anymore.
+func (e *exportFiller) leaveOut(manifest *syncManifest, target string) error {
+	if _, ok := manifest.Files[target]; !ok {
+		return nil
+	}
+	err := removeIfExists(e.dst, target)
+	if err != nil {
+		return errors.Wrapf(err, "removing %s", target)
+	}
+	delete(manifest.Files, target)
+	return nil
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSelection(t *testing.T) {
+	tests := []struct {
+		answer  string
+		want    []int
+		wantErr bool
+	}{
+		{answer: "", want: nil},
+		{answer: "2", want: []int{1}},
+		{answer: "3, 1-2", want: []int{2, 0, 1}},
+		{answer: "1-3,2", want: []int{0, 1, 2}},
+		{answer: "4", wantErr: true},
+		{answer: "3-1", wantErr: true},
+		{answer: "saga", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			got, err := parseSelection(tt.answer, 3)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// newTestExport is a library of plain cbz files, each size bytes, the
+// later in the list the newer.
+func newTestExport(t *testing.T, names []string, size int) *exportFiller {
+	t.Helper()
+	files := filenameBytes{"Saga/cover.jpg": []byte("cover")}
+	for _, name := range names {
+		files[name] = bytes.Repeat([]byte("x"), size)
+	}
+	src, err := setupFS(t, files)
+	require.NoError(t, err)
+	for i, name := range names {
+		mtime := time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		require.NoError(t, hackpadfs.Chtimes(src, name, mtime, mtime))
+	}
+	dst, err := setupFS(t, filenameBytes{})
+	require.NoError(t, err)
+
+	s, err := newSyncer(src, dst, testLogger{t}, cbr2cbz.ImageOptions{}, map[string]bool{".cbr": true})
+	require.NoError(t, err)
+	return &exportFiller{syncer: s, order: "recent"}
+}
+
+func exportedFiles(t *testing.T, fsys hackpadfs.FS) []string {
+	t.Helper()
+	files := []string{}
+	err := fs.WalkDir(fsys, ".", func(name string, de fs.DirEntry, err error) error {
+		if err == nil && !de.IsDir() && name != syncManifestName {
+			files = append(files, name)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func Test_exportFiller(t *testing.T) {
+	names := []string{"Saga/001.cbz", "Saga/002.cbz", "Monstress/001.cbz", "Monstress/002.cbz"}
+
+	t.Run("candidates", func(t *testing.T) {
+		e := newTestExport(t, names, 10)
+		items, err := e.candidates()
+		require.NoError(t, err)
+		got := []string{}
+		for _, item := range items {
+			got = append(got, item.name)
+		}
+		require.Equal(t, []string{"Monstress/002.cbz", "Monstress/001.cbz", "Saga/002.cbz", "Saga/001.cbz"}, got)
+
+		e.series = []string{"saga"}
+		items, err = e.candidates()
+		require.NoError(t, err)
+		got = got[:0]
+		for _, item := range items {
+			got = append(got, item.name)
+		}
+		require.Equal(t, []string{"Saga/001.cbz", "Saga/002.cbz", "Monstress/002.cbz", "Monstress/001.cbz"}, got)
+	})
+
+	t.Run("reading list", func(t *testing.T) {
+		e := newTestExport(t, names, 10)
+		e.maxTotal = 100
+		e.list = &readingList{books: []readingBook{{series: "Saga", number: "2"}, {series: "Monstress"}}}
+		require.NoError(t, e.run(context.Background()))
+		require.Equal(t, []string{"Monstress/001.cbz", "Monstress/002.cbz", "Saga/002.cbz"}, exportedFiles(t, e.dst))
+
+		items, err := e.candidates()
+		require.NoError(t, err)
+		got := []string{}
+		for _, item := range items {
+			got = append(got, item.name)
+		}
+		require.Equal(t, []string{"Saga/002.cbz", "Monstress/001.cbz", "Monstress/002.cbz"}, got)
+	})
+
+	t.Run("fills up to the cap", func(t *testing.T) {
+		e := newTestExport(t, names, 10)
+		e.maxTotal = 20
+		require.NoError(t, e.run(context.Background()))
+		require.Equal(t, []string{"Monstress/001.cbz", "Monstress/002.cbz"}, exportedFiles(t, e.dst))
+
+		// what was exported before gives way to what goes first now
+		e.series = []string{"Saga"}
+		require.NoError(t, e.run(context.Background()))
+		require.Equal(t, []string{"Saga/001.cbz", "Saga/002.cbz"}, exportedFiles(t, e.dst))
+	})
+
+	t.Run("interactive", func(t *testing.T) {
+		e := newTestExport(t, names, 10)
+		e.maxTotal = 20
+		e.interactive = true
+		e.in = strings.NewReader("2\n")
+		out := &bytes.Buffer{}
+		e.out = out
+		require.NoError(t, e.run(context.Background()))
+		require.Contains(t, out.String(), "  1) Monstress, 2 comics (20 B)")
+		require.Contains(t, out.String(), "  2) Saga, 2 comics (20 B)")
+		require.Equal(t, []string{"Saga/001.cbz", "Saga/002.cbz"}, exportedFiles(t, e.dst))
+
+		// series that weren't picked again count unless they are deleted
+		e.in = strings.NewReader("1\n")
+		require.ErrorContains(t, e.run(context.Background()), "already use 20 B")
+		e.in = strings.NewReader("1\n")
+		e.delete = true
+		require.NoError(t, e.run(context.Background()))
+		require.Equal(t, []string{"Monstress/001.cbz", "Monstress/002.cbz"}, exportedFiles(t, e.dst))
+	})
+}
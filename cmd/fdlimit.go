@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// filesPerJob is about how many files one conversion holds open at
+	// once: the source, an unwrapped copy, the cbz, a scratch file, a claim
+	// and a sidecar.
+	filesPerJob = 6
+	// filesReserved is left for the log, stdio, watchers and the runtime.
+	filesReserved = 32
+)
+
+// jobsForFileLimit is how many conversions can run at once without going
+// over limit open files, never less than one. A zero limit means unknown.
+func jobsForFileLimit(jobs int, limit uint64) int {
+	if limit == 0 {
+		return jobs
+	}
+	fit := 1
+	if limit > filesReserved {
+		fit = max(1, int((limit-filesReserved)/filesPerJob))
+	}
+	return min(jobs, fit)
+}
+
+// isFileLimitError reports whether err is the process or system running out
+// of file descriptors.
+func isFileLimitError(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// explainFileLimit turns running out of file descriptors into something
+// that says what to do about it, other errors are returned as they are.
+func explainFileLimit(err error) error {
+	if !isFileLimitError(err) {
+		return err
+	}
+	if limit := openFileLimit(); limit > 0 {
+		return errors.Wrapf(err, "out of file descriptors (limit %d), lower --jobs or raise the limit with ulimit -n", limit)
+	}
+	return errors.Wrap(err, "out of file descriptors, lower --jobs")
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_jobsForFileLimit(t *testing.T) {
+	tests := []struct {
+		jobs  int
+		limit uint64
+		want  int
+	}{
+		{jobs: 8, limit: 0, want: 8},
+		{jobs: 8, limit: 1024, want: 8},
+		{jobs: 64, limit: 256, want: 37},
+		{jobs: 4, limit: 16, want: 1},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, jobsForFileLimit(tt.jobs, tt.limit), "%d jobs, limit %d", tt.jobs, tt.limit)
+	}
+}
+
+func Test_explainFileLimit(t *testing.T) {
+	err := errors.Wrap(&os.PathError{Op: "open", Path: "a.cbr", Err: syscall.EMFILE}, "trying to open cbr")
+	require.ErrorContains(t, explainFileLimit(err), "out of file descriptors")
+	require.ErrorIs(t, explainFileLimit(err), syscall.EMFILE)
+	require.True(t, isIOError(err))
+
+	other := errors.New("not a rar, 7z, tar or pdf file")
+	require.Equal(t, other, explainFileLimit(other))
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package cmd
+
+import "syscall"
+
+// openFileLimit is how many files the process may have open, 0 if unknown.
+// Go already raises the soft limit to the hard one at startup.
+func openFileLimit() uint64 {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0
+	}
+	return uint64(rlim.Cur)
+}
@@ -0,0 +1,7 @@
+package cmd
+
+// openFileLimit is 0 on Windows, which has no small per process handle
+// limit worth planning around.
+func openFileLimit() uint64 {
+	return 0
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+var (
+	minSizeFlag   string
+	maxSizeFlag   string
+	newerThanFlag string
+	olderThanFlag string
+)
+
+// fileFilter scopes a run by the size and modification time of files, for
+// only converting what was added lately or skipping archives too small to
+// be anything but broken.
+type fileFilter struct {
+	minSize   uint64
+	maxSize   uint64
+	newerThan time.Time
+	olderThan time.Time
+}
+
+// newFileFilter parses the flags, returning nil when none are set. Times are
+// ages like 36h, 7d or 2w before now, or dates like 2024-03-01.
+func newFileFilter(minSize, maxSize, newerThan, olderThan string, now time.Time) (*fileFilter, error) {
+	if minSize == "" && maxSize == "" && newerThan == "" && olderThan == "" {
+		return nil, nil
+	}
+	f := &fileFilter{}
+	var err error
+	if minSize != "" {
+		f.minSize, err = humanize.ParseBytes(minSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --min-size")
+		}
+	}
+	if maxSize != "" {
+		f.maxSize, err = humanize.ParseBytes(maxSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --max-size")
+		}
+	}
+	if newerThan != "" {
+		f.newerThan, err = parseSince(newerThan, now)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --newer-than")
+		}
+	}
+	if olderThan != "" {
+		f.olderThan, err = parseSince(olderThan, now)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --older-than")
+		}
+	}
+	if f.maxSize > 0 && f.minSize > f.maxSize {
+		return nil, errors.Errorf("--min-size %s is more than --max-size %s", minSize, maxSize)
+	}
+	return f, nil
+}
+
+// parseSince is the time value stands for, an age back from now or a date.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, value, now.Location())
+		if err == nil {
+			return t, nil
+		}
+	}
+	age, err := parseAge(value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("%q is neither an age (36h, 7d, 2w) nor a date (2024-03-01)", value)
+	}
+	return now.Add(-age), nil
+}
+
+// parseAge parses a duration that may also be in days or weeks, 7d or 2w.
+func parseAge(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64); strings.HasSuffix(value, suffix) && err == nil {
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Errorf("%q isn't an age like 36h, 7d or 2w", value)
+	}
+	return age, nil
+}
+
+// allows reports whether info is in scope: no smaller than --min-size or
+// bigger than --max-size, and modified between --newer-than and
+// --older-than.
+func (f *fileFilter) allows(info fs.FileInfo) bool {
+	if f == nil {
+		return true
+	}
+	size := uint64(info.Size())
+	if size < f.minSize || (f.maxSize > 0 && size > f.maxSize) {
+		return false
+	}
+	modTime := info.ModTime()
+	if !f.newerThan.IsZero() && !modTime.After(f.newerThan) {
+		return false
+	}
+	if !f.olderThan.IsZero() && !modTime.Before(f.olderThan) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSince(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr string
+	}{
+		{value: "36h", want: now.Add(-36 * time.Hour)},
+		{value: "7d", want: time.Date(2024, time.March, 8, 12, 0, 0, 0, time.UTC)},
+		{value: "2w", want: time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)},
+		{value: "2024-03-01", want: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2024-03-01T09:30", want: time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)},
+		{value: "last tuesday", wantErr: `"last tuesday" is neither an age`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseSince(tt.value, now)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, tt.want.Equal(got), "got %s", got)
+		})
+	}
+}
+
+func Test_findFilesAndSize_fileFilter(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/old.cbr":    realCBRContents,
+		"library/new.cbr":    realCBRContents,
+		"library/broken.cbr": []byte("x"),
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, hackpadfs.Chtimes(fsys, "library/old.cbr", now, now.Add(-30*24*time.Hour)))
+
+	tests := []struct {
+		name                          string
+		minSize, newerThan, olderThan string
+		want                          []string
+	}{
+		{name: "min size", minSize: "100B", want: []string{"/library/new.cbr", "/library/old.cbr"}},
+		{name: "newer than", newerThan: "1w", want: []string{"/library/broken.cbr", "/library/new.cbr"}},
+		{name: "older than", olderThan: "1w", want: []string{"/library/old.cbr"}},
+		{name: "both", minSize: "100B", newerThan: "1w", want: []string{"/library/new.cbr"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newFileFilter(tt.minSize, "", tt.newerThan, tt.olderThan, now)
+			require.NoError(t, err)
+			c := &converter{fs: fsys, logger: testLogger{t}, fileFilter: filter}
+			require.NoError(t, c.findFilesAndSize(context.Background(), []string{"/library"}))
+			require.ElementsMatch(t, tt.want, c.cbrFiles)
+		})
+	}
+
+	_, err = newFileFilter("2MB", "1MB", "", "", now)
+	require.ErrorContains(t, err, "--min-size 2MB is more than --max-size 1MB")
+}
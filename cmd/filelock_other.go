@@ -0,0 +1,8 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package cmd
+
+// lockFile can't lock anything here, other processes aren't kept out.
+func lockFile(string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on name, creating it if needed, waiting
+// for any other process holding it. The returned func releases it.
+func lockFile(name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening lock file")
+	}
+	ol := &windows.Overlapped{}
+	err = windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "locking")
+	}
+	return func() {
+		windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+		f.Close()
+	}, nil
+}
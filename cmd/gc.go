@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var gcDelete bool
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:   "gc <src> <dst>",
+	Short: "Finds stale cbz files in a tree synced or exported from src",
+	Long: `Looks through dst, a tree sync or export writes to from src, for cbz files that
+don't belong there anymore:
+
+  orphans, written from a file in src that was since removed or renamed
+  duplicates, an issue some other cbz in the same folder is a newer copy of
+
+Orphans go by ` + syncManifestName + `, duplicates by the series, volume and
+number in the names. A file the manifest has from a source that still exists is
+never a duplicate, the next sync would only bring it back.
+
+Nothing is removed without --delete.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		src, err := localDir(fsys, args[0], false)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		dst, err := localDir(fsys, args[1], false)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		g := &collector{src: src, dst: dst, logger: logger, delete: gcDelete}
+		err = g.run()
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+
+	gcCmd.Flags().BoolVar(&gcDelete, "delete", false, "remove what was found instead of only listing it")
+}
+
+// garbage is a cbz in dst that can go.
+type garbage struct {
+	name   string
+	reason string
+	size   int64
+}
+
+// collector finds, and removes if asked to, the garbage in dst.
+type collector struct {
+	src    hackpadfs.FS
+	dst    hackpadfs.FS
+	logger logger
+	delete bool
+}
+
+func (g *collector) run() error {
+	manifest, err := readSyncManifest(g.dst)
+	if err != nil {
+		return err
+	}
+	found, err := g.find(manifest)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	removed := 0
+	for _, item := range found {
+		total += item.size
+		if !g.delete {
+			g.logger.Printf("%s %s\n", item.name, item.reason)
+			continue
+		}
+		err := removeIfExists(g.dst, item.name)
+		if err != nil {
+			return errors.Wrapf(err, "removing %s", item.name)
+		}
+		g.logger.Printf("Removed %s, %s\n", item.name, item.reason)
+		delete(manifest.Files, item.name)
+		pruneDirs(g.dst, path.Dir(item.name))
+		removed++
+	}
+
+	switch {
+	case len(found) == 0:
+		g.logger.Printf("Nothing to collect\n")
+	case !g.delete:
+		g.logger.Printf("%d stale files using %s, --delete removes them\n", len(found), formatBytes(uint64(total)))
+	default:
+		g.logger.Printf("Removed %d stale files, freeing %s\n", removed, formatBytes(uint64(total)))
+		return writeSyncManifest(g.dst, manifest)
+	}
+	return nil
+}
+
+// find is the orphans in manifest and the duplicates in dst, by name.
+func (g *collector) find(manifest *syncManifest) ([]garbage, error) {
+	found := []garbage{}
+	collected := map[string]bool{}
+	live := map[string]bool{}
+	for target, entry := range manifest.Files {
+		if !strings.EqualFold(path.Ext(target), ".cbz") {
+			continue
+		}
+		_, err := fs.Stat(g.src, entry.Source)
+		if err == nil {
+			live[target] = true
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.Wrapf(err, "stating %s", entry.Source)
+		}
+		info, err := fs.Stat(g.dst, target)
+		if err != nil {
+			// already gone, only the manifest still has it
+			continue
+		}
+		found = append(found, garbage{name: target, reason: fmt.Sprintf("was made from %s, which is gone", entry.Source), size: info.Size()})
+		collected[target] = true
+	}
+
+	// issues with more than one cbz in a folder
+	type copyInfo struct {
+		name string
+		info fs.FileInfo
+	}
+	issues := map[string][]copyInfo{}
+	err := fs.WalkDir(g.dst, ".", func(name string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() || strings.HasPrefix(de.Name(), ".") || !strings.EqualFold(path.Ext(name), ".cbz") || collected[name] {
+			return nil
+		}
+		id := comicIdentity(name)
+		if id.Number == "" {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return err
+		}
+		series := id.Series
+		if series == "" {
+			series = path.Base(path.Dir(name))
+		}
+		key := fmt.Sprintf("%s\x00%s\x00%d\x00%s", path.Dir(name), seriesKey(series), id.Volume, id.Number)
+		issues[key] = append(issues[key], copyInfo{name: name, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "looking through the destination")
+	}
+	for _, copies := range issues {
+		if len(copies) < 2 {
+			continue
+		}
+		sort.Slice(copies, func(i, j int) bool {
+			if !copies[i].info.ModTime().Equal(copies[j].info.ModTime()) {
+				return copies[i].info.ModTime().After(copies[j].info.ModTime())
+			}
+			return copies[i].name < copies[j].name
+		})
+		newest := copies[0].name
+		for _, c := range copies[1:] {
+			if live[c.name] {
+				continue
+			}
+			found = append(found, garbage{name: c.name, reason: "duplicates the newer " + newest, size: c.info.Size()})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].name < found[j].name })
+	return found, nil
+}
+
+// pruneDirs removes dir and the directories above it for as long as they
+// are empty.
+func pruneDirs(fsys hackpadfs.FS, dir string) {
+	for dir != "." {
+		entries, err := hackpadfs.ReadDir(fsys, dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if hackpadfs.Remove(fsys, dir) != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
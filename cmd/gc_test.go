@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_collector(t *testing.T) {
+	setup := func(t *testing.T) (hackpadfs.FS, hackpadfs.FS) {
+		t.Helper()
+		src, err := setupFS(t, filenameBytes{"Saga/Saga 002.cbr": []byte("rar")})
+		require.NoError(t, err)
+		dst, err := setupFS(t, filenameBytes{
+			"Saga/Saga 001.cbz":          []byte("one"),
+			"Saga/Saga 002.cbz":          []byte("two"),
+			"Saga/Saga #002 (old).cbz":   []byte("two, from before"),
+			"Saga/003.cbz":               []byte("three"),
+			"Saga/Saga 003.cbz":          []byte("three, redone"),
+			"Saga/cover.jpg":             []byte("cover"),
+			"Monstress/Monstress 01.cbz": []byte("one"),
+		})
+		require.NoError(t, err)
+		old := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		for _, name := range []string{"Saga/Saga #002 (old).cbz", "Saga/003.cbz"} {
+			require.NoError(t, hackpadfs.Chtimes(dst, name, old, old))
+		}
+		require.NoError(t, writeSyncManifest(dst, &syncManifest{Files: map[string]syncEntry{
+			"Saga/Saga 001.cbz":          {Source: "Saga/Saga 001.cbr"},
+			"Saga/Saga 002.cbz":          {Source: "Saga/Saga 002.cbr"},
+			"Monstress/Monstress 01.cbz": {Source: "Monstress/Monstress 01.cbr"},
+		}}))
+		return src, dst
+	}
+
+	t.Run("find", func(t *testing.T) {
+		src, dst := setup(t)
+		g := &collector{src: src, dst: dst, logger: testLogger{t}}
+		manifest, err := readSyncManifest(dst)
+		require.NoError(t, err)
+		found, err := g.find(manifest)
+		require.NoError(t, err)
+		require.Equal(t, []garbage{
+			{name: "Monstress/Monstress 01.cbz", reason: "was made from Monstress/Monstress 01.cbr, which is gone", size: 3},
+			{name: "Saga/003.cbz", reason: "duplicates the newer Saga/Saga 003.cbz", size: 5},
+			{name: "Saga/Saga #002 (old).cbz", reason: "duplicates the newer Saga/Saga 002.cbz", size: 16},
+			{name: "Saga/Saga 001.cbz", reason: "was made from Saga/Saga 001.cbr, which is gone", size: 3},
+		}, found)
+
+		// listing them leaves them be
+		require.NoError(t, g.run())
+		require.Len(t, filesIn(t, dst, syncManifestName), 7)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		src, dst := setup(t)
+		g := &collector{src: src, dst: dst, logger: testLogger{t}, delete: true}
+		require.NoError(t, g.run())
+		require.Equal(t, []string{"Saga/Saga 002.cbz", "Saga/Saga 003.cbz", "Saga/cover.jpg"}, filesIn(t, dst, syncManifestName))
+		_, err := hackpadfs.Stat(dst, "Monstress")
+		require.Error(t, err)
+
+		manifest, err := readSyncManifest(dst)
+		require.NoError(t, err)
+		require.Equal(t, map[string]syncEntry{"Saga/Saga 002.cbz": {Source: "Saga/Saga 002.cbr"}}, manifest.Files)
+	})
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// healthState backs the liveness and readiness endpoints of the long running
+// modes. Ready means configuration is loaded and the library is reachable;
+// live means the worker loop has checked in recently.
+type healthState struct {
+	// maxSilence is how long the worker loop may go without calling beat
+	// before it's considered hung.
+	maxSilence time.Duration
+
+	mu          sync.Mutex
+	ready       bool
+	notReadyWhy string
+	draining    bool
+	lastBeat    time.Time
+	// queued is how much work is waiting or in progress: files for watch,
+	// jobs for serve and runs for daemon
+	queued      int
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// healthStatus is what /status answers with.
+type healthStatus struct {
+	Live  bool `json:"live"`
+	Ready bool `json:"ready"`
+	// Reason is why it isn't live or ready
+	Reason      string     `json:"reason,omitempty"`
+	Queued      int        `json:"queued"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+func newHealthState(maxSilence time.Duration) *healthState {
+	return &healthState{
+		maxSilence:  maxSilence,
+		notReadyWhy: "starting",
+		lastBeat:    time.Now(),
+	}
+}
+
+// setReady marks the process ready, or not ready with a reason.
+func (h *healthState) setReady(ready bool, why string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = ready
+	h.notReadyWhy = why
+}
+
+// drain marks the process as shutting down so orchestrators stop sending it
+// work, while it stays live to finish what it has.
+func (h *healthState) drain() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.draining = true
+}
+
+// beat records that the worker loop is still making progress.
+func (h *healthState) beat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastBeat = time.Now()
+}
+
+// setQueued records how much work is waiting. h may be nil.
+func (h *healthState) setQueued(n int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queued = n
+}
+
+// record notes how a piece of work went, err nil for a success. what is
+// what failed, for the error shown. h may be nil.
+func (h *healthState) record(what string, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.lastSuccess = time.Now()
+		return
+	}
+	h.lastError = what + ": " + err.Error()
+	h.lastErrorAt = time.Now()
+}
+
+func (h *healthState) live() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxSilence > 0 && time.Since(h.lastBeat) > h.maxSilence {
+		return false, "worker loop hasn't checked in since " + h.lastBeat.Format(time.RFC3339)
+	}
+	return true, "ok"
+}
+
+func (h *healthState) readiness() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.draining:
+		return false, "draining"
+	case !h.ready:
+		return false, h.notReadyWhy
+	}
+	return true, "ok"
+}
+
+func (h *healthState) status() healthStatus {
+	live, notLiveWhy := h.live()
+	ready, notReadyWhy := h.readiness()
+	status := healthStatus{Live: live, Ready: ready}
+	switch {
+	case !live:
+		status.Reason = notLiveWhy
+	case !ready:
+		status.Reason = notReadyWhy
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status.Queued = h.queued
+	status.LastError = h.lastError
+	if !h.lastSuccess.IsZero() {
+		t := h.lastSuccess.UTC()
+		status.LastSuccess = &t
+	}
+	if !h.lastErrorAt.IsZero() {
+		t := h.lastErrorAt.UTC()
+		status.LastErrorAt = &t
+	}
+	return status
+}
+
+// register adds /livez, /healthz (the same, for those expecting that name)
+// and /readyz to mux.
+func (h *healthState) register(mux *http.ServeMux) {
+	mux.HandleFunc("/livez", healthHandler(h.live))
+	mux.HandleFunc("/healthz", healthHandler(h.live))
+	mux.HandleFunc("/readyz", healthHandler(h.readiness))
+}
+
+// statusHandler answers with h's status as JSON. The last error names a
+// file, so serve keeps it behind the token.
+func (h *healthState) statusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.status())
+	}
+}
+
+func healthHandler(check func() (bool, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, why := check()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte(why + "\n"))
+	}
+}
+
+// drainOnSignal returns two contexts for a long running mode. work is
+// cancelled on the first SIGINT/SIGTERM, which is the cue to stop picking
+// up new jobs. hard is cancelled once drainTimeout has passed after that, or
+// on a second signal, and in-flight jobs should be aborted then.
+func drainOnSignal(parent context.Context, health *healthState, drainTimeout time.Duration) (work context.Context, hard context.Context, stop func()) {
+	work, cancelWork := context.WithCancel(parent)
+	hard, cancelHard := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		if health != nil {
+			health.drain()
+		}
+		cancelWork()
+
+		timer := time.NewTimer(drainTimeout)
+		defer timer.Stop()
+		select {
+		case <-signals:
+		case <-timer.C:
+		case <-done:
+		}
+		cancelHard()
+	}()
+
+	var once sync.Once
+	return work, hard, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			cancelWork()
+			cancelHard()
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_healthState(t *testing.T) {
+	h := newHealthState(time.Hour)
+	mux := http.NewServeMux()
+	h.register(mux)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, get("/livez"))
+	require.Equal(t, http.StatusOK, get("/healthz"))
+	require.Equal(t, http.StatusServiceUnavailable, get("/readyz"), "not ready until config is loaded")
+
+	h.setReady(true, "")
+	require.Equal(t, http.StatusOK, get("/readyz"))
+
+	h.drain()
+	require.Equal(t, http.StatusServiceUnavailable, get("/readyz"), "draining stops new work")
+	require.Equal(t, http.StatusOK, get("/livez"), "but we're still alive")
+
+	h.maxSilence = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	require.Equal(t, http.StatusServiceUnavailable, get("/livez"), "worker loop went quiet")
+}
+
+func Test_healthStatus(t *testing.T) {
+	h := newHealthState(0)
+	status := func() healthStatus {
+		rec := httptest.NewRecorder()
+		h.statusHandler()(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		s := healthStatus{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &s))
+		return s
+	}
+
+	require.Equal(t, healthStatus{Live: true, Reason: "starting"}, status())
+
+	h.setReady(true, "")
+	h.setQueued(3)
+	h.record("/comics/a.cbr", nil)
+	h.record("/comics/b.cbr", errors.New("not an archive"))
+	s := status()
+	require.True(t, s.Ready)
+	require.Equal(t, 3, s.Queued)
+	require.NotNil(t, s.LastSuccess)
+	require.NotNil(t, s.LastErrorAt)
+	require.Equal(t, "/comics/b.cbr: not an archive", s.LastError)
+
+	var none *healthState
+	none.setQueued(1)
+	none.record("nothing", nil)
+}
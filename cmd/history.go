@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyFileName string
+	historyWeeks    int
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Reports on past conversions",
+}
+
+var statsHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Shows conversions, space saved, failures and throughput per week",
+	Long: `Shows what convert runs did week by week, from the totals each run adds to
+--history-file: files converted and failed, the space saved that week and
+overall, and how fast conversion went. Throughput is also broken down by the
+version that did the converting, for spotting regressions after an upgrade.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runs, err := readHistory(historyFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printHistory(cmd.OutOrStdout(), runs, historyWeeks)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.AddCommand(statsHistoryCmd)
+
+	statsHistoryCmd.Flags().StringVar(&historyFileName, "history-file", defaultHistoryPath(), "file convert adds the totals of each run to")
+	statsHistoryCmd.Flags().IntVar(&historyWeeks, "weeks", 12, "how many of the latest weeks to show, 0 for all")
+}
+
+func defaultHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "history.jsonl")
+}
+
+// runRecord is the totals of one convert run, a line in the history file.
+type runRecord struct {
+	Start   time.Time `json:"start"`
+	Version string    `json:"version"`
+	// Duration is in seconds
+	Duration  float64 `json:"duration"`
+	Converted int     `json:"converted"`
+	Failed    int     `json:"failed"`
+	// BytesIn and BytesOut are the sizes of the converted files before and
+	// after
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// recordHistory adds the totals of the run that just finished to the
+// history file. Failing to is only logged.
+func (c *converter) recordHistory(start time.Time) {
+	if c.historyPath == "" || len(c.converted)+len(c.failed) == 0 {
+		return
+	}
+	data, err := json.Marshal(runRecord{
+		Start:     start.UTC(),
+		Version:   rootCmd.Version,
+		Duration:  time.Since(start).Seconds(),
+		Converted: len(c.converted),
+		Failed:    len(c.failed),
+		BytesIn:   c.bytesIn,
+		BytesOut:  c.bytesOut,
+	})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.historyPath), 0o755)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(c.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	}
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		c.logger.Printf("Unable to record the run in %s: %s\n", c.historyPath, err.Error())
+	}
+}
+
+// readHistory reads every run in the history file at name, skipping lines
+// it can't make sense of.
+func readHistory(name string) ([]runRecord, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening history")
+	}
+	defer f.Close()
+
+	runs := []runRecord{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		run := runRecord{}
+		if json.Unmarshal(scanner.Bytes(), &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading history")
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Start.Before(runs[j].Start) })
+	return runs, nil
+}
+
+// historyTotals adds up runs.
+type historyTotals struct {
+	label     string
+	runs      int
+	converted int
+	failed    int
+	bytesIn   int64
+	bytesOut  int64
+	duration  float64
+}
+
+func (t *historyTotals) add(run runRecord) {
+	t.runs++
+	t.converted += run.Converted
+	t.failed += run.Failed
+	t.bytesIn += run.BytesIn
+	t.bytesOut += run.BytesOut
+	t.duration += run.Duration
+}
+
+func (t historyTotals) failRate() string {
+	if t.converted+t.failed == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(t.failed)/float64(t.converted+t.failed))
+}
+
+func (t historyTotals) throughput() string {
+	if t.duration <= 0 {
+		return "-"
+	}
+	return formatBytes(uint64(float64(t.bytesIn)/t.duration)) + "/s"
+}
+
+// signedBytes is formatBytes for sizes that can go below zero, like
+// the space saved by conversions that grew.
+func signedBytes(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(uint64(-n))
+	}
+	return formatBytes(uint64(n))
+}
+
+// weeklyHistory totals runs by the ISO week they started in, oldest first.
+func weeklyHistory(runs []runRecord) []historyTotals {
+	weeks := []historyTotals{}
+	for _, run := range runs {
+		year, week := run.Start.ISOWeek()
+		label := fmt.Sprintf("%d-W%02d", year, week)
+		if len(weeks) == 0 || weeks[len(weeks)-1].label != label {
+			weeks = append(weeks, historyTotals{label: label})
+		}
+		weeks[len(weeks)-1].add(run)
+	}
+	return weeks
+}
+
+// versionHistory totals runs by the version that did them, in the order
+// they were first used.
+func versionHistory(runs []runRecord) []historyTotals {
+	versions := []historyTotals{}
+	index := map[string]int{}
+	for _, run := range runs {
+		i, ok := index[run.Version]
+		if !ok {
+			i = len(versions)
+			index[run.Version] = i
+			versions = append(versions, historyTotals{label: run.Version})
+		}
+		versions[i].add(run)
+	}
+	return versions
+}
+
+// printHistory writes the weekly report for runs, the latest weeks of it
+// or all of them if weeks is 0.
+func printHistory(w io.Writer, runs []runRecord, weeks int) {
+	if len(runs) == 0 {
+		fmt.Fprintln(w, "No conversions recorded yet")
+		return
+	}
+
+	all := weeklyHistory(runs)
+	var saved int64
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "Week\tRuns\tConverted\tFailed\tFail rate\tSaved\tTotal saved\tThroughput\t")
+	for i, week := range all {
+		saved += week.bytesIn - week.bytesOut
+		if weeks > 0 && i < len(all)-weeks {
+			continue
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", week.label, formatCount(week.runs), formatCount(week.converted), formatCount(week.failed),
+			week.failRate(), signedBytes(week.bytesIn-week.bytesOut), signedBytes(saved), week.throughput())
+	}
+	table.Flush()
+
+	fmt.Fprintln(w)
+	table = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "Version\tRuns\tConverted\tFail rate\tThroughput\t")
+	for _, v := range versionHistory(runs) {
+		label := v.label
+		if label == "" {
+			label = "unknown"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t\n", label, formatCount(v.runs), formatCount(v.converted), v.failRate(), v.throughput())
+	}
+	table.Flush()
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_weeklyHistory(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.March, d, 12, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name string
+		runs []runRecord
+		want []historyTotals
+	}{
+		{
+			name: "none",
+			want: []historyTotals{},
+		},
+		{
+			name: "same week",
+			runs: []runRecord{
+				{Start: day(4), Converted: 2, Failed: 1, BytesIn: 300, BytesOut: 200, Duration: 3},
+				{Start: day(6), Converted: 1, BytesIn: 100, BytesOut: 50, Duration: 1},
+			},
+			want: []historyTotals{
+				{label: "2024-W10", runs: 2, converted: 3, failed: 1, bytesIn: 400, bytesOut: 250, duration: 4},
+			},
+		},
+		{
+			name: "weeks apart",
+			runs: []runRecord{
+				{Start: day(4), Converted: 1},
+				{Start: day(11), Converted: 2},
+				{Start: day(26), Failed: 1},
+			},
+			want: []historyTotals{
+				{label: "2024-W10", runs: 1, converted: 1},
+				{label: "2024-W11", runs: 1, converted: 2},
+				{label: "2024-W13", runs: 1, failed: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, weeklyHistory(tt.runs))
+		})
+	}
+}
+
+func Test_printHistory(t *testing.T) {
+	runs := []runRecord{
+		{Start: time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC), Version: "1.0", Converted: 3, Failed: 1, BytesIn: 3000, BytesOut: 1000, Duration: 2},
+		{Start: time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC), Version: "1.1", Converted: 1, BytesIn: 1000, BytesOut: 1500, Duration: 1},
+	}
+
+	out := &bytes.Buffer{}
+	printHistory(out, runs, 0)
+	lines := strings.Split(out.String(), "\n")
+	require.Equal(t, []string{"2024-W10", "1", "3", "1", "25.0%", "2.0", "kB", "2.0", "kB", "1.5", "kB/s"}, strings.Fields(lines[1]))
+	require.Equal(t, []string{"2024-W11", "1", "1", "0", "0.0%", "-500", "B", "1.5", "kB", "1.0", "kB/s"}, strings.Fields(lines[2]))
+	require.Contains(t, out.String(), "Version")
+
+	// the total saved still counts the weeks left out
+	out.Reset()
+	printHistory(out, runs, 1)
+	require.NotContains(t, out.String(), "2024-W10")
+	require.Contains(t, strings.Fields(out.String()), "1.5")
+
+	out.Reset()
+	printHistory(out, nil, 0)
+	require.Equal(t, "No conversions recorded yet\n", out.String())
+}
+
+func Test_recordHistory(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr": realCBRContents,
+		"library/b.cbr": []byte("not yet downloaded"),
+	})
+	require.NoError(t, err)
+	historyPath := filepath.Join(t.TempDir(), "cbr2cbz", "history.jsonl")
+
+	c := &converter{fs: fsys, logger: testLogger{t}, historyPath: historyPath}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	runs, err := readHistory(historyPath)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	require.Equal(t, 1, runs[0].Converted)
+	require.Equal(t, 1, runs[0].Failed)
+	require.Equal(t, int64(len(realCBRContents)), runs[0].BytesIn)
+	require.NotZero(t, runs[0].BytesOut)
+	// a.cbr is converted already, only b.cbr is tried again
+	require.Equal(t, 0, runs[1].Converted)
+	require.Equal(t, 1, runs[1].Failed)
+}
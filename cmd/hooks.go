@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+var (
+	preHookCommand  string
+	postHookCommand string
+)
+
+// hookTimeout is how long a hook may run before it is killed.
+const hookTimeout = 10 * time.Minute
+
+// errPreHook is what files fail with when --pre-hook does.
+var errPreHook = errors.New("--pre-hook failed")
+
+// preHook runs --pre-hook before cbrFile is converted to cbzFile, failing
+// the file if it exits with an error.
+func (c *converter) preHook(ctx context.Context, cbrFile string, cbzFile string) error {
+	if c.preHookCommand == "" {
+		return nil
+	}
+	e := logEvent{File: cbrFile, Output: cbzFile}
+	if info, err := hackpadfs.Stat(c.fs, pathToFsPath(cbrFile)); err == nil {
+		e.BytesIn = info.Size()
+	}
+	output, err := c.runHook(ctx, c.preHookCommand, "pre", e)
+	if err != nil {
+		return errors.Wrapf(errPreHook, "%s: %s", err.Error(), output)
+	}
+	return nil
+}
+
+// postHook runs --post-hook once a file is done with, whether it converted,
+// failed or was skipped. It only warns when the hook fails, the file is
+// done either way. It still runs once ctx is cancelled, for the files
+// cut short.
+func (c *converter) postHook(ctx context.Context, e logEvent) {
+	if c.postHookCommand == "" {
+		return
+	}
+	output, err := c.runHook(context.WithoutCancel(ctx), c.postHookCommand, "post", e)
+	if err != nil {
+		c.warn(cbr2cbz.CodePostHookFailed, e.File, "--post-hook failed for %s: %s: %s", e.File, err.Error(), output)
+	}
+}
+
+// runHook runs command with the file e is about in its environment and
+// returns what it printed, trimmed.
+func (c *converter) runHook(ctx context.Context, command string, hook string, e logEvent) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), hookEnv(hook, e)...)
+	output, err := cmd.CombinedOutput()
+	trimmed := strings.TrimSpace(string(output))
+	if err == nil && trimmed != "" {
+		debugf(c.logFor(e.File), "--%s-hook for %s: %s\n", hook, e.File, trimmed)
+	}
+	return trimmed, err
+}
+
+// hookEnv is the environment telling a hook about the file e is about.
+// Paths are as cbr2cbz sees them, on the remote for s3://, sftp:// and
+// webdav paths.
+func hookEnv(hook string, e logEvent) []string {
+	env := []string{
+		envPrefix + "HOOK=" + hook,
+		envPrefix + "SOURCE=" + e.File,
+		envPrefix + "DESTINATION=" + e.Output,
+		envPrefix + "SOURCE_SIZE=" + strconv.FormatInt(e.BytesIn, 10),
+	}
+	if hook == "post" {
+		env = append(env,
+			envPrefix+"STATUS="+e.Action,
+			envPrefix+"DESTINATION_SIZE="+strconv.FormatInt(e.BytesOut, 10),
+			envPrefix+"DURATION="+strconv.FormatFloat(e.Duration, 'f', 3, 64),
+			envPrefix+"CODE="+e.Code,
+			envPrefix+"ERROR="+e.Error,
+		)
+	}
+	return env
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_hooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook commands are sh")
+	}
+	ran := filepath.Join(t.TempDir(), "ran")
+
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr":      realCBRContents,
+		"library/b.cbr":      realCBRContents,
+		"library/broken.cbr": []byte("not a rar"),
+	})
+	require.NoError(t, err)
+	c := &converter{
+		fs:              fsys,
+		logger:          testLogger{t},
+		preHookCommand:  `case "$CBR2CBZ_SOURCE" in */b.cbr) echo not today; exit 1;; esac`,
+		postHookCommand: `echo "$CBR2CBZ_HOOK $CBR2CBZ_STATUS $CBR2CBZ_SOURCE $CBR2CBZ_DESTINATION $CBR2CBZ_SOURCE_SIZE $CBR2CBZ_CODE" >> ` + ran,
+	}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Equal(t, []string{"/library/a.cbz"}, c.converted)
+	require.ErrorContains(t, c.failed["/library/b.cbr"], "not today")
+	require.Equal(t, cbr2cbz.CodePreHookFailed, errorCode(c.failed["/library/b.cbr"]))
+
+	data, err := os.ReadFile(ran)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	sort.Strings(lines)
+	require.Equal(t, []string{
+		"post convert /library/a.cbr /library/a.cbz 140 ",
+		"post fail /library/b.cbr  140 E115",
+		"post fail /library/broken.cbr  9 E101",
+	}, lines)
+}
+
+func Test_postHookFailing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook commands are sh")
+	}
+	fsys, err := setupFS(t, filenameBytes{"library/a.cbr": realCBRContents})
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}, postHookCommand: "exit 3"}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Equal(t, []string{"/library/a.cbz"}, c.converted, "the file is done whatever the hook says")
+}
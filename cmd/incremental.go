@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+)
+
+var (
+	incremental          bool
+	incrementalCacheName string
+)
+
+func defaultIncrementalCachePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "processed.jsonl")
+}
+
+// processedFile is a line of the --incremental cache, a file that was
+// converted, or skipped for its cbz being there already, as it was then.
+type processedFile struct {
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Fingerprint is of the conversion options, with others the file is
+	// done again
+	Fingerprint string `json:"fingerprint"`
+}
+
+// processedCache is the files earlier runs got through. Like the state
+// file lines are only appended, the last line for a file being the one
+// that counts.
+type processedCache struct {
+	path  string
+	files map[string]processedFile
+	mu    sync.Mutex
+}
+
+// loadProcessedCache reads the cache at name, which needn't exist yet.
+// Lines it can't make sense of are skipped, and once most lines are about
+// files seen again since the cache is written afresh.
+func loadProcessedCache(name string) (*processedCache, error) {
+	if name == "" {
+		return nil, errors.New("--incremental needs an --incremental-cache")
+	}
+	p := &processedCache{path: name, files: map[string]processedFile{}}
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening incremental cache")
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		record := processedFile{}
+		if json.Unmarshal(scanner.Bytes(), &record) != nil || record.File == "" {
+			continue
+		}
+		lines++
+		p.files[record.File] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", name)
+	}
+	if lines > 2*len(p.files) {
+		err = p.compact()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// compact writes the cache again with a line per file.
+func (p *processedCache) compact() error {
+	tmp := tempName(p.path)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "compacting incremental cache")
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, record := range p.files {
+		if err = enc.Encode(record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "compacting incremental cache")
+	}
+	return nil
+}
+
+// unchanged is whether file was got through before, with the same size,
+// modification time and conversion options.
+func (p *processedCache) unchanged(file string, info fs.FileInfo, fingerprint string) bool {
+	record, ok := p.files[file]
+	return ok && record.Size == info.Size() && record.ModTime.Equal(info.ModTime()) && record.Fingerprint == fingerprint
+}
+
+// add notes file as got through, as info describes it.
+func (p *processedCache) add(file string, info fs.FileInfo, fingerprint string) error {
+	record := processedFile{File: file, Size: info.Size(), ModTime: info.ModTime().UTC(), Fingerprint: fingerprint}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "encoding incremental cache")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err = os.MkdirAll(filepath.Dir(p.path), 0o755)
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	}
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing incremental cache")
+	}
+	p.files[file] = record
+	return nil
+}
+
+// skipUnchanged leaves out of the files found those the cache has as got
+// through already and not changed since.
+func (c *converter) skipUnchanged() error {
+	if c.processed == nil {
+		return nil
+	}
+	fingerprint := c.packer().Options().Fingerprint()
+	unchanged := map[string]bool{}
+	for _, file := range c.cbrFiles {
+		info, err := hackpadfs.Stat(c.fs, pathToFsPath(file))
+		if err != nil {
+			return errors.Wrap(err, "getting cbr file stats")
+		}
+		if c.processed.unchanged(file, info, fingerprint) {
+			unchanged[file] = true
+		}
+	}
+	if len(unchanged) == 0 {
+		return nil
+	}
+
+	keep := func(files []string) []string {
+		kept := []string{}
+		for _, file := range files {
+			if !unchanged[file] {
+				kept = append(kept, file)
+			}
+		}
+		return kept
+	}
+	c.cbrFiles = keep(c.cbrFiles)
+	c.allFiles = keep(c.allFiles)
+	c.logger.Printf("Leaving out %d files unchanged since they were converted, as recorded in %s\n", len(unchanged), c.processed.path)
+	return nil
+}
+
+// remember adds cbrFile to the incremental cache as it was when it
+// started converting. Failing to is only logged, the next run converts
+// it again.
+func (c *converter) remember(cbrFile string, info fs.FileInfo) {
+	if c.processed == nil || info == nil {
+		return
+	}
+	err := c.processed.add(cbrFile, info, c.packer().Options().Fingerprint())
+	if err != nil {
+		c.logger.Printf("%s, the next run will look at %s again\n", err.Error(), cbrFile)
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_convertIncremental(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr": realCBRContents,
+		"library/b.cbr": []byte("not yet downloaded"),
+	})
+	require.NoError(t, err)
+	cachePath := filepath.Join(t.TempDir(), "cbr2cbz", "processed.jsonl")
+
+	run := func() *converter {
+		processed, err := loadProcessedCache(cachePath)
+		require.NoError(t, err)
+		c := &converter{fs: fsys, logger: testLogger{t}, keep: true, processed: processed}
+		err = c.runConvert(context.Background(), []string{"/library"})
+		if err != nil {
+			require.ErrorIs(t, err, errNoFiles)
+		}
+		return c
+	}
+
+	c := run()
+	require.Equal(t, []string{"/library/a.cbz"}, c.converted)
+	require.Len(t, c.failed, 1)
+
+	// a.cbr is left out, b.cbr failed so is tried again
+	c = run()
+	require.Empty(t, c.converted)
+	require.Equal(t, []string{"/library/b.cbr"}, c.cbrFiles)
+
+	// changed since
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, hackpadfs.Chtimes(fsys, "library/a.cbr", later, later))
+	require.NoError(t, hackpadfs.Remove(fsys, "library/a.cbz"))
+	c = run()
+	require.Equal(t, []string{"/library/a.cbz"}, c.converted)
+
+	// other options
+	processed, err := loadProcessedCache(cachePath)
+	require.NoError(t, err)
+	c = &converter{fs: fsys, logger: testLogger{t}, keep: true, processed: processed}
+	info, err := hackpadfs.Stat(fsys, "library/a.cbr")
+	require.NoError(t, err)
+	require.True(t, processed.unchanged("/library/a.cbr", info, c.packer().Options().Fingerprint()))
+	require.False(t, processed.unchanged("/library/a.cbr", info, "other"))
+}
+
+func Test_loadProcessedCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "processed.jsonl")
+	fsys, err := setupFS(t, filenameBytes{"library/a.cbr": realCBRContents})
+	require.NoError(t, err)
+	info, err := hackpadfs.Stat(fsys, "library/a.cbr")
+	require.NoError(t, err)
+
+	p, err := loadProcessedCache(cachePath)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.add("/library/a.cbr", info, "fp"))
+	}
+	// a line cut off by a crash
+	f, err := os.OpenFile(cachePath, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"file":"/library/b.c`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	p, err = loadProcessedCache(cachePath)
+	require.NoError(t, err)
+	require.True(t, p.unchanged("/library/a.cbr", info, "fp"))
+	// written again with a line for a.cbr alone
+	data, err := os.ReadFile(cachePath)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "\n"))
+
+	_, err = loadProcessedCache("")
+	require.ErrorContains(t, err, "needs an --incremental-cache")
+}
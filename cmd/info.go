@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var infoJSON bool
+
+var infoCmd = &cobra.Command{
+	Use:   "info [files...]",
+	Short: "Shows what an archive really is and what it holds, without changing it",
+	Long: `Shows the format an archive really is, whatever its name says, told from its
+contents the same way convert tells it, along with its entries and what convert
+would do with each, the page count, the format and size of the pages, whether
+it has a ComicInfo.xml and how big it is unpacked.
+
+Nothing is converted or changed.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stderr))
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fsys, args, err = localArgs(fsys, args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		infos := []*archiveInfo{}
+		failed := false
+		for _, file := range args {
+			info, err := readArchiveInfo(cmd.Context(), fsys, file, cbr2cbz.EntryFilter{})
+			if err != nil {
+				logger.Printf("%s: %s\n", file, err.Error())
+				failed = true
+				continue
+			}
+			infos = append(infos, info)
+		}
+
+		if infoJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(infos); err != nil {
+				logger.Fatal(err)
+			}
+		} else {
+			for i, info := range infos {
+				if i > 0 {
+					fmt.Fprintln(cmd.OutOrStdout())
+				}
+				printArchiveInfo(cmd.OutOrStdout(), info)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+
+	infoCmd.Flags().BoolVar(&infoJSON, "json", false, "print the details as JSON")
+}
+
+// archiveInfo is what info found in an archive.
+type archiveInfo struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Format is what the archive is going by its contents, rar, zip, 7z or
+	// tar, and NamedAs what its name says when that is something else
+	Format  string `json:"format"`
+	NamedAs string `json:"named_as,omitempty"`
+	Pages   int    `json:"pages"`
+	// Uncompressed is the size of all the entries unpacked
+	Uncompressed int64 `json:"uncompressed"`
+	ComicInfo    bool  `json:"comic_info"`
+	// ImageFormats and Resolutions count the pages in each format and of
+	// each width x height
+	ImageFormats map[string]int `json:"image_formats"`
+	Resolutions  map[string]int `json:"resolutions"`
+	Entries      []entryInfo    `json:"entries"`
+}
+
+// entryInfo is one entry of an archive.
+type entryInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Kind is what convert does with it: page, passthrough or dropped
+	Kind string `json:"kind"`
+	// Format, Width and Height are those of pages that could be read
+	Format string `json:"format,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// readArchiveInfo identifies file and lists its entries, reading the start
+// of each page for its format and size.
+func readArchiveInfo(ctx context.Context, fsys hackpadfs.FS, file string, filter cbr2cbz.EntryFilter) (*archiveInfo, error) {
+	archive, err := openArchive(ctx, fsys, pathToFsPath(file))
+	if err != nil {
+		if f, openErr := fsys.Open(pathToFsPath(file)); openErr == nil {
+			defer f.Close()
+			if ra, ok := f.(io.ReaderAt); ok && cbr2cbz.IsPDF(ra) {
+				return nil, errors.New("is a pdf, info only reads archives")
+			}
+		}
+		return nil, err
+	}
+	defer archive.Close()
+
+	info := &archiveInfo{
+		Path:         file,
+		Size:         archive.stream.Size(),
+		Format:       strings.TrimPrefix(archive.format.(archiver.Format).Name(), "."),
+		ImageFormats: map[string]int{},
+		Resolutions:  map[string]int{},
+		Entries:      []entryInfo{},
+	}
+	if want := scannedFormats[strings.ToLower(filepath.Ext(file))]; want != "" && strings.TrimPrefix(want, ".") != info.Format {
+		info.NamedAs = strings.TrimPrefix(want, ".")
+	}
+
+	err = archive.format.Extract(ctx, archive.stream, nil, func(_ context.Context, f archiver.File) error {
+		if f.IsDir() {
+			return nil
+		}
+		kind := filter.Classify(f.NameInArchive)
+		entry := entryInfo{Name: f.NameInArchive, Size: f.Size(), Kind: kind.String()}
+		info.Uncompressed += f.Size()
+		if cbr2cbz.IsComicInfo(f.NameInArchive) {
+			info.ComicInfo = true
+		}
+		if kind == cbr2cbz.EntryPage {
+			info.Pages++
+			if page, err := readEntryPageInfo(f); err == nil {
+				entry.Format, entry.Width, entry.Height = page.Format, page.Width, page.Height
+				info.ImageFormats[page.Format]++
+				info.Resolutions[fmt.Sprintf("%dx%d", page.Width, page.Height)]++
+			}
+		}
+		info.Entries = append(info.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "reading entries")
+	}
+	return info, nil
+}
+
+func readEntryPageInfo(f archiver.File) (cbr2cbz.PageInfo, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return cbr2cbz.PageInfo{}, err
+	}
+	defer rc.Close()
+	return cbr2cbz.ReadPageInfo(f.NameInArchive, rc)
+}
+
+func printArchiveInfo(w io.Writer, info *archiveInfo) {
+	format := info.Format
+	if info.NamedAs != "" {
+		format += ", though named as " + info.NamedAs
+	}
+	comicInfo := "no"
+	if info.ComicInfo {
+		comicInfo = "yes"
+	}
+
+	fmt.Fprintf(w, "%s\n", info.Path)
+	fmt.Fprintf(w, "  Format:        %s\n", format)
+	fmt.Fprintf(w, "  Size:          %s, %s unpacked\n", formatBytes(uint64(info.Size)), formatBytes(uint64(info.Uncompressed)))
+	fmt.Fprintf(w, "  Entries:       %s, %s pages\n", formatCount(len(info.Entries)), formatCount(info.Pages))
+	fmt.Fprintf(w, "  ComicInfo.xml: %s\n", comicInfo)
+	fmt.Fprintf(w, "  Page formats:  %s\n", countsByMost(info.ImageFormats))
+	fmt.Fprintf(w, "  Page sizes:    %s\n", countsByMost(info.Resolutions))
+
+	fmt.Fprintln(w)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  Entry\tSize\tKind\tPage\t")
+	for _, e := range info.Entries {
+		page := ""
+		if e.Format != "" {
+			page = fmt.Sprintf("%s %dx%d", e.Format, e.Width, e.Height)
+		}
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\t\n", e.Name, formatBytes(uint64(e.Size)), e.Kind, page)
+	}
+	table.Flush()
+}
+
+// countsByMost lists counts as "jpeg 20, png 2", the most first, or "none".
+func countsByMost(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %s", k, formatCount(counts[k]))
+	}
+	return strings.Join(parts, ", ")
+}
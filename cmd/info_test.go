@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readArchiveInfo(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		// a zip only named cbr
+		"library/Saga 001.cbr": makeZip(t, map[string]string{
+			"001.png":       string(makePNG(t, 20, 30)),
+			"002.png":       string(makePNG(t, 20, 30)),
+			"003.png":       string(makePNG(t, 40, 30)),
+			"ComicInfo.xml": "<ComicInfo/>",
+			"scans.nfo":     "ripped by",
+		}),
+		"library/notes.cbr": []byte("not an archive"),
+	})
+	require.NoError(t, err)
+
+	info, err := readArchiveInfo(context.Background(), fsys, "/library/Saga 001.cbr", cbr2cbz.EntryFilter{})
+	require.NoError(t, err)
+	require.Equal(t, "zip", info.Format)
+	require.Equal(t, "rar", info.NamedAs)
+	require.Equal(t, 3, info.Pages)
+	require.True(t, info.ComicInfo)
+	require.Len(t, info.Entries, 5)
+	require.Equal(t, map[string]int{"png": 3}, info.ImageFormats)
+	require.Equal(t, map[string]int{"20x30": 2, "40x30": 1}, info.Resolutions)
+	var total int64
+	kinds := map[string]string{}
+	for _, e := range info.Entries {
+		total += e.Size
+		kinds[e.Name] = e.Kind
+	}
+	require.Equal(t, total, info.Uncompressed)
+	require.Equal(t, map[string]string{"001.png": "page", "002.png": "page", "003.png": "page", "ComicInfo.xml": "passthrough", "scans.nfo": "dropped"}, kinds)
+
+	out := &bytes.Buffer{}
+	printArchiveInfo(out, info)
+	require.Contains(t, out.String(), "Format:        zip, though named as rar\n")
+	require.Contains(t, out.String(), "Page sizes:    20x30 2, 40x30 1\n")
+
+	_, err = readArchiveInfo(context.Background(), fsys, "/library/notes.cbr", cbr2cbz.EntryFilter{})
+	require.Error(t, err)
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var initForce bool
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Interactively creates a config file",
+	Long: `Interactively creates a config file, asking where the library is, whether
+originals should be deleted and how conversions should run.
+
+The file is written to --config, afterwards cbr2cbz convert works without arguments.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configFileName == "" {
+			fmt.Fprintln(os.Stderr, "no config location, pass --config")
+			os.Exit(1)
+		}
+		if _, err := os.Stat(configFileName); err == nil && !initForce {
+			fmt.Fprintf(os.Stderr, "%s already exists, pass --force to replace it\n", configFileName)
+			os.Exit(1)
+		}
+
+		w := newInitWizard(os.Stdin, os.Stdout)
+		data, err := w.run()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		err = os.MkdirAll(filepath.Dir(configFileName), 0755)
+		if err == nil {
+			err = os.WriteFile(configFileName, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "writing config"))
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote %s, check it with cbr2cbz config validate\n", configFileName)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().BoolVar(&initForce, "force", false, "replace an existing config file")
+}
+
+// initWizard asks the questions for init and builds the config file from
+// the answers.
+type initWizard struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	doc *yaml.Node
+}
+
+func newInitWizard(in io.Reader, out io.Writer) *initWizard {
+	return &initWizard{
+		in:  bufio.NewReader(in),
+		out: out,
+		doc: &yaml.Node{Kind: yaml.MappingNode},
+	}
+}
+
+func (w *initWizard) run() ([]byte, error) {
+	fmt.Fprintln(w.out, "Answer each question or press enter for the default in brackets.")
+	fmt.Fprintln(w.out)
+
+	paths := []string{}
+	for {
+		question := "Library path to convert"
+		if len(paths) > 0 {
+			question = "Another library path (enter when done)"
+		}
+		p, err := w.ask(question, "")
+		if err != nil {
+			return nil, err
+		}
+		if p == "" {
+			if len(paths) == 0 {
+				fmt.Fprintln(w.out, "At least one path is needed.")
+				continue
+			}
+			break
+		}
+		paths = append(paths, p)
+	}
+	w.setList("paths", paths)
+
+	keep, err := w.askBool("Keep the original cbr files after converting?", true)
+	if err != nil {
+		return nil, err
+	}
+	w.set("keep", strconv.FormatBool(keep))
+
+	outputDir, err := w.ask("Write cbz files into a separate directory (empty for next to the cbr)", "")
+	if err != nil {
+		return nil, err
+	}
+	if outputDir != "" {
+		w.set("output-dir", outputDir)
+	}
+
+	jobs, err := w.ask("How many files to convert at once", "1")
+	if err != nil {
+		return nil, err
+	}
+	if n, err := strconv.Atoi(jobs); err != nil || n < 1 {
+		return nil, errors.Errorf("%q is not a number of jobs", jobs)
+	}
+	w.set("jobs", jobs)
+
+	for _, q := range []struct{ key, question string }{
+		{"series-json", "Create Mylar style series.json files?"},
+		{"mark-cover", "Mark the cover page in ComicInfo.xml?"},
+		{"bookmarks", "Bookmark chapters in ComicInfo.xml?"},
+	} {
+		yes, err := w.askBool(q.question, false)
+		if err != nil {
+			return nil, err
+		}
+		if yes {
+			w.set(q.key, "true")
+		}
+	}
+
+	return yaml.Marshal(w.doc)
+}
+
+func (w *initWizard) ask(question string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.Wrap(err, "reading answer")
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func (w *initWizard) askBool(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := w.ask(question+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(w.out, "Please answer y or n.")
+	}
+}
+
+func (w *initWizard) set(key string, value string) {
+	w.doc.Content = append(w.doc.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value},
+	)
+}
+
+func (w *initWizard) setList(key string, values []string) {
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, v := range values {
+		list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: v})
+	}
+	w.doc.Content = append(w.doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, list)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitWizard(t *testing.T) {
+	answers := strings.Join([]string{
+		"",             // a path is required
+		"~/Comics",     // first path
+		"/srv/manga",   // second path
+		"",             // done with paths
+		"maybe",        // not a yes or no
+		"n",            // don't keep
+		"/srv/cbz",     // output dir
+		"4",            // jobs
+		"y", "", "yes", // series-json, mark-cover, bookmarks
+	}, "\n") + "\n"
+
+	data, err := newInitWizard(strings.NewReader(answers), io.Discard).run()
+	require.NoError(t, err)
+	require.Equal(t, `paths:
+    - ~/Comics
+    - /srv/manga
+keep: false
+output-dir: /srv/cbz
+jobs: 4
+series-json: true
+bookmarks: true
+`, string(data))
+
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(name, data, 0644))
+	cfg, err := loadConfig(name, true)
+	require.NoError(t, err)
+	require.Empty(t, cfg.validate(configFlagSets()...))
+}
+
+func TestInitWizardBadJobs(t *testing.T) {
+	_, err := newInitWizard(strings.NewReader("/comics\n\n\n\nlots\n"), io.Discard).run()
+	require.Error(t, err)
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/hack-pad/hackpadfs"
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/pkg/errors"
+)
+
+// localInputs turns the local paths convert is given into paths in c.fs.
+// Relative paths are taken from the working directory, or from --root when
+// it is given, trailing separators are dropped and globs the shell passed
+// along as they are, as cmd.exe and quoted PowerShell globs do, are
+// expanded here. On Windows c.fs moves to the drive or share the paths are
+// on, so D:\Comics\ and \\nas\comics work as well as C:\Comics.
+func (c *converter) localInputs(args []string) ([]string, error) {
+	if len(args) > 0 && isRemotePath(args[0]) {
+		// useRemote already refused mixing them
+		return args, nil
+	}
+	if rootDir != "" {
+		return rootedInputs(c.fs, args)
+	}
+
+	paths, volume, err := localPaths(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.outputDir != "" {
+		abs, err := filepath.Abs(c.outputDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolving --output-dir")
+		}
+		abs = withoutLongPrefix(abs)
+		if len(paths) > 0 && !strings.EqualFold(filepath.VolumeName(abs), volume) {
+			return nil, errors.Errorf("--output-dir %s has to be on the same drive as %s", abs, paths[0])
+		}
+		c.outputDir = osToInputPath(abs)
+	}
+
+	c.fs, err = onVolume(c.fs, volume)
+	return paths, err
+}
+
+// localArgs is localInputs for the commands other than convert, returning
+// fsys moved to the drive the paths are on.
+func localArgs(fsys hackpadfs.FS, args []string) (hackpadfs.FS, []string, error) {
+	if rootDir != "" {
+		paths, err := rootedInputs(fsys, args)
+		return fsys, paths, err
+	}
+	paths, volume, err := localPaths(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	fsys, err = onVolume(fsys, volume)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fsys, paths, nil
+}
+
+// localPaths resolves and expands local paths, returning them as paths on
+// the volume they all have to be on, which is empty but on Windows.
+func localPaths(args []string) ([]string, string, error) {
+	paths := []string{}
+	volume := ""
+	for _, arg := range args {
+		matches, err := expandInput(arg, func(pattern string) ([]string, error) {
+			return doublestar.FilepathGlob(pattern)
+		}, func(name string) bool {
+			_, err := os.Stat(name)
+			return err == nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		for _, match := range matches {
+			abs, err := filepath.Abs(match)
+			if err != nil {
+				return nil, "", errors.Wrapf(err, "resolving %s", match)
+			}
+			abs = withoutLongPrefix(abs)
+			v := filepath.VolumeName(abs)
+			if len(paths) > 0 && !strings.EqualFold(v, volume) {
+				return nil, "", errors.Errorf("can't convert %s and %s in one run, they are on different drives", paths[0], abs)
+			}
+			volume = v
+			paths = append(paths, osToInputPath(abs))
+		}
+	}
+	return paths, volume, nil
+}
+
+// onVolume is fsys moved to volume, when it is the local disk and volume
+// isn't empty.
+func onVolume(fsys hackpadfs.FS, volume string) (hackpadfs.FS, error) {
+	if _, ok := unthrottled(fsys).(*hackpados.FS); !ok || volume == "" {
+		return fsys, nil
+	}
+	sub, err := hackpados.NewFS().SubVolume(volume)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", volume)
+	}
+	return throttleFS(sub), nil
+}
+
+// rootedInputs is localInputs under --root, where paths are already in
+// fsys and globs are expanded in it.
+func rootedInputs(fsys fs.FS, args []string) ([]string, error) {
+	paths := []string{}
+	for _, arg := range args {
+		arg = path.Clean("/" + filepath.ToSlash(arg))
+		matches, err := expandInput(arg, func(pattern string) ([]string, error) {
+			return doublestar.Glob(fsys, pathToFsPath(pattern))
+		}, func(name string) bool {
+			_, err := fs.Stat(fsys, pathToFsPath(name))
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			paths = append(paths, "/"+pathToFsPath(match))
+		}
+	}
+	return paths, nil
+}
+
+// expandInput is arg as it is, or everything it matches when it is a glob
+// that isn't the name of a file too, like Saga [2012].
+func expandInput(arg string, glob func(pattern string) ([]string, error), exists func(name string) bool) ([]string, error) {
+	if !strings.ContainsAny(arg, "*?[{") || exists(arg) {
+		return []string{arg}, nil
+	}
+	matches, err := glob(arg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "expanding %s", arg)
+	}
+	if len(matches) == 0 {
+		return nil, errors.Errorf("nothing matches %s", arg)
+	}
+	return matches, nil
+}
+
+// withoutLongPrefix drops the \\?\ from a Windows path that has it, which
+// takes the path as it is and past the 260 characters paths are otherwise
+// held to: \\?\D:\Comics becomes D:\Comics and \\?\UNC\nas\comics
+// \\nas\comics. Go adds it back to long paths itself, and without it the
+// drive can be compared with that of other paths.
+func withoutLongPrefix(abs string) string {
+	switch {
+	case strings.HasPrefix(abs, `\\?\UNC\`):
+		return `\\` + strings.TrimPrefix(abs, `\\?\UNC\`)
+	case strings.HasPrefix(abs, `\\?\`) && len(abs) > 5 && abs[5] == ':':
+		return strings.TrimPrefix(abs, `\\?\`)
+	}
+	return abs
+}
+
+// osToInputPath is the absolute os path abs as a path in a filesystem on
+// its drive, C:\Comics\Saga becoming /Comics/Saga.
+func osToInputPath(abs string) string {
+	rest := strings.TrimPrefix(abs, filepath.VolumeName(abs))
+	return "/" + strings.Trim(filepath.ToSlash(rest), "/")
+}
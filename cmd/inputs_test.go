@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_localInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Series/a.cbr", "Series/b.cbr", "Saga [2012]/c.cbr"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), realCBRContents, 0o644))
+	}
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	// the temp dir may be behind a symlink, like /tmp on macOS
+	dir, err = os.Getwd()
+	require.NoError(t, err)
+	in := func(name string) string { return osToInputPath(filepath.Join(dir, name)) }
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "trailing separator", args: []string{dir + string(filepath.Separator)}, want: []string{in("")}},
+		{name: "relative", args: []string{"." + string(filepath.Separator) + "Series"}, want: []string{in("Series")}},
+		{name: "literal glob", args: []string{filepath.Join("Series", "*")}, want: []string{in("Series/a.cbr"), in("Series/b.cbr")}},
+		{name: "recursive glob", args: []string{filepath.Join(dir, "**", "*.cbr")}, want: []string{in("Saga [2012]/c.cbr"), in("Series/a.cbr"), in("Series/b.cbr")}},
+		{name: "brackets in a name", args: []string{"Saga [2012]"}, want: []string{in("Saga [2012]")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &converter{fs: hackpados.NewFS(), logger: testLogger{t}}
+			paths, err := c.localInputs(tt.args)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, paths)
+		})
+	}
+
+	c := &converter{fs: hackpados.NewFS(), logger: testLogger{t}}
+	_, err = c.localInputs([]string{filepath.Join("Series", "*.cb7")})
+	require.ErrorContains(t, err, "nothing matches")
+
+	c = &converter{fs: hackpados.NewFS(), logger: testLogger{t}, outputDir: "out"}
+	paths, err := c.localInputs([]string{"Series"})
+	require.NoError(t, err)
+	require.Equal(t, []string{in("Series")}, paths)
+	require.Equal(t, in("out"), c.outputDir)
+
+	// what convert then does with them
+	c = &converter{fs: hackpados.NewFS(), logger: testLogger{t}, keep: true}
+	paths, err = c.localInputs([]string{"Series" + string(filepath.Separator)})
+	require.NoError(t, err)
+	require.NoError(t, c.findFilesAndSize(context.Background(), paths))
+	require.Len(t, c.cbrFiles, 2)
+}
+
+func Test_rootedInputs(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"Series/a.cbr": realCBRContents,
+		"Series/b.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+
+	paths, err := rootedInputs(fsys, []string{"Series/", "/Series/b.cbr", "Series/*.cbr"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/Series", "/Series/b.cbr", "/Series/a.cbr", "/Series/b.cbr"}, paths)
+
+	_, err = rootedInputs(fsys, []string{"Other/*"})
+	require.ErrorContains(t, err, "nothing matches")
+}
+
+func Test_withoutLongPrefix(t *testing.T) {
+	for abs, want := range map[string]string{
+		`\\?\D:\Comics\Saga`:      `D:\Comics\Saga`,
+		`\\?\UNC\nas\comics\Saga`: `\\nas\comics\Saga`,
+		`D:\Comics`:               `D:\Comics`,
+		`\\nas\comics`:            `\\nas\comics`,
+		`\\?\Volume{1234}\Comics`: `\\?\Volume{1234}\Comics`,
+		"/home/comics/Saga":       "/home/comics/Saga",
+	} {
+		require.Equal(t, want, withoutLongPrefix(abs), abs)
+	}
+}
+
+func Test_pathToFsPath(t *testing.T) {
+	require.Equal(t, "library/Saga", pathToFsPath("/library/Saga/"))
+	require.Equal(t, "library/Saga", pathToFsPath("library/Saga"))
+	require.Equal(t, "", pathToFsPath("/"))
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var fileTimeout time.Duration
+
+// errInterrupted is the cause the files in progress are aborted with on a
+// second interrupt.
+var errInterrupted = errors.New("interrupted")
+
+// errFileTimeout is what a file fails with once it took --file-timeout.
+var errFileTimeout = errors.New("took longer than --file-timeout")
+
+// interruptSignals returns a channel getting SIGINT and SIGTERM instead of
+// them ending the process, until stop is called.
+func interruptSignals() (<-chan os.Signal, func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	return signals, func() { signal.Stop(signals) }
+}
+
+// interruptible makes the first of signals stop c's batch from starting
+// files, letting the ones in progress finish, and the second abort those
+// too by cancelling the context returned; their partial cbz files are
+// removed and the originals kept. Either way the batch state stays for
+// --resume and the stats are printed. stop ends the watching.
+func (c *converter) interruptible(ctx context.Context, signals <-chan os.Signal) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stopping := make(chan struct{})
+	c.stopping = stopping
+	// runConvert swaps c.logger while the batch runs
+	logger := c.logger
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		logger.Printf("Interrupted, finishing the files in progress; interrupt again to abort them\n")
+		close(stopping)
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		logger.Printf("Interrupted again, aborting the files in progress\n")
+		cancel(errInterrupted)
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// interrupted reports whether the batch was asked to stop starting files.
+func (c *converter) interrupted() bool {
+	select {
+	case <-c.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// withFileTimeout is ctx for converting one file, cancelled with
+// errFileTimeout after --file-timeout.
+func (c *converter) withFileTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.fileTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, c.fileTimeout, errFileTimeout)
+}
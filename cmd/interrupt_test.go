@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_convertInterrupted(t *testing.T) {
+	setup := func(t *testing.T) (*converter, hackpadfs.FS) {
+		fsys, err := setupFS(t, filenameBytes{
+			"library/a.cbt": makeTar(t, map[string]string{"001.jpg": "page"}),
+			"library/b.cbt": makeTar(t, map[string]string{"001.jpg": "page"}),
+		})
+		require.NoError(t, err)
+		return &converter{fs: fsys, logger: testLogger{t}, jobs: 1}, fsys
+	}
+
+	t.Run("finishes the file in progress", func(t *testing.T) {
+		c, fsys := setup(t)
+		signals := make(chan os.Signal, 2)
+		ctx, stop := c.interruptible(context.Background(), signals)
+		defer stop()
+		c.onStart = func(string, *cbr2cbz.Progress) {
+			signals <- syscall.SIGTERM
+			<-c.stopping
+		}
+
+		require.NoError(t, c.runConvert(ctx, []string{"/library"}))
+		require.Equal(t, []string{"/library/a.cbz"}, c.converted)
+		require.Equal(t, 1, c.notStarted)
+		require.Equal(t, exitStopped, c.exitCode(nil))
+		_, err := hackpadfs.Stat(fsys, "library/b.cbt")
+		require.NoError(t, err)
+	})
+
+	t.Run("a second interrupt aborts it", func(t *testing.T) {
+		c, fsys := setup(t)
+		signals := make(chan os.Signal, 2)
+		ctx, stop := c.interruptible(context.Background(), signals)
+		defer stop()
+		c.onStart = func(string, *cbr2cbz.Progress) {
+			signals <- os.Interrupt
+			signals <- os.Interrupt
+			<-ctx.Done()
+		}
+
+		require.NoError(t, c.runConvert(ctx, []string{"/library"}))
+		require.Empty(t, c.converted)
+		require.ErrorIs(t, context.Cause(ctx), errInterrupted)
+		for _, name := range []string{"library/a.cbt", "library/b.cbt"} {
+			_, err := hackpadfs.Stat(fsys, name)
+			require.NoError(t, err, "%s is kept", name)
+		}
+		entries, err := hackpadfs.ReadDir(fsys, "library")
+		require.NoError(t, err)
+		require.Len(t, entries, 2, "no partial cbz left behind")
+	})
+}
+
+func Test_convertFileTimeout(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbt": makeTar(t, map[string]string{"001.jpg": "page"}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, fileTimeout: time.Millisecond}
+	c.onStart = func(string, *cbr2cbz.Progress) { time.Sleep(10 * time.Millisecond) }
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.failed, 1)
+	require.Equal(t, cbr2cbz.CodeFileTimeout, errorCode(c.failed["/library/a.cbt"]))
+	_, err = hackpadfs.Stat(fsys, "library/a.cbt")
+	require.NoError(t, err)
+}
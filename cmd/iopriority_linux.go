@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// from linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// setIOPriority puts every thread of the process in the best effort class
+// at its lowest level for low, or the idle class for idle. Priorities are
+// per thread, the ones started later and unrar inherit it from the thread
+// starting them.
+func setIOPriority(level string) error {
+	prio := ioprioClassBE<<ioprioClassShift | 7
+	if level == "idle" {
+		prio = ioprioClassIdle << ioprioClassShift
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return errors.Wrap(err, "listing threads")
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio))
+		if errno != 0 {
+			return errors.Wrap(errno, "ioprio_set")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package cmd
+
+import "github.com/pkg/errors"
+
+// setIOPriority is only implemented on Linux.
+func setIOPriority(level string) error {
+	return errors.New("--io-nice is only supported on Linux")
+}
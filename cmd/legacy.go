@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// legacyScriptName is what the original bash version is called, running
+// this binary under that name behaves like it.
+const legacyScriptName = "cbr2cbz.sh"
+
+var legacyYes bool
+
+// legacyCmd represents the legacy command
+var legacyCmd = &cobra.Command{
+	Use:   "legacy [dir]",
+	Short: "Behaves like the original cbr2cbz.sh",
+	Long: `Behaves like the original cbr2cbz.sh, for wrappers and cron jobs written around it.
+
+Converts everything under the current directory (or dir), writes cbr2cbz.log there
+and asks before starting since the original files get deleted. Installing or
+symlinking the binary as cbr2cbz.sh runs this command.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeDirs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := os.Getwd()
+		if err != nil {
+			panic(err)
+		}
+		if len(args) == 1 {
+			dir, err = filepath.Abs(args[0])
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		if !legacyYes && !keepOriginal {
+			ok, err := confirmLegacy(os.Stdin, os.Stdout, dir)
+			if err != nil || !ok {
+				fmt.Println("Aborted.")
+				os.Exit(1)
+			}
+		}
+
+		if !cmd.Flags().Changed("log-file") {
+			logFileName = filepath.Join(dir, "cbr2cbz.log")
+		}
+		logFile, err := openLogFile(time.Now())
+		if err != nil {
+			panic(err)
+		}
+		logger := log.Default()
+		logger.SetOutput(io.MultiWriter(consoleOutput(os.Stdout), logFileOutput(logFile)))
+
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		c.settings = effectiveOptions(cmd.Flags())
+
+		signals, stopSignals := interruptSignals()
+		defer stopSignals()
+		ctx, stop := c.interruptible(cmd.Context(), signals)
+		defer stop()
+		err = c.runConvert(ctx, []string{dir})
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+var migrateConfigCmd = &cobra.Command{
+	Use:   "migrate-config [wrapper-script...]",
+	Short: "Prints a config file equivalent to wrapper scripts around cbr2cbz.sh",
+	Long: `Prints a config file equivalent to wrapper scripts around cbr2cbz.sh.
+
+Each script is searched for the directories cbr2cbz.sh gets run in, either after a cd
+or given as an argument, and they become the config's paths. Save the output as the
+config file and the wrappers can be replaced with a plain cbr2cbz convert.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		paths := []string{}
+		for _, name := range args {
+			f, err := os.Open(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			dirs := wrapperDirs(f)
+			f.Close()
+			if len(dirs) == 0 {
+				fmt.Fprintf(os.Stderr, "%s: no cbr2cbz.sh runs found\n", name)
+			}
+			paths = appendMissing(paths, dirs...)
+		}
+		if len(paths) == 0 {
+			os.Exit(1)
+		}
+
+		out, err := legacyConfig(paths)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(legacyCmd)
+	rootCmd.AddCommand(migrateConfigCmd)
+
+	legacyCmd.Flags().AddFlagSet(convertCmd.Flags())
+	legacyCmd.Flags().BoolVarP(&legacyYes, "yes", "y", false, "don't ask before converting")
+}
+
+// legacyArgs rewrites the command line to run legacy when the binary was
+// started as sh.
+func legacyArgs(args []string) []string {
+	if len(args) == 0 || filepath.Base(args[0]) != legacyScriptName {
+		return nil
+	}
+	return append([]string{"legacy"}, args[1:]...)
+}
+
+func confirmLegacy(in io.Reader, out io.Writer, dir string) (bool, error) {
+	fmt.Fprintf(out, "This will convert every cbr file in %s and below, deleting the originals once converted.\n", dir)
+	fmt.Fprint(out, "Are you sure you want to continue? (y/n) ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return false, errors.Wrap(err, "reading answer")
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+var (
+	wrapperCd  = regexp.MustCompile(`(?:^|[;&|]\s*)cd\s+("[^"]+"|'[^']+'|[^\s;&|]+)`)
+	wrapperRun = regexp.MustCompile(`cbr2cbz\.sh(?:\s+("[^"]+"|'[^']+'|[^\s;&|]+))?`)
+)
+
+// wrapperDirs finds the directories a shell script runs sh in.
+func wrapperDirs(r io.Reader) []string {
+	dirs := []string{}
+	cwd := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, m := range wrapperCd.FindAllStringSubmatch(line, -1) {
+			cwd = unquoteShell(m[1])
+		}
+		m := wrapperRun.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		dir := cwd
+		if arg := unquoteShell(m[1]); arg != "" && !strings.HasPrefix(arg, "-") {
+			dir = arg
+		}
+		if dir != "" {
+			dirs = appendMissing(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func unquoteShell(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// legacyConfig is a config file for paths that keeps the bash version's
+// behaviour.
+func legacyConfig(paths []string) ([]byte, error) {
+	return yaml.Marshal(struct {
+		Paths []string `yaml:"paths"`
+		Keep  bool     `yaml:"keep"`
+	}{Paths: paths})
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_legacyArgs(t *testing.T) {
+	require.Nil(t, legacyArgs([]string{"/usr/bin/cbr2cbz", "convert", "."}))
+	require.Equal(t, []string{"legacy", "-y"}, legacyArgs([]string{"/usr/local/bin/cbr2cbz.sh", "-y"}))
+}
+
+func Test_wrapperDirs(t *testing.T) {
+	script := `#!/bin/bash
+# cd /not/this
+cd "/mnt/comics/new arrivals" && ~/bin/cbr2cbz.sh
+cd /mnt/manga
+./cbr2cbz.sh
+/opt/cbr2cbz.sh '/mnt/other'
+cd /mnt/manga; ./cbr2cbz.sh
+echo done
+`
+	require.Equal(t, []string{"/mnt/comics/new arrivals", "/mnt/manga", "/mnt/other"}, wrapperDirs(strings.NewReader(script)))
+}
+
+func Test_confirmLegacy(t *testing.T) {
+	ok, err := confirmLegacy(strings.NewReader("y\n"), io.Discard, "/comics")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = confirmLegacy(strings.NewReader("\n"), io.Discard, "/comics")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func Test_legacyConfig(t *testing.T) {
+	out, err := legacyConfig([]string{"/mnt/manga"})
+	require.NoError(t, err)
+	require.Equal(t, "paths:\n    - /mnt/manga\nkeep: false\n", string(out))
+}
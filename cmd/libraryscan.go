@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	komgaURL       string
+	komgaAPIKey    string
+	kavitaURL      string
+	kavitaAPIKey   string
+	libraryPathMap []string
+)
+
+// libraryScanTimeout is how long a Komga or Kavita request may take.
+const libraryScanTimeout = 30 * time.Second
+
+// libraryScanner asks Komga and Kavita to scan the folders cbz files were
+// written to, so they show up without waiting for the next scheduled scan.
+// Failing to ask is only logged. A nil libraryScanner does nothing.
+type libraryScanner struct {
+	komgaURL  string
+	komgaKey  string
+	kavitaURL string
+	kavitaKey string
+	// pathMap turns local folders into what the server sees, for servers
+	// in a container or on another machine
+	pathMap []pathMapping
+	logger  logger
+	client  *http.Client
+
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+// pathMapping is a --library-path-map, local=server.
+type pathMapping struct {
+	local  string
+	server string
+}
+
+// newLibraryScanner checks the flags, returning nil when neither server is
+// set.
+func newLibraryScanner(komgaURL, komgaKey, kavitaURL, kavitaKey string, pathMap []string, logger logger) (*libraryScanner, error) {
+	if komgaURL == "" && kavitaURL == "" {
+		return nil, nil
+	}
+	s := &libraryScanner{
+		komgaURL:  strings.TrimRight(komgaURL, "/"),
+		komgaKey:  komgaKey,
+		kavitaURL: strings.TrimRight(kavitaURL, "/"),
+		kavitaKey: kavitaKey,
+		logger:    logger,
+		client:    &http.Client{Timeout: libraryScanTimeout},
+		dirs:      map[string]bool{},
+	}
+	for _, server := range []struct{ flag, url, key string }{{"komga", komgaURL, komgaKey}, {"kavita", kavitaURL, kavitaKey}} {
+		if server.url == "" {
+			continue
+		}
+		u, err := url.Parse(server.url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.Errorf("--%s-url must be an http or https URL, got %q", server.flag, server.url)
+		}
+		if server.key == "" {
+			return nil, errors.Errorf("--%s-url needs --%s-api-key", server.flag, server.flag)
+		}
+	}
+	for _, m := range pathMap {
+		local, server, ok := strings.Cut(m, "=")
+		if !ok || local == "" || server == "" {
+			return nil, errors.Errorf("--library-path-map %q isn't local=server", m)
+		}
+		s.pathMap = append(s.pathMap, pathMapping{local: path.Clean(local), server: path.Clean(server)})
+	}
+	// the longest, most specific, mapping wins
+	sort.SliceStable(s.pathMap, func(i, j int) bool { return len(s.pathMap[i].local) > len(s.pathMap[j].local) })
+	return s, nil
+}
+
+// add notes the folders of cbzFiles for the next refresh.
+func (s *libraryScanner) add(cbzFiles ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, file := range cbzFiles {
+		s.dirs[s.serverPath(path.Dir(filepath.ToSlash(file)))] = true
+	}
+}
+
+// serverPath is dir as the server sees it.
+func (s *libraryScanner) serverPath(dir string) string {
+	dir = path.Clean(dir)
+	for _, m := range s.pathMap {
+		if rest, ok := cutPathPrefix(dir, m.local); ok {
+			return path.Join(m.server, rest)
+		}
+	}
+	return dir
+}
+
+// cutPathPrefix is p relative to dir, if p is dir or under it.
+func cutPathPrefix(p string, dir string) (string, bool) {
+	if p == dir {
+		return "", true
+	}
+	rest, ok := strings.CutPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+	return rest, ok
+}
+
+// refresh asks the servers to scan the folders added since the last
+// refresh.
+func (s *libraryScanner) refresh() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	dirs := make([]string, 0, len(s.dirs))
+	for dir := range s.dirs {
+		dirs = append(dirs, dir)
+	}
+	s.dirs = map[string]bool{}
+	s.mu.Unlock()
+	if len(dirs) == 0 {
+		return
+	}
+	sort.Strings(dirs)
+
+	if s.komgaURL != "" {
+		if err := s.scanKomga(dirs); err != nil {
+			s.logger.Printf("Unable to ask Komga to scan: %s\n", err.Error())
+		}
+	}
+	if s.kavitaURL != "" {
+		for _, dir := range dirs {
+			if err := s.scanKavita(dir); err != nil {
+				s.logger.Printf("Unable to ask Kavita to scan %s: %s\n", dir, err.Error())
+			}
+		}
+	}
+}
+
+// scanKomga scans the Komga libraries holding any of dirs. Komga only
+// scans whole libraries.
+func (s *libraryScanner) scanKomga(dirs []string) error {
+	libraries := []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Root string `json:"root"`
+	}{}
+	auth := map[string]string{"X-API-Key": s.komgaKey}
+	err := s.request(http.MethodGet, s.komgaURL+"/api/v1/libraries", auth, nil, &libraries)
+	if err != nil {
+		return errors.Wrap(err, "listing libraries")
+	}
+
+	unmatched := map[string]bool{}
+	for _, dir := range dirs {
+		unmatched[dir] = true
+	}
+	for _, library := range libraries {
+		root := path.Clean(library.Root)
+		holds := false
+		for _, dir := range dirs {
+			if _, ok := cutPathPrefix(dir, root); ok {
+				holds = true
+				delete(unmatched, dir)
+			}
+		}
+		if !holds {
+			continue
+		}
+		err := s.request(http.MethodPost, s.komgaURL+"/api/v1/libraries/"+url.PathEscape(library.ID)+"/scan", auth, nil, nil)
+		if err != nil {
+			return errors.Wrapf(err, "scanning library %s", library.Name)
+		}
+		s.logger.Printf("Asked Komga to scan library %s\n", library.Name)
+	}
+	for _, dir := range dirs {
+		if unmatched[dir] {
+			s.logger.Printf("No Komga library holds %s, map its path with --library-path-map\n", dir)
+		}
+	}
+	return nil
+}
+
+// scanKavita asks Kavita to scan dir, which it finds the library of itself.
+func (s *libraryScanner) scanKavita(dir string) error {
+	body := map[string]string{"apiKey": s.kavitaKey, "folderPath": dir}
+	err := s.request(http.MethodPost, s.kavitaURL+"/api/Library/scan-folder", nil, body, nil)
+	if err != nil {
+		return err
+	}
+	s.logger.Printf("Asked Kavita to scan %s\n", dir)
+	return nil
+}
+
+// request sends body as JSON, if there is one, with headers and decodes
+// the response into out, if there is one.
+func (s *libraryScanner) request(method string, url string, headers map[string]string, body any, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), libraryScanTimeout)
+	defer cancel()
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "building request")
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "cbr2cbz/"+buildVersion)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("answered %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(out), "parsing response")
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_libraryScanner(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		komga    []string
+		kavita   []string
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		switch {
+		case r.URL.Path == "/komga/api/v1/libraries":
+			require.Equal(t, "komga-key", r.Header.Get("X-API-Key"))
+			fmt.Fprint(w, `[{"id":"0A","name":"Comics","root":"/comics"},{"id":"0B","name":"Manga","root":"/manga"}]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/komga/api/v1/libraries/0A/scan":
+			komga = append(komga, "0A")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPost && r.URL.Path == "/kavita/api/Library/scan-folder":
+			body := map[string]string{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Equal(t, "kavita-key", body["apiKey"])
+			kavita = append(kavita, body["folderPath"])
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+
+	s, err := newLibraryScanner(server.URL+"/komga/", "komga-key", server.URL+"/kavita", "kavita-key", []string{"/mnt/nas/Comics=/comics"}, testLogger{t})
+	require.NoError(t, err)
+	s.add("/mnt/nas/Comics/Saga/Saga 001.cbz", "/mnt/nas/Comics/Saga/Saga 002.cbz", "/elsewhere/Other.cbz")
+	s.refresh()
+
+	require.Equal(t, []string{"0A"}, komga)
+	require.Equal(t, []string{"/comics/Saga", "/elsewhere"}, kavita)
+
+	// nothing new, nothing asked
+	before := requests
+	s.refresh()
+	require.Equal(t, before, requests)
+}
+
+func Test_convertRefreshesLibraries(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga/001.cbt": makeTar(t, map[string]string{"001.jpg": "page"}),
+	})
+	require.NoError(t, err)
+
+	scanned := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		scanned = append(scanned, body["folderPath"])
+	}))
+	defer server.Close()
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	c.libraries, err = newLibraryScanner("", "", server.URL, "key", nil, testLogger{t})
+	require.NoError(t, err)
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Equal(t, []string{"/library/Saga"}, scanned)
+}
+
+func Test_newLibraryScanner(t *testing.T) {
+	s, err := newLibraryScanner("", "", "", "", nil, testLogger{t})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	_, err = newLibraryScanner("nas:25600", "key", "", "", nil, testLogger{t})
+	require.Error(t, err)
+	_, err = newLibraryScanner("http://nas:25600", "", "", "", nil, testLogger{t})
+	require.EqualError(t, err, "--komga-url needs --komga-api-key")
+	_, err = newLibraryScanner("", "", "http://nas:5000", "key", []string{"/mnt/nas"}, testLogger{t})
+	require.Error(t, err)
+}
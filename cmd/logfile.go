@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+var (
+	logAppend  bool
+	logMaxSize string
+	logKeep    int
+	// openedLogFile is the name --log-file was opened as, placeholders
+	// filled in
+	openedLogFile string
+)
+
+// logFileNameAt fills in {date} and {time} in name, for a log per batch
+// like cbr2cbz-{date}.log.
+func logFileNameAt(name string, now time.Time) string {
+	return strings.NewReplacer("{date}", now.Format("2006-01-02"), "{time}", now.Format("150405")).Replace(name)
+}
+
+// rotatingLog is the log file. With a maximum size it is moved aside to
+// name.1, name.1 to name.2 and so on up to keep of them once it would grow
+// past it, and started again.
+type rotatingLog struct {
+	name    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// openLogFile opens --log-file, appended to with --log-append and emptied
+// otherwise.
+func openLogFile(now time.Time) (*rotatingLog, error) {
+	l := &rotatingLog{name: logFileNameAt(logFileName, now), keep: logKeep}
+	if logMaxSize != "" {
+		size, err := humanize.ParseBytes(logMaxSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing --log-max-size")
+		}
+		l.maxSize = int64(size)
+	}
+	if l.keep < 1 {
+		return nil, errors.New("--log-keep must be at least 1")
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if logAppend {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(l.name, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.file, l.size = file, info.Size()
+	openedLogFile = l.name
+	return l, nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves the log aside, dropping the oldest past keep, and starts a
+// new one.
+func (l *rotatingLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.name, l.keep))
+	for n := l.keep - 1; n >= 1; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.name, n), fmt.Sprintf("%s.%d", l.name, n+1))
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if err := os.Rename(l.name, l.name+".1"); err != nil {
+		// one that can't be moved aside keeps growing rather than losing lines
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(l.name, flags, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+func (l *rotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_logFileNameAt(t *testing.T) {
+	now := time.Date(2024, 5, 1, 3, 4, 5, 0, time.UTC)
+	require.Equal(t, "cbr2cbz-2024-05-01.log", logFileNameAt("cbr2cbz-{date}.log", now))
+	require.Equal(t, "logs/2024-05-01-030405.log", logFileNameAt("logs/{date}-{time}.log", now))
+	require.Equal(t, "cbr2cbz.log", logFileNameAt("cbr2cbz.log", now))
+}
+
+func Test_openLogFile(t *testing.T) {
+	oldName, oldAppend, oldMax, oldKeep := logFileName, logAppend, logMaxSize, logKeep
+	defer func() { logFileName, logAppend, logMaxSize, logKeep = oldName, oldAppend, oldMax, oldKeep }()
+
+	dir := t.TempDir()
+	logFileName, logMaxSize, logKeep = filepath.Join(dir, "cbr2cbz.log"), "", 5
+	require.NoError(t, os.WriteFile(logFileName, []byte("a much longer log from the last batch\n"), 0666))
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// started afresh, nothing of the last batch left at the end
+	l, err := openLogFile(time.Now())
+	require.NoError(t, err)
+	l.Write([]byte("new\n"))
+	require.NoError(t, l.Close())
+	require.Equal(t, "new\n", read(logFileName))
+
+	logAppend = true
+	l, err = openLogFile(time.Now())
+	require.NoError(t, err)
+	l.Write([]byte("more\n"))
+	require.NoError(t, l.Close())
+	require.Equal(t, "new\nmore\n", read(logFileName))
+
+	logMaxSize, logKeep = "12B", 2
+	l, err = openLogFile(time.Now())
+	require.NoError(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = l.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+	require.Equal(t, "fourth\n", read(logFileName))
+	require.Equal(t, "third\n", read(logFileName+".1"))
+	require.Equal(t, "second\n", read(logFileName+".2"))
+	require.NoFileExists(t, logFileName+".3")
+	require.Equal(t, logFileName, openedLogFile)
+}
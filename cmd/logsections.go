@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// logSections groups what is logged about each file while several convert
+// at once. A file's lines are held back until it is done and then written
+// together, so they don't interleave with those of other files. Anything
+// logged about no file in particular is written straight away.
+type logSections struct {
+	out logger
+
+	mu       sync.Mutex
+	sections map[string]*strings.Builder
+}
+
+func newLogSections(out logger) *logSections {
+	return &logSections{out: out, sections: map[string]*strings.Builder{}}
+}
+
+func (s *logSections) Printf(format string, v ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Printf(format, v...)
+}
+
+func (s *logSections) Println(v ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Println(v...)
+}
+
+// open starts holding back the lines about file.
+func (s *logSections) open(file string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sections[file] = &strings.Builder{}
+}
+
+// flush writes the lines held back about file, a line at a time so each
+// gets the logger's prefix, and stops holding them back.
+func (s *logSections) flush(file string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	section, ok := s.sections[file]
+	if !ok {
+		return
+	}
+	delete(s.sections, file)
+	for _, line := range strings.SplitAfter(section.String(), "\n") {
+		if line != "" {
+			s.out.Printf("%s", line)
+		}
+	}
+}
+
+// section is the logger for lines about file.
+func (s *logSections) section(file string) logger {
+	return sectionLogger{s: s, file: file}
+}
+
+type sectionLogger struct {
+	s    *logSections
+	file string
+}
+
+func (l sectionLogger) Printf(format string, v ...any) {
+	l.write(fmt.Sprintf(format, v...))
+}
+
+func (l sectionLogger) Println(v ...any) {
+	l.write(fmt.Sprintln(v...))
+}
+
+func (l sectionLogger) write(msg string) {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+	section, ok := l.s.sections[l.file]
+	if !ok {
+		l.s.out.Printf("%s", msg)
+		return
+	}
+	section.WriteString(msg)
+	if !strings.HasSuffix(msg, "\n") {
+		section.WriteString("\n")
+	}
+}
+
+// logFor is the logger for lines about file, held back with the rest of
+// them when files convert in parallel.
+func (c *converter) logFor(file string) logger {
+	if c.sections == nil {
+		return c.logger
+	}
+	return c.sections.section(file)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_logSections(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := newLogSections(log.New(buf, "> ", 0))
+	s.open("/a.cbr")
+	s.open("/b.cbr")
+
+	a, b := s.section("/a.cbr"), s.section("/b.cbr")
+	a.Printf("Converting: %s\n", "/a.cbr")
+	b.Printf("Converting: %s\n", "/b.cbr")
+	s.Printf("Still converting /a.cbr\n")
+	a.Printf("line one\nline two")
+	b.Println("Successfully Converted", "/b.cbr")
+	s.flush("/b.cbr")
+	s.flush("/a.cbr")
+	s.section("/c.cbr").Printf("not held back\n")
+
+	require.Equal(t, `> Still converting /a.cbr
+> Converting: /b.cbr
+> Successfully Converted /b.cbr
+> Converting: /a.cbr
+> line one
+> line two
+> not held back
+`, buf.String())
+}
+
+func Test_convertParallelLogs(t *testing.T) {
+	fixtures := filenameBytes{}
+	for i := 1; i <= 6; i++ {
+		fixtures[fmt.Sprintf("library/%03d.cbr", i)] = realCBRContents
+	}
+	fsys, err := setupFS(t, fixtures)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	c := &converter{fs: fsys, logger: log.New(buf, "", 0), jobs: 3}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Nil(t, c.sections)
+
+	// nothing about another file comes between a file's first and last line
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		cbrFile, ok := strings.CutPrefix(line, "Converting: ")
+		if !ok {
+			continue
+		}
+		cbrFile, _, _ = strings.Cut(cbrFile, " to ")
+		for _, next := range lines[i+1:] {
+			if strings.HasPrefix(next, "Successfully Converted ") {
+				require.True(t, strings.HasPrefix(next, "Successfully Converted "+cbrFile), "the lines of %s are together", cbrFile)
+				break
+			}
+			require.NotContains(t, next, "Converting: ")
+		}
+	}
+	require.Contains(t, buf.String(), "Converting 3 files at a time")
+}
@@ -0,0 +1,370 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lookupSource    string
+	comicVineAPIKey string
+	metronUser      string
+	metronPassword  string
+	lookupCacheDir  string
+	lookupCacheTTL  time.Duration
+	lookupInterval  time.Duration
+	lookupOverwrite bool
+)
+
+// lookupSources are the services meta lookup can ask, with how long to wait
+// between requests to stay within their limits.
+var lookupSources = map[string]time.Duration{
+	// 200 requests an hour for each kind of resource, and no bursts
+	"comicvine": 2 * time.Second,
+	// 30 requests a minute
+	"metron": 2 * time.Second,
+}
+
+var metaLookupCmd = &cobra.Command{
+	Use:   "lookup [paths...]",
+	Short: "Fills in ComicInfo.xml from ComicVine or Metron",
+	Long: `Looks up the series and issue of each cbz, from its ComicInfo.xml or else its file
+name, on ComicVine or Metron and fills in ComicInfo.xml with the title, date,
+summary, credits, publisher and link found. Fields already set are kept unless
+--metadata-overwrite is given.
+
+ComicVine needs an API key, Metron an account. Both can go in the config file:
+
+  metadata-source: metron
+  metron-user: me
+  metron-password: secret
+
+Responses are cached in --metadata-cache, so looking up the same series again
+doesn't ask again, and requests are spaced out to keep within each service's
+rate limits.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		m := newMetaTool()
+		l, err := newMetadataLookup(m.logger)
+		if err != nil {
+			m.logger.Fatal(err)
+		}
+		err = m.run(cmd.Context(), args, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+			return l.fill(cmd.Context(), file, info)
+		})
+		if err != nil {
+			m.logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	metaCmd.AddCommand(metaLookupCmd)
+
+	metaLookupCmd.Flags().StringVar(&lookupSource, "metadata-source", "comicvine", "where to look comics up, comicvine or metron")
+	metaLookupCmd.Flags().StringVar(&comicVineAPIKey, "comicvine-api-key", "", "ComicVine API key, from https://comicvine.gamespot.com/api/")
+	metaLookupCmd.Flags().StringVar(&metronUser, "metron-user", "", "Metron user name")
+	metaLookupCmd.Flags().StringVar(&metronPassword, "metron-password", "", "Metron password")
+	metaLookupCmd.Flags().StringVar(&lookupCacheDir, "metadata-cache", defaultLookupCacheDir(), "directory responses are cached in, empty to disable")
+	metaLookupCmd.Flags().DurationVar(&lookupCacheTTL, "metadata-cache-ttl", 30*24*time.Hour, "how long cached responses are used for")
+	metaLookupCmd.Flags().DurationVar(&lookupInterval, "metadata-interval", 0, "time between requests, 0 for what the source allows")
+	metaLookupCmd.Flags().BoolVar(&lookupOverwrite, "metadata-overwrite", false, "replace fields ComicInfo.xml already has")
+}
+
+func defaultLookupCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "metadata")
+}
+
+// metadataSource finds an issue on a service, nil if it has none matching.
+type metadataSource interface {
+	findIssue(ctx context.Context, series string, number string, year int) (*cbr2cbz.ComicInfo, error)
+}
+
+// metadataLookup fills in ComicInfo from a metadataSource.
+type metadataLookup struct {
+	source    metadataSource
+	name      string
+	logger    logger
+	overwrite bool
+}
+
+// newMetadataLookup builds the lookup the flags ask for.
+func newMetadataLookup(logger logger) (*metadataLookup, error) {
+	interval, ok := lookupSources[lookupSource]
+	if !ok {
+		return nil, errors.Errorf("unknown --metadata-source %q, expected comicvine or metron", lookupSource)
+	}
+	if lookupInterval > 0 {
+		interval = lookupInterval
+	}
+	client := &lookupClient{
+		http:     &http.Client{Timeout: 30 * time.Second},
+		cache:    lookupCache{dir: lookupCacheDir, ttl: lookupCacheTTL},
+		interval: interval,
+	}
+
+	l := &metadataLookup{name: lookupSource, logger: logger, overwrite: lookupOverwrite}
+	switch lookupSource {
+	case "comicvine":
+		if comicVineAPIKey == "" {
+			return nil, errors.New("--comicvine-api-key is needed to look comics up on ComicVine")
+		}
+		l.source = &comicVine{client: client, baseURL: comicVineURL, apiKey: comicVineAPIKey}
+	case "metron":
+		if metronUser == "" || metronPassword == "" {
+			return nil, errors.New("--metron-user and --metron-password are needed to look comics up on Metron")
+		}
+		l.source = &metron{client: client, baseURL: metronURL, user: metronUser, password: metronPassword}
+	}
+	return l, nil
+}
+
+// fill looks file up by the series and number in info, or in its name when
+// info has none, and sets the fields found. It reports false when nothing
+// was found or changed.
+func (l *metadataLookup) fill(ctx context.Context, file string, info *cbr2cbz.ComicInfo) (bool, error) {
+	series, number, year := info.Series, info.Number, info.Year
+	if series == "" || number == "" {
+		guess := cbr2cbz.ComicInfoFromFilename(file)
+		series, number = guess.Series, guess.Number
+		if year == 0 {
+			year = guess.Year
+		}
+	}
+	if series == "" || number == "" {
+		l.logger.Printf("No series and issue number for %s, not looking it up\n", file)
+		return false, nil
+	}
+
+	found, err := l.source.findIssue(ctx, series, cbr2cbz.TrimIssueNumber(number), year)
+	if err != nil {
+		return false, errors.Wrapf(err, "looking up %s #%s on %s", series, number, l.name)
+	}
+	if found == nil {
+		l.logger.Printf("No match for %s #%s on %s, leaving %s as it is\n", series, number, l.name, file)
+		return false, nil
+	}
+
+	changed := false
+	for _, field := range metaFields() {
+		value := field.get(found)
+		if value == "" || value == field.get(info) || (!l.overwrite && field.get(info) != "") {
+			continue
+		}
+		if err := field.set(info, value); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	if changed {
+		l.logger.Printf("Found %s #%s on %s for %s\n", series, number, l.name, file)
+	}
+	return changed, nil
+}
+
+// lookupAll fills in the ComicInfo of files, for the lookup step of run.
+func (l *metadataLookup) lookupAll(ctx context.Context, m *metaTool, files []string) error {
+	if len(files) == 0 {
+		l.logger.Printf("Nothing to look up\n")
+		return nil
+	}
+	return m.run(ctx, files, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+		return l.fill(ctx, file, info)
+	})
+}
+
+// lookupClient gets JSON from a service, from the cache when it can and
+// otherwise no more often than every interval.
+type lookupClient struct {
+	http     *http.Client
+	cache    lookupCache
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// getJSON decodes the response to req into out. key names the request in
+// the cache, without any credentials.
+func (c *lookupClient) getJSON(req *http.Request, key string, out any) error {
+	if data, ok := c.cache.get(key); ok {
+		return errors.Wrap(json.Unmarshal(data, out), "reading cached response")
+	}
+
+	if err := c.wait(req.Context()); err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "cbr2cbz/"+buildVersion)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// the error of the client names the whole url, api keys and all
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return errors.Wrapf(err, "requesting %s%s", req.URL.Host, req.URL.Path)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return errors.Wrap(err, "reading response")
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return errors.Errorf("%s, check the credentials", resp.Status)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == 420:
+		return errors.Errorf("%s, rate limited, try again later or raise --metadata-interval", resp.Status)
+	case resp.StatusCode >= 300:
+		return errors.Errorf("answered %s", resp.Status)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return errors.Wrap(err, "parsing response")
+	}
+	// errors some services answer with 200 aren't cached
+	if checked, ok := out.(interface{ check() error }); ok {
+		if err := checked.check(); err != nil {
+			return err
+		}
+	}
+	c.cache.put(key, data)
+	return nil
+}
+
+// wait blocks until the next request may go out.
+func (c *lookupClient) wait(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	at := c.next
+	if at.Before(now) {
+		at = now
+	}
+	c.next = at.Add(c.interval)
+	c.mu.Unlock()
+	if !at.After(now) {
+		return nil
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// lookupCache keeps responses in dir, named after the SHA-256 of their key,
+// for ttl. An empty dir caches nothing.
+type lookupCache struct {
+	dir string
+	ttl time.Duration
+}
+
+func (c lookupCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name+".json")
+}
+
+func (c lookupCache) get(key string) ([]byte, bool) {
+	if c.dir == "" {
+		return nil, false
+	}
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > c.ttl {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	return data, err == nil
+}
+
+// put caches data, a cache that can't be written to only means asking again.
+func (c lookupCache) put(key string, data []byte) {
+	if c.dir == "" {
+		return
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	part, err := os.CreateTemp(filepath.Dir(path), ".response-*")
+	if err != nil {
+		return
+	}
+	_, err = part.Write(data)
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(part.Name(), path)
+	}
+	if err != nil {
+		os.Remove(part.Name())
+	}
+}
+
+// matchName is name reduced to compare series names by, lower case letters
+// and digits without a leading "the".
+func matchName(name string) string {
+	b := strings.Builder{}
+	for _, r := range strings.ToLower(name) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r > 127 {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimPrefix(b.String(), "the")
+}
+
+// seriesCandidate is a series a source's search found.
+type seriesCandidate struct {
+	id        int
+	name      string
+	startYear int
+	issues    int
+}
+
+// pickSeries picks the candidate named series. With a year, it's the one
+// started last by then, a reboot rather than the original run; without one,
+// the longest.
+func pickSeries(candidates []seriesCandidate, series string, year int) *seriesCandidate {
+	var best *seriesCandidate
+	for i := range candidates {
+		c := &candidates[i]
+		if matchName(c.name) != matchName(series) {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = c
+		case year > 0:
+			started := c.startYear > 0 && c.startYear <= year
+			bestStarted := best.startYear > 0 && best.startYear <= year
+			if started && (!bestStarted || c.startYear > best.startYear) {
+				best = c
+			}
+		case c.issues > best.issues:
+			best = c
+		}
+	}
+	return best
+}
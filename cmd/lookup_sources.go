@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	comicVineURL = "https://comicvine.gamespot.com/api"
+	metronURL    = "https://metron.cloud/api"
+)
+
+// comicVine looks issues up with the ComicVine API: the series (a volume, in
+// its terms) by name, then the issue by number within it.
+type comicVine struct {
+	client  *lookupClient
+	baseURL string
+	apiKey  string
+}
+
+// comicVineResponse is what every ComicVine request answers, with errors
+// like a bad key given with a 200.
+type comicVineResponse[T any] struct {
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	Results    T      `json:"results"`
+}
+
+func (r *comicVineResponse[T]) check() error {
+	if r.StatusCode != 1 {
+		return errors.Errorf("ComicVine answered %q", r.Error)
+	}
+	return nil
+}
+
+type comicVineIssue struct {
+	Name          string `json:"name"`
+	IssueNumber   string `json:"issue_number"`
+	CoverDate     string `json:"cover_date"`
+	Description   string `json:"description"`
+	SiteDetailURL string `json:"site_detail_url"`
+	PersonCredits []struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	} `json:"person_credits"`
+}
+
+func (cv *comicVine) get(ctx context.Context, resource string, query url.Values, out any) error {
+	query.Set("format", "json")
+	key := "comicvine " + resource + "?" + query.Encode()
+	query.Set("api_key", cv.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cv.baseURL+"/"+resource+"/?"+query.Encode(), nil)
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+	return cv.client.getJSON(req, key, out)
+}
+
+func (cv *comicVine) findIssue(ctx context.Context, series string, number string, year int) (*cbr2cbz.ComicInfo, error) {
+	volumes := comicVineResponse[[]struct {
+		ID            int    `json:"id"`
+		Name          string `json:"name"`
+		StartYear     string `json:"start_year"`
+		CountOfIssues int    `json:"count_of_issues"`
+		Publisher     *struct {
+			Name string `json:"name"`
+		} `json:"publisher"`
+	}]{}
+	err := cv.get(ctx, "search", url.Values{
+		"resources":  {"volume"},
+		"query":      {series},
+		"field_list": {"id,name,start_year,count_of_issues,publisher"},
+		"limit":      {"50"},
+	}, &volumes)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []seriesCandidate{}
+	for _, v := range volumes.Results {
+		start, _ := strconv.Atoi(v.StartYear)
+		candidates = append(candidates, seriesCandidate{id: v.ID, name: v.Name, startYear: start, issues: v.CountOfIssues})
+	}
+	picked := pickSeries(candidates, series, year)
+	if picked == nil {
+		return nil, nil
+	}
+
+	issues := comicVineResponse[[]struct {
+		ID int `json:"id"`
+	}]{}
+	err = cv.get(ctx, "issues", url.Values{
+		"filter":     {fmt.Sprintf("volume:%d,issue_number:%s", picked.id, number)},
+		"field_list": {"id"},
+	}, &issues)
+	if err != nil || len(issues.Results) == 0 {
+		return nil, err
+	}
+
+	issue := comicVineResponse[comicVineIssue]{}
+	err = cv.get(ctx, fmt.Sprintf("issue/4000-%d", issues.Results[0].ID), url.Values{
+		"field_list": {"name,issue_number,cover_date,description,site_detail_url,person_credits"},
+	}, &issue)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &cbr2cbz.ComicInfo{
+		Title:   issue.Results.Name,
+		Series:  picked.name,
+		Number:  issue.Results.IssueNumber,
+		Count:   picked.issues,
+		Summary: plainText(issue.Results.Description),
+		Web:     issue.Results.SiteDetailURL,
+	}
+	for _, v := range volumes.Results {
+		if v.ID == picked.id && v.Publisher != nil {
+			info.Publisher = v.Publisher.Name
+		}
+	}
+	setCoverDate(info, issue.Results.CoverDate)
+	for _, credit := range issue.Results.PersonCredits {
+		for _, role := range strings.Split(credit.Role, ",") {
+			addCredit(info, credit.Name, role)
+		}
+	}
+	return info, nil
+}
+
+// metron looks issues up with the Metron API, the series by name and then
+// the issue by number within it.
+type metron struct {
+	client   *lookupClient
+	baseURL  string
+	user     string
+	password string
+}
+
+type metronPage[T any] struct {
+	Results []T `json:"results"`
+}
+
+type metronIssue struct {
+	Publisher struct {
+		Name string `json:"name"`
+	} `json:"publisher"`
+	Series struct {
+		Name string `json:"name"`
+	} `json:"series"`
+	Number      string   `json:"number"`
+	Title       string   `json:"title"`
+	Stories     []string `json:"name"`
+	CoverDate   string   `json:"cover_date"`
+	Description string   `json:"desc"`
+	ResourceURL string   `json:"resource_url"`
+	Credits     []struct {
+		Creator string `json:"creator"`
+		Role    []struct {
+			Name string `json:"name"`
+		} `json:"role"`
+	} `json:"credits"`
+}
+
+// metronSeriesYear is the " (2012)" Metron puts after series names in lists.
+var metronSeriesYear = regexp.MustCompile(`\s*\(\d{4}\)$`)
+
+func (m *metron) get(ctx context.Context, resource string, query url.Values, out any) error {
+	key := "metron " + resource + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+"/"+resource+"/?"+query.Encode(), nil)
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+	req.SetBasicAuth(m.user, m.password)
+	return m.client.getJSON(req, key, out)
+}
+
+func (m *metron) findIssue(ctx context.Context, series string, number string, year int) (*cbr2cbz.ComicInfo, error) {
+	found := metronPage[struct {
+		ID         int    `json:"id"`
+		Series     string `json:"series"`
+		YearBegan  int    `json:"year_began"`
+		IssueCount int    `json:"issue_count"`
+	}]{}
+	err := m.get(ctx, "series", url.Values{"name": {series}}, &found)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []seriesCandidate{}
+	for _, s := range found.Results {
+		candidates = append(candidates, seriesCandidate{id: s.ID, name: metronSeriesYear.ReplaceAllString(s.Series, ""), startYear: s.YearBegan, issues: s.IssueCount})
+	}
+	picked := pickSeries(candidates, series, year)
+	if picked == nil {
+		return nil, nil
+	}
+
+	issues := metronPage[struct {
+		ID int `json:"id"`
+	}]{}
+	err = m.get(ctx, "issue", url.Values{"series_id": {strconv.Itoa(picked.id)}, "number": {number}}, &issues)
+	if err != nil || len(issues.Results) == 0 {
+		return nil, err
+	}
+
+	issue := metronIssue{}
+	err = m.get(ctx, fmt.Sprintf("issue/%d", issues.Results[0].ID), url.Values{}, &issue)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &cbr2cbz.ComicInfo{
+		Title:     issue.Title,
+		Series:    issue.Series.Name,
+		Number:    issue.Number,
+		Count:     picked.issues,
+		Summary:   issue.Description,
+		Publisher: issue.Publisher.Name,
+		Web:       issue.ResourceURL,
+	}
+	if info.Title == "" {
+		info.Title = strings.Join(issue.Stories, "; ")
+	}
+	setCoverDate(info, issue.CoverDate)
+	for _, credit := range issue.Credits {
+		for _, role := range credit.Role {
+			addCredit(info, credit.Creator, role.Name)
+		}
+	}
+	return info, nil
+}
+
+// setCoverDate sets the year, month and day of a 2012-03-14 cover date.
+func setCoverDate(info *cbr2cbz.ComicInfo, date string) {
+	parts := strings.Split(date, "-")
+	fields := []*int{&info.Year, &info.Month, &info.Day}
+	for i := 0; i < len(parts) && i < len(fields); i++ {
+		*fields[i], _ = strconv.Atoi(parts[i])
+	}
+}
+
+// addCredit adds name to the ComicInfo field for role, if there is one.
+func addCredit(info *cbr2cbz.ComicInfo, name string, role string) {
+	var field *string
+	switch strings.ToLower(strings.TrimSpace(role)) {
+	case "writer", "script", "story", "plot", "plotter":
+		field = &info.Writer
+	case "artist", "penciller", "penciler", "pencils":
+		field = &info.Penciller
+	case "inker", "inks":
+		field = &info.Inker
+	case "colorist", "colourist", "colors":
+		field = &info.Colorist
+	case "letterer", "letters":
+		field = &info.Letterer
+	case "cover", "cover artist":
+		field = &info.CoverArtist
+	case "editor":
+		field = &info.Editor
+	default:
+		return
+	}
+	if name == "" || containsFold(strings.Split(*field, ","), name) {
+		return
+	}
+	if *field != "" {
+		*field += ", "
+	}
+	*field += name
+}
+
+// plainText is the text of an HTML description, a line per paragraph.
+func plainText(description string) string {
+	lines := []string{}
+	line := strings.Builder{}
+	endLine := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+	z := html.NewTokenizer(strings.NewReader(description))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			endLine()
+			return strings.Join(lines, "\n")
+		case html.TextToken:
+			line.Write(z.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "p", "br", "div", "li", "h1", "h2", "h3", "h4", "tr":
+				endLine()
+			}
+		}
+	}
+}
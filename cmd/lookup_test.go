@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_metadataLookup_comicVine(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.Equal(t, "key", r.URL.Query().Get("api_key"))
+		switch r.URL.Path {
+		case "/search/":
+			require.Equal(t, "Saga", r.URL.Query().Get("query"))
+			fmt.Fprint(w, `{"status_code":1,"error":"OK","results":[
+				{"id":1,"name":"Saga","start_year":"1990","count_of_issues":3},
+				{"id":2,"name":"Saga","start_year":"2012","count_of_issues":66,"publisher":{"name":"Image"}},
+				{"id":3,"name":"Saga of the Swamp Thing","start_year":"1982","count_of_issues":45}]}`)
+		case "/issues/":
+			require.Equal(t, "volume:2,issue_number:1", r.URL.Query().Get("filter"))
+			fmt.Fprint(w, `{"status_code":1,"error":"OK","results":[{"id":300}]}`)
+		case "/issue/4000-300/":
+			fmt.Fprint(w, `{"status_code":1,"error":"OK","results":{"name":"Chapter One","issue_number":"1",
+				"cover_date":"2012-03-14","description":"<p>A <em>space</em> opera.</p><p>Begins.</p>",
+				"site_detail_url":"https://comicvine.gamespot.com/saga-1/",
+				"person_credits":[{"name":"Brian K. Vaughan","role":"writer"},{"name":"Fiona Staples","role":"artist, cover, colorist"}]}}`)
+		default:
+			t.Errorf("unexpected request for %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	client := &lookupClient{http: server.Client(), cache: lookupCache{dir: t.TempDir(), ttl: time.Hour}}
+	l := &metadataLookup{source: &comicVine{client: client, baseURL: server.URL, apiKey: "key"}, name: "comicvine", logger: testLogger{t}}
+
+	info := &cbr2cbz.ComicInfo{Writer: "Someone"}
+	changed, err := l.fill(context.Background(), "/comics/Saga 001 (2012) (Digital).cbz", info)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, &cbr2cbz.ComicInfo{
+		Title: "Chapter One", Series: "Saga", Number: "1", Count: 66, Summary: "A space opera.\nBegins.",
+		Year: 2012, Month: 3, Day: 14, Writer: "Someone", Penciller: "Fiona Staples", Colorist: "Fiona Staples",
+		CoverArtist: "Fiona Staples", Publisher: "Image", Web: "https://comicvine.gamespot.com/saga-1/",
+	}, info)
+	require.Equal(t, 3, requests)
+
+	// answered from the cache the second time
+	_, err = l.fill(context.Background(), "/comics/Saga 001 (2012) (Digital).cbz", &cbr2cbz.ComicInfo{})
+	require.NoError(t, err)
+	require.Equal(t, 3, requests)
+}
+
+func Test_metadataLookup_metron(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/Paper Girls 003.cbz": makeZip(t, map[string]string{"001.jpg": "page"}),
+		"comics/Unknown 001.cbz":     makeZip(t, map[string]string{"001.jpg": "page"}),
+	})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		require.Equal(t, "me:secret", user+":"+password)
+		switch {
+		case r.URL.Path == "/series/" && r.URL.Query().Get("name") == "Paper Girls":
+			fmt.Fprint(w, `{"results":[{"id":7,"series":"Paper Girls (2015)","year_began":2015,"issue_count":30}]}`)
+		case r.URL.Path == "/series/":
+			fmt.Fprint(w, `{"results":[]}`)
+		case r.URL.Path == "/issue/":
+			require.Equal(t, "7", r.URL.Query().Get("series_id"))
+			require.Equal(t, "3", r.URL.Query().Get("number"))
+			fmt.Fprint(w, `{"results":[{"id":70}]}`)
+		case r.URL.Path == "/issue/70/":
+			fmt.Fprint(w, `{"publisher":{"name":"Image"},"series":{"name":"Paper Girls"},"number":"3","name":["Part Three"],
+				"cover_date":"2015-12-01","credits":[{"creator":"Cliff Chiang","role":[{"name":"Artist"},{"name":"Cover"}]}]}`)
+		default:
+			t.Errorf("unexpected request for %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	client := &lookupClient{http: server.Client()}
+	l := &metadataLookup{source: &metron{client: client, baseURL: server.URL, user: "me", password: "secret"}, name: "metron", logger: testLogger{t}}
+	m := &metaTool{fs: fsys, logger: log.New(&bytes.Buffer{}, "", 0)}
+	require.NoError(t, l.lookupAll(context.Background(), m, []string{"/comics/Paper Girls 003.cbz", "/comics/Unknown 001.cbz"}))
+
+	zr, f, err := openZip(fsys, "comics/Paper Girls 003.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := readZipComicInfo(zr)
+	require.NoError(t, err)
+	require.Equal(t, "Part Three", info.Title)
+	require.Equal(t, "Cliff Chiang", info.Penciller)
+	require.Equal(t, "Cliff Chiang", info.CoverArtist)
+	require.Equal(t, 12, info.Month)
+}
+
+func Test_lookupClient_errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/search/" {
+			fmt.Fprint(w, `{"status_code":100,"error":"Invalid API Key","results":[]}`)
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := &lookupClient{http: server.Client(), cache: lookupCache{dir: dir, ttl: time.Hour}}
+	_, err := (&comicVine{client: client, baseURL: server.URL}).findIssue(context.Background(), "Saga", "1", 0)
+	require.ErrorContains(t, err, "Invalid API Key")
+	_, err = (&metron{client: client, baseURL: server.URL}).findIssue(context.Background(), "Saga", "1", 0)
+	require.ErrorContains(t, err, "rate limited")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "errors aren't cached")
+}
+
+func Test_lookupClient_redactsKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	client := &lookupClient{http: server.Client(), cache: lookupCache{dir: t.TempDir(), ttl: time.Hour}}
+	_, err := (&comicVine{client: client, baseURL: server.URL, apiKey: "secret-key"}).findIssue(context.Background(), "Saga", "1", 0)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret-key")
+	require.Contains(t, err.Error(), "/search/")
+}
+
+func Test_pickSeries(t *testing.T) {
+	candidates := []seriesCandidate{
+		{id: 1, name: "The Flash", startYear: 1959, issues: 350},
+		{id: 2, name: "Flash", startYear: 2011, issues: 52},
+		{id: 3, name: "Flash", startYear: 2016, issues: 88},
+		{id: 4, name: "Flashpoint", startYear: 2011, issues: 5},
+	}
+	require.Equal(t, 2, pickSeries(candidates, "flash", 2012).id)
+	require.Equal(t, 1, pickSeries(candidates, "Flash", 1960).id)
+	require.Equal(t, 1, pickSeries(candidates, "Flash", 0).id)
+	require.Nil(t, pickSeries(candidates, "Arrow", 0))
+}
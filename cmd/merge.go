@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mergeOutput    string
+	mergeComicInfo bool
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge [paths...] --output volume.cbz",
+	Short: "Combines several archives, like single issues, into one cbz volume",
+	Long: `Puts the pages of each cbz, cbr, cb7, cbt and pdf together into one cbz, in the order
+they are given, the archives in a folder in the order they are found. The pages are
+renamed after the archive they came from and their place in it, c01_001.jpg,
+c01_002.jpg, c02_001.jpg and so on, so every reader shows them in that order.
+Anything that isn't a page is left out.
+
+--comic-info gives the volume a ComicInfo.xml made from those of the issues, the
+series, publisher and date of the first and the credits of all of them, with a
+bookmark where each issue starts.
+
+The archives merged are left alone, and an existing volume is never overwritten.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		m := &volumeMerger{fs: fsys, logger: logger, comicInfo: mergeComicInfo}
+		err = m.run(cmd.Context(), args, mergeOutput)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "the cbz to write the volume to")
+	mergeCmd.Flags().BoolVar(&mergeComicInfo, "comic-info", false, "give the volume a ComicInfo.xml made from those of the issues, with a bookmark where each starts")
+	mergeCmd.MarkFlagRequired("output")
+}
+
+// mergedExtensions are the archives merge reads.
+var mergedExtensions = append([]string{".pdf"}, verifiedExtensions...)
+
+type volumeMerger struct {
+	fs        hackpadfs.FS
+	logger    logger
+	comicInfo bool
+}
+
+func (m *volumeMerger) run(ctx context.Context, paths []string, output string) error {
+	files, err := findArchives(m.fs, paths, "cbz, cbr, cb7, cbt or pdf", mergedExtensions...)
+	if err != nil {
+		return err
+	}
+	if len(files) < 2 {
+		return errors.Errorf("only found %s, merging needs at least two archives", files[0])
+	}
+	pages, err := m.merge(ctx, files, output)
+	if err != nil {
+		return err
+	}
+	m.logger.Printf("Merged %d archives into %s, %d pages\n", len(files), output, pages)
+	return nil
+}
+
+// merge writes the pages of files to output, returning how many there were.
+func (m *volumeMerger) merge(ctx context.Context, files []string, output string) (int, error) {
+	dest := pathToFsPath(output)
+	if _, err := hackpadfs.Stat(m.fs, dest); err == nil {
+		return 0, errors.Errorf("%s already exists", output)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, errors.Wrap(err, "checking for the volume")
+	}
+
+	engine, err := cbr2cbz.New(cbr2cbz.Options{PageOrder: cbr2cbz.DefaultPageOrder})
+	if err != nil {
+		return 0, err
+	}
+	engine.Logger = m.logger
+
+	// the sources stay open until the volume is written, entries are only
+	// read as they get packed
+	issues := []cbr2cbz.Issue{}
+	for _, file := range files {
+		src, entries, err := openEntries(ctx, m.fs, engine, file)
+		if err != nil {
+			return 0, errors.Wrapf(err, "reading %s", file)
+		}
+		defer src.Close()
+		issues = append(issues, cbr2cbz.Issue{Name: file, Files: entries})
+	}
+	entries, err := engine.Merge(output, issues, m.comicInfo)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := tempName(dest)
+	out, err := hackpadfs.Create(m.fs, tmp)
+	if err != nil {
+		return 0, errors.Wrap(err, "creating cbz")
+	}
+	w, ok := out.(io.Writer)
+	if !ok {
+		out.Close()
+		hackpadfs.Remove(m.fs, tmp)
+		return 0, errors.New("destination isn't a writable filesystem")
+	}
+	err = engine.Pack(ctx, output, entries, w, &cbr2cbz.Progress{})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyZip(m.fs, tmp)
+	}
+	if err == nil {
+		err = hackpadfs.Rename(m.fs, tmp, dest)
+	}
+	if err != nil {
+		hackpadfs.Remove(m.fs, tmp)
+		return 0, err
+	}
+
+	pages := len(entries)
+	if m.comicInfo {
+		pages--
+	}
+	return pages, nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_volumeMerger(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"issues/Saga 001.cbz": makeZip(t, map[string]string{"b.jpg": "second", "a.jpg": "first", "ComicInfo.xml": "<ComicInfo><Title>One</Title></ComicInfo>"}),
+		"issues/Saga 002.cbz": makeZip(t, map[string]string{"page.png": "third"}),
+	})
+	require.NoError(t, err)
+
+	m := &volumeMerger{fs: fsys, logger: testLogger{t}, comicInfo: true}
+	require.NoError(t, m.run(context.Background(), []string{"/issues"}, "/Saga Vol 1.cbz"))
+
+	data, err := hackpadfs.ReadFile(fsys, "Saga Vol 1.cbz")
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"c01_001.jpg", "c01_002.jpg", "c02_001.png", "ComicInfo.xml"}, names)
+	rc, err := zr.File[0].Open()
+	require.NoError(t, err)
+	first, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(first))
+
+	// the issues are still there
+	_, err = hackpadfs.Stat(fsys, "issues/Saga 002.cbz")
+	require.NoError(t, err)
+
+	// and the volume isn't written over
+	require.ErrorContains(t, m.run(context.Background(), []string{"/issues"}, "/Saga Vol 1.cbz"), "already exists")
+	require.ErrorContains(t, m.run(context.Background(), []string{"/issues/Saga 001.cbz"}, "/other.cbz"), "at least two")
+}
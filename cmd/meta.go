@@ -0,0 +1,362 @@
+package cmd
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var metaFieldsFlag []string
+
+// metaCmd represents the meta command
+var metaCmd = &cobra.Command{
+	Use:   "meta",
+	Short: "Reads and edits ComicInfo.xml inside existing cbz files",
+}
+
+var metaGetCmd = &cobra.Command{
+	Use:   "get [paths...]",
+	Short: "Prints ComicInfo.xml fields",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		m := newMetaTool()
+		err := m.run(cmd.Context(), args, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+			for _, field := range metaFields() {
+				if len(metaFieldsFlag) > 0 && !containsFold(metaFieldsFlag, field.flag) {
+					continue
+				}
+				value := field.get(info)
+				if value == "" {
+					continue
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\n", file, field.name, value)
+			}
+			return false, nil
+		})
+		if err != nil {
+			m.logger.Fatal(err)
+		}
+	},
+}
+
+var metaSetCmd = &cobra.Command{
+	Use:   "set [paths...]",
+	Short: "Sets ComicInfo.xml fields, e.g. meta set --series Saga --volume 1 dir/",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		values := map[metaField]string{}
+		for _, field := range metaFields() {
+			if cmd.Flags().Changed(field.flag) {
+				values[field], _ = cmd.Flags().GetString(field.flag)
+			}
+		}
+
+		m := newMetaTool()
+		if len(values) == 0 {
+			m.logger.Fatal("nothing to set")
+		}
+
+		err := m.run(cmd.Context(), args, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+			for field, value := range values {
+				if err := field.set(info, value); err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			m.logger.Fatal(err)
+		}
+	},
+}
+
+var metaDelCmd = &cobra.Command{
+	Use:   "del [paths...]",
+	Short: "Removes ComicInfo.xml fields, e.g. meta del --field series,volume dir/",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		m := newMetaTool()
+		if len(metaFieldsFlag) == 0 {
+			m.logger.Fatal("no --field given to delete")
+		}
+
+		err := m.run(cmd.Context(), args, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+			changed := false
+			for _, field := range metaFields() {
+				if containsFold(metaFieldsFlag, field.flag) && field.get(info) != "" {
+					field.set(info, "")
+					changed = true
+				}
+			}
+			return changed, nil
+		})
+		if err != nil {
+			m.logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(metaCmd)
+	metaCmd.AddCommand(metaGetCmd, metaSetCmd, metaDelCmd)
+
+	metaGetCmd.Flags().StringSliceVar(&metaFieldsFlag, "field", nil, "only print these fields")
+	metaDelCmd.Flags().StringSliceVar(&metaFieldsFlag, "field", nil, "fields to remove")
+	for _, field := range metaFields() {
+		metaSetCmd.Flags().String(field.flag, "", "set "+field.name)
+	}
+}
+
+// metaField is one editable element of ComicInfo, found by reflection so
+// every simple field in the struct gets a flag for free.
+type metaField struct {
+	name  string
+	flag  string
+	index int
+}
+
+func metaFields() []metaField {
+	fields := []metaField{}
+	t := reflect.TypeOf(cbr2cbz.ComicInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() != reflect.String && f.Type.Kind() != reflect.Int {
+			continue
+		}
+		fields = append(fields, metaField{name: f.Name, flag: kebabCase(f.Name), index: i})
+	}
+	return fields
+}
+
+func (f metaField) get(info *cbr2cbz.ComicInfo) string {
+	v := reflect.ValueOf(info).Elem().Field(f.index)
+	if v.Kind() == reflect.Int {
+		if v.Int() == 0 {
+			return ""
+		}
+		return strconv.FormatInt(v.Int(), 10)
+	}
+	return v.String()
+}
+
+func (f metaField) set(info *cbr2cbz.ComicInfo, value string) error {
+	v := reflect.ValueOf(info).Elem().Field(f.index)
+	if v.Kind() == reflect.Int {
+		if value == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Wrapf(err, "%s must be a number", f.flag)
+		}
+		v.SetInt(int64(n))
+		return nil
+	}
+	v.SetString(value)
+	return nil
+}
+
+// kebabCase turns a field name like LanguageISO into language-iso.
+func kebabCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (nextLower && unicode.IsUpper(runes[i-1])) {
+				b.WriteRune('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
+
+type metaTool struct {
+	fs     hackpadfs.FS
+	logger *log.Logger
+}
+
+func newMetaTool() *metaTool {
+	logger := log.Default()
+	logger.SetOutput(consoleOutput(os.Stderr))
+	fsys, err := newLocalFS()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return &metaTool{fs: fsys, logger: logger}
+}
+
+// run calls fn with the ComicInfo of every cbz under paths. If fn reports a
+// change the archive is rewritten with the updated ComicInfo.xml.
+func (m *metaTool) run(ctx context.Context, paths []string, fn func(file string, info *cbr2cbz.ComicInfo) (bool, error)) error {
+	files, err := findCBZs(m.fs, paths)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := m.edit(file, fn)
+		if err != nil {
+			m.logger.Printf("Error updating %s - Skipping...%s\n", file, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+func (m *metaTool) edit(file string, fn func(file string, info *cbr2cbz.ComicInfo) (bool, error)) error {
+	r, src, err := openZip(m.fs, pathToFsPath(file))
+	if err != nil {
+		return err
+	}
+	info, err := readZipComicInfo(r)
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	changed, err := fn(file, info)
+	if err != nil || !changed {
+		return err
+	}
+
+	data, err := info.Marshal()
+	if err != nil {
+		return err
+	}
+
+	err = rewriteZip(m.fs, pathToFsPath(file), func(r *zip.Reader, w *zip.Writer) error {
+		for _, f := range r.File {
+			if cbr2cbz.IsComicInfo(f.Name) {
+				continue
+			}
+			if err := copyZipEntry(w, f); err != nil {
+				return err
+			}
+		}
+		out, err := w.Create(cbr2cbz.ComicInfoName)
+		if err != nil {
+			return errors.Wrap(err, "adding ComicInfo.xml")
+		}
+		_, err = out.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Printf("Updated ComicInfo.xml in %s\n", file)
+	return nil
+}
+
+// readZipComicInfo returns the ComicInfo.xml in r, or an empty one if there
+// isn't one yet.
+func readZipComicInfo(r *zip.Reader) (*cbr2cbz.ComicInfo, error) {
+	for _, f := range r.File {
+		if !cbr2cbz.IsComicInfo(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrap(err, "opening ComicInfo.xml")
+		}
+		defer rc.Close()
+		return cbr2cbz.ParseComicInfo(io.LimitReader(rc, 16<<20))
+	}
+	return &cbr2cbz.ComicInfo{}, nil
+}
+
+// findCBZs expands paths into the cbz files they contain.
+func findCBZs(fsys hackpadfs.FS, paths []string) ([]string, error) {
+	return findArchives(fsys, paths, "cbz", ".cbz")
+}
+
+// findArchives returns the files under paths with one of exts, what names
+// the kind of file in the error when there are none.
+func findArchives(fsys hackpadfs.FS, paths []string, what string, exts ...string) ([]string, error) {
+	found := []string{}
+	for _, path := range paths {
+		stat, err := fs.Stat(fsys, pathToFsPath(path))
+		if err != nil {
+			return nil, errors.Wrap(err, "error looking up path")
+		}
+
+		files := []string{path}
+		if stat.IsDir() {
+			files, err = findFiles(fsys, filepath.Join(path, "."))
+			if err != nil {
+				return nil, errors.Wrapf(err, "finding %ss", what)
+			}
+		}
+
+		found = append(found, withExtension(files, exts...)...)
+	}
+	found = uniquePaths(found)
+	if len(found) == 0 {
+		return nil, errors.Errorf("No %s files found!", what)
+	}
+	return found, nil
+}
+
+// uniquePaths drops the files that are already in files under another
+// spelling, like a file reached through two overlapping arguments.
+func uniquePaths(files []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, file := range files {
+		key := pathToFsPath(filepath.Clean(file))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, file)
+	}
+	return unique
+}
+
+// withExtension returns the files with one of exts.
+func withExtension(files []string, exts ...string) []string {
+	found := []string{}
+	for _, file := range files {
+		for _, ext := range exts {
+			if strings.ToLower(filepath.Ext(file)) == ext {
+				found = append(found, file)
+				break
+			}
+		}
+	}
+	return found
+}
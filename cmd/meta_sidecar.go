@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/fs"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var metaSidecarFormat string
+
+var metaExportCmd = &cobra.Command{
+	Use:   "export [paths...]",
+	Short: "Writes each cbz's ComicInfo.xml fields to a .json or .yaml sidecar next to it",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		m := newMetaTool()
+		if metaSidecarFormat != "json" && metaSidecarFormat != "yaml" {
+			m.logger.Fatalf("unknown --format %q, expected json or yaml", metaSidecarFormat)
+		}
+
+		err := m.run(cmd.Context(), args, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+			data, err := marshalSidecar(metaValues(info), metaSidecarFormat)
+			if err != nil {
+				return false, err
+			}
+			sidecar := file + "." + metaSidecarFormat
+			err = hackpadfs.WriteFullFile(m.fs, pathToFsPath(sidecar), data, 0644)
+			if err != nil {
+				return false, errors.Wrap(err, "writing sidecar")
+			}
+			m.logger.Printf("Exported %s\n", sidecar)
+			return false, nil
+		})
+		if err != nil {
+			m.logger.Fatal(err)
+		}
+	},
+}
+
+var metaImportCmd = &cobra.Command{
+	Use:   "import [paths...]",
+	Short: "Applies edited .json or .yaml sidecars back into each cbz's ComicInfo.xml",
+	Long: `Applies edited .json or .yaml sidecars back into each cbz's ComicInfo.xml.
+
+The sidecar is authoritative, fields removed from it are removed from ComicInfo.xml too.
+Archives without a sidecar are left alone.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		m := newMetaTool()
+		err := m.run(cmd.Context(), args, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+			values, err := readSidecar(m.fs, file)
+			if err != nil || values == nil {
+				return false, err
+			}
+			return applyMetaValues(info, values)
+		})
+		if err != nil {
+			m.logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	metaCmd.AddCommand(metaExportCmd, metaImportCmd)
+
+	metaExportCmd.Flags().StringVar(&metaSidecarFormat, "format", "json", "sidecar format, json or yaml")
+}
+
+// metaValues returns the fields of info that are set, keyed by element name.
+func metaValues(info *cbr2cbz.ComicInfo) map[string]string {
+	values := map[string]string{}
+	for _, field := range metaFields() {
+		if v := field.get(info); v != "" {
+			values[field.name] = v
+		}
+	}
+	return values
+}
+
+// applyMetaValues makes info's fields match values, reporting whether
+// anything changed.
+func applyMetaValues(info *cbr2cbz.ComicInfo, values map[string]string) (bool, error) {
+	known := map[string]bool{}
+	changed := false
+	for _, field := range metaFields() {
+		known[field.name] = true
+		if field.get(info) == values[field.name] {
+			continue
+		}
+		if err := field.set(info, values[field.name]); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	for key := range values {
+		if !known[key] {
+			return false, errors.Errorf("unknown field %q in sidecar", key)
+		}
+	}
+	return changed, nil
+}
+
+func marshalSidecar(values map[string]string, format string) ([]byte, error) {
+	if format == "yaml" {
+		return yaml.Marshal(values)
+	}
+	data, err := json.MarshalIndent(values, "", "  ")
+	return append(data, '\n'), err
+}
+
+// readSidecar loads the sidecar for file, or nil if there isn't one.
+func readSidecar(fsys hackpadfs.FS, file string) (map[string]string, error) {
+	for _, ext := range []string{".json", ".yaml", ".yml"} {
+		data, err := hackpadfs.ReadFile(fsys, pathToFsPath(file+ext))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading sidecar")
+		}
+
+		values := map[string]string{}
+		if strings.HasSuffix(ext, ".json") {
+			err = json.Unmarshal(data, &values)
+		} else {
+			err = yaml.Unmarshal(data, &values)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", file+ext)
+		}
+		return values, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"log"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func makeZip(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, contents := range entries {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Test_metaTool_setAndGet(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/saga.cbz": makeZip(t, map[string]string{"001.jpg": "page"}),
+	})
+	require.NoError(t, err)
+
+	m := &metaTool{fs: fsys, logger: log.New(&bytes.Buffer{}, "", 0)}
+	series := metaField{}
+	for _, field := range metaFields() {
+		if field.flag == "series" {
+			series = field
+		}
+	}
+
+	err = m.run(context.Background(), []string{"/comics"}, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+		return true, series.set(info, "Saga")
+	})
+	require.NoError(t, err)
+
+	got := ""
+	err = m.run(context.Background(), []string{"/comics/saga.cbz"}, func(file string, info *cbr2cbz.ComicInfo) (bool, error) {
+		got = series.get(info)
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Saga", got)
+
+	r, f, err := openZip(fsys, "comics/saga.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	require.Len(t, r.File, 2, "page is kept alongside the new ComicInfo.xml")
+}
+
+func Test_kebabCase(t *testing.T) {
+	require.Equal(t, "language-iso", kebabCase("LanguageISO"))
+	require.Equal(t, "scan-information", kebabCase("ScanInformation"))
+	require.Equal(t, "series", kebabCase("Series"))
+}
+
+func Test_applyMetaValues(t *testing.T) {
+	info := &cbr2cbz.ComicInfo{Series: "Saga", Volume: 2, Writer: "Brian K. Vaughan"}
+
+	changed, err := applyMetaValues(info, map[string]string{"Series": "Saga", "Volume": "3"})
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, 3, info.Volume)
+	require.Empty(t, info.Writer, "fields missing from the sidecar are cleared")
+
+	changed, err = applyMetaValues(info, metaValues(info))
+	require.NoError(t, err)
+	require.False(t, changed, "exported values round trip unchanged")
+
+	_, err = applyMetaValues(info, map[string]string{"Colour": "red"})
+	require.Error(t, err)
+}
+
+func Test_seriesMetadata(t *testing.T) {
+	meta := seriesMetadata("comics/Saga (2012)", []*cbr2cbz.ComicInfo{{Series: "Saga Deluxe", Publisher: "Image", Volume: 1}})
+	require.Equal(t, "Saga", meta["name"], "folder name wins over ComicInfo")
+	require.Equal(t, 2012, meta["year"])
+	require.Equal(t, "Image", meta["publisher"])
+	require.Equal(t, 1, meta["volume"])
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mirrorPrune  bool
+	mirrorDryRun bool
+)
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror <src> <dst>",
+	Short: "Keeps a tree of cbz files converted from a library up to date",
+	Long: `Converts the cbr, cb7, cbt and pdf files under src into the same place under dst,
+leaving src alone. A file whose cbz in dst is at least as new as it is skipped,
+so running it again only converts what was added or changed since, like rsync.
+--prune removes the cbz files in dst that nothing in src converts to any more;
+nothing else in dst is ever touched.
+
+Files are converted with the same flags convert takes, except that originals
+are always kept and --output-dir and --rename can't be given. Unlike sync,
+nothing but the comics is brought over.
+
+  cbr2cbz mirror --prune /mnt/nas/comics /srv/cbz`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeDirs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if c.outputDir != "" || c.rename != nil {
+			logger.Fatal("mirror writes to the place under dst of each file, --output-dir and --rename can't be given")
+		}
+		if c.trash || c.backupDir != "" {
+			logger.Fatal("mirror always keeps the originals, --trash and --backup-dir can't be given")
+		}
+		c.outputDir, c.keep = args[1], true
+		paths, err := c.localInputs(args[:1])
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if len(paths) != 1 {
+			logger.Fatalf("mirror takes one source directory, %s matched %d", args[0], len(paths))
+		}
+		c.settings = effectiveOptions(cmd.Flags())
+		c.historyPath = historyFileName
+		c.crashDir = crashDir
+		c.reportPath = reportFileName
+
+		err = c.runMirror(cmd.Context(), paths[0], mirrorPrune, mirrorDryRun)
+		if err != nil {
+			logger.Println(err)
+		}
+		if code := c.exitCode(err); code != exitOK {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mirrorCmd)
+
+	mirrorCmd.Flags().BoolVar(&mirrorPrune, "prune", false, "remove the cbz files in dst that no file in src converts to any more")
+	mirrorCmd.Flags().BoolVar(&mirrorDryRun, "dry-run", false, "only log what would be converted and removed")
+	mirrorCmd.Flags().AddFlagSet(convertCmd.Flags())
+}
+
+// runMirror converts the files under src whose cbz under c.outputDir is
+// missing or older than they are. With prune, cbz files there that no file
+// under src converts to are removed first.
+func (c *converter) runMirror(ctx context.Context, src string, prune bool, dryRun bool) error {
+	// the cbz files in dst say what is up to date
+	c.processed = nil
+	err := c.findFilesAndSize(ctx, []string{src})
+	if err != nil && !errors.Is(err, errNoFiles) {
+		return errors.Wrap(err, "finding files and sizes")
+	}
+
+	if prune {
+		err = c.pruneMirror(src, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	stale := []string{}
+	for _, file := range c.cbrFiles {
+		fresh, err := c.mirrored(file, c.cbzPath(file))
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			stale = append(stale, file)
+		}
+	}
+	c.logger.Printf("%d of %d files in %s are new or changed since they were mirrored to %s\n", len(stale), len(c.cbrFiles), src, c.outputDir)
+	if len(stale) == 0 {
+		return nil
+	}
+	if dryRun {
+		for _, file := range stale {
+			c.logger.Printf("Would convert %s to %s\n", file, c.cbzPath(file))
+		}
+		return nil
+	}
+
+	c.cbrFiles = stale
+	c.cbrSize, err = getFileSize(c.fs, "", stale...)
+	if err != nil {
+		return errors.Wrap(err, "getting cbr file stats")
+	}
+	c.scanned = true
+	return c.runConvert(ctx, []string{src})
+}
+
+// mirrored reports whether cbzFile is there and at least as new as cbrFile.
+func (c *converter) mirrored(cbrFile string, cbzFile string) (bool, error) {
+	out, err := hackpadfs.Stat(c.fs, pathToFsPath(cbzFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "checking for an existing cbz")
+	}
+	in, err := hackpadfs.Stat(c.fs, pathToFsPath(cbrFile))
+	if err != nil {
+		return false, errors.Wrap(err, "getting cbr file stats")
+	}
+	return !out.ModTime().Before(in.ModTime()), nil
+}
+
+// pruneMirror removes the cbz files under c.outputDir that no file under
+// src would convert to. Every file under src counts, whatever --include,
+// --from or the like leave out, so narrowing a run never removes anything.
+func (c *converter) pruneMirror(src string, dryRun bool) error {
+	sources, err := findFiles(c.fs, filepath.Join(src, "."))
+	if err != nil {
+		return errors.Wrap(err, "finding files")
+	}
+	wanted := map[string]bool{}
+	for _, file := range sources {
+		if _, ok := c.roots[file]; !ok {
+			c.roots[file] = src
+		}
+		wanted[c.cbzPath(file)] = true
+	}
+
+	outputs, err := findFiles(c.fs, filepath.Join(c.outputDir, "."))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "finding mirrored files")
+	}
+	for _, file := range outputs {
+		if !strings.EqualFold(filepath.Ext(file), ".cbz") || wanted[file] {
+			continue
+		}
+		if dryRun {
+			c.logger.Printf("Would remove %s, nothing in %s converts to it\n", file, src)
+			continue
+		}
+		err := removeIfExists(c.fs, pathToFsPath(file))
+		if err != nil {
+			return errors.Wrapf(err, "removing %s", file)
+		}
+		c.logger.Printf("Removed %s, nothing in %s converts to it\n", file, src)
+	}
+	return nil
+}
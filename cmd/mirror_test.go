@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runMirror(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr":        realCBRContents,
+		"library/Saga/b.cbr":   realCBRContents,
+		"library/notes.txt":    []byte("not a comic"),
+		"mirror/gone.cbz":      []byte("left over"),
+		"mirror/Saga/keep.jpg": []byte("not ours"),
+	})
+	require.NoError(t, err)
+	newMirror := func() *converter {
+		return &converter{fs: fsys, logger: testLogger{t}, outputDir: "/mirror", keep: true}
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		c := newMirror()
+		require.NoError(t, c.runMirror(context.Background(), "/library", true, true))
+		require.Empty(t, c.converted)
+		_, err := hackpadfs.Stat(fsys, "mirror/gone.cbz")
+		require.NoError(t, err)
+	})
+
+	t.Run("first run", func(t *testing.T) {
+		c := newMirror()
+		require.NoError(t, c.runMirror(context.Background(), "/library", true, false))
+		require.ElementsMatch(t, []string{"/mirror/a.cbz", "/mirror/Saga/b.cbz"}, c.converted)
+		// the originals are left alone
+		_, err := hackpadfs.Stat(fsys, "library/a.cbr")
+		require.NoError(t, err)
+		_, err = hackpadfs.Stat(fsys, "mirror/gone.cbz")
+		require.ErrorIs(t, err, hackpadfs.ErrNotExist)
+		_, err = hackpadfs.Stat(fsys, "mirror/Saga/keep.jpg")
+		require.NoError(t, err)
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		c := newMirror()
+		require.NoError(t, c.runMirror(context.Background(), "/library", true, false))
+		require.Empty(t, c.converted)
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		later := time.Now().Add(time.Hour)
+		require.NoError(t, hackpadfs.Chtimes(fsys, "library/Saga/b.cbr", later, later))
+		c := newMirror()
+		require.NoError(t, c.runMirror(context.Background(), "/library", false, false))
+		require.Equal(t, []string{"/mirror/Saga/b.cbz"}, c.converted)
+	})
+
+	t.Run("narrowed run keeps the rest", func(t *testing.T) {
+		c := newMirror()
+		c.filter, err = newPathFilter(nil, []string{"Saga/**"})
+		require.NoError(t, err)
+		require.NoError(t, c.runMirror(context.Background(), "/library", true, false))
+		_, err = hackpadfs.Stat(fsys, "mirror/Saga/b.cbz")
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	notifyDesktop bool
+	ntfyURL       string
+	ntfyToken     string
+	pushoverUser  string
+	pushoverToken string
+)
+
+// pushoverAPI is where Pushover messages are posted.
+var pushoverAPI = "https://api.pushover.net/1/messages.json"
+
+// noticeFailures is how many failed files a notification lists by name.
+const noticeFailures = 10
+
+// batchNotice is the summary of a finished batch sent to people.
+type batchNotice struct {
+	Title   string
+	Message string
+	// Failed is whether any file failed, sent with a higher priority
+	Failed bool
+}
+
+// notifier sends batch summaries somewhere a person sees them.
+type notifier interface {
+	name() string
+	notify(n batchNotice) error
+}
+
+// newNotifiers returns the notifiers --notify-desktop, --ntfy-url and
+// --pushover-user ask for.
+func newNotifiers() ([]notifier, error) {
+	notifiers := []notifier{}
+	if notifyDesktop {
+		notifiers = append(notifiers, desktopNotifier{})
+	}
+	if ntfyURL != "" {
+		u, err := url.Parse(ntfyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return nil, errors.Errorf("--ntfy-url must be the http or https URL of a topic, like https://ntfy.sh/comics, got %q", ntfyURL)
+		}
+		notifiers = append(notifiers, ntfyNotifier{url: ntfyURL, token: ntfyToken})
+	}
+	if (pushoverUser == "") != (pushoverToken == "") {
+		return nil, errors.New("--pushover-user and --pushover-token are needed together")
+	}
+	if pushoverUser != "" {
+		notifiers = append(notifiers, pushoverNotifier{user: pushoverUser, token: pushoverToken})
+	}
+	return notifiers, nil
+}
+
+// notifyBatch sends the summary of the batch that started at startTime to
+// every notifier. Batches that had nothing to do aren't worth one, and
+// failing to send one is only logged.
+func (c *converter) notifyBatch(startTime time.Time) {
+	if len(c.notifiers) == 0 || len(c.converted)+len(c.failed)+c.notStarted == 0 {
+		return
+	}
+	n := c.batchNotice(startTime)
+	for _, to := range c.notifiers {
+		if err := to.notify(n); err != nil {
+			c.logger.Printf("Unable to send %s notification: %s\n", to.name(), err.Error())
+		}
+	}
+}
+
+// batchNotice is the summary of the batch that started at startTime, the
+// line the webhook gets followed by the first of the failed files.
+func (c *converter) batchNotice(startTime time.Time) batchNotice {
+	batch := c.batchWebhook(startTime)
+	lines := []string{batch.Text}
+	for i, f := range batch.Batch.FailedFiles {
+		if i == noticeFailures {
+			lines = append(lines, fmt.Sprintf("and %d more", len(batch.Batch.FailedFiles)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: [%s] %s", filepath.Base(f.File), f.Code, f.Error))
+	}
+
+	n := batchNotice{Title: "cbr2cbz batch finished", Message: strings.Join(lines, "\n"), Failed: len(c.failed) > 0}
+	if n.Failed {
+		n.Title = "cbr2cbz batch finished with failures"
+	}
+	return n
+}
+
+// desktopNotifier shows notices with the desktop's own notifications.
+type desktopNotifier struct{}
+
+func (desktopNotifier) name() string { return "desktop" }
+
+func (desktopNotifier) notify(n batchNotice) error {
+	return desktopNotify(n.Title, n.Message, n.Failed)
+}
+
+// ntfyNotifier publishes notices to an ntfy topic, on ntfy.sh or a server
+// of one's own.
+type ntfyNotifier struct {
+	url   string
+	token string
+}
+
+func (ntfyNotifier) name() string { return "ntfy" }
+
+func (t ntfyNotifier) notify(n batchNotice) error {
+	req, err := http.NewRequest(http.MethodPost, t.url, strings.NewReader(n.Message))
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+	req.Header.Set("Title", n.Title)
+	req.Header.Set("Tags", "white_check_mark")
+	if n.Failed {
+		req.Header.Set("Tags", "warning")
+		req.Header.Set("Priority", "high")
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return sendNotice(req)
+}
+
+// pushoverNotifier sends notices through Pushover.
+type pushoverNotifier struct {
+	user  string
+	token string
+}
+
+func (pushoverNotifier) name() string { return "Pushover" }
+
+func (p pushoverNotifier) notify(n batchNotice) error {
+	priority := "0"
+	if n.Failed {
+		priority = "1"
+	}
+	form := url.Values{"token": {p.token}, "user": {p.user}, "title": {n.Title}, "message": {n.Message}, "priority": {priority}}
+	req, err := http.NewRequest(http.MethodPost, pushoverAPI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return sendNotice(req)
+}
+
+// sendNotice sends req, giving up after 10 seconds like postJSON.
+func sendNotice(req *http.Request) error {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+	req.Header.Set("User-Agent", "cbr2cbz/"+buildVersion)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "posting to %s", req.URL.Host)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
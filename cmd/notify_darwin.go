@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// desktopNotify shows a notification in Notification Center, urgent ones
+// with a sound.
+func desktopNotify(title string, message string, urgent bool) error {
+	script := "display notification " + appleScriptString(message) + " with title " + appleScriptString(title)
+	if urgent {
+		script += ` sound name "Basso"`
+	}
+	return exec.Command("osascript", "-e", script).Run()
+}
+
+// appleScriptString quotes s as an AppleScript string.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	notices []batchNotice
+}
+
+func (r *recordingNotifier) name() string { return "recording" }
+
+func (r *recordingNotifier) notify(n batchNotice) error {
+	r.notices = append(r.notices, n)
+	return nil
+}
+
+func Test_notifyBatch(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr":      realCBRContents,
+		"library/broken.cbr": []byte("not a rar"),
+		"empty/notes.txt":    []byte("no comics here"),
+	})
+	require.NoError(t, err)
+
+	to := &recordingNotifier{}
+	c := &converter{fs: fsys, logger: testLogger{t}, notifiers: []notifier{to}}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, to.notices, 1)
+	require.True(t, to.notices[0].Failed)
+	require.Equal(t, "cbr2cbz batch finished with failures", to.notices[0].Title)
+	require.Contains(t, to.notices[0].Message, "1 converted")
+	require.Contains(t, to.notices[0].Message, "\nbroken.cbr: [E101] ")
+
+	to.notices = nil
+	c = &converter{fs: fsys, logger: testLogger{t}, notifiers: []notifier{to}}
+	require.Error(t, c.runConvert(context.Background(), []string{"/empty"}))
+	require.Empty(t, to.notices, "nothing to do, nothing to tell")
+}
+
+func Test_ntfyNotifier(t *testing.T) {
+	var got *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+	}))
+	defer server.Close()
+
+	n := ntfyNotifier{url: server.URL + "/comics", token: "tk_secret"}
+	require.NoError(t, n.notify(batchNotice{Title: "done", Message: "2 converted", Failed: true}))
+	require.Equal(t, "/comics", got.URL.Path)
+	require.Equal(t, "done", got.Header.Get("Title"))
+	require.Equal(t, "high", got.Header.Get("Priority"))
+	require.Equal(t, "Bearer tk_secret", got.Header.Get("Authorization"))
+	require.Equal(t, "2 converted", body)
+}
+
+func Test_pushoverNotifier(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+	}))
+	defer server.Close()
+	defer func(api string) { pushoverAPI = api }(pushoverAPI)
+	pushoverAPI = server.URL
+
+	n := pushoverNotifier{user: "u123", token: "a456"}
+	require.NoError(t, n.notify(batchNotice{Title: "done", Message: "2 converted"}))
+	require.Equal(t, "u123", form.Get("user"))
+	require.Equal(t, "a456", form.Get("token"))
+	require.Equal(t, "done", form.Get("title"))
+	require.Equal(t, "2 converted", form.Get("message"))
+	require.Equal(t, "0", form.Get("priority"))
+
+	pushoverAPI = server.URL + "/missing"
+	server.Config.Handler = http.NotFoundHandler()
+	require.Error(t, n.notify(batchNotice{Title: "done"}))
+}
+
+func Test_newNotifiers(t *testing.T) {
+	defer func() { ntfyURL, pushoverUser, pushoverToken = "", "", "" }()
+
+	ntfyURL = "https://ntfy.sh/comics"
+	notifiers, err := newNotifiers()
+	require.NoError(t, err)
+	require.Len(t, notifiers, 1)
+
+	ntfyURL = "https://ntfy.sh/"
+	_, err = newNotifiers()
+	require.ErrorContains(t, err, "--ntfy-url")
+
+	ntfyURL, pushoverUser = "", "u123"
+	_, err = newNotifiers()
+	require.ErrorContains(t, err, "--pushover-token")
+}
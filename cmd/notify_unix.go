@@ -0,0 +1,15 @@
+//go:build !windows && !darwin
+
+package cmd
+
+import "os/exec"
+
+// desktopNotify shows a desktop notification, urgent ones staying until
+// dismissed.
+func desktopNotify(title string, message string, urgent bool) error {
+	urgency := "normal"
+	if urgent {
+		urgency = "critical"
+	}
+	return exec.Command("notify-send", "--app-name=cbr2cbz", "--urgency="+urgency, title, message).Run()
+}
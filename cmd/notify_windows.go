@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+)
+
+// notifyScript shows a balloon from the tray, reading what to show from
+// the environment so nothing needs quoting. It stays for the balloon to be
+// seen, so it isn't waited for.
+const notifyScript = `Add-Type -AssemblyName System.Windows.Forms
+$n = New-Object System.Windows.Forms.NotifyIcon
+$n.Icon = [System.Drawing.SystemIcons]::Information
+$n.Visible = $true
+$n.ShowBalloonTip(10000, $env:CBR2CBZ_TITLE, $env:CBR2CBZ_MESSAGE, $env:CBR2CBZ_ICON)
+Start-Sleep -Seconds 10
+$n.Dispose()`
+
+// desktopNotify shows a notification from the tray, urgent ones with the
+// warning icon.
+func desktopNotify(title string, message string, urgent bool) error {
+	icon := "Info"
+	if urgent {
+		icon = "Warning"
+	}
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-WindowStyle", "Hidden", "-Command", notifyScript)
+	cmd.Env = append(os.Environ(), "CBR2CBZ_TITLE="+title, "CBR2CBZ_MESSAGE="+message, "CBR2CBZ_ICON="+icon)
+	return cmd.Start()
+}
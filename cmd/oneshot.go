@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const envPrefix = "CBR2CBZ_"
+
+// oneshotCmd represents the oneshot command
+var oneshotCmd = &cobra.Command{
+	Use:   "oneshot",
+	Short: "Runs a single conversion configured only through environment variables",
+	Long: `Runs a single conversion configured only through environment variables, for
+Kubernetes Jobs and docker-compose one-shots.
+
+CBR2CBZ_PATHS holds the paths to convert, separated like $PATH. Every convert flag
+can be set as CBR2CBZ_<FLAG>, e.g. CBR2CBZ_JOBS=4 or CBR2CBZ_SERIES_JSON=true.
+
+Logs go to stderr and the log file, a JSON summary of the run is printed on stdout.
+The exit code is non-zero if anything failed, --qa-sample checks included: 1
+on errors stopping the batch, 2 when some files failed, 3 when there was
+nothing to convert and 4 when --stop-after or --stop-at left files for later.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+
+		err := applyEnvFlags(rootCmd.PersistentFlags(), convertCmd.Flags())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		// CBR2CBZ_PRESET is only known now
+		err = applyPreset(configFlagSets()...)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		// never the paths of a config file, which may just happen to be
+		// mounted into the container
+		paths := filepath.SplitList(os.Getenv(envPrefix + "PATHS"))
+		if len(paths) == 0 {
+			logger.Fatal(envPrefix + "PATHS is not set")
+		}
+
+		logFile, err := openLogFile(time.Now())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer logFile.Close()
+		logger.SetOutput(io.MultiWriter(consoleOutput(os.Stderr), logFileOutput(logFile)))
+
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		paths, err = c.useRemote(paths)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		paths, err = c.localInputs(paths)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		c.settings = effectiveOptions(configFlagSets()...)
+		c.snapshotCommand, c.rollbackCommand = snapshotCommand, rollbackCommand
+
+		signals, stopSignals := interruptSignals()
+		defer stopSignals()
+		ctx, stop := c.interruptible(cmd.Context(), signals)
+		defer stop()
+		runErr := c.runConvert(ctx, paths)
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(c.summary(paths, runErr)); err != nil {
+			logger.Fatal(err)
+		}
+
+		if code := c.exitCode(runErr); code != exitOK {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(oneshotCmd)
+}
+
+// envName is the environment variable a flag is read from, --series-json
+// becomes CBR2CBZ_SERIES_JSON.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnvFlags sets every flag in sets that has a matching environment
+// variable.
+func applyEnvFlags(sets ...*pflag.FlagSet) error {
+	var err error
+	for _, set := range sets {
+		set.VisitAll(func(f *pflag.Flag) {
+			value, ok := os.LookupEnv(envName(f.Name))
+			if !ok || err != nil {
+				return
+			}
+			if setErr := set.Set(f.Name, value); setErr != nil {
+				err = errors.Wrapf(setErr, "invalid %s", envName(f.Name))
+				return
+			}
+			optionSources[f.Name] = "env"
+		})
+	}
+	return err
+}
+
+// batchSummary is the machine readable outcome of a runConvert.
+type batchSummary struct {
+	Version         string            `json:"version"`
+	Paths           []string          `json:"paths"`
+	FilesConsidered int               `json:"files_considered"`
+	CBRFiles        int               `json:"cbr_files"`
+	Converted       []string          `json:"converted"`
+	Failed          map[string]string `json:"failed"`
+	// FailedCodes is the error code for each file in Failed
+	FailedCodes     map[string]string `json:"failed_codes"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	// NotStarted is how many files were left when the batch stopped at
+	// its deadline
+	NotStarted int               `json:"not_started,omitempty"`
+	Options    []effectiveOption `json:"options"`
+	// Libraries is how the files under each of Paths went
+	Libraries map[string]*libraryTotals `json:"libraries"`
+	// QA is the outcome of --qa-sample, if it was set
+	QA *qaReport `json:"qa,omitempty"`
+	// Snapshot is the snapshot taken before the batch, if there was one
+	Snapshot *snapshot `json:"snapshot,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (c *converter) summary(paths []string, runErr error) batchSummary {
+	s := batchSummary{
+		Version:         rootCmd.Version,
+		Paths:           paths,
+		FilesConsidered: len(c.allFiles),
+		CBRFiles:        len(c.cbrFiles),
+		Converted:       c.converted,
+		Failed:          map[string]string{},
+		FailedCodes:     map[string]string{},
+		DurationSeconds: c.duration.Seconds(),
+		NotStarted:      c.notStarted,
+		Options:         c.settings,
+		Libraries:       libraryTotalsOf(c.results),
+		QA:              c.qa,
+		Snapshot:        c.snapshot,
+	}
+	if s.Converted == nil {
+		s.Converted = []string{}
+	}
+	for file, err := range c.failed {
+		s.Failed[file] = err.Error()
+		s.FailedCodes[file] = errorCode(err)
+	}
+	if runErr != nil {
+		s.Error = runErr.Error()
+	}
+	return s
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_applyEnvFlags(t *testing.T) {
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	jobs := set.Int("jobs", 1, "")
+	seriesJSON := set.Bool("series-json", false, "")
+
+	t.Setenv("CBR2CBZ_JOBS", "4")
+	t.Setenv("CBR2CBZ_SERIES_JSON", "true")
+	require.NoError(t, applyEnvFlags(set))
+	require.Equal(t, 4, *jobs)
+	require.True(t, *seriesJSON)
+
+	t.Setenv("CBR2CBZ_JOBS", "lots")
+	require.Error(t, applyEnvFlags(set))
+}
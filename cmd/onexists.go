@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"io/fs"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// OnExistsPolicies are the values --on-exists accepts, what to do when the
+// cbz a file converts to is already there: overwrite it, skip the file,
+// rename the new one "Saga 001 (2).cbz" or fail the file.
+var OnExistsPolicies = map[string]bool{"overwrite": true, "skip": true, "rename": true, "error": true}
+
+// errOutputExists is what files fail with under --on-exists error.
+var errOutputExists = errors.New("the cbz already exists, see --on-exists")
+
+// errOutputSkipped is what files are skipped with under --on-exists skip.
+var errOutputSkipped = errors.New("the cbz already exists, keeping it")
+
+// existsPolicy is --on-exists, which defaults to overwrite, or rename with
+// --rename as that always numbered taken names.
+func (c *converter) existsPolicy() string {
+	switch {
+	case c.onExists != "":
+		return c.onExists
+	case c.rename != nil:
+		return "rename"
+	}
+	return "overwrite"
+}
+
+// isSkip reports whether err means the file was left alone rather than
+// failed: claimed by another instance or its cbz kept.
+func isSkip(err error) bool {
+	return errors.Is(err, errClaimed) || errors.Is(err, errOutputSkipped)
+}
+
+// checkOutput applies --on-exists to cbzFile, the cbz cbrFile is about to
+// be converted to. Converting in place is never a clash, and rename already
+// picked a free name.
+func (c *converter) checkOutput(cbrFile string, cbzFile string) error {
+	if cbzFile == cbrFile {
+		return nil
+	}
+	info, err := hackpadfs.Stat(c.fs, pathToFsPath(cbzFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "checking for an existing cbz")
+	}
+	if info.IsDir() {
+		return errors.Errorf("%s is a directory", cbzFile)
+	}
+
+	switch c.existsPolicy() {
+	case "skip":
+		return errors.Wrap(errOutputSkipped, cbzFile)
+	case "error":
+		return errors.Wrap(errOutputExists, cbzFile)
+	case "overwrite":
+		c.warn(cbr2cbz.CodeOutputReplaced, cbrFile, "%s already exists, overwriting it", cbzFile)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_convertOnExists(t *testing.T) {
+	for _, source := range []struct {
+		name     string
+		contents []byte
+	}{
+		{"library/test.cbt", nil},
+		{"library/test.cbr", notrealCBRContents},
+	} {
+		contents := source.contents
+		if contents == nil {
+			contents = makeTar(t, map[string]string{"001.jpg": "page"})
+		}
+		run := func(t *testing.T, policy string) (*converter, hackpadfs.FS) {
+			fsys, err := setupFS(t, filenameBytes{
+				source.name:        contents,
+				"library/test.cbz": []byte("already there"),
+			})
+			require.NoError(t, err)
+			c := &converter{fs: fsys, logger: testLogger{t}, onExists: policy}
+			require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+			return c, fsys
+		}
+
+		t.Run(source.name+" skip", func(t *testing.T) {
+			c, fsys := run(t, "skip")
+			require.Empty(t, c.failed)
+			require.Empty(t, c.converted)
+			data, err := hackpadfs.ReadFile(fsys, "library/test.cbz")
+			require.NoError(t, err)
+			require.Equal(t, "already there", string(data))
+			_, err = hackpadfs.Stat(fsys, source.name)
+			require.NoError(t, err, "the original is kept")
+		})
+
+		t.Run(source.name+" error", func(t *testing.T) {
+			c, fsys := run(t, "error")
+			require.Len(t, c.failed, 1)
+			require.Equal(t, cbr2cbz.CodeOutputExists, errorCode(c.failed["/"+source.name]))
+			_, err := hackpadfs.Stat(fsys, source.name)
+			require.NoError(t, err, "the original is kept")
+		})
+
+		t.Run(source.name+" rename", func(t *testing.T) {
+			c, fsys := run(t, "rename")
+			require.Equal(t, []string{"/library/test (2).cbz"}, c.converted)
+			data, err := hackpadfs.ReadFile(fsys, "library/test.cbz")
+			require.NoError(t, err)
+			require.Equal(t, "already there", string(data))
+		})
+
+		t.Run(source.name+" overwrite", func(t *testing.T) {
+			c, fsys := run(t, "overwrite")
+			require.Equal(t, []string{"/library/test.cbz"}, c.converted)
+			data, err := hackpadfs.ReadFile(fsys, "library/test.cbz")
+			require.NoError(t, err)
+			require.NotEqual(t, "already there", string(data))
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// optimizeCmd represents the optimize command
+var optimizeCmd = &cobra.Command{
+	Use:   "optimize [paths...]",
+	Short: "Repacks existing cbz files the way convert packs cbr files, in place",
+	Long: `Repacks existing cbz files through the same steps convert puts cbr files through,
+taking the same flags: --strip-junk, --drop-duplicate-pages, --page-order, --pad-numbers,
+--recompress, --generate-comicinfo, --bookmarks, --compression and the rest. For
+libraries that were zips all along.
+
+  cbr2cbz optimize --strip-junk --generate-comicinfo --compression store ~/Comics
+
+Each cbz is written to a temporary file and verified before it replaces the
+original, keeping its modification time and permissions unless
+--preserve-attributes=false. Flags about originals and where outputs go, like
+--keep, --trash or --output-dir, don't apply.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		err = c.runOptimize(cmd.Context(), args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(optimizeCmd)
+
+	optimizeCmd.Flags().AddFlagSet(convertCmd.Flags())
+}
+
+func (c *converter) runOptimize(ctx context.Context, paths []string) error {
+	files, err := findCBZs(c.fs, paths)
+	if err != nil {
+		return err
+	}
+	opts := c.packer().Options()
+	c.logger.Printf("Optimizing %d files with options %s %s\n", len(files), opts.Fingerprint(), opts)
+
+	failed := 0
+	var before, after int64
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		in, out, err := c.optimize(ctx, file)
+		if err != nil {
+			c.logger.Printf("Error optimizing %s - Skipping...%s\n", file, err.Error())
+			failed++
+			continue
+		}
+		c.logger.Printf("Optimized %s: %s to %s\n", file, formatBytes(uint64(in)), formatBytes(uint64(out)))
+		before += in
+		after += out
+	}
+
+	c.logger.Printf("Optimized %d files, %s to %s\n", len(files)-failed, formatBytes(uint64(before)), formatBytes(uint64(after)))
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+// optimize repacks the cbz file in place, returning its size before and
+// after.
+func (c *converter) optimize(ctx context.Context, file string) (int64, int64, error) {
+	name := pathToFsPath(file)
+	src, err := c.fs.Open(name)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "opening cbz")
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "stating cbz")
+	}
+	reader, ok := src.(io.ReaderAt)
+	if !ok {
+		return 0, 0, errors.New("filesystem doesn't support random access reads")
+	}
+
+	engine := c.packer()
+	progress := &cbr2cbz.Progress{}
+	files, err := engine.ZipEntries(ctx, file, reader, info.Size(), progress)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tmp := tempName(name)
+	out, err := hackpadfs.Create(c.fs, tmp)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "creating cbz")
+	}
+	w, ok := out.(io.Writer)
+	if !ok {
+		out.Close()
+		hackpadfs.Remove(c.fs, tmp)
+		return 0, 0, errors.New("destination isn't a writable filesystem")
+	}
+	err = engine.PackWithComment(ctx, file, files, engine.Comment(file, reader, info.Size()), w, progress)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyZip(c.fs, tmp)
+	}
+	var packed hackpadfs.FileInfo
+	if err == nil {
+		packed, err = hackpadfs.Stat(c.fs, tmp)
+	}
+	if err != nil {
+		hackpadfs.Remove(c.fs, tmp)
+		return 0, 0, err
+	}
+
+	c.copyAttributes(file, "/"+tmp)
+	src.Close()
+	err = hackpadfs.Rename(c.fs, tmp, name)
+	if err != nil {
+		hackpadfs.Remove(c.fs, tmp)
+		return 0, 0, errors.Wrap(err, "replacing cbz")
+	}
+	return info.Size(), packed.Size(), nil
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runOptimize(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga 001.cbz": makeZip(t, map[string]string{
+			"2.jpg":     "page two",
+			"10.jpg":    "page ten",
+			"Thumbs.db": "junk",
+			"notes.txt": "notes",
+		}),
+		"library/broken.cbz": []byte("not a zip"),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{StripJunk: true, PageOrder: "natural", PadNumbers: true, GenerateInfo: true}, cbr2cbz.Limits{}))
+	err = c.runOptimize(context.Background(), []string{"/library"})
+	require.EqualError(t, err, "1 files failed")
+
+	zr, f, err := openZip(fsys, "library/Saga 001.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	names := []string{}
+	for _, entry := range zr.File {
+		names = append(names, entry.Name)
+	}
+	require.Equal(t, []string{"02.jpg", "10.jpg", "ComicInfo.xml"}, names)
+
+	// nothing left behind
+	files, err := findFiles(fsys, "library")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
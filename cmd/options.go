@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"sort"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/spf13/pflag"
+)
+
+// setOptions sets c up to pack archives the way opts says, within limits.
+// The engine's warnings go to the log and out as warning events.
+func (c *converter) setOptions(opts cbr2cbz.Options, limits cbr2cbz.Limits) error {
+	engine, err := cbr2cbz.New(opts)
+	if err != nil {
+		return err
+	}
+	engine.Limits = limits
+	engine.OnWarning = func(w cbr2cbz.Warning) {
+		// logged here rather than by the engine, to go with the file's lines
+		c.logFor(w.File).Printf("[%s] %s\n", w.Code, w.Message)
+		if w.RenamedTo != "" {
+			c.noteRewrite(w.File, rewrittenPath{From: w.Entry, To: w.RenamedTo})
+		}
+		if c.events != nil {
+			c.events.emit(logEvent{Action: "warning", Code: w.Code, File: w.File, Library: c.roots[w.File], Error: w.Message})
+		}
+	}
+	c.engine = engine
+	return nil
+}
+
+// packer is the engine packing archives, one with the default options for
+// converters that never had any set.
+func (c *converter) packer() *cbr2cbz.Converter {
+	if c.engine == nil {
+		// the defaults always validate
+		c.setOptions(cbr2cbz.Options{}, cbr2cbz.Limits{})
+	}
+	return c.engine
+}
+
+// optionSources records where flags that weren't given on the command line
+// got their value from, "config", "profile" or "env".
+var optionSources = map[string]string{}
+
+// effectiveOption is one resolved setting and where it came from.
+type effectiveOption struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// effectiveOptions resolves every flag in sets, so a run's log and summary
+// say exactly what it was configured with.
+func effectiveOptions(sets ...*pflag.FlagSet) []effectiveOption {
+	seen := map[string]bool{}
+	options := []effectiveOption{}
+	for _, set := range sets {
+		set.VisitAll(func(f *pflag.Flag) {
+			if f.Name == "help" || seen[f.Name] {
+				return
+			}
+			seen[f.Name] = true
+
+			source := "default"
+			if f.Changed {
+				source = "flag"
+			}
+			if s, ok := optionSources[f.Name]; ok {
+				source = s
+			}
+			options = append(options, effectiveOption{Name: f.Name, Value: flagValue(f), Source: source})
+		})
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Name < options[j].Name })
+	return options
+}
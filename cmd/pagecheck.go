@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// errPagesMismatch is what files fail with when the cbz read back doesn't
+// hold the pages the original does.
+var errPagesMismatch = errors.New("the cbz doesn't hold the pages of the original")
+
+// checkPages compares the pages in cbzFile with the ones in src, the
+// source of cbrFile: as many of them, and of the same sizes unless they
+// were re-encoded. Only the listings are read, verifyZip reads the
+// contents. What the options change on purpose, duplicates dropped, bad
+// entries skipped or strips sliced, isn't held against the cbz.
+func (c *converter) checkPages(ctx context.Context, cbrFile string, cbzFile string, src io.ReaderAt, size int64) error {
+	opts := c.packer().Options()
+	if opts.Images != nil && opts.Images.Strips != "" {
+		return nil
+	}
+	entries, err := c.packer().Entries(ctx, cbrFile, src, size, &cbr2cbz.Progress{})
+	if err != nil {
+		// it was read some other way, with --external-unrar say
+		debugf(c.logFor(cbrFile), "Not comparing the pages of %s with %s, unable to list them: %s\n", cbzFile, cbrFile, err.Error())
+		return nil
+	}
+
+	filter := c.pageFilter()
+	sizes := map[int64]int{}
+	want := 0
+	for _, f := range entries {
+		if !f.IsDir() && filter.Classify(f.NameInArchive) == cbr2cbz.EntryPage {
+			sizes[f.Size()]++
+			want++
+		}
+	}
+
+	r, file, err := openZip(c.fs, pathToFsPath(cbzFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pages := []*zip.File{}
+	for _, entry := range r.File {
+		if !entry.FileInfo().IsDir() && filter.Classify(entry.Name) == cbr2cbz.EntryPage {
+			pages = append(pages, entry)
+		}
+	}
+	fewer := opts.DropDuplicatePages || (opts.SkipBadEntries && !opts.Placeholders)
+	if len(pages) > want || (len(pages) < want && !fewer) {
+		return errors.Wrapf(errPagesMismatch, "%d pages instead of %d", len(pages), want)
+	}
+
+	// pdf pages are sized once extracted and placeholders are their own
+	if opts.Images != nil || opts.SkipBadEntries || cbr2cbz.IsPDF(src) {
+		return nil
+	}
+	for _, page := range pages {
+		size := int64(page.UncompressedSize64)
+		if sizes[size] == 0 {
+			return errors.Wrapf(errPagesMismatch, "%s is %s, no page of the original is", page.Name, formatBytes(uint64(size)))
+		}
+		sizes[size]--
+	}
+	return nil
+}
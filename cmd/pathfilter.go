@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"path"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
+)
+
+var (
+	includePatterns []string
+	excludePatterns []string
+)
+
+// pathFilter scopes a run to part of a tree with glob patterns, ** matching
+// any number of folders. Patterns without a / are matched against the file
+// name alone, the rest against the path under the directory being searched
+// and the full path.
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+// newPathFilter checks the patterns, returning nil when there are none.
+func newPathFilter(include, exclude []string) (*pathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	for _, set := range []struct {
+		flag     string
+		patterns []string
+	}{{"--include", include}, {"--exclude", exclude}} {
+		for _, pattern := range set.patterns {
+			if !doublestar.ValidatePattern(pattern) {
+				return nil, errors.Errorf("invalid %s pattern %q", set.flag, pattern)
+			}
+		}
+	}
+	return &pathFilter{include: include, exclude: exclude}, nil
+}
+
+// allows reports whether the file at full, rel under the directory it was
+// found in, is in scope: matching an --include, if any were given, and no
+// --exclude.
+func (f *pathFilter) allows(rel, full string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, rel, full) {
+		return false
+	}
+	return !matchAny(f.exclude, rel, full)
+}
+
+func matchAny(patterns []string, rel, full string) bool {
+	rel = strings.TrimPrefix(rel, "/")
+	full = strings.TrimPrefix(full, "/")
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := doublestar.Match(pattern, path.Base(full)); ok {
+				return true
+			}
+			continue
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+		if ok, _ := doublestar.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := doublestar.Match(pattern, full); ok {
+			return true
+		}
+	}
+	return false
+}
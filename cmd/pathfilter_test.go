@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pathFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		rel     string
+		want    bool
+	}{
+		{name: "no patterns", rel: "Manga/Berserk/001.cbr", want: true},
+		{name: "excluded folder", exclude: []string{"**/Manga/**"}, rel: "Manga/Berserk/001.cbr", want: false},
+		{name: "other folder", exclude: []string{"**/Manga/**"}, rel: "Marvel/X-Men/001.cbr", want: true},
+		{name: "file name", exclude: []string{"*preview*"}, rel: "Saga/Saga 001 preview.cbr", want: false},
+		{name: "included", include: []string{"Marvel/**"}, rel: "Marvel/X-Men/001.cbr", want: true},
+		{name: "not included", include: []string{"Marvel/**"}, rel: "DC/Batman/001.cbr", want: false},
+		{name: "exclude wins", include: []string{"Marvel/**"}, exclude: []string{"**/X-Men/**"}, rel: "Marvel/X-Men/001.cbr", want: false},
+		{name: "full path", include: []string{"/library/Marvel/**"}, rel: "Marvel/X-Men/001.cbr", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newPathFilter(tt.include, tt.exclude)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, f.allows(tt.rel, "/library/"+tt.rel))
+		})
+	}
+
+	_, err := newPathFilter(nil, []string{"Manga/[a"})
+	require.ErrorContains(t, err, `invalid --exclude pattern "Manga/[a"`)
+}
+
+func Test_findFilesAndSize_filter(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Manga/Berserk/001.cbr":  realCBRContents,
+		"library/Marvel/X-Men/001.cbr":   realCBRContents,
+		"library/Marvel/X-Men/notes.txt": []byte("notes"),
+		"single/Manga/002.cbr":           realCBRContents,
+	})
+	require.NoError(t, err)
+
+	filter, err := newPathFilter(nil, []string{"**/Manga/**", "*.txt"})
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}, filter: filter}
+	require.NoError(t, c.findFilesAndSize(context.Background(), []string{"/library", "/single/Manga/002.cbr"}))
+	// files given on their own are always converted
+	require.ElementsMatch(t, []string{"/library/Marvel/X-Men/001.cbr", "/single/Manga/002.cbr"}, c.cbrFiles)
+	require.ElementsMatch(t, c.cbrFiles, c.allFiles)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/stretchr/testify/require"
+)
+
+func makePDF(t *testing.T, pages ...[]byte) []byte {
+	t.Helper()
+
+	imgs := []io.Reader{}
+	for _, page := range pages {
+		imgs = append(imgs, bytes.NewReader(page))
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, api.ImportImages(nil, buf, imgs, pdfcpu.DefaultImportConfig(), nil))
+	return buf.Bytes()
+}
+
+func Test_convertPDF(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga 001 (2012).pdf": makePDF(t, makePNG(t, 20, 30), makePNG(t, 30, 20)),
+		"library/test.cbr":            realCBRContents,
+	})
+	require.NoError(t, err)
+
+	sources, err := sourceExtensions([]string{"pdf"})
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}, sources: sources}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{GenerateInfo: true}, cbr2cbz.Limits{}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Equal(t, []string{"/library/Saga 001 (2012).pdf"}, c.cbrFiles)
+
+	zr, f, err := openZip(fsys, "library/Saga 001 (2012).cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	names := []string{}
+	for _, entry := range zr.File {
+		names = append(names, entry.Name)
+	}
+	require.Equal(t, []string{"001.png", "002.png", "ComicInfo.xml"}, names)
+
+	_, err = hackpadfs.Stat(fsys, "library/test.cbr")
+	require.NoError(t, err, "cbr isn't converted without --from cbr")
+}
+
+func Test_sourceExtensions(t *testing.T) {
+	exts, err := sourceExtensions([]string{"cbr", " PDF"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{".cbr": true, ".pdf": true}, exts)
+
+	_, err = sourceExtensions([]string{"epub"})
+	require.Error(t, err)
+}
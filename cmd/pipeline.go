@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pipelineSteps    []string
+	pipelineImages   cbr2cbz.ImageOptions
+	pipelineProfile  string
+	pipelinePassword string
+)
+
+// pipelineStepNames are the steps run can chain, in the order they usually go.
+var pipelineStepNames = []string{"convert", "reencode", "lookup", "verify"}
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [paths...]",
+	Short: "Runs several steps over the same files, looking for them only once",
+	Long: `Runs several steps, in the order given to --steps, over the files under the given
+paths. The tree is walked once and each step works on what the one before left,
+so a big library on a slow share isn't walked again for every step.
+
+The steps are convert, reencode, lookup and verify:
+
+  convert   converts the cbr, cb7 and cbt files, taking all the convert flags
+  reencode  runs the pages of the cbz files found and converted through the image
+            pipeline, as reencode does, with --profile, --format, --quality,
+            --strips, --grayscale, --contrast, --gamma and --password; --max-width,
+            --max-height and --auto-rotate apply to both
+  lookup    fills in the ComicInfo.xml of the cbz files from ComicVine or Metron, as
+            meta lookup does, taking its flags
+  verify    checks every archive left afterwards, as verify does
+
+  cbr2cbz run --steps convert,reencode,verify --profile kobo ~/Comics
+
+A step that fails doesn't stop the ones after it. Exits non-zero if any of them
+failed.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeSources,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+
+		steps, err := parsePipelineSteps(pipelineSteps)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		logFile, err := openLogFile(time.Now())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer logFile.Close()
+		logger.SetOutput(io.MultiWriter(consoleOutput(os.Stdout), logFileOutput(logFile)))
+
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		args, err = c.useRemote(args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		args, err = c.localInputs(args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		c.settings = effectiveOptions(cmd.Flags())
+		c.snapshotCommand, c.rollbackCommand = snapshotCommand, rollbackCommand
+
+		if err := applyImageProfile(cmd, pipelineProfile, &pipelineImages); err != nil {
+			logger.Fatal(err)
+		}
+		if cmd.Flags().Changed("max-width") {
+			pipelineImages.MaxWidth = convertImages.MaxWidth
+		}
+		if cmd.Flags().Changed("max-height") {
+			pipelineImages.MaxHeight = convertImages.MaxHeight
+		}
+		if cmd.Flags().Changed("auto-rotate") {
+			pipelineImages.AutoRotate = convertImages.AutoRotate
+		}
+		passwords := zipPasswords{fallback: pipelinePassword}
+		if loadedConfig != nil {
+			passwords.rules = loadedConfig.passwords
+		}
+		if hasStep(steps, "reencode") {
+			if !pipelineImages.Enabled() && !passwords.any() {
+				logger.Fatal("nothing for reencode to do, give at least one of --profile, --format, --max-width, --max-height, --strips, --auto-rotate, --grayscale, --contrast, --gamma or --password")
+			}
+			if err := pipelineImages.Validate(); err != nil {
+				logger.Fatal(err)
+			}
+		}
+
+		var lookup *metadataLookup
+		if hasStep(steps, "lookup") {
+			lookup, err = newMetadataLookup(logger)
+			if err != nil {
+				logger.Fatal(err)
+			}
+		}
+
+		p := &pipeline{
+			steps:    steps,
+			logger:   logger,
+			c:        c,
+			reencode: &reencoder{fs: c.fs, logger: logger, images: pipelineImages, passwords: passwords},
+			verify:   &archiveVerifier{fs: c.fs, logger: logger},
+			lookup:   lookup,
+			meta:     &metaTool{fs: c.fs, logger: logger},
+		}
+		err = p.run(cmd.Context(), args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().AddFlagSet(convertCmd.Flags())
+	runCmd.Flags().AddFlagSet(metaLookupCmd.Flags())
+	runCmd.Flags().StringSliceVar(&pipelineSteps, "steps", []string{"convert"}, "steps to run, in order: "+strings.Join(pipelineStepNames, ", "))
+	runCmd.Flags().StringVar(&pipelineImages.Format, "format", "", "for reencode, re-encode pages as jpeg, png, webp or avif, keeps each page's format if unset")
+	runCmd.Flags().IntVar(&pipelineImages.Quality, "quality", 85, "for reencode, quality for lossy formats, 1-100")
+	runCmd.Flags().StringVar(&pipelineImages.Strips, "strips", "", "for reencode, slice webtoon strips into screen sized pages (slice) or join their pages into long strips (stitch)")
+	runCmd.Flags().BoolVar(&pipelineImages.Grayscale, "grayscale", false, "for reencode, drop the colour of pages")
+	runCmd.Flags().Float64Var(&pipelineImages.Contrast, "contrast", 1, "for reencode, stretch levels away from the middle grey by this factor, 1 leaves them")
+	runCmd.Flags().Float64Var(&pipelineImages.Gamma, "gamma", 1, "for reencode, darken midtones above 1 and lighten them below, 1 leaves them")
+	runCmd.Flags().StringVar(&pipelineProfile, "profile", "", "for reencode, image profile to apply (kobo, kindle, tablet, eink)")
+	runCmd.RegisterFlagCompletionFunc("profile", completeImageProfiles)
+	runCmd.Flags().StringVar(&pipelinePassword, "password", "", "for reencode, password for protected archives not matched by passwords in the config file")
+}
+
+// parsePipelineSteps checks steps are known and given once each.
+func parsePipelineSteps(steps []string) ([]string, error) {
+	if len(steps) == 0 {
+		return nil, errors.New("--steps needs at least one step")
+	}
+	parsed := []string{}
+	for _, step := range steps {
+		step = strings.ToLower(strings.TrimSpace(step))
+		if !hasStep(pipelineStepNames, step) {
+			return nil, errors.Errorf("unknown step %q, expected one of %s", step, strings.Join(pipelineStepNames, ", "))
+		}
+		if hasStep(parsed, step) {
+			return nil, errors.Errorf("step %s is given more than once", step)
+		}
+		parsed = append(parsed, step)
+	}
+	return parsed, nil
+}
+
+func hasStep(steps []string, step string) bool {
+	for _, s := range steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// pipeline runs steps one after another over the files found under the
+// paths given, walking the tree once.
+type pipeline struct {
+	steps    []string
+	logger   logger
+	c        *converter
+	reencode *reencoder
+	verify   *archiveVerifier
+	// lookup and meta fill in ComicInfo.xml, lookup is nil without the step
+	lookup *metadataLookup
+	meta   *metaTool
+}
+
+func (p *pipeline) run(ctx context.Context, paths []string) error {
+	// the converter's scan is shared, its filters scoping every step
+	err := p.c.findFilesAndSize(ctx, paths)
+	if err != nil && !errors.Is(err, errNoFiles) {
+		return errors.Wrap(err, "finding files and sizes")
+	}
+	p.c.scanned = true
+	files := p.c.allFiles
+
+	failed := []string{}
+	for _, step := range p.steps {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p.logger.Printf("Step %s\n", step)
+		switch step {
+		case "convert":
+			if len(p.c.cbrFiles) == 0 {
+				p.logger.Printf("Nothing to convert\n")
+				continue
+			}
+			err = p.c.runConvert(ctx, paths)
+			files = p.afterConvert(files)
+		case "reencode":
+			err = p.reencode.reencodeAll(ctx, withExtension(files, ".cbz"))
+		case "lookup":
+			err = p.lookup.lookupAll(ctx, p.meta, withExtension(files, ".cbz"))
+		case "verify":
+			err = p.verify.verifyAll(ctx, withExtension(files, verifiedExtensions...))
+		}
+		if err != nil {
+			p.logger.Printf("Step %s failed: %s\n", step, err.Error())
+			failed = append(failed, step)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("steps %s failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// afterConvert is files as convert left them: the cbz files it wrote are
+// added and the originals it removed, volumes of split rars included, are
+// dropped. Only those are looked up again, not the whole tree.
+func (p *pipeline) afterConvert(files []string) []string {
+	origins := map[string]bool{}
+	for _, origin := range p.c.origins {
+		origins[origin] = true
+	}
+
+	left := []string{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		if origins[file] || laterVolume(file) {
+			if _, err := fs.Stat(p.c.fs, pathToFsPath(file)); err != nil {
+				continue
+			}
+		}
+		left = append(left, file)
+		seen[file] = true
+	}
+	for _, cbz := range p.c.converted {
+		if !seen[cbz] {
+			left = append(left, cbz)
+		}
+	}
+	return left
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parsePipelineSteps(t *testing.T) {
+	steps, err := parsePipelineSteps([]string{"convert", " Reencode", "verify"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"convert", "reencode", "verify"}, steps)
+
+	_, err = parsePipelineSteps([]string{"convert", "organize"})
+	require.ErrorContains(t, err, `unknown step "organize"`)
+	_, err = parsePipelineSteps([]string{"verify", "verify"})
+	require.ErrorContains(t, err, "more than once")
+	_, err = parsePipelineSteps(nil)
+	require.Error(t, err)
+}
+
+func Test_pipeline(t *testing.T) {
+	page := string(makePNG(t, 64, 64))
+	fsys, err := setupFS(t, filenameBytes{
+		"library/new.cbt":   makeTar(t, map[string]string{"001.png": page}),
+		"library/old.cbz":   makeZip(t, map[string]string{"001.png": page}),
+		"library/notes.txt": []byte("not an archive"),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	p := &pipeline{
+		steps:    []string{"convert", "reencode", "verify"},
+		logger:   testLogger{t},
+		c:        c,
+		reencode: &reencoder{fs: fsys, logger: testLogger{t}, images: cbr2cbz.ImageOptions{Format: "jpeg", Quality: 85}},
+		verify:   &archiveVerifier{fs: fsys, logger: testLogger{t}},
+	}
+	require.NoError(t, p.run(context.Background(), []string{"/library"}))
+	require.Equal(t, []string{"/library/new.cbz"}, c.converted)
+
+	entries, err := hackpadfs.ReadDir(fsys, "library")
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch(t, []string{"new.cbz", "old.cbz", "notes.txt"}, names)
+
+	// both the cbz converted and the one already there were re-encoded
+	for _, name := range []string{"library/new.cbz", "library/old.cbz"} {
+		zr, f, err := openZip(fsys, name)
+		require.NoError(t, err)
+		pages := []string{}
+		for _, entry := range zr.File {
+			pages = append(pages, entry.Name)
+		}
+		f.Close()
+		require.Contains(t, pages, "001.jpg", name)
+	}
+
+	require.Equal(t, []string{"/library/notes.txt", "/library/old.cbz", "/library/new.cbz"}, p.afterConvert([]string{"/library/new.cbt", "/library/notes.txt", "/library/old.cbz"}))
+}
+
+func Test_pipelineFailedStep(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/bad.cbz": makeCorruptZip(t),
+	})
+	require.NoError(t, err)
+
+	p := &pipeline{
+		steps:  []string{"convert", "verify"},
+		logger: testLogger{t},
+		c:      &converter{fs: fsys, logger: testLogger{t}},
+		verify: &archiveVerifier{fs: fsys, logger: testLogger{t}},
+	}
+	require.EqualError(t, p.run(context.Background(), []string{"/library"}), "steps verify failed")
+}
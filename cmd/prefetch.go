@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+)
+
+// prefetcher reads the files about to be converted ahead of the workers, one
+// at a time and start to finish, so they are in the OS page cache by the time
+// a worker gets to them. On a NAS this turns the workers' scattered reads
+// over the network into a single sequential stream.
+type prefetcher struct {
+	fs     fs.FS
+	logger logger
+	files  []string
+	ahead  int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	started int
+}
+
+func newPrefetcher(fsys fs.FS, logger logger, files []string, ahead int) *prefetcher {
+	p := &prefetcher{fs: fsys, logger: logger, files: files, ahead: ahead}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// start tells the prefetcher a worker picked up the next file, letting it
+// read one more ahead.
+func (p *prefetcher) start() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.started++
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// run reads files until it is done with them or ctx is. Files a worker
+// already started on are skipped, there is no point racing it.
+func (p *prefetcher) run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.cond.Broadcast()
+	})
+	defer stop()
+
+	for i, name := range p.files {
+		p.mu.Lock()
+		for i >= p.started+p.ahead && ctx.Err() == nil {
+			p.cond.Wait()
+		}
+		skip := i < p.started
+		p.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		if skip {
+			continue
+		}
+
+		err := p.read(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			p.logger.Printf("[%s] Unable to prefetch %s: %s\n", cbr2cbz.CodePrefetchFailed, name, err.Error())
+		}
+	}
+}
+
+func (p *prefetcher) read(ctx context.Context, name string) error {
+	f, err := p.fs.Open(pathToFsPath(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(io.Discard, contextReader{ctx: ctx, r: f})
+	return err
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type openRecorder struct {
+	fs.FS
+	mu     sync.Mutex
+	opened []string
+}
+
+func (o *openRecorder) Open(name string) (fs.File, error) {
+	o.mu.Lock()
+	o.opened = append(o.opened, name)
+	o.mu.Unlock()
+	return o.FS.Open(name)
+}
+
+func (o *openRecorder) names() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string{}, o.opened...)
+}
+
+func Test_prefetcher(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{"a.cbr": []byte("a"), "b.cbr": []byte("b"), "c.cbr": []byte("c")})
+	require.NoError(t, err)
+	rec := &openRecorder{FS: fsys}
+
+	p := newPrefetcher(rec, testLogger{t}, []string{"/a.cbr", "/b.cbr", "/c.cbr"}, 1)
+	done := make(chan struct{})
+	go func() {
+		p.run(context.Background())
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(rec.names()) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	// one ahead, so b waits for a worker to pick up a
+	require.Equal(t, []string{"a.cbr"}, rec.names())
+
+	p.start()
+	require.Eventually(t, func() bool { return len(rec.names()) == 2 }, time.Second, time.Millisecond)
+
+	// workers caught up with c before it was read, so it is skipped
+	p.mu.Lock()
+	p.started += 2
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	<-done
+	require.Equal(t, []string{"a.cbr", "b.cbr"}, rec.names())
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+var presetName string
+
+// conversionPresets are built in bundles of convert flags, selected with
+// --preset. Values are in flag syntax, lists separated by commas.
+var conversionPresets = map[string]map[string]string{
+	// packs exactly what the source had, in its order, and keeps the original
+	"archive-faithful": {
+		"keep":         "true",
+		"verify":       "true",
+		"page-order":   "archive",
+		"keep-files":   "*",
+		"entry-errors": "fail",
+	},
+	// smallest cbz that still reads well, the original goes once it checks out
+	"space-saver": {
+		"keep":        "false",
+		"verify":      "true",
+		"recompress":  "webp:80",
+		"max-width":   "2400",
+		"strip-junk":  "true",
+		"compression": "best",
+	},
+	// sized and named for e-ink readers that sort pages by byte
+	"e-reader": {
+		"keep":               "true",
+		"verify":             "true",
+		"recompress":         "jpeg:80",
+		"max-width":          "1264",
+		"max-height":         "1680",
+		"strip-junk":         "true",
+		"flatten":            "true",
+		"renumber":           "true",
+		"cover-first":        "true",
+		"generate-comicinfo": "true",
+	},
+	// unattended library conversion for Komga, Kavita and friends
+	"server-default": {
+		"keep":               "false",
+		"verify":             "true",
+		"strip-junk":         "true",
+		"pad-numbers":        "true",
+		"generate-comicinfo": "true",
+		"series-json":        "true",
+		"entry-errors":       "skip",
+		"placeholder-pages":  "true",
+	},
+}
+
+// presetNames lists the presets for help and error messages.
+func presetNames() string {
+	names := make([]string, 0, len(conversionPresets))
+	for name := range conversionPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyPreset sets every flag of the preset named by --preset that wasn't
+// given on the command line, in the environment or in the config file.
+func applyPreset(sets ...*pflag.FlagSet) error {
+	presetFlag := lookupFlag("preset", sets...)
+	if presetFlag == nil || presetFlag.Value.String() == "" {
+		return nil
+	}
+	preset, ok := conversionPresets[presetFlag.Value.String()]
+	if !ok {
+		return errors.Errorf("unknown preset %q, use one of %s", presetFlag.Value.String(), presetNames())
+	}
+
+	for name, value := range preset {
+		f := lookupFlag(name, sets...)
+		if f == nil || f.Changed {
+			continue
+		}
+
+		var err error
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			err = slice.Replace(strings.Split(value, ","))
+		} else {
+			err = f.Value.Set(value)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "preset %s: %s", presetFlag.Value.String(), name)
+		}
+		optionSources[name] = "preset"
+	}
+	return nil
+}
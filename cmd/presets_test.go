@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func Test_conversionPresets(t *testing.T) {
+	for name, preset := range conversionPresets {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range preset {
+				f := convertCmd.Flags().Lookup(key)
+				require.NotNil(t, f, key)
+				require.NoError(t, checkConfigValue(f, &yaml.Node{Kind: yaml.ScalarNode, Value: value}), key)
+			}
+
+			get := func(key string) string {
+				if value, ok := preset[key]; ok {
+					return value
+				}
+				if f := convertCmd.Flags().Lookup(key); f != nil {
+					return f.DefValue
+				}
+				return ""
+			}
+			for _, rule := range configRules {
+				require.NoError(t, rule(get))
+			}
+		})
+	}
+}
+
+func Test_applyPreset(t *testing.T) {
+	t.Cleanup(func() { optionSources = map[string]string{} })
+
+	set := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	set.String("preset", "", "")
+	set.Bool("keep", false, "")
+	set.Bool("strip-junk", false, "")
+	set.String("recompress", "", "")
+	set.StringSlice("keep-files", []string{"ComicInfo.xml"}, "")
+	require.NoError(t, set.Parse([]string{"--preset", "archive-faithful", "--keep=false"}))
+
+	require.NoError(t, applyPreset(set))
+	keep, _ := set.GetBool("keep")
+	require.False(t, keep, "flags given by hand win over the preset")
+	keepFiles, _ := set.GetStringSlice("keep-files")
+	require.Equal(t, []string{"*"}, keepFiles)
+	require.Equal(t, "preset", optionSources["keep-files"])
+	require.NotContains(t, optionSources, "keep")
+
+	require.NoError(t, set.Set("preset", "space-saver"))
+	require.NoError(t, applyPreset(set))
+	recompress, _ := set.GetString("recompress")
+	require.Equal(t, "webp:80", recompress)
+
+	require.NoError(t, set.Set("preset", "turbo"))
+	require.ErrorContains(t, applyPreset(set), `unknown preset "turbo"`)
+}
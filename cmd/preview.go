@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	previewImages  cbr2cbz.ImageOptions
+	previewProfile string
+	previewOut     string
+	previewPages   int
+)
+
+// previewCmd represents the preview command
+var previewCmd = &cobra.Command{
+	Use:   "preview [file]",
+	Short: "Converts a few sample pages with the chosen image settings to judge quality",
+	Long: `Converts a few sample pages with the chosen image settings to judge quality.
+
+Nothing in the source archive is changed, e.g. preview --profile kobo file.cbr --out samples/`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		err := applyImageProfile(cmd, previewProfile, &previewImages)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err := previewImages.Validate(); err != nil {
+			logger.Fatal(err)
+		}
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		p := &previewer{
+			fs:     fsys,
+			logger: logger,
+			images: previewImages,
+		}
+		err = p.preview(cmd.Context(), args[0], previewOut, previewPages)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(previewCmd)
+
+	addImageFlags(previewCmd, &previewImages)
+	previewCmd.Flags().StringVar(&previewProfile, "profile", "", "image profile to preview (kobo, kindle, tablet, eink)")
+	previewCmd.RegisterFlagCompletionFunc("profile", completeImageProfiles)
+	previewCmd.Flags().StringVar(&previewOut, "out", "", "directory to write the sample pages to")
+	previewCmd.Flags().IntVar(&previewPages, "pages", 4, "how many sample pages to convert")
+	previewCmd.MarkFlagRequired("out")
+}
+
+type previewer struct {
+	fs      hackpadfs.FS
+	logger  logger
+	images  cbr2cbz.ImageOptions
+	entries cbr2cbz.EntryFilter
+}
+
+func (p *previewer) preview(ctx context.Context, file string, outDir string, count int) error {
+	archive, err := openArchive(ctx, p.fs, pathToFsPath(file))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	pages, err := archive.pages(p.entries)
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return errors.New("no pages found")
+	}
+
+	err = hackpadfs.MkdirAll(p.fs, pathToFsPath(outDir), 0755)
+	if err != nil {
+		return errors.Wrap(err, "creating output directory")
+	}
+
+	stem := strings.TrimSuffix(path.Base(file), path.Ext(file))
+	for _, page := range samplePages(pages, count) {
+		data, err := fs.ReadFile(archive, page)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", page)
+		}
+
+		name, out, err := p.images.Process(page, data)
+		if err != nil {
+			return err
+		}
+
+		dest := path.Join(pathToFsPath(outDir), stem+"-"+strings.ReplaceAll(name, "/", "-"))
+		err = hackpadfs.WriteFullFile(p.fs, dest, out, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "writing %s", dest)
+		}
+
+		quality := ""
+		if q, err := comparePages(data, out); err == nil {
+			quality = fmt.Sprintf(", SSIM %.4f, PSNR %.2fdB", q.SSIM, q.PSNR)
+		}
+		p.logger.Printf("%s: %s -> %s (%s%s) written to %s\n", page, formatBytes(uint64(len(data))), formatBytes(uint64(len(out))), imageDimensions(out), quality, "/"+dest)
+	}
+	return nil
+}
+
+// samplePages spreads count picks evenly over pages, always including the
+// first and last page.
+func samplePages(pages []string, count int) []string {
+	if count <= 0 || count >= len(pages) {
+		return pages
+	}
+	if count == 1 {
+		return pages[:1]
+	}
+
+	picked := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		picked = append(picked, pages[i*(len(pages)-1)/(count-1)])
+	}
+	return picked
+}
+
+func imageDimensions(data []byte) string {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "unknown size"
+	}
+	return fmt.Sprintf("%s %dx%d", format, cfg.Width, cfg.Height)
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+type countingWriter struct {
+	io.Writer
+	n *atomic.Uint64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n.Add(uint64(n))
+	return n, err
+}
+
+// startHeartbeat logs a progress line for file every interval until the
+// returned stop function is called. An interval of zero disables it.
+func (c *converter) startHeartbeat(file string, p *cbr2cbz.Progress, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// straight out rather than held back with the file's lines,
+				// it is there to show the file is still going
+				c.logger.Printf("Still converting %s: %s extracted, %s written, current entry %s\n",
+					file, formatBytes(p.Read.Load()), formatBytes(p.Written.Load()), p.Entry())
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// stallNoticeKey is the context key of the func telling abandonIfStuck a
+// conversion was cancelled for stalling.
+type stallNoticeKey struct{}
+
+// cancelNoticing is cancel, also telling abandonIfStuck, if ctx comes from
+// it, when the cause is errStalled.
+func cancelNoticing(ctx context.Context, cancel context.CancelCauseFunc) context.CancelCauseFunc {
+	notice, _ := ctx.Value(stallNoticeKey{}).(func())
+	return func(cause error) {
+		cancel(cause)
+		if notice != nil && errors.Is(cause, errStalled) {
+			notice()
+		}
+	}
+}
+
+// abandonIfStuck runs convert, but stops waiting for it once it was
+// cancelled for stalling and still hasn't returned grace later: a read
+// blocked on a dead network mount never sees the cancellation, and the rest
+// of the batch shouldn't wait on it forever. convert's calls to written
+// are ignored from then on.
+func abandonIfStuck(ctx context.Context, grace time.Duration, convert func(ctx context.Context, written func()) error, written func()) error {
+	stalled := make(chan struct{})
+	var noticed sync.Once
+	ctx = context.WithValue(ctx, stallNoticeKey{}, func() { noticed.Do(func() { close(stalled) }) })
+
+	var mu sync.Mutex
+	abandoned := false
+	guarded := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !abandoned && written != nil {
+			written()
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- convert(ctx, guarded) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-stalled:
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(grace):
+	}
+	mu.Lock()
+	abandoned = true
+	mu.Unlock()
+	return errors.Wrapf(errStalled, "still stuck %s after giving up on it, left behind", grace)
+}
+
+// errStalled is the cause a conversion is cancelled with when no bytes
+// have moved for longer than the stall timeout.
+var errStalled = errors.New("stalled")
+
+// watchForStall cancels the conversion with errStalled if neither the read
+// nor written totals in p change for timeout. A timeout of zero disables it.
+func watchForStall(p *cbr2cbz.Progress, timeout time.Duration, cancel context.CancelCauseFunc) (stop func()) {
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(timeout / 4)
+	go func() {
+		defer ticker.Stop()
+		last := p.Read.Load() + p.Written.Load()
+		lastChange := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				current := p.Read.Load() + p.Written.Load()
+				if current != last {
+					last = current
+					lastChange = now
+					continue
+				}
+				if now.Sub(lastChange) >= timeout {
+					cancel(errStalled)
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_watchForStall(t *testing.T) {
+	p := &cbr2cbz.Progress{}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	stop := watchForStall(p, 20*time.Millisecond, cancel)
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+		require.ErrorIs(t, context.Cause(ctx), errStalled)
+	case <-time.After(time.Second):
+		t.Fatal("stall was never detected")
+	}
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+)
+
+const progressBarWidth = 30
+
+// batchDisplay draws a single status line with an overall bar for the batch
+// and how far along the files being converted are. Log lines written through
+// it are printed above the bar rather than over it. When the terminal can't
+// redraw, the status is printed as a plain line each time a file finishes.
+type batchDisplay struct {
+	term *terminal
+
+	mu        sync.Mutex
+	files     int
+	total     uint64
+	doneFiles int
+	doneBytes uint64
+	active    map[string]*activeFile
+	shown     bool
+}
+
+type activeFile struct {
+	size     uint64
+	progress *cbr2cbz.Progress
+}
+
+func newBatchDisplay(term *terminal) *batchDisplay {
+	return &batchDisplay{
+		term:   term,
+		active: map[string]*activeFile{},
+	}
+}
+
+// begin sets what the batch consists of and starts redrawing every interval
+// until the returned stop function is called, which also takes the bar away.
+func (d *batchDisplay) begin(files int, total uint64, interval time.Duration) (stop func()) {
+	if d == nil {
+		return func() {}
+	}
+	d.mu.Lock()
+	d.files, d.total = files, total
+	d.doneFiles, d.doneBytes = 0, 0
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				d.redraw()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.files = 0
+			d.clear()
+		})
+	}
+}
+
+// start adds a file to the ones being converted.
+func (d *batchDisplay) start(name string, size uint64, p *cbr2cbz.Progress) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active[name] = &activeFile{size: size, progress: p}
+}
+
+// finish removes a file from the active ones, counting it as done whether it
+// worked or not.
+func (d *batchDisplay) finish(name string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.active[name]; ok {
+		d.doneBytes += f.size
+		delete(d.active, name)
+	}
+	d.doneFiles++
+	if !d.term.interactive {
+		fmt.Fprintln(d.term.out, d.term.text(d.line()))
+	}
+}
+
+// Write prints log output above the bar.
+func (d *batchDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	n, err := d.term.out.Write(p)
+	if d.files > 0 {
+		d.draw()
+	}
+	return n, err
+}
+
+func (d *batchDisplay) redraw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.draw()
+}
+
+func (d *batchDisplay) draw() {
+	if !d.term.interactive {
+		return
+	}
+	d.term.redraw(d.line())
+	d.shown = true
+}
+
+func (d *batchDisplay) clear() {
+	if d.shown {
+		d.term.clear()
+		d.shown = false
+	}
+}
+
+// line renders the status line, e.g.
+// [#########.....................] 31% 1.2 GB/3.9 GB 12/40 files | Saga 01.cbr 14/40 pages
+func (d *batchDisplay) line() string {
+	processed := float64(d.doneBytes)
+	names := make([]string, 0, len(d.active))
+	for name, f := range d.active {
+		processed += float64(f.size) * f.progress.Fraction()
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fraction := 0.0
+	if d.total > 0 {
+		fraction = min(processed/float64(d.total), 1)
+	}
+	filled := int(fraction * progressBarWidth)
+
+	line := fmt.Sprintf("[%s%s] %3.0f%% %s/%s %d/%d files",
+		strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled),
+		fraction*100, formatBytes(uint64(processed)), formatBytes(d.total), d.doneFiles, d.files)
+
+	if len(names) > 0 {
+		p := d.active[names[0]].progress
+		line += " | " + filepath.Base(names[0])
+		if entries := p.Entries.Load(); entries > 0 {
+			line += fmt.Sprintf(" %d/%d pages", min(p.Opened.Load(), entries), entries)
+		}
+		if len(names) > 1 {
+			line += fmt.Sprintf(" (+%d more)", len(names)-1)
+		}
+	}
+	return line
+}
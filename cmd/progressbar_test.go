@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_batchDisplay(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := newBatchDisplay(&terminal{out: out, interactive: true, ansi: true, unicode: true})
+	stop := d.begin(4, 4000, time.Hour)
+
+	require.Equal(t, "[..............................]   0% 0 B/4.0 kB 0/4 files", d.line())
+
+	d.start("/comics/a.cbr", 1000, &cbr2cbz.Progress{})
+	d.finish("/comics/a.cbr")
+	d.finish("/comics/broken.cbr")
+
+	p := &cbr2cbz.Progress{}
+	p.Expected.Store(100)
+	p.Entries.Store(10)
+	p.Read.Store(50)
+	p.Opened.Store(5)
+	d.start("/comics/b/Saga 01.cbr", 2000, p)
+	d.start("/comics/c.cbr", 1000, &cbr2cbz.Progress{})
+	require.Equal(t, "[###############...............]  50% 2.0 kB/4.0 kB 2/4 files | Saga 01.cbr 5/10 pages (+1 more)", d.line())
+
+	d.Write([]byte("log line\n"))
+	require.True(t, strings.HasPrefix(out.String(), "log line\n\r[###"), "log lines go above the bar")
+
+	stop()
+	out.Reset()
+	d.Write([]byte("after\n"))
+	require.Equal(t, "after\n", out.String(), "no bar once the batch is done")
+}
+
+func Test_batchDisplay_notInteractive(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := newBatchDisplay(&terminal{out: out})
+	stop := d.begin(2, 2000, time.Millisecond)
+	defer stop()
+
+	d.start("/comics/a.cbr", 1000, &cbr2cbz.Progress{})
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, out.String(), "no redraws")
+
+	d.finish("/comics/a.cbr")
+	require.Equal(t, "[###############...............]  50% 1.0 kB/2.0 kB 1/2 files\n", out.String())
+}
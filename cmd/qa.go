@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// qaReport is the outcome of checking a --qa-sample of a batch.
+type qaReport struct {
+	Percent float64    `json:"percent"`
+	Sampled int        `json:"sampled"`
+	Passed  int        `json:"passed"`
+	Failed  int        `json:"failed"`
+	Results []qaResult `json:"results"`
+}
+
+// qaResult is how one sampled cbz held up.
+type qaResult struct {
+	File   string `json:"file"`
+	Source string `json:"source"`
+	Pages  int    `json:"pages"`
+	// Compared is whether the original was still there to compare against
+	Compared    bool   `json:"compared"`
+	SourcePages int    `json:"source_pages,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// sampleFiles picks percent of files at random, at least one if there are
+// any, in their original order.
+func sampleFiles(files []string, percent float64) []string {
+	n := int(math.Ceil(float64(len(files)) * percent / 100))
+	n = min(n, len(files))
+	picked := rand.Perm(len(files))[:n]
+	sort.Ints(picked)
+
+	sample := make([]string, 0, n)
+	for _, i := range picked {
+		sample = append(sample, files[i])
+	}
+	return sample
+}
+
+// runQA deep checks each of the sampled cbz files and reports how they did.
+func (c *converter) runQA(ctx context.Context, sample []string) *qaReport {
+	report := &qaReport{Percent: c.qaSample, Sampled: len(sample), Results: []qaResult{}}
+	for _, cbzFile := range sample {
+		if ctx.Err() != nil {
+			break
+		}
+		res := qaResult{File: cbzFile, Source: c.origins[cbzFile]}
+		err := c.qaCheck(ctx, &res)
+		if err != nil {
+			res.Error = err.Error()
+			report.Failed++
+			c.logger.Printf("[%s] QA failed for %s: %s\n", cbr2cbz.CodeQAFailed, cbzFile, err.Error())
+			c.events.emit(logEvent{Action: "qa", Code: cbr2cbz.CodeQAFailed, File: res.Source, Output: cbzFile, Error: res.Error})
+		} else {
+			report.Passed++
+			c.events.emit(logEvent{Action: "qa", File: res.Source, Output: cbzFile})
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	c.logger.Printf("QA sampled %d of %d converted files (%g%%): %d passed, %d failed\n",
+		report.Sampled, len(c.converted), c.qaSample, report.Passed, report.Failed)
+	return report
+}
+
+// qaCheck reads res.File back, checking CRCs and decoding every page, and
+// compares its pages with the original's if that was kept.
+func (c *converter) qaCheck(ctx context.Context, res *qaResult) error {
+	outputs, err := c.qaOutputs(res.File)
+	if err != nil {
+		return err
+	}
+
+	opts := c.packer().Options()
+	filter := cbr2cbz.NewEntryFilter(opts.ImageExtensions, opts.KeepFiles)
+	hashes := []string{}
+	for _, output := range outputs {
+		err := verifyZip(c.fs, output)
+		if err != nil {
+			return errors.Wrapf(err, "verifying %s", output)
+		}
+		sums, err := pageHashes(ctx, c.fs, output, filter, true)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, sums...)
+	}
+	res.Pages = len(hashes)
+
+	// pdfs and compressed archives can't be compared page by page
+	if res.Source == "" || res.Source == res.File || strings.EqualFold(path.Ext(res.Source), ".pdf") || isWrapped(c.fs, res.Source) {
+		return nil
+	}
+	if _, err := hackpadfs.Stat(c.fs, pathToFsPath(res.Source)); err != nil {
+		// deleted after converting, nothing to compare with
+		return nil
+	}
+	sourceHashes, err := pageHashes(ctx, c.fs, pathToFsPath(res.Source), filter, false)
+	if err != nil {
+		return errors.Wrap(err, "reading original")
+	}
+	if opts.StripJunk {
+		// empty pages were left out as junk
+		sourceHashes = slices.DeleteFunc(sourceHashes, func(h string) bool { return h == emptyHash })
+	}
+	if opts.DropDuplicatePages {
+		// pages the same as another were left out
+		slices.Sort(sourceHashes)
+		sourceHashes = slices.Compact(sourceHashes)
+	}
+	res.Compared = true
+	res.SourcePages = len(sourceHashes)
+
+	// slicing strips changes the page count, re-encoding every page's bytes
+	if opts.Images != nil && opts.Images.Strips != "" {
+		return nil
+	}
+	if res.Pages != res.SourcePages {
+		return errors.Errorf("%d pages, the original has %d", res.Pages, res.SourcePages)
+	}
+	if opts.Images != nil {
+		return nil
+	}
+	slices.Sort(hashes)
+	slices.Sort(sourceHashes)
+	if !slices.Equal(hashes, sourceHashes) {
+		return errors.New("pages differ from the original")
+	}
+	return nil
+}
+
+// qaOutputs are the cbz files cbzFile was written as, itself or the chapters
+// --split-chapters wrote in its place.
+func (c *converter) qaOutputs(cbzFile string) ([]string, error) {
+	name := pathToFsPath(cbzFile)
+	if _, err := hackpadfs.Stat(c.fs, name); err == nil || !c.splitChapters {
+		return []string{name}, nil
+	}
+
+	dir := strings.TrimSuffix(name, path.Ext(name))
+	entries, err := hackpadfs.ReadDir(c.fs, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing chapters")
+	}
+	outputs := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(path.Ext(e.Name()), ".cbz") {
+			outputs = append(outputs, path.Join(dir, e.Name()))
+		}
+	}
+	return outputs, nil
+}
+
+// emptyHash is what pageHashes gives an empty page.
+var emptyHash = func() string {
+	sum := sha256.Sum256(nil)
+	return hex.EncodeToString(sum[:])
+}()
+
+// pageHashes hashes every page of the archive at name, decoding each too
+// when decode is set.
+func pageHashes(ctx context.Context, fsys hackpadfs.FS, name string, filter cbr2cbz.EntryFilter, decode bool) ([]string, error) {
+	archive, err := openArchive(ctx, fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	pages, err := archive.pages(filter)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(pages))
+	for _, page := range pages {
+		data, err := fs.ReadFile(archive, page)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", page)
+		}
+		if decode {
+			_, _, err = image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, errors.Wrapf(err, "decoding %s", page)
+			}
+		}
+		sum := sha256.Sum256(data)
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+	return hashes, nil
+}
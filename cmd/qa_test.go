@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sampleFiles(t *testing.T) {
+	files := []string{}
+	for i := 0; i < 10; i++ {
+		files = append(files, fmt.Sprintf("%02d.cbz", i))
+	}
+
+	tests := []struct {
+		name    string
+		files   []string
+		percent float64
+		want    int
+	}{
+		{name: "none to pick from", files: nil, percent: 50, want: 0},
+		{name: "rounds up", files: files, percent: 25, want: 3},
+		{name: "at least one", files: files, percent: 1, want: 1},
+		{name: "everything", files: files, percent: 100, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sampleFiles(tt.files, tt.percent)
+			require.Len(t, got, tt.want)
+			require.Subset(t, files, got)
+			require.IsIncreasing(t, got)
+		})
+	}
+}
+
+func Test_runQA(t *testing.T) {
+	page := string(makePNG(t, 4, 4))
+	fsys, err := setupFS(t, filenameBytes{
+		"library/good.cbt":   makeTar(t, map[string]string{"good/001.png": page, "good/002.png": string(makePNG(t, 8, 8))}),
+		"library/broken.cbt": makeTar(t, map[string]string{"broken/001.png": page, "broken/002.png": "not a png"}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, keep: true, qaSample: 100}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.converted, 2)
+
+	require.NotNil(t, c.qa)
+	require.Equal(t, 2, c.qa.Sampled)
+	require.Equal(t, 1, c.qa.Passed)
+	require.Equal(t, 1, c.qa.Failed)
+	for _, res := range c.qa.Results {
+		switch res.File {
+		case "/library/good.cbz":
+			require.Empty(t, res.Error)
+			require.True(t, res.Compared)
+			require.Equal(t, 2, res.Pages)
+			require.Equal(t, 2, res.SourcePages)
+		case "/library/broken.cbz":
+			require.Contains(t, res.Error, "decoding broken/002.png")
+		default:
+			t.Fatalf("unexpected result for %s", res.File)
+		}
+	}
+
+	t.Run("pages differ from the original", func(t *testing.T) {
+		require.NoError(t, hackpadfs.WriteFullFile(fsys, "library/good.cbz", makeZip(t, map[string]string{
+			"001.png": page,
+			"002.png": page,
+		}), 0o644))
+
+		res := qaResult{File: "/library/good.cbz", Source: "/library/good.cbt"}
+		err := c.qaCheck(context.Background(), &res)
+		require.EqualError(t, err, "pages differ from the original")
+	})
+
+	t.Run("original deleted", func(t *testing.T) {
+		require.NoError(t, hackpadfs.Remove(fsys, "library/good.cbt"))
+
+		res := qaResult{File: "/library/good.cbz", Source: "/library/good.cbt"}
+		require.NoError(t, c.qaCheck(context.Background(), &res))
+		require.False(t, res.Compared)
+		require.Equal(t, 2, res.Pages)
+	})
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+// pageQuality is how close a re-encoded page is to the original.
+type pageQuality struct {
+	SSIM float64 `json:"ssim"`
+	PSNR float64 `json:"psnr"`
+}
+
+// qualityStats accumulates page metrics and sizes for a whole file.
+type qualityStats struct {
+	Pages   int     `json:"pages"`
+	SSIM    float64 `json:"avg_ssim"`
+	PSNR    float64 `json:"avg_psnr"`
+	BytesIn uint64  `json:"bytes_in"`
+	Bytes   uint64  `json:"bytes_out"`
+}
+
+func (s *qualityStats) add(q pageQuality, before, after int) {
+	s.SSIM = (s.SSIM*float64(s.Pages) + q.SSIM) / float64(s.Pages+1)
+	s.PSNR = (s.PSNR*float64(s.Pages) + q.PSNR) / float64(s.Pages+1)
+	s.Pages++
+	s.BytesIn += uint64(before)
+	s.Bytes += uint64(after)
+}
+
+func (s qualityStats) String() string {
+	if s.Pages == 0 {
+		return "no pages re-encoded"
+	}
+	delta := 0.0
+	if s.BytesIn > 0 {
+		delta = (float64(s.Bytes) - float64(s.BytesIn)) / float64(s.BytesIn) * 100
+	}
+	return fmt.Sprintf("%d pages, avg SSIM %.4f, avg PSNR %.2fdB, size %+.1f%%", s.Pages, s.SSIM, s.PSNR, delta)
+}
+
+// comparePages decodes the original and re-encoded page and measures how
+// much the re-encode lost. If the page was downscaled the original is
+// scaled the same way first, so only the encoding loss is measured.
+func comparePages(original, reencoded []byte) (pageQuality, error) {
+	a, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return pageQuality{}, errors.Wrap(err, "decoding original")
+	}
+	b, _, err := image.Decode(bytes.NewReader(reencoded))
+	if err != nil {
+		return pageQuality{}, errors.Wrap(err, "decoding re-encoded")
+	}
+
+	if a.Bounds().Size() != b.Bounds().Size() {
+		scaled := image.NewRGBA(image.Rectangle{Max: b.Bounds().Size()})
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), a, a.Bounds(), draw.Src, nil)
+		a = scaled
+	}
+
+	return pageQuality{SSIM: ssim(a, b), PSNR: psnr(a, b)}, nil
+}
+
+// psnr is the peak signal to noise ratio over the RGB channels, in dB.
+// Identical images come back as +Inf capped to 100.
+func psnr(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	var sum float64
+	n := 0
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+				n++
+			}
+		}
+	}
+	if n == 0 || sum == 0 {
+		return 100
+	}
+	mse := sum / float64(n)
+	return 10 * math.Log10(255*255/mse)
+}
+
+// ssim is the mean structural similarity of the two images' luma, computed
+// over non overlapping 8x8 windows.
+func ssim(a, b image.Image) float64 {
+	const (
+		window = 8
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+
+	ab, bb := a.Bounds(), b.Bounds()
+	w, h := ab.Dx(), ab.Dy()
+
+	var total float64
+	windows := 0
+	for wy := 0; wy+window <= h || (wy == 0 && h > 0); wy += window {
+		for wx := 0; wx+window <= w || (wx == 0 && w > 0); wx += window {
+			var sa, sb, saa, sbb, sab float64
+			n := 0
+			for y := wy; y < min(wy+window, h); y++ {
+				for x := wx; x < min(wx+window, w); x++ {
+					la := luma(a, ab.Min.X+x, ab.Min.Y+y)
+					lb := luma(b, bb.Min.X+x, bb.Min.Y+y)
+					sa += la
+					sb += lb
+					saa += la * la
+					sbb += lb * lb
+					sab += la * lb
+					n++
+				}
+			}
+			fn := float64(n)
+			ma, mb := sa/fn, sb/fn
+			va := saa/fn - ma*ma
+			vb := sbb/fn - mb*mb
+			cov := sab/fn - ma*mb
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
+
+func luma(img image.Image, x, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+}
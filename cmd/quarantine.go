@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+var (
+	quarantineDir  string
+	quarantineLink bool
+)
+
+// notQuarantined are failures that say nothing about the file itself, it
+// would most likely convert fine next time.
+var notQuarantined = map[string]bool{
+	cbr2cbz.CodeCanceled:       true,
+	cbr2cbz.CodeIOError:        true,
+	cbr2cbz.CodeOutOfFiles:     true,
+	cbr2cbz.CodeReadOnlySource: true,
+	cbr2cbz.CodeClaimed:        true,
+}
+
+// quarantineFailed moves the files that failed into quarantineDir, at the
+// same place under it as they were under the path they were found in, each
+// with a note of why next to it.
+func (c *converter) quarantineFailed(failed map[string]error) {
+	if c.quarantineDir == "" {
+		return
+	}
+	files := make([]string, 0, len(failed))
+	for file := range failed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		err := failed[file]
+		if notQuarantined[errorCode(err)] {
+			continue
+		}
+		dst, qerr := c.quarantine(file, err)
+		if qerr != nil {
+			c.warn(cbr2cbz.CodeQuarantineFailed, file, "Unable to quarantine %s: %s", file, qerr.Error())
+			continue
+		}
+		c.logger.Printf("Quarantined %s in %s\n", file, dst)
+	}
+}
+
+// quarantine moves or links file, and every volume of a split rar, into
+// quarantineDir and writes the note, returning where file went.
+func (c *converter) quarantine(file string, reason error) (string, error) {
+	volumes := rarVolumes(c.fs, file)
+	if volumes == nil {
+		volumes = []string{file}
+	}
+	dst := ""
+	for _, volume := range volumes {
+		to := path.Join(pathToFsPath(c.quarantineDir), c.relPath(volume))
+		err := hackpadfs.MkdirAll(c.fs, path.Dir(to), 0755)
+		if err != nil {
+			return "", errors.Wrap(err, "creating quarantine directory")
+		}
+		to, err = freeName(c.fs, to)
+		if err != nil {
+			return "", err
+		}
+		if c.quarantineLink {
+			err = c.linkFile(pathToFsPath(volume), to)
+		} else {
+			err = moveFile(c.fs, pathToFsPath(volume), to)
+		}
+		if err != nil {
+			return "", err
+		}
+		if volume == file {
+			dst = "/" + to
+		}
+	}
+
+	note := strings.Builder{}
+	fmt.Fprintf(&note, "File: %s\n", file)
+	fmt.Fprintf(&note, "Error: [%s] %s\n", errorCode(reason), reason.Error())
+	fmt.Fprintf(&note, "Failed: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&note, "Version: %s\n", buildVersion)
+	if len(volumes) > 1 {
+		fmt.Fprintf(&note, "Volumes: %s\n", strings.Join(volumes, ", "))
+	}
+	err := hackpadfs.WriteFullFile(c.fs, pathToFsPath(dst)+".error.txt", []byte(note.String()), 0644)
+	return dst, errors.Wrap(err, "writing the note")
+}
+
+// linkFile hard links src to dst, which only works on a local disk.
+func (c *converter) linkFile(src string, dst string) error {
+	osFS, ok := c.fs.(interface{ ToOSPath(string) (string, error) })
+	if !ok {
+		return errors.New("--quarantine-link only works for files on a local disk")
+	}
+	osSrc, err := osFS.ToOSPath(src)
+	if err != nil {
+		return err
+	}
+	osDst, err := osFS.ToOSPath(dst)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(os.Link(osSrc, osDst), "linking into the quarantine directory")
+}
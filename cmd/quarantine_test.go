@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_quarantineFailed(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga/001.cbr":    realCBRContents,
+		"library/Saga/broken.cbr": []byte("not an archive"),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, quarantineDir: "/quarantine"}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	_, err = hackpadfs.Stat(fsys, "library/Saga/broken.cbr")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	data, err := hackpadfs.ReadFile(fsys, "quarantine/Saga/broken.cbr")
+	require.NoError(t, err)
+	require.Equal(t, "not an archive", string(data))
+	note, err := hackpadfs.ReadFile(fsys, "quarantine/Saga/broken.cbr.error.txt")
+	require.NoError(t, err)
+	require.Contains(t, string(note), "File: /library/Saga/broken.cbr\n")
+	require.Contains(t, string(note), "Error: [E101]")
+
+	_, err = hackpadfs.Stat(fsys, "library/Saga/001.cbz")
+	require.NoError(t, err, "converted files stay where they are")
+}
+
+func Test_quarantineFailed_link(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/broken.cbr": []byte("not an archive"),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, quarantineDir: "/quarantine", quarantineLink: true}
+	c.roots = map[string]string{"/library/broken.cbr": "/library"}
+	c.quarantineFailed(map[string]error{"/library/broken.cbr": errNoFiles})
+
+	// links need a local disk, the file is left alone
+	_, err = hackpadfs.Stat(fsys, "library/broken.cbr")
+	require.NoError(t, err)
+	_, err = hackpadfs.Stat(fsys, "quarantine/broken.cbr.error.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
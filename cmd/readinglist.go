@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// readingBook is one entry of a reading list. Without a number it stands
+// for every issue of the series.
+type readingBook struct {
+	series string
+	number string
+	year   int
+}
+
+func (b readingBook) String() string {
+	if b.number == "" {
+		return b.series
+	}
+	return b.series + " #" + b.number
+}
+
+// readingList is the comics to convert or export, in reading order.
+type readingList struct {
+	books []readingBook
+}
+
+// cblList is the part of a ComicRack .cbl file that says which books are on
+// the list.
+type cblList struct {
+	XMLName xml.Name `xml:"ReadingList"`
+	Books   []struct {
+		Series string `xml:"Series,attr"`
+		Number string `xml:"Number,attr"`
+		Year   int    `xml:"Year,attr"`
+	} `xml:"Books>Book"`
+}
+
+// loadReadingList reads the reading list at name.
+func loadReadingList(name string) (*readingList, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the reading list")
+	}
+	list, err := parseReadingList(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", name)
+	}
+	return list, nil
+}
+
+// parseReadingList parses a ComicRack .cbl, or plain text with a book per
+// line named like the files are, "Saga #14" or "Saga v02 014 (2013)". A
+// line with just a series is all of it, lines starting with # are comments.
+func parseReadingList(data []byte) (*readingList, error) {
+	list := &readingList{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		cbl := cblList{}
+		err := xml.Unmarshal(data, &cbl)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range cbl.Books {
+			book := readingBook{series: strings.TrimSpace(b.Series), year: b.Year}
+			if number := strings.TrimSpace(b.Number); number != "" {
+				book.number = cbr2cbz.TrimIssueNumber(number)
+			}
+			if book.series == "" {
+				continue
+			}
+			list.books = append(list.books, book)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			// the extension keeps a ".5" issue from being taken for one
+			info := cbr2cbz.ComicInfoFromFilename(strings.ReplaceAll(line, "/", " ") + ".cbz")
+			if info.Series == "" {
+				continue
+			}
+			list.books = append(list.books, readingBook{series: info.Series, number: info.Number, year: info.Year})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(list.books) == 0 {
+		return nil, errors.New("no books on the reading list")
+	}
+	return list, nil
+}
+
+// issueOnly is a file name that is nothing but the issue number.
+var issueOnly = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+// comicIdentity is the series, number and year guessed from name, taking
+// names that are nothing but a number as the issue, like Saga/014.cbr.
+func comicIdentity(name string) *cbr2cbz.ComicInfo {
+	info := cbr2cbz.ComicInfoFromFilename(name)
+	if info.Number == "" && issueOnly.MatchString(info.Series) {
+		info.Number, info.Series = cbr2cbz.TrimIssueNumber(info.Series), ""
+	}
+	return info
+}
+
+// seriesKey is series with case, punctuation and spacing ignored, so
+// "Batman/Superman" is the same as "batman - superman".
+func seriesKey(series string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(series), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// position is where the comic at name is on the list, going by the series
+// and number in its name, or the name of its folder for files only named
+// after the issue. Years are only compared when both have one.
+func (l *readingList) position(name string) (int, bool) {
+	name = filepath.ToSlash(name)
+	info := comicIdentity(name)
+	series := []string{seriesKey(info.Series), seriesKey(path.Base(path.Dir(name)))}
+	for i, book := range l.books {
+		key := seriesKey(book.series)
+		if key != series[0] && key != series[1] {
+			continue
+		}
+		if book.number != "" && book.number != info.Number {
+			continue
+		}
+		if book.year != 0 && info.Year != 0 && book.year != info.Year {
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+// filter is the names that are on the list, in the order of the list and
+// by name for a whole series.
+func (l *readingList) filter(names []string) []string {
+	positions := map[string]int{}
+	picked := []string{}
+	for _, name := range names {
+		if i, ok := l.position(name); ok {
+			positions[name] = i
+			picked = append(picked, name)
+		}
+	}
+	sort.SliceStable(picked, func(i, j int) bool {
+		pi, pj := positions[picked[i]], positions[picked[j]]
+		if pi != pj {
+			return pi < pj
+		}
+		return picked[i] < picked[j]
+	})
+	return picked
+}
+
+// missing is the books on the list none of names are.
+func (l *readingList) missing(names []string) []readingBook {
+	found := map[int]bool{}
+	for _, name := range names {
+		if i, ok := l.position(name); ok {
+			found[i] = true
+		}
+	}
+	missing := []readingBook{}
+	for i, book := range l.books {
+		if !found[i] {
+			missing = append(missing, book)
+		}
+	}
+	return missing
+}
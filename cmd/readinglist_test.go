@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseReadingList(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []readingBook
+		wantErr bool
+	}{
+		{
+			name: "cbl",
+			data: `<?xml version="1.0"?>
+<ReadingList>
+  <Name>Crisis</Name>
+  <Books>
+    <Book Series="Crisis on Infinite Earths" Number="001" Volume="1985" Year="1985"/>
+    <Book Series="Batman/Superman" Number="12.5"/>
+  </Books>
+</ReadingList>`,
+			want: []readingBook{
+				{series: "Crisis on Infinite Earths", number: "1", year: 1985},
+				{series: "Batman/Superman", number: "12.5"},
+			},
+		},
+		{
+			name: "text",
+			data: "# summer\nSaga #14\n\nThe_Walking_Dead v03 018 (2005)\nMonstress\n",
+			want: []readingBook{
+				{series: "Saga", number: "14"},
+				{series: "The Walking Dead", number: "18", year: 2005},
+				{series: "Monstress"},
+			},
+		},
+		{name: "empty", data: "# nothing yet\n", wantErr: true},
+		{name: "broken cbl", data: "<ReadingList><Books>", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReadingList([]byte(tt.data))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got.books)
+		})
+	}
+}
+
+func Test_readingList_filter(t *testing.T) {
+	list := &readingList{books: []readingBook{
+		{series: "Batman - Superman", number: "2"},
+		{series: "Saga", number: "14", year: 2013},
+		{series: "Monstress"},
+		{series: "Paper Girls", number: "1"},
+	}}
+	names := []string{
+		"/comics/Monstress/Monstress 002.cbr",
+		"/comics/Monstress/Monstress 001.cbr",
+		"/comics/Saga/Saga v02 #014 (2013).cbr",
+		"/comics/Saga/Saga 015 (2013).cbr",
+		"/comics/Saga Old/Saga #14 (1999).cbr",
+		"/comics/Batman_Superman/002.cbr",
+		"/comics/Batman_Superman/003.cbr",
+	}
+	require.Equal(t, []string{
+		"/comics/Batman_Superman/002.cbr",
+		"/comics/Saga/Saga v02 #014 (2013).cbr",
+		"/comics/Monstress/Monstress 001.cbr",
+		"/comics/Monstress/Monstress 002.cbr",
+	}, list.filter(names))
+	require.Equal(t, []readingBook{{series: "Paper Girls", number: "1"}}, list.missing(names))
+}
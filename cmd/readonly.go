@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"io/fs"
+	"path"
+	"syscall"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// errReadOnlySource is what files fail with when the folder they are in
+// can't be written to and there is no --output-dir to put the cbz in.
+var errReadOnlySource = errors.New("the source is read-only, convert it with --output-dir to keep the original and write the cbz elsewhere")
+
+// isReadOnlyError matches errors for changing files on a read-only
+// filesystem or without the permission to.
+func isReadOnlyError(err error) bool {
+	return errors.Is(err, syscall.EROFS) || errors.Is(err, fs.ErrPermission)
+}
+
+// checkSource makes sure the original of cbrFile can be dealt with before
+// any work is done on it. When its folder is read-only the file is copied
+// into outputDir and the original kept, and without an outputDir it fails
+// right away.
+func (c *converter) checkSource(cbrFile string) error {
+	if c.keep && c.outputDir != "" {
+		// nothing gets written next to it or removed
+		return nil
+	}
+	if !c.readOnlySource(cbrFile) {
+		return nil
+	}
+	if c.outputDir == "" {
+		return errors.Wrapf(errReadOnlySource, "can't write to %s", path.Dir(cbrFile))
+	}
+	c.warn(cbr2cbz.CodeSourceReadOnly, cbrFile, "%s is on a read-only source, keeping it and writing the cbz into %s", cbrFile, c.outputDir)
+	return nil
+}
+
+// readOnlySource reports whether the folder cbrFile is in can't be written
+// to, by creating and removing a hidden file there the first time a file
+// from it is converted. Anything but a read-only error counts as writable
+// and is left to fail the conversion itself. Only local disks are probed,
+// on remote ones it would cost an upload per folder; those are only caught
+// when removing the original.
+func (c *converter) readOnlySource(cbrFile string) bool {
+	if _, ok := c.fs.(interface{ ToOSPath(string) (string, error) }); !ok {
+		return false
+	}
+	dir := path.Dir(pathToFsPath(cbrFile))
+	c.readOnlyMu.Lock()
+	defer c.readOnlyMu.Unlock()
+	if readOnly, ok := c.readOnlyDirs[dir]; ok {
+		return readOnly
+	}
+	if c.readOnlyDirs == nil {
+		c.readOnlyDirs = map[string]bool{}
+	}
+
+	probe := tempName(path.Join(dir, "cbr2cbz-probe"))
+	f, err := hackpadfs.Create(c.fs, probe)
+	if err == nil {
+		f.Close()
+		err = hackpadfs.Remove(c.fs, probe)
+	}
+	c.readOnlyDirs[dir] = isReadOnlyError(err)
+	return c.readOnlyDirs[dir]
+}
+
+// keeps reports whether the original of cbrFile stays where it is after
+// converting, because of --keep or because it can't be removed.
+func (c *converter) keeps(cbrFile string) bool {
+	if c.keep {
+		return true
+	}
+	c.readOnlyMu.Lock()
+	defer c.readOnlyMu.Unlock()
+	return c.readOnlyDirs[path.Dir(pathToFsPath(cbrFile))]
+}
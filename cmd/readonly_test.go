@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/hack-pad/hackpadfs/mem"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+// readOnlyFS fails the writes denied says no to, like a local read-only
+// mount or a folder without write permission.
+type readOnlyFS struct {
+	*mem.FS
+	denied func(op string, name string) bool
+}
+
+func (r *readOnlyFS) OpenFile(name string, flag int, perm hackpadfs.FileMode) (hackpadfs.File, error) {
+	if flag&(hackpadfs.FlagWriteOnly|hackpadfs.FlagReadWrite|hackpadfs.FlagCreate) != 0 && r.denied("open", name) {
+		return nil, &hackpadfs.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	}
+	return r.FS.OpenFile(name, flag, perm)
+}
+
+func (r *readOnlyFS) ToOSPath(name string) (string, error) {
+	return "/" + name, nil
+}
+
+func (r *readOnlyFS) Remove(name string) error {
+	if r.denied("remove", name) {
+		return &hackpadfs.PathError{Op: "remove", Path: name, Err: syscall.EACCES}
+	}
+	return r.FS.Remove(name)
+}
+
+func (r *readOnlyFS) Rename(oldname, newname string) error {
+	if r.denied("rename", oldname) || r.denied("rename", newname) {
+		return &hackpadfs.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EROFS}
+	}
+	return r.FS.Rename(oldname, newname)
+}
+
+func Test_readOnlySource(t *testing.T) {
+	underLibrary := func(_ string, name string) bool { return strings.HasPrefix(name, "library/") }
+	tests := []struct {
+		name      string
+		outputDir string
+		denied    func(op string, name string) bool
+		converted []string
+		code      string
+		warning   bool
+	}{
+		{"copied into output dir", "/out", underLibrary, []string{"/out/a.cbz"}, "", true},
+		{"no output dir", "", underLibrary, []string{}, cbr2cbz.CodeReadOnlySource, false},
+		{
+			"only removing fails", "/out",
+			func(op string, name string) bool { return op == "remove" && strings.HasSuffix(name, ".cbr") },
+			[]string{"/out/a.cbz"}, "", true,
+		},
+		{"writable", "/out", func(string, string) bool { return false }, []string{"/out/a.cbz"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, err := setupFS(t, filenameBytes{"library/a.cbr": realCBRContents})
+			require.NoError(t, err)
+			events := &bytes.Buffer{}
+			c := &converter{fs: &readOnlyFS{FS: fsys.(*mem.FS), denied: tt.denied}, logger: testLogger{t}, outputDir: tt.outputDir, events: newEventLog(events)}
+			require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+			require.Equal(t, tt.converted, c.converted)
+			require.Equal(t, tt.code, errorCode(c.failed["/library/a.cbr"]))
+			require.Equal(t, tt.warning, strings.Contains(events.String(), `"code":"`+cbr2cbz.CodeSourceReadOnly+`"`))
+			if len(tt.converted) > 0 {
+				_, err = hackpadfs.Stat(fsys, "out/a.cbz")
+				require.NoError(t, err)
+			}
+			_, err = hackpadfs.Stat(fsys, "library/a.cbr")
+			require.Equal(t, tt.name == "writable", err != nil, "original removed")
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+var writeReceipts bool
+
+// receiptSuffix replaces the .cbz of a conversion's output for its receipt.
+const receiptSuffix = ".converted.json"
+
+// conversionReceipt is the provenance of a cbz, kept next to it so it goes
+// wherever the file does.
+type conversionReceipt struct {
+	// Source is the name of the file converted, without its folder
+	Source       string `json:"source"`
+	SourceSize   int64  `json:"source_size"`
+	SourceSHA256 string `json:"source_sha256"`
+	Output       string `json:"output"`
+	OutputSHA256 string `json:"output_sha256"`
+	// Converted is when the conversion finished
+	Converted time.Time       `json:"converted"`
+	Version   string          `json:"version"`
+	Options   cbr2cbz.Options `json:"options"`
+	// Fingerprint is Options.Fingerprint, as logged
+	Fingerprint string `json:"options_fingerprint"`
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+	// Verified is whether every entry of the output was read back and
+	// checked, which --verify does
+	Verified bool `json:"verified"`
+}
+
+// receiptPath is where the receipt of cbzFile goes.
+func receiptPath(cbzFile string) string {
+	return strings.TrimSuffix(cbzFile, filepath.Ext(cbzFile)) + receiptSuffix
+}
+
+// writeReceipt saves the receipt of converting cbrFile, whose checksum was
+// sourceSum, to cbzFile. Conversions split into chapters made more than one
+// output and get none.
+func (c *converter) writeReceipt(cbrFile string, cbzFile string, sourceSum string, sourceSize int64, took time.Duration) error {
+	outputSum, err := fileSum(c.fs, pathToFsPath(cbzFile))
+	if errors.Is(err, hackpadfs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	opts := c.packer().Options()
+	data, err := json.MarshalIndent(conversionReceipt{
+		Source:       filepath.Base(cbrFile),
+		SourceSize:   sourceSize,
+		SourceSHA256: sourceSum,
+		Output:       filepath.Base(cbzFile),
+		OutputSHA256: outputSum,
+		Converted:    time.Now().UTC(),
+		Version:      buildVersion,
+		Options:      opts,
+		Fingerprint:  opts.Fingerprint(),
+		Duration:     took.Seconds(),
+		Verified:     c.verify,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return hackpadfs.WriteFullFile(c.fs, pathToFsPath(receiptPath(cbzFile)), append(data, '\n'), 0644)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeReceipt(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga 001.cbr": realCBRContents,
+		"library/is-zip.cbr":   notrealCBRContents,
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, receipts: true, verify: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Len(t, c.converted, 2)
+
+	data, err := fs.ReadFile(fsys, "library/Saga 001.converted.json")
+	require.NoError(t, err)
+	receipt := conversionReceipt{}
+	require.NoError(t, json.Unmarshal(data, &receipt))
+	sum := sha256.Sum256(realCBRContents)
+	require.Equal(t, "Saga 001.cbr", receipt.Source)
+	require.Equal(t, hex.EncodeToString(sum[:]), receipt.SourceSHA256)
+	require.Equal(t, int64(len(realCBRContents)), receipt.SourceSize)
+	require.Equal(t, "Saga 001.cbz", receipt.Output)
+	output, err := fileSum(fsys, "library/Saga 001.cbz")
+	require.NoError(t, err)
+	require.Equal(t, output, receipt.OutputSHA256)
+	require.Equal(t, c.packer().Options().Fingerprint(), receipt.Fingerprint)
+	require.True(t, receipt.Verified)
+
+	// repacked, so the cbz has a checksum of its own
+	data, err = fs.ReadFile(fsys, "library/is-zip.converted.json")
+	require.NoError(t, err)
+	receipt = conversionReceipt{}
+	require.NoError(t, json.Unmarshal(data, &receipt))
+	require.NotEqual(t, receipt.SourceSHA256, receipt.OutputSHA256)
+}
+
+func Test_receiptPath(t *testing.T) {
+	require.Equal(t, "/library/Saga 001.converted.json", receiptPath("/library/Saga 001.cbz"))
+}
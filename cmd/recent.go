@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	recentFileName string
+	recentFor      time.Duration
+)
+
+func defaultRecentPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "watch-recent.json")
+}
+
+// recentFile is how a file watch tried went, and what it looked like then.
+type recentFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Status is converted or failed
+	Status string `json:"status"`
+	// Code is the error code of failed files, see codes.go
+	Code string    `json:"code,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+// recentIndex is the files watch tried lately, saved across restarts so a
+// file that is still there unchanged, or is touched again without changing,
+// isn't tried again until keep has passed. A nil recentIndex remembers
+// nothing.
+type recentIndex struct {
+	path    string
+	keep    time.Duration
+	entries map[string]recentFile
+}
+
+// loadRecentIndex reads the index at path, empty if there is none yet. An
+// index that can't be read is started over, with the error returned next to
+// it.
+func loadRecentIndex(path string, keep time.Duration, now time.Time) (*recentIndex, error) {
+	if path == "" || keep <= 0 {
+		return nil, nil
+	}
+	r := &recentIndex{path: path, keep: keep, entries: map[string]recentFile{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &r.entries)
+	}
+	if err != nil {
+		r.entries = map[string]recentFile{}
+		return r, errors.Wrapf(err, "reading %s", path)
+	}
+	r.prune(now)
+	return r, nil
+}
+
+func (r *recentIndex) prune(now time.Time) {
+	for name, e := range r.entries {
+		if now.Sub(e.At) >= r.keep {
+			delete(r.entries, name)
+		}
+	}
+}
+
+// seen is how name went if it was tried lately with the same size and
+// modification time.
+func (r *recentIndex) seen(name string, size int64, modTime time.Time, now time.Time) (recentFile, bool) {
+	if r == nil {
+		return recentFile{}, false
+	}
+	e, ok := r.entries[name]
+	if !ok || e.Size != size || !e.ModTime.Equal(modTime) || now.Sub(e.At) >= r.keep {
+		return recentFile{}, false
+	}
+	return e, true
+}
+
+// add records how name went and saves the index.
+func (r *recentIndex) add(name string, e recentFile) error {
+	if r == nil {
+		return nil
+	}
+	r.entries[name] = e
+	r.prune(e.At)
+	data, err := json.Marshal(r.entries)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(r.path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".part"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err == nil {
+		err = os.Rename(tmp, r.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
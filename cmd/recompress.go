@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// parseRecompress parses a --recompress value, a format optionally followed
+// by a quality like jpeg:85. Without a quality each format's own default is
+// used.
+func parseRecompress(value string) (cbr2cbz.ImageOptions, error) {
+	format, quality, hasQuality := strings.Cut(strings.ToLower(value), ":")
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	opts := cbr2cbz.ImageOptions{Format: format}
+	if hasQuality {
+		q, err := strconv.Atoi(quality)
+		if err != nil || q < 1 {
+			return cbr2cbz.ImageOptions{}, errors.Errorf("quality must be between 1 and 100, got %q", quality)
+		}
+		opts.Quality = q
+	}
+	return opts, opts.Validate()
+}
+
+// entryErrorModes are the values --entry-errors accepts.
+var entryErrorModes = map[string]bool{"fail": true, "skip": true}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/gif"
+	"io"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+)
+
+func Test_parseRecompress(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    cbr2cbz.ImageOptions
+		wantErr bool
+	}{
+		{value: "jpeg:85", want: cbr2cbz.ImageOptions{Format: "jpeg", Quality: 85}},
+		{value: "JPG", want: cbr2cbz.ImageOptions{Format: "jpeg"}},
+		{value: "webp", want: cbr2cbz.ImageOptions{Format: "webp"}},
+		{value: "avif:50", want: cbr2cbz.ImageOptions{Format: "avif", Quality: 50}},
+		{value: "jpeg:0", wantErr: true},
+		{value: "jpeg:high", wantErr: true},
+		{value: "tiff", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRecompress(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_convertRecompress(t *testing.T) {
+	page := string(makePNG(t, 64, 96))
+	fsys, err := setupFS(t, filenameBytes{
+		"library/test.cbt": makeTar(t, map[string]string{
+			"test/001.png":  page,
+			"test/002.png":  page,
+			"test/002.webp": page,
+			"test/info.txt": "kept",
+		}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{KeepFiles: []string{"*.txt"}, Images: &cbr2cbz.ImageOptions{Format: "webp", Quality: 60}}, cbr2cbz.Limits{}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	zr, f, err := openZip(fsys, "library/test.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+
+	formats := map[string]string{}
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		_, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			format = ""
+		}
+		formats[zf.Name] = format
+	}
+
+	require.Equal(t, map[string]string{
+		"test/001.webp": "webp",
+		// 002.webp is taken, so 002.png is left alone
+		"test/002.png":  "png",
+		"test/002.webp": "webp",
+		"test/info.txt": "",
+	}, formats)
+}
+
+func Test_convertDownscale(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/test.cbt": makeTar(t, map[string]string{
+			"test/001.png": string(makePNG(t, 400, 600)),
+			"test/002.png": string(makePNG(t, 100, 150)),
+			"test/003.gif": string(makePNG(t, 400, 600)),
+		}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{Images: &cbr2cbz.ImageOptions{MaxWidth: 200, MaxHeight: 200}}, cbr2cbz.Limits{}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	zr, f, err := openZip(fsys, "library/test.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+
+	sizes := map[string]image.Point{}
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		require.NoError(t, err)
+		cfg, format, err := image.DecodeConfig(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.Equal(t, "png", format)
+		sizes[zf.Name] = image.Pt(cfg.Width, cfg.Height)
+	}
+	require.Equal(t, map[string]image.Point{
+		"test/001.png": image.Pt(133, 200),
+		"test/002.png": image.Pt(100, 150),
+		// can't write gif, so it becomes a png
+		"test/003.png": image.Pt(133, 200),
+	}, sizes)
+}
+
+func Test_convertNormalizeFormat(t *testing.T) {
+	img, _, err := image.Decode(bytes.NewReader(makePNG(t, 40, 60)))
+	require.NoError(t, err)
+	gifPage, bmpPage := &bytes.Buffer{}, &bytes.Buffer{}
+	require.NoError(t, gif.Encode(gifPage, img, nil))
+	require.NoError(t, bmp.Encode(bmpPage, img))
+	pngPage := makePNG(t, 40, 60)
+
+	fsys, err := setupFS(t, filenameBytes{
+		"library/test.cbt": makeTar(t, map[string]string{
+			"test/001.png": string(pngPage),
+			"test/002.gif": gifPage.String(),
+			"test/003.bmp": bmpPage.String(),
+		}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{Images: &cbr2cbz.ImageOptions{Normalize: "png"}}, cbr2cbz.Limits{}))
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	zr, f, err := openZip(fsys, "library/test.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	pages := map[string][]byte{}
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		_, format, err := image.DecodeConfig(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, "png", format, zf.Name)
+		pages[zf.Name] = data
+	}
+	require.Len(t, pages, 3)
+	require.Contains(t, pages, "test/002.png")
+	require.Contains(t, pages, "test/003.png")
+	// the page that was a png already is untouched
+	require.Equal(t, pngPage, pages["test/001.png"])
+}
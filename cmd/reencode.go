@@ -0,0 +1,371 @@
+package cmd
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reencodeImages   cbr2cbz.ImageOptions
+	reencodeProfile  string
+	reencodeMetrics  bool
+	reencodePassword string
+)
+
+// reencodeCmd represents the reencode command
+var reencodeCmd = &cobra.Command{
+	Use:   "reencode [paths...]",
+	Short: "Runs the pages of existing cbz files through the image pipeline, in place",
+	Long: `Runs the pages of existing cbz files through the image pipeline, in place.
+
+Each archive is rewritten to a temporary file and verified before it replaces the original.
+
+Password protected archives are decrypted with --password or the matching entry of
+"passwords" in the config file, and written back without a password. With only
+passwords and no image options, just the protected archives are rewritten.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		if err := applyImageProfile(cmd, reencodeProfile, &reencodeImages); err != nil {
+			logger.Fatal(err)
+		}
+		passwords := zipPasswords{fallback: reencodePassword}
+		if loadedConfig != nil {
+			passwords.rules = loadedConfig.passwords
+		}
+		if !reencodeImages.Enabled() && !passwords.any() {
+			logger.Fatal("nothing to do, give at least one of --profile, --format, --max-width, --max-height, --strips, --auto-rotate, --grayscale, --contrast, --gamma or --password")
+		}
+		if err := reencodeImages.Validate(); err != nil {
+			logger.Fatal(err)
+		}
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fsys, args, err = localArgs(fsys, args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		r := &reencoder{
+			fs:        fsys,
+			logger:    logger,
+			images:    reencodeImages,
+			metrics:   reencodeMetrics,
+			passwords: passwords,
+		}
+		err = r.run(cmd.Context(), args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reencodeCmd)
+
+	addImageFlags(reencodeCmd, &reencodeImages)
+	reencodeCmd.Flags().BoolVar(&reencodeMetrics, "metrics", false, "measure SSIM/PSNR of every re-encoded page against the original (slow)")
+	reencodeCmd.Flags().StringVar(&reencodeProfile, "profile", "", "image profile to apply (kobo, kindle, tablet, eink)")
+	reencodeCmd.RegisterFlagCompletionFunc("profile", completeImageProfiles)
+	reencodeCmd.Flags().StringVar(&reencodePassword, "password", "", "password for protected archives not matched by passwords in the config file")
+}
+
+// addImageFlags registers the image pipeline flags on cmd, storing them in opts.
+func addImageFlags(cmd *cobra.Command, opts *cbr2cbz.ImageOptions) {
+	cmd.Flags().StringVar(&opts.Format, "format", "", "re-encode pages as jpeg, png, webp or avif, keeps each page's format if unset")
+	cmd.Flags().IntVar(&opts.Quality, "quality", 85, "quality for lossy formats, 1-100")
+	cmd.Flags().IntVar(&opts.MaxWidth, "max-width", 0, "downscale pages wider than this")
+	cmd.Flags().IntVar(&opts.MaxHeight, "max-height", 0, "downscale pages taller than this")
+	cmd.Flags().StringVar(&opts.Strips, "strips", "", "slice webtoon strips into screen sized pages (slice) or join their pages into long strips (stitch)")
+	cmd.Flags().BoolVar(&opts.AutoRotate, "auto-rotate", false, "turn pages upright by their EXIF orientation")
+	cmd.Flags().BoolVar(&opts.Grayscale, "grayscale", false, "drop the colour of pages")
+	cmd.Flags().Float64Var(&opts.Contrast, "contrast", 1, "stretch levels away from the middle grey by this factor, 1 leaves them")
+	cmd.Flags().Float64Var(&opts.Gamma, "gamma", 1, "darken midtones above 1 and lighten them below, 1 leaves them")
+}
+
+type reencoder struct {
+	fs        hackpadfs.FS
+	logger    logger
+	images    cbr2cbz.ImageOptions
+	entries   cbr2cbz.EntryFilter
+	metrics   bool
+	passwords zipPasswords
+}
+
+func (r *reencoder) run(ctx context.Context, paths []string) error {
+	files, err := findCBZs(r.fs, paths)
+	if err != nil {
+		return err
+	}
+	return r.reencodeAll(ctx, files)
+}
+
+// reencodeAll re-encodes files, cbz files found already.
+func (r *reencoder) reencodeAll(ctx context.Context, files []string) error {
+	failed := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := r.reencode(file)
+		if err != nil {
+			r.logger.Printf("Error re-encoding %s - Skipping...%s\n", file, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+func (r *reencoder) reencode(file string) error {
+	var (
+		before, after uint64
+		quality       qualityStats
+	)
+
+	password := r.passwords.lookup(file)
+	if !r.images.Enabled() {
+		zr, f, err := openZip(r.fs, pathToFsPath(file))
+		if err != nil {
+			return err
+		}
+		locked := hasEncrypted(zr)
+		f.Close()
+		if !locked {
+			return nil
+		}
+	}
+
+	err := rewriteZip(r.fs, pathToFsPath(file), func(zr *zip.Reader, zw *zip.Writer) error {
+		names := map[string]bool{}
+		for _, f := range zr.File {
+			names[strings.ToLower(f.Name)] = true
+		}
+
+		arranged, err := r.arrangeStrips(zr, password)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range zr.File {
+			before += f.CompressedSize64
+			if replacement, ok := arranged[f.Name]; ok {
+				for _, page := range replacement {
+					name, out, err := r.images.Process(page.Name, page.Data)
+					if err != nil {
+						return err
+					}
+					w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: f.Modified})
+					if err != nil {
+						return errors.Wrapf(err, "writing %s", name)
+					}
+					if _, err := w.Write(out); err != nil {
+						return errors.Wrapf(err, "writing %s", name)
+					}
+					after += uint64(len(out))
+				}
+				continue
+			}
+			if f.FileInfo().IsDir() || r.entries.Classify(f.Name) != cbr2cbz.EntryPage || !r.images.Enabled() {
+				n, err := keepZipEntry(zw, f, password)
+				if err != nil {
+					return err
+				}
+				after += n
+				continue
+			}
+
+			data, err := readZipEntry(f, password)
+			if err != nil {
+				return err
+			}
+
+			name, out, err := r.images.Process(f.Name, data)
+			if err != nil {
+				return err
+			}
+			if name != f.Name && names[strings.ToLower(name)] {
+				// would clobber another entry, leave this one as it was
+				name, out = f.Name, data
+			}
+			if name == f.Name && len(out) == len(data) {
+				n, err := keepZipEntry(zw, f, password)
+				if err != nil {
+					return err
+				}
+				after += n
+				continue
+			}
+
+			if r.metrics {
+				q, err := comparePages(data, out)
+				if err != nil {
+					return errors.Wrapf(err, "measuring %s", f.Name)
+				}
+				quality.add(q, len(data), len(out))
+			}
+
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: f.Modified})
+			if err != nil {
+				return errors.Wrapf(err, "writing %s", name)
+			}
+			if _, err := w.Write(out); err != nil {
+				return errors.Wrapf(err, "writing %s", name)
+			}
+			after += uint64(len(out))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.logger.Printf("Re-encoded %s: %s -> %s\n", file, formatBytes(before), formatBytes(after))
+	if r.metrics {
+		r.logger.Printf("Quality for %s: %s\n", file, quality)
+	}
+	return nil
+}
+
+// arrangeStrips slices or stitches the pages of zr when --strips is set. The
+// result maps the first page to every page that replaces the old ones, and
+// the other old pages to nothing; it is empty when the layout stays as it is.
+func (r *reencoder) arrangeStrips(zr *zip.Reader, password string) (map[string][]cbr2cbz.PageImage, error) {
+	if r.images.Strips == "" {
+		return nil, nil
+	}
+
+	files := map[string]*zip.File{}
+	names := []string{}
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() && r.entries.Classify(f.Name) == cbr2cbz.EntryPage {
+			files[f.Name] = f
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+
+	pages := make([]cbr2cbz.PageImage, len(names))
+	for i, name := range names {
+		data, err := readZipEntry(files[name], password)
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = cbr2cbz.PageImage{Name: name, Data: data}
+	}
+
+	out, err := r.images.ArrangeStrips(pages)
+	if err != nil {
+		return nil, err
+	}
+	changed := len(out) != len(pages)
+	for i := 0; !changed && i < len(out); i++ {
+		changed = out[i].Name != pages[i].Name
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	arranged := map[string][]cbr2cbz.PageImage{}
+	for _, name := range names {
+		arranged[name] = nil
+	}
+	arranged[names[0]] = out
+	return arranged, nil
+}
+
+// keepZipEntry writes f to w unchanged, decrypting it first if it needs a
+// password. It returns about how much space the entry takes up in w.
+func keepZipEntry(w *zip.Writer, f *zip.File, password string) (uint64, error) {
+	if !isEncrypted(f) {
+		return f.CompressedSize64, copyZipEntry(w, f)
+	}
+
+	data, err := readZipEntry(f, password)
+	if err != nil {
+		return 0, err
+	}
+	header := &zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.Modified, Comment: f.Comment}
+	if f.FileInfo().IsDir() {
+		header.Method = zip.Store
+	}
+	dst, err := w.CreateHeader(header)
+	if err != nil {
+		return 0, errors.Wrapf(err, "writing %s", f.Name)
+	}
+	_, err = dst.Write(data)
+	// recompressed it ends up about the size it was encrypted
+	return f.CompressedSize64, errors.Wrapf(err, "writing %s", f.Name)
+}
+
+func readZipEntry(f *zip.File, password string) ([]byte, error) {
+	rc, err := openZipEntry(f, password)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", f.Name)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", f.Name)
+	}
+	return data, nil
+}
+
+// applyImageProfile fills opts from the named profile, leaving alone any
+// image flag the user set explicitly on cmd.
+func applyImageProfile(cmd *cobra.Command, name string, opts *cbr2cbz.ImageOptions) error {
+	if name == "" {
+		return nil
+	}
+
+	profile, ok := cbr2cbz.ImageProfiles[name]
+	if !ok {
+		return errors.Errorf("unknown profile %q", name)
+	}
+
+	flags := cmd.Flags()
+	if !flags.Changed("format") {
+		opts.Format = profile.Format
+	}
+	if !flags.Changed("quality") {
+		opts.Quality = profile.Quality
+	}
+	if !flags.Changed("max-width") {
+		opts.MaxWidth = profile.MaxWidth
+	}
+	if !flags.Changed("max-height") {
+		opts.MaxHeight = profile.MaxHeight
+	}
+	if flags.Lookup("strips") != nil && !flags.Changed("strips") {
+		opts.Strips = profile.Strips
+	}
+	if !flags.Changed("grayscale") {
+		opts.Grayscale = profile.Grayscale
+	}
+	if !flags.Changed("contrast") {
+		opts.Contrast = profile.Contrast
+	}
+	if !flags.Changed("gamma") {
+		opts.Gamma = profile.Gamma
+	}
+	return nil
+}
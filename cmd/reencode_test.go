@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func makePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func Test_reencoder(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/test.cbz": makeZip(t, map[string]string{
+			"001.png":       string(makePNG(t, 64, 64)),
+			"ComicInfo.xml": "<ComicInfo/>",
+		}),
+	})
+	require.NoError(t, err)
+
+	r := &reencoder{fs: fsys, logger: testLogger{t}, images: cbr2cbz.ImageOptions{Format: "jpeg"}}
+	require.NoError(t, r.run(context.Background(), []string{"/comics"}))
+
+	zr, f, err := openZip(fsys, "comics/test.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+
+	names := []string{}
+	for _, entry := range zr.File {
+		names = append(names, entry.Name)
+	}
+	require.ElementsMatch(t, []string{"001.jpg", "ComicInfo.xml"}, names)
+}
+
+func Test_samplePages(t *testing.T) {
+	pages := []string{"1", "2", "3", "4", "5", "6", "7"}
+	require.Equal(t, []string{"1", "4", "7"}, samplePages(pages, 3))
+	require.Equal(t, pages, samplePages(pages, 10))
+}
+
+func Test_previewer(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/test.cbz": makeZip(t, map[string]string{
+			"001.png": string(makePNG(t, 64, 64)),
+			"002.png": string(makePNG(t, 64, 64)),
+		}),
+	})
+	require.NoError(t, err)
+
+	p := &previewer{fs: fsys, logger: testLogger{t}, images: cbr2cbz.ImageProfiles["kobo"]}
+	require.NoError(t, p.preview(context.Background(), "/comics/test.cbz", "/samples", 1))
+
+	_, err = fs.Stat(fsys, "samples/test-001.jpg")
+	require.NoError(t, err)
+}
+
+func Test_comparePages(t *testing.T) {
+	page := makePNG(t, 64, 64)
+
+	same, err := comparePages(page, page)
+	require.NoError(t, err)
+	require.InDelta(t, 1, same.SSIM, 0.0001)
+	require.Equal(t, 100.0, same.PSNR)
+
+	_, lossy, err := cbr2cbz.ImageOptions{Format: "jpeg", Quality: 10}.Process("001.png", page)
+	require.NoError(t, err)
+	worse, err := comparePages(page, lossy)
+	require.NoError(t, err)
+	require.Less(t, worse.SSIM, same.SSIM)
+	require.Less(t, worse.PSNR, 60.0)
+}
+
+func Test_reencoder_strips(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/webtoon.cbz": makeZip(t, map[string]string{
+			"001.png":       string(makePNG(t, 100, 600)),
+			"ComicInfo.xml": "<ComicInfo/>",
+		}),
+	})
+	require.NoError(t, err)
+
+	r := &reencoder{fs: fsys, logger: testLogger{t}, images: cbr2cbz.ImageOptions{Strips: "slice", MaxWidth: 100, MaxHeight: 200}}
+	require.NoError(t, r.run(context.Background(), []string{"/comics"}))
+
+	zr, f, err := openZip(fsys, "comics/webtoon.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	names := []string{}
+	for _, entry := range zr.File {
+		names = append(names, entry.Name)
+	}
+	require.ElementsMatch(t, []string{"001-1.png", "001-2.png", "001-3.png", "ComicInfo.xml"}, names)
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// reloadRequest asks a long running mode to read its config file again.
+// The outcome is sent back on it, unless it is nil.
+type reloadRequest chan error
+
+// reloadOnSignal sends a reloadRequest on reloads for every SIGHUP until ctx
+// is done.
+func reloadOnSignal(ctx context.Context, reloads chan<- reloadRequest) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case reloads <- nil:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reloadHandler serves POST /reload, answering once the reload is done.
+func reloadHandler(ctx context.Context, reloads chan<- reloadRequest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		reply := make(reloadRequest, 1)
+		select {
+		case reloads <- reply:
+		case <-ctx.Done():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		var err error
+		select {
+		case err = <-reply:
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write([]byte("reloaded\n"))
+	}
+}
+
+// flagSnapshot is what a reload may change, for putting it back when the
+// new configuration turns out to be bad.
+type flagSnapshot struct {
+	values  map[*pflag.Flag][]string
+	changed map[*pflag.Flag]bool
+	sources map[string]string
+}
+
+func snapshotFlags(sets ...*pflag.FlagSet) *flagSnapshot {
+	s := &flagSnapshot{values: map[*pflag.Flag][]string{}, changed: map[*pflag.Flag]bool{}, sources: map[string]string{}}
+	for _, set := range sets {
+		set.VisitAll(func(f *pflag.Flag) {
+			if slice, ok := f.Value.(pflag.SliceValue); ok {
+				s.values[f] = slice.GetSlice()
+			} else {
+				s.values[f] = []string{f.Value.String()}
+			}
+			s.changed[f] = f.Changed
+		})
+	}
+	for name, source := range optionSources {
+		s.sources[name] = source
+	}
+	return s
+}
+
+func (s *flagSnapshot) restore() {
+	for f, values := range s.values {
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			slice.Replace(values)
+		} else {
+			f.Value.Set(values[0])
+		}
+		f.Changed = s.changed[f]
+	}
+	optionSources = map[string]string{}
+	for name, source := range s.sources {
+		optionSources[name] = source
+	}
+}
+
+// resetFlag puts f back to its default.
+func resetFlag(f *pflag.Flag) error {
+	f.Changed = false
+	delete(optionSources, f.Name)
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		values := []string{}
+		if def := strings.Trim(f.DefValue, "[]"); def != "" {
+			values = strings.Split(def, ",")
+		}
+		return slice.Replace(values)
+	}
+	return f.Value.Set(f.DefValue)
+}
+
+// reloadConfigFile reads the config file again and applies it in place of
+// the one loaded when the command started. Flags given on the command line
+// keep their value; those the old config file or preset set are reset
+// first, so removing a key takes effect too. A bad file is reported before
+// any flag changes, take a snapshotFlags to undo the rest.
+func reloadConfigFile(sets ...*pflag.FlagSet) (*configFile, error) {
+	explicit := rootCmd.PersistentFlags().Changed("config")
+	cfg, err := loadConfig(configFileName, explicit)
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		if problems := cfg.validate(sets...); len(problems) > 0 {
+			return nil, errors.Wrap(problems[0], "invalid config, see cbr2cbz config validate")
+		}
+	}
+
+	for _, set := range sets {
+		set.VisitAll(func(f *pflag.Flag) {
+			source := optionSources[f.Name]
+			if err == nil && (source == "config" || source == "profile" || source == "preset") {
+				err = resetFlag(f)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg != nil {
+		err = cfg.apply(sets...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cfg, applyPreset(sets...)
+}
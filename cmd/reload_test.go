@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_reloadConfigFile(t *testing.T) {
+	oldName := configFileName
+	optionSources = map[string]string{}
+	t.Cleanup(func() {
+		configFileName = oldName
+		optionSources = map[string]string{}
+	})
+
+	configFileName = writeConfig(t, "jobs: 4\nkeep-files: ['*.nfo']\nclaim-dir: /config\n")
+	cfg, err := loadConfig(configFileName, true)
+	require.NoError(t, err)
+	set := testConfigFlags()
+	require.NoError(t, set.Parse([]string{"--claim-dir", "/flag"}))
+	require.NoError(t, cfg.apply(set))
+
+	// jobs and keep-files are gone from the file, sandbox is new
+	require.NoError(t, os.WriteFile(configFileName, []byte("sandbox: true\nclaim-dir: /config\npaths: [/library]\n"), 0644))
+	cfg, err = reloadConfigFile(set)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/library"}, cfg.paths)
+	jobs, _ := set.GetInt("jobs")
+	require.Equal(t, 1, jobs)
+	keepFiles, _ := set.GetStringSlice("keep-files")
+	require.Equal(t, []string{"ComicInfo.xml"}, keepFiles)
+	sandbox, _ := set.GetBool("sandbox")
+	require.True(t, sandbox)
+	claimDir, _ := set.GetString("claim-dir")
+	require.Equal(t, "/flag", claimDir, "flags given on the command line win")
+	require.Equal(t, map[string]string{"sandbox": "config"}, optionSources)
+
+	// a bad file changes nothing
+	require.NoError(t, os.WriteFile(configFileName, []byte("jobs: 4\nsandbox: maybe\n"), 0644))
+	_, err = reloadConfigFile(set)
+	require.ErrorContains(t, err, "invalid config")
+	sandbox, _ = set.GetBool("sandbox")
+	require.True(t, sandbox)
+	jobs, _ = set.GetInt("jobs")
+	require.Equal(t, 1, jobs)
+}
+
+func Test_flagSnapshot(t *testing.T) {
+	t.Cleanup(func() { optionSources = map[string]string{} })
+
+	set := testConfigFlags()
+	require.NoError(t, set.Parse([]string{"--jobs", "3"}))
+	snapshot := snapshotFlags(set)
+	require.NoError(t, set.Set("keep-files", "*.nfo,*.txt"))
+	require.NoError(t, resetFlag(set.Lookup("jobs")))
+	optionSources["keep-files"] = "config"
+
+	snapshot.restore()
+	jobs, _ := set.GetInt("jobs")
+	require.Equal(t, 3, jobs)
+	require.True(t, set.Lookup("jobs").Changed)
+	keepFiles, _ := set.GetStringSlice("keep-files")
+	require.Equal(t, []string{"ComicInfo.xml"}, keepFiles)
+	require.Empty(t, optionSources)
+}
+
+func Test_reloadHandler(t *testing.T) {
+	reloads := make(chan reloadRequest)
+	go func() {
+		(<-reloads) <- nil
+		(<-reloads) <- errors.New("bad config")
+	}()
+	server := httptest.NewServer(reloadHandler(context.Background(), reloads))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Post(server.URL, "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	resp, err = http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func Test_watcherReload(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	newC := func() *converter {
+		return &converter{fs: hackpados.NewFS(), logger: testLogger{t}}
+	}
+	w := newWatcher(newC(), 0)
+	reloads := make(chan reloadRequest)
+	w.reloads = reloads
+	var next *watchSetup
+	w.reload = func() (*watchSetup, error) {
+		if next == nil {
+			return nil, errors.New("bad config")
+		}
+		return next, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := make(chan error)
+	go func() {
+		ran <- w.run(ctx, context.Background(), []string{first}, 10*time.Millisecond, newHealthState(0))
+	}()
+	reload := func() error {
+		reply := make(reloadRequest, 1)
+		reloads <- reply
+		return <-reply
+	}
+
+	require.ErrorContains(t, reload(), "bad config")
+
+	next = &watchSetup{c: newC(), roots: []string{filepath.Join(second, "missing")}}
+	require.ErrorContains(t, reload(), "watching")
+
+	next = &watchSetup{c: newC(), roots: []string{second}}
+	require.NoError(t, reload())
+	require.NoError(t, os.WriteFile(filepath.Join(second, "is-zip.cbr"), notrealCBRContents, 0644))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(second, "is-zip.cbz"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// first isn't watched anymore
+	require.NoError(t, os.WriteFile(filepath.Join(first, "is-zip.cbr"), notrealCBRContents, 0644))
+	time.Sleep(100 * time.Millisecond)
+	_, err := os.Stat(filepath.Join(first, "is-zip.cbz"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	cancel()
+	require.NoError(t, <-ran)
+}
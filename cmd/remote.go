@@ -0,0 +1,402 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// remoteHeadSize is how much of a remote file is fetched up front, enough
+// for identifying archives without downloading all of them.
+const remoteHeadSize = 1 << 20
+
+// remoteSchemes are the urls libraries that aren't on a local disk can be
+// given as.
+var remoteSchemes = []string{"s3", "sftp", "webdav", "webdavs"}
+
+// addRemoteFlags registers the flags for connecting to remotes on cmd.
+func addRemoteFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s3Endpoint, "s3-endpoint", "s3.amazonaws.com", "S3 compatible endpoint for s3:// paths, a host or an http(s) url; credentials come from AWS_ACCESS_KEY_ID and friends")
+	cmd.Flags().StringVar(&s3Region, "s3-region", "", "region of the bucket for s3:// paths, looked up if empty")
+	cmd.Flags().StringVar(&sftpKey, "sftp-key", "", "private key for sftp:// paths, instead of the ssh agent and ~/.ssh/id_*")
+	cmd.Flags().StringVar(&sftpKnownHosts, "sftp-known-hosts", "", "known_hosts file the host keys of sftp:// servers are checked against (default ~/.ssh/known_hosts)")
+	cmd.Flags().BoolVar(&sftpInsecure, "sftp-insecure", false, "don't check the host keys of sftp:// servers")
+}
+
+// isRemotePath reports whether p is a url for one of remoteSchemes.
+func isRemotePath(p string) bool {
+	scheme, _, ok := strings.Cut(p, "://")
+	if !ok {
+		return false
+	}
+	for _, s := range remoteSchemes {
+		if strings.EqualFold(scheme, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRemotePath splits a remote url into where the library lives and the
+// path there: s3://bucket/some/prefix into s3://bucket and /some/prefix,
+// sftp://me@nas/volume1/comics into sftp://me@nas and /volume1/comics.
+func splitRemotePath(p string) (string, string, error) {
+	u, err := url.Parse(p)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "parsing %s", p)
+	}
+	if u.Host == "" {
+		if u.Scheme == "s3" {
+			return "", "", errors.Errorf("%s has no bucket", p)
+		}
+		return "", "", errors.Errorf("%s has no host", p)
+	}
+	key := "/" + strings.Trim(u.Path, "/")
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = "", "", "", ""
+	return u.String(), key, nil
+}
+
+// useRemote points c at the bucket or server when paths are remote urls,
+// returning them rewritten to paths there. Local paths are returned as is.
+// Every path, and --output-dir and --backup-dir if given, has to be on the
+// same remote.
+func (c *converter) useRemote(paths []string) ([]string, error) {
+	server := ""
+	remote := []string{}
+	for _, p := range paths {
+		if !isRemotePath(p) {
+			continue
+		}
+		s, key, err := splitRemotePath(p)
+		if err != nil {
+			return nil, err
+		}
+		if server != "" && s != server {
+			return nil, errors.Errorf("can't convert %s and %s in one run, use one bucket or server at a time", p, redactURL(server))
+		}
+		server = s
+		remote = append(remote, key)
+	}
+	dirs := []struct {
+		flag string
+		dir  *string
+	}{{"--output-dir", &c.outputDir}, {"--backup-dir", &c.backupDir}}
+	if server == "" {
+		for _, d := range dirs {
+			if isRemotePath(*d.dir) {
+				return nil, errors.Errorf("%s can only be a remote url when converting remote paths", d.flag)
+			}
+		}
+		return paths, nil
+	}
+	if len(remote) != len(paths) {
+		return nil, errors.New("remote and local paths can't be mixed in one run")
+	}
+
+	for _, d := range dirs {
+		if *d.dir == "" {
+			continue
+		}
+		s, key, err := splitRemotePath(*d.dir)
+		if err != nil || !isRemotePath(*d.dir) || s != server {
+			return nil, errors.Errorf("%s has to be on %s too", d.flag, redactURL(server))
+		}
+		*d.dir = key
+	}
+	if c.claims != nil {
+		return nil, errors.New("--claim-dir isn't supported with remote paths")
+	}
+	if c.sandbox {
+		return nil, errors.New("--sandbox isn't supported with remote paths")
+	}
+
+	fsys, err := openRemote(server)
+	if err != nil {
+		return nil, err
+	}
+	c.fs = fsys
+	return remote, nil
+}
+
+// openRemote connects to server, as split off by splitRemotePath.
+func openRemote(server string) (hackpadfs.FS, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", redactURL(server))
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "s3":
+		return newS3FS(s3Endpoint, s3Region, u.Host)
+	case "sftp":
+		return newSFTPFS(u)
+	case "webdav", "webdavs":
+		return newWebDAVFS(u)
+	}
+	return nil, errors.Errorf("unsupported remote %s", u.Scheme)
+}
+
+// redactURL hides any password in the url u.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return parsed.Redacted()
+}
+
+// remoteName is name as an absolute path on a remote.
+func remoteName(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+type remoteInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i remoteInfo) Name() string       { return i.name }
+func (i remoteInfo) Size() int64        { return i.size }
+func (i remoteInfo) ModTime() time.Time { return i.modTime }
+func (i remoteInfo) IsDir() bool        { return i.dir }
+func (i remoteInfo) Sys() any           { return nil }
+
+func (i remoteInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// remoteDir is an opened folder on a remote.
+type remoteDir struct {
+	fs      fs.ReadDirFS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *remoteDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *remoteDir) Close() error               { return nil }
+
+func (d *remoteDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *remoteDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// remoteFile is a remote file opened for reading. Its first remoteHeadSize
+// bytes are fetched on their own, reading past them downloads the whole
+// file once into a temporary file, since archives are read all over the
+// place.
+type remoteFile struct {
+	name string
+	info fs.FileInfo
+	// fetch reads length bytes from the start of the file, or all of it
+	// when length is 0
+	fetch  func(length int64) (io.ReadCloser, error)
+	offset int64
+	head   []byte
+	spool  *os.File
+}
+
+func (f *remoteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.spool == nil && off+int64(len(p)) <= min(remoteHeadSize, f.info.Size()) {
+		if f.head == nil {
+			err := f.fetchHead()
+			if err != nil {
+				return 0, err
+			}
+		}
+		n, err := bytes.NewReader(f.head).ReadAt(p, off)
+		return n, err
+	}
+
+	if f.spool == nil {
+		err := f.download()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return f.spool.ReadAt(p, off)
+}
+
+func (f *remoteFile) fetchHead() error {
+	r, err := f.fetch(min(remoteHeadSize, f.info.Size()))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f.head, err = io.ReadAll(r)
+	if err != nil {
+		return &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *remoteFile) download() error {
+	r, err := f.fetch(0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	spool, err := os.CreateTemp("", "cbr2cbz-remote-*")
+	if err != nil {
+		return errors.Wrap(err, "creating download file")
+	}
+	_, err = io.Copy(spool, r)
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	f.spool = spool
+	f.head = nil
+	return nil
+}
+
+func (f *remoteFile) Close() error {
+	if f.spool == nil {
+		return nil
+	}
+	f.spool.Close()
+	return os.Remove(f.spool.Name())
+}
+
+// remoteUpload is a remote file being written, kept in a temporary file
+// until it is closed and uploaded.
+type remoteUpload struct {
+	name string
+	tmp  *os.File
+	// put uploads the size bytes of r to name
+	put  func(r io.Reader, size int64) error
+	done bool
+}
+
+func newRemoteUpload(name string, put func(r io.Reader, size int64) error) (*remoteUpload, error) {
+	tmp, err := os.CreateTemp("", "cbr2cbz-remote-*")
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &remoteUpload{name: name, tmp: tmp, put: put}, nil
+}
+
+func (u *remoteUpload) Write(p []byte) (int, error) {
+	return u.tmp.Write(p)
+}
+
+func (u *remoteUpload) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: u.name, Err: errors.New("uploads are write only")}
+}
+
+func (u *remoteUpload) Stat() (fs.FileInfo, error) {
+	info, err := u.tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return remoteInfo{name: path.Base(u.name), size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (u *remoteUpload) Close() error {
+	if u.done {
+		return nil
+	}
+	u.done = true
+	defer os.Remove(u.tmp.Name())
+	defer u.tmp.Close()
+
+	info, err := u.tmp.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stating upload")
+	}
+	_, err = u.tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrap(err, "rewinding upload")
+	}
+	return u.put(u.tmp, info.Size())
+}
+
+// remoteSub is dir on a remote as a filesystem of its own. Unlike
+// hackpadfs.Sub it passes renames through, which the remotes all do.
+type remoteSub struct {
+	fs  hackpadfs.FS
+	dir string
+}
+
+func (s *remoteSub) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return s.fs.Open(path.Join(s.dir, name))
+}
+
+func (s *remoteSub) Mount(name string) (hackpadfs.FS, string) {
+	return s.fs, path.Join(s.dir, name)
+}
+
+func (s *remoteSub) Rename(oldname, newname string) error {
+	return hackpadfs.Rename(s.fs, path.Join(s.dir, oldname), path.Join(s.dir, newname))
+}
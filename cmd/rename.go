@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+// renameFields are the fields a --rename template can use.
+var renameFields = map[string]bool{"series": true, "volume": true, "issue": true, "year": true, "group": true, "name": true}
+
+// renameTemplate names the cbz after what is parsed from the source's name,
+// e.g. "{series} v{volume} #{issue:03}". {field:03} pads a number with zeros
+// and a part in <> is left out when any field in it is empty. A / starts a
+// directory.
+type renameTemplate struct {
+	parts []renamePart
+}
+
+// renamePart is literal text, a field or an optional group of parts.
+type renamePart struct {
+	text     string
+	field    string
+	width    int
+	optional []renamePart
+}
+
+// parseRenameTemplate tokenizes template, a trailing .cbz is optional.
+func parseRenameTemplate(template string) (*renameTemplate, error) {
+	if ext := path.Ext(template); strings.EqualFold(ext, ".cbz") {
+		template = strings.TrimSuffix(template, ext)
+	}
+	parts, rest, err := parseRenameParts(template, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.Errorf("unexpected %q in --rename", rest[:1])
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("--rename is empty")
+	}
+	return &renameTemplate{parts: parts}, nil
+}
+
+// parseRenameParts reads parts until the end of s or, within <>, the closing
+// >, returning what is left after it.
+func parseRenameParts(s string, optional bool) ([]renamePart, string, error) {
+	parts := []renamePart{}
+	text := strings.Builder{}
+	flush := func() {
+		if text.Len() > 0 {
+			parts = append(parts, renamePart{text: text.String()})
+			text.Reset()
+		}
+	}
+	for s != "" {
+		switch s[0] {
+		case '{':
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return nil, "", errors.Errorf("unclosed { in --rename")
+			}
+			part, err := parseRenameField(s[1:end])
+			if err != nil {
+				return nil, "", err
+			}
+			flush()
+			parts = append(parts, part)
+			s = s[end+1:]
+		case '<':
+			if optional {
+				return nil, "", errors.New("<> can't be nested in --rename")
+			}
+			inner, rest, err := parseRenameParts(s[1:], true)
+			if err != nil {
+				return nil, "", err
+			}
+			if !strings.HasPrefix(rest, ">") {
+				return nil, "", errors.New("unclosed < in --rename")
+			}
+			flush()
+			parts = append(parts, renamePart{optional: inner})
+			s = rest[1:]
+		case '>':
+			if !optional {
+				return nil, "", errors.New("unexpected > in --rename")
+			}
+			flush()
+			return parts, s, nil
+		case '}':
+			return nil, "", errors.New("unexpected } in --rename")
+		default:
+			text.WriteByte(s[0])
+			s = s[1:]
+		}
+	}
+	flush()
+	return parts, "", nil
+}
+
+// parseRenameField parses the inside of {field:03}.
+func parseRenameField(spec string) (renamePart, error) {
+	field, width, padded := strings.Cut(spec, ":")
+	field = strings.ToLower(strings.TrimSpace(field))
+	if !renameFields[field] {
+		return renamePart{}, errors.Errorf("unknown field {%s} in --rename, use series, volume, issue, year, group or name", field)
+	}
+	part := renamePart{field: field}
+	if padded {
+		n, err := strconv.Atoi(width)
+		if err != nil || n < 1 {
+			return renamePart{}, errors.Errorf("bad width %q for {%s} in --rename", width, field)
+		}
+		part.width = n
+	}
+	return part, nil
+}
+
+// render is the name, without extension, the source name stem gets. Parts
+// of the path rendering empty fall back to stem.
+func (t *renameTemplate) render(stem string) string {
+	// the extension keeps "Spawn 12.5" from being taken for one
+	name := stem + ".cbz"
+	info := cbr2cbz.ComicInfoFromFilename(name)
+	values := map[string]string{
+		"series": info.Series,
+		"issue":  info.Number,
+		"group":  cbr2cbz.ScanGroupFromFilename(name),
+		"name":   stem,
+	}
+	if info.Volume > 0 {
+		values["volume"] = strconv.Itoa(info.Volume)
+	}
+	if info.Year > 0 {
+		values["year"] = strconv.Itoa(info.Year)
+	}
+
+	rendered, _ := renderParts(t.parts, values)
+	dirs := []string{}
+	for _, dir := range strings.Split(rendered, "/") {
+		dir = strings.Trim(filenameSpaces(dir), " .-")
+		if dir != "" && dir != ".." {
+			dirs = append(dirs, cbr2cbz.SafeFileName(dir))
+		}
+	}
+	if len(dirs) == 0 {
+		return stem
+	}
+	return path.Join(dirs...)
+}
+
+// renderParts renders parts, reporting false if a field was empty.
+func renderParts(parts []renamePart, values map[string]string) (string, bool) {
+	out := strings.Builder{}
+	complete := true
+	for _, part := range parts {
+		switch {
+		case part.optional != nil:
+			if text, ok := renderParts(part.optional, values); ok {
+				out.WriteString(text)
+			}
+		case part.field != "":
+			value := chapterFileName.Replace(values[part.field])
+			if value == "" {
+				complete = false
+			}
+			out.WriteString(padNumber(value, part.width))
+		default:
+			out.WriteString(part.text)
+		}
+	}
+	return out.String(), complete
+}
+
+// padNumber pads the whole number part of value with zeros to width, 012.5
+// for 12.5 at 3. Values not starting with a digit are left alone.
+func padNumber(value string, width int) string {
+	digits := len(value) - len(strings.TrimLeft(value, "0123456789"))
+	if digits == 0 || digits >= width {
+		return value
+	}
+	return strings.Repeat("0", width-digits) + value
+}
+
+// filenameSpaces collapses runs of spaces left by empty fields.
+func filenameSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// renamedPath is where cbrFile goes under dir with --rename, see
+// numberedPath.
+func (c *converter) renamedPath(cbrFile string, dir string, stem string) string {
+	return c.numberedPath(cbrFile, filepath.Join(dir, filepath.FromSlash(c.rename.render(stem))))
+}
+
+// numberedPath is base.cbz, or if another file of the batch or, with
+// --on-exists rename, an existing file already has that name, numbered,
+// "Saga #001 (2).cbz". Each file keeps the name it was first given.
+func (c *converter) numberedPath(cbrFile string, base string) string {
+	c.renameMu.Lock()
+	defer c.renameMu.Unlock()
+	if cbzFile, ok := c.renamed[cbrFile]; ok {
+		return cbzFile
+	}
+	if c.renamed == nil {
+		c.renamed, c.renamedTo = map[string]string{}, map[string]string{}
+	}
+
+	cbzFile := base + ".cbz"
+	for n := 2; c.outputTaken(cbrFile, cbzFile); n++ {
+		cbzFile = fmt.Sprintf("%s (%d).cbz", base, n)
+	}
+	if cbzFile != base+".cbz" {
+		c.warn(cbr2cbz.CodeOutputNumbered, cbrFile, "%s.cbz is taken, naming %s %s instead", base, cbrFile, cbzFile)
+	}
+	c.renamed[cbrFile], c.renamedTo[cbzFile] = cbzFile, cbrFile
+	return cbzFile
+}
+
+// outputTaken is whether cbzFile is another file's name already.
+func (c *converter) outputTaken(cbrFile string, cbzFile string) bool {
+	if cbzFile == cbrFile {
+		return false
+	}
+	if _, ok := c.renamedTo[cbzFile]; ok {
+		return true
+	}
+	if c.existsPolicy() != "rename" {
+		return false
+	}
+	_, err := hackpadfs.Stat(c.fs, pathToFsPath(cbzFile))
+	return err == nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_renameTemplate_render(t *testing.T) {
+	tests := []struct {
+		template string
+		stem     string
+		want     string
+	}{
+		{template: "{series} v{volume} #{issue:03}.cbz", stem: "Saga v02 #14 (2013) (Digital) (Zone-Empire)", want: "Saga v2 #014"},
+		{template: "{series} v{volume} #{issue:03}", stem: "Saga 001", want: "Saga v #001"},
+		{template: "{series}< v{volume}> #{issue:03}< ({year})>", stem: "Saga 001", want: "Saga #001"},
+		{template: "{series}/{series} #{issue:03} [{group}]", stem: "Spawn 12.5 (1993) [c2c] (Group)", want: "Spawn/Spawn #012.5 [Group]"},
+		{template: "{series} #{issue}", stem: "Batman: Year One 01", want: "Batman- Year One #1"},
+		{template: "{group}", stem: "Watchmen", want: "Watchmen"},
+		{template: "{name} - {year}", stem: "Watchmen (1986)", want: "Watchmen (1986) - 1986"},
+		{template: "{series}/{issue}", stem: "Con 01", want: "_Con/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			template, err := parseRenameTemplate(tt.template)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, template.render(tt.stem))
+		})
+	}
+}
+
+func Test_parseRenameTemplate(t *testing.T) {
+	for _, template := range []string{"", "{series", "{title}", "{issue:x}", "a}", "<{series}", "{series}>", "<<{series}>>"} {
+		_, err := parseRenameTemplate(template)
+		require.Error(t, err, template)
+	}
+}
+
+func Test_convertRename(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga 001 (2012) (Digital) (Empire).cbt": makeTar(t, map[string]string{"001.jpg": "page"}),
+		"library/Saga 01 (2012) (Other).cbt":             makeTar(t, map[string]string{"001.jpg": "page"}),
+		"library/Saga #2.cbt":                            makeTar(t, map[string]string{"001.jpg": "page"}),
+		"library/Saga/Saga #002.cbz":                     []byte("already there"),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	c.rename, err = parseRenameTemplate("{series}/{series} #{issue:03}.cbz")
+	require.NoError(t, err)
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.ElementsMatch(t, []string{"/library/Saga/Saga #001.cbz", "/library/Saga/Saga #001 (2).cbz", "/library/Saga/Saga #002 (2).cbz"}, c.converted)
+	require.Equal(t, c.cbzPath("/library/Saga #2.cbt"), "/library/Saga/Saga #002 (2).cbz")
+}
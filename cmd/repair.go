@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	repairPlaceholders bool
+	repairReportPath   string
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair [paths...]",
+	Short: "Saves what can still be read of damaged archives as a new cbz",
+	Long: `Reads each cbz, cbr, cb7 and cbt entry by entry, keeping every page that reads in
+full and skipping those that don't, and writes what it got to "<name> (repaired).cbz"
+next to it. Zips are read without their central directory, so one cut short or with
+a damaged index still gives up the pages before the damage.
+
+The original is never changed or deleted, and an existing repaired copy is never
+overwritten. The pages that couldn't be read are listed in the log, and in the
+JSON written to --report.
+
+Exits non-zero if any archive had nothing readable left.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fsys, args, err = localArgs(fsys, args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		r := &archiveRepairer{fs: fsys, logger: logger, placeholders: repairPlaceholders}
+		err = r.run(cmd.Context(), args)
+		if repairReportPath != "" {
+			if reportErr := r.writeReport(repairReportPath); reportErr != nil {
+				logger.Printf("Unable to write report: %s\n", reportErr.Error())
+			}
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(repairCmd)
+
+	repairCmd.Flags().BoolVar(&repairPlaceholders, "placeholder-pages", false, "put a page saying it was unreadable in place of each page that couldn't be read, so page counts and spreads line up")
+	repairCmd.Flags().StringVar(&repairReportPath, "report", "", "write what was recovered from each archive and the pages that were missing here, as JSON")
+}
+
+// repairResult is what repair got out of one archive.
+type repairResult struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination,omitempty"`
+	// Entries is how many entries were recovered, and Missing the names of
+	// those found that couldn't be read
+	Entries int      `json:"entries"`
+	Missing []string `json:"missing"`
+	// Truncated is why the rest of the archive couldn't be read, when it
+	// couldn't, entries past it being lost without their names
+	Truncated string `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type archiveRepairer struct {
+	fs           hackpadfs.FS
+	logger       logger
+	placeholders bool
+	results      []repairResult
+}
+
+func (r *archiveRepairer) run(ctx context.Context, paths []string) error {
+	files, err := findArchives(r.fs, paths, "cbz, cbr, cb7 or cbt", verifiedExtensions...)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		res, err := r.repair(ctx, file)
+		if err != nil {
+			r.logger.Printf("Unable to repair %s: %s\n", file, err.Error())
+			res.Error = err.Error()
+			failed++
+		} else {
+			r.logger.Printf("Repaired %s as %s: %d entries recovered, %d missing\n", file, res.Destination, res.Entries, len(res.Missing))
+			for _, name := range res.Missing {
+				r.logger.Printf("  missing %s\n", name)
+			}
+			if res.Truncated != "" {
+				r.logger.Printf("  and anything after that: %s\n", res.Truncated)
+			}
+		}
+		r.results = append(r.results, res)
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d archives couldn't be repaired", failed)
+	}
+	return nil
+}
+
+// repair writes what can be read of file to its repaired copy.
+func (r *archiveRepairer) repair(ctx context.Context, file string) (repairResult, error) {
+	res := repairResult{Source: file, Missing: []string{}}
+	name := pathToFsPath(file)
+	src, err := r.fs.Open(name)
+	if err != nil {
+		return res, errors.Wrap(err, "opening archive")
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return res, errors.Wrap(err, "stating archive")
+	}
+	reader, ok := src.(io.ReaderAt)
+	if !ok {
+		return res, errors.New("filesystem doesn't support random access reads")
+	}
+
+	engine, err := cbr2cbz.New(cbr2cbz.Options{SkipBadEntries: true, Placeholders: r.placeholders})
+	if err != nil {
+		return res, err
+	}
+	engine.Logger = r.logger
+	salvaged, err := engine.Salvage(ctx, file, reader, info.Size())
+	if err != nil {
+		return res, err
+	}
+	res.Entries = len(salvaged.Files) - len(salvaged.Unreadable)
+	res.Missing = append(res.Missing, salvaged.Unreadable...)
+	if salvaged.Cut != nil {
+		res.Truncated = salvaged.Cut.Error()
+	}
+	if res.Entries == 0 {
+		return res, errors.New("nothing in it could be read")
+	}
+
+	dest := repairedName(name)
+	if _, err := hackpadfs.Stat(r.fs, dest); err == nil {
+		return res, errors.Errorf("%s already exists", dest)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return res, errors.Wrap(err, "checking for a repaired copy")
+	}
+
+	tmp := tempName(dest)
+	out, err := hackpadfs.Create(r.fs, tmp)
+	if err != nil {
+		return res, errors.Wrap(err, "creating cbz")
+	}
+	w, ok := out.(io.Writer)
+	if !ok {
+		out.Close()
+		hackpadfs.Remove(r.fs, tmp)
+		return res, errors.New("destination isn't a writable filesystem")
+	}
+	err = engine.Pack(ctx, file, salvaged.Files, w, &cbr2cbz.Progress{})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyZip(r.fs, tmp)
+	}
+	if err == nil {
+		err = hackpadfs.Rename(r.fs, tmp, dest)
+	}
+	if err != nil {
+		hackpadfs.Remove(r.fs, tmp)
+		return res, err
+	}
+	res.Destination = repairedName(file)
+	return res, nil
+}
+
+// repairedName is where the repaired copy of name goes, beside it.
+func repairedName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + " (repaired).cbz"
+}
+
+// writeReport writes the results as JSON to path.
+func (r *archiveRepairer) writeReport(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "creating report")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(r.results)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_archiveRepairer(t *testing.T) {
+	damaged := makeZip(t, map[string]string{"001.jpg": "first page", "002.jpg": "second page"})
+	damaged = bytes.Replace(damaged, []byte("second"), []byte("SECOND"), 1)
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/damaged.cbz": damaged,
+		"comics/empty.cbr":   []byte("not an archive at all"),
+	})
+	require.NoError(t, err)
+
+	r := &archiveRepairer{fs: fsys, logger: testLogger{t}}
+	require.ErrorContains(t, r.run(context.Background(), []string{"/comics"}), "1 archives couldn't be repaired")
+
+	require.Len(t, r.results, 2)
+	res := r.results[0]
+	require.Equal(t, "/comics/damaged (repaired).cbz", res.Destination)
+	require.Equal(t, 1, res.Entries)
+	require.Equal(t, []string{"002.jpg"}, res.Missing)
+	require.NotEmpty(t, r.results[1].Error)
+
+	// the original is left alone
+	original, err := hackpadfs.ReadFile(fsys, "comics/damaged.cbz")
+	require.NoError(t, err)
+	require.Equal(t, damaged, original)
+
+	repaired, err := hackpadfs.ReadFile(fsys, "comics/damaged (repaired).cbz")
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(repaired), int64(len(repaired)))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 1)
+	require.Equal(t, "001.jpg", zr.File[0].Name)
+
+	// and so is an earlier repair
+	_, err = r.repair(context.Background(), "/comics/damaged.cbz")
+	require.ErrorContains(t, err, "already exists")
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+var reportFileName string
+
+// fileResult is how converting one file went, a row of --report.
+type fileResult struct {
+	// Library is the path Source was found under
+	Library     string `json:"library"`
+	Source      string `json:"source"`
+	Destination string `json:"destination,omitempty"`
+	// Status is converted, failed or skipped
+	Status   string `json:"status"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	// Ratio is BytesOut over BytesIn, 0 for files that weren't converted
+	Ratio float64 `json:"ratio"`
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+	Code     string  `json:"code,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	// Pages are the size and format of each page of the cbz, MinWidth and
+	// MinHeight those of the smallest, for finding scans worth replacing
+	Pages     []cbr2cbz.PageInfo `json:"pages,omitempty"`
+	MinWidth  int                `json:"min_width,omitempty"`
+	MinHeight int                `json:"min_height,omitempty"`
+	// RewrittenPaths are the entries whose paths weren't safe in a zip and
+	// what they were renamed to in the cbz
+	RewrittenPaths []rewrittenPath `json:"rewritten_paths,omitempty"`
+}
+
+// rewrittenPath is an entry renamed to a safe path, see warning W009.
+type rewrittenPath struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// noteRewrite records that an entry of cbrFile was renamed to a safe path,
+// for its row of the report.
+func (c *converter) noteRewrite(cbrFile string, r rewrittenPath) {
+	c.rewritesMu.Lock()
+	defer c.rewritesMu.Unlock()
+	if c.rewrites == nil {
+		c.rewrites = map[string][]rewrittenPath{}
+	}
+	c.rewrites[cbrFile] = append(c.rewrites[cbrFile], r)
+}
+
+// takeRewrites returns the entries of cbrFile renamed to safe paths, and
+// forgets them.
+func (c *converter) takeRewrites(cbrFile string) []rewrittenPath {
+	c.rewritesMu.Lock()
+	defer c.rewritesMu.Unlock()
+	rewrites := c.rewrites[cbrFile]
+	delete(c.rewrites, cbrFile)
+	return rewrites
+}
+
+// batchReport is what --report writes as JSON.
+type batchReport struct {
+	Version         string    `json:"version"`
+	Paths           []string  `json:"paths"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Converted       int       `json:"converted"`
+	Failed          int       `json:"failed"`
+	BytesIn         int64     `json:"bytes_in"`
+	BytesOut        int64     `json:"bytes_out"`
+	// BytesSaved is BytesIn less BytesOut, negative if the cbz files came
+	// out bigger
+	BytesSaved int64 `json:"bytes_saved"`
+	// GrownFiles are the converted files that grew the most
+	GrownFiles []grownFile `json:"grown_files,omitempty"`
+	// Snapshot is the snapshot taken before the batch, if there was one
+	Snapshot *snapshot `json:"snapshot,omitempty"`
+	// Libraries totals Files by library
+	Libraries map[string]*libraryTotals `json:"libraries"`
+	Files     []fileResult              `json:"files"`
+}
+
+// grownFilesShown is how many of the files that grew the stats and the
+// report list.
+const grownFilesShown = 10
+
+// grownFile is a converted file whose cbz is bigger than the original.
+type grownFile struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	BytesIn     int64  `json:"bytes_in"`
+	BytesOut    int64  `json:"bytes_out"`
+	// Growth is BytesOut less BytesIn
+	Growth int64 `json:"growth"`
+}
+
+// grownFiles returns the n converted files of results that grew the most,
+// biggest growth first.
+func grownFiles(results []fileResult, n int) []grownFile {
+	grown := []grownFile{}
+	for _, r := range results {
+		if r.Status == "converted" && r.BytesOut > r.BytesIn {
+			grown = append(grown, grownFile{Source: r.Source, Destination: r.Destination, BytesIn: r.BytesIn, BytesOut: r.BytesOut, Growth: r.BytesOut - r.BytesIn})
+		}
+	}
+	sort.SliceStable(grown, func(i, j int) bool { return grown[i].Growth > grown[j].Growth })
+	if len(grown) > n {
+		grown = grown[:n]
+	}
+	return grown
+}
+
+// libraryTotals is how the files of one library went.
+type libraryTotals struct {
+	Converted int   `json:"converted"`
+	Failed    int   `json:"failed"`
+	Skipped   int   `json:"skipped"`
+	BytesIn   int64 `json:"bytes_in"`
+	BytesOut  int64 `json:"bytes_out"`
+}
+
+// libraryTotalsOf adds up results by library.
+func libraryTotalsOf(results []fileResult) map[string]*libraryTotals {
+	totals := map[string]*libraryTotals{}
+	for _, r := range results {
+		t, ok := totals[r.Library]
+		if !ok {
+			t = &libraryTotals{}
+			totals[r.Library] = t
+		}
+		switch r.Status {
+		case "converted":
+			t.Converted++
+			t.BytesIn += r.BytesIn
+			t.BytesOut += r.BytesOut
+		case "failed":
+			t.Failed++
+		case "skipped":
+			t.Skipped++
+		}
+	}
+	return totals
+}
+
+var reportStatuses = map[string]string{"convert": "converted", "fail": "failed", "skip": "skipped"}
+
+// fileResultOf turns the event for a file into its row of the report.
+func fileResultOf(e logEvent) fileResult {
+	r := fileResult{
+		Library:     e.Library,
+		Source:      e.File,
+		Destination: e.Output,
+		Status:      reportStatuses[e.Action],
+		BytesIn:     e.BytesIn,
+		BytesOut:    e.BytesOut,
+		Duration:    e.Duration,
+		Code:        e.Code,
+		Error:       e.Error,
+	}
+	if r.Status == "converted" && r.BytesIn > 0 {
+		r.Ratio = float64(r.BytesOut) / float64(r.BytesIn)
+	}
+	return r
+}
+
+// addPages fills in the pages of the cbz r was converted to.
+func (c *converter) addPages(r *fileResult) {
+	zr, file, err := openZip(c.fs, pathToFsPath(r.Destination))
+	if err != nil {
+		// split into chapters, or already gone
+		return
+	}
+	defer file.Close()
+	r.Pages = c.packer().Pages(zr)
+	for i, p := range r.Pages {
+		if i == 0 || p.Width < r.MinWidth {
+			r.MinWidth = p.Width
+		}
+		if i == 0 || p.Height < r.MinHeight {
+			r.MinHeight = p.Height
+		}
+	}
+}
+
+// writeReport writes the results of the run that started at start to
+// c.reportPath, as CSV if it ends in .csv and JSON otherwise.
+func (c *converter) writeReport(start time.Time) error {
+	if c.reportPath == "" {
+		return nil
+	}
+	results := append([]fileResult{}, c.results...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Source < results[j].Source })
+
+	f, err := os.Create(c.reportPath)
+	if err != nil {
+		return errors.Wrap(err, "creating report")
+	}
+	if strings.EqualFold(filepath.Ext(c.reportPath), ".csv") {
+		err = writeCSVReport(f, results)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(batchReport{
+			Version:         rootCmd.Version,
+			Paths:           c.paths,
+			Started:         start,
+			DurationSeconds: time.Since(start).Seconds(),
+			Converted:       len(c.converted),
+			Failed:          len(c.failed),
+			BytesIn:         c.bytesIn,
+			BytesOut:        c.bytesOut,
+			BytesSaved:      c.bytesIn - c.bytesOut,
+			GrownFiles:      grownFiles(results, grownFilesShown),
+			Snapshot:        c.snapshot,
+			Libraries:       libraryTotalsOf(results),
+			Files:           results,
+		})
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing report")
+	}
+	c.logger.Printf("A report has been written to %s\n", c.reportPath)
+	return nil
+}
+
+func writeCSVReport(f *os.File, results []fileResult) error {
+	w := csv.NewWriter(f)
+	w.Write([]string{"library", "source", "destination", "status", "bytes_in", "bytes_out", "ratio", "duration", "code", "error", "pages", "min_width", "min_height", "rewritten_paths"})
+	for _, r := range results {
+		w.Write([]string{
+			r.Library,
+			r.Source,
+			r.Destination,
+			r.Status,
+			strconv.FormatInt(r.BytesIn, 10),
+			strconv.FormatInt(r.BytesOut, 10),
+			strconv.FormatFloat(r.Ratio, 'f', 4, 64),
+			strconv.FormatFloat(r.Duration, 'f', 3, 64),
+			r.Code,
+			r.Error,
+			strconv.Itoa(len(r.Pages)),
+			strconv.Itoa(r.MinWidth),
+			strconv.Itoa(r.MinHeight),
+			strconv.Itoa(len(r.RewrittenPaths)),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
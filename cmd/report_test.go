@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeReport(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "json", file: "report.json"},
+		{name: "csv", file: "report.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, err := setupFS(t, filenameBytes{
+				"library/a.cbr": realCBRContents,
+				"library/b.cbr": []byte("not yet downloaded"),
+			})
+			require.NoError(t, err)
+			reportPath := filepath.Join(t.TempDir(), tt.file)
+
+			c := &converter{fs: fsys, logger: testLogger{t}, reportPath: reportPath}
+			require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+			f, err := os.Open(reportPath)
+			require.NoError(t, err)
+			defer f.Close()
+			if tt.name == "csv" {
+				rows, err := csv.NewReader(f).ReadAll()
+				require.NoError(t, err)
+				require.Len(t, rows, 3)
+				require.Equal(t, []string{"library", "source", "destination", "status", "bytes_in", "bytes_out", "ratio", "duration", "code", "error", "pages", "min_width", "min_height", "rewritten_paths"}, rows[0])
+				require.Equal(t, []string{"/library", "/library/a.cbr", "/library/a.cbz", "converted"}, rows[1][:4])
+				require.Equal(t, []string{"/library", "/library/b.cbr", "", "failed"}, rows[2][:4])
+				require.Equal(t, cbr2cbz.CodeNotArchive, rows[2][8])
+				return
+			}
+
+			report := batchReport{}
+			require.NoError(t, json.NewDecoder(f).Decode(&report))
+			require.Equal(t, 1, report.Converted)
+			require.Equal(t, 1, report.Failed)
+			require.Len(t, report.Files, 2)
+			require.Equal(t, report.BytesIn-report.BytesOut, report.BytesSaved)
+			require.Equal(t, map[string]*libraryTotals{
+				"/library": {Converted: 1, Failed: 1, BytesIn: report.BytesIn, BytesOut: report.BytesOut},
+			}, report.Libraries)
+			converted, failed := report.Files[0], report.Files[1]
+			require.Equal(t, "/library/a.cbz", converted.Destination)
+			require.Equal(t, int64(len(realCBRContents)), converted.BytesIn)
+			require.InDelta(t, float64(converted.BytesOut)/float64(converted.BytesIn), converted.Ratio, 0.0001)
+			require.Equal(t, "failed", failed.Status)
+			require.Equal(t, cbr2cbz.CodeNotArchive, failed.Code)
+			require.NotEmpty(t, failed.Error)
+		})
+	}
+}
+
+func Test_writeReport_rewrittenPaths(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbt": makeTar(t, map[string]string{"001.jpg": "page", "../../002.jpg": "page"}),
+	})
+	require.NoError(t, err)
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+
+	c := &converter{fs: fsys, logger: testLogger{t}, reportPath: reportPath}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	report := batchReport{}
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Len(t, report.Files, 1)
+	require.Equal(t, []rewrittenPath{{From: "../../002.jpg", To: "002.jpg"}}, report.Files[0].RewrittenPaths)
+	require.Empty(t, c.rewrites, "taken once the file is done")
+}
+
+func Test_libraryTotalsOf(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"marvel/a.cbr": realCBRContents,
+		"marvel/b.cbr": []byte("not yet downloaded"),
+		"dc/c.cbr":     realCBRContents,
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/marvel", "/dc"}))
+	totals := libraryTotalsOf(c.results)
+	require.Len(t, totals, 2)
+	require.Equal(t, 1, totals["/marvel"].Converted)
+	require.Equal(t, 1, totals["/marvel"].Failed)
+	require.Equal(t, 1, totals["/dc"].Converted)
+	require.Equal(t, 0, totals["/dc"].Failed)
+}
+
+func Test_grownFiles(t *testing.T) {
+	results := []fileResult{
+		{Source: "/a.cbr", Destination: "/a.cbz", Status: "converted", BytesIn: 100, BytesOut: 90},
+		{Source: "/b.cbr", Destination: "/b.cbz", Status: "converted", BytesIn: 100, BytesOut: 110},
+		{Source: "/c.cbr", Destination: "/c.cbz", Status: "converted", BytesIn: 100, BytesOut: 150},
+		{Source: "/d.cbr", Status: "failed", BytesIn: 100},
+		{Source: "/e.cbr", Destination: "/e.cbz", Status: "converted", BytesIn: 100, BytesOut: 101},
+	}
+	require.Equal(t, []grownFile{
+		{Source: "/c.cbr", Destination: "/c.cbz", BytesIn: 100, BytesOut: 150, Growth: 50},
+		{Source: "/b.cbr", Destination: "/b.cbz", BytesIn: 100, BytesOut: 110, Growth: 10},
+	}, grownFiles(results, 2))
+	require.Empty(t, grownFiles(results[:1], 10))
+}
+
+func Test_printStats_savings(t *testing.T) {
+	var out strings.Builder
+	c := &converter{logger: log.New(&out, "", 0), bytesIn: 1000, bytesOut: 750}
+	c.results = []fileResult{{Source: "/b.cbr", Destination: "/b.cbz", Status: "converted", BytesIn: 100, BytesOut: 150}}
+	c.printStats(time.Now(), nil)
+	require.Contains(t, out.String(), "Space saved: 250 B (25.0%)\n")
+	require.Contains(t, out.String(), "Files that grew the most:\n\t/b.cbr\t100 B to 150 B (+50 B)\n")
+
+	out.Reset()
+	c = &converter{logger: log.New(&out, "", 0), bytesIn: 1000, bytesOut: 1100}
+	c.printStats(time.Now(), nil)
+	require.Contains(t, out.String(), "Space gained: 100 B (10.0%)\n")
+	require.NotContains(t, out.String(), "grew")
+}
+
+func Test_converter_addPages(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbz": makeZip(t, map[string]string{
+			"01.png": string(makePNG(t, 800, 1200)),
+			"02.png": string(makePNG(t, 1600, 1100)),
+		}),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	r := fileResult{Destination: "/library/a.cbz"}
+	c.addPages(&r)
+	require.Len(t, r.Pages, 2)
+	require.Equal(t, 800, r.MinWidth)
+	require.Equal(t, 1100, r.MinHeight)
+
+	gone := fileResult{Destination: "/library/b.cbz"}
+	c.addPages(&gone)
+	require.Empty(t, gone.Pages)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const issuesURL = "https://github.com/halkeye/cbr2cbz/issues/new"
+
+// maxIssueStack keeps the issue url short enough for browsers, the full
+// stack is in the attached report.
+const maxIssueStack = 3000
+
+// reportCrashCmd represents the report-crash command
+var reportCrashCmd = &cobra.Command{
+	Use:   "report-crash [id]",
+	Short: "Shares a report saved when reading an archive crashed",
+	Long: `When reading an archive crashes, convert fails that file, carries on with the
+rest and saves a report to --crash-dir: where it crashed, the first bytes of
+the file and the headers of its entries. No page contents, and the file only by
+its extension, size and checksum.
+
+Without an id the saved reports are listed. With one, an issue is opened for it
+with a link to follow; attach the report file it names so the crash can be
+reproduced.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if len(args) == 0 {
+			err = listCrashReports(cmd.OutOrStdout(), crashDir)
+		} else {
+			err = shareCrashReport(cmd.OutOrStdout(), crashDir, args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCrashCmd)
+
+	reportCrashCmd.Flags().StringVar(&crashDir, "crash-dir", defaultCrashDir(), "directory convert saves crash reports in")
+}
+
+func listCrashReports(w io.Writer, dir string) error {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return errors.Wrap(err, "listing crash reports")
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(w, "No crash reports saved")
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		report, err := readCrashReport(dir, id)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t%s\n", id, err.Error())
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s %s\t%s\n", id, report.Format, report.Extension, report.Panic)
+	}
+	return nil
+}
+
+func shareCrashReport(w io.Writer, dir string, id string) error {
+	report, err := readCrashReport(dir, id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Open this to report the crash:\n\n%s\n\n", crashIssueURL(report))
+	fmt.Fprintf(w, "and attach %s to the issue. It has the first %d bytes of the file and the headers of its %d entries, nothing of the pages.\n",
+		filepath.Join(dir, id+".json"), len(report.Header), len(report.Entries))
+	return nil
+}
+
+// crashIssueURL is the link to a new issue filled in from report.
+func crashIssueURL(report *crashReport) string {
+	stack := report.Stack
+	if len(stack) > maxIssueStack {
+		stack = stack[:maxIssueStack] + "\n..."
+	}
+	format := report.Format
+	if format == "" {
+		format = "unknown"
+	}
+	body := &strings.Builder{}
+	fmt.Fprintf(body, "Reading a %s file (%s, %d bytes, %d entries) crashed.\n\n", report.Extension, format, report.Size, len(report.Entries))
+	fmt.Fprintf(body, "- version: %s\n- os: %s/%s\n- report: %s (attached)\n- sha256: %s\n\n", report.Version, report.OS, report.Arch, report.ID, report.SHA256)
+	fmt.Fprintf(body, "```\npanic: %s\n\n%s\n```\n", report.Panic, stack)
+
+	query := url.Values{}
+	query.Set("title", "Crash reading a "+report.Extension+" file: "+firstLine(report.Panic))
+	query.Set("body", body.String())
+	return issuesURL + "?" + query.Encode()
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	retentionFileName string
+	purgeOlderThan    string
+	purgeDryRun       bool
+	watchPurgeAfter   string
+)
+
+// retentionMu is held while the retention file is added to or rewritten,
+// watch purges it between conversions still adding to it. lockRetention
+// keeps other processes out as well.
+var retentionMu sync.Mutex
+
+// lockRetention takes the lock next to the retention file at path, so a
+// purge rewriting it can't drop what a convert running alongside appends.
+func lockRetention(path string) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating retention directory")
+	}
+	unlock, err := lockFile(path + ".lock")
+	return unlock, errors.Wrap(err, "locking retention file")
+}
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Removes originals --trash or --backup-dir moved aside longer ago than --older-than",
+	Long: `Removes the originals convert moved to the trash or under --backup-dir once they
+have been kept longer than --older-than, going by --retention-file, so there is
+a window to undo a conversion without having to remember to clean up later.
+
+An original is only removed while the cbz it was converted to is still there.
+Originals the recycle bin on Windows took aren't tracked, it keeps its own
+limits. watch --purge-after does the same as it runs.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		window, err := parseAge(purgeOlderThan)
+		if err != nil {
+			logger.Fatal(errors.Wrap(err, "parsing --older-than"))
+		}
+		purged, _, err := purgeRetained(retentionFileName, window, time.Now(), purgeDryRun, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if purged == 0 {
+			logger.Printf("Nothing to purge\n")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+
+	purgeCmd.Flags().StringVar(&retentionFileName, "retention-file", defaultRetentionPath(), "file convert records the originals it moved aside in")
+	purgeCmd.Flags().StringVar(&purgeOlderThan, "older-than", "30d", "remove originals moved aside longer ago than this (e.g. 30d, 2w, 36h)")
+	purgeCmd.Flags().BoolVar(&purgeDryRun, "dry-run", false, "only list what would be removed")
+}
+
+func defaultRetentionPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "retention.jsonl")
+}
+
+// retainedOriginal is an original that was moved aside instead of deleted,
+// a line in the retention file. The paths are on the local disk.
+type retainedOriginal struct {
+	// Original is where it was converted from, Kept where it is now
+	Original string `json:"original"`
+	Kept     string `json:"kept"`
+	// Via is trash or backup
+	Via      string    `json:"via"`
+	Output   string    `json:"output"`
+	Disposed time.Time `json:"disposed"`
+	// Verified is whether the cbz was read back before the original went
+	Verified bool `json:"verified"`
+}
+
+// recordRetained adds cbrFile, moved to kept, to the retention file for
+// purge. Originals not on the local disk, or gone somewhere unknown, can't
+// be purged later and aren't recorded. Failing to is only logged.
+func (c *converter) recordRetained(cbrFile string, cbzFile string, kept string, via string) {
+	if c.retentionPath == "" || kept == "" {
+		return
+	}
+	osFS, ok := c.fs.(interface{ ToOSPath(string) (string, error) })
+	if !ok {
+		return
+	}
+	e := retainedOriginal{Kept: kept, Via: via, Disposed: time.Now().UTC(), Verified: c.verify}
+	var err error
+	e.Original, err = osFS.ToOSPath(pathToFsPath(cbrFile))
+	if err == nil {
+		e.Output, err = osFS.ToOSPath(pathToFsPath(cbzFile))
+	}
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(e)
+	}
+
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	if err == nil {
+		var unlock func()
+		unlock, err = lockRetention(c.retentionPath)
+		if err == nil {
+			defer unlock()
+		}
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(c.retentionPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	}
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		c.logFor(cbrFile).Printf("Unable to record %s in %s, purge won't remove it: %s\n", cbrFile, c.retentionPath, err.Error())
+	}
+}
+
+// purgeRetained removes the originals in the retention file at path that
+// were moved aside at least window before now, as long as their cbz is
+// still there, and drops them from the file along with those already gone.
+// With dryRun it only lists them. It returns how many were, or would be,
+// removed and the space that frees.
+func purgeRetained(path string, window time.Duration, now time.Time, dryRun bool, logger logger) (int, int64, error) {
+	if path == "" {
+		return 0, 0, errors.New("purge needs a --retention-file")
+	}
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	unlock, err := lockRetention(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "opening retention file")
+	}
+
+	kept := []retainedOriginal{}
+	purged := 0
+	var freed int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var e retainedOriginal
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Kept == "" {
+			continue
+		}
+		info, err := os.Stat(e.Kept)
+		if errors.Is(err, fs.ErrNotExist) {
+			// restored or removed by hand
+			continue
+		}
+		if err != nil || now.Sub(e.Disposed) < window {
+			kept = append(kept, e)
+			continue
+		}
+		if _, err := os.Stat(e.Output); err != nil {
+			logger.Printf("Keeping %s, %s it was converted to is gone\n", e.Kept, e.Output)
+			kept = append(kept, e)
+			continue
+		}
+		if dryRun {
+			logger.Printf("Would remove %s, converted to %s %s\n", e.Kept, e.Output, humanize.RelTime(e.Disposed, now, "ago", "from now"))
+			purged++
+			freed += info.Size()
+			continue
+		}
+		err = os.Remove(e.Kept)
+		if err != nil {
+			logger.Printf("Unable to remove %s: %s\n", e.Kept, err.Error())
+			kept = append(kept, e)
+			continue
+		}
+		if e.Via == "trash" {
+			removeTrashInfo(e.Kept)
+		}
+		logger.Printf("Removed %s, converted to %s %s\n", e.Kept, e.Output, humanize.RelTime(e.Disposed, now, "ago", "from now"))
+		purged++
+		freed += info.Size()
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, errors.Wrap(err, "reading retention file")
+	}
+
+	switch {
+	case purged == 0:
+	case dryRun:
+		logger.Printf("%d originals using %s are due, purge without --dry-run removes them\n", purged, formatBytes(uint64(freed)))
+		return purged, freed, nil
+	default:
+		logger.Printf("Removed %d originals, freeing %s\n", purged, formatBytes(uint64(freed)))
+	}
+	return purged, freed, writeRetained(path, kept)
+}
+
+// writeRetained replaces the retention file at path with entries.
+func writeRetained(path string, entries []retainedOriginal) error {
+	buf := &bytes.Buffer{}
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	tmp := path + ".part"
+	err := os.WriteFile(tmp, buf.Bytes(), 0o644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return errors.Wrap(err, "updating retention file")
+}
+
+// removeTrashInfo removes the freedesktop.org .trashinfo of a file purged
+// from the trash, so file managers don't list it anymore.
+func removeTrashInfo(trashed string) {
+	files := filepath.Dir(trashed)
+	if filepath.Base(files) != "files" {
+		return
+	}
+	os.Remove(filepath.Join(filepath.Dir(files), "info", filepath.Base(trashed)+".trashinfo"))
+}
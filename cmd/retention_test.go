@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseAge(t *testing.T) {
+	for value, want := range map[string]time.Duration{"36h": 36 * time.Hour, "7d": 7 * 24 * time.Hour, "2w": 14 * 24 * time.Hour} {
+		age, err := parseAge(value)
+		require.NoError(t, err)
+		require.Equal(t, want, age)
+	}
+	_, err := parseAge("soon")
+	require.Error(t, err)
+}
+
+func Test_purgeRetained(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	file := func(name string) string {
+		name = filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(t, os.WriteFile(name, []byte("rar"), 0o644))
+		return name
+	}
+	entries := []retainedOriginal{
+		// due, its cbz is there
+		{Kept: file("Trash/files/old.cbr"), Via: "trash", Output: file("library/old.cbz"), Disposed: now.Add(-31 * 24 * time.Hour)},
+		// due, but its cbz is gone
+		{Kept: file("backup/lost.cbr"), Via: "backup", Output: filepath.Join(dir, "library/lost.cbz"), Disposed: now.Add(-31 * 24 * time.Hour)},
+		// not due yet
+		{Kept: file("backup/new.cbr"), Via: "backup", Output: file("library/new.cbz"), Disposed: now.Add(-24 * time.Hour)},
+		// restored by hand
+		{Kept: filepath.Join(dir, "backup/restored.cbr"), Via: "backup", Output: file("library/restored.cbz"), Disposed: now.Add(-31 * 24 * time.Hour)},
+	}
+	info := file("Trash/info/old.cbr.trashinfo")
+	ledger := filepath.Join(dir, "retention.jsonl")
+	require.NoError(t, writeRetained(ledger, entries))
+
+	purged, freed, err := purgeRetained(ledger, 30*24*time.Hour, now, true, testLogger{t})
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	require.Equal(t, int64(3), freed)
+	_, err = os.Stat(entries[0].Kept)
+	require.NoError(t, err)
+
+	purged, _, err = purgeRetained(ledger, 30*24*time.Hour, now, false, testLogger{t})
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	for _, gone := range []string{entries[0].Kept, info} {
+		_, err = os.Stat(gone)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+	for _, kept := range []string{entries[1].Kept, entries[2].Kept} {
+		_, err = os.Stat(kept)
+		require.NoError(t, err)
+	}
+	data, err := os.ReadFile(ledger)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(data), "\n"))
+	require.Contains(t, string(data), "lost.cbr")
+	require.Contains(t, string(data), "new.cbr")
+}
+
+func Test_convertBackupDirRetention(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "library"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "library", "test.cbr"), realCBRContents, 0o644))
+
+	fsys, err := hackpados.NewFS().Sub(strings.TrimPrefix(filepath.ToSlash(dir), "/"))
+	require.NoError(t, err)
+	ledger := filepath.Join(dir, "retention.jsonl")
+	c := &converter{fs: fsys, logger: testLogger{t}, backupDir: "/backup", retentionPath: ledger}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+
+	backedUp := filepath.Join(dir, "backup", "test.cbr")
+	_, err = os.Stat(backedUp)
+	require.NoError(t, err)
+	purged, _, err := purgeRetained(ledger, 0, time.Now().Add(time.Second), false, testLogger{t})
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	_, err = os.Stat(backedUp)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(dir, "library", "test.cbz"))
+	require.NoError(t, err)
+}
+
+func Test_lockRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cbr2cbz", "retention.jsonl")
+	unlock, err := lockRetention(path)
+	require.NoError(t, err)
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := lockRetention(path)
+		require.NoError(t, err)
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("took the lock while it was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+var (
+	retries    int
+	retryDelay time.Duration
+)
+
+// maxRetryDelay caps the backoff, however many retries are allowed.
+const maxRetryDelay = 5 * time.Minute
+
+// isTransient reports whether err may well not happen again, like an NFS
+// hiccup or a file another program has locked, as opposed to the file
+// itself being broken.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return isIOError(err)
+}
+
+// withRetries runs convert, trying again after a transient error up to
+// c.retries times, waiting c.retryDelay and then twice as long each time.
+// Once the cbz is written, calling written, convert isn't tried again: the
+// original may already be on its way out.
+func (c *converter) withRetries(ctx context.Context, cbrFile string, convert func(written func()) error, written func()) error {
+	wrote := false
+	onWritten := func() {
+		wrote = true
+		if written != nil {
+			written()
+		}
+	}
+	delay := c.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := convert(onWritten)
+		if err == nil || wrote || attempt > c.retries || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		c.warn(cbr2cbz.CodeRetrying, cbrFile, "%s failed, trying again in %s (%d of %d): [%s] %s",
+			cbrFile, delay, attempt, c.retries, errorCode(err), err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_withRetries(t *testing.T) {
+	c := &converter{logger: testLogger{t}, retries: 2, retryDelay: time.Millisecond}
+	failing := func(failures int, err error, calls *int) func(func()) error {
+		return func(written func()) error {
+			*calls++
+			if *calls <= failures {
+				return errors.Wrap(err, "reading entry")
+			}
+			written()
+			return nil
+		}
+	}
+
+	calls, written := 0, 0
+	err := c.withRetries(context.Background(), "/a.cbr", failing(2, syscall.EIO, &calls), func() { written++ })
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, 1, written)
+
+	calls = 0
+	err = c.withRetries(context.Background(), "/a.cbr", failing(5, syscall.ESTALE, &calls), nil)
+	require.ErrorIs(t, err, syscall.ESTALE)
+	require.Equal(t, 3, calls, "gives up after --retries")
+
+	calls = 0
+	err = c.withRetries(context.Background(), "/a.cbr", failing(5, cbr2cbz.ErrNotArchive, &calls), nil)
+	require.ErrorIs(t, err, cbr2cbz.ErrNotArchive)
+	require.Equal(t, 1, calls, "a broken file isn't tried again")
+
+	calls = 0
+	err = c.withRetries(context.Background(), "/a.cbr", func(written func()) error {
+		calls++
+		written()
+		return errors.Wrap(syscall.EIO, "verifying")
+	}, nil)
+	require.Error(t, err)
+	require.Equal(t, 1, calls, "not tried again once the cbz is written")
+}
@@ -16,27 +16,69 @@ var rootCmd = &cobra.Command{
 
 https://github.com/halkeye/cbr2cbz (original bash version at https://git.zaks.web.za/thisiszeev/cbr2cbz)
 
-Warning: If conversion is successful, the original file(s) will be deleted.`,
+Warning: If conversion is successful, the original file(s) will be deleted unless --keep is given.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
 }
 
+// loadedConfig is the config file applied to this run, nil if there isn't one.
+var loadedConfig *configFile
+
+// buildVersion is the release this is, without the build details.
+var buildVersion = "dev"
+
 func SetVersionInfo(version, commit, date string) {
+	buildVersion = version
 	rootCmd.Version = fmt.Sprintf("%s (Built on %s from Git SHA %s)", version, date, commit)
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	if args := legacyArgs(os.Args); args != nil {
+		rootCmd.SetArgs(args)
+	}
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
+var rootDir string
+
 func init() {
 	cobra.OnInitialize(initConfig)
+
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if cmd == configValidateCmd {
+			// reports problems itself instead of refusing to start
+			return nil
+		}
+		if cmd == sandboxWorkerCmd {
+			// gets everything it needs from the parent, not the config
+			return nil
+		}
+		var err error
+		loadedConfig, err = applyConfigFile(cmd)
+		if err != nil {
+			return err
+		}
+		err = applyPreset(configFlagSets()...)
+		if err != nil {
+			return err
+		}
+		if _, err := consoleLevel(); err != nil {
+			return err
+		}
+		if err := setUnits(); err != nil {
+			return err
+		}
+		return setIOLimits()
+	}
+
+	rootCmd.PersistentFlags().StringVar(&rootDir, "root", "", "confine every path to this directory, inputs are taken relative to it and anything resolving outside it is refused")
+	rootCmd.RegisterFlagCompletionFunc("root", completeDirs)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+var (
+	s3Endpoint string
+	s3Region   string
+)
+
+// s3FS is an S3 bucket as a filesystem. Keys are paths and folders are the
+// prefixes between slashes, so there are no empty folders and making them
+// does nothing. Renames are server side copies.
+type s3FS struct {
+	client *minio.Client
+	bucket string
+}
+
+// newS3FS connects to bucket at endpoint, a host or an http(s) url, with
+// credentials from the usual AWS environment variables, the shared
+// credentials file or the instance role.
+func newS3FS(endpoint string, region string, bucket string) (*s3FS, error) {
+	secure := true
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		secure = u.Scheme != "http"
+		endpoint = u.Host
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		}),
+		Secure: secure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to s3")
+	}
+	return &s3FS{client: client, bucket: bucket}, nil
+}
+
+func (s *s3FS) pathError(op string, name string, err error) error {
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NotFound" {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// isDir reports whether any key is under name/.
+func (s *s3FS) isDir(name string) (bool, error) {
+	if name == "." {
+		return true, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: name + "/", MaxKeys: 1}) {
+		return obj.Err == nil, obj.Err
+	}
+	return false, nil
+}
+
+func (s *s3FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		obj, err := s.client.StatObject(context.Background(), s.bucket, name, minio.StatObjectOptions{})
+		if err == nil {
+			return remoteInfo{name: path.Base(name), size: obj.Size, modTime: obj.LastModified}, nil
+		}
+		if !errors.Is(s.pathError("stat", name, err), fs.ErrNotExist) {
+			return nil, s.pathError("stat", name, err)
+		}
+	}
+
+	dir, err := s.isDir(name)
+	if err != nil {
+		return nil, s.pathError("stat", name, err)
+	}
+	if !dir {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return remoteInfo{name: path.Base(name), dir: true}, nil
+}
+
+func (s *s3FS) Open(name string) (fs.File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &remoteDir{fs: s, name: name, info: info}, nil
+	}
+	return &remoteFile{name: name, info: info, fetch: func(length int64) (io.ReadCloser, error) {
+		opts := minio.GetObjectOptions{}
+		if length > 0 {
+			opts.SetRange(0, length-1)
+		}
+		obj, err := s.client.GetObject(context.Background(), s.bucket, name, opts)
+		if err != nil {
+			return nil, s.pathError("read", name, err)
+		}
+		return obj, nil
+	}}, nil
+}
+
+func (s *s3FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	entries := []fs.DirEntry{}
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, s.pathError("readdir", name, obj.Err)
+		}
+		rel := strings.TrimPrefix(obj.Key, prefix)
+		if rel == "" {
+			// folder marker some tools create
+			continue
+		}
+		if strings.HasSuffix(rel, "/") {
+			entries = append(entries, fs.FileInfoToDirEntry(remoteInfo{name: strings.TrimSuffix(rel, "/"), dir: true}))
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(remoteInfo{name: rel, size: obj.Size, modTime: obj.LastModified}))
+	}
+	if len(entries) == 0 && name != "." {
+		if _, err := s.Stat(name); err != nil {
+			return nil, err
+		}
+	}
+	// keys sort by bytes, "a.cbz" before the folder "a/"
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// OpenFile opens name for reading, or for writing when flag asks for it.
+// Written files are uploaded when they are closed.
+func (s *s3FS) OpenFile(name string, flag int, perm hackpadfs.FileMode) (hackpadfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return s.Open(name)
+	}
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if flag&os.O_APPEND != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("s3 objects can't be appended to")}
+	}
+	if flag&os.O_EXCL != 0 {
+		if _, err := s.Stat(name); err == nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+	}
+
+	return newRemoteUpload(name, func(r io.Reader, size int64) error {
+		_, err := s.client.PutObject(context.Background(), s.bucket, name, r, size, minio.PutObjectOptions{
+			ContentType: "application/octet-stream",
+		})
+		if err != nil {
+			return s.pathError("write", name, err)
+		}
+		return nil
+	})
+}
+
+func (s *s3FS) Remove(name string) error {
+	if _, err := s.client.StatObject(context.Background(), s.bucket, name, minio.StatObjectOptions{}); err != nil {
+		return s.pathError("remove", name, err)
+	}
+	err := s.client.RemoveObject(context.Background(), s.bucket, name, minio.RemoveObjectOptions{})
+	if err != nil {
+		return s.pathError("remove", name, err)
+	}
+	return nil
+}
+
+// Rename copies oldname to newname inside the bucket, without the data
+// leaving it, then removes oldname.
+func (s *s3FS) Rename(oldname, newname string) error {
+	err := s.Copy(oldname, newname)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return s.Remove(oldname)
+}
+
+// Copy copies src to dst inside the bucket, without the data leaving it.
+func (s *s3FS) Copy(src, dst string) error {
+	_, err := s.client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: s.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: src},
+	)
+	if err != nil {
+		return s.pathError("copy", src, err)
+	}
+	return nil
+}
+
+func (s *s3FS) Mkdir(name string, perm hackpadfs.FileMode) error {
+	return nil
+}
+
+func (s *s3FS) MkdirAll(name string, perm hackpadfs.FileMode) error {
+	return nil
+}
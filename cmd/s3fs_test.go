@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is just enough of the S3 API for s3FS, path style, one bucket and
+// no auth.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	// puts and copies count uploads and server side copies by key
+	puts   map[string]int
+	copies map[string]int
+}
+
+func newFakeS3(t *testing.T, bucket string, objects filenameBytes) (*fakeS3, *s3FS) {
+	t.Helper()
+	f := &fakeS3{bucket: bucket, objects: map[string][]byte{}, puts: map[string]int{}, copies: map[string]int{}}
+	for key, data := range objects {
+		f.objects[key] = data
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	fsys, err := newS3FS(server.URL, "us-east-1", bucket)
+	require.NoError(t, err)
+	return f, fsys
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodHead, r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start, end int
+			fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			end = min(end, len(data)-1)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		_, srcKey, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+		data, ok := f.objects[srcKey]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[key] = data
+		f.copies[key]++
+		fmt.Fprintf(w, `<CopyObjectResult><LastModified>%s</LastModified><ETag>"etag"</ETag></CopyObjectResult>`, time.Unix(0, 0).UTC().Format(time.RFC3339))
+	case r.Method == http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			body = decodeAWSChunked(body)
+		}
+		f.objects[key] = body
+		f.puts[key]++
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	type object struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		KeyCount       int
+		IsTruncated    bool
+		Contents       []object
+		CommonPrefixes []commonPrefix
+	}{Name: f.bucket, Prefix: q.Get("prefix")}
+
+	keys := []string{}
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := map[string]bool{}
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, result.Prefix)
+		if !ok {
+			continue
+		}
+		if d := q.Get("delimiter"); d != "" {
+			if dir, _, found := strings.Cut(rest, d); found {
+				if !seen[dir] {
+					seen[dir] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{result.Prefix + dir + d})
+				}
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, object{Key: key, Size: len(f.objects[key]), LastModified: time.Unix(0, 0).UTC().Format(time.RFC3339)})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// decodeAWSChunked strips the signatures from a streaming signed upload.
+func decodeAWSChunked(body []byte) []byte {
+	out := []byte{}
+	r := bufio.NewReader(bytes.NewReader(body))
+	for {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return out
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 {
+			return out
+		}
+		chunk := make([]byte, size)
+		io.ReadFull(r, chunk)
+		out = append(out, chunk...)
+		r.ReadString('\n')
+	}
+}
+
+func Test_s3FS(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), remoteHeadSize/5)
+	_, fsys := newFakeS3(t, "comics", filenameBytes{
+		"library/a.cbr":          []byte("rar"),
+		"library/a/001.jpg":      []byte("page"),
+		"library/big.cbr":        big,
+		"library/nested/b/c.cbr": []byte("deep"),
+	})
+
+	entries, err := fs.ReadDir(fsys, "library")
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, fmt.Sprintf("%s %v", e.Name(), e.IsDir()))
+	}
+	require.Equal(t, []string{"a true", "a.cbr false", "big.cbr false", "nested true"}, names)
+
+	files, err := findFiles(fsys, "/library")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/library/a/001.jpg", "/library/a.cbr", "/library/big.cbr", "/library/nested/b/c.cbr"}, files)
+
+	info, err := fs.Stat(fsys, "library/nested")
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+	_, err = fs.Stat(fsys, "library/missing")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// reads within the head and past it
+	f, err := fsys.Open("library/big.cbr")
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = f.(io.ReaderAt).ReadAt(buf, 20)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(buf))
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, big, data)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "library/a.cbz", []byte("zip"), 0o644))
+	data, err = fs.ReadFile(fsys, "library/a.cbz")
+	require.NoError(t, err)
+	require.Equal(t, "zip", string(data))
+
+	_, err = hackpadfs.OpenFile(fsys, "library/a.cbz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	require.ErrorIs(t, err, fs.ErrExist)
+
+	require.NoError(t, hackpadfs.Rename(fsys, "library/a.cbz", "library/renamed.cbz"))
+	_, err = fs.Stat(fsys, "library/a.cbz")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.ErrorIs(t, hackpadfs.Remove(fsys, "library/a.cbz"), fs.ErrNotExist)
+}
+
+func Test_convertS3(t *testing.T) {
+	server, fsys := newFakeS3(t, "comics", filenameBytes{
+		"library/test.cbr":   realCBRContents,
+		"library/is-zip.cbr": notrealCBRContents,
+	})
+
+	c := &converter{fs: fsys, logger: testLogger{t}, verify: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+	require.Len(t, c.converted, 2)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	require.Contains(t, server.objects, "library/test.cbz")
+	require.NotContains(t, server.objects, "library/test.cbr")
+	// each uploaded once under a temporary name, then moved into place,
+	// the zip in disguise too as it is repacked
+	uploads := 0
+	for key, n := range server.puts {
+		require.NotContains(t, server.objects, key)
+		uploads += n
+	}
+	require.Equal(t, 2, uploads)
+	require.Equal(t, 1, server.copies["library/test.cbz"])
+	require.Equal(t, 1, server.copies["library/is-zip.cbz"])
+	require.NotEqual(t, notrealCBRContents, server.objects["library/is-zip.cbz"])
+	require.NotContains(t, server.objects, "library/is-zip.cbr")
+}
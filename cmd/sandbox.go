@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const sandboxConfigEnv = "CBR2CBZ_SANDBOX_CONFIG"
+
+// sandboxConfig is everything the sandboxed worker needs to repack a file
+// the same way the parent would have.
+type sandboxConfig struct {
+	Options  cbr2cbz.Options `json:"options"`
+	MaxEntry uint64          `json:"max_entry"`
+	MaxRatio float64         `json:"max_ratio"`
+}
+
+// sandboxWorkerCmd repacks a single archive it is handed by a parent
+// convert --sandbox. It never touches the filesystem itself: the source
+// arrives as an inherited read only file and the zip goes to stdout.
+var sandboxWorkerCmd = &cobra.Command{
+	Use:    "sandbox-worker [name]",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", 0)
+
+		err := runSandboxWorker(cmd.Context(), args[0], logger)
+		if err != nil {
+			logger.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sandboxWorkerCmd)
+}
+
+// sandboxEnvKeep is what the worker gets of our environment. Tokens and
+// credentials for the backends, webhooks and lookups stay out of its reach.
+var sandboxEnvKeep = []string{"PATH", "TMPDIR", "TEMP", "TMP", "SYSTEMROOT"}
+
+// sandboxEnv is the environment the worker is started with: the few
+// variables it needs from ours and its config.
+func sandboxEnv(config string) []string {
+	env := []string{}
+	for _, key := range sandboxEnvKeep {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	return append(env, sandboxConfigEnv+"="+config)
+}
+
+func runSandboxWorker(ctx context.Context, name string, logger logger) error {
+	config := sandboxConfig{}
+	err := json.Unmarshal([]byte(os.Getenv(sandboxConfigEnv)), &config)
+	if err != nil {
+		return errors.Wrap(err, "reading sandbox config")
+	}
+
+	src, err := openSandboxSource()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stating source")
+	}
+
+	err = restrictSandboxWorker()
+	if err != nil {
+		return errors.Wrap(err, "restricting sandbox")
+	}
+
+	c, err := cbr2cbz.New(config.Options)
+	if err != nil {
+		return errors.Wrap(err, "reading sandbox config")
+	}
+	c.Logger = logger
+	c.Limits = cbr2cbz.Limits{MaxEntry: config.MaxEntry, MaxRatio: config.MaxRatio}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = c.Repack(ctx, name, src, info.Size(), out, &cbr2cbz.Progress{})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(out.Flush(), "writing zip")
+}
+
+// repackInSandbox does what repack does, but in a child copy of this binary
+// running with as few privileges as the platform lets us drop.
+func (c *converter) repackInSandbox(ctx context.Context, cbrFile string, dst io.Writer) error {
+	osFS, ok := c.fs.(interface {
+		ToOSPath(string) (string, error)
+	})
+	if !ok {
+		return errors.New("--sandbox only works on the local filesystem")
+	}
+	osPath, err := osFS.ToOSPath(pathToFsPath(cbrFile))
+	if err != nil {
+		return errors.Wrap(err, "resolving path")
+	}
+
+	src, err := os.Open(osPath)
+	if err != nil {
+		return errors.Wrap(err, "trying to open cbr")
+	}
+	defer src.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "finding our own executable")
+	}
+
+	config, err := json.Marshal(sandboxConfig{
+		Options:  c.packer().Options(),
+		MaxEntry: c.packer().Limits.MaxEntry,
+		MaxRatio: c.packer().Limits.MaxRatio,
+	})
+	if err != nil {
+		return errors.Wrap(err, "encoding sandbox config")
+	}
+
+	cmd := exec.CommandContext(ctx, exe, "sandbox-worker", cbrFile)
+	cmd.Env = sandboxEnv(string(config))
+	cmd.Stdout = dst
+	cmd.SysProcAttr = sandboxSysProcAttr()
+	passSandboxSource(cmd, src, osPath)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrap(err, "capturing sandbox output")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "starting sandbox")
+	}
+
+	release, err := confineSandbox(cmd)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.Wrap(err, "confining sandbox")
+	}
+	defer release()
+
+	var (
+		wg       sync.WaitGroup
+		lastLine string
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			lastLine = scanner.Text()
+			c.logFor(cbrFile).Printf("[sandbox] %s\n", lastLine)
+		}
+	}()
+	wg.Wait()
+
+	err = cmd.Wait()
+	if err != nil {
+		if lastLine != "" {
+			return errors.Errorf("sandboxed conversion failed: %s", lastLine)
+		}
+		return errors.Wrap(err, "sandboxed conversion failed")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const sandboxSupported = true
+
+// sandboxUser is who the worker runs as when we're started as root, nobody
+// on most systems.
+const sandboxUser = 65534
+
+func sandboxSysProcAttr() *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if os.Geteuid() == 0 {
+		attr.Credential = &syscall.Credential{Uid: sandboxUser, Gid: sandboxUser}
+	}
+	return attr
+}
+
+func confineSandbox(_ *exec.Cmd) (func(), error) {
+	return func() {}, nil
+}
+
+// restrictSandboxWorker locks the worker down once it has everything it
+// needs open: no core dumps, no creating or growing files and only a
+// handful of descriptors. There's no PR_SET_NO_NEW_PRIVS here, and unless
+// we were started as root the worker can still read whatever the user can.
+func restrictSandboxWorker() error {
+	limits := map[int]uint64{
+		unix.RLIMIT_CORE:   0,
+		unix.RLIMIT_FSIZE:  0,
+		unix.RLIMIT_NOFILE: 32,
+	}
+	for resource, limit := range limits {
+		err := unix.Setrlimit(resource, &unix.Rlimit{Cur: limit, Max: limit})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+const restrictedChildEnv = "CBR2CBZ_TEST_RESTRICTED_CHILD"
+
+// Test_restrictSandboxWorker runs itself again as a stand in for the
+// worker, restricts that and reports what it could still do.
+func Test_restrictSandboxWorker(t *testing.T) {
+	if seccompArch == 0 {
+		t.Skip("no syscall filter for this architecture")
+	}
+	if os.Getenv(restrictedChildEnv) != "" {
+		restrictedChild()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_restrictSandboxWorker$")
+	cmd.Env = append(os.Environ(), restrictedChildEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Equal(t, `restricted
+open: operation not permitted
+socket: operation not permitted
+exec: operation not permitted
+repack: ok
+`, string(out))
+}
+
+func restrictedChild() {
+	defer os.Exit(0)
+	err := restrictSandboxWorker()
+	if err != nil {
+		fmt.Println("restrict:", err)
+		return
+	}
+	fmt.Println("restricted")
+
+	_, err = os.Open("/etc/passwd")
+	fmt.Println("open:", errorOnly(err))
+	_, err = net.Dial("tcp", "127.0.0.1:80")
+	fmt.Println("socket:", errorOnly(err))
+	err = exec.Command("/bin/true").Run()
+	fmt.Println("exec:", errorOnly(err))
+
+	c, err := cbr2cbz.New(cbr2cbz.Options{})
+	if err == nil {
+		err = c.Repack(context.Background(), "test.cbr", bytes.NewReader(realCBRContents), int64(len(realCBRContents)), io.Discard, &cbr2cbz.Progress{})
+	}
+	if err == nil {
+		fmt.Println("repack: ok")
+	} else {
+		fmt.Println("repack:", err)
+	}
+}
+
+// errorOnly is the innermost error's text, without the op and path the os
+// and net packages wrap it in.
+func errorOnly(err error) string {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			if err == nil {
+				return "no error"
+			}
+			return err.Error()
+		}
+		err = next
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package cmd
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// sandboxSupported is false here, there is nothing to restrict the worker
+// with, so --sandbox is refused rather than running it unconfined.
+const sandboxSupported = false
+
+func sandboxSysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func confineSandbox(_ *exec.Cmd) (func(), error) {
+	return nil, errors.Errorf("--sandbox isn't supported on %s", runtime.GOOS)
+}
+
+func restrictSandboxWorker() error {
+	return errors.Errorf("--sandbox isn't supported on %s", runtime.GOOS)
+}
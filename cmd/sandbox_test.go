@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sandboxEnv(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("TMPDIR", "/tmp/cbr2cbz")
+	t.Setenv("CBR2CBZ_TOKEN", "secret")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	env := sandboxEnv(`{"options":{}}`)
+	require.Contains(t, env, "PATH=/usr/bin")
+	require.Contains(t, env, "TMPDIR=/tmp/cbr2cbz")
+	require.Contains(t, env, sandboxConfigEnv+`={"options":{}}`)
+	for _, kv := range env {
+		require.NotContains(t, kv, "secret")
+	}
+}
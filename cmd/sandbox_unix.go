@@ -0,0 +1,26 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// sandboxSourceFd is where the inherited source file shows up in the worker,
+// ExtraFiles start after stdin, stdout and stderr.
+const sandboxSourceFd = 3
+
+func passSandboxSource(cmd *exec.Cmd, src *os.File, _ string) {
+	cmd.ExtraFiles = []*os.File{src}
+}
+
+func openSandboxSource() (*os.File, error) {
+	f := os.NewFile(sandboxSourceFd, "source")
+	if f == nil {
+		return nil, errors.New("no source file passed to sandbox")
+	}
+	return f, nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+const sandboxSourceEnv = "CBR2CBZ_SANDBOX_SOURCE"
+
+const sandboxSupported = true
+
+// ntResumeProcess resumes every thread of a process, the only way to resume
+// one os/exec started suspended, as it doesn't hand out the thread handle.
+var ntResumeProcess = windows.NewLazySystemDLL("ntdll.dll").NewProc("NtResumeProcess")
+
+// Windows can't hand extra handles to a child through os/exec, so the worker
+// gets the path and opens it read only itself.
+func passSandboxSource(cmd *exec.Cmd, _ *os.File, osPath string) {
+	cmd.Env = append(cmd.Env, sandboxSourceEnv+"="+osPath)
+}
+
+func openSandboxSource() (*os.File, error) {
+	name := os.Getenv(sandboxSourceEnv)
+	if name == "" {
+		return nil, errors.New("no source file passed to sandbox")
+	}
+	return os.Open(name)
+}
+
+func sandboxSysProcAttr() *syscall.SysProcAttr {
+	// suspended until confineSandbox has it in the job object
+	return &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP | windows.CREATE_SUSPENDED}
+}
+
+// confineSandbox puts the worker, started suspended, in a job object that
+// can't start any more processes and gets killed along with us, and only
+// then lets it run. That is all the confinement there is on Windows: the
+// worker keeps the user's token, so it can still read and write whatever
+// the user can.
+func confineSandbox(cmd *exec.Cmd) (func(), error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating job object")
+	}
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE |
+				windows.JOB_OBJECT_LIMIT_ACTIVE_PROCESS |
+				windows.JOB_OBJECT_LIMIT_DIE_ON_UNHANDLED_EXCEPTION,
+			ActiveProcessLimit: 1,
+		},
+	}
+	_, err = windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	if err != nil {
+		windows.CloseHandle(job)
+		return nil, errors.Wrap(err, "limiting job object")
+	}
+
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE|windows.PROCESS_SUSPEND_RESUME, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return nil, errors.Wrap(err, "opening sandbox process")
+	}
+	defer windows.CloseHandle(process)
+
+	err = windows.AssignProcessToJobObject(job, process)
+	if err != nil {
+		windows.CloseHandle(job)
+		return nil, errors.Wrap(err, "assigning job object")
+	}
+	if status, _, _ := ntResumeProcess.Call(uintptr(process)); status != 0 {
+		windows.CloseHandle(job)
+		return nil, errors.Errorf("resuming sandbox process: NTSTATUS %#x", status)
+	}
+
+	return func() { windows.CloseHandle(job) }, nil
+}
+
+func restrictSandboxWorker() error {
+	return nil
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/mholt/archiver/v4"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scanTop      int
+	scanContents bool
+	scanJSON     bool
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [paths...]",
+	Short: "Reports what a library holds, without changing anything",
+	Long: `Walks the paths and reports how many cbr, cbz, cb7, cbt and pdf files there are and
+how much space they take, how much converting would save, the largest files and
+the ones that look wrong: empty, unreadable, named as another format than they
+are, without pages, or holding programs or more archives.
+
+The saving goes by how large past conversions in --history-file came out,
+or without any by what conversion would leave out of the archives. Reading
+the archives' contents can be skipped with --contents=false, for a quick count.
+Nothing is converted or changed.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stderr))
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fsys, args, err = localArgs(fsys, args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		s := &libraryReporter{fs: fsys, contents: scanContents}
+		report, err := s.scan(cmd.Context(), args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		runs, err := readHistory(historyFileName)
+		if err != nil {
+			logger.Println(err)
+		}
+		report.estimateSaving(runs)
+
+		if scanJSON {
+			report.Largest = report.largest(scanTop)
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				logger.Fatal(err)
+			}
+			return
+		}
+		printScan(cmd.OutOrStdout(), report, scanTop)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+
+	scanCmd.Flags().IntVar(&scanTop, "top", 10, "how many of the largest files to list")
+	scanCmd.Flags().BoolVar(&scanContents, "contents", true, "read each archive's list of entries to find suspicious ones, slower on large rars")
+	scanCmd.Flags().BoolVar(&scanJSON, "json", false, "print the report as JSON")
+	scanCmd.Flags().StringVar(&historyFileName, "history-file", defaultHistoryPath(), "file convert adds the totals of each run to, for estimating the saving")
+}
+
+// scannedFormats are the files scan counts, by extension, with the format
+// each should be underneath.
+var scannedFormats = map[string]string{".cbr": ".rar", ".cbz": ".zip", ".cb7": ".7z", ".cbt": ".tar", ".pdf": ""}
+
+// programExtensions are entries no comic should hold.
+var programExtensions = map[string]bool{
+	".exe": true, ".com": true, ".scr": true, ".bat": true, ".cmd": true, ".msi": true, ".dll": true,
+	".lnk": true, ".js": true, ".vbs": true, ".ps1": true, ".sh": true, ".jar": true, ".apk": true,
+}
+
+// nestedExtensions are archives inside an archive, which only
+// --recurse-archives unpacks.
+var nestedExtensions = map[string]bool{".zip": true, ".rar": true, ".7z": true, ".tar": true, ".cbz": true, ".cbr": true, ".cb7": true, ".cbt": true}
+
+type formatTotals struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (t *formatTotals) add(size int64) {
+	t.Files++
+	t.Bytes += size
+}
+
+type scannedFile struct {
+	Path     string   `json:"path"`
+	Size     int64    `json:"size"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// scanReport is what scan found.
+type scanReport struct {
+	// Formats is keyed by extension, cbr, cbz and so on
+	Formats map[string]*formatTotals `json:"formats"`
+	// Convertible is what convert picks up by default
+	Convertible formatTotals `json:"convertible"`
+	// Dropped is the size of the entries conversion would leave out, when
+	// the contents were read
+	Dropped int64 `json:"dropped_bytes"`
+	// SizeRatio is how large past conversions came out, 0 without any
+	SizeRatio       float64       `json:"size_ratio,omitempty"`
+	EstimatedSaving int64         `json:"estimated_saving"`
+	Largest         []scannedFile `json:"largest"`
+	Suspicious      []scannedFile `json:"suspicious"`
+
+	files []scannedFile
+}
+
+// libraryReporter walks a library for scan, only ever reading.
+type libraryReporter struct {
+	fs       hackpadfs.FS
+	contents bool
+}
+
+func (s *libraryReporter) scan(ctx context.Context, paths []string) (*scanReport, error) {
+	exts := []string{}
+	for ext := range scannedFormats {
+		exts = append(exts, ext)
+	}
+	files, err := findArchives(s.fs, paths, "cbr, cbz, cb7, cbt or pdf", exts...)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	convertible, _ := sourceExtensions(defaultSources)
+	filter := cbr2cbz.NewEntryFilter(nil, nil)
+	report := &scanReport{Formats: map[string]*formatTotals{}, Suspicious: []scannedFile{}}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		info, err := fs.Stat(s.fs, pathToFsPath(file))
+		if err != nil {
+			return nil, err
+		}
+		ext := strings.ToLower(filepath.Ext(file))
+		name := strings.TrimPrefix(ext, ".")
+		if report.Formats[name] == nil {
+			report.Formats[name] = &formatTotals{}
+		}
+		report.Formats[name].add(info.Size())
+		if convertible[ext] {
+			report.Convertible.add(info.Size())
+		}
+
+		scanned := scannedFile{Path: file, Size: info.Size()}
+		switch {
+		case info.Size() == 0:
+			scanned.Problems = []string{"empty file"}
+		case s.contents && scannedFormats[ext] != "":
+			var dropped int64
+			scanned.Problems, dropped = s.inspect(ctx, file, scannedFormats[ext], filter)
+			if convertible[ext] {
+				report.Dropped += dropped
+			}
+		}
+		if len(scanned.Problems) > 0 {
+			report.Suspicious = append(report.Suspicious, scanned)
+		}
+		report.files = append(report.files, scanned)
+	}
+	return report, nil
+}
+
+// inspect lists the entries of the archive file, which should be a want
+// underneath, returning what looks wrong with it and the size of the
+// entries conversion would leave out.
+func (s *libraryReporter) inspect(ctx context.Context, file string, want string, filter cbr2cbz.EntryFilter) ([]string, int64) {
+	archive, err := openArchive(ctx, s.fs, pathToFsPath(file))
+	if err != nil {
+		return []string{"can't be read: " + err.Error()}, 0
+	}
+	defer archive.Close()
+
+	problems := []string{}
+	if got := archive.format.(archiver.Format).Name(); got != want {
+		problems = append(problems, fmt.Sprintf("is a %s archive, not the %s its name says", strings.TrimPrefix(got, "."), strings.TrimPrefix(want, ".")))
+	}
+
+	pages := 0
+	var dropped int64
+	err = archive.format.Extract(ctx, archive.stream, nil, func(_ context.Context, f archiver.File) error {
+		if f.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(path.Ext(f.NameInArchive))
+		switch {
+		case programExtensions[ext]:
+			problems = append(problems, "holds a program, "+f.NameInArchive)
+		case nestedExtensions[ext]:
+			problems = append(problems, "holds another archive, "+f.NameInArchive+", see --recurse-archives")
+		}
+		switch filter.Classify(f.NameInArchive) {
+		case cbr2cbz.EntryPage:
+			pages++
+		case cbr2cbz.EntryDropped:
+			dropped += f.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return append(problems, "damaged: "+err.Error()), dropped
+	}
+	if pages == 0 {
+		problems = append(problems, "has no pages")
+	}
+	return problems, dropped
+}
+
+// estimateSaving works out what converting would save, going by how large
+// runs came out if there were any and by what would be dropped otherwise.
+func (r *scanReport) estimateSaving(runs []runRecord) {
+	var in, out int64
+	for _, run := range runs {
+		in += run.BytesIn
+		out += run.BytesOut
+	}
+	if in > 0 {
+		r.SizeRatio = float64(out) / float64(in)
+		r.EstimatedSaving = int64(float64(r.Convertible.Bytes) * (1 - r.SizeRatio))
+		return
+	}
+	r.EstimatedSaving = r.Dropped
+}
+
+// largest are the top n files by size.
+func (r *scanReport) largest(n int) []scannedFile {
+	files := append([]scannedFile{}, r.files...)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	if len(files) > n {
+		files = files[:n]
+	}
+	for i := range files {
+		files[i].Problems = nil
+	}
+	return files
+}
+
+func printScan(w io.Writer, r *scanReport, top int) {
+	names := make([]string, 0, len(r.Formats))
+	for name := range r.Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := formatTotals{}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "Format\tFiles\tSize\t")
+	for _, name := range names {
+		t := r.Formats[name]
+		total.Files += t.Files
+		total.Bytes += t.Bytes
+		fmt.Fprintf(table, "%s\t%s\t%s\t\n", name, formatCount(t.Files), formatBytes(uint64(t.Bytes)))
+	}
+	fmt.Fprintf(table, "Total\t%s\t%s\t\n", formatCount(total.Files), formatBytes(uint64(total.Bytes)))
+	table.Flush()
+
+	fmt.Fprintln(w)
+	switch {
+	case r.Convertible.Files == 0:
+		fmt.Fprintln(w, "Nothing to convert")
+	case r.SizeRatio > 0:
+		fmt.Fprintf(w, "Converting the %s cbr, cb7 and cbt files (%s) would save about %s, going by past conversions coming out at %.0f%% of the original size\n",
+			formatCount(r.Convertible.Files), formatBytes(uint64(r.Convertible.Bytes)), signedBytes(r.EstimatedSaving), r.SizeRatio*100)
+	default:
+		fmt.Fprintf(w, "Converting the %s cbr, cb7 and cbt files (%s) would save about %s by leaving out what isn't pages or kept files\n",
+			formatCount(r.Convertible.Files), formatBytes(uint64(r.Convertible.Bytes)), formatBytes(uint64(r.EstimatedSaving)))
+	}
+
+	if largest := r.largest(top); len(largest) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Largest files:")
+		table = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, f := range largest {
+			fmt.Fprintf(table, "  %s\t %s\n", formatBytes(uint64(f.Size)), f.Path)
+		}
+		table.Flush()
+	}
+
+	if len(r.Suspicious) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%s suspicious files:\n", formatCount(len(r.Suspicious)))
+		for _, f := range r.Suspicious {
+			fmt.Fprintf(w, "  %s: %s\n", f.Path, strings.Join(f.Problems, "; "))
+		}
+	}
+}
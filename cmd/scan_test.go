@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_libraryReporter(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/text.cbr":    realCBRContents,
+		"comics/good.cbz":    makeZip(t, map[string]string{"001.jpg": "page", "ComicInfo.xml": "<ComicInfo/>"}),
+		"comics/renamed.cbr": makeZip(t, map[string]string{"001.jpg": "page", "Thumbs.db": "junk junk"}),
+		"comics/setup.cbz":   makeZip(t, map[string]string{"001.jpg": "page", "setup.exe": "MZ"}),
+		"comics/nested.cbt":  makeTar(t, map[string]string{"ch1.zip": "zip"}),
+		"comics/empty.cb7":   {},
+		"comics/notes.txt":   []byte("not an archive"),
+		"comics/broken.cbz":  []byte("not a zip at all"),
+		"comics/manual.pdf":  []byte("%PDF-1.4"),
+	})
+	require.NoError(t, err)
+
+	s := &libraryReporter{fs: fsys, contents: true}
+	report, err := s.scan(context.Background(), []string{"/comics"})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, report.Formats["cbr"].Files)
+	require.Equal(t, 3, report.Formats["cbz"].Files)
+	require.Equal(t, 1, report.Formats["pdf"].Files)
+	require.Nil(t, report.Formats["txt"])
+	require.Equal(t, 4, report.Convertible.Files)
+	_, cbrDropped := s.inspect(context.Background(), "/comics/text.cbr", ".rar", cbr2cbz.NewEntryFilter(nil, nil))
+	require.Equal(t, cbrDropped+int64(len("junk junk")+len("zip")), report.Dropped)
+
+	problems := map[string][]string{}
+	for _, f := range report.Suspicious {
+		problems[f.Path] = f.Problems
+	}
+	require.Equal(t, map[string][]string{
+		"/comics/broken.cbz": {"can't be read: unable to identify: no formats matched"},
+		"/comics/empty.cb7":  {"empty file"},
+		// the fixture's one entry is a .txt
+		"/comics/text.cbr":    {"has no pages"},
+		"/comics/nested.cbt":  {"holds another archive, ch1.zip, see --recurse-archives", "has no pages"},
+		"/comics/renamed.cbr": {"is a zip archive, not the rar its name says"},
+		"/comics/setup.cbz":   {"holds a program, setup.exe"},
+	}, problems)
+
+	// nothing converted yet, so the saving is what would be left out
+	report.estimateSaving(nil)
+	require.Equal(t, report.Dropped, report.EstimatedSaving)
+	report.estimateSaving([]runRecord{{BytesIn: 1000, BytesOut: 900}})
+	require.InDelta(t, 0.9, report.SizeRatio, 0.001)
+	require.Equal(t, int64(float64(report.Convertible.Bytes)*0.1), report.EstimatedSaving)
+
+	largest := report.largest(2)
+	require.Len(t, largest, 2)
+	require.GreaterOrEqual(t, largest[0].Size, largest[1].Size)
+
+	out := &bytes.Buffer{}
+	printScan(out, report, 3)
+	require.Contains(t, out.String(), "6 suspicious files:")
+	require.Contains(t, out.String(), "going by past conversions coming out at 90% of the original size")
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// schedule is a crontab style schedule, minute hour day-of-month month
+// day-of-week, each field a set of the values it allows.
+type schedule struct {
+	minute, hour, day, month, weekday uint64
+	// as in cron, with both days restricted either one matching will do
+	anyDay, anyWeekday bool
+}
+
+// scheduleAliases are the @ shorthands cron understands.
+var scheduleAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// parseSchedule reads a five field cron expression like "0 3 * * *" or
+// "*/15 9-17 * * 1-5", or one of the @ aliases.
+func parseSchedule(spec string) (*schedule, error) {
+	if alias, ok := scheduleAliases[strings.TrimSpace(spec)]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("invalid schedule %q, expected minute hour day month weekday like \"0 3 * * *\"", spec)
+	}
+
+	s := &schedule{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		name     string
+		field    string
+		min, max int
+		bits     *uint64
+	}{
+		{"minute", fields[0], 0, 59, &s.minute},
+		{"hour", fields[1], 0, 23, &s.hour},
+		{"day", fields[2], 1, 31, &s.day},
+		{"month", fields[3], 1, 12, &s.month},
+		{"weekday", fields[4], 0, 7, &s.weekday},
+	} {
+		*f.bits, err = parseScheduleField(f.field, f.min, f.max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule %q, %s", spec, f.name)
+		}
+	}
+	// 7 is Sunday too
+	if s.weekday&(1<<7) != 0 {
+		s.weekday |= 1
+	}
+	return s, nil
+}
+
+// parseScheduleField reads a comma separated list of *, numbers and ranges,
+// each optionally with a /step.
+func parseScheduleField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, errors.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			from, to, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(from)
+			hi, err2 = strconv.Atoi(to)
+			if err1 != nil || err2 != nil || lo > hi {
+				return 0, errors.Errorf("invalid range %q", rng)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, errors.Errorf("invalid value %q", rng)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				// 5/15 is every 15 from 5 on
+				hi = max
+			}
+		}
+		if lo < min || hi > max {
+			return 0, errors.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << n
+		}
+	}
+	return bits, nil
+}
+
+// next is the first minute after t the schedule matches, in t's location.
+// It is the zero time if there is none, like "0 0 30 2 *".
+func (s *schedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every combination of days comes round within a few years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *schedule) matchesDay(t time.Time) bool {
+	day := s.day&(1<<t.Day()) != 0
+	weekday := s.weekday&(1<<int(t.Weekday())) != 0
+	if !s.anyDay && !s.anyWeekday {
+		return day || weekday
+	}
+	return day && weekday
+}
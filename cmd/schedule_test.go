@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_scheduleNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{spec: "0 3 * * *", next: time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC)},
+		{spec: "@hourly", next: time.Date(2024, 5, 1, 4, 0, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", next: time.Date(2024, 5, 1, 3, 15, 0, 0, time.UTC)},
+		{spec: "30 9-17 * * 1-5", next: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)},
+		{spec: "0 0 * * 0", next: time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", next: time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 12 1,15 * *", next: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{spec: "0 2 1,15 * *", next: time.Date(2024, 5, 15, 2, 0, 0, 0, time.UTC)},
+		// either day will do when both are given, as in cron
+		{spec: "0 12 15 * 5", next: time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", next: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 30 2 *", next: time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			require.NoError(t, err)
+			require.Equal(t, tt.next, s.next(from))
+		})
+	}
+}
+
+func Test_parseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"", "0 3 * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := parseSchedule(spec)
+		require.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// scratchBudget tracks how much temporary disk space in-flight conversions
+// are expected to use and holds back new jobs until there is room for them.
+type scratchBudget struct {
+	limit uint64
+
+	mu    sync.Mutex
+	inUse uint64
+	freed chan struct{}
+}
+
+func newScratchBudget(limit uint64) *scratchBudget {
+	return &scratchBudget{
+		limit: limit,
+		freed: make(chan struct{}),
+	}
+}
+
+// estimateScratch guesses how much space converting a source of the given
+// size will need. Comic pages are already compressed so the cbz ends up
+// roughly the same size as the cbr.
+func estimateScratch(sourceSize uint64) uint64 {
+	return sourceSize
+}
+
+// acquire blocks until n bytes fit within the budget, or the context is done.
+func (b *scratchBudget) acquire(ctx context.Context, n uint64) error {
+	if b == nil || b.limit == 0 {
+		return nil
+	}
+
+	if n > b.limit {
+		return errors.Errorf("needs %s of scratch space but budget is %s", formatBytes(n), formatBytes(b.limit))
+	}
+
+	for {
+		b.mu.Lock()
+		if b.inUse+n <= b.limit {
+			b.inUse += n
+			b.mu.Unlock()
+			return nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release returns n bytes to the budget and wakes up anyone waiting on it.
+func (b *scratchBudget) release(n uint64) {
+	if b == nil || b.limit == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n > b.inUse {
+		n = b.inUse
+	}
+	b.inUse -= n
+
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_scratchBudget(t *testing.T) {
+	b := newScratchBudget(100)
+
+	require.NoError(t, b.acquire(context.Background(), 60))
+	require.Error(t, b.acquire(context.Background(), 101), "bigger than the whole budget")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, b.acquire(ctx, 50), context.DeadlineExceeded, "should wait for space")
+
+	done := make(chan error)
+	go func() { done <- b.acquire(context.Background(), 50) }()
+	b.release(60)
+	require.NoError(t, <-done)
+}
+
+func Test_scratchBudget_unlimited(t *testing.T) {
+	var b *scratchBudget
+	require.NoError(t, b.acquire(context.Background(), 1<<40))
+	b.release(1 << 40)
+}
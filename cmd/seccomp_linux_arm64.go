@@ -0,0 +1,10 @@
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// seccompArch is the architecture installSeccomp's filter is written for.
+const seccompArch = unix.AUDIT_ARCH_AARCH64
+
+// seccompArchSyscalls are allowed in the worker on top of seccompSyscalls,
+// ones only this architecture has.
+var seccompArchSyscalls = []uintptr{}
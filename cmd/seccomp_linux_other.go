@@ -0,0 +1,9 @@
+//go:build linux && !amd64 && !arm64
+
+package cmd
+
+// seccompArch is zero where there is no syscall filter for the
+// architecture yet, the worker then only gets the rest of its restrictions.
+const seccompArch = 0
+
+var seccompArchSyscalls = []uintptr{}
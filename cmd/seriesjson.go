@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+const seriesJSONName = "series.json"
+
+// seriesFolderPattern matches the common "Series Name (2012)" folder naming.
+var seriesFolderPattern = regexp.MustCompile(`^(.*?)\s*\((\d{4})\)\s*$`)
+
+// seriesJSON is the series.json format Mylar writes and Kavita/Komga read.
+type seriesJSON struct {
+	Version  string         `json:"version"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+// writeSeriesJSON creates or updates series.json in every folder that had a
+// cbz written to it. Values already in an existing series.json win, we only
+// fill in what's missing.
+func (c *converter) writeSeriesJSON(cbzFiles []string) {
+	byDir := map[string][]string{}
+	for _, file := range cbzFiles {
+		dir := path.Dir(pathToFsPath(file))
+		byDir[dir] = append(byDir[dir], file)
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		err := c.updateSeriesJSON(dir, byDir[dir])
+		if err != nil {
+			c.warn(cbr2cbz.CodeSeriesJSONFailed, "", "Unable to write %s in %s: %s", seriesJSONName, dir, err.Error())
+		}
+	}
+}
+
+func (c *converter) updateSeriesJSON(dir string, cbzFiles []string) error {
+	name := path.Join(dir, seriesJSONName)
+
+	series := seriesJSON{Version: "1.0.2", Metadata: map[string]any{}}
+	data, err := hackpadfs.ReadFile(c.fs, name)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &series); err != nil {
+			return errors.Wrap(err, "parsing existing series.json")
+		}
+		if series.Metadata == nil {
+			series.Metadata = map[string]any{}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return errors.Wrap(err, "reading existing series.json")
+	}
+
+	found := seriesMetadata(dir, c.comicInfos(cbzFiles))
+	changed := false
+	for key, value := range found {
+		if existing, ok := series.Metadata[key]; ok && existing != nil && existing != "" {
+			continue
+		}
+		series.Metadata[key] = value
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	out, err := json.MarshalIndent(series, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding series.json")
+	}
+	err = hackpadfs.WriteFullFile(c.fs, name, append(out, '\n'), 0644)
+	if err != nil {
+		return errors.Wrap(err, "writing series.json")
+	}
+
+	c.logger.Printf("Updated %s\n", "/"+name)
+	return nil
+}
+
+// comicInfos reads the ComicInfo.xml out of each cbz, skipping any without.
+func (c *converter) comicInfos(cbzFiles []string) []*cbr2cbz.ComicInfo {
+	infos := []*cbr2cbz.ComicInfo{}
+	for _, file := range cbzFiles {
+		r, f, err := openZip(c.fs, pathToFsPath(file))
+		if err != nil {
+			continue
+		}
+		info, err := readZipComicInfo(r)
+		f.Close()
+		if err == nil && info.Series != "" {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// seriesMetadata builds series.json metadata from the archives' ComicInfo,
+// falling back to parsing the folder name.
+func seriesMetadata(dir string, infos []*cbr2cbz.ComicInfo) map[string]any {
+	meta := map[string]any{"type": "comicSeries"}
+
+	folder := path.Base(dir)
+	if m := seriesFolderPattern.FindStringSubmatch(folder); m != nil {
+		meta["name"] = m[1]
+		meta["year"], _ = strconv.Atoi(m[2])
+	} else if folder != "." && folder != "/" {
+		meta["name"] = folder
+	}
+
+	for _, info := range infos {
+		setIfEmpty(meta, "name", info.Series)
+		setIfEmpty(meta, "publisher", info.Publisher)
+		setIfEmpty(meta, "description_text", strings.TrimSpace(info.Summary))
+		if info.Year > 0 {
+			setIfEmpty(meta, "year", info.Year)
+		}
+		if info.Volume > 0 {
+			setIfEmpty(meta, "volume", info.Volume)
+		}
+		if info.Count > 0 {
+			setIfEmpty(meta, "total_issues", info.Count)
+		}
+	}
+	return meta
+}
+
+func setIfEmpty(meta map[string]any, key string, value any) {
+	if value == "" {
+		return
+	}
+	if _, ok := meta[key]; !ok {
+		meta[key] = value
+	}
+}
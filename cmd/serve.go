@@ -0,0 +1,649 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/hack-pad/hackpadfs"
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr         string
+	serveToken        string
+	serveUploadDir    string
+	serveMaxUpload    string
+	serveQueue        int
+	serveDrainTimeout time.Duration
+	serveWebUI        bool
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves a REST API for submitting conversions remotely",
+	Long: `Serves a small REST API so media servers and scripts can start conversions
+without a shell on the box. Jobs run one at a time, in the order they were
+submitted, with the convert flags serve was started with.
+
+  POST   /jobs             {"paths": [...]} as application/json converts paths
+                           on this machine
+  POST   /uploads?name=... converts the archive in the body
+  GET    /jobs             lists jobs
+  GET    /jobs/{id}        a job's status and, once finished, its summary
+  GET    /jobs/{id}/log    a job's log
+  GET    /jobs/{id}/result the cbz of an upload
+  DELETE /jobs/{id}        forgets a finished job and removes its upload
+
+Unless --web-ui=false, / is a page to drop archives on from a browser, follow
+their conversion and download the cbz files, for anyone the command line
+isn't for.
+
+/livez, /healthz and /readyz are served too, without a token, and
+GET /status answers with the number of jobs queued or running and when the
+last one succeeded and failed. With --token, or
+CBR2CBZ_TOKEN, every other request needs an "Authorization: Bearer <token>"
+header. It only listens on this machine unless --addr says otherwise, which
+needs a token; use --root to keep submitted paths inside the library.
+Requests from web pages on other sites are refused, and so are requests for
+any host but localhost while there is no token.
+
+On SIGINT/SIGTERM no new jobs are started, the running one gets
+--drain-timeout to finish and queued ones are dropped.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		s, err := newServeServer(cmd, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if s.token == "" && !isLoopbackAddr(serveAddr) {
+			logger.Fatalf("serving on %s lets anyone who can reach it convert and delete files, set --token or CBR2CBZ_TOKEN", serveAddr)
+		}
+
+		health := newHealthState(0)
+		work, hard, stop := drainOnSignal(cmd.Context(), health, serveDrainTimeout)
+		defer stop()
+
+		httpServer := s.listen(serveAddr, health)
+		s.run(work, hard)
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	},
+}
+
+// newServeServer sets up logging and the server from the serve flags.
+func newServeServer(cmd *cobra.Command, logger *log.Logger) (*server, error) {
+	logFile, err := openLogFile(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	out := io.MultiWriter(consoleOutput(os.Stdout), logFileOutput(logFile))
+	logger.SetOutput(out)
+
+	if serveToken == "" {
+		serveToken = os.Getenv(envPrefix + "TOKEN")
+	}
+	maxUpload, err := humanize.ParseBytes(serveMaxUpload)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing --max-upload")
+	}
+	uploadDir, err := filepath.Abs(serveUploadDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving --upload-dir")
+	}
+	// fail now rather than on the first job
+	if _, err := newConverter(logger); err != nil {
+		return nil, err
+	}
+
+	s := newServer(logger, out, hackpados.NewFS(), uploadDir)
+	s.token = serveToken
+	s.maxUpload = int64(maxUpload)
+	s.webUI = serveWebUI
+	s.queue = make(chan *serveJob, serveQueue)
+	s.newConverter = jobConverter(effectiveOptions(cmd.Flags()))
+	return s, nil
+}
+
+// listen serves the api and health's endpoints on addr in the background.
+func (s *server) listen(addr string, health *healthState) *http.Server {
+	mux := http.NewServeMux()
+	s.health = health
+	health.register(mux)
+	mux.Handle("GET /status", s.authorize(health.statusHandler()))
+	mux.Handle("/", s.handler())
+	httpServer := &http.Server{Addr: addr, Handler: s.sameOrigin(mux)}
+	go func() {
+		err := httpServer.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	s.logger.Printf("Serving on %s\n", addr)
+	health.setReady(true, "")
+	return httpServer
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().AddFlagSet(convertCmd.Flags())
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "address to serve the API on, any but a loopback one needs --token")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "bearer token every request needs, CBR2CBZ_TOKEN is used if not set")
+	serveCmd.Flags().StringVar(&serveUploadDir, "upload-dir", filepath.Join(os.TempDir(), "cbr2cbz-uploads"), "directory uploads are converted in and kept until their job is deleted")
+	serveCmd.Flags().StringVar(&serveMaxUpload, "max-upload", "2GB", "largest archive that can be uploaded")
+	serveCmd.Flags().IntVar(&serveQueue, "queue", 100, "how many jobs can wait to run before new ones are refused")
+	serveCmd.Flags().DurationVar(&serveDrainTimeout, "drain-timeout", time.Minute, "how long the running job may take to finish after SIGINT/SIGTERM")
+	serveCmd.Flags().BoolVar(&serveWebUI, "web-ui", true, "serve a page on / to drop archives on and download the cbz files from")
+}
+
+// isLoopbackAddr reports whether addr only listens on this machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isLoopbackHost(host)
+}
+
+// isLoopbackHost reports whether host, with or without a port, names this
+// machine.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// jobConverter builds the converter of each job from the convert flags,
+// recording its runs and crashes like convert does.
+func jobConverter(settings []effectiveOption) func(logger) (*converter, error) {
+	return func(l logger) (*converter, error) {
+		c, err := newConverter(l)
+		if err != nil {
+			return nil, err
+		}
+		c.settings = settings
+		c.historyPath = historyFileName
+		c.crashDir = crashDir
+		return c, nil
+	}
+}
+
+// Job statuses, in the order a job goes through them.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	// jobFailed is for jobs that stopped with an error or where any file
+	// failed, the summary says which
+	jobFailed = "failed"
+)
+
+// serveJob is one conversion submitted to serve.
+type serveJob struct {
+	ID        string        `json:"id"`
+	Status    string        `json:"status"`
+	Paths     []string      `json:"paths"`
+	Upload    bool          `json:"upload,omitempty"`
+	Submitted time.Time     `json:"submitted"`
+	Started   *time.Time    `json:"started,omitempty"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+	Summary   *batchSummary `json:"summary,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	// Progress is how far along the file being converted is, from 0 to 1
+	Progress float64 `json:"progress,omitempty"`
+
+	// result is the cbz an upload was converted to
+	result string
+	log    *syncBuffer
+	// current is the progress of the file being converted
+	current *cbr2cbz.Progress
+}
+
+// snapshot is a copy of j to answer with, taken under the server's lock.
+func (j *serveJob) snapshot() serveJob {
+	view := *j
+	if j.Status == jobRunning && j.current != nil {
+		view.Progress = j.current.Fraction()
+	}
+	return view
+}
+
+// syncBuffer is a bytes.Buffer a job can log to while its log is read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// server runs the jobs submitted through its handler one at a time.
+type server struct {
+	logger logger
+	// logOut is where job logs go besides the job itself
+	logOut io.Writer
+	// newConverter builds the converter for a job
+	newConverter func(logger) (*converter, error)
+	// uploads is the filesystem uploadDir is on
+	uploads   hackpadfs.FS
+	uploadDir string
+	token     string
+	maxUpload int64
+	queue     chan *serveJob
+	// webUI is whether the drag and drop page is served on /
+	webUI bool
+	// health is told how many jobs are waiting and how they went, nil
+	// until listen
+	health *healthState
+
+	mu   sync.Mutex
+	jobs map[string]*serveJob
+	// order is the ids of jobs, oldest first
+	order []string
+}
+
+func newServer(l logger, logOut io.Writer, uploads hackpadfs.FS, uploadDir string) *server {
+	return &server{
+		logger:    l,
+		logOut:    logOut,
+		uploads:   uploads,
+		uploadDir: uploadDir,
+		queue:     make(chan *serveJob, 100),
+		jobs:      map[string]*serveJob{},
+	}
+}
+
+func (s *server) handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("POST /jobs", s.submitPaths)
+	api.HandleFunc("POST /uploads", s.submitUpload)
+	api.HandleFunc("GET /jobs", s.listJobs)
+	api.HandleFunc("GET /jobs/{id}", s.getJob)
+	api.HandleFunc("GET /jobs/{id}/log", s.getLog)
+	api.HandleFunc("GET /jobs/{id}/result", s.getResult)
+	api.HandleFunc("DELETE /jobs/{id}", s.deleteJob)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", s.authorize(api))
+	if s.webUI {
+		// the page itself holds nothing, it asks for the token when the api
+		// wants one
+		mux.HandleFunc("GET /{$}", serveWebPage)
+	}
+	return mux
+}
+
+// authorize turns away requests without the bearer token, if there is one.
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin turns away what a web page on another site can get a browser
+// to send: requests from another origin and, without a token, those for a
+// host name that isn't this machine, which is how DNS rebinding gets past
+// the browser.
+func (s *server) sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" && !isLoopbackHost(r.Host) {
+			http.Error(w, "only served as localhost without a token", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(u.Host, r.Host) {
+				http.Error(w, "cross-origin requests aren't allowed", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) submitPaths(w http.ResponseWriter, r *http.Request) {
+	// a form on another site can post text/plain but not json
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req struct {
+		Paths []string `json:"paths"`
+	}
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req)
+	if err != nil {
+		http.Error(w, "expected {\"paths\": [...]}: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) == 0 {
+		http.Error(w, "no paths to convert", http.StatusBadRequest)
+		return
+	}
+	job, err := newServeJob(req.Paths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.submit(w, job)
+}
+
+// submitUpload saves the archive in the body under its own folder in
+// uploadDir and queues converting it.
+func (s *server) submitUpload(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(filepath.ToSlash(r.URL.Query().Get("name")))
+	if name == "." || name == "/" || name == ".." {
+		http.Error(w, "name the upload with ?name=", http.StatusBadRequest)
+		return
+	}
+	job, err := newServeJob(nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	dir := path.Join(pathToFsPath(s.uploadDir), job.ID)
+	err = hackpadfs.MkdirAll(s.uploads, dir, 0o755)
+	if err == nil {
+		err = s.saveUpload(path.Join(dir, name), http.MaxBytesReader(w, r.Body, s.maxUpload))
+	}
+	if err != nil {
+		hackpadfs.RemoveAll(s.uploads, dir)
+		status := http.StatusInternalServerError
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	job.Paths, job.Upload = []string{"/" + dir}, true
+	s.submit(w, job)
+}
+
+func (s *server) saveUpload(name string, body io.Reader) error {
+	f, err := hackpadfs.Create(s.uploads, name)
+	if err != nil {
+		return errors.Wrap(err, "saving upload")
+	}
+	dst, ok := f.(io.Writer)
+	if !ok {
+		f.Close()
+		return errors.New("upload directory isn't writable")
+	}
+	_, err = io.Copy(dst, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "saving upload")
+}
+
+func newServeJob(paths []string) (*serveJob, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "making a job id")
+	}
+	return &serveJob{ID: hex.EncodeToString(id), Status: jobQueued, Paths: paths, Submitted: time.Now().UTC(), log: &syncBuffer{}}, nil
+}
+
+// submit queues job and answers with it, or refuses it if the queue is full.
+func (s *server) submit(w http.ResponseWriter, job *serveJob) {
+	s.mu.Lock()
+	select {
+	case s.queue <- job:
+	default:
+		s.mu.Unlock()
+		if job.Upload {
+			hackpadfs.RemoveAll(s.uploads, pathToFsPath(job.Paths[0]))
+		}
+		http.Error(w, "too many jobs waiting, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	view := job.snapshot()
+	s.health.setQueued(s.pending())
+	s.mu.Unlock()
+
+	s.logger.Printf("Queued job %s for %s\n", job.ID, strings.Join(job.Paths, ", "))
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, &view)
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]serveJob, 0, len(s.order))
+	for _, id := range s.order {
+		view := s.jobs[id].snapshot()
+		// the list stays small, the summary is at /jobs/{id}
+		view.Summary = nil
+		jobs = append(jobs, view)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// lookup finds the job r is about, answering 404 if there is none.
+func (s *server) lookup(w http.ResponseWriter, r *http.Request) (*serveJob, serveJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return nil, serveJob{}, false
+	}
+	return job, job.snapshot(), true
+}
+
+func (s *server) getJob(w http.ResponseWriter, r *http.Request) {
+	if _, view, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, &view)
+	}
+}
+
+func (s *server) getLog(w http.ResponseWriter, r *http.Request) {
+	job, _, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(job.log.Bytes())
+}
+
+func (s *server) getResult(w http.ResponseWriter, r *http.Request) {
+	_, view, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case !view.Upload:
+		http.Error(w, "the results of path jobs are where they were converted, see the summary", http.StatusNotFound)
+		return
+	case view.Status == jobQueued || view.Status == jobRunning:
+		http.Error(w, "job is "+view.Status, http.StatusConflict)
+		return
+	case view.result == "":
+		http.Error(w, "the upload wasn't converted, see the summary", http.StatusNotFound)
+		return
+	}
+	f, err := s.uploads.Open(pathToFsPath(view.result))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/vnd.comicbook+zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(path.Base(view.result), `"`, "")+`"`)
+	io.Copy(w, f)
+}
+
+func (s *server) deleteJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	job, ok := s.jobs[id]
+	switch {
+	case !ok:
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	case job.Status == jobQueued || job.Status == jobRunning:
+		http.Error(w, "job is "+job.Status, http.StatusConflict)
+		return
+	}
+	if job.Upload {
+		err := hackpadfs.RemoveAll(s.uploads, pathToFsPath(job.Paths[0]))
+		if err != nil {
+			http.Error(w, errors.Wrap(err, "removing upload").Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	delete(s.jobs, id)
+	for i, queued := range s.order {
+		if queued == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// run works through the queue until work is cancelled. The job running then
+// is given until hard is cancelled.
+func (s *server) run(work context.Context, hard context.Context) {
+	for {
+		select {
+		case <-work.Done():
+			return
+		case job := <-s.queue:
+			s.runJob(hard, job)
+		}
+	}
+}
+
+func (s *server) runJob(ctx context.Context, job *serveJob) {
+	s.mu.Lock()
+	started := time.Now().UTC()
+	job.Status, job.Started = jobRunning, &started
+	s.health.setQueued(s.pending())
+	s.mu.Unlock()
+
+	logger := log.New(io.MultiWriter(s.logOut, logFileOutput(job.log)), "", log.LstdFlags)
+	logger.Printf("Starting job %s\n", job.ID)
+	c, err := s.newConverter(logger)
+	paths := job.Paths
+	if err == nil && job.Upload {
+		// converted where it was uploaded, whatever the flags say about
+		// where other files go
+		c.fs = s.uploads
+		c.outputDir, c.backupDir, c.trash, c.keep = "", "", false, false
+		c.claims, c.readingList = nil, nil
+	} else if err == nil {
+		paths, err = c.useRemote(paths)
+		if err == nil {
+			// relative to where serve runs, like the paths given to convert
+			paths, err = c.localInputs(paths)
+		}
+	}
+	if err == nil {
+		c.onStart = func(_ string, p *cbr2cbz.Progress) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			job.current = p
+		}
+	}
+
+	var summary *batchSummary
+	status := jobFailed
+	if err == nil {
+		err = c.runConvert(ctx, paths)
+		sum := c.summary(paths, err)
+		summary = &sum
+		if c.exitCode(err) == exitOK {
+			status = jobDone
+		}
+	}
+	if err != nil {
+		logger.Printf("Job %s failed: %s\n", job.ID, err.Error())
+	}
+	switch {
+	case err != nil:
+		s.health.record("job "+job.ID, err)
+	case status == jobFailed:
+		s.health.record("job "+job.ID, errors.New("failed, see its summary"))
+	default:
+		s.health.record("job "+job.ID, nil)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now().UTC()
+	job.Status, job.Finished, job.Summary, job.current = status, &finished, summary, nil
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if job.Upload && c != nil && len(c.converted) == 1 {
+		job.result = c.converted[0]
+	}
+	s.health.setQueued(s.pending())
+}
+
+// pending is how many jobs are queued or running. s.mu must be held.
+func (s *server) pending() int {
+	n := 0
+	for _, job := range s.jobs {
+		if job.Status == jobQueued || job.Status == jobRunning {
+			n++
+		}
+	}
+	return n
+}
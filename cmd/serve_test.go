@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, fsys hackpadfs.FS, token string) *httptest.Server {
+	s := newServer(testLogger{t}, io.Discard, fsys, "/uploads")
+	s.token = token
+	s.maxUpload = 1 << 20
+	s.webUI = true
+	s.newConverter = func(l logger) (*converter, error) {
+		return &converter{fs: fsys, logger: l}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, ctx)
+	}()
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(func() {
+		ts.Close()
+		cancel()
+		<-done
+	})
+	return ts
+}
+
+// waitForJob polls the job at location until it finished.
+func waitForJob(t *testing.T, ts *httptest.Server, location string) serveJob {
+	var job serveJob
+	require.Eventually(t, func() bool {
+		res, err := http.Get(ts.URL + location)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&job))
+		return job.Status == jobDone || job.Status == jobFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func Test_serveJobs(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr":      realCBRContents,
+		"library/broken.cbr": []byte("not a rar"),
+	})
+	require.NoError(t, err)
+	ts := newTestServer(t, fsys, "")
+
+	res, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"paths": ["/library"]}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+	location := res.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, "/jobs/"), location)
+
+	job := waitForJob(t, ts, location)
+	require.Equal(t, jobFailed, job.Status, "one of the files failed")
+	require.NotNil(t, job.Summary)
+	require.Equal(t, []string{"/library/a.cbz"}, job.Summary.Converted)
+	require.Equal(t, cbr2cbz.CodeNotArchive, job.Summary.FailedCodes["/library/broken.cbr"])
+
+	res, err = http.Get(ts.URL + location + "/log")
+	require.NoError(t, err)
+	log, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(log), "Converting: /library/a.cbr to /library/a.cbz")
+
+	res, err = http.Get(ts.URL + location + "/result")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode, "path jobs have nothing to download")
+
+	res, err = http.Get(ts.URL + "/jobs")
+	require.NoError(t, err)
+	var jobs []serveJob
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&jobs))
+	res.Body.Close()
+	require.Len(t, jobs, 1)
+	require.Equal(t, job.ID, jobs[0].ID)
+
+	res, err = http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"paths": []}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func Test_serveUpload(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{})
+	require.NoError(t, err)
+	ts := newTestServer(t, fsys, "")
+
+	res, err := http.Post(ts.URL+"/uploads?name=Saga%20001.cbr", "application/octet-stream", bytes.NewReader(realCBRContents))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+	location := res.Header.Get("Location")
+
+	job := waitForJob(t, ts, location)
+	require.Equal(t, jobDone, job.Status, job.Error)
+	require.True(t, job.Upload)
+
+	res, err = http.Get(ts.URL + location + "/result")
+	require.NoError(t, err)
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Contains(t, res.Header.Get("Content-Disposition"), `filename="Saga 001.cbz"`)
+	_, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+location, nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	_, err = hackpadfs.Stat(fsys, strings.TrimPrefix(job.Paths[0], "/"))
+	require.Error(t, err, "upload removed with its job")
+
+	res, err = http.Get(ts.URL + location)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = http.Post(ts.URL+"/uploads?name=big.cbr", "application/octet-stream", bytes.NewReader(make([]byte, 2<<20)))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
+
+func Test_serveToken(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{})
+	require.NoError(t, err)
+	ts := newTestServer(t, fsys, "s3cret")
+
+	get := func(auth string) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/jobs", nil)
+		require.NoError(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	res, err := http.Get(ts.URL + "/")
+	require.NoError(t, err)
+	page, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode, "the page asks for the token itself")
+	require.Contains(t, string(page), `id="drop"`)
+
+	require.Equal(t, http.StatusUnauthorized, get(""))
+	require.Equal(t, http.StatusUnauthorized, get("Bearer wrong"))
+	require.Equal(t, http.StatusOK, get("Bearer s3cret"))
+}
+
+func Test_serveHealthStatus(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr":      realCBRContents,
+		"library/broken.cbr": []byte("not a rar"),
+		"good/a.cbr":         realCBRContents,
+	})
+	require.NoError(t, err)
+	s := newServer(testLogger{t}, io.Discard, fsys, "/uploads")
+	s.newConverter = func(l logger) (*converter, error) {
+		return &converter{fs: fsys, logger: l}, nil
+	}
+	s.health = newHealthState(0)
+
+	job := &serveJob{ID: "j1", Status: jobQueued, Paths: []string{"/library"}, log: &syncBuffer{}}
+	s.jobs[job.ID] = job
+	s.runJob(context.Background(), job)
+	status := s.health.status()
+	require.Equal(t, 0, status.Queued)
+	require.Equal(t, "job j1: failed, see its summary", status.LastError)
+	require.Nil(t, status.LastSuccess)
+
+	job = &serveJob{ID: "j2", Status: jobQueued, Paths: []string{"/good"}, log: &syncBuffer{}}
+	s.jobs[job.ID] = job
+	s.runJob(context.Background(), job)
+	require.NotNil(t, s.health.status().LastSuccess)
+}
+
+func Test_isLoopbackAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.5:8080":  false,
+		"8080":           false,
+	} {
+		require.Equal(t, want, isLoopbackAddr(addr), addr)
+	}
+}
+
+func Test_serveRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "library"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "library", "a.cbr"), realCBRContents, 0o644))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	s := newServer(testLogger{t}, io.Discard, hackpados.NewFS(), "/uploads")
+	s.newConverter = func(l logger) (*converter, error) {
+		return &converter{fs: hackpados.NewFS(), logger: l, outputDir: "out"}, nil
+	}
+	job := &serveJob{ID: "j1", Status: jobQueued, Paths: []string{"library"}, log: &syncBuffer{}}
+	s.jobs[job.ID] = job
+	s.runJob(context.Background(), job)
+	require.Equal(t, jobDone, job.Status, job.Error)
+	_, err = os.Stat(filepath.Join(dir, "out", "a.cbz"))
+	require.NoError(t, err, "both resolved against the working directory")
+}
+
+func Test_serveSameOrigin(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{})
+	require.NoError(t, err)
+	s := newServer(testLogger{t}, io.Discard, fsys, "/uploads")
+	handler := s.sameOrigin(s.handler())
+
+	send := func(host string, origin string, contentType string) int {
+		req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"paths": []}`))
+		req.Host = host
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// an empty list of paths gets as far as it can
+	require.Equal(t, http.StatusBadRequest, send("127.0.0.1:8080", "", "application/json"))
+	require.Equal(t, http.StatusBadRequest, send("localhost:8080", "http://localhost:8080", "application/json; charset=utf-8"))
+	require.Equal(t, http.StatusUnsupportedMediaType, send("127.0.0.1:8080", "", "text/plain"), "what a form on another site sends")
+	require.Equal(t, http.StatusForbidden, send("127.0.0.1:8080", "https://evil.example", "application/json"))
+	require.Equal(t, http.StatusForbidden, send("evil.example:8080", "", "application/json"), "rebound to 127.0.0.1")
+
+	// a token keeps others out whatever the host
+	s.token = "s3cret"
+	require.Equal(t, http.StatusUnauthorized, send("comics.example:8080", "", "application/json"))
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSFTP serves an in memory SFTP server over a pipe, skipping ssh.
+func newTestSFTP(t *testing.T, files filenameBytes) *sftpFS {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, sftp.InMemHandler())
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	fsys := &sftpFS{client: client}
+	for name, data := range files {
+		require.NoError(t, hackpadfs.MkdirAll(fsys, path.Dir(name), 0o755))
+		require.NoError(t, hackpadfs.WriteFullFile(fsys, name, data, 0o644))
+	}
+	return fsys
+}
+
+func Test_sftpFS(t *testing.T) {
+	fsys := newTestSFTP(t, filenameBytes{
+		"library/a.cbr":          []byte("rar"),
+		"library/nested/b/c.cbr": []byte("deep"),
+	})
+
+	files, err := findFiles(fsys, "/library")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/library/a.cbr", "/library/nested/b/c.cbr"}, files)
+
+	_, err = fs.Stat(fsys, "library/missing")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	f, err := fsys.Open("library/a.cbr")
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = f.(io.ReaderAt).ReadAt(buf, 1)
+	require.NoError(t, err)
+	require.Equal(t, "ar", string(buf))
+	require.NoError(t, f.Close())
+
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "library/a.cbz", []byte("zip"), 0o644))
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "library/b.cbz", []byte("old"), 0o644))
+	require.NoError(t, hackpadfs.Rename(fsys, "library/a.cbz", "library/b.cbz"))
+	data, err := fs.ReadFile(fsys, "library/b.cbz")
+	require.NoError(t, err)
+	require.Equal(t, "zip", string(data))
+	require.ErrorIs(t, hackpadfs.Remove(fsys, "library/a.cbz"), fs.ErrNotExist)
+}
+
+func Test_convertSFTP(t *testing.T) {
+	fsys := newTestSFTP(t, filenameBytes{
+		"library/test.cbr":   realCBRContents,
+		"library/is-zip.cbr": notrealCBRContents,
+	})
+
+	c := &converter{fs: fsys, logger: testLogger{t}, verify: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+	require.Len(t, c.converted, 2)
+
+	require.NoError(t, verifyZip(fsys, "library/test.cbz"))
+	_, err := fs.Stat(fsys, "library/test.cbr")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, verifyZip(fsys, "library/is-zip.cbz"))
+	data, err := fs.ReadFile(fsys, "library/is-zip.cbz")
+	require.NoError(t, err)
+	require.NotEqual(t, notrealCBRContents, data, "the zip in disguise is repacked")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	snapshotCommand string
+	rollbackCommand string
+)
+
+// snapshot is the filesystem snapshot taken of the library before a batch.
+type snapshot struct {
+	Name string `json:"name"`
+	// Rollback is the command restoring it, empty without
+	// --snapshot-rollback-command
+	Rollback string `json:"rollback,omitempty"`
+}
+
+// takeSnapshot runs c.snapshotCommand before a batch that removes
+// originals, btrfs subvolume snapshot or zfs snapshot say, with {name} in
+// it replaced by a name of the batch's own. Batches keeping their originals
+// or with nothing to convert don't take one.
+func (c *converter) takeSnapshot(ctx context.Context, now time.Time) error {
+	c.snapshot = nil
+	if c.snapshotCommand == "" || c.keep || len(c.cbrFiles) == 0 {
+		return nil
+	}
+
+	name := "cbr2cbz-" + now.Format("20060102-150405")
+	output, err := shellCommand(ctx, expandSnapshotName(c.snapshotCommand, name)).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "taking snapshot %s, nothing was converted: %s", name, strings.TrimSpace(string(output)))
+	}
+
+	c.snapshot = &snapshot{Name: name}
+	c.logger.Printf("Took snapshot %s before converting\n", name)
+	if c.rollbackCommand != "" {
+		c.snapshot.Rollback = expandSnapshotName(c.rollbackCommand, name)
+		c.logger.Printf("To undo this batch: %s\n", c.snapshot.Rollback)
+	}
+	return nil
+}
+
+func expandSnapshotName(command string, name string) string {
+	return strings.ReplaceAll(command, "{name}", name)
+}
+
+// shellCommand runs command the way the system shell would.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
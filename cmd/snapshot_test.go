@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_takeSnapshot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the snapshot commands are sh")
+	}
+	dir := t.TempDir()
+	taken := filepath.Join(dir, "taken")
+
+	fsys, err := setupFS(t, filenameBytes{"library/test.cbr": realCBRContents})
+	require.NoError(t, err)
+	c := &converter{
+		fs:              fsys,
+		logger:          testLogger{t},
+		snapshotCommand: "printf %s {name} > " + taken,
+		rollbackCommand: "zfs rollback -r tank/comics@{name}",
+		reportPath:      filepath.Join(dir, "report.json"),
+	}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.NotNil(t, c.snapshot)
+
+	name, err := os.ReadFile(taken)
+	require.NoError(t, err)
+	require.Equal(t, c.snapshot.Name, string(name))
+	require.Equal(t, "zfs rollback -r tank/comics@"+c.snapshot.Name, c.snapshot.Rollback)
+
+	data, err := os.ReadFile(c.reportPath)
+	require.NoError(t, err)
+	var report batchReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, c.snapshot, report.Snapshot)
+
+	// originals kept, nothing to undo
+	c = &converter{fs: fsys, logger: testLogger{t}, keep: true, snapshotCommand: "exit 1"}
+	c.cbrFiles = []string{"/library/test.cbr"}
+	require.NoError(t, c.takeSnapshot(context.Background(), time.Now()))
+	require.Nil(t, c.snapshot)
+}
+
+func Test_takeSnapshotFailing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the snapshot commands are sh")
+	}
+	fsys, err := setupFS(t, filenameBytes{"library/test.cbr": realCBRContents})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, snapshotCommand: "echo no such pool; exit 1"}
+	err = c.runConvert(context.Background(), []string{"/library"})
+	require.ErrorContains(t, err, "nothing was converted: no such pool")
+	require.Empty(t, c.converted)
+	_, err = hackpadfs.Stat(fsys, "library/test.cbr")
+	require.NoError(t, err)
+}
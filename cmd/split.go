@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	splitEvery int
+	splitAt    string
+)
+
+var splitCmd = &cobra.Command{
+	Use:   "split [paths...]",
+	Short: "Splits big archives, like omnibuses, into a cbz per chapter or part",
+	Long: `Writes the pages of each cbz, cbr, cb7 and cbt into several cbz files in a directory
+named after it, for omnibuses too big for mobile readers. By default there is one
+per chapter folder, the way convert --split-chapters does it. --every 200 makes a
+part of every 200 pages instead, and --at starts a part at every page whose name
+matches a regular expression, like --at '(?i)chapter.*cover', the pages before the
+first one making a part of their own. Parts are named Part 01.cbz, Part 02.cbz and
+so on.
+
+Pages are packed with the same flags convert takes. The archive split is left
+alone, and existing parts are never overwritten.
+
+  cbr2cbz split --every 200 ~/Comics/Omnibus.cbz`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		by, err := newChapterSplit(splitEvery, splitAt)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		err = c.runSplit(cmd.Context(), args, by)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(splitCmd)
+
+	splitCmd.Flags().IntVar(&splitEvery, "every", 0, "make a part of every this many pages, rather than one per chapter folder")
+	splitCmd.Flags().StringVar(&splitAt, "at", "", "start a part at every page whose name matches this regular expression, rather than one per chapter folder")
+	splitCmd.MarkFlagsMutuallyExclusive("every", "at")
+	splitCmd.Flags().AddFlagSet(convertCmd.Flags())
+}
+
+// chapterSplit is how split divides an archive, every so many pages, at
+// the pages matching at, or by chapter folder.
+type chapterSplit struct {
+	every int
+	at    *regexp.Regexp
+}
+
+func newChapterSplit(every int, at string) (chapterSplit, error) {
+	if every < 0 {
+		return chapterSplit{}, errors.Errorf("--every must be a number of pages, got %d", every)
+	}
+	by := chapterSplit{every: every}
+	if at != "" {
+		pattern, err := regexp.Compile(at)
+		if err != nil {
+			return chapterSplit{}, errors.Wrap(err, "invalid --at")
+		}
+		by.at = pattern
+	}
+	return by, nil
+}
+
+// chapters splits files the way by says, explaining why when there is
+// nothing to split.
+func (by chapterSplit) chapters(engine *cbr2cbz.Converter, files []archiver.File) ([]cbr2cbz.Chapter, int, error) {
+	var chapters []cbr2cbz.Chapter
+	var leftOut int
+	var none error
+	switch {
+	case by.every > 0:
+		chapters, leftOut = engine.ChaptersEvery(files, by.every)
+		none = errors.Errorf("it has no more than %d pages", by.every)
+	case by.at != nil:
+		chapters, leftOut = engine.ChaptersAt(files, by.at)
+		none = errors.Errorf("no page after the first matches %s", by.at)
+	default:
+		chapters, leftOut = engine.Chapters(files)
+		none = errors.New("it has fewer than two chapter folders, see --every and --at")
+	}
+	if len(chapters) == 0 {
+		return nil, 0, none
+	}
+	return chapters, leftOut, nil
+}
+
+func (c *converter) runSplit(ctx context.Context, paths []string, by chapterSplit) error {
+	files, err := findArchives(c.fs, paths, "cbz, cbr, cb7 or cbt", verifiedExtensions...)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		parts, dir, err := c.splitArchive(ctx, file, by)
+		if err != nil {
+			c.logger.Printf("Error splitting %s - Skipping...%s\n", file, err.Error())
+			failed++
+			continue
+		}
+		c.logger.Printf("Split %s into %d parts under %s\n", file, parts, dir)
+	}
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+// splitArchive writes the parts of file into a directory named after it,
+// returning how many there were and the directory.
+func (c *converter) splitArchive(ctx context.Context, file string, by chapterSplit) (int, string, error) {
+	engine := c.packer()
+	src, files, err := openEntries(ctx, c.fs, engine, file)
+	if err != nil {
+		return 0, "", err
+	}
+	defer src.Close()
+	chapters, leftOut, err := by.chapters(engine, files)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "nothing to split")
+	}
+	if leftOut > 0 {
+		c.warn(cbr2cbz.CodeChapterExtraEntries, file, "Leaving %d non-page entries of %s out of the chapters", leftOut, file)
+	}
+
+	dir := pathToFsPath(strings.TrimSuffix(file, path.Ext(file)))
+	for _, ch := range chapters {
+		if _, err := hackpadfs.Stat(c.fs, chapterPath(dir, ch)); err == nil {
+			return 0, "", errors.Errorf("%s already exists", chapterPath(dir, ch))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return 0, "", errors.Wrap(err, "checking for parts")
+		}
+	}
+
+	comment := ""
+	if info, err := src.Stat(); err == nil {
+		comment = engine.Comment(file, src.(io.ReaderAt), info.Size())
+	}
+	err = c.writeChapters(ctx, file, dir, chapters, comment, &cbr2cbz.Progress{})
+	if err != nil {
+		return 0, "", err
+	}
+	return len(chapters), dir, nil
+}
+
+// chapterFileName makes a chapter title safe to use as a file name.
+var chapterFileName = strings.NewReplacer("/", "-", "\\", "-", ":", "-", "*", "", "?", "", "\"", "", "<", "", ">", "", "|", "")
+
+// convertChapters writes one cbz per internal chapter folder of the source
+// into a directory named after cbzFile, the series/chapter layout
+// Tachiyomi style readers expect. It reports false, having written nothing,
+// when the source doesn't have at least two chapter folders.
+func (c *converter) convertChapters(ctx context.Context, cbrFile string, cbzFile string, src io.ReaderAt, size int64, progress *cbr2cbz.Progress) (bool, error) {
+	engine := c.packer()
+	files, err := engine.Entries(ctx, cbrFile, src, size, progress)
+	if err != nil {
+		return false, err
+	}
+	chapters, leftOut := engine.Chapters(files)
+	if len(chapters) == 0 {
+		c.warn(cbr2cbz.CodeChaptersNotFound, cbrFile, "No chapter folders in %s, converting it as a single cbz", cbrFile)
+		return false, nil
+	}
+	if leftOut > 0 {
+		c.warn(cbr2cbz.CodeChapterExtraEntries, cbrFile, "Leaving %d non-page entries of %s out of the chapters", leftOut, cbrFile)
+	}
+
+	dir := pathToFsPath(strings.TrimSuffix(cbzFile, path.Ext(cbzFile)))
+	err = c.writeChapters(ctx, cbrFile, dir, chapters, engine.Comment(cbrFile, src, size), progress)
+	if err != nil {
+		return false, err
+	}
+	c.logFor(cbrFile).Printf("Split %s into %d chapters under %s\n", cbrFile, len(chapters), dir)
+	return true, nil
+}
+
+// writeChapters writes each chapter to a cbz named after it in dir,
+// removing those already written if one fails.
+func (c *converter) writeChapters(ctx context.Context, cbrFile string, dir string, chapters []cbr2cbz.Chapter, comment string, progress *cbr2cbz.Progress) error {
+	err := hackpadfs.MkdirAll(c.fs, dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "creating chapter directory")
+	}
+
+	var expected uint64
+	var entries int64
+	for _, ch := range chapters {
+		for _, f := range ch.Files {
+			expected += uint64(f.Size())
+		}
+		entries += int64(len(ch.Files))
+	}
+	progress.Expected.Store(expected)
+	progress.Entries.Store(entries)
+
+	written := []string{}
+	for _, ch := range chapters {
+		name := chapterPath(dir, ch)
+		err = c.writeChapter(ctx, cbrFile, name, ch.Files, comment, progress)
+		if err != nil {
+			for _, w := range written {
+				hackpadfs.Remove(c.fs, w)
+			}
+			return errors.Wrapf(err, "writing chapter %s", ch.Title)
+		}
+		written = append(written, name)
+	}
+	return nil
+}
+
+// chapterPath is where ch is written in dir, under a name Windows can
+// create even for a chapter titled Con or Aux.
+func chapterPath(dir string, ch cbr2cbz.Chapter) string {
+	return path.Join(dir, cbr2cbz.SafeFileName(chapterFileName.Replace(ch.Title))+".cbz")
+}
+
+func (c *converter) writeChapter(ctx context.Context, cbrFile string, name string, files []archiver.File, comment string, progress *cbr2cbz.Progress) error {
+	tmp := tempName(name)
+	out, err := hackpadfs.Create(c.fs, tmp)
+	if err != nil {
+		return errors.Wrap(err, "unable to create zip")
+	}
+	defer out.Close()
+
+	w, ok := out.(io.Writer)
+	if !ok {
+		return errors.New("destination isn't a writable filesystem")
+	}
+	err = c.packer().PackWithComment(ctx, cbrFile, files, comment, countingWriter{Writer: w, n: &progress.Written}, &cbr2cbz.Progress{})
+	if err != nil {
+		out.Close()
+		hackpadfs.Remove(c.fs, tmp)
+		return err
+	}
+	err = out.Close()
+	if err == nil {
+		err = hackpadfs.Rename(c.fs, tmp, name)
+	}
+	if err != nil {
+		hackpadfs.Remove(c.fs, tmp)
+		return err
+	}
+	c.copyAttributes(cbrFile, name)
+	if c.verify {
+		err = verifyZip(c.fs, name)
+		if err != nil {
+			hackpadfs.Remove(c.fs, name)
+		}
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+// partNames lists the entries of the cbz at name.
+func partNames(t *testing.T, c *converter, name string) []string {
+	zr, f, err := openZip(c.fs, name)
+	require.NoError(t, err)
+	defer f.Close()
+	names := []string{}
+	for _, entry := range zr.File {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func Test_runSplit(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Omnibus.cbz": makeZip(t, map[string]string{
+			"ch1/cover.jpg": "cover one",
+			"ch1/p1.jpg":    "page",
+			"ch1/p2.jpg":    "page",
+			"ch2/cover.jpg": "cover two",
+			"ch2/p1.jpg":    "page",
+		}),
+	})
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.setOptions(cbr2cbz.Options{PageOrder: "natural"}, cbr2cbz.Limits{}))
+
+	by, err := newChapterSplit(2, "")
+	require.NoError(t, err)
+	require.NoError(t, c.runSplit(context.Background(), []string{"/library"}, by))
+	require.Equal(t, []string{"ch1/cover.jpg", "ch1/p1.jpg"}, partNames(t, c, "library/Omnibus/Part 01.cbz"))
+	require.Equal(t, []string{"ch2/p1.jpg"}, partNames(t, c, "library/Omnibus/Part 03.cbz"))
+	// the original is left alone
+	require.Len(t, partNames(t, c, "library/Omnibus.cbz"), 5)
+
+	// and so are the parts
+	by, err = newChapterSplit(0, "cover")
+	require.NoError(t, err)
+	require.EqualError(t, c.runSplit(context.Background(), []string{"/library/Omnibus.cbz"}, by), "1 files failed")
+	require.Equal(t, []string{"ch1/cover.jpg", "ch1/p1.jpg"}, partNames(t, c, "library/Omnibus/Part 01.cbz"))
+}
+
+func Test_chapterSplit(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Omnibus.cbz": makeZip(t, map[string]string{
+			"ch1/cover.jpg": "cover one",
+			"ch1/p1.jpg":    "page",
+			"ch2/cover.jpg": "cover two",
+			"ch2/p1.jpg":    "page",
+		}),
+	})
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}}
+
+	tests := []struct {
+		name    string
+		every   int
+		at      string
+		want    []string
+		wantErr string
+	}{
+		{name: "folders", want: []string{"ch1", "ch2"}},
+		{name: "at", at: "cover", want: []string{"Part 01", "Part 02"}},
+		{name: "every", every: 3, want: []string{"Part 01", "Part 02"}},
+		{name: "too few pages", every: 4, wantErr: "no more than 4 pages"},
+		{name: "no match", at: "credits", wantErr: "no page after the first matches credits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			by, err := newChapterSplit(tt.every, tt.at)
+			require.NoError(t, err)
+			src, files, err := openEntries(context.Background(), fsys, c.packer(), "library/Omnibus.cbz")
+			require.NoError(t, err)
+			defer src.Close()
+
+			chapters, _, err := by.chapters(c.packer(), files)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			titles := []string{}
+			for _, ch := range chapters {
+				titles = append(titles, ch.Title)
+			}
+			require.Equal(t, tt.want, titles)
+		})
+	}
+
+	_, err = newChapterSplit(0, "(")
+	require.ErrorContains(t, err, "invalid --at")
+}
+
+func Test_chapterPath(t *testing.T) {
+	require.Equal(t, "Webtoon/Chapter 1.cbz", chapterPath("Webtoon", cbr2cbz.Chapter{Title: "Chapter 1"}))
+	require.Equal(t, "Webtoon/_Aux.cbz", chapterPath("Webtoon", cbr2cbz.Chapter{Title: "Aux"}))
+	require.Equal(t, "Webtoon/Part 2- The End.cbz", chapterPath("Webtoon", cbr2cbz.Chapter{Title: "Part 2: The End..."}))
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var nulDelimited bool
+
+// readPathArgs replaces a - among args with the paths read from stdin, one
+// per line, or separated by NULs with nul set as find -print0 writes them.
+// Relative paths, like those of find ., are taken from the working directory.
+func readPathArgs(args []string, stdin io.Reader, nul bool) ([]string, error) {
+	at := -1
+	for i, arg := range args {
+		if arg != "-" {
+			continue
+		}
+		if at >= 0 {
+			return nil, errors.New("- can only be given once, stdin is read a single time")
+		}
+		at = i
+	}
+	if at < 0 {
+		return args, nil
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(nil, 1<<20)
+	if nul {
+		scanner.Split(splitNUL)
+	}
+	paths := []string{}
+	for scanner.Scan() {
+		path := scanner.Text()
+		if !nul {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path == "" {
+			continue
+		}
+		if !isRemotePath(path) && !filepath.IsAbs(path) {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "resolving %s", path)
+			}
+			path = abs
+		}
+		paths = append(paths, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading paths from stdin")
+	}
+
+	out := append([]string{}, args[:at]...)
+	out = append(out, paths...)
+	return append(out, args[at+1:]...), nil
+}
+
+// splitNUL is a bufio.SplitFunc for NUL terminated tokens.
+func splitNUL(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readPathArgs(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		nul     bool
+		want    []string
+		wantErr string
+	}{
+		{name: "no dash", args: []string{"/comics"}, stdin: "/ignored\n", want: []string{"/comics"}},
+		{name: "lines", args: []string{"-"}, stdin: "/comics/a.cbr\r\n\n/comics/b.cbr", want: []string{"/comics/a.cbr", "/comics/b.cbr"}},
+		{name: "in place", args: []string{"/first", "-", "/last"}, stdin: "/comics/a.cbr\n", want: []string{"/first", "/comics/a.cbr", "/last"}},
+		{name: "nul", args: []string{"-"}, stdin: "/comics/odd\nname.cbr\x00/comics/b.cbr\x00", nul: true, want: []string{"/comics/odd\nname.cbr", "/comics/b.cbr"}},
+		{name: "relative", args: []string{"-"}, stdin: "./a.cbr\ns3://bucket/b.cbr\n", want: []string{filepath.Join(wd, "a.cbr"), "s3://bucket/b.cbr"}},
+		{name: "empty", args: []string{"-"}, stdin: "", want: []string{}},
+		{name: "twice", args: []string{"-", "-"}, wantErr: "- can only be given once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPathArgs(tt.args, strings.NewReader(tt.stdin), tt.nul)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
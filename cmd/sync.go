@@ -0,0 +1,433 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	syncImages  cbr2cbz.ImageOptions
+	syncProfile string
+	syncFrom    []string
+	syncDelete  bool
+	syncDryRun  bool
+)
+
+// syncManifestName is where sync remembers what it wrote, at the top of the
+// destination.
+const syncManifestName = ".cbr2cbz-sync.json"
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync <src> <dst>",
+	Short: "Mirrors a library into another tree, converting on the way",
+	Long: `Mirrors the library under src into dst, like rsync with the conversion built in.
+cbr, cb7 and cbt files become cbz files, cbz files are copied, and with --profile
+or the image flags every page is re-encoded for the device dst belongs to.
+Anything else is copied as is.
+
+Only what is new or changed since the last sync is redone, or everything once
+the image settings change. What was synced is recorded in ` + syncManifestName + `
+in dst; with --delete, files sync wrote whose source is gone are removed too.
+Nothing else in dst is ever touched.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeDirs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		if err := applyImageProfile(cmd, syncProfile, &syncImages); err != nil {
+			logger.Fatal(err)
+		}
+		sources, err := sourceExtensions(syncFrom)
+		if err != nil {
+			logger.Fatal(errors.Wrap(err, "parsing --from"))
+		}
+
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		src, err := localDir(fsys, args[0], false)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		dst, err := localDir(fsys, args[1], true)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		s, err := newSyncer(src, dst, logger, syncImages, sources)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		s.delete = syncDelete
+		s.dryRun = syncDryRun
+
+		err = s.run(cmd.Context())
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+
+	addImageFlags(syncCmd, &syncImages)
+	syncCmd.Flags().StringVar(&syncProfile, "profile", "", "image profile of the destination device (kobo, kindle, tablet, eink)")
+	syncCmd.RegisterFlagCompletionFunc("profile", completeImageProfiles)
+	syncCmd.Flags().StringSliceVar(&syncFrom, "from", defaultSources, "types of file to convert: cbr, cb7, cbt and pdf")
+	syncCmd.Flags().BoolVar(&syncDelete, "delete", false, "remove files sync wrote to dst whose source is gone")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "only log what would be done")
+}
+
+// localDir is the local directory arg as a filesystem of its own, on
+// whichever drive or share it is, relative to the working directory unless
+// --root was given. With create it is made first if it isn't there.
+func localDir(fsys hackpadfs.FS, arg string, create bool) (hackpadfs.FS, error) {
+	dir := pathToFsPath(arg)
+	if rootDir == "" {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving %s", arg)
+		}
+		abs = withoutLongPrefix(abs)
+		fsys, err = onVolume(fsys, filepath.VolumeName(abs))
+		if err != nil {
+			return nil, err
+		}
+		dir = pathToFsPath(osToInputPath(abs))
+	}
+	if dir == "" {
+		dir = "."
+	}
+	if create {
+		err := hackpadfs.MkdirAll(fsys, dir, 0755)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating %s", arg)
+		}
+	}
+	sub, err := hackpadfs.Sub(fsys, dir)
+	return sub, errors.Wrapf(err, "opening %s", arg)
+}
+
+// syncManifest records each file sync wrote, keyed by its path in dst.
+type syncManifest struct {
+	Files map[string]syncEntry `json:"files"`
+}
+
+// syncEntry is what a file in dst was made from.
+type syncEntry struct {
+	Source  string    `json:"source"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Options is the fingerprint of the conversion options for converted
+	// and re-encoded files
+	Options string `json:"options,omitempty"`
+}
+
+type syncer struct {
+	src     hackpadfs.FS
+	dst     hackpadfs.FS
+	logger  logger
+	engine  *cbr2cbz.Converter
+	images  cbr2cbz.ImageOptions
+	sources map[string]bool
+	delete  bool
+	dryRun  bool
+}
+
+func newSyncer(src, dst hackpadfs.FS, logger logger, images cbr2cbz.ImageOptions, sources map[string]bool) (*syncer, error) {
+	if err := images.Validate(); err != nil {
+		return nil, err
+	}
+	engine, err := cbr2cbz.New(cbr2cbz.Options{Images: &images})
+	if err != nil {
+		return nil, err
+	}
+	engine.Logger = logger
+	return &syncer{src: src, dst: dst, logger: logger, engine: engine, images: images, sources: sources}, nil
+}
+
+// syncAction is what to do with one source file.
+type syncAction int
+
+const (
+	syncCopy syncAction = iota
+	syncConvert
+	syncReencode
+)
+
+func (s *syncer) run(ctx context.Context) error {
+	manifest, err := s.readManifest()
+	if err != nil {
+		return err
+	}
+	files, err := s.sourceFiles()
+	if err != nil {
+		return err
+	}
+
+	var converted, copied, upToDate, removed, failed int
+	seen := map[string]bool{}
+	for _, name := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		target, action := s.plan(name)
+		if seen[target] {
+			s.logger.Printf("Skipping %s, another file already syncs to %s\n", name, target)
+			continue
+		}
+		seen[target] = true
+
+		written, err := s.syncOne(ctx, manifest, name, target, action)
+		switch {
+		case err != nil:
+			s.logger.Printf("[%s] Unable to sync %s - Skipping...%s\n", errorCode(err), name, err.Error())
+			failed++
+		case !written:
+			upToDate++
+		case s.dryRun:
+		case action == syncCopy:
+			copied++
+		default:
+			converted++
+		}
+	}
+
+	if s.delete {
+		targets := make([]string, 0, len(manifest.Files))
+		for target := range manifest.Files {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			if seen[target] {
+				continue
+			}
+			if s.dryRun {
+				s.logger.Printf("Would remove %s, %s is gone\n", target, manifest.Files[target].Source)
+				continue
+			}
+			err := removeIfExists(s.dst, target)
+			if err != nil {
+				s.logger.Printf("Unable to remove %s: %s\n", target, err.Error())
+				failed++
+				continue
+			}
+			s.logger.Printf("Removed %s, %s is gone\n", target, manifest.Files[target].Source)
+			delete(manifest.Files, target)
+			removed++
+		}
+	}
+
+	s.logger.Printf("Synced: %d converted, %d copied, %d up to date, %d removed, %d failed\n", converted, copied, upToDate, removed, failed)
+	if !s.dryRun {
+		err = s.writeManifest(manifest)
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d files failed to sync", failed)
+	}
+	return nil
+}
+
+// sourceFiles is every file under src, in walk order.
+func (s *syncer) sourceFiles() ([]string, error) {
+	files := []string{}
+	err := fs.WalkDir(s.src, ".", func(name string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !de.IsDir() && name != syncManifestName {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "walking source")
+	}
+	return files, nil
+}
+
+// syncOne brings target in dst up to date with name in src, recording it
+// in manifest. It reports whether target had to be written, or would have
+// been on a dry run.
+func (s *syncer) syncOne(ctx context.Context, manifest *syncManifest, name string, target string, action syncAction) (bool, error) {
+	info, err := fs.Stat(s.src, name)
+	if err != nil {
+		return false, errors.Wrapf(err, "stating %s", name)
+	}
+	entry := syncEntry{Source: name, Size: info.Size(), ModTime: info.ModTime()}
+	if action != syncCopy {
+		entry.Options = s.engine.Options().Fingerprint()
+	}
+	if s.upToDate(manifest, target, entry) {
+		return false, nil
+	}
+
+	if s.dryRun {
+		s.logger.Printf("Would sync %s to %s\n", name, target)
+		return true, nil
+	}
+	err = s.syncFile(ctx, name, target, action)
+	if err != nil {
+		return true, err
+	}
+	manifest.Files[target] = entry
+	return true, nil
+}
+
+// plan is where name ends up in dst and how it gets there.
+func (s *syncer) plan(name string) (string, syncAction) {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case s.sources[ext]:
+		return strings.TrimSuffix(name, path.Ext(name)) + ".cbz", syncConvert
+	case ext == ".cbz" && s.images.Enabled():
+		return name, syncReencode
+	}
+	return name, syncCopy
+}
+
+// upToDate reports whether target was synced from exactly this version of
+// its source and is still there.
+func (s *syncer) upToDate(manifest *syncManifest, target string, entry syncEntry) bool {
+	prev, ok := manifest.Files[target]
+	if !ok || prev.Source != entry.Source || prev.Size != entry.Size || !prev.ModTime.Equal(entry.ModTime) || prev.Options != entry.Options {
+		return false
+	}
+	_, err := fs.Stat(s.dst, target)
+	return err == nil
+}
+
+func (s *syncer) syncFile(ctx context.Context, name string, target string, action syncAction) error {
+	err := hackpadfs.MkdirAll(s.dst, path.Dir(target), 0755)
+	if err != nil {
+		return errors.Wrap(err, "creating destination directory")
+	}
+
+	if action == syncConvert {
+		res, err := s.engine.Convert(ctx, s.src, s.dst, name)
+		if !errors.Is(err, cbr2cbz.ErrNotArchive) {
+			if err == nil {
+				s.logger.Printf("Converted %s to %s (%d entries)\n", name, res.Output, res.Entries)
+			}
+			return err
+		}
+		// a zip pretending to be something else only needs renaming
+		_, f, err := openZip(s.src, name)
+		if err != nil {
+			return cbr2cbz.ErrNotArchive
+		}
+		f.Close()
+		action = syncCopy
+		if s.images.Enabled() {
+			action = syncReencode
+		}
+	}
+
+	err = copyBetween(s.src, name, s.dst, target)
+	if err != nil {
+		return errors.Wrapf(err, "copying %s", name)
+	}
+	if action == syncReencode {
+		r := &reencoder{fs: s.dst, logger: s.logger, images: s.images}
+		err = r.reencode(target)
+		if err != nil {
+			hackpadfs.Remove(s.dst, target)
+			return err
+		}
+	}
+	s.logger.Printf("Copied %s to %s\n", name, target)
+	return nil
+}
+
+// copyBetween copies name in src to target in dst, replacing it if it
+// exists.
+func copyBetween(src hackpadfs.FS, name string, dst hackpadfs.FS, target string) error {
+	in, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := hackpadfs.Create(dst, target)
+	if err != nil {
+		return err
+	}
+	w, ok := out.(io.Writer)
+	if !ok {
+		out.Close()
+		return errors.New("destination isn't a writable filesystem")
+	}
+	_, err = io.Copy(w, in)
+	if err != nil {
+		out.Close()
+		hackpadfs.Remove(dst, target)
+		return err
+	}
+	return out.Close()
+}
+
+func (s *syncer) readManifest() (*syncManifest, error) {
+	return readSyncManifest(s.dst)
+}
+
+func (s *syncer) writeManifest(manifest *syncManifest) error {
+	return writeSyncManifest(s.dst, manifest)
+}
+
+// readSyncManifest reads what sync wrote to dst, nothing if it never did.
+func readSyncManifest(dst hackpadfs.FS) (*syncManifest, error) {
+	manifest := &syncManifest{Files: map[string]syncEntry{}}
+	data, err := fs.ReadFile(dst, syncManifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading "+syncManifestName)
+	}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing "+syncManifestName)
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]syncEntry{}
+	}
+	return manifest, nil
+}
+
+func writeSyncManifest(dst hackpadfs.FS, manifest *syncManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding "+syncManifestName)
+	}
+	f, err := hackpadfs.Create(dst, syncManifestName)
+	if err != nil {
+		return errors.Wrap(err, "writing "+syncManifestName)
+	}
+	_, err = hackpadfs.WriteFile(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "writing "+syncManifestName)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_syncer(t *testing.T) {
+	page := string(makePNG(t, 4, 4))
+	src, err := setupFS(t, filenameBytes{
+		"Saga/001.cbt":    makeTar(t, map[string]string{"001.png": page, "002.png": page}),
+		"Saga/002.cbz":    makeZip(t, map[string]string{"001.png": page}),
+		"Saga/is-zip.cbr": notrealCBRContents,
+		"notes.txt":       []byte("notes"),
+	})
+	require.NoError(t, err)
+	dst, err := setupFS(t, filenameBytes{"mine.txt": []byte("not synced")})
+	require.NoError(t, err)
+
+	sync := func(t *testing.T, delete, dryRun bool) {
+		t.Helper()
+		s, err := newSyncer(src, dst, testLogger{t}, cbr2cbz.ImageOptions{}, map[string]bool{".cbr": true, ".cbt": true})
+		require.NoError(t, err)
+		s.delete = delete
+		s.dryRun = dryRun
+		require.NoError(t, s.run(context.Background()))
+	}
+	read := func(t *testing.T, name string) string {
+		t.Helper()
+		data, err := fs.ReadFile(dst, name)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	sync(t, false, false)
+	for _, name := range []string{"Saga/001.cbz", "Saga/002.cbz", "Saga/is-zip.cbz", "notes.txt", syncManifestName} {
+		_, err := fs.Stat(dst, name)
+		require.NoError(t, err, name)
+	}
+	require.NoError(t, verifyZip(dst, "Saga/001.cbz"))
+	require.Equal(t, string(notrealCBRContents), read(t, "Saga/is-zip.cbz"))
+
+	t.Run("only what changed is synced again", func(t *testing.T) {
+		// a marker that survives as long as sync leaves the file alone
+		require.NoError(t, hackpadfs.WriteFullFile(dst, "Saga/002.cbz", []byte("untouched"), 0o644))
+		require.NoError(t, hackpadfs.WriteFullFile(src, "notes.txt", []byte("more notes"), 0o644))
+
+		sync(t, false, false)
+		require.Equal(t, "untouched", read(t, "Saga/002.cbz"))
+		require.Equal(t, "more notes", read(t, "notes.txt"))
+	})
+
+	t.Run("missing files are synced again", func(t *testing.T) {
+		require.NoError(t, hackpadfs.Remove(dst, "Saga/001.cbz"))
+		sync(t, false, false)
+		require.NoError(t, verifyZip(dst, "Saga/001.cbz"))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		require.NoError(t, hackpadfs.Remove(src, "notes.txt"))
+		require.NoError(t, hackpadfs.WriteFullFile(src, "new.txt", []byte("new"), 0o644))
+		sync(t, true, true)
+		_, err := fs.Stat(dst, "new.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		require.Equal(t, "more notes", read(t, "notes.txt"))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		sync(t, true, false)
+		require.Equal(t, "new", read(t, "new.txt"))
+		_, err := fs.Stat(dst, "notes.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		// files sync didn't write are left alone
+		require.Equal(t, "not synced", read(t, "mine.txt"))
+
+		s, err := newSyncer(src, dst, testLogger{t}, cbr2cbz.ImageOptions{}, nil)
+		require.NoError(t, err)
+		manifest, err := s.readManifest()
+		require.NoError(t, err)
+		require.NotContains(t, manifest.Files, "notes.txt")
+		require.Equal(t, "Saga/001.cbt", manifest.Files["Saga/001.cbz"].Source)
+	})
+}
+
+func Test_syncer_plan(t *testing.T) {
+	s := &syncer{sources: map[string]bool{".cbr": true}}
+	tests := []struct {
+		name   string
+		want   string
+		action syncAction
+	}{
+		{name: "a/b.CBR", want: "a/b.cbz", action: syncConvert},
+		{name: "a/b.cbz", want: "a/b.cbz", action: syncCopy},
+		{name: "a/b.cb7", want: "a/b.cb7", action: syncCopy},
+		{name: "cover.jpg", want: "cover.jpg", action: syncCopy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, action := s.plan(tt.name)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.action, action)
+		})
+	}
+
+	s.images = cbr2cbz.ImageOptions{MaxWidth: 1000}
+	_, action := s.plan("a/b.cbz")
+	require.Equal(t, syncReencode, action)
+}
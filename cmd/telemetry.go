@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultTelemetryURL is where --telemetry reports go unless --telemetry-url
+// says otherwise. Release builds set it with
+// -ldflags "-X github.com/halkeye/cbr2cbz/cmd.defaultTelemetryURL=...".
+var defaultTelemetryURL string
+
+var (
+	telemetryEnabled bool
+	telemetryURL     string
+)
+
+// telemetryReport is everything --telemetry sends after a run: counts, and
+// nothing naming or sizing a file.
+type telemetryReport struct {
+	Version   string `json:"version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Converted int    `json:"converted"`
+	Failed    int    `json:"failed"`
+	// Errors is how many files failed with each error code, see codes.go
+	Errors map[string]int `json:"errors"`
+	// Formats is how many files of each extension were tried
+	Formats map[string]int `json:"formats"`
+}
+
+// telemetryReport totals up the run that just finished.
+func (c *converter) telemetryReport() telemetryReport {
+	r := telemetryReport{
+		Version:   buildVersion,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Converted: len(c.converted),
+		Failed:    len(c.failed),
+		Errors:    map[string]int{},
+		Formats:   map[string]int{},
+	}
+	format := func(name string) string {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+		if ext == "" {
+			return "none"
+		}
+		return ext
+	}
+	for _, cbzFile := range c.converted {
+		r.Formats[format(c.origins[cbzFile])]++
+	}
+	for file, err := range c.failed {
+		r.Formats[format(file)]++
+		r.Errors[errorCode(err)]++
+	}
+	return r
+}
+
+// sendTelemetry reports the run that just finished, if --telemetry asked
+// for it. What is sent is logged first, and failing to send it is only
+// logged.
+func (c *converter) sendTelemetry() {
+	if c.telemetryURL == "" || len(c.converted)+len(c.failed) == 0 {
+		return
+	}
+	report := c.telemetryReport()
+	data, err := json.Marshal(report)
+	if err == nil {
+		c.logger.Printf("Sending anonymous telemetry to %s: %s\n", c.telemetryURL, data)
+		err = postJSON(c.telemetryURL, data)
+	}
+	if err != nil {
+		c.logger.Printf("Unable to send telemetry: %s\n", err.Error())
+	}
+}
+
+// postJSON posts data to url, giving up after 10 seconds.
+func postJSON(url string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cbr2cbz/"+buildVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "posting to %s", url)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sendTelemetry(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Secret Series/a.cbr": realCBRContents,
+		"library/Secret Series/b.cbt": realCBTContents,
+		"library/Secret Series/c.cbr": []byte("not yet downloaded"),
+	})
+	require.NoError(t, err)
+	sources, err := sourceExtensions([]string{"cbr", "cbt"})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, sources: sources, telemetryURL: server.URL}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+
+	body := <-bodies
+	require.NotContains(t, string(body), "Secret")
+	report := telemetryReport{}
+	require.NoError(t, json.Unmarshal(body, &report))
+	require.Equal(t, telemetryReport{
+		Version:   buildVersion,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Converted: 2,
+		Failed:    1,
+		Errors:    map[string]int{cbr2cbz.CodeNotArchive: 1},
+		Formats:   map[string]int{"cbr": 2, "cbt": 1},
+	}, report)
+}
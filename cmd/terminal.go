@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// terminal is where progress gets drawn, and what it copes with. Redrawing
+// a line in place only works on an interactive console; pipes, files and CI
+// logs get plain lines instead. Consoles without escape sequences, like
+// older Windows ones, get lines blanked out with spaces, and ones that can't
+// show UTF-8 get names with anything else replaced.
+type terminal struct {
+	out io.Writer
+	// interactive is whether a line can be redrawn in place
+	interactive bool
+	// ansi is whether escape sequences like erase line are understood
+	ansi bool
+	// unicode is whether text past ASCII comes out right
+	unicode bool
+
+	// drawn is how wide the line being redrawn is, to blank it without ansi
+	drawn int
+}
+
+func newTerminal(f *os.File) *terminal {
+	t := &terminal{out: f, interactive: term.IsTerminal(int(f.Fd()))}
+	if t.interactive {
+		t.ansi = os.Getenv("TERM") != "dumb" && enableANSI(f)
+		t.unicode = consoleUnicode()
+	}
+	return t
+}
+
+// redraw replaces the current line with line.
+func (t *terminal) redraw(line string) {
+	line = t.text(line)
+	if t.ansi {
+		fmt.Fprintf(t.out, "\r%s\033[K", line)
+	} else {
+		fmt.Fprintf(t.out, "\r%s%s", line, strings.Repeat(" ", max(0, t.drawn-utf8.RuneCountInString(line))))
+	}
+	t.drawn = utf8.RuneCountInString(line)
+}
+
+// clear blanks the line redraw drew, leaving the cursor at its start.
+func (t *terminal) clear() {
+	if t.ansi {
+		fmt.Fprint(t.out, "\r\033[K")
+	} else {
+		fmt.Fprintf(t.out, "\r%s\r", strings.Repeat(" ", t.drawn))
+	}
+	t.drawn = 0
+}
+
+// text makes s safe to print, replacing anything the console can't show.
+func (t *terminal) text(s string) string {
+	if t.unicode {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if r > 0x7e || (r < 0x20 && r != '\n' && r != '\t') {
+			return '?'
+		}
+		return r
+	}, s)
+}
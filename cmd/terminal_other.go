@@ -0,0 +1,26 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"strings"
+)
+
+// enableANSI has nothing to switch on outside Windows, terminals there
+// understand escape sequences unless TERM says otherwise.
+func enableANSI(_ *os.File) bool {
+	return true
+}
+
+// consoleUnicode goes by the locale, the first of LC_ALL, LC_CTYPE and LANG
+// that is set. Without any, UTF-8 is the safe bet these days.
+func consoleUnicode() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			v = strings.ToLower(v)
+			return strings.Contains(v, "utf-8") || strings.Contains(v, "utf8")
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_terminal(t *testing.T) {
+	t.Run("ansi", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		term := &terminal{out: out, interactive: true, ansi: true, unicode: true}
+		term.redraw("Ünïcode 01.cbr")
+		term.clear()
+		require.Equal(t, "\rÜnïcode 01.cbr\033[K\r\033[K", out.String())
+	})
+
+	t.Run("no escape sequences", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		term := &terminal{out: out, interactive: true, unicode: true}
+		term.redraw("longer line")
+		term.redraw("short")
+		term.clear()
+		require.Equal(t, "\rlonger line\rshort      \r     \r", out.String())
+	})
+
+	t.Run("not utf-8", func(t *testing.T) {
+		term := &terminal{}
+		require.Equal(t, "Sag? 01 ??.cbr", term.text("Sagà 01 漫画.cbr"))
+	})
+}
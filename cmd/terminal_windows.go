@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+const utf8CodePage = 65001
+
+var procGetConsoleOutputCP = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetConsoleOutputCP")
+
+// enableANSI turns on escape sequence handling for the console, which
+// Windows 10 and later support but leave off. Older consoles refuse it.
+func enableANSI(f *os.File) bool {
+	var mode uint32
+	handle := windows.Handle(f.Fd())
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
+
+// consoleUnicode reports whether the console's output code page is UTF-8,
+// anything else turns the UTF-8 we write into garbage.
+func consoleUnicode() bool {
+	if procGetConsoleOutputCP.Find() != nil {
+		return false
+	}
+	cp, _, _ := procGetConsoleOutputCP.Call()
+	return cp == utf8CodePage
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+)
+
+var (
+	bwLimit string
+	ioNice  string
+)
+
+// IONiceLevels are the values --io-nice accepts. low still gets the disk
+// after everything else has had a turn, idle only when nothing else wants
+// it.
+var IONiceLevels = map[string]bool{"": true, "low": true, "idle": true}
+
+// throttleBurst is how far ahead of the rate reads and writes may get after
+// a pause.
+const throttleBurst = 100 * time.Millisecond
+
+// ioThrottle paces every local filesystem, nil without --bwlimit.
+var ioThrottle *throttle
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&bwLimit, "bwlimit", "", "most bytes a second read and written in all, across every job, e.g. 20MB, so a batch leaves the disks to media servers streaming from them; unlimited by default")
+	rootCmd.PersistentFlags().StringVar(&ioNice, "io-nice", "", "lower the disk priority of cbr2cbz and the unrar it runs, low or idle (Linux only)")
+}
+
+// setIOLimits checks --bwlimit and --io-nice and applies them. Not being
+// allowed a lower priority isn't worth refusing to run over.
+func setIOLimits() error {
+	ioThrottle = nil
+	if bwLimit != "" {
+		rate, err := humanize.ParseBytes(strings.TrimSuffix(bwLimit, "/s"))
+		if err != nil {
+			return errors.Errorf("--bwlimit %q is not a size", bwLimit)
+		}
+		if rate > 0 {
+			ioThrottle = newThrottle(float64(rate))
+		}
+	}
+	if !IONiceLevels[ioNice] {
+		return errors.Errorf("unknown --io-nice %q, use low or idle", ioNice)
+	}
+	if ioNice != "" {
+		if err := setIOPriority(ioNice); err != nil {
+			log.Printf("Unable to lower the disk priority, carrying on without: %s\n", err.Error())
+		}
+	}
+	return nil
+}
+
+// throttle paces the bytes going through it to rate a second, however many
+// readers and writers share it.
+type throttle struct {
+	rate float64
+
+	mu sync.Mutex
+	// due is when the bytes let through so far are paid for
+	due   time.Time
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newThrottle(rate float64) *throttle {
+	return &throttle{rate: rate, now: time.Now, sleep: time.Sleep}
+}
+
+// wait blocks until n more bytes fit under the rate. A nil throttle never
+// waits.
+func (t *throttle) wait(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	now := t.now()
+	if earliest := now.Add(-throttleBurst); t.due.Before(earliest) {
+		t.due = earliest
+	}
+	t.due = t.due.Add(time.Duration(float64(n) / t.rate * float64(time.Second)))
+	delay := t.due.Sub(now)
+	t.mu.Unlock()
+	if delay > 0 {
+		t.sleep(delay)
+	}
+}
+
+// throttleFS wraps fsys in --bwlimit, if it was given.
+func throttleFS(fsys hackpadfs.FS) hackpadfs.FS {
+	if ioThrottle == nil {
+		return fsys
+	}
+	return &throttledFS{base: fsys, t: ioThrottle}
+}
+
+// unthrottled is the filesystem under --bwlimit's.
+func unthrottled(fsys hackpadfs.FS) hackpadfs.FS {
+	if t, ok := fsys.(*throttledFS); ok {
+		return t.base
+	}
+	return fsys
+}
+
+// throttledFS is a local filesystem whose files read and write no faster
+// than t lets them. What unrar or the sandbox read through ToOSPath isn't
+// paced.
+type throttledFS struct {
+	base hackpadfs.FS
+	t    *throttle
+}
+
+func (f *throttledFS) ToOSPath(name string) (string, error) {
+	osFS, ok := f.base.(interface{ ToOSPath(string) (string, error) })
+	if !ok {
+		return "", errors.New("not a local filesystem")
+	}
+	return osFS.ToOSPath(name)
+}
+
+func (f *throttledFS) Open(name string) (hackpadfs.File, error) {
+	file, err := f.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledFile{File: file, t: f.t}, nil
+}
+
+func (f *throttledFS) OpenFile(name string, flag int, perm hackpadfs.FileMode) (hackpadfs.File, error) {
+	file, err := hackpadfs.OpenFile(f.base, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledFile{File: file, t: f.t}, nil
+}
+
+func (f *throttledFS) Mkdir(name string, perm hackpadfs.FileMode) error {
+	return hackpadfs.Mkdir(f.base, name, perm)
+}
+
+func (f *throttledFS) MkdirAll(name string, perm hackpadfs.FileMode) error {
+	return hackpadfs.MkdirAll(f.base, name, perm)
+}
+
+func (f *throttledFS) Remove(name string) error {
+	return hackpadfs.Remove(f.base, name)
+}
+
+func (f *throttledFS) RemoveAll(name string) error {
+	return hackpadfs.RemoveAll(f.base, name)
+}
+
+func (f *throttledFS) Rename(oldName, newName string) error {
+	return hackpadfs.Rename(f.base, oldName, newName)
+}
+
+func (f *throttledFS) Stat(name string) (hackpadfs.FileInfo, error) {
+	return hackpadfs.Stat(f.base, name)
+}
+
+func (f *throttledFS) Lstat(name string) (hackpadfs.FileInfo, error) {
+	return hackpadfs.Lstat(f.base, name)
+}
+
+func (f *throttledFS) Chmod(name string, mode hackpadfs.FileMode) error {
+	return hackpadfs.Chmod(f.base, name, mode)
+}
+
+func (f *throttledFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return hackpadfs.Chtimes(f.base, name, atime, mtime)
+}
+
+func (f *throttledFS) ReadDir(name string) ([]hackpadfs.DirEntry, error) {
+	return hackpadfs.ReadDir(f.base, name)
+}
+
+// throttledFile waits on t for the bytes it read or wrote, once they are.
+type throttledFile struct {
+	hackpadfs.File
+	t *throttle
+}
+
+func (f *throttledFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.t.wait(n)
+	return n, err
+}
+
+func (f *throttledFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := hackpadfs.ReadAtFile(f.File, p, off)
+	f.t.wait(n)
+	return n, err
+}
+
+func (f *throttledFile) Write(p []byte) (int, error) {
+	n, err := hackpadfs.WriteFile(f.File, p)
+	f.t.wait(n)
+	return n, err
+}
+
+func (f *throttledFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := hackpadfs.WriteAtFile(f.File, p, off)
+	f.t.wait(n)
+	return n, err
+}
+
+func (f *throttledFile) Seek(offset int64, whence int) (int64, error) {
+	return hackpadfs.SeekFile(f.File, offset, whence)
+}
+
+func (f *throttledFile) ReadDir(n int) ([]hackpadfs.DirEntry, error) {
+	return hackpadfs.ReadDirFile(f.File, n)
+}
+
+func (f *throttledFile) Sync() error {
+	return hackpadfs.SyncFile(f.File)
+}
+
+func (f *throttledFile) Truncate(size int64) error {
+	return hackpadfs.TruncateFile(f.File, size)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_throttle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	slept := time.Duration(0)
+	th := &throttle{rate: 1000, now: func() time.Time { return now }, sleep: func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}}
+
+	th.wait(100)
+	require.Zero(t, slept, "the first tenth of a second's worth goes straight through")
+	th.wait(1000)
+	require.Equal(t, time.Second, slept)
+
+	now = now.Add(time.Minute)
+	slept = 0
+	th.wait(100)
+	require.Zero(t, slept, "a pause only earns the burst back, not a minute's worth")
+	th.wait(500)
+	require.Equal(t, 500*time.Millisecond, slept)
+
+	var unlimited *throttle
+	unlimited.wait(1 << 30)
+}
+
+func Test_throttledFS(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{"library/a.cbr": []byte("0123456789")})
+	require.NoError(t, err)
+
+	paced := 0
+	th := &throttle{rate: 1, now: func() time.Time { return time.Time{} }, sleep: func(time.Duration) { paced++ }}
+	tfs := &throttledFS{base: fsys, t: th}
+
+	file, err := tfs.Open("library/a.cbr")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	n, err := file.(io.ReaderAt).ReadAt(buf, 2)
+	require.NoError(t, err)
+	require.Equal(t, "2345", string(buf[:n]))
+	require.NoError(t, file.Close())
+
+	out, err := hackpadfs.Create(tfs, "library/a.cbz")
+	require.NoError(t, err)
+	_, err = out.(io.Writer).Write([]byte("zip"))
+	require.NoError(t, err)
+	require.NoError(t, out.Close())
+	data, err := hackpadfs.ReadFile(fsys, "library/a.cbz")
+	require.NoError(t, err)
+	require.Equal(t, "zip", string(data))
+	require.Equal(t, 2, paced, "both the read and the write wait their turn")
+
+	require.Equal(t, fsys, unthrottled(tfs))
+	require.Equal(t, fsys, unthrottled(fsys))
+}
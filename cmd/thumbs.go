@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	thumbsDir    string
+	thumbsSize   string
+	thumbsFormat string
+)
+
+// thumbsCmd represents the thumbs command
+var thumbsCmd = &cobra.Command{
+	Use:   "thumbs [paths...]",
+	Short: "Writes a thumbnail of the cover of each archive into a cache directory",
+	Long: `Writes a thumbnail of the cover of each cbz, cbr, cb7 and cbt into --thumbs-dir,
+for gallery UIs to show. Each is named after the SHA-256 of the archive, in a folder
+of the first two characters of it: ab/ab12...ef.jpg. Archives whose thumbnail is
+there already are skipped, an archive that changes gets a new one.
+
+convert --thumbs-dir writes them for the cbz files it converts, the same way.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArchives,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		logger.SetOutput(consoleOutput(os.Stdout))
+
+		if thumbsDir == "" {
+			logger.Fatal("--thumbs-dir is needed, there is no cache directory on this system")
+		}
+		t, err := newThumbnailer(thumbsDir, thumbsSize, thumbsFormat)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fsys, err := newLocalFS()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fsys, args, err = localArgs(fsys, args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		err = t.run(cmd.Context(), fsys, logger, args)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(thumbsCmd)
+
+	thumbsCmd.Flags().StringVar(&thumbsDir, "thumbs-dir", defaultThumbsDir(), "cache directory the thumbnails are written to")
+	thumbsCmd.RegisterFlagCompletionFunc("thumbs-dir", completeDirs)
+	addThumbFlags(thumbsCmd)
+}
+
+// addThumbFlags registers the flags shaping thumbnails on cmd.
+func addThumbFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&thumbsSize, "thumbs-size", "300", "size thumbnails are scaled down to fit, a width, WxH or xH (e.g. 300x450)")
+	cmd.Flags().StringVar(&thumbsFormat, "thumbs-format", "jpeg", "format of thumbnails, jpeg, png, webp or avif, optionally with a quality (e.g. webp:75)")
+}
+
+func defaultThumbsDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbr2cbz", "thumbs")
+}
+
+// thumbnailer writes a thumbnail of the cover of archives into dir, named
+// after the SHA-256 of each so gallery UIs can find it from the file alone.
+type thumbnailer struct {
+	dir     string
+	images  cbr2cbz.ImageOptions
+	entries cbr2cbz.EntryFilter
+}
+
+func newThumbnailer(dir string, size string, format string) (*thumbnailer, error) {
+	images, err := parseRecompress(format)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing --thumbs-format")
+	}
+	images.MaxWidth, images.MaxHeight, err = parseThumbSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return &thumbnailer{dir: dir, images: images}, nil
+}
+
+// parseThumbSize parses a --thumbs-size, 300, 300x450 or x450.
+func parseThumbSize(size string) (int, int, error) {
+	w, h, _ := strings.Cut(strings.ToLower(size), "x")
+	dims := []int{0, 0}
+	for i, s := range []string{w, h} {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, errors.Errorf("invalid --thumbs-size %q, expected a width, WxH or xH", size)
+		}
+		dims[i] = n
+	}
+	if dims[0] == 0 && dims[1] == 0 {
+		return 0, 0, errors.Errorf("invalid --thumbs-size %q, expected a width, WxH or xH", size)
+	}
+	return dims[0], dims[1], nil
+}
+
+func (t *thumbnailer) run(ctx context.Context, fsys hackpadfs.FS, logger logger, paths []string) error {
+	files, err := findArchives(fsys, paths, "cbz, cbr, cb7 or cbt", verifiedExtensions...)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		dest, written, err := t.write(ctx, fsys, file)
+		if err != nil {
+			logger.Printf("Error making a thumbnail of %s - Skipping...%s\n", file, err.Error())
+			failed++
+			continue
+		}
+		if written {
+			logger.Printf("Thumbnail of %s written to %s\n", file, dest)
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+// path is where the thumbnail of the archive with the SHA-256 sum goes.
+func (t *thumbnailer) path(sum string) string {
+	ext := "." + t.images.Format
+	if t.images.Format == "jpeg" {
+		ext = ".jpg"
+	}
+	return filepath.Join(t.dir, sum[:2], sum+ext)
+}
+
+// write makes the thumbnail of file unless the cache has it already. It
+// returns where the thumbnail is and whether it was written now.
+func (t *thumbnailer) write(ctx context.Context, fsys hackpadfs.FS, file string) (string, bool, error) {
+	sum, err := fileSum(fsys, pathToFsPath(file))
+	if err != nil {
+		return "", false, err
+	}
+	dest := t.path(sum)
+	if _, err := os.Stat(dest); err == nil {
+		return dest, false, nil
+	}
+
+	archive, err := openArchive(ctx, fsys, pathToFsPath(file))
+	if err != nil {
+		return "", false, err
+	}
+	defer archive.Close()
+	pages, err := archive.pages(t.entries)
+	if err != nil {
+		return "", false, err
+	}
+	cover := cbr2cbz.CoverPage(pages)
+	if cover == "" {
+		return "", false, errors.New("no pages found")
+	}
+	data, err := fs.ReadFile(archive, cover)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "reading %s", cover)
+	}
+	_, thumb, err := t.images.Process(cover, data)
+	if err != nil {
+		return "", false, err
+	}
+
+	// renamed into place once complete, so a gallery never sees half of one
+	err = os.MkdirAll(filepath.Dir(dest), 0o755)
+	if err != nil {
+		return "", false, errors.Wrap(err, "creating thumbnail folder")
+	}
+	part, err := os.CreateTemp(filepath.Dir(dest), ".thumb-*")
+	if err != nil {
+		return "", false, errors.Wrap(err, "writing thumbnail")
+	}
+	_, err = part.Write(thumb)
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(part.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(part.Name())
+		return "", false, errors.Wrap(err, "writing thumbnail")
+	}
+	return dest, true, nil
+}
+
+// writeThumbnail makes the thumbnail of cbzFile, converted from cbrFile,
+// when --thumbs-dir is set. Conversions split into chapters made more than
+// one cbz and get none.
+func (c *converter) writeThumbnail(ctx context.Context, cbrFile string, cbzFile string) {
+	if c.thumbs == nil {
+		return
+	}
+	if info, err := hackpadfs.Stat(c.fs, pathToFsPath(cbzFile)); err != nil || info.IsDir() {
+		return
+	}
+	dest, _, err := c.thumbs.write(ctx, c.fs, cbzFile)
+	if err != nil {
+		c.warn(cbr2cbz.CodeThumbnailFailed, cbrFile, "Unable to write the thumbnail of %s: %s", cbzFile, err.Error())
+		return
+	}
+	c.logFor(cbrFile).Printf("Thumbnail of %s written to %s\n", cbzFile, dest)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseThumbSize(t *testing.T) {
+	tests := []struct {
+		size          string
+		width, height int
+		wantErr       bool
+	}{
+		{size: "300", width: 300},
+		{size: "300x450", width: 300, height: 450},
+		{size: "x450", height: 450},
+		{size: "300X450", width: 300, height: 450},
+		{size: "", wantErr: true},
+		{size: "x", wantErr: true},
+		{size: "big", wantErr: true},
+		{size: "0x0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			width, height, err := parseThumbSize(tt.size)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.width, width)
+			require.Equal(t, tt.height, height)
+		})
+	}
+}
+
+func Test_thumbnailer(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/Saga 001.cbz": makeZip(t, map[string]string{
+			"001.png":   string(makePNG(t, 64, 96)),
+			"cover.png": string(makePNG(t, 200, 300)),
+		}),
+		"comics/notes.txt": []byte("not an archive"),
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	th, err := newThumbnailer(dir, "100", "jpeg:80")
+	require.NoError(t, err)
+	require.NoError(t, th.run(context.Background(), fsys, testLogger{t}, []string{"/comics"}))
+
+	sum, err := fileSum(fsys, "comics/Saga 001.cbz")
+	require.NoError(t, err)
+	dest := filepath.Join(dir, sum[:2], sum+".jpg")
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, "jpeg", format)
+	// the cover, not the first page
+	require.Equal(t, 100, cfg.Width)
+	require.Equal(t, 150, cfg.Height)
+
+	got, written, err := th.write(context.Background(), fsys, "/comics/Saga 001.cbz")
+	require.NoError(t, err)
+	require.False(t, written)
+	require.Equal(t, dest, got)
+}
+
+func Test_convertThumbnail(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/new.cbt": makeTar(t, map[string]string{"001.png": string(makePNG(t, 64, 64))}),
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	th, err := newThumbnailer(dir, "32", "png")
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}, thumbs: th}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Equal(t, []string{"/library/new.cbz"}, c.converted)
+
+	sum, err := fileSum(fsys, "library/new.cbz")
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(dir, sum[:2], sum+".png"))
+}
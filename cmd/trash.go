@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+)
+
+var (
+	trashOriginals bool
+	backupDir      string
+)
+
+// disposeOriginal gets rid of cbrFile once it has been converted to
+// cbzFile, moving it under --backup-dir or to the trash when asked to, and
+// recording where it went for purge, deleting it otherwise.
+func (c *converter) disposeOriginal(cbrFile string, cbzFile string) error {
+	name := pathToFsPath(cbrFile)
+	switch {
+	case c.backupDir != "":
+		dst := path.Join(pathToFsPath(c.backupDir), c.relPath(cbrFile))
+		err := hackpadfs.MkdirAll(c.fs, path.Dir(dst), 0755)
+		if err != nil {
+			return errors.Wrap(err, "creating backup directory")
+		}
+		dst, err = freeName(c.fs, dst)
+		if err != nil {
+			return err
+		}
+		err = moveFile(c.fs, name, dst)
+		if err != nil {
+			return errors.Wrap(err, "moving old cbr to the backup directory")
+		}
+		if osFS, ok := c.fs.(interface{ ToOSPath(string) (string, error) }); ok {
+			kept, _ := osFS.ToOSPath(dst)
+			c.recordRetained(cbrFile, cbzFile, kept, "backup")
+		}
+		return nil
+	case c.trash:
+		osFS, ok := c.fs.(interface{ ToOSPath(string) (string, error) })
+		if !ok {
+			return errors.New("--trash only works for files on a local disk")
+		}
+		osPath, err := osFS.ToOSPath(name)
+		if err != nil {
+			return errors.Wrap(err, "finding old cbr")
+		}
+		trashed, err := moveToTrash(osPath)
+		if err != nil {
+			return errors.Wrap(err, "moving old cbr to the trash")
+		}
+		c.recordRetained(cbrFile, cbzFile, trashed, "trash")
+		return nil
+	}
+	return errors.Wrap(hackpadfs.Remove(c.fs, name), "deleting old cbr")
+}
+
+// numberedName is the n-th name to try for base when it is taken,
+// "001.cbr", "001.2.cbr", "001.3.cbr" and so on.
+func numberedName(base string, n int) string {
+	if n < 2 {
+		return base
+	}
+	ext := path.Ext(base)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), n, ext)
+}
+
+// freeName is name, or name numbered until it doesn't exist yet.
+func freeName(fsys hackpadfs.FS, name string) (string, error) {
+	dir, base := path.Split(name)
+	for n := 1; ; n++ {
+		candidate := dir + numberedName(base, n)
+		_, err := hackpadfs.Stat(fsys, candidate)
+		if errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "checking %s", candidate)
+		}
+	}
+}
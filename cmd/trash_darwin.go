@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// moveToTrash moves osPath into ~/.Trash, or the .Trashes folder of the
+// volume it's on, under a free name, and returns that.
+func moveToTrash(osPath string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "finding the trash")
+	}
+	dirs := []string{filepath.Join(home, ".Trash")}
+	if vol := volumeOf(osPath); vol != "" {
+		dirs = append(dirs, filepath.Join(vol, ".Trashes", strconv.Itoa(os.Getuid())))
+	}
+
+	var moveErr error
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			moveErr = err
+			continue
+		}
+		base := filepath.Base(osPath)
+		trashed := ""
+		for n := 1; ; n++ {
+			trashed = filepath.Join(dir, numberedName(base, n))
+			if _, err := os.Lstat(trashed); err == nil {
+				continue
+			}
+			moveErr = os.Rename(osPath, trashed)
+			break
+		}
+		if moveErr == nil {
+			return trashed, nil
+		}
+	}
+	return "", moveErr
+}
+
+// volumeOf is the /Volumes/name osPath is on, if it is on one.
+func volumeOf(osPath string) string {
+	abs, err := filepath.Abs(osPath)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(abs, string(filepath.Separator))
+	if len(parts) > 2 && parts[1] == "Volumes" {
+		return filepath.Join("/", parts[1], parts[2])
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_numberedName(t *testing.T) {
+	require.Equal(t, "001.cbr", numberedName("001.cbr", 1))
+	require.Equal(t, "001.2.cbr", numberedName("001.cbr", 2))
+	require.Equal(t, "README.3", numberedName("README", 3))
+}
+
+func Test_convertBackupDir(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga/test.cbr": realCBRContents,
+		// backed up by an earlier run
+		"backup/Saga/test.cbr": []byte("older"),
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, backupDir: "/backup"}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+
+	_, err = hackpadfs.Stat(fsys, "library/Saga/test.cbr")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	data, err := fs.ReadFile(fsys, "backup/Saga/test.2.cbr")
+	require.NoError(t, err)
+	require.Equal(t, realCBRContents, data)
+	data, err = fs.ReadFile(fsys, "backup/Saga/test.cbr")
+	require.NoError(t, err)
+	require.Equal(t, "older", string(data))
+}
+
+func Test_convertTrashNeedsLocalFiles(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{"library/test.cbr": realCBRContents})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, trash: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.ErrorContains(t, c.failed["/library/test.cbr"], "--trash only works for files on a local disk")
+	_, err = hackpadfs.Stat(fsys, "library/test.cbr")
+	require.NoError(t, err)
+}
@@ -0,0 +1,112 @@
+//go:build !windows && !darwin
+
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// moveToTrash moves osPath into the freedesktop.org trash, the one in the
+// home directory when it's on the same filesystem, otherwise the one at
+// the top of the filesystem the file is on, so file managers can restore it.
+// It returns where in the trash the file went.
+func moveToTrash(osPath string) (string, error) {
+	osPath, err := filepath.Abs(osPath)
+	if err != nil {
+		return "", err
+	}
+	dir, err := trashDir(osPath)
+	if err != nil {
+		return "", err
+	}
+	for _, sub := range []string{"files", "info"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0700)
+		if err != nil {
+			return "", errors.Wrap(err, "creating trash")
+		}
+	}
+
+	base := filepath.Base(osPath)
+	for n := 1; ; n++ {
+		name := numberedName(base, n)
+		trashed := filepath.Join(dir, "files", name)
+		if _, err := os.Lstat(trashed); err == nil {
+			continue
+		}
+		// the info file is created first, claiming the name
+		infoFile := filepath.Join(dir, "info", name+".trashinfo")
+		f, err := os.OpenFile(infoFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "writing trash info")
+		}
+		_, err = fmt.Fprintf(f, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+			(&url.URL{Path: osPath}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(osPath, trashed)
+		}
+		if err != nil {
+			os.Remove(infoFile)
+			return "", err
+		}
+		return trashed, nil
+	}
+}
+
+// trashDir is the trash osPath goes to.
+func trashDir(osPath string) (string, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "finding the trash")
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+
+	dev, err := deviceOf(filepath.Dir(osPath))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(data, 0700); err == nil {
+		if homeDev, err := deviceOf(data); err == nil && homeDev == dev {
+			return filepath.Join(data, "Trash"), nil
+		}
+	}
+
+	top := filepath.Dir(osPath)
+	for {
+		parent := filepath.Dir(top)
+		parentDev, err := deviceOf(parent)
+		if parent == top || err != nil || parentDev != dev {
+			break
+		}
+		top = parent
+	}
+	return filepath.Join(top, fmt.Sprintf(".Trash-%d", os.Getuid())), nil
+}
+
+func deviceOf(osPath string) (uint64, error) {
+	info, err := os.Stat(osPath)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errors.Errorf("no device for %s", osPath)
+	}
+	return uint64(st.Dev), nil
+}
@@ -0,0 +1,57 @@
+//go:build !windows && !darwin
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_moveToTrash(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+
+	for i := 0; i < 2; i++ {
+		file := filepath.Join(dir, "my comic.cbr")
+		require.NoError(t, os.WriteFile(file, []byte("rar"), 0o644))
+		trashed, err := moveToTrash(file)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, "data", "Trash", "files", numberedName("my comic.cbr", i+1)), trashed)
+		_, err = os.Stat(file)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	trash := filepath.Join(dir, "data", "Trash")
+	for _, name := range []string{"my comic.cbr", "my comic.2.cbr"} {
+		_, err := os.Stat(filepath.Join(trash, "files", name))
+		require.NoError(t, err)
+		info, err := os.ReadFile(filepath.Join(trash, "info", name+".trashinfo"))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(info), "[Trash Info]\nPath="+filepath.ToSlash(dir)+"/my%20comic.cbr\nDeletionDate="))
+	}
+}
+
+func Test_convertTrash(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "library"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "library", "test.cbr"), realCBRContents, 0o644))
+
+	fsys, err := hackpados.NewFS().Sub(strings.TrimPrefix(filepath.ToSlash(dir), "/"))
+	require.NoError(t, err)
+	c := &converter{fs: fsys, logger: testLogger{t}, trash: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+
+	_, err = os.Stat(filepath.Join(dir, "library", "test.cbz"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "data", "Trash", "files", "test.cbr"))
+	require.NoError(t, err)
+	require.Equal(t, realCBRContents, data)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	shell32              = syscall.NewLazyDLL("shell32.dll")
+	procSHFileOperationW = shell32.NewProc("SHFileOperationW")
+)
+
+const (
+	foDelete          = 0x0003
+	fofSilent         = 0x0004
+	fofNoConfirmation = 0x0010
+	fofAllowUndo      = 0x0040
+	fofNoErrorUI      = 0x0400
+)
+
+// shFileOpStruct is SHFILEOPSTRUCTW.
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+// moveToTrash sends osPath to the recycle bin. Where it went there isn't
+// known, so it returns an empty string.
+func moveToTrash(osPath string) (string, error) {
+	from, err := syscall.UTF16FromString(osPath)
+	if err != nil {
+		return "", err
+	}
+	// pFrom is a list, ended by an extra null
+	from = append(from, 0)
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+	ret, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op)))
+	if ret != 0 {
+		return "", errors.Errorf("SHFileOperation failed with %#x", ret)
+	}
+	if op.fAnyOperationsAborted != 0 {
+		return "", errors.New("moving to the recycle bin was aborted")
+	}
+	return "", nil
+}
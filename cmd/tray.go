@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	trayAddr   string
+	trayNoOpen bool
+)
+
+// trayCmd represents the tray command
+var trayCmd = &cobra.Command{
+	Use:   "tray",
+	Short: "Runs serve with an icon in the system tray, for using cbr2cbz without a terminal",
+	Long: `Runs serve on this machine only, with an icon in the system tray. Clicking
+Open cbr2cbz opens the web page in the browser, to drop archives on and
+download the cbz files from; it is opened once on start too, unless --no-open
+is given. While jobs run the icon's title and tooltip say how many.
+
+Takes all the serve and convert flags. Quit stops taking new jobs and gives the
+running one --drain-timeout to finish.
+
+Not in the released macOS binaries, which are built without cgo; build
+cbr2cbz with CGO_ENABLED=1 on a Mac for it.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+		s, err := newServeServer(cmd, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if !s.webUI {
+			logger.Fatal("tray needs the web page, drop --web-ui=false")
+		}
+
+		health := newHealthState(0)
+		work, hard, stop := drainOnSignal(cmd.Context(), health, serveDrainTimeout)
+		defer stop()
+		ctx, quit := context.WithCancel(work)
+		defer quit()
+
+		httpServer := s.listen(trayAddr, health)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.run(ctx, hard)
+		}()
+
+		url := trayURL(trayAddr)
+		if !trayNoOpen {
+			if err := openBrowser(url); err != nil {
+				logger.Printf("Unable to open %s: %s\n", url, err.Error())
+			}
+		}
+		err = runTray(ctx, trayMenu{
+			url:    url,
+			active: s.activeJobs,
+			quit:   quit,
+		})
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		select {
+		case <-done:
+		case <-time.After(serveDrainTimeout):
+			stop()
+			<-done
+		}
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trayCmd)
+
+	trayCmd.Flags().StringVar(&trayAddr, "addr", "127.0.0.1:8765", "address to serve the web page and api on")
+	trayCmd.Flags().BoolVar(&trayNoOpen, "no-open", false, "don't open the web page on start")
+	trayCmd.Flags().AddFlagSet(serveCmd.Flags())
+}
+
+// trayMenu is what the tray icon offers, whatever draws it.
+type trayMenu struct {
+	// url is the web page
+	url string
+	// active is how many jobs are queued or running
+	active func() int
+	// quit stops the server once asked to from the menu
+	quit func()
+}
+
+// status is the title and tooltip of the icon.
+func (m trayMenu) status() (title string, tooltip string) {
+	switch n := m.active(); n {
+	case 0:
+		return "", "cbr2cbz, idle"
+	case 1:
+		return "1", "cbr2cbz, 1 job running"
+	default:
+		return fmt.Sprint(n), fmt.Sprintf("cbr2cbz, %d jobs running", n)
+	}
+}
+
+// trayURL is the address of the web page served on addr, localhost when it
+// listens on every interface.
+func trayURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr + "/"
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
+
+// activeJobs is how many jobs are queued or running.
+func (s *server) activeJobs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, job := range s.jobs {
+		if job.Status == jobQueued || job.Status == jobRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// trayIcon is the tray icon, drawn here so there is no image to ship: a
+// rounded green square with a white page on it.
+func trayIcon() []byte {
+	const size = 32
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	green := color.NRGBA{0x22, 0xaa, 0x77, 0xff}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// cut the corners
+			dx, dy := min(x, size-1-x), min(y, size-1-y)
+			if dx+dy < 4 {
+				continue
+			}
+			img.Set(x, y, green)
+			if x >= 9 && x < 23 && y >= 6 && y < 26 {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	// encoding an image held in memory doesn't fail
+	png.Encode(buf, img)
+	return buf.Bytes()
+}
+
+// icoOf wraps a png in an ico file, which is what Windows wants for tray
+// icons.
+func icoOf(pngData []byte, size int) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, struct {
+		Reserved, Type, Count uint16
+	}{0, 1, 1})
+	binary.Write(buf, binary.LittleEndian, struct {
+		Width, Height, Colors, Reserved uint8
+		Planes, BitCount                uint16
+		Size, Offset                    uint32
+	}{uint8(size), uint8(size), 0, 0, 1, 32, uint32(len(pngData)), 6 + 16})
+	buf.Write(pngData)
+	return buf.Bytes()
+}
@@ -0,0 +1,15 @@
+//go:build darwin && !cgo
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// runTray needs cgo on macOS, where the tray is only reachable through
+// Cocoa.
+func runTray(_ context.Context, _ trayMenu) error {
+	return errors.New("this build of cbr2cbz has no tray support, the released macOS binaries are built without cgo; build it with CGO_ENABLED=1 on a Mac for the tray")
+}
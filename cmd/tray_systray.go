@@ -0,0 +1,50 @@
+//go:build !darwin || cgo
+
+package cmd
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"fyne.io/systray"
+)
+
+// runTray shows the tray icon until m.quit is picked or ctx is done. It has
+// to be called from the main goroutine.
+func runTray(ctx context.Context, m trayMenu) error {
+	icon := trayIcon()
+	if runtime.GOOS == "windows" {
+		icon = icoOf(icon, 32)
+	}
+	systray.Run(func() {
+		systray.SetTemplateIcon(icon, icon)
+		title, tooltip := m.status()
+		systray.SetTitle(title)
+		systray.SetTooltip(tooltip)
+		open := systray.AddMenuItem("Open cbr2cbz", "Open the page to drop archives on")
+		systray.AddSeparator()
+		quit := systray.AddMenuItem("Quit", "Stop once the running job is done")
+
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-open.ClickedCh:
+					openBrowser(m.url)
+				case <-quit.ClickedCh:
+					m.quit()
+				case <-ticker.C:
+					title, tooltip := m.status()
+					systray.SetTitle(title)
+					systray.SetTooltip(tooltip)
+				case <-ctx.Done():
+					systray.Quit()
+					return
+				}
+			}
+		}()
+	}, nil)
+	return nil
+}
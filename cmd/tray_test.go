@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_trayURL(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"127.0.0.1:8765", "http://127.0.0.1:8765/"},
+		{":8080", "http://localhost:8080/"},
+		{"0.0.0.0:8080", "http://localhost:8080/"},
+		{"[::]:8080", "http://localhost:8080/"},
+		{"nas.local:80", "http://nas.local:80/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			require.Equal(t, tt.want, trayURL(tt.addr))
+		})
+	}
+}
+
+func Test_trayMenu_status(t *testing.T) {
+	active := 0
+	m := trayMenu{active: func() int { return active }}
+	title, tooltip := m.status()
+	require.Empty(t, title)
+	require.Equal(t, "cbr2cbz, idle", tooltip)
+
+	active = 3
+	title, tooltip = m.status()
+	require.Equal(t, "3", title)
+	require.Equal(t, "cbr2cbz, 3 jobs running", tooltip)
+}
+
+func Test_trayIcon(t *testing.T) {
+	icon := trayIcon()
+	img, err := png.Decode(bytes.NewReader(icon))
+	require.NoError(t, err)
+	require.Equal(t, 32, img.Bounds().Dx())
+
+	ico := icoOf(icon, 32)
+	require.Equal(t, []byte{0, 0, 1, 0, 1, 0, 32, 32}, ico[:8])
+	require.Equal(t, uint32(len(icon)), binary.LittleEndian.Uint32(ico[14:18]))
+	require.Equal(t, icon, ico[22:])
+}
@@ -0,0 +1,446 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// errSkipped is the cause of a file being canceled with the skip key.
+var errSkipped = errors.Wrap(context.Canceled, "skipped")
+
+// tuiLogLines is how many log lines the TUI keeps to show.
+const tuiLogLines = 100
+
+// batchTUI is the full screen view of a batch --tui shows: the files queued,
+// the ones converting with how far along each is, the ones that failed and
+// the latest log lines. Keys pause the batch, skip a file or retry the ones
+// that failed. Pausing stops new files from starting, the ones converting
+// carry on.
+type batchTUI struct {
+	term *terminal
+	// in is where keys are read from, nil for none
+	in *os.File
+	// size is the width and height of the screen
+	size func() (int, int)
+
+	mu        sync.Mutex
+	running   bool
+	files     int
+	total     uint64
+	doneFiles int
+	doneBytes uint64
+	queue     []string
+	active    []*tuiFile
+	failed    []tuiFailure
+	logs      []string
+	partial   string
+	selected  int
+	// resume is closed when the batch is unpaused, nil while it isn't paused
+	resume chan struct{}
+	// changed is closed when a file finishes or retries are asked for, so
+	// nextRetry can look again
+	changed chan struct{}
+	retries []string
+	quit    context.CancelFunc
+	quitted bool
+}
+
+type tuiFile struct {
+	name     string
+	size     uint64
+	progress *cbr2cbz.Progress
+	cancel   context.CancelCauseFunc
+}
+
+type tuiFailure struct {
+	name string
+	err  string
+}
+
+func newBatchTUI(t *terminal, in *os.File, size func() (int, int)) *batchTUI {
+	return &batchTUI{term: t, in: in, size: size, changed: make(chan struct{})}
+}
+
+// terminalSize is the size of the screen f is on, 80x24 if it can't tell.
+func terminalSize(f *os.File) func() (int, int) {
+	return func() (int, int) {
+		w, h, err := term.GetSize(int(f.Fd()))
+		if err != nil || w <= 0 || h <= 0 {
+			return 80, 24
+		}
+		return w, h
+	}
+}
+
+// begin takes over the screen for files until the returned stop function
+// is called. quit cancels the batch.
+func (t *batchTUI) begin(files []string, total uint64, quit context.CancelFunc, interval time.Duration) (stop func()) {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	t.running = true
+	t.files, t.total = len(files), total
+	t.doneFiles, t.doneBytes = 0, 0
+	t.queue = append([]string{}, files...)
+	t.quit = quit
+	t.mu.Unlock()
+
+	restore := func() {}
+	if t.in != nil {
+		if state, err := term.MakeRaw(int(t.in.Fd())); err == nil {
+			restore = func() { term.Restore(int(t.in.Fd()), state) }
+		}
+		go t.readKeys(t.in)
+	}
+	// the alternate screen, so the shell is left as it was
+	fmt.Fprint(t.term.out, "\033[?1049h\033[?25l")
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				t.redraw()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.running = false
+			fmt.Fprint(t.term.out, "\033[?25h\033[?1049l")
+			restore()
+		})
+	}
+}
+
+// readKeys handles keys from in until it closes.
+func (t *batchTUI) readKeys(in io.Reader) {
+	r := bufio.NewReader(in)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		key := string(b)
+		if b == 0x1b {
+			// arrow keys are ESC [ A and ESC [ B
+			seq := make([]byte, 2)
+			if _, err := io.ReadFull(r, seq); err != nil {
+				return
+			}
+			key += string(seq)
+		}
+		t.key(key)
+		t.redraw()
+	}
+}
+
+// key acts on a key press.
+func (t *batchTUI) key(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch key {
+	case "p", " ":
+		if t.resume == nil {
+			t.resume = make(chan struct{})
+		} else {
+			close(t.resume)
+			t.resume = nil
+		}
+	case "s":
+		if t.selected < len(t.active) && t.active[t.selected].cancel != nil {
+			t.active[t.selected].cancel(errSkipped)
+		}
+	case "r":
+		for _, f := range t.failed {
+			t.retries = append(t.retries, f.name)
+			t.queue = append(t.queue, f.name)
+		}
+		t.files += len(t.failed)
+		t.failed = nil
+		t.notify()
+	case "k", "\033[A":
+		t.selected = max(t.selected-1, 0)
+	case "j", "\033[B":
+		t.selected = min(t.selected+1, max(len(t.active)-1, 0))
+	case "q", "\x03":
+		t.quitted = true
+		if t.quit != nil {
+			t.quit()
+		}
+		t.notify()
+	}
+}
+
+// notify wakes nextRetry.
+func (t *batchTUI) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// waitWhilePaused blocks while the batch is paused.
+func (t *batchTUI) waitWhilePaused(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	resume := t.resume
+	t.mu.Unlock()
+	if resume == nil {
+		return nil
+	}
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fileContext is ctx for converting name, canceled when it is skipped.
+func (t *batchTUI) fileContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	if t == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	t.mu.Lock()
+	t.remove(name)
+	t.active = append(t.active, &tuiFile{name: name, progress: &cbr2cbz.Progress{}, cancel: cancel})
+	t.mu.Unlock()
+	return ctx, func() { cancel(nil) }
+}
+
+// remove takes name off the queue.
+func (t *batchTUI) remove(name string) {
+	for i, queued := range t.queue {
+		if queued == name {
+			t.queue = append(t.queue[:i], t.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// start gives the file being converted its size and progress.
+func (t *batchTUI) start(name string, size uint64, p *cbr2cbz.Progress) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range t.active {
+		if f.name == name {
+			f.size, f.progress = size, p
+		}
+	}
+}
+
+// finish moves name from the active files to the done or failed ones.
+func (t *batchTUI) finish(name string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, f := range t.active {
+		if f.name == name {
+			t.doneBytes += f.size
+			t.active = append(t.active[:i], t.active[i+1:]...)
+			break
+		}
+	}
+	t.selected = min(t.selected, max(len(t.active)-1, 0))
+	t.doneFiles++
+	if err != nil && !isSkip(err) {
+		t.failed = append(t.failed, tuiFailure{name: name, err: err.Error()})
+	}
+	t.notify()
+}
+
+// nextRetry waits for a file to retry once the files found have all been
+// started. It returns false once everything is done and nothing failed, or
+// on quitting.
+func (t *batchTUI) nextRetry(ctx context.Context) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	for {
+		t.mu.Lock()
+		if len(t.retries) > 0 {
+			next := t.retries[0]
+			t.retries = t.retries[1:]
+			t.mu.Unlock()
+			return next, true
+		}
+		if t.quitted || (len(t.active) == 0 && len(t.failed) == 0) {
+			t.mu.Unlock()
+			return "", false
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// Write keeps log output to show under the files, or prints it once the
+// TUI is gone.
+func (t *batchTUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.running {
+		return t.term.out.Write(p)
+	}
+	lines := strings.Split(t.partial+string(p), "\n")
+	t.partial = lines[len(lines)-1]
+	t.logs = append(t.logs, lines[:len(lines)-1]...)
+	if len(t.logs) > tuiLogLines {
+		t.logs = t.logs[len(t.logs)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+func (t *batchTUI) redraw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.running {
+		return
+	}
+	width, height := t.size()
+	lines := t.render(width, height)
+	fmt.Fprint(t.term.out, "\033[H"+strings.Join(lines, "\033[K\r\n")+"\033[K\033[J")
+}
+
+// render lays the screen out as lines no wider than width, height of them
+// at most.
+func (t *batchTUI) render(width int, height int) []string {
+	processed := float64(t.doneBytes)
+	for _, f := range t.active {
+		processed += float64(f.size) * f.progress.Fraction()
+	}
+	fraction := 0.0
+	if t.total > 0 {
+		fraction = min(processed/float64(t.total), 1)
+	}
+
+	status := ""
+	switch {
+	case t.quitted:
+		status = " | quitting"
+	case t.resume != nil:
+		status = " | paused, new files wait"
+	case len(t.queue) == 0 && len(t.active) == 0:
+		status = " | done"
+	}
+	header := []string{
+		fmt.Sprintf("cbr2cbz %3.0f%% %s/%s %d/%d files, %d failed%s",
+			fraction*100, formatBytes(uint64(processed)), formatBytes(t.total), t.doneFiles, t.files, len(t.failed), status),
+		tuiBar(fraction, width-2),
+		"",
+	}
+	footer := []string{"", "p pause  s skip  r retry failed  up/down select  q quit"}
+
+	active := []string{fmt.Sprintf("Converting (%d)", len(t.active))}
+	barWidth := min(20, width/4)
+	for i, f := range t.active {
+		cursor := "  "
+		if i == t.selected {
+			cursor = "> "
+		}
+		pages := ""
+		if entries := f.progress.Entries.Load(); entries > 0 {
+			pages = fmt.Sprintf(" %d/%d pages", min(f.progress.Opened.Load(), entries), entries)
+		}
+		active = append(active, cursor+tuiBar(f.progress.Fraction(), barWidth)+pages+" "+filepath.Base(f.name))
+	}
+
+	queue := []string{"", fmt.Sprintf("Queued (%d)", len(t.queue))}
+	for _, name := range t.queue {
+		queue = append(queue, "  "+filepath.Base(name))
+	}
+	failed := []string{}
+	if len(t.failed) > 0 {
+		failed = append(failed, "", fmt.Sprintf("Failed (%d)", len(t.failed)))
+		for _, f := range t.failed {
+			failed = append(failed, "  "+filepath.Base(f.name)+": "+f.err)
+		}
+	}
+
+	// the header, active files and footer always show, the rest share
+	// what is left, failures first and the latest log lines last
+	room := max(height-len(header)-len(active)-len(footer), 0)
+	failed = failed[:min(len(failed), room)]
+	room -= len(failed)
+	if len(t.logs) > 0 {
+		queue = tuiTruncate(queue, room/2)
+	} else {
+		queue = tuiTruncate(queue, room)
+	}
+	room -= len(queue)
+	logs := []string{}
+	if room >= 3 && len(t.logs) > 0 {
+		n := min(room-2, len(t.logs))
+		logs = append([]string{"", "Log"}, t.logs[len(t.logs)-n:]...)
+	}
+
+	lines := append(header, active...)
+	lines = append(lines, queue...)
+	lines = append(lines, failed...)
+	lines = append(lines, logs...)
+	lines = append(lines, footer...)
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for i, line := range lines {
+		line = t.term.text(line)
+		if r := []rune(line); len(r) > width {
+			line = string(r[:width])
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+// tuiTruncate cuts a titled list down to n lines, saying how many more
+// there are.
+func tuiTruncate(lines []string, n int) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	if n < 3 {
+		return nil
+	}
+	more := len(lines) - n + 1
+	return append(lines[:n-1:n-1], fmt.Sprintf("  ... %d more", more))
+}
+
+// tuiBar is a progress bar width wide, brackets included.
+func tuiBar(fraction float64, width int) string {
+	width = max(width-2, 1)
+	filled := int(fraction * float64(width))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTUI() *batchTUI {
+	return newBatchTUI(&terminal{out: &bytes.Buffer{}, interactive: true, ansi: true, unicode: true}, nil, func() (int, int) { return 60, 20 })
+}
+
+func Test_batchTUI_render(t *testing.T) {
+	tui := newTestTUI()
+	stop := tui.begin([]string{"/comics/a.cbr", "/comics/b.cbr", "/comics/c.cbr"}, 3000, nil, time.Hour)
+	defer stop()
+
+	p := &cbr2cbz.Progress{}
+	p.Expected.Store(100)
+	p.Entries.Store(10)
+	p.Read.Store(50)
+	p.Opened.Store(5)
+	tui.fileContext(context.Background(), "/comics/a.cbr")
+	tui.start("/comics/a.cbr", 1000, p)
+	tui.fileContext(context.Background(), "/comics/b.cbr")
+	tui.finish("/comics/b.cbr", errors.New("not a rar"))
+	tui.Write([]byte("Converting: a.cbr\npartial"))
+
+	screen := strings.Join(tui.render(60, 20), "\n")
+	require.Contains(t, screen, "cbr2cbz  17% 500 B/3.0 kB 1/3 files, 1 failed")
+	require.Contains(t, screen, "> [######.......] 5/10 pages a.cbr")
+	require.Contains(t, screen, "Queued (1)\n  c.cbr")
+	require.Contains(t, screen, "Failed (1)\n  b.cbr: not a rar")
+	require.Contains(t, screen, "Log\nConverting: a.cbr\n")
+	require.NotContains(t, screen, "partial")
+
+	tui.key("p")
+	require.Contains(t, tui.render(60, 20)[0], "paused")
+	for _, line := range tui.render(30, 5) {
+		require.LessOrEqual(t, len([]rune(line)), 30)
+	}
+	require.Len(t, tui.render(30, 5), 5)
+}
+
+func Test_batchTUI_keys(t *testing.T) {
+	tui := newTestTUI()
+	stop := tui.begin([]string{"/comics/a.cbr"}, 1000, nil, time.Hour)
+	defer stop()
+	ctx := context.Background()
+
+	tui.key("p")
+	waited := make(chan error)
+	go func() { waited <- tui.waitWhilePaused(ctx) }()
+	select {
+	case <-waited:
+		t.Fatal("didn't wait while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	tui.key("p")
+	require.NoError(t, <-waited)
+
+	fileCtx, done := tui.fileContext(ctx, "/comics/a.cbr")
+	defer done()
+	tui.key("s")
+	require.ErrorIs(t, context.Cause(fileCtx), errSkipped)
+}
+
+func Test_convertTUIRetry(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/good.cbt":   makeTar(t, map[string]string{"001.jpg": "page"}),
+		"library/broken.cbt": []byte("not a tar yet"),
+	})
+	require.NoError(t, err)
+
+	tui := newTestTUI()
+	c := &converter{fs: fsys, logger: testLogger{t}, tui: tui}
+	go func() {
+		// once it failed, fix it and retry
+		for {
+			tui.mu.Lock()
+			failed := len(tui.failed)
+			tui.mu.Unlock()
+			if failed > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		hackpadfs.WriteFullFile(fsys, "library/broken.cbt", makeTar(t, map[string]string{"001.jpg": "page"}), 0o644)
+		tui.key("r")
+	}()
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.ElementsMatch(t, []string{"/library/good.cbz", "/library/broken.cbz"}, c.converted)
+	require.Empty(t, c.failed)
+	require.Len(t, c.results, 2)
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var (
+	sizeUnits      string
+	numberLocale   string
+	durationFormat string
+)
+
+// SizeUnits are the values --size-units accepts. si counts in powers of
+// 1000 (MB) like macOS and most Linux file managers, binary in powers of
+// 1024 (MiB) like Windows Explorer and du -h.
+var SizeUnits = map[string]bool{"si": true, "binary": true}
+
+// DurationFormats are the values --duration-format accepts. human rounds
+// to the biggest unit, "3 minutes", exact gives them all, "3m12s".
+var DurationFormats = map[string]bool{"human": true, "exact": true}
+
+var (
+	siSuffixes     = []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+	binarySuffixes = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+)
+
+// sprinter is what numbers are formatted with, fmt unless --locale asks
+// for separators.
+type sprinter interface {
+	Sprintf(format string, args ...any) string
+}
+
+type plainSprinter struct{}
+
+func (plainSprinter) Sprintf(format string, args ...any) string {
+	return fmt.Sprintf(format, args...)
+}
+
+type localeSprinter struct {
+	p *message.Printer
+}
+
+func (s localeSprinter) Sprintf(format string, args ...any) string {
+	return s.p.Sprintf(format, args...)
+}
+
+var numbers sprinter = plainSprinter{}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&sizeUnits, "size-units", "si", "units sizes are shown in, si (MB) or binary (MiB), to match your file manager")
+	rootCmd.PersistentFlags().StringVar(&numberLocale, "locale", "", "format numbers in messages for this locale, like de or en-US, auto for the one in LANG, plain digits by default")
+	rootCmd.PersistentFlags().StringVar(&durationFormat, "duration-format", "human", "how durations are shown, human (3 minutes) or exact (3m12s)")
+}
+
+// setUnits checks the formatting flags and sets up the number printer.
+// Reports and JSON output always have raw numbers, these only change
+// messages.
+func setUnits() error {
+	if !SizeUnits[sizeUnits] {
+		return errors.Errorf("unknown --size-units %q, use si or binary", sizeUnits)
+	}
+	if !DurationFormats[durationFormat] {
+		return errors.Errorf("unknown --duration-format %q, use human or exact", durationFormat)
+	}
+	tag, err := localeTag(numberLocale)
+	if err != nil {
+		return err
+	}
+	numbers = plainSprinter{}
+	if tag != language.Und {
+		numbers = localeSprinter{p: message.NewPrinter(tag)}
+	}
+	return nil
+}
+
+// localeTag is the language --locale names, language.Und for plain digits.
+// auto looks at LC_ALL, LC_NUMERIC and LANG the way C programs do.
+func localeTag(locale string) (language.Tag, error) {
+	if locale == "auto" {
+		locale = ""
+		for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+			if locale = os.Getenv(name); locale != "" {
+				break
+			}
+		}
+		// en_US.UTF-8 or de_DE@euro
+		locale, _, _ = strings.Cut(locale, ".")
+		locale, _, _ = strings.Cut(locale, "@")
+		if locale == "C" || locale == "POSIX" {
+			locale = ""
+		}
+	}
+	if locale == "" {
+		return language.Und, nil
+	}
+	tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+	if err != nil {
+		return language.Und, errors.Wrapf(err, "unknown --locale %q", locale)
+	}
+	return tag, nil
+}
+
+// formatBytes is n in the --size-units, with a decimal under 10 the way
+// humanize.Bytes does it, "1.2 MB" or "1,2 MiB".
+func formatBytes(n uint64) string {
+	base, suffixes := 1000.0, siSuffixes
+	if sizeUnits == "binary" {
+		base, suffixes = 1024, binarySuffixes
+	}
+	if n < 10 {
+		return numbers.Sprintf("%d B", n)
+	}
+	e := math.Floor(math.Log(float64(n)) / math.Log(base))
+	val := math.Floor(float64(n)/math.Pow(base, e)*10+0.5) / 10
+	if val < 10 {
+		return numbers.Sprintf("%.1f %s", val, suffixes[int(e)])
+	}
+	return numbers.Sprintf("%.0f %s", val, suffixes[int(e)])
+}
+
+// formatCount is n with the --locale thousands separator.
+func formatCount[T int | int64 | uint64](n T) string {
+	return numbers.Sprintf("%d", n)
+}
+
+// formatDuration is d in the --duration-format.
+func formatDuration(d time.Duration) string {
+	if durationFormat == "exact" {
+		return d.Round(time.Second).String()
+	}
+	now := time.Now()
+	return humanize.RelTime(now.Add(-d), now, "", "")
+}
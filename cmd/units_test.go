@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+)
+
+func withUnits(t *testing.T, units, locale, durations string) {
+	t.Helper()
+	sizeUnits, numberLocale, durationFormat = units, locale, durations
+	t.Cleanup(func() {
+		sizeUnits, numberLocale, durationFormat = "si", "", "human"
+		require.NoError(t, setUnits())
+	})
+	require.NoError(t, setUnits())
+}
+
+func Test_formatBytes(t *testing.T) {
+	withUnits(t, "si", "", "human")
+	for _, n := range []uint64{0, 9, 999, 1000, 1234, 123456789, 5 << 40} {
+		require.Equal(t, humanize.Bytes(n), formatBytes(n))
+	}
+
+	withUnits(t, "binary", "", "human")
+	for _, n := range []uint64{0, 9, 1023, 1024, 1234, 123456789, 5 << 40} {
+		require.Equal(t, humanize.IBytes(n), formatBytes(n))
+	}
+
+	withUnits(t, "binary", "de", "human")
+	require.Equal(t, "1,2 KiB", formatBytes(1234))
+	require.Equal(t, "1.234", formatCount(1234))
+	withUnits(t, "si", "en-US", "human")
+	require.Equal(t, "1,234", formatCount(1234))
+	require.Equal(t, "1.2 kB", formatBytes(1234))
+}
+
+func Test_formatDuration(t *testing.T) {
+	withUnits(t, "si", "", "human")
+	require.Equal(t, "3 minutes ", formatDuration(3*time.Minute+12*time.Second))
+	withUnits(t, "si", "", "exact")
+	require.Equal(t, "3m12s", formatDuration(3*time.Minute+12*time.Second+300*time.Millisecond))
+}
+
+func Test_localeTag(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_NUMERIC", "de_DE.UTF-8")
+	tag, err := localeTag("auto")
+	require.NoError(t, err)
+	require.Equal(t, "de-DE", tag.String())
+
+	t.Setenv("LC_ALL", "C")
+	tag, err = localeTag("auto")
+	require.NoError(t, err)
+	require.Equal(t, "und", tag.String())
+
+	_, err = localeTag("not a locale")
+	require.Error(t, err)
+
+	sizeUnits = "decimal"
+	t.Cleanup(func() { sizeUnits = "si" })
+	require.ErrorContains(t, setUnits(), "--size-units")
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+var externalUnrar string
+
+// triesUnrar reports whether a rar the built in reader failed on with err
+// is worth giving to --external-unrar. Limits, stalls and interruptions
+// would end the same way there.
+func (c *converter) triesUnrar(ctx context.Context, format archiver.Format, err error) bool {
+	if err == nil || c.unrar == "" || ctx.Err() != nil {
+		return false
+	}
+	if _, ok := format.(archiver.Rar); !ok {
+		return false
+	}
+	return !errors.Is(err, cbr2cbz.ErrDecompressionLimit)
+}
+
+// repackWithUnrar unpacks source, the file cbrFile was opened from, with
+// the --external-unrar binary into a temporary folder and packs that into
+// dst. Sources not on the local filesystem are copied out of src first,
+// which only works for a single rar, not volumes.
+func (c *converter) repackWithUnrar(ctx context.Context, cbrFile string, source string, src io.ReaderAt, size int64, dst io.Writer, progress *cbr2cbz.Progress) error {
+	tmp, err := os.MkdirTemp("", "cbr2cbz-unrar-*")
+	if err != nil {
+		return errors.Wrap(err, "creating unrar folder")
+	}
+	defer os.RemoveAll(tmp)
+
+	archive := ""
+	if osFS, ok := c.fs.(interface {
+		ToOSPath(string) (string, error)
+	}); ok {
+		archive, err = osFS.ToOSPath(source)
+		if err != nil {
+			return errors.Wrap(err, "resolving path")
+		}
+	} else {
+		if _, isVolumes := src.(*cbr2cbz.Volumes); isVolumes {
+			return errors.New("split rars can only be given to --external-unrar on the local filesystem")
+		}
+		archive = filepath.Join(tmp, "source.rar")
+		err = copyToOS(archive, io.NewSectionReader(src, 0, size))
+		if err != nil {
+			return err
+		}
+	}
+
+	out := filepath.Join(tmp, "out")
+	if err := os.Mkdir(out, 0o700); err != nil {
+		return errors.Wrap(err, "creating unrar folder")
+	}
+	cmd := exec.CommandContext(ctx, c.unrar, unrarArgs(c.unrar, archive, out)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		return errors.Wrapf(err, "%s failed: %s", filepath.Base(c.unrar), strings.TrimSpace(lines[len(lines)-1]))
+	}
+
+	engine := c.packer()
+	files, err := engine.DirEntries(ctx, cbrFile, os.DirFS(out), size, progress)
+	if err != nil {
+		return err
+	}
+	err = engine.PackWithComment(ctx, cbrFile, files, engine.Comment(cbrFile, src, size), dst, progress)
+	if err != nil {
+		return err
+	}
+	c.logFor(cbrFile).Printf("Read %s with %s instead of the built in reader\n", cbrFile, c.unrar)
+	return nil
+}
+
+// unrarArgs are the arguments that have unrar, or 7z when the binary is
+// called that, unpack archive into dir without asking anything.
+func unrarArgs(binary string, archive string, dir string) []string {
+	if strings.HasPrefix(strings.ToLower(filepath.Base(binary)), "7z") {
+		return []string{"x", "-y", "-bd", "-p", "-o" + dir, archive}
+	}
+	return []string{"x", "-y", "-idq", "-p-", archive, dir + string(filepath.Separator)}
+}
+
+func copyToOS(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "creating copy for unrar")
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "copying for unrar")
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_unrarArgs(t *testing.T) {
+	require.Equal(t, []string{"x", "-y", "-idq", "-p-", "a.rar", "out" + string(filepath.Separator)}, unrarArgs("/usr/bin/unrar", "a.rar", "out"))
+	require.Equal(t, []string{"x", "-y", "-bd", "-p", "-oout", "a.rar"}, unrarArgs("/usr/local/bin/7zz", "a.rar", "out"))
+}
+
+func Test_repackWithUnrar(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stand-in unrar is a shell script")
+	}
+	// unpacks a single page into the folder it is given last
+	unrar := filepath.Join(t.TempDir(), "unrar")
+	require.NoError(t, os.WriteFile(unrar, []byte("#!/bin/sh\nfor last; do :; done\nprintf page > \"${last}001.jpg\"\n"), 0o755))
+
+	// cut short, so the built in reader fails on it
+	broken := realCBRContents[:len(realCBRContents)/2]
+	fsys, err := setupFS(t, filenameBytes{"library/test.cbr": broken})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Contains(t, c.failed, "/library/test.cbr")
+
+	c = &converter{fs: fsys, logger: testLogger{t}, unrar: unrar}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+	require.Equal(t, []string{"/library/test.cbz"}, c.converted)
+
+	zr, f, err := openZip(fsys, "library/test.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	require.Len(t, zr.File, 1)
+	require.Equal(t, "001.jpg", zr.File[0].Name)
+
+	fsys, err = setupFS(t, filenameBytes{"library/test.cbr": broken})
+	require.NoError(t, err)
+	failing := filepath.Join(t.TempDir(), "unrar")
+	require.NoError(t, os.WriteFile(failing, []byte("#!/bin/sh\necho 'Unsupported format'\nexit 3\n"), 0o755))
+	c = &converter{fs: fsys, logger: testLogger{t}, unrar: failing}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.ErrorContains(t, c.failed["/library/test.cbr"], "unrar failed: Unsupported format")
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+// compressionLayer returns the compression wrapped around an archive, for
+// the gzip, bzip2 or xz streams some rippers produce instead of a plain
+// cbr or cbz.
+func compressionLayer(format archiver.Format) (archiver.Compression, bool) {
+	if ca, ok := format.(archiver.CompressedArchive); ok {
+		format = ca.Compression
+	}
+	switch format.(type) {
+	case archiver.Gz, archiver.Bz2, archiver.Xz:
+		return format.(archiver.Compression), true
+	}
+	return nil, false
+}
+
+// isWrapped reports whether name is an archive inside a compression layer.
+func isWrapped(fsys hackpadfs.FS, name string) bool {
+	f, err := fsys.Open(pathToFsPath(name))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	format, _, err := archiver.Identify(pathToFsPath(name), f)
+	if err != nil {
+		return false
+	}
+	_, ok := compressionLayer(format)
+	return ok
+}
+
+// unwrap decompresses src into a temporary file next to cbrFile, returning
+// its name. The caller removes it once done.
+func (c *converter) unwrap(ctx context.Context, cbrFile string, src io.Reader, size int64, compression archiver.Compression) (string, error) {
+	rc, err := compression.OpenReader(src)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %s stream", compression.Name())
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	max := c.packer().Limits.MaxTotal(size)
+	if max > 0 {
+		r = io.LimitReader(rc, int64(max)+1)
+	}
+
+	tmp := tempName(pathToFsPath(cbrFile))
+	out, err := hackpadfs.Create(c.fs, tmp)
+	if err != nil {
+		return "", errors.Wrap(err, "creating unwrapped file")
+	}
+	w, ok := out.(io.Writer)
+	if !ok {
+		out.Close()
+		hackpadfs.Remove(c.fs, tmp)
+		return "", errors.New("destination isn't a writable filesystem")
+	}
+
+	n, err := io.Copy(w, contextReader{ctx: ctx, r: r})
+	if err == nil && max > 0 && uint64(n) > max {
+		err = errors.Wrapf(cbr2cbz.ErrDecompressionLimit, "%s stream decompressed to more than %.0fx its size", compression.Name(), c.packer().Limits.MaxRatio)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		hackpadfs.Remove(c.fs, tmp)
+		return "", errors.Wrapf(err, "unwrapping %s", compression.Name())
+	}
+
+	c.logFor(cbrFile).Printf("Unwrapped %s from %s, %s -> %s\n", compression.Name(), cbrFile, formatBytes(uint64(size)), formatBytes(uint64(n)))
+	return tmp, nil
+}
+
+// contextReader stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// removeIfExists removes name, not minding if it is already gone.
+func removeIfExists(fsys hackpadfs.FS, name string) error {
+	err := hackpadfs.Remove(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
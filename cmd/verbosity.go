@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	quietConsole   bool
+	verboseConsole bool
+	debugLogging   bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&quietConsole, "quiet", "q", false, "only show warnings and errors on the console, the log file still gets everything")
+	rootCmd.PersistentFlags().BoolVarP(&verboseConsole, "verbose", "v", false, "also show the options used and other details on the console, they always go into the log file")
+	rootCmd.PersistentFlags().BoolVar(&debugLogging, "debug", false, "also log what is being done to each file step by step, on the console and in the log file")
+}
+
+// Levels of log lines, and how much of them the console shows.
+const (
+	levelQuiet = iota
+	levelNormal
+	levelVerbose
+	levelDebug
+)
+
+// Marks put in front of the message of a line to log it at a level other
+// than normal. The writers for the console and the log file take them out
+// again, keeping or dropping the line.
+const (
+	verboseMark = "\x01"
+	debugMark   = "\x02"
+)
+
+// logCode matches the [W001] or [E101] warnings and errors are logged with,
+// all a quiet console shows.
+var logCode = regexp.MustCompile(`\[[WE]\d{3}\]`)
+
+// verbosef logs a line only shown on the console with --verbose, it always
+// goes into the log file.
+func verbosef(l logger, format string, v ...any) {
+	l.Printf(verboseMark+format, v...)
+}
+
+// debugf logs a line only logged at all with --debug.
+func debugf(l logger, format string, v ...any) {
+	l.Printf(debugMark+format, v...)
+}
+
+// consoleLevel is the level the verbosity flags ask for.
+func consoleLevel() (int, error) {
+	switch {
+	case quietConsole && (verboseConsole || debugLogging):
+		return 0, errors.New("--quiet can't be combined with --verbose or --debug")
+	case quietConsole:
+		return levelQuiet, nil
+	case debugLogging:
+		return levelDebug, nil
+	case verboseConsole:
+		return levelVerbose, nil
+	}
+	return levelNormal, nil
+}
+
+// consoleOutput is w showing as much as the verbosity flags ask for.
+func consoleOutput(w io.Writer) io.Writer {
+	level, err := consoleLevel()
+	if err != nil {
+		level = levelNormal
+	}
+	return &levelWriter{w: w, level: level}
+}
+
+// logFileOutput is w taking everything but debug lines, and those too with
+// --debug, whatever the console shows.
+func logFileOutput(w io.Writer) io.Writer {
+	if debugLogging {
+		return &levelWriter{w: w, level: levelDebug}
+	}
+	return &levelWriter{w: w, level: levelVerbose}
+}
+
+// levelWriter passes on the lines at or below level, without their marks.
+// Loggers write a line at a time.
+type levelWriter struct {
+	w     io.Writer
+	level int
+}
+
+func (lw *levelWriter) Write(p []byte) (int, error) {
+	line, level := p, levelNormal
+	if i := bytes.Index(line, []byte(debugMark)); i >= 0 {
+		line, level = append(append([]byte{}, line[:i]...), line[i+len(debugMark):]...), levelDebug
+	} else if i := bytes.Index(line, []byte(verboseMark)); i >= 0 {
+		line, level = append(append([]byte{}, line[:i]...), line[i+len(verboseMark):]...), levelVerbose
+	}
+	keep := level <= lw.level
+	if lw.level == levelQuiet {
+		keep = level == levelNormal && logCode.Match(line)
+	}
+	if !keep {
+		return len(p), nil
+	}
+	if _, err := lw.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
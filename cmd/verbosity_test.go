@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_levelWriter(t *testing.T) {
+	oldQuiet, oldVerbose, oldDebug := quietConsole, verboseConsole, debugLogging
+	defer func() { quietConsole, verboseConsole, debugLogging = oldQuiet, oldVerbose, oldDebug }()
+
+	logged := func(console func(io.Writer) io.Writer) string {
+		out := bytes.Buffer{}
+		l := log.New(console(&out), "", 0)
+		l.Printf("Considering 2 files\n")
+		verbosef(l, "Effective options:\n")
+		debugf(l, "Identified /a.cbr as .zip\n")
+		l.Printf("[W022] /a.cbr failed, trying again\n")
+		return out.String()
+	}
+
+	quietConsole, verboseConsole, debugLogging = false, false, false
+	require.Equal(t, "Considering 2 files\n[W022] /a.cbr failed, trying again\n", logged(consoleOutput))
+	require.Equal(t, "Considering 2 files\nEffective options:\n[W022] /a.cbr failed, trying again\n", logged(logFileOutput))
+
+	quietConsole = true
+	require.Equal(t, "[W022] /a.cbr failed, trying again\n", logged(consoleOutput))
+	// the log file doesn't care how quiet the console is
+	require.Equal(t, "Considering 2 files\nEffective options:\n[W022] /a.cbr failed, trying again\n", logged(logFileOutput))
+
+	quietConsole, verboseConsole = false, true
+	require.Equal(t, "Considering 2 files\nEffective options:\n[W022] /a.cbr failed, trying again\n", logged(consoleOutput))
+
+	verboseConsole, debugLogging = false, true
+	all := "Considering 2 files\nEffective options:\nIdentified /a.cbr as .zip\n[W022] /a.cbr failed, trying again\n"
+	require.Equal(t, all, logged(consoleOutput))
+	require.Equal(t, all, logged(logFileOutput))
+}
+
+func Test_levelWriterSections(t *testing.T) {
+	oldQuiet, oldVerbose, oldDebug := quietConsole, verboseConsole, debugLogging
+	defer func() { quietConsole, verboseConsole, debugLogging = oldQuiet, oldVerbose, oldDebug }()
+	quietConsole, verboseConsole, debugLogging = false, false, false
+
+	// the marks survive being held back with the rest of a file's lines
+	out := bytes.Buffer{}
+	sections := newLogSections(log.New(consoleOutput(&out), "", 0))
+	sections.open("/a.cbr")
+	verbosef(sections.section("/a.cbr"), "Converted /a.cbz with options\n")
+	sections.section("/a.cbr").Printf("Successfully converted /a.cbr\n")
+	sections.flush("/a.cbr")
+	require.Equal(t, "Successfully converted /a.cbr\n", out.String())
+}
+
+func Test_consoleLevel(t *testing.T) {
+	oldQuiet, oldVerbose, oldDebug := quietConsole, verboseConsole, debugLogging
+	defer func() { quietConsole, verboseConsole, debugLogging = oldQuiet, oldVerbose, oldDebug }()
+
+	quietConsole, verboseConsole, debugLogging = true, true, false
+	_, err := consoleLevel()
+	require.Error(t, err)
+
+	quietConsole, verboseConsole, debugLogging = false, true, true
+	level, err := consoleLevel()
+	require.NoError(t, err)
+	require.Equal(t, levelDebug, level)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// makeCorruptZip is a zip with one stored entry whose contents no longer
+// match its CRC.
+func makeCorruptZip(t *testing.T) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "001.jpg", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a page of the comic"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return bytes.Replace(buf.Bytes(), []byte("a page"), []byte("a PAGE"), 1)
+}
+
+func Test_archiveVerifier(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/good.cbr":  realCBRContents,
+		"comics/good.cbt":  realCBTContents,
+		"comics/good.cbz":  makeZip(t, map[string]string{"001.jpg": "page"}),
+		"comics/notes.txt": []byte("not an archive"),
+	})
+	require.NoError(t, err)
+
+	v := &archiveVerifier{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, v.run(context.Background(), []string{"/comics"}))
+
+	entries, err := v.verify(context.Background(), "/comics/good.cbr")
+	require.NoError(t, err)
+	require.Equal(t, 1, entries)
+
+	fsys, err = setupFS(t, filenameBytes{
+		"comics/good.cbz": makeZip(t, map[string]string{"001.jpg": "page"}),
+		"comics/bad.cbz":  makeCorruptZip(t),
+	})
+	require.NoError(t, err)
+	v = &archiveVerifier{fs: fsys, logger: testLogger{t}}
+	require.ErrorContains(t, v.run(context.Background(), []string{"/comics"}), "1 corrupt archives")
+
+	_, err = v.verify(context.Background(), "/comics/bad.cbz")
+	require.ErrorContains(t, err, "checksum")
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hack-pad/hackpadfs"
+)
+
+// partVolume matches the volumes of a rar split the newer way,
+// Saga.part1.cbr, Saga.part2.cbr and so on.
+var partVolume = regexp.MustCompile(`(?i)^(.+)\.part(\d+)(\.(?:cbr|rar))$`)
+
+// oldVolume matches the later volumes of a rar split the older way, after
+// Saga.cbr come Saga.r00, Saga.r01 and so on.
+var oldVolume = regexp.MustCompile(`(?i)^(.+)\.r(\d\d)$`)
+
+// rarVolumes is every volume of the split rar first starts, in order and
+// named the way first is, or nil when first doesn't start a set of two or
+// more or the set has a gap.
+func rarVolumes(fsys hackpadfs.FS, first string) []string {
+	volumes, missing := volumeSet(fsys, first)
+	if missing != "" || len(volumes) < 2 {
+		return nil
+	}
+	return volumes
+}
+
+// missingVolume is the first volume missing from the split rar first
+// starts, "" when there is no gap or it isn't one.
+func missingVolume(fsys hackpadfs.FS, first string) string {
+	_, missing := volumeSet(fsys, first)
+	return missing
+}
+
+func volumeSet(fsys hackpadfs.FS, first string) ([]string, string) {
+	base := filepath.Base(first)
+	numbered := map[int]string{}
+	start := 0
+	var match func(name string) (int, bool)
+	// gap names volume n, for saying it is missing
+	var gap func(n int) string
+	if m := partVolume.FindStringSubmatch(base); m != nil {
+		if n, _ := strconv.Atoi(m[2]); n != 1 {
+			return nil, ""
+		}
+		start = 1
+		gap = func(n int) string { return fmt.Sprintf("%s.part%0*d%s", m[1], len(m[2]), n, m[3]) }
+		match = func(name string) (int, bool) {
+			other := partVolume.FindStringSubmatch(name)
+			if other == nil || other[1] != m[1] || !strings.EqualFold(other[3], m[3]) {
+				return 0, false
+			}
+			n, err := strconv.Atoi(other[2])
+			return n, err == nil
+		}
+	} else if ext := strings.ToLower(path.Ext(base)); ext == ".cbr" || ext == ".rar" {
+		stem := strings.TrimSuffix(base, path.Ext(base))
+		numbered[0] = base
+		gap = func(n int) string { return fmt.Sprintf("%s.r%02d", stem, n-1) }
+		match = func(name string) (int, bool) {
+			other := oldVolume.FindStringSubmatch(name)
+			if other == nil || other[1] != stem {
+				return 0, false
+			}
+			n, err := strconv.Atoi(other[2])
+			return n + 1, err == nil
+		}
+	} else {
+		return nil, ""
+	}
+
+	dir := path.Dir(pathToFsPath(first))
+	entries, err := hackpadfs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, ""
+	}
+	for _, e := range entries {
+		if n, ok := match(e.Name()); ok && !e.IsDir() {
+			numbered[n] = e.Name()
+		}
+	}
+
+	volumes := []string{}
+	i := start
+	for ; numbered[i] != ""; i++ {
+		volumes = append(volumes, filepath.Join(filepath.Dir(first), numbered[i]))
+	}
+	if len(numbered) > len(volumes) {
+		return volumes, gap(i)
+	}
+	return volumes, ""
+}
+
+// laterVolume reports whether file is a volume of a split rar after the
+// first, which stands for the whole set.
+func laterVolume(file string) bool {
+	base := filepath.Base(file)
+	if m := partVolume.FindStringSubmatch(base); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return n != 1
+	}
+	return oldVolume.MatchString(base)
+}
+
+// firstVolume is the first volume of the split rar file is a later volume
+// of, "" when it isn't one or the first can't be found.
+func firstVolume(fsys hackpadfs.FS, file string) string {
+	if !laterVolume(file) {
+		return ""
+	}
+	dir, base := filepath.Dir(file), filepath.Base(file)
+	candidates := []string{}
+	if m := partVolume.FindStringSubmatch(base); m != nil {
+		candidates = append(candidates, fmt.Sprintf("%s.part%0*d%s", m[1], len(m[2]), 1, m[3]))
+	} else if m := oldVolume.FindStringSubmatch(base); m != nil {
+		candidates = append(candidates, m[1]+".cbr", m[1]+".rar", m[1]+".CBR", m[1]+".RAR")
+	}
+	for _, name := range candidates {
+		first := filepath.Join(dir, name)
+		if _, err := hackpadfs.Stat(fsys, pathToFsPath(first)); err == nil {
+			return first
+		}
+	}
+	return ""
+}
+
+// volumeStem is the name of the cbz a split rar converts to, without the
+// volume number, or "" when file doesn't start one.
+func volumeStem(fsys hackpadfs.FS, file string) string {
+	m := partVolume.FindStringSubmatch(filepath.Base(file))
+	if m == nil || rarVolumes(fsys, file) == nil {
+		return ""
+	}
+	return m[1]
+}
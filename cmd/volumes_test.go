@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	rarVolumeFlag   = 0x0001
+	rarNewNaming    = 0x0010
+	rarFirstVolume  = 0x0100
+	rarSplitBefore  = 0x0001
+	rarSplitAfter   = 0x0002
+	rarEndNotLast   = 0x0001
+	rarLongBlock    = 0x8000
+	rarMarker       = "Rar!\x1a\x07\x00"
+	rarStoredMethod = 0x30
+)
+
+// rarBlock is a rar 4 block header, its CRC being the low half of the
+// CRC32 of the rest.
+func rarBlock(kind byte, flags uint16, body []byte) []byte {
+	h := []byte{kind}
+	h = binary.LittleEndian.AppendUint16(h, flags)
+	h = binary.LittleEndian.AppendUint16(h, uint16(7+len(body)))
+	h = append(h, body...)
+	return append(binary.LittleEndian.AppendUint16(nil, uint16(crc32.ChecksumIEEE(h))), h...)
+}
+
+// rarPiece is part of a stored file, whole being all of it.
+func rarPiece(name string, part []byte, whole []byte, split uint16) []byte {
+	body := binary.LittleEndian.AppendUint32(nil, uint32(len(part)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(whole)))
+	body = append(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(whole))
+	body = binary.LittleEndian.AppendUint32(body, 0x21<<16)
+	body = append(body, 20, rarStoredMethod)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(name)))
+	body = binary.LittleEndian.AppendUint32(body, 0x20)
+	body = append(body, name...)
+	return append(rarBlock(0x74, rarLongBlock|split, body), part...)
+}
+
+// rarVolume is one volume of a split rar holding pieces.
+func rarVolume(first bool, last bool, newNaming bool, pieces ...[]byte) []byte {
+	flags := uint16(rarVolumeFlag)
+	if first {
+		flags |= rarFirstVolume
+	}
+	if newNaming {
+		flags |= rarNewNaming
+	}
+	out := append([]byte(rarMarker), rarBlock(0x73, flags, make([]byte, 6))...)
+	for _, p := range pieces {
+		out = append(out, p...)
+	}
+	var end uint16
+	if !last {
+		end = rarEndNotLast
+	}
+	return append(out, rarBlock(0x7b, end, nil)...)
+}
+
+// splitRar is three pages over two volumes, the second page split between
+// them.
+func splitRar(newNaming bool) ([]byte, []byte) {
+	second := []byte("page two, split over both volumes")
+	one := rarVolume(true, false, newNaming,
+		rarPiece("Saga/001.jpg", []byte("page one"), []byte("page one"), 0),
+		rarPiece("Saga/002.jpg", second[:10], second, rarSplitAfter),
+	)
+	two := rarVolume(false, true, newNaming,
+		rarPiece("Saga/002.jpg", second[10:], second, rarSplitBefore),
+		rarPiece("Saga/003.jpg", []byte("page three"), []byte("page three"), 0),
+	)
+	return one, two
+}
+
+func Test_convertVolumes(t *testing.T) {
+	newOne, newTwo := splitRar(true)
+	oldOne, oldTwo := splitRar(false)
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga.part1.cbr": newOne,
+		"library/Saga.part2.cbr": newTwo,
+		"library/Old.rar":        oldOne,
+		"library/Old.r00":        oldTwo,
+	})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.findFilesAndSize(context.Background(), []string{"/library"}))
+	require.ElementsMatch(t, []string{"/library/Saga.part1.cbr", "/library/Old.rar"}, c.cbrFiles)
+
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+
+	for _, name := range []string{"Saga", "Old"} {
+		zr, f, err := openZip(fsys, "library/"+name+".cbz")
+		require.NoError(t, err, name)
+		require.Len(t, zr.File, 3)
+		rc, err := zr.File[1].Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		f.Close()
+		require.Equal(t, "page two, split over both volumes", string(data))
+	}
+	for _, name := range []string{"Saga.part1.cbr", "Saga.part2.cbr", "Old.rar", "Old.r00"} {
+		_, err = hackpadfs.Stat(fsys, "library/"+name)
+		require.ErrorIs(t, err, fs.ErrNotExist, name)
+	}
+}
+
+func Test_convertVolumes_missing(t *testing.T) {
+	one, _ := splitRar(true)
+	three, _ := splitRar(true)
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga.part1.cbr": one,
+		"library/Saga.part3.cbr": three,
+	})
+	require.NoError(t, err)
+
+	// part2 is missing, so part1 is the whole set and fails half way
+	c := &converter{fs: fsys, logger: testLogger{t}}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Contains(t, c.failed, "/library/Saga.part1.cbr")
+	for _, name := range []string{"Saga.part1.cbr", "Saga.part3.cbr"} {
+		_, err = hackpadfs.Stat(fsys, "library/"+name)
+		require.NoError(t, err, name)
+	}
+}
+
+func Test_firstVolume(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"library/Saga.part01.cbr": nil,
+		"library/Old.cbr":         nil,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "/library/Saga.part01.cbr", firstVolume(fsys, "/library/Saga.part02.cbr"))
+	require.Equal(t, "/library/Old.cbr", firstVolume(fsys, "/library/Old.r03"))
+	require.Empty(t, firstVolume(fsys, "/library/Saga.part01.cbr"))
+	require.Empty(t, firstVolume(fsys, "/library/Other.part2.cbr"))
+}
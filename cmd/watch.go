@@ -0,0 +1,636 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hack-pad/hackpadfs"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	watchDebounce     time.Duration
+	watchHealthAddr   string
+	watchDrainTimeout time.Duration
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [dir...]",
+	Short: "Watches directories and converts new cbr, cb7 and cbt files as they appear",
+	Long: `Watches directories and converts new cbr, cb7 and cbt files as they appear, for download
+and drop folders. Files already there when it starts are converted too.
+
+What was converted or failed is kept in --recent-file, and a file tried within
+--recent-for is left alone until its size or modification time changes, so a
+restart or a touch doesn't try the same files again. A file that fails as if
+it's still being downloaded, cut short or not looking like an archive yet, is
+tried again once it changes and settles, even if no event says so.
+
+With --purge-after, the originals --trash or --backup-dir moved aside are
+removed once they have been kept that long, as purge does, checked at start
+and every hour.
+
+A file is only picked up once it stopped changing for --debounce, so copies still in
+progress are left alone. Takes all the convert flags. Without arguments the paths from
+the config file are watched.
+
+On SIGINT/SIGTERM no new files are started and the current one gets --drain-timeout
+to finish.
+
+On SIGHUP, or a POST to /reload on --health-addr, the config file is read again:
+new paths are watched, removed ones dropped and changed options apply from the
+next file on. The file being converted finishes as it started. Flags given on the
+command line keep their value, and paths given as arguments are kept too.`,
+	Args:              cobra.ArbitraryArgs,
+	ValidArgsFunction: completeDirs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.Default()
+
+		// paths given here are kept across reloads
+		given := args
+		if len(args) == 0 && loadedConfig != nil {
+			args = loadedConfig.paths
+		}
+		if len(args) == 0 {
+			logger.Fatal("nothing to watch, pass some directories or set paths in the config file")
+		}
+
+		logFile, err := openLogFile(time.Now())
+		if err != nil {
+			panic(err)
+		}
+		logger.SetOutput(io.MultiWriter(consoleOutput(os.Stdout), logFileOutput(logFile)))
+
+		c, err := newConverter(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		c.settings = effectiveOptions(cmd.Flags())
+
+		interval := watchDebounce / 4
+		if interval < time.Second {
+			interval = time.Second
+		}
+		health := newHealthState(10 * interval)
+		work, hard, stop := drainOnSignal(cmd.Context(), health, watchDrainTimeout)
+		defer stop()
+
+		w := newWatcher(c, watchDebounce)
+		if watchPurgeAfter != "" {
+			w.purgeAfter, err = parseAge(watchPurgeAfter)
+			if err != nil {
+				logger.Fatal(errors.Wrap(err, "parsing --purge-after"))
+			}
+		}
+		w.recent, err = loadRecentIndex(recentFileName, recentFor, time.Now())
+		if err != nil {
+			logger.Printf("Starting over with no recently tried files: %s\n", err.Error())
+		}
+		reloads := make(chan reloadRequest)
+		w.reloads = reloads
+		w.reload = func() (*watchSetup, error) {
+			return reloadWatch(cmd, logger, given)
+		}
+		reloadOnSignal(work, reloads)
+
+		if watchHealthAddr != "" {
+			mux := http.NewServeMux()
+			health.register(mux)
+			mux.HandleFunc("/status", health.statusHandler())
+			mux.HandleFunc("/reload", reloadHandler(work, reloads))
+			go func() {
+				logger.Println(http.ListenAndServe(watchHealthAddr, mux))
+			}()
+		}
+
+		err = w.run(work, hard, args, interval, health)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().AddFlagSet(convertCmd.Flags())
+	watchCmd.Flags().DurationVar(&watchDebounce, "debounce", 30*time.Second, "how long a file has to stop changing before it is converted")
+	watchCmd.Flags().StringVar(&watchHealthAddr, "health-addr", "", "serve /livez, /healthz, /readyz, /status and POST /reload on this address, e.g. :8080")
+	watchCmd.Flags().StringVar(&recentFileName, "recent-file", defaultRecentPath(), "file remembering what was converted or failed lately, so a restart or a touch that changes nothing doesn't try it again; empty to disable")
+	watchCmd.Flags().DurationVar(&recentFor, "recent-for", 24*time.Hour, "how long a file in --recent-file isn't tried again unless it changes")
+	watchCmd.Flags().StringVar(&watchPurgeAfter, "purge-after", "", "remove the originals --trash or --backup-dir moved aside once kept this long (e.g. 30d), checked hourly; unset to keep them")
+	watchCmd.Flags().DurationVar(&watchDrainTimeout, "drain-timeout", time.Minute, "how long the current file may take to finish after SIGINT/SIGTERM")
+}
+
+// watcher tracks cbr files that showed up in the watched directories until
+// they have been stable long enough to convert.
+type watcher struct {
+	c        *converter
+	debounce time.Duration
+	// reloads asks for reload to be applied, between files
+	reloads <-chan reloadRequest
+	reload  func() (*watchSetup, error)
+
+	// recent is what was tried lately, across restarts
+	recent *recentIndex
+	// purgeAfter is how long originals moved aside are kept, 0 for
+	// forever, and nextPurge when they are next checked
+	purgeAfter time.Duration
+	nextPurge  time.Time
+
+	mu      sync.Mutex
+	pending map[string]*pendingFile
+	// tried is how the files handed out by due looked then
+	tried map[string]*pendingFile
+	// incomplete is the files that failed as if they were still being
+	// written, with stableSince when they failed, tried again if they
+	// change within incompleteFor
+	incomplete map[string]*pendingFile
+}
+
+// incompleteFor is how long a file that failed as if cut short is looked
+// at for growing.
+const incompleteFor = 24 * time.Hour
+
+// watchSetup is what a reload can change about a running watch.
+type watchSetup struct {
+	c        *converter
+	roots    []string
+	debounce time.Duration
+}
+
+type pendingFile struct {
+	root        string
+	size        int64
+	modTime     time.Time
+	stableSince time.Time
+}
+
+func newWatcher(c *converter, debounce time.Duration) *watcher {
+	if c.roots == nil {
+		c.roots = map[string]string{}
+	}
+	return &watcher{
+		c:          c,
+		debounce:   debounce,
+		pending:    map[string]*pendingFile{},
+		tried:      map[string]*pendingFile{},
+		incomplete: map[string]*pendingFile{},
+	}
+}
+
+// notice records that name under root was created or changed.
+func (w *watcher) notice(root string, name string, now time.Time) {
+	if first := firstVolume(w.c.fs, name); first != "" {
+		// the set is converted once the last volume stops changing too
+		name = first
+	} else if !w.c.isSource(name) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if p, ok := w.pending[name]; ok {
+		p.stableSince = now
+		return
+	}
+	w.pending[name] = &pendingFile{root: root, size: -1, stableSince: now}
+}
+
+// due returns the pending files that haven't changed for debounce, and
+// forgets about them. Those tried lately and unchanged since are dropped.
+// Files that failed as if incomplete are pending again once they change.
+func (w *watcher) due(now time.Time) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for name, p := range w.incomplete {
+		info, err := hackpadfs.Stat(w.c.fs, pathToFsPath(name))
+		if err != nil || now.Sub(p.stableSince) >= incompleteFor {
+			delete(w.incomplete, name)
+			continue
+		}
+		if info.Size() == p.size && info.ModTime().Equal(p.modTime) {
+			continue
+		}
+		delete(w.incomplete, name)
+		if _, ok := w.pending[name]; !ok {
+			w.c.logger.Printf("%s changed since it failed, trying again once it stops changing\n", name)
+			w.pending[name] = &pendingFile{root: p.root, size: info.Size(), modTime: info.ModTime(), stableSince: now}
+		}
+	}
+
+	ready := []string{}
+	for name, p := range w.pending {
+		info, err := hackpadfs.Stat(w.c.fs, pathToFsPath(name))
+		if err != nil {
+			// moved away or already converted
+			delete(w.pending, name)
+			continue
+		}
+		if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+			p.size = info.Size()
+			p.modTime = info.ModTime()
+			p.stableSince = now
+			continue
+		}
+		if now.Sub(p.stableSince) >= w.debounce {
+			delete(w.pending, name)
+			if e, ok := w.recent.seen(name, p.size, p.modTime, now); ok {
+				w.c.logger.Printf("Skipping %s, it %s %s ago and hasn't changed since\n", name, e.Status, formatDuration(now.Sub(e.At)))
+				continue
+			}
+			ready = append(ready, name)
+			w.c.roots[name] = p.root
+			w.tried[name] = p
+		}
+	}
+	return ready
+}
+
+// scan notices every cbr already under dir.
+func (w *watcher) scan(root string, dir string, now time.Time) error {
+	files, err := findFiles(w.c.fs, dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		w.notice(root, file, now)
+	}
+	return nil
+}
+
+// run watches roots until work is cancelled, converting one file at a time.
+// A conversion still running then is given until hard is cancelled.
+func (w *watcher) run(work context.Context, hard context.Context, roots []string, interval time.Duration, health *healthState) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "starting watcher")
+	}
+	defer fsw.Close()
+
+	// os paths of the watched directories to the root they're under
+	watchedDirs := map[string]string{}
+	for _, root := range roots {
+		root = "/" + pathToFsPath(root)
+		err := w.addTree(fsw, watchedDirs, root, root)
+		if err != nil {
+			return errors.Wrapf(err, "watching %s", root)
+		}
+		err = w.scan(root, root, time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "scanning %s", root)
+		}
+		w.c.logger.Printf("Watching %s\n", root)
+	}
+	health.setReady(true, "")
+	w.purgeDue(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	queue := []string{}
+	done := make(chan error)
+	current := ""
+	for {
+		select {
+		case <-work.Done():
+			if current != "" {
+				w.c.logger.Printf("Waiting for %s to finish\n", current)
+				w.finished(current, <-done)
+			}
+			w.c.webhook.flush()
+			return nil
+		case ev, ok := <-fsw.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			w.handle(fsw, watchedDirs, ev)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			w.c.warn(cbr2cbz.CodeWatchError, "", "Watch error: %s", err.Error())
+		case <-ticker.C:
+			health.beat()
+			queue = append(queue, w.due(time.Now())...)
+			w.purgeDue(time.Now())
+		case err := <-done:
+			w.finished(current, err)
+			if !isSkip(err) {
+				health.record(current, err)
+			}
+			current = ""
+		case reply := <-w.reloads:
+			var err error
+			queue, err = w.applyReload(fsw, watchedDirs, queue)
+			if err != nil {
+				w.c.logger.Printf("Reload failed, carrying on as before: %s\n", err.Error())
+			} else {
+				w.c.logger.Printf("Reloaded the configuration\n")
+			}
+			if reply != nil {
+				reply <- err
+			}
+		}
+
+		if current == "" && len(queue) > 0 {
+			current, queue = queue[0], queue[1:]
+			// a reload while this converts only applies to the next file
+			c, cbrFile, cbzFile := w.c, current, w.c.cbzPath(current)
+			go func() {
+				if err := c.preHook(hard, cbrFile, cbzFile); err != nil {
+					done <- err
+					return
+				}
+				done <- c.withRetries(hard, cbrFile, func(written func()) error {
+					return c.convertWithScratch(hard, cbrFile, cbzFile, written)
+				}, nil)
+			}()
+		}
+		busy := 0
+		if current != "" {
+			busy = 1
+		}
+		health.setQueued(len(queue) + busy)
+		if current == "" {
+			// once the queue is done, rather than for every file
+			w.c.libraries.refresh()
+		}
+	}
+}
+
+// purgeInterval is how often watch --purge-after looks for originals due.
+const purgeInterval = time.Hour
+
+// purgeDue purges the originals kept longer than purgeAfter, once every
+// purgeInterval.
+func (w *watcher) purgeDue(now time.Time) {
+	if w.purgeAfter <= 0 || now.Before(w.nextPurge) {
+		return
+	}
+	w.nextPurge = now.Add(purgeInterval)
+	_, _, err := purgeRetained(w.c.retentionPath, w.purgeAfter, now, false, w.c.logger)
+	if err != nil {
+		w.c.logger.Printf("Unable to purge originals: %s\n", err.Error())
+	}
+}
+
+// applyReload switches to the converter and roots of a reload, watching
+// the roots that are new and forgetting the files under those that are
+// gone, queue included. Nothing changes if the reload fails.
+func (w *watcher) applyReload(fsw *fsnotify.Watcher, watchedDirs map[string]string, queue []string) ([]string, error) {
+	if w.reload == nil {
+		return queue, errors.New("nothing to reload from")
+	}
+	setup, err := w.reload()
+	if err != nil {
+		return queue, err
+	}
+	wanted := map[string]bool{}
+	roots := []string{}
+	for _, root := range setup.roots {
+		root = "/" + pathToFsPath(root)
+		info, err := hackpadfs.Stat(setup.c.fs, pathToFsPath(root))
+		if err == nil && !info.IsDir() {
+			err = errors.New("not a directory")
+		}
+		if err != nil {
+			return queue, errors.Wrapf(err, "watching %s", root)
+		}
+		if !wanted[root] {
+			wanted[root] = true
+			roots = append(roots, root)
+		}
+	}
+
+	setup.c.roots = w.c.roots
+	w.c = setup.c
+	w.debounce = setup.debounce
+
+	watching := map[string]bool{}
+	for osPath, root := range watchedDirs {
+		watching[root] = true
+		if !wanted[root] {
+			fsw.Remove(osPath)
+			delete(watchedDirs, osPath)
+		}
+	}
+	for root := range watching {
+		if !wanted[root] {
+			w.c.logger.Printf("No longer watching %s\n", root)
+		}
+	}
+	w.mu.Lock()
+	for name, p := range w.pending {
+		if !wanted[p.root] {
+			delete(w.pending, name)
+		}
+	}
+	for name, p := range w.incomplete {
+		if !wanted[p.root] {
+			delete(w.incomplete, name)
+		}
+	}
+	w.mu.Unlock()
+	kept := []string{}
+	for _, name := range queue {
+		if wanted[w.c.roots[name]] {
+			kept = append(kept, name)
+		}
+	}
+
+	for _, root := range roots {
+		if watching[root] {
+			continue
+		}
+		err := w.addTree(fsw, watchedDirs, root, root)
+		if err == nil {
+			err = w.scan(root, root, time.Now())
+		}
+		if err != nil {
+			w.c.warn(cbr2cbz.CodeWatchError, "", "Unable to watch %s: %s", root, err.Error())
+			continue
+		}
+		w.c.logger.Printf("Watching %s\n", root)
+	}
+	return kept, nil
+}
+
+// reloadWatch reads the config file again for the watch command, keeping
+// the paths in args if there are any.
+func reloadWatch(cmd *cobra.Command, logger logger, args []string) (*watchSetup, error) {
+	// configFlagSets, which would refer back to watchCmd from its own Run
+	sets := []*pflag.FlagSet{rootCmd.PersistentFlags(), convertCmd.Flags(), cmd.Flags()}
+	snapshot := snapshotFlags(sets...)
+	setup, err := func() (*watchSetup, error) {
+		cfg, err := reloadConfigFile(sets...)
+		if err != nil {
+			return nil, err
+		}
+		roots := args
+		if len(roots) == 0 {
+			if cfg == nil || len(cfg.paths) == 0 {
+				return nil, errors.New("the config file has no paths to watch")
+			}
+			roots = cfg.paths
+		}
+		c, err := newConverter(logger)
+		if err != nil {
+			return nil, err
+		}
+		roots, err = c.localInputs(roots)
+		if err != nil {
+			return nil, err
+		}
+		c.settings = effectiveOptions(cmd.Flags())
+		loadedConfig = cfg
+		return &watchSetup{c: c, roots: roots, debounce: watchDebounce}, nil
+	}()
+	if err != nil {
+		snapshot.restore()
+		return nil, err
+	}
+	return setup, nil
+}
+
+func (w *watcher) finished(cbrFile string, err error) {
+	w.mu.Lock()
+	p := w.tried[cbrFile]
+	delete(w.tried, cbrFile)
+	retry := p != nil && mayBeIncomplete(err)
+	if retry {
+		w.incomplete[cbrFile] = &pendingFile{root: p.root, size: p.size, modTime: p.modTime, stableSince: time.Now()}
+	}
+	w.mu.Unlock()
+
+	w.remember(cbrFile, p, err)
+	if err == nil {
+		w.c.libraries.add(w.c.cbzPath(cbrFile))
+	}
+	var size int64
+	if p != nil {
+		size = p.size
+	}
+	event := w.c.fileEvent(cbrFile, w.c.cbzPath(cbrFile), size, 0, explainFileLimit(err))
+	w.c.webhook.send(fileWebhook(event))
+	w.c.postHook(context.Background(), event)
+	switch {
+	case isSkip(err):
+		w.c.warn(errorCode(err), cbrFile, "Skipping %s, %s", cbrFile, err.Error())
+	case retry:
+		w.c.logger.Printf("[%s] Error Reading %s - Skipping until it changes, it may still be downloading...%s\n", errorCode(err), cbrFile, err.Error())
+	case err != nil:
+		w.c.logger.Printf("[%s] Error Reading %s - Skipping...%s\n", errorCode(err), cbrFile, explainFileLimit(err).Error())
+		w.c.quarantineFailed(map[string]error{cbrFile: explainFileLimit(err)})
+	case w.c.seriesJSON:
+		w.c.writeSeriesJSON([]string{w.c.cbzPath(cbrFile)})
+	}
+}
+
+// mayBeIncomplete reports whether err is how a file that hasn't been
+// written in full yet fails: not looking like an archive, ending early or
+// not matching its checksums.
+func mayBeIncomplete(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, cbr2cbz.ErrNotArchive) || errors.Is(err, io.ErrUnexpectedEOF) || isChecksumError(err)
+}
+
+// remember adds how cbrFile, which looked like p when it was handed out,
+// went to the recent index. Files claimed elsewhere or interrupted weren't
+// tried and are left out.
+func (w *watcher) remember(cbrFile string, p *pendingFile, err error) {
+	if p == nil || errors.Is(err, errClaimed) || errors.Is(err, context.Canceled) {
+		return
+	}
+	e := recentFile{Size: p.size, ModTime: p.modTime, Status: "converted", At: time.Now()}
+	if err != nil {
+		e.Status, e.Code = "failed", errorCode(err)
+	}
+	if err := w.recent.add(cbrFile, e); err != nil {
+		w.c.logger.Printf("Unable to save %s: %s\n", w.recent.path, err.Error())
+	}
+}
+
+// addTree watches dir and every directory below it.
+func (w *watcher) addTree(fsw *fsnotify.Watcher, watchedDirs map[string]string, root string, dir string) error {
+	return fs.WalkDir(w.c.fs, pathToFsPath(dir), func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		osPath, err := w.toOSPath(name)
+		if err != nil {
+			return err
+		}
+		watchedDirs[osPath] = root
+		return fsw.Add(osPath)
+	})
+}
+
+// handle reacts to one filesystem event, picking up new files and watching
+// new directories.
+func (w *watcher) handle(fsw *fsnotify.Watcher, watchedDirs map[string]string, ev fsnotify.Event) {
+	if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) {
+		return
+	}
+	root, ok := watchedDirs[filepath.Dir(ev.Name)]
+	if !ok {
+		return
+	}
+	name, err := w.fromOSPath(root, ev.Name)
+	if err != nil {
+		return
+	}
+
+	info, err := hackpadfs.Stat(w.c.fs, pathToFsPath(name))
+	if err == nil && info.IsDir() && ev.Has(fsnotify.Create) {
+		if err := w.addTree(fsw, watchedDirs, root, name); err != nil {
+			w.c.warn(cbr2cbz.CodeWatchError, "", "Unable to watch %s: %s", name, err.Error())
+		}
+		// anything moved in with the directory won't get its own event
+		w.scan(root, name, time.Now())
+		return
+	}
+	w.notice(root, name, time.Now())
+}
+
+func (w *watcher) toOSPath(name string) (string, error) {
+	if osFS, ok := w.c.fs.(interface {
+		ToOSPath(string) (string, error)
+	}); ok {
+		return osFS.ToOSPath(pathToFsPath(name))
+	}
+	return filepath.FromSlash("/" + pathToFsPath(name)), nil
+}
+
+// fromOSPath maps an event's os path back to a path in the converter's
+// filesystem, through the root it's under.
+func (w *watcher) fromOSPath(root string, osPath string) (string, error) {
+	osRoot, err := w.toOSPath(root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(osRoot, osPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", errors.Errorf("%s is outside %s", osPath, root)
+	}
+	return path.Join(root, filepath.ToSlash(rel)), nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	hackpados "github.com/hack-pad/hackpadfs/os"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_watcherDebounce(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"drop/test.cbr":   realCBRContents,
+		"drop/notes.txt":  []byte("hi"),
+		"drop/gone.cbr":   realCBRContents,
+		"drop/a/deep.cbr": realCBRContents,
+	})
+	require.NoError(t, err)
+
+	w := newWatcher(&converter{fs: fsys, logger: testLogger{t}}, time.Minute)
+	start := time.Now()
+	require.NoError(t, w.scan("/drop", "/drop", start))
+	require.Len(t, w.pending, 3, "only cbr files are picked up")
+
+	require.Empty(t, w.due(start), "first look only records size and time")
+	require.NoError(t, hackpadfs.Remove(fsys, "drop/gone.cbr"))
+	require.Empty(t, w.due(start.Add(30*time.Second)), "not stable for long enough")
+	require.Len(t, w.pending, 2, "removed files are forgotten")
+
+	// still being copied
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "drop/test.cbr", append(realCBRContents, 0), 0644))
+	require.Equal(t, []string{"/drop/a/deep.cbr"}, w.due(start.Add(time.Minute)))
+	require.Empty(t, w.due(start.Add(90*time.Second)))
+	require.Equal(t, []string{"/drop/test.cbr"}, w.due(start.Add(2*time.Minute)))
+	require.Empty(t, w.pending)
+	require.Equal(t, "/drop", w.c.roots["/drop/test.cbr"])
+}
+
+func Test_watcherRun(t *testing.T) {
+	dir := t.TempDir()
+	c := &converter{fs: hackpados.NewFS(), logger: testLogger{t}}
+	w := newWatcher(c, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := make(chan error)
+	go func() {
+		ran <- w.run(ctx, context.Background(), []string{dir}, 10*time.Millisecond, newHealthState(0))
+	}()
+
+	// dropped into a new directory after the watcher started
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "new"), 0755))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "is-zip.cbr"), notrealCBRContents, 0644))
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "new", "is-zip.cbz"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-ran)
+}
+
+func Test_watcherRecent(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"drop/broken.cbr": []byte("not a rar"),
+	})
+	require.NoError(t, err)
+	indexPath := filepath.Join(t.TempDir(), "recent.json")
+	start := time.Now()
+
+	watchOnce := func(now time.Time) []string {
+		recent, err := loadRecentIndex(indexPath, time.Hour, now)
+		require.NoError(t, err)
+		w := newWatcher(&converter{fs: fsys, logger: testLogger{t}}, 0)
+		w.recent = recent
+		require.NoError(t, w.scan("/drop", "/drop", now))
+		require.Empty(t, w.due(now))
+		ready := w.due(now)
+		for _, name := range ready {
+			w.finished(name, w.c.convertWithScratch(context.Background(), name, w.c.cbzPath(name), nil))
+		}
+		return ready
+	}
+
+	require.Equal(t, []string{"/drop/broken.cbr"}, watchOnce(start))
+	require.Empty(t, watchOnce(start.Add(time.Minute)), "failed a minute ago")
+
+	recent, err := loadRecentIndex(indexPath, time.Hour, start)
+	require.NoError(t, err)
+	require.Equal(t, "failed", recent.entries["/drop/broken.cbr"].Status)
+
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "drop/broken.cbr", []byte("still not a rar"), 0644))
+	require.Equal(t, []string{"/drop/broken.cbr"}, watchOnce(start.Add(2*time.Minute)), "changed since")
+	require.Equal(t, []string{"/drop/broken.cbr"}, watchOnce(start.Add(3*time.Hour)), "no longer recent")
+}
+
+func Test_watcherIncomplete(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"drop/test.cbr": realCBRContents[:len(realCBRContents)/2],
+	})
+	require.NoError(t, err)
+	w := newWatcher(&converter{fs: fsys, logger: testLogger{t}}, 0)
+	now := time.Now()
+	require.NoError(t, w.scan("/drop", "/drop", now))
+	require.Empty(t, w.due(now))
+	for _, name := range w.due(now) {
+		w.finished(name, w.c.convertWithScratch(context.Background(), name, w.c.cbzPath(name), nil))
+	}
+	require.Contains(t, w.incomplete, "/drop/test.cbr")
+	require.Empty(t, w.due(now.Add(time.Minute)), "hasn't changed")
+
+	// the download finishes without an event being noticed
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "drop/test.cbr", realCBRContents, 0644))
+	require.Equal(t, []string{"/drop/test.cbr"}, w.due(now.Add(2*time.Minute)))
+	require.Empty(t, w.incomplete)
+	w.finished("/drop/test.cbr", w.c.convertWithScratch(context.Background(), "/drop/test.cbr", "/drop/test.cbz", nil))
+	require.Empty(t, w.incomplete)
+	_, err = hackpadfs.Stat(fsys, "drop/test.cbz")
+	require.NoError(t, err)
+
+	require.False(t, mayBeIncomplete(nil))
+	require.False(t, mayBeIncomplete(errPasswordRequired))
+	require.True(t, mayBeIncomplete(errors.Wrap(io.ErrUnexpectedEOF, "reading")))
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+	"github.com/studio-b12/gowebdav"
+)
+
+// webdavPasswordEnv holds the password for webdav:// paths that don't have
+// one in the url.
+const webdavPasswordEnv = envPrefix + "WEBDAV_PASSWORD"
+
+// webdavFS is a WebDAV share. Names are taken from the root of the server,
+// webdavs://nas/dav/comics is /dav/comics there. Renames and copies are
+// done by the server.
+type webdavFS struct {
+	client *gowebdav.Client
+}
+
+// newWebDAVFS connects to the server in u, webdav:// for http and webdavs://
+// for https, as the user in u with the password in u or
+// $CBR2CBZ_WEBDAV_PASSWORD.
+func newWebDAVFS(u *url.URL) (*webdavFS, error) {
+	scheme := "http"
+	if u.Scheme == "webdavs" {
+		scheme = "https"
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		password = os.Getenv(webdavPasswordEnv)
+	}
+	// no connecting up front, shares are often only readable below the root
+	client := gowebdav.NewClient(scheme+"://"+u.Host, u.User.Username(), password)
+	return &webdavFS{client: client}, nil
+}
+
+func (w *webdavFS) pathError(op string, name string, err error) error {
+	if gowebdav.IsErrNotFound(err) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (w *webdavFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := w.client.Stat(remoteName(name))
+	if err != nil {
+		return nil, w.pathError("stat", name, err)
+	}
+	return remoteInfo{name: path.Base(name), size: info.Size(), modTime: info.ModTime(), dir: info.IsDir()}, nil
+}
+
+func (w *webdavFS) Open(name string) (fs.File, error) {
+	info, err := w.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &remoteDir{fs: w, name: name, info: info}, nil
+	}
+	return &remoteFile{name: name, info: info, fetch: func(length int64) (io.ReadCloser, error) {
+		var (
+			r   io.ReadCloser
+			err error
+		)
+		if length > 0 {
+			r, err = w.client.ReadStreamRange(remoteName(name), 0, length)
+		} else {
+			r, err = w.client.ReadStream(remoteName(name))
+		}
+		if err != nil {
+			return nil, w.pathError("read", name, err)
+		}
+		return r, nil
+	}}, nil
+}
+
+func (w *webdavFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	infos, err := w.client.ReadDir(remoteName(name))
+	if err != nil {
+		return nil, w.pathError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(remoteInfo{name: info.Name(), size: info.Size(), modTime: info.ModTime(), dir: info.IsDir()}))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// OpenFile opens name for reading, or for writing when flag asks for it.
+// Written files are uploaded when they are closed.
+func (w *webdavFS) OpenFile(name string, flag int, perm hackpadfs.FileMode) (hackpadfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return w.Open(name)
+	}
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if flag&os.O_APPEND != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("webdav files can't be appended to")}
+	}
+	if flag&os.O_EXCL != 0 {
+		if _, err := w.Stat(name); err == nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+	}
+
+	return newRemoteUpload(name, func(r io.Reader, size int64) error {
+		err := w.client.WriteStream(remoteName(name), r, perm)
+		if err != nil {
+			return w.pathError("write", name, err)
+		}
+		return nil
+	})
+}
+
+func (w *webdavFS) Remove(name string) error {
+	// deleting something that isn't there succeeds in WebDAV
+	if _, err := w.Stat(name); err != nil {
+		return err
+	}
+	err := w.client.Remove(remoteName(name))
+	if err != nil {
+		return w.pathError("remove", name, err)
+	}
+	return nil
+}
+
+func (w *webdavFS) Rename(oldname, newname string) error {
+	err := w.client.Rename(remoteName(oldname), remoteName(newname), true)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Copy copies src to dst on the server, without the data leaving it.
+func (w *webdavFS) Copy(src, dst string) error {
+	err := w.client.Copy(remoteName(src), remoteName(dst), true)
+	if err != nil {
+		return w.pathError("copy", src, err)
+	}
+	return nil
+}
+
+func (w *webdavFS) Mkdir(name string, perm hackpadfs.FileMode) error {
+	err := w.client.Mkdir(remoteName(name), perm)
+	if err != nil {
+		return w.pathError("mkdir", name, err)
+	}
+	return nil
+}
+
+func (w *webdavFS) MkdirAll(name string, perm hackpadfs.FileMode) error {
+	err := w.client.MkdirAll(remoteName(name), perm)
+	if err != nil {
+		return w.pathError("mkdir", name, err)
+	}
+	return nil
+}
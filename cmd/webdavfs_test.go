@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+// newTestWebDAV serves an in memory WebDAV share, counting the requests by
+// method.
+func newTestWebDAV(t *testing.T, files filenameBytes) (*webdavFS, map[string]int, *sync.Mutex) {
+	t.Helper()
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	methods := map[string]int{}
+	mu := &sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods[r.Method]++
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	u.Scheme = "webdav"
+	fsys, err := newWebDAVFS(u)
+	require.NoError(t, err)
+	for name, data := range files {
+		require.NoError(t, hackpadfs.MkdirAll(fsys, path.Dir(name), 0o755))
+		require.NoError(t, hackpadfs.WriteFullFile(fsys, name, data, 0o644))
+	}
+	return fsys, methods, mu
+}
+
+func Test_webdavFS(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), remoteHeadSize/5)
+	fsys, _, _ := newTestWebDAV(t, filenameBytes{
+		"library/a.cbr":          []byte("rar"),
+		"library/big.cbr":        big,
+		"library/nested/b/c.cbr": []byte("deep"),
+	})
+
+	files, err := findFiles(fsys, "/library")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/library/a.cbr", "/library/big.cbr", "/library/nested/b/c.cbr"}, files)
+
+	_, err = fs.Stat(fsys, "library/missing")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// reads within the head and past it
+	f, err := fsys.Open("library/big.cbr")
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = f.(io.ReaderAt).ReadAt(buf, 20)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(buf))
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, big, data)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "library/a.cbz", []byte("zip"), 0o644))
+	require.NoError(t, hackpadfs.Rename(fsys, "library/a.cbz", "library/renamed.cbz"))
+	data, err = fs.ReadFile(fsys, "library/renamed.cbz")
+	require.NoError(t, err)
+	require.Equal(t, "zip", string(data))
+	require.ErrorIs(t, hackpadfs.Remove(fsys, "library/a.cbz"), fs.ErrNotExist)
+}
+
+func Test_convertWebDAV(t *testing.T) {
+	fsys, methods, mu := newTestWebDAV(t, filenameBytes{
+		"library/test.cbr":   realCBRContents,
+		"library/is-zip.cbr": notrealCBRContents,
+	})
+	mu.Lock()
+	clear(methods)
+	mu.Unlock()
+
+	c := &converter{fs: fsys, logger: testLogger{t}, verify: true}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	require.Empty(t, c.failed)
+	require.Len(t, c.converted, 2)
+
+	require.NoError(t, verifyZip(fsys, "library/test.cbz"))
+	require.NoError(t, verifyZip(fsys, "library/is-zip.cbz"))
+	data, err := fs.ReadFile(fsys, "library/is-zip.cbz")
+	require.NoError(t, err)
+	require.NotEqual(t, notrealCBRContents, data, "the zip in disguise is repacked")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 2, methods[http.MethodPut])
+}
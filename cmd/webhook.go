@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var webhookURL string
+
+// webhookQueue is how many notifications may wait on a slow endpoint before
+// more are dropped.
+const webhookQueue = 100
+
+// webhookPayload is what --webhook-url is sent as each file and each batch
+// finishes.
+type webhookPayload struct {
+	// Event is "file" or "batch"
+	Event string `json:"event"`
+	// Text and Content are the same line for people, where Slack and
+	// Discord look for one
+	Text    string        `json:"text"`
+	Content string        `json:"content"`
+	File    *logEvent     `json:"file,omitempty"`
+	Batch   *webhookBatch `json:"batch,omitempty"`
+}
+
+// webhookBatch is the outcome of a batch.
+type webhookBatch struct {
+	Converted int   `json:"converted"`
+	Failed    int   `json:"failed"`
+	BytesIn   int64 `json:"bytes_in"`
+	BytesOut  int64 `json:"bytes_out"`
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+	// NotStarted is how many files were left when the batch stopped at
+	// its deadline
+	NotStarted  int              `json:"not_started,omitempty"`
+	FailedFiles []webhookFailure `json:"failed_files"`
+}
+
+type webhookFailure struct {
+	File  string `json:"file"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// webhook posts notifications to url one at a time, so a slow endpoint
+// holds up the notifications but not the conversions. Failing to deliver
+// one is only logged. A nil webhook drops them.
+type webhook struct {
+	url     string
+	logger  logger
+	queue   chan webhookPayload
+	start   sync.Once
+	pending sync.WaitGroup
+}
+
+func newWebhook(rawURL string, logger logger) (*webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("--webhook-url must be an http or https URL, got %q", rawURL)
+	}
+	return &webhook{url: rawURL, logger: logger, queue: make(chan webhookPayload, webhookQueue)}, nil
+}
+
+func (h *webhook) send(p webhookPayload) {
+	if h == nil {
+		return
+	}
+	h.start.Do(func() { go h.deliver() })
+	h.pending.Add(1)
+	select {
+	case h.queue <- p:
+	default:
+		h.pending.Done()
+		h.logger.Printf("Webhook is falling behind, dropping: %s\n", p.Text)
+	}
+}
+
+func (h *webhook) deliver() {
+	for p := range h.queue {
+		data, err := json.Marshal(p)
+		if err == nil {
+			err = postJSON(h.url, data)
+		}
+		if err != nil {
+			h.logger.Printf("Unable to send webhook: %s\n", err.Error())
+		}
+		h.pending.Done()
+	}
+}
+
+// flush waits for every notification sent so far to be delivered or given
+// up on.
+func (h *webhook) flush() {
+	if h == nil {
+		return
+	}
+	h.pending.Wait()
+}
+
+// fileWebhook is the notification for a file having finished.
+func fileWebhook(e logEvent) webhookPayload {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	var text string
+	switch e.Action {
+	case "convert":
+		text = fmt.Sprintf("Converted %s (%s to %s)", filepath.Base(e.File), formatBytes(uint64(e.BytesIn)), formatBytes(uint64(e.BytesOut)))
+	case "skip":
+		text = fmt.Sprintf("Skipped %s, %s", filepath.Base(e.File), e.Error)
+	default:
+		text = fmt.Sprintf("Failed to convert %s: [%s] %s", filepath.Base(e.File), e.Code, e.Error)
+	}
+	return webhookPayload{Event: "file", Text: text, Content: text, File: &e}
+}
+
+// batchWebhook is the notification for the batch that just finished.
+func (c *converter) batchWebhook(startTime time.Time) webhookPayload {
+	b := &webhookBatch{
+		Converted:   len(c.converted),
+		Failed:      len(c.failed),
+		BytesIn:     c.bytesIn,
+		BytesOut:    c.bytesOut,
+		Duration:    time.Since(startTime).Seconds(),
+		NotStarted:  c.notStarted,
+		FailedFiles: []webhookFailure{},
+	}
+	for file, err := range c.failed {
+		b.FailedFiles = append(b.FailedFiles, webhookFailure{File: file, Code: errorCode(err), Error: err.Error()})
+	}
+	sort.Slice(b.FailedFiles, func(i, j int) bool { return b.FailedFiles[i].File < b.FailedFiles[j].File })
+
+	text := fmt.Sprintf("Batch finished in %s: %d converted, %d failed", time.Since(startTime).Round(time.Second), b.Converted, b.Failed)
+	if b.NotStarted > 0 {
+		text += fmt.Sprintf(", %d left for --resume", b.NotStarted)
+	}
+	return webhookPayload{Event: "batch", Text: text, Content: text, Batch: b}
+}
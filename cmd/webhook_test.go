@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_webhook(t *testing.T) {
+	payloads := make(chan webhookPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := webhookPayload{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		payloads <- p
+	}))
+	defer server.Close()
+
+	fsys, err := setupFS(t, filenameBytes{
+		"library/a.cbr":      realCBRContents,
+		"library/broken.cbr": []byte("not a rar"),
+	})
+	require.NoError(t, err)
+	hook, err := newWebhook(server.URL, testLogger{t})
+	require.NoError(t, err)
+
+	c := &converter{fs: fsys, logger: testLogger{t}, webhook: hook}
+	require.NoError(t, c.runConvert(context.Background(), []string{"/library"}))
+	close(payloads)
+
+	files := map[string]webhookPayload{}
+	var batch *webhookBatch
+	for p := range payloads {
+		switch p.Event {
+		case "file":
+			files[p.File.File] = p
+		case "batch":
+			require.Len(t, files, 2, "the batch comes after its files")
+			require.Equal(t, p.Text, p.Content)
+			batch = p.Batch
+		}
+	}
+	require.Equal(t, "convert", files["/library/a.cbr"].File.Action)
+	require.Contains(t, files["/library/a.cbr"].Text, "Converted a.cbr (140 B to ")
+	require.Equal(t, cbr2cbz.CodeNotArchive, files["/library/broken.cbr"].File.Code)
+	require.NotNil(t, batch)
+	require.Equal(t, 1, batch.Converted)
+	require.Equal(t, 1, batch.Failed)
+	require.Equal(t, []webhookFailure{{
+		File:  "/library/broken.cbr",
+		Code:  cbr2cbz.CodeNotArchive,
+		Error: batch.FailedFiles[0].Error,
+	}}, batch.FailedFiles)
+
+	_, err = newWebhook("ftp://example.com", testLogger{t})
+	require.Error(t, err)
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// webPage is the drag and drop page of serve, built on the uploads api.
+//
+//go:embed web/index.html
+var webPage []byte
+
+func serveWebPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(webPage)
+}
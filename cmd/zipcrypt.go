@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errPasswordRequired = errors.New("encrypted and no password configured for it")
+	errWrongPassword    = errors.New("wrong password")
+)
+
+const (
+	zipFlagEncrypted      = 0x1
+	zipFlagDataDescriptor = 0x8
+	zipMethodAES          = 99
+	zipExtraAES           = 0x9901
+)
+
+// passwordRule gives the password for encrypted archives matching pattern.
+type passwordRule struct {
+	pattern  string
+	password string
+}
+
+// zipPasswords picks the password for an archive, the first matching rule
+// wins and fallback is used for everything else.
+type zipPasswords struct {
+	rules    []passwordRule
+	fallback string
+}
+
+func (p zipPasswords) any() bool {
+	return len(p.rules) > 0 || p.fallback != ""
+}
+
+// lookup matches the rules against the archive's full path, its base name
+// and the directories it is in.
+func (p zipPasswords) lookup(name string) string {
+	name = "/" + pathToFsPath(name)
+	for _, rule := range p.rules {
+		pattern := rule.pattern
+		if ok, _ := path.Match(pattern, name); ok {
+			return rule.password
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return rule.password
+		}
+		if dir := "/" + pathToFsPath(pattern); dir != "/" && strings.HasPrefix(name, dir+"/") {
+			return rule.password
+		}
+	}
+	return p.fallback
+}
+
+func isEncrypted(f *zip.File) bool {
+	return f.Flags&zipFlagEncrypted != 0
+}
+
+// hasEncrypted reports whether any entry of r needs a password.
+func hasEncrypted(r *zip.Reader) bool {
+	for _, f := range r.File {
+		if isEncrypted(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// openZipEntry opens f, decrypting it with password if it is encrypted with
+// either the traditional PKWARE scheme or WinZip AES.
+func openZipEntry(f *zip.File, password string) (io.ReadCloser, error) {
+	if !isEncrypted(f) {
+		return f.Open()
+	}
+	if password == "" {
+		return nil, errPasswordRequired
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	method, checkCRC := f.Method, true
+	if f.Method == zipMethodAES {
+		var aeVersion uint16
+		data, method, aeVersion, err = decryptAES(f, data, password)
+		// AE-2 leaves the CRC out, the HMAC covers it instead
+		checkCRC = aeVersion == 1
+	} else {
+		data, err = decryptZipCrypto(f, data, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var plain []byte
+	switch method {
+	case zip.Store:
+		plain = data
+	case zip.Deflate:
+		plain, err = io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, errors.Wrap(err, "inflating")
+		}
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+
+	if checkCRC && crc32.ChecksumIEEE(plain) != f.CRC32 {
+		return nil, errWrongPassword
+	}
+	return io.NopCloser(bytes.NewReader(plain)), nil
+}
+
+// zipCryptoKeys is the state of the traditional PKWARE stream cipher.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	k := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+(k[0]&0xff))*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+func (k *zipCryptoKeys) decrypt(b byte) byte {
+	t := k[2] | 2
+	plain := b ^ byte((t*(t^1))>>8)
+	k.update(plain)
+	return plain
+}
+
+func decryptZipCrypto(f *zip.File, data []byte, password string) ([]byte, error) {
+	if len(data) < 12 {
+		return nil, errors.New("encrypted entry too short")
+	}
+	keys := newZipCryptoKeys(password)
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = keys.decrypt(b)
+	}
+
+	// the last header byte is a quick check against the CRC, or the
+	// modification time when the CRC comes after the data
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&zipFlagDataDescriptor != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if out[11] != check {
+		return nil, errWrongPassword
+	}
+	return out[12:], nil
+}
+
+// decryptAES handles WinZip AES entries, returning the data along with the
+// compression method and AE version from the entry's extra field.
+func decryptAES(f *zip.File, data []byte, password string) ([]byte, uint16, uint16, error) {
+	version, strength, method, ok := aesExtra(f.Extra)
+	if !ok {
+		return nil, 0, 0, errors.New("AES entry without its extra field")
+	}
+	keyLen := map[byte]int{1: 16, 2: 24, 3: 32}[strength]
+	if keyLen == 0 {
+		return nil, 0, 0, errors.Errorf("unknown AES strength %d", strength)
+	}
+	saltLen := keyLen / 2
+	if len(data) < saltLen+2+10 {
+		return nil, 0, 0, errors.New("encrypted entry too short")
+	}
+
+	salt, verifier := data[:saltLen], data[saltLen:saltLen+2]
+	body, mac := data[saltLen+2:len(data)-10], data[len(data)-10:]
+
+	keys := pbkdf2SHA1([]byte(password), salt, 1000, 2*keyLen+2)
+	encKey, macKey, check := keys[:keyLen], keys[keyLen:2*keyLen], keys[2*keyLen:]
+	if subtle.ConstantTimeCompare(check, verifier) != 1 {
+		return nil, 0, 0, errWrongPassword
+	}
+
+	h := hmac.New(sha1.New, macKey)
+	h.Write(body)
+	if subtle.ConstantTimeCompare(h.Sum(nil)[:10], mac) != 1 {
+		return nil, 0, 0, errors.New("AES authentication failed, the entry is corrupt")
+	}
+
+	out, err := winzipCTR(encKey, body)
+	return out, method, version, err
+}
+
+func aesExtra(extra []byte) (version uint16, strength byte, method uint16, ok bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return 0, 0, 0, false
+		}
+		field := extra[4 : 4+size]
+		if id == zipExtraAES && size >= 7 {
+			return binary.LittleEndian.Uint16(field), field[4], binary.LittleEndian.Uint16(field[5:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, 0, 0, false
+}
+
+// winzipCTR is AES in counter mode the way WinZip does it, with a little
+// endian counter starting at 1.
+func winzipCTR(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(data); i += aes.BlockSize {
+		for j := range counter {
+			counter[j]++
+			if counter[j] != 0 {
+				break
+			}
+		}
+		block.Encrypt(stream[:], counter[:])
+		for j := i; j < len(data) && j < i+aes.BlockSize; j++ {
+			out[j] = data[j] ^ stream[j-i]
+		}
+	}
+	return out, nil
+}
+
+func pbkdf2SHA1(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	out := make([]byte, 0, keyLen)
+	for block := uint32(1); len(out) < keyLen; block++ {
+		out = append(out, pbkdf2Block(prf, salt, iterations, block)...)
+	}
+	return out[:keyLen]
+}
+
+func pbkdf2Block(prf hash.Hash, salt []byte, iterations int, block uint32) []byte {
+	prf.Reset()
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, block))
+	u := prf.Sum(nil)
+	t := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range t {
+			t[j] ^= u[j]
+		}
+	}
+	return t
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// encryptedEntry describes an entry for makeEncryptedZip, aes picks WinZip
+// AES-256 (AE-2) over the traditional PKWARE scheme.
+type encryptedEntry struct {
+	name     string
+	contents string
+	password string
+	aes      bool
+}
+
+func makeEncryptedZip(t *testing.T, entries ...encryptedEntry) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, e := range entries {
+		compressed := &bytes.Buffer{}
+		fw, err := flate.NewWriter(compressed, flate.DefaultCompression)
+		require.NoError(t, err)
+		fw.Write([]byte(e.contents))
+		require.NoError(t, fw.Close())
+
+		header := &zip.FileHeader{
+			Name:               e.name,
+			Method:             zip.Deflate,
+			Flags:              zipFlagEncrypted,
+			CRC32:              crc32.ChecksumIEEE([]byte(e.contents)),
+			UncompressedSize64: uint64(len(e.contents)),
+			Modified:           time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		var data []byte
+		if e.aes {
+			salt := bytes.Repeat([]byte{7}, 16)
+			keys := pbkdf2SHA1([]byte(e.password), salt, 1000, 66)
+			body, err := winzipCTR(keys[:32], compressed.Bytes())
+			require.NoError(t, err)
+			mac := hmac.New(sha1.New, keys[32:64])
+			mac.Write(body)
+
+			data = append(append(append(salt, keys[64:]...), body...), mac.Sum(nil)[:10]...)
+			header.Method = zipMethodAES
+			header.CRC32 = 0
+			extra := binary.LittleEndian.AppendUint16(nil, zipExtraAES)
+			extra = binary.LittleEndian.AppendUint16(extra, 7)
+			extra = binary.LittleEndian.AppendUint16(extra, 2)
+			extra = append(extra, 'A', 'E', 3)
+			extra = binary.LittleEndian.AppendUint16(extra, zip.Deflate)
+			header.Extra = extra
+		} else {
+			keys := newZipCryptoKeys(e.password)
+			plain := append(bytes.Repeat([]byte{0}, 11), byte(header.CRC32>>24))
+			plain = append(plain, compressed.Bytes()...)
+			for _, b := range plain {
+				t := keys[2] | 2
+				data = append(data, b^byte((t*(t^1))>>8))
+				keys.update(b)
+			}
+		}
+		header.CompressedSize64 = uint64(len(data))
+
+		fw2, err := w.CreateRaw(header)
+		require.NoError(t, err)
+		_, err = fw2.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Test_pbkdf2SHA1(t *testing.T) {
+	// RFC 6070
+	require.Equal(t, "0c60c80f961f0e71f3a9b524af6012062fe037a6", hex.EncodeToString(pbkdf2SHA1([]byte("password"), []byte("salt"), 1, 20)))
+	require.Equal(t, "4b007901b765489abead49d926f721d065a429c1", hex.EncodeToString(pbkdf2SHA1([]byte("password"), []byte("salt"), 4096, 20)))
+}
+
+func Test_openZipEntry(t *testing.T) {
+	for _, useAES := range []bool{false, true} {
+		data := makeEncryptedZip(t, encryptedEntry{name: "001.jpg", contents: "secret page", password: "hunter2", aes: useAES})
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		f := zr.File[0]
+		require.True(t, isEncrypted(f))
+
+		_, err = openZipEntry(f, "")
+		require.ErrorIs(t, err, errPasswordRequired)
+		_, err = openZipEntry(f, "wrong")
+		require.Error(t, err)
+
+		rc, err := openZipEntry(f, "hunter2")
+		require.NoError(t, err)
+		out, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, "secret page", string(out), "aes %v", useAES)
+	}
+}
+
+func Test_zipPasswords(t *testing.T) {
+	p := zipPasswords{
+		rules: []passwordRule{
+			{pattern: "/comics/locked", password: "dir"},
+			{pattern: "Saga *.cbz", password: "glob"},
+			{pattern: "/other/*.cbz", password: "full"},
+		},
+		fallback: "default",
+	}
+	require.Equal(t, "dir", p.lookup("/comics/locked/a/b.cbz"))
+	require.Equal(t, "glob", p.lookup("/comics/Saga 01.cbz"))
+	require.Equal(t, "full", p.lookup("other/x.cbz"))
+	require.Equal(t, "default", p.lookup("/comics/lockedout.cbz"))
+	require.False(t, zipPasswords{}.any())
+}
+
+func Test_reencoder_unlock(t *testing.T) {
+	fsys, err := setupFS(t, filenameBytes{
+		"comics/locked.cbz": makeEncryptedZip(t,
+			encryptedEntry{name: "001.jpg", contents: "page one", password: "pw"},
+			encryptedEntry{name: "ComicInfo.xml", contents: "<ComicInfo/>", password: "pw", aes: true},
+		),
+		"comics/open.cbz": makeZip(t, map[string]string{"001.jpg": "page"}),
+	})
+	require.NoError(t, err)
+
+	r := &reencoder{fs: fsys, logger: testLogger{t}, passwords: zipPasswords{fallback: "pw"}}
+	require.NoError(t, r.run(context.Background(), []string{"/comics"}))
+
+	zr, f, err := openZip(fsys, "comics/locked.cbz")
+	require.NoError(t, err)
+	defer f.Close()
+	for _, entry := range zr.File {
+		require.False(t, isEncrypted(entry))
+	}
+	data, err := readZipEntry(zr.File[0], "")
+	require.NoError(t, err)
+	require.Equal(t, "page one", string(data))
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/pkg/errors"
+)
+
+// openZip opens name from fsys as a zip archive. The returned file must be
+// closed once the reader is no longer needed.
+func openZip(fsys hackpadfs.FS, name string) (*zip.Reader, hackpadfs.File, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "stating zip")
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "opening zip")
+	}
+
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		file.Close()
+		return nil, nil, errors.New("filesystem doesn't support random access reads")
+	}
+
+	r, err := zip.NewReader(readerAt, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.Wrap(err, "reading zip")
+	}
+	return r, file, nil
+}
+
+// tempName returns a sibling of name to write to before renaming into place,
+// so the rename stays on the same filesystem.
+func tempName(name string) string {
+	return path.Join(path.Dir(name), fmt.Sprintf(".%s.%d.part", path.Base(name), time.Now().UnixNano()))
+}
+
+// stripZipComment removes the archive comment of the zip at name, leaving
+// the entries as they are.
+func stripZipComment(fsys hackpadfs.FS, name string) error {
+	r, f, err := openZip(fsys, name)
+	if err != nil {
+		return err
+	}
+	comment := r.Comment
+	f.Close()
+	if comment == "" {
+		return nil
+	}
+	return rewriteZip(fsys, name, func(r *zip.Reader, w *zip.Writer) error {
+		err := w.SetComment("")
+		if err != nil {
+			return err
+		}
+		for _, entry := range r.File {
+			if err := copyZipEntry(w, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rewriteZip rewrites the zip at name through fn. fn gets the existing
+// archive and a writer for the new one; everything goes to a temp file which
+// only replaces the original once it has been written completely.
+func rewriteZip(fsys hackpadfs.FS, name string, fn func(r *zip.Reader, w *zip.Writer) error) error {
+	r, src, err := openZip(fsys, name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := tempName(name)
+	err = writeZipFile(fsys, tmp, func(w *zip.Writer) error {
+		if r.Comment != "" {
+			if err := w.SetComment(r.Comment); err != nil {
+				return err
+			}
+		}
+		return fn(r, w)
+	})
+	if err != nil {
+		hackpadfs.Remove(fsys, tmp)
+		return err
+	}
+
+	err = verifyZip(fsys, tmp)
+	if err != nil {
+		hackpadfs.Remove(fsys, tmp)
+		return errors.Wrap(err, "verifying rewritten zip")
+	}
+
+	src.Close()
+	err = hackpadfs.Rename(fsys, tmp, name)
+	if err != nil {
+		hackpadfs.Remove(fsys, tmp)
+		return errors.Wrap(err, "replacing zip")
+	}
+	return nil
+}
+
+// writeZipFile creates name and writes a zip archive into it through fn.
+func writeZipFile(fsys hackpadfs.FS, name string, fn func(w *zip.Writer) error) error {
+	out, err := hackpadfs.Create(fsys, name)
+	if err != nil {
+		return errors.Wrap(err, "creating zip")
+	}
+	defer out.Close()
+
+	writer, ok := out.(io.Writer)
+	if !ok {
+		return errors.New("destination isn't a writable filesystem")
+	}
+
+	zw := zip.NewWriter(writer)
+	err = fn(zw)
+	if err != nil {
+		return err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return errors.Wrap(err, "finishing zip")
+	}
+
+	err = hackpadfs.SyncFile(out)
+	if err != nil && !errors.Is(err, hackpadfs.ErrNotImplemented) {
+		return errors.Wrap(err, "syncing zip")
+	}
+	return out.Close()
+}
+
+// copyZipEntry copies f into w without recompressing it.
+func copyZipEntry(w *zip.Writer, f *zip.File) error {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", f.Name)
+	}
+
+	header := f.FileHeader
+	header.Extra = withoutZip64Extra(header.Extra)
+	dst, err := w.CreateRaw(&header)
+	if err != nil {
+		return errors.Wrapf(err, "writing %s", f.Name)
+	}
+
+	_, err = io.Copy(dst, raw)
+	return errors.Wrapf(err, "copying %s", f.Name)
+}
+
+// zip64ExtraID is the tag of the extra field with the sizes and offset of
+// entries past 4GB.
+const zip64ExtraID = 0x0001
+
+// withoutZip64Extra is extra without its zip64 field, which has the sizes
+// and offset the entry had in the archive it was copied from. archive/zip
+// writes a new one where the copy needs it, readers going by the stale one
+// would look for the entry in the wrong place, or fail when it is missing
+// the offset the copy needs.
+func withoutZip64Extra(extra []byte) []byte {
+	out := []byte{}
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra) {
+			break
+		}
+		if tag != zip64ExtraID {
+			out = append(out, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+	return append(out, extra...)
+}
+
+// verifyZip reads every entry of the zip at name all the way through, which
+// makes archive/zip check each one against its CRC.
+func verifyZip(fsys hackpadfs.FS, name string) error {
+	r, f, err := openZip(fsys, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, entry := range r.File {
+		rc, err := entry.Open()
+		if err != nil {
+			return errors.Wrapf(err, "opening %s", entry.Name)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", entry.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sparseChunk is the size of the chunks sparseBuffer keeps.
+const sparseChunk = 1 << 16
+
+var zeroChunk = make([]byte, sparseChunk)
+
+// sparseBuffer is an in memory file that only keeps the chunks that aren't
+// all zeros, for archives past 4GB without holding 4GB.
+type sparseBuffer struct {
+	chunks map[int64][]byte
+	size   int64
+}
+
+func (b *sparseBuffer) Write(p []byte) (int, error) {
+	if b.chunks == nil {
+		b.chunks = map[int64][]byte{}
+	}
+	written := len(p)
+	for len(p) > 0 {
+		at := b.size % sparseChunk
+		part := p[:min(int64(len(p)), sparseChunk-at)]
+		chunk, ok := b.chunks[b.size/sparseChunk]
+		if !ok && !bytes.Equal(part, zeroChunk[:len(part)]) {
+			chunk = make([]byte, sparseChunk)
+			b.chunks[b.size/sparseChunk] = chunk
+		}
+		if chunk != nil {
+			copy(chunk[at:], part)
+		}
+		b.size += int64(len(part))
+		p = p[len(part):]
+	}
+	return written, nil
+}
+
+func (b *sparseBuffer) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && off < b.size {
+		at := off % sparseChunk
+		part := p[n:min(len(p), n+int(min(sparseChunk-at, b.size-off)))]
+		if chunk, ok := b.chunks[off/sparseChunk]; ok {
+			copy(part, chunk[at:])
+		} else {
+			clear(part)
+		}
+		n += len(part)
+		off += int64(len(part))
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func Test_copyZipEntry_zip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes 8GB of zeros")
+	}
+	const big = 1<<32 + 1<<20
+
+	src := &sparseBuffer{}
+	zw := zip.NewWriter(src)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "omnibus/001.jpg", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = io.Copy(w, io.LimitReader(zeroReader{}, big))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	zr, err := zip.NewReader(src, src.size)
+	require.NoError(t, err)
+	require.Equal(t, uint64(big), zr.File[0].UncompressedSize64)
+
+	// the second copy starts past 4GB, where the stale zip64 field from the
+	// source has no offset for it
+	dst := &sparseBuffer{}
+	zw = zip.NewWriter(dst)
+	require.NoError(t, copyZipEntry(zw, zr.File[0]))
+	zr.File[0].Name = "omnibus/002.jpg"
+	require.NoError(t, copyZipEntry(zw, zr.File[0]))
+	w, err = zw.Create("ComicInfo.xml")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("<ComicInfo/>"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	out, err := zip.NewReader(dst, dst.size)
+	require.NoError(t, err)
+	require.Len(t, out.File, 3)
+	require.Equal(t, uint64(big), out.File[1].UncompressedSize64)
+	rc, err := out.File[2].Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "<ComicInfo/>", string(data))
+}
+
+func Test_withoutZip64Extra(t *testing.T) {
+	extended := []byte{0x55, 0x54, 5, 0, 1, 2, 3, 4, 5}
+	zip64 := []byte{0x01, 0x00, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8}
+	extra := append(append(append([]byte{}, zip64...), extended...), 0xff)
+	require.Equal(t, append(append([]byte{}, extended...), 0xff), withoutZip64Extra(extra))
+	require.Empty(t, withoutZip64Extra(nil))
+}
@@ -0,0 +1,166 @@
+package cbr2cbz
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// chapter is a run of pages starting at start, an index into the page list.
+type chapter struct {
+	start int
+	title string
+}
+
+var chapterNamePattern = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:ch|chap|chapter|c)[ ._-]*(\d+)`)
+
+// detectChapters works out where chapters begin from the page names in
+// reading order. Internal folders win over filename patterns like "ch01".
+// Nothing is returned unless there are at least two chapters.
+func detectChapters(pages []string) []chapter {
+	chapters := chaptersFromFolders(pages)
+	if len(chapters) < 2 {
+		chapters = chaptersFromNames(pages)
+	}
+	if len(chapters) < 2 {
+		return nil
+	}
+	return chapters
+}
+
+func chaptersFromFolders(pages []string) []chapter {
+	chapters := []chapter{}
+	prefix := commonDir(pages)
+	last := ""
+	for i, page := range pages {
+		dir := strings.TrimPrefix(path.Dir(page), prefix)
+		dir = strings.Trim(dir, "/")
+		if dir == "" || dir == "." {
+			// loose pages alongside chapter folders, can't place them
+			continue
+		}
+		if dir == last {
+			continue
+		}
+		last = dir
+		chapters = append(chapters, chapter{start: i, title: path.Base(dir)})
+	}
+	return chapters
+}
+
+func chaptersFromNames(pages []string) []chapter {
+	chapters := []chapter{}
+	last := -1
+	for i, page := range pages {
+		m := chapterNamePattern.FindStringSubmatch(path.Base(page))
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n == last {
+			continue
+		}
+		last = n
+		chapters = append(chapters, chapter{start: i, title: "Chapter " + strconv.Itoa(n)})
+	}
+	return chapters
+}
+
+// commonDir returns the directory every path shares, like a single wrapper
+// folder around the whole comic.
+func commonDir(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	prefix := path.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for prefix != "." && prefix != "/" && p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			prefix = path.Dir(prefix)
+		}
+	}
+	if prefix == "." || prefix == "/" {
+		return ""
+	}
+	return prefix
+}
+
+// Chapter is the pages of one chapter folder of an archive, with the folders
+// taken off their names so they can be packed on their own.
+type Chapter struct {
+	Title string
+	Files []archiver.File
+}
+
+// Chapters splits the pages in files into one Chapter per chapter folder.
+// Fewer than two folders gives none. Entries that aren't pages don't belong
+// to any chapter and are counted in leftOut.
+func (c *Converter) Chapters(files []archiver.File) (chapters []Chapter, leftOut int) {
+	pages := c.pageIndexes(files)
+	found := chaptersFromFolders(c.entryNames(files, pages))
+	return c.chapterFiles(files, pages, found, true)
+}
+
+// ChaptersEvery splits the pages in files into chapters of n pages, the
+// last holding what is left, titled Part 01, Part 02 and so on. Pages keep
+// their names. Fewer than two chapters gives none, like Chapters.
+func (c *Converter) ChaptersEvery(files []archiver.File, n int) (chapters []Chapter, leftOut int) {
+	pages := c.pageIndexes(files)
+	found := []chapter{}
+	for start := 0; n > 0 && start < len(pages); start += n {
+		found = append(found, chapter{start: start})
+	}
+	return c.chapterFiles(files, pages, partTitles(found), false)
+}
+
+// ChaptersAt starts a chapter at every page whose name in the archive
+// matches pattern, like the covers of the chapters of an omnibus, the pages
+// before the first going into one of their own. They are titled like
+// ChaptersEvery's.
+func (c *Converter) ChaptersAt(files []archiver.File, pattern *regexp.Regexp) (chapters []Chapter, leftOut int) {
+	pages := c.pageIndexes(files)
+	found := []chapter{}
+	for i, name := range c.entryNames(files, pages) {
+		if i == 0 || pattern.MatchString(name) {
+			found = append(found, chapter{start: i})
+		}
+	}
+	return c.chapterFiles(files, pages, partTitles(found), false)
+}
+
+// partTitles numbers found Part 01, Part 02 and so on.
+func partTitles(found []chapter) []chapter {
+	digits := max(len(strconv.Itoa(len(found))), 2)
+	for i := range found {
+		found[i].title = fmt.Sprintf("Part %0*d", digits, i+1)
+	}
+	return found
+}
+
+// chapterFiles gives each of found the pages from its start to the next
+// one's, taking the folders off their names with stripFolders.
+func (c *Converter) chapterFiles(files []archiver.File, pages []int, found []chapter, stripFolders bool) (chapters []Chapter, leftOut int) {
+	if len(found) < 2 {
+		return nil, 0
+	}
+
+	for i, ch := range found {
+		end := len(pages)
+		if i+1 < len(found) {
+			end = found[i+1].start
+		}
+		chapter := Chapter{Title: ch.title}
+		for _, idx := range pages[ch.start:end] {
+			f := files[idx]
+			if stripFolders {
+				f.NameInArchive = path.Base(f.NameInArchive)
+			}
+			chapter.Files = append(chapter.Files, f)
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, len(files) - len(pages)
+}
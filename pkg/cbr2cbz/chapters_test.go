@@ -0,0 +1,57 @@
+package cbr2cbz
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// chapterNames lists the pages of each chapter by name.
+func chapterNames(chapters []Chapter) map[string][]string {
+	names := map[string][]string{}
+	for _, ch := range chapters {
+		for _, f := range ch.Files {
+			names[ch.Title] = append(names[ch.Title], f.NameInArchive)
+		}
+	}
+	return names
+}
+
+func Test_ChaptersEvery(t *testing.T) {
+	files := []archiver.File{}
+	for _, name := range []string{"a/001.jpg", "a/002.jpg", "ComicInfo.xml", "b/003.jpg", "b/004.jpg", "b/005.jpg"} {
+		files = append(files, virtualFile(name, nil))
+	}
+	c := newTestConverter(t, Options{})
+
+	chapters, leftOut := c.ChaptersEvery(files, 2)
+	require.Equal(t, 1, leftOut)
+	require.Equal(t, map[string][]string{
+		"Part 01": {"a/001.jpg", "a/002.jpg"},
+		"Part 02": {"b/003.jpg", "b/004.jpg"},
+		"Part 03": {"b/005.jpg"},
+	}, chapterNames(chapters))
+
+	chapters, _ = c.ChaptersEvery(files, 5)
+	require.Empty(t, chapters)
+}
+
+func Test_ChaptersAt(t *testing.T) {
+	files := []archiver.File{}
+	for _, name := range []string{"credits.jpg", "ch01_cover.jpg", "ch01_p1.jpg", "ch02_cover.jpg", "ch02_p1.jpg"} {
+		files = append(files, virtualFile(name, nil))
+	}
+	c := newTestConverter(t, Options{})
+
+	chapters, _ := c.ChaptersAt(files, regexp.MustCompile(`cover`))
+	require.Equal(t, map[string][]string{
+		"Part 01": {"credits.jpg"},
+		"Part 02": {"ch01_cover.jpg", "ch01_p1.jpg"},
+		"Part 03": {"ch02_cover.jpg", "ch02_p1.jpg"},
+	}, chapterNames(chapters))
+
+	chapters, _ = c.ChaptersAt(files, regexp.MustCompile(`nothing`))
+	require.Empty(t, chapters)
+}
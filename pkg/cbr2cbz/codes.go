@@ -0,0 +1,118 @@
+package cbr2cbz
+
+import "github.com/pkg/errors"
+
+// Codes tag warnings and errors in logs, events and reports so automation
+// can filter on them. They never change meaning once released; new
+// conditions get new codes.
+//
+//	W001 junk-removed           OS leftover or empty file left out
+//	W002 entry-dropped          entry isn't an image or kept file
+//	W003 pdf-page-empty         pdf page had no images
+//	W004 entry-unreadable       entry couldn't be read and was skipped
+//	W005 placeholder-inserted   unreadable page replaced by a placeholder
+//	W006 nested-dropped         nested archive couldn't be read and was skipped
+//	W007 duplicate-page         page the same as an earlier one left out
+//	W008 names-decoded          entry names weren't UTF-8 and were transcoded
+//	W009 path-sanitized         unsafe entry path rewritten, like ../ or C:
+//	W010 chapters-not-found     --split found no chapter folders
+//	W011 chapter-extra-entries  non-page entries left out of chapters
+//	W014 entry-renamed          flattened name was taken, numbered instead
+//	W015 rename-skipped         padded, renumbered or re-encoded name was taken
+//	W016 output-numbered        --rename or --on-exists rename name was taken, numbered
+//	W017 output-replaced        the cbz already existed and was overwritten
+//	W018 output-skipped         the cbz already exists, --on-exists skip kept it
+//	W020 concurrency-reduced    IO errors lowered the number of jobs
+//	W021 jobs-capped            open file limit lowered the number of jobs
+//	W022 retrying               transient error, trying the file again
+//	W030 prefetch-failed        reading a file ahead of time failed
+//	W031 partial-not-removed    couldn't remove a half written cbz
+//	W032 series-json-failed     couldn't write series.json
+//	W033 source-read-only       original can't be removed, kept it
+//	W034 receipt-failed         couldn't write a .converted.json receipt
+//	W035 attributes-not-copied  couldn't copy the original's time and permissions
+//	W036 comment-dropped        the archive comment couldn't be carried over
+//	W037 external-unrar         rar couldn't be read, trying --external-unrar
+//	W038 thumbnail-failed       couldn't write the thumbnail of a cbz
+//	W039 checksums-failed       couldn't hash the pages of a cbz or its source
+//	W040 watch-error            the file watcher reported a problem
+//	W041 quarantine-failed      couldn't move a failed file into --quarantine-dir
+//	W042 post-hook-failed       --post-hook exited with an error
+//	W043 comic-info-ignored     a merged issue's ComicInfo.xml couldn't be read
+//	W044 wrapper-kept           taking out the shared folder would make names clash
+//	W050 claimed-elsewhere      another instance is converting the file
+//
+//	E100 unknown                anything without its own code
+//	E101 not-an-archive         source isn't a rar, 7z, tar or pdf
+//	E102 crc-mismatch           an entry failed its checksum
+//	E103 decompression-limit    archive exceeds decompression limits
+//	E104 stalled                no data read or written for too long
+//	E105 out-of-fds             ran out of open files
+//	E106 io-error               timeout, network or disk error
+//	E107 password               encrypted and no or the wrong password
+//	E108 canceled               interrupted or timed out
+//	E109 qa-failed              a --qa-sample check found a bad cbz
+//	E110 crashed                reading the archive panicked, see report-crash
+//	E111 read-only-source       source is read-only and there is no --output-dir
+//	E112 pages-mismatch         the cbz read back doesn't hold the original's pages
+//	E113 output-exists          the cbz already exists and --on-exists is error
+//	E114 file-timeout           the file took longer than --file-timeout
+//	E115 pre-hook-failed        --pre-hook exited with an error, the file wasn't converted
+const (
+	CodeJunkRemoved         = "W001"
+	CodeEntryDropped        = "W002"
+	CodePDFPageEmpty        = "W003"
+	CodeEntryUnreadable     = "W004"
+	CodePlaceholder         = "W005"
+	CodeNestedDropped       = "W006"
+	CodeDuplicatePage       = "W007"
+	CodeNamesDecoded        = "W008"
+	CodePathSanitized       = "W009"
+	CodeChaptersNotFound    = "W010"
+	CodeChapterExtraEntries = "W011"
+	CodeEntryRenamed        = "W014"
+	CodeRenameSkipped       = "W015"
+	CodeOutputNumbered      = "W016"
+	CodeOutputReplaced      = "W017"
+	CodeOutputSkipped       = "W018"
+	CodeConcurrencyReduced  = "W020"
+	CodeJobsCapped          = "W021"
+	CodeRetrying            = "W022"
+	CodePrefetchFailed      = "W030"
+	CodePartialNotRemoved   = "W031"
+	CodeSeriesJSONFailed    = "W032"
+	CodeSourceReadOnly      = "W033"
+	CodeReceiptFailed       = "W034"
+	CodeAttributesNotCopied = "W035"
+	CodeCommentDropped      = "W036"
+	CodeExternalUnrar       = "W037"
+	CodeThumbnailFailed     = "W038"
+	CodeChecksumsFailed     = "W039"
+	CodeWatchError          = "W040"
+	CodeQuarantineFailed    = "W041"
+	CodePostHookFailed      = "W042"
+	CodeComicInfoIgnored    = "W043"
+	CodeWrapperKept         = "W044"
+	CodeClaimed             = "W050"
+
+	CodeUnknown            = "E100"
+	CodeNotArchive         = "E101"
+	CodeCRCMismatch        = "E102"
+	CodeDecompressionLimit = "E103"
+	CodeStalled            = "E104"
+	CodeOutOfFiles         = "E105"
+	CodeIOError            = "E106"
+	CodePassword           = "E107"
+	CodeCanceled           = "E108"
+	CodeQAFailed           = "E109"
+	CodeCrashed            = "E110"
+	CodeReadOnlySource     = "E111"
+	CodePagesMismatch      = "E112"
+	CodeOutputExists       = "E113"
+	CodeFileTimeout        = "E114"
+	CodePreHookFailed      = "E115"
+)
+
+// ErrNotArchive is returned for sources that aren't anything a Converter
+// can repack.
+var ErrNotArchive = errors.New("not a rar, 7z, tar or pdf file")
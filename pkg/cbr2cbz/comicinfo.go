@@ -0,0 +1,254 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+const ComicInfoName = "ComicInfo.xml"
+
+// ComicInfo is the subset of the ComicRack ComicInfo.xml schema that we read
+// and write. Elements we don't know about are kept in Extra so rewriting an
+// existing file doesn't lose anything.
+type ComicInfo struct {
+	XMLName         xml.Name     `xml:"ComicInfo"`
+	Title           string       `xml:"Title,omitempty"`
+	Series          string       `xml:"Series,omitempty"`
+	Number          string       `xml:"Number,omitempty"`
+	Count           int          `xml:"Count,omitempty"`
+	Volume          int          `xml:"Volume,omitempty"`
+	Summary         string       `xml:"Summary,omitempty"`
+	Year            int          `xml:"Year,omitempty"`
+	Month           int          `xml:"Month,omitempty"`
+	Day             int          `xml:"Day,omitempty"`
+	Writer          string       `xml:"Writer,omitempty"`
+	Penciller       string       `xml:"Penciller,omitempty"`
+	Inker           string       `xml:"Inker,omitempty"`
+	Colorist        string       `xml:"Colorist,omitempty"`
+	Letterer        string       `xml:"Letterer,omitempty"`
+	CoverArtist     string       `xml:"CoverArtist,omitempty"`
+	Editor          string       `xml:"Editor,omitempty"`
+	Publisher       string       `xml:"Publisher,omitempty"`
+	Genre           string       `xml:"Genre,omitempty"`
+	Web             string       `xml:"Web,omitempty"`
+	PageCount       int          `xml:"PageCount,omitempty"`
+	LanguageISO     string       `xml:"LanguageISO,omitempty"`
+	Manga           string       `xml:"Manga,omitempty"`
+	ScanInformation string       `xml:"ScanInformation,omitempty"`
+	Extra           []xmlElement `xml:",any"`
+	Pages           *ComicPages  `xml:"Pages,omitempty"`
+}
+
+// ComicPages wraps the page list so an empty list can be left out entirely.
+type ComicPages struct {
+	Page []ComicPage `xml:"Page"`
+}
+
+// ComicPage describes a single page. Image is the index of the page within
+// the archive, in reading order.
+type ComicPage struct {
+	Image       int    `xml:"Image,attr"`
+	Type        string `xml:"Type,attr,omitempty"`
+	DoublePage  bool   `xml:"DoublePage,attr,omitempty"`
+	ImageSize   int64  `xml:"ImageSize,attr,omitempty"`
+	Key         string `xml:"Key,attr,omitempty"`
+	Bookmark    string `xml:"Bookmark,attr,omitempty"`
+	ImageWidth  int    `xml:"ImageWidth,attr,omitempty"`
+	ImageHeight int    `xml:"ImageHeight,attr,omitempty"`
+}
+
+// xmlElement holds an element we don't model, verbatim.
+type xmlElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+func ParseComicInfo(r io.Reader) (*ComicInfo, error) {
+	info := &ComicInfo{}
+	err := xml.NewDecoder(r).Decode(info)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing ComicInfo.xml")
+	}
+	return info, nil
+}
+
+func (ci *ComicInfo) Marshal() ([]byte, error) {
+	out, err := xml.MarshalIndent(ci, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "writing ComicInfo.xml")
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// page returns the entry for the page at index, adding one if needed.
+func (ci *ComicInfo) page(index int) *ComicPage {
+	if ci.Pages == nil {
+		ci.Pages = &ComicPages{}
+	}
+	for i := range ci.Pages.Page {
+		if ci.Pages.Page[i].Image == index {
+			return &ci.Pages.Page[i]
+		}
+	}
+	ci.Pages.Page = append(ci.Pages.Page, ComicPage{Image: index})
+	return &ci.Pages.Page[len(ci.Pages.Page)-1]
+}
+
+func IsComicInfo(name string) bool {
+	return strings.EqualFold(path.Base(name), ComicInfoName)
+}
+
+// virtualFileInfo describes an entry we generate rather than copy from the
+// source archive.
+type virtualFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i virtualFileInfo) Name() string       { return path.Base(i.name) }
+func (i virtualFileInfo) Size() int64        { return i.size }
+func (i virtualFileInfo) Mode() fs.FileMode  { return 0644 }
+func (i virtualFileInfo) ModTime() time.Time { return i.modTime }
+func (i virtualFileInfo) IsDir() bool        { return false }
+func (i virtualFileInfo) Sys() any           { return nil }
+
+func virtualFile(name string, data []byte) archiver.File {
+	return datedVirtualFile(name, data, time.Now())
+}
+
+// datedVirtualFile is a virtualFile modified at modTime, or now if that's
+// zero.
+func datedVirtualFile(name string, data []byte, modTime time.Time) archiver.File {
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return archiver.File{
+		FileInfo:      virtualFileInfo{name: name, size: int64(len(data)), modTime: modTime},
+		NameInArchive: name,
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}
+}
+
+// pageIndexes returns the positions in files of the entries that are pages,
+// which is the order ComicInfo Image indexes refer to.
+func (c *Converter) pageIndexes(files []archiver.File) []int {
+	pages := []int{}
+	for i, f := range files {
+		if c.entries.Classify(f.NameInArchive) == EntryPage {
+			pages = append(pages, i)
+		}
+	}
+	return pages
+}
+
+func (c *Converter) entryNames(files []archiver.File, indexes []int) []string {
+	names := make([]string, len(indexes))
+	for i, idx := range indexes {
+		names[i] = files[idx].NameInArchive
+	}
+	return names
+}
+
+// updateComicInfo applies whatever ComicInfo.xml changes are enabled to the
+// archive's ComicInfo.xml, creating one if there wasn't already one.
+func (c *Converter) updateComicInfo(cbrFile string, files []archiver.File) ([]archiver.File, error) {
+	if !c.opts.Bookmarks && !c.opts.MarkCover && !c.opts.GenerateInfo {
+		return files, nil
+	}
+
+	var (
+		info     *ComicInfo
+		existing = -1
+	)
+	for i, f := range files {
+		if !IsComicInfo(f.NameInArchive) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrap(err, "opening ComicInfo.xml")
+		}
+		info, err = ParseComicInfo(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		existing = i
+		break
+	}
+	pages := c.pageIndexes(files)
+	names := c.entryNames(files, pages)
+
+	changed := false
+	if info == nil && c.opts.GenerateInfo {
+		info = ComicInfoFromFilename(cbrFile)
+		info.PageCount = len(pages)
+		for i, idx := range pages {
+			info.page(i).ImageSize = files[idx].Size()
+		}
+		changed = true
+	}
+	if info == nil {
+		info = &ComicInfo{}
+	}
+
+	if c.opts.Bookmarks {
+		for _, ch := range detectChapters(names) {
+			info.page(ch.start).Bookmark = ch.title
+			changed = true
+		}
+	}
+	if c.opts.MarkCover {
+		if cover := findCover(names); cover >= 0 {
+			info.page(cover).Type = "FrontCover"
+			changed = true
+		}
+	}
+
+	if !changed {
+		return files, nil
+	}
+
+	data, err := info.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// dated like the one it replaces, or the newest entry, so converting
+	// the same archive again gives the same zip
+	modTime := newestModTime(files)
+	if existing >= 0 {
+		modTime = files[existing].ModTime()
+	}
+	out := make([]archiver.File, 0, len(files)+1)
+	for i, f := range files {
+		if i != existing {
+			out = append(out, f)
+		}
+	}
+	return append(out, datedVirtualFile(ComicInfoName, data, modTime)), nil
+}
+
+// newestModTime is the latest modification time of files, zero if there
+// are none.
+func newestModTime(files []archiver.File) time.Time {
+	var newest time.Time
+	for _, f := range files {
+		if f.ModTime().After(newest) {
+			newest = f.ModTime()
+		}
+	}
+	return newest
+}
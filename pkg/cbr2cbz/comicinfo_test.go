@@ -0,0 +1,112 @@
+package cbr2cbz
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_detectChapters(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []string
+		want  []chapter
+	}{
+		{
+			name:  "folders under a wrapper",
+			pages: []string{"Vol 1/Ch 1/01.jpg", "Vol 1/Ch 1/02.jpg", "Vol 1/Ch 2/01.jpg"},
+			want:  []chapter{{start: 0, title: "Ch 1"}, {start: 2, title: "Ch 2"}},
+		},
+		{
+			name:  "filename pattern",
+			pages: []string{"saga_c001_p01.jpg", "saga_c001_p02.jpg", "saga_c002_p01.jpg"},
+			want:  []chapter{{start: 0, title: "Chapter 1"}, {start: 2, title: "Chapter 2"}},
+		},
+		{
+			name:  "single chapter",
+			pages: []string{"Comic/01.jpg", "Comic/02.jpg"},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, detectChapters(tt.pages))
+		})
+	}
+}
+
+func Test_ComicInfo_roundtrip(t *testing.T) {
+	src := `<?xml version="1.0"?>
+<ComicInfo>
+  <Series>Saga</Series>
+  <Colorist>Fiona Staples</Colorist>
+</ComicInfo>`
+
+	info, err := ParseComicInfo(strings.NewReader(src))
+	require.NoError(t, err)
+	require.Equal(t, "Saga", info.Series)
+
+	info.page(3).Bookmark = "Chapter 2"
+	out, err := info.Marshal()
+	require.NoError(t, err)
+	require.Contains(t, string(out), "<Colorist>Fiona Staples</Colorist>")
+	require.Contains(t, string(out), `<Page Image="3" Bookmark="Chapter 2"></Page>`)
+}
+
+func Test_findCover(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []string
+		want  int
+	}{
+		{name: "named cover", pages: []string{"01.jpg", "02.jpg", "Cover.jpg"}, want: 2},
+		{name: "folder art", pages: []string{"01.jpg", "folder.jpg"}, want: 1},
+		{name: "zero page", pages: []string{"001.jpg", "000.jpg"}, want: 1},
+		{name: "first page", pages: []string{"001.jpg", "002.jpg"}, want: 0},
+		{name: "no pages", pages: nil, want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, findCover(tt.pages))
+		})
+	}
+	require.Equal(t, "Cover.jpg", CoverPage([]string{"01.jpg", "Cover.jpg"}))
+	require.Equal(t, "", CoverPage(nil))
+}
+
+func Test_updateComicInfo_generate(t *testing.T) {
+	c := newTestConverter(t, Options{GenerateInfo: true})
+	scanned := time.Date(2013, 4, 1, 12, 0, 0, 0, time.UTC)
+	files := []archiver.File{
+		datedVirtualFile("Saga 014/01.jpg", []byte("one"), scanned.Add(-time.Hour)),
+		datedVirtualFile("Saga 014/notes.nfo", []byte("kept"), scanned),
+		datedVirtualFile("Saga 014/02.jpg", []byte("second"), scanned.Add(-time.Minute)),
+	}
+
+	out, err := c.updateComicInfo("/comics/Saga v02 014 (2013).cbr", files)
+	require.NoError(t, err)
+	require.Len(t, out, 4)
+	require.Equal(t, ComicInfoName, out[3].NameInArchive)
+	require.Equal(t, scanned, out[3].ModTime(), "dated like the newest entry")
+
+	rc, err := out[3].Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	info, err := ParseComicInfo(rc)
+	require.NoError(t, err)
+	require.Equal(t, "Saga", info.Series)
+	require.Equal(t, "14", info.Number)
+	require.Equal(t, 2, info.Volume)
+	require.Equal(t, 2013, info.Year)
+	require.Equal(t, 2, info.PageCount)
+	require.Equal(t, []ComicPage{{Image: 0, ImageSize: 3}, {Image: 1, ImageSize: 6}}, info.Pages.Page)
+
+	// an existing ComicInfo.xml is left alone
+	existing := append(files, virtualFile(ComicInfoName, []byte("<ComicInfo><Series>Real</Series></ComicInfo>")))
+	out, err = c.updateComicInfo("/comics/Saga 014.cbr", existing)
+	require.NoError(t, err)
+	require.Equal(t, existing, out)
+}
@@ -0,0 +1,225 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+
+	// errCommentPacked is for comments stored compressed, which the rar
+	// decoder has no way to unpack on their own
+	errCommentPacked = errors.New("the comment is compressed")
+)
+
+// maxComment is the longest comment a zip can hold.
+const maxComment = 0xffff
+
+// Comment is the archive comment of the rar or zip in src, often where the
+// release group or scanner left their notes, to be carried over into the
+// cbz. It is empty when there is none or Options.StripComments is set. A
+// comment that can't be read is left out with a warning.
+func (c *Converter) Comment(name string, src io.ReaderAt, size int64) string {
+	if c.opts.StripComments {
+		return ""
+	}
+	var comment string
+	var err error
+	header := make([]byte, len(rar5Signature))
+	n, _ := src.ReadAt(header, 0)
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, rar5Signature):
+		comment, err = rar5Comment(src, size)
+	case bytes.HasPrefix(header, rar4Signature):
+		comment, err = rar4Comment(src, size)
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		if zr, zipErr := zip.NewReader(src, size); zipErr == nil {
+			comment = zr.Comment
+		}
+	}
+	if err == nil && len(comment) > maxComment {
+		err = errors.Errorf("it is %d bytes, more than a zip can hold", len(comment))
+	}
+	if err != nil {
+		c.warn(CodeCommentDropped, name, "Leaving the comment of %s out: %s", name, err)
+		return ""
+	}
+	return comment
+}
+
+// rar5Comment reads the CMT service header rar 5 archives keep their
+// comment in, which comes before the first file.
+func rar5Comment(src io.ReaderAt, size int64) (string, error) {
+	r := io.NewSectionReader(src, 0, size)
+	off := int64(len(rar5Signature))
+	for {
+		// header CRC, then the size of the rest of the header
+		r.Seek(off+4, io.SeekStart)
+		br := byteReader{r}
+		headerSize, err := binary.ReadUvarint(br)
+		if err != nil {
+			return "", nil
+		}
+		fieldsAt, _ := r.Seek(0, io.SeekCurrent)
+		fields := make([]byte, headerSize)
+		if _, err := io.ReadFull(r, fields); err != nil {
+			return "", nil
+		}
+		h := bytes.NewReader(fields)
+		kind, _ := binary.ReadUvarint(h)
+		flags, _ := binary.ReadUvarint(h)
+		if flags&0x01 != 0 {
+			// extra area size
+			binary.ReadUvarint(h)
+		}
+		var dataSize uint64
+		if flags&0x02 != 0 {
+			dataSize, _ = binary.ReadUvarint(h)
+		}
+		dataAt := fieldsAt + int64(headerSize)
+
+		switch kind {
+		case 2, 4, 5:
+			// a file, encrypted headers or the end: no comment
+			return "", nil
+		case 3:
+			fileFlags, _ := binary.ReadUvarint(h)
+			binary.ReadUvarint(h) // unpacked size
+			binary.ReadUvarint(h) // attributes
+			if fileFlags&0x02 != 0 {
+				h.Seek(4, io.SeekCurrent) // modification time
+			}
+			if fileFlags&0x04 != 0 {
+				h.Seek(4, io.SeekCurrent) // data CRC
+			}
+			compression, _ := binary.ReadUvarint(h)
+			binary.ReadUvarint(h) // host OS
+			nameSize, _ := binary.ReadUvarint(h)
+			name := make([]byte, nameSize)
+			if _, err := io.ReadFull(h, name); err != nil || string(name) != "CMT" {
+				break
+			}
+			if (compression>>7)&0x07 != 0 {
+				return "", errCommentPacked
+			}
+			return readComment(src, dataAt, dataSize)
+		}
+		off = dataAt + int64(dataSize)
+	}
+}
+
+// rar4Comment reads the CMT sub block of rar 3 and 4 archives, which comes
+// before the first file, or the comment rar 2 archives embed in their main
+// header.
+func rar4Comment(src io.ReaderAt, size int64) (string, error) {
+	const (
+		blockMain    = 0x73
+		blockFile    = 0x74
+		blockComment = 0x75
+		blockService = 0x7a
+		blockEnd     = 0x7b
+
+		mainComment   = 0x0002
+		mainEncrypted = 0x0080
+		hasAddSize    = 0x8000
+		largeFile     = 0x0100
+		methodStore   = 0x30
+	)
+	off := int64(len(rar4Signature))
+	for off+7 <= size {
+		base := make([]byte, 7)
+		if _, err := src.ReadAt(base, off); err != nil {
+			return "", nil
+		}
+		kind := base[2]
+		flags := binary.LittleEndian.Uint16(base[3:])
+		headerSize := int64(binary.LittleEndian.Uint16(base[5:]))
+		if headerSize < 7 {
+			return "", nil
+		}
+		header := make([]byte, headerSize)
+		if _, err := src.ReadAt(header, off); err != nil {
+			return "", nil
+		}
+		var addSize int64
+		if (flags&hasAddSize != 0 || kind == blockService) && headerSize >= 11 {
+			addSize = int64(binary.LittleEndian.Uint32(header[7:]))
+		}
+
+		switch kind {
+		case blockMain:
+			if flags&mainEncrypted != 0 {
+				return "", nil
+			}
+			// after the 13 bytes of the main header: the usual 7, then
+			// unpacked size, version, method and CRC, then the comment
+			comm := make([]byte, 13)
+			if flags&mainComment == 0 || headerSize < 26 {
+				break
+			}
+			if _, err := src.ReadAt(comm, off+13); err != nil || comm[2] != blockComment {
+				break
+			}
+			commSize := int64(binary.LittleEndian.Uint16(comm[5:]))
+			if comm[10] != methodStore {
+				return "", errCommentPacked
+			}
+			if commSize < 13 {
+				return "", nil
+			}
+			return readComment(src, off+26, uint64(commSize-13))
+		case blockFile, blockEnd:
+			return "", nil
+		case blockService:
+			// pack size, unpacked size, host OS, CRC, time, version, method,
+			// name size and attributes, then the high halves of the sizes
+			nameAt := int64(32)
+			if flags&largeFile != 0 {
+				nameAt += 8
+			}
+			if headerSize < nameAt {
+				return "", nil
+			}
+			nameSize := int64(binary.LittleEndian.Uint16(header[26:]))
+			if nameAt+nameSize > headerSize || string(header[nameAt:nameAt+nameSize]) != "CMT" {
+				break
+			}
+			if header[25] != methodStore {
+				return "", errCommentPacked
+			}
+			return readComment(src, off+headerSize, uint64(addSize))
+		}
+		off += headerSize + addSize
+	}
+	return "", nil
+}
+
+func readComment(src io.ReaderAt, off int64, size uint64) (string, error) {
+	if size > maxComment {
+		return "", errors.Errorf("it is %d bytes, more than a zip can hold", size)
+	}
+	data := make([]byte, size)
+	if _, err := src.ReadAt(data, off); err != nil {
+		return "", errors.Wrap(err, "reading it")
+	}
+	return strings.TrimRight(string(data), "\x00"), nil
+}
+
+// byteReader reads a byte at a time, for binary.ReadUvarint.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	b := make([]byte, 1)
+	_, err := io.ReadFull(r.Reader, b)
+	return b[0], err
+}
@@ -0,0 +1,149 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	memfs "github.com/hack-pad/hackpadfs/mem"
+	"github.com/stretchr/testify/require"
+)
+
+// rar5Block is a rar 5 header of kind with fields, followed by data.
+func rar5Block(kind uint64, fields []byte, data []byte) []byte {
+	body := binary.AppendUvarint(nil, kind)
+	body = binary.AppendUvarint(body, 0x02)
+	body = binary.AppendUvarint(body, uint64(len(data)))
+	body = append(body, fields...)
+	header := binary.AppendUvarint(nil, uint64(len(body)))
+	header = append(header, body...)
+	block := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(header))
+	return append(append(block, header...), data...)
+}
+
+// rar5CommentBlock is the CMT service header holding comment, packed with
+// method.
+func rar5CommentBlock(comment string, method uint64) []byte {
+	fields := binary.AppendUvarint(nil, 0x04)
+	fields = binary.AppendUvarint(fields, uint64(len(comment)))
+	fields = binary.AppendUvarint(fields, 0)
+	fields = binary.LittleEndian.AppendUint32(fields, crc32.ChecksumIEEE([]byte(comment)))
+	fields = binary.AppendUvarint(fields, method<<7)
+	fields = binary.AppendUvarint(fields, 0)
+	fields = binary.AppendUvarint(fields, 3)
+	fields = append(fields, "CMT"...)
+	return rar5Block(3, fields, []byte(comment))
+}
+
+// rar4Block is a rar 1.5 to 4 block of kind with fields, followed by data.
+func rar4Block(kind byte, flags uint16, fields []byte, data []byte) []byte {
+	header := []byte{kind}
+	header = binary.LittleEndian.AppendUint16(header, flags)
+	header = binary.LittleEndian.AppendUint16(header, uint16(7+len(fields)))
+	header = append(header, fields...)
+	block := binary.LittleEndian.AppendUint16(nil, uint16(crc32.ChecksumIEEE(header)))
+	return append(append(block, header...), data...)
+}
+
+func rar4CommentBlock(comment string, method byte) []byte {
+	fields := binary.LittleEndian.AppendUint32(nil, uint32(len(comment)))
+	fields = binary.LittleEndian.AppendUint32(fields, uint32(len(comment)))
+	fields = append(fields, 0)
+	fields = binary.LittleEndian.AppendUint32(fields, crc32.ChecksumIEEE([]byte(comment)))
+	fields = binary.LittleEndian.AppendUint32(fields, 0)
+	fields = append(fields, 29, method)
+	fields = binary.LittleEndian.AppendUint16(fields, 3)
+	fields = binary.LittleEndian.AppendUint32(fields, 0)
+	fields = append(fields, "CMT"...)
+	return rar4Block(0x7a, 0x8000, fields, []byte(comment))
+}
+
+func rar4Archive(blocks ...[]byte) []byte {
+	archive := append([]byte{}, rar4Signature...)
+	for _, b := range blocks {
+		archive = append(archive, b...)
+	}
+	return append(archive, rar4Block(0x7b, 0, nil, nil)...)
+}
+
+// withComment is the rar 5 fixture with a CMT header after its main header.
+func withComment(t *testing.T, comment string, method uint64) []byte {
+	fixture := readFixture(t, "test.cbr")
+	// signature, CRC, size and the 10 bytes of the main header
+	mainEnd := len(rar5Signature) + 4 + 1 + 10
+	out := append([]byte{}, fixture[:mainEnd]...)
+	out = append(out, rar5CommentBlock(comment, method)...)
+	return append(out, fixture[mainEnd:]...)
+}
+
+func Test_Converter_Comment(t *testing.T) {
+	const notes = "Scanned by the TestGroup\r\nEnjoy!"
+	zipped := &bytes.Buffer{}
+	zw := zip.NewWriter(zipped)
+	require.NoError(t, zw.SetComment(notes))
+	_, err := zw.Create("01.jpg")
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	oldMain := make([]byte, 6)
+	commHead := []byte{0x75}
+	commHead = binary.LittleEndian.AppendUint16(commHead, 0)
+	commHead = binary.LittleEndian.AppendUint16(commHead, uint16(13+len(notes)))
+	commHead = binary.LittleEndian.AppendUint16(commHead, uint16(len(notes)))
+	commHead = append(commHead, 20, 0x30, 0, 0)
+	oldMain = append(oldMain, 0, 0)
+	oldMain = append(oldMain, commHead...)
+	oldMain = append(oldMain, notes...)
+
+	tests := []struct {
+		name        string
+		archive     []byte
+		opts        Options
+		want        string
+		wantWarning bool
+	}{
+		{name: "rar 5", archive: withComment(t, notes, 0), want: notes},
+		{name: "rar 5 compressed", archive: withComment(t, notes, 3), wantWarning: true},
+		{name: "rar 5 stripped", archive: withComment(t, notes, 0), opts: Options{StripComments: true}},
+		{name: "rar 5 without", archive: readFixture(t, "test.cbr")},
+		{name: "rar 4", archive: rar4Archive(rar4Block(0x73, 0, make([]byte, 6), nil), rar4CommentBlock(notes, 0x30)), want: notes},
+		{name: "rar 4 compressed", archive: rar4Archive(rar4Block(0x73, 0, make([]byte, 6), nil), rar4CommentBlock(notes, 0x33)), wantWarning: true},
+		{name: "rar 2 in the main header", archive: rar4Archive(rar4Block(0x73, 0x0002, oldMain, nil)), want: notes},
+		{name: "zip", archive: zipped.Bytes(), want: notes},
+		{name: "tar", archive: readFixture(t, "test.cbt")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConverter(t, tt.opts)
+			var warnings []Warning
+			c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+			require.Equal(t, tt.want, c.Comment("test.cbr", bytes.NewReader(tt.archive), int64(len(tt.archive))))
+			if tt.wantWarning {
+				require.Len(t, warnings, 1)
+				require.Equal(t, CodeCommentDropped, warnings[0].Code)
+			} else {
+				require.Empty(t, warnings)
+			}
+		})
+	}
+}
+
+func Test_Converter_Convert_comment(t *testing.T) {
+	fsys, err := memfs.NewFS()
+	require.NoError(t, err)
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "test.cbr", withComment(t, "Scanned by the TestGroup", 0), 0o644))
+
+	c := newTestConverter(t, Options{KeepFiles: []string{"*.txt"}})
+	res, err := c.Convert(context.Background(), fsys, fsys, "test.cbr")
+	require.NoError(t, err)
+	data, err := hackpadfs.ReadFile(fsys, res.Output)
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Equal(t, "Scanned by the TestGroup", zr.Comment)
+	require.Len(t, zr.File, res.Entries)
+}
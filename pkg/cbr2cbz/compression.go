@@ -0,0 +1,37 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+)
+
+// Compressions are the values --compression accepts. store packs entries as
+// they are, which is quickest and what most comic tools do, the pages being
+// compressed already. The others deflate at the speed or size they say. An
+// empty compression means default.
+var Compressions = map[string]bool{"store": true, "fastest": true, "default": true, "best": true}
+
+// compressionLevels are the deflate levels of the compressions that don't
+// use the default one.
+var compressionLevels = map[string]int{"fastest": flate.BestSpeed, "best": flate.BestCompression}
+
+// newZipWriter is a zip.Writer to dst that deflates at the level named by
+// compression.
+func newZipWriter(dst io.Writer, compression string) *zip.Writer {
+	zw := zip.NewWriter(dst)
+	if level, ok := compressionLevels[compression]; ok {
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	return zw
+}
+
+// zipMethod is the method files are written with under compression.
+func zipMethod(compression string) uint16 {
+	if compression == "store" {
+		return zip.Store
+	}
+	return zip.Deflate
+}
@@ -0,0 +1,54 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hack-pad/hackpadfs"
+	memfs "github.com/hack-pad/hackpadfs/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Converter_Convert_compression(t *testing.T) {
+	tests := []struct {
+		compression string
+		method      uint16
+	}{
+		{compression: "", method: zip.Deflate},
+		{compression: "store", method: zip.Store},
+		{compression: "fastest", method: zip.Deflate},
+		{compression: "best", method: zip.Deflate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.compression, func(t *testing.T) {
+			fsys, err := memfs.NewFS()
+			require.NoError(t, err)
+			require.NoError(t, hackpadfs.WriteFullFile(fsys, "test.cbr", readFixture(t, "test.cbr"), 0o644))
+
+			c := newTestConverter(t, Options{KeepFiles: []string{"*.txt"}, Compression: tt.compression})
+			res, err := c.Convert(context.Background(), fsys, fsys, "test.cbr")
+			require.NoError(t, err)
+			data, err := hackpadfs.ReadFile(fsys, res.Output)
+			require.NoError(t, err)
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			require.NoError(t, err)
+			require.NotEmpty(t, zr.File)
+			for _, f := range zr.File {
+				if !f.FileInfo().IsDir() {
+					require.Equal(t, tt.method, f.Method, f.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_New_compression(t *testing.T) {
+	c, err := New(Options{Compression: "default"})
+	require.NoError(t, err)
+	require.Empty(t, c.Options().Compression)
+
+	_, err = New(Options{Compression: "zstd"})
+	require.ErrorContains(t, err, `unknown compression "zstd"`)
+}
@@ -0,0 +1,624 @@
+// Package cbr2cbz repacks comic archives, cbr (rar), cb7 (7z), cbt (tar)
+// and comic pdfs, into cbz files, the way the cbr2cbz command does.
+//
+//	conv, err := cbr2cbz.New(cbr2cbz.Options{StripJunk: true})
+//	...
+//	res, err := conv.Convert(ctx, os.DirFS("/comics"), outFS, "Saga 001.cbr")
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+// Logger is where a Converter logs to, *log.Logger will do.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Warning is something that happened during a conversion that didn't stop
+// it, like an entry being left out. Code is one of the codes in codes.go.
+type Warning struct {
+	Code    string
+	File    string
+	Message string
+	// Entry and RenamedTo are set when an entry was rewritten to a safe
+	// path, its name in the source and in the cbz.
+	Entry     string
+	RenamedTo string
+}
+
+// Converter repacks archives into cbz files. It is safe to use from several
+// goroutines once set up.
+type Converter struct {
+	// Logger gets a line for every warning, nil discards them.
+	Logger Logger
+	// Limits bounds how much an archive may decompress to, the zero value
+	// doesn't limit anything.
+	Limits Limits
+	// OnWarning, if set, is called with every warning after it is logged.
+	OnWarning func(Warning)
+	// OnProgress, if set, is called by Convert each time it starts packing
+	// another entry of file.
+	OnProgress func(file string, p *Progress)
+
+	opts    Options
+	entries EntryFilter
+	images  ImageOptions
+}
+
+// New returns a Converter that packs archives the way opts says.
+func New(opts Options) (*Converter, error) {
+	if opts.PageOrder != "" && !PageOrders[opts.PageOrder] {
+		return nil, errors.Errorf("unknown page order %q", opts.PageOrder)
+	}
+	if opts.Compression != "" && !Compressions[opts.Compression] {
+		return nil, errors.Errorf("unknown compression %q", opts.Compression)
+	}
+	if _, ok := EntryEncodings[opts.EntryEncoding]; opts.EntryEncoding != "" && !ok {
+		return nil, errors.Errorf("unknown entry encoding %q", opts.EntryEncoding)
+	}
+	if opts.Placeholders && !opts.SkipBadEntries {
+		return nil, errors.New("placeholder pages need bad entries to be skipped")
+	}
+
+	c := &Converter{entries: NewEntryFilter(opts.ImageExtensions, opts.KeepFiles)}
+	c.entries.stripJunk = opts.StripJunk
+	if opts.Images != nil {
+		c.images = *opts.Images
+	}
+	if err := c.images.Validate(); err != nil {
+		return nil, err
+	}
+	c.opts = opts.normalize(c.entries, c.images)
+	return c, nil
+}
+
+// Options are the options c was made with, with defaults filled in, in the
+// form worth recording next to a converted file.
+func (c *Converter) Options() Options {
+	return c.opts
+}
+
+// Result describes a finished Convert.
+type Result struct {
+	// Output is the path of the cbz in dst.
+	Output string
+	// Entries is how many entries went into the cbz.
+	Entries int
+	// BytesIn and BytesOut are the sizes of the source and the cbz.
+	BytesIn  int64
+	BytesOut int64
+	Duration time.Duration
+}
+
+// Convert repacks the archive or pdf at name in src into a cbz next to
+// where it would be in dst, name with a .cbz extension. dst has to be
+// writable through hackpadfs, like hackpadfs/os or hackpadfs/mem. The cbz
+// only appears under its name once it is complete. The source is left alone.
+func (c *Converter) Convert(ctx context.Context, src fs.FS, dst fs.FS, name string) (Result, error) {
+	start := time.Now()
+	res := Result{Output: strings.TrimSuffix(name, path.Ext(name)) + ".cbz"}
+
+	reader, size, closeSrc, err := openReaderAt(src, name)
+	if err != nil {
+		return res, err
+	}
+	defer closeSrc()
+	res.BytesIn = size
+
+	format, _, err := archiver.Identify("", io.NewSectionReader(reader, 0, size))
+	if err != nil && !errors.Is(err, archiver.ErrNoMatch) {
+		return res, errors.Wrap(err, "unable to identify")
+	}
+	if _, ok := SourceFormat(format); !ok && !IsPDF(reader) {
+		return res, ErrNotArchive
+	}
+
+	progress := &Progress{}
+	if c.OnProgress != nil {
+		progress.OnEntry = func() { c.OnProgress(name, progress) }
+	}
+
+	// written under a temporary name and renamed into place once complete
+	part := path.Join(path.Dir(res.Output), fmt.Sprintf(".%s.%d.part", path.Base(res.Output), time.Now().UnixNano()))
+	out, err := hackpadfs.Create(dst, part)
+	if err != nil {
+		return res, errors.Wrap(err, "unable to create cbz")
+	}
+	w, ok := out.(io.Writer)
+	if !ok {
+		out.Close()
+		return res, errors.New("destination isn't a writable filesystem")
+	}
+	counted := &countingWriter{Writer: w}
+
+	files, err := c.Entries(ctx, name, reader, res.BytesIn, progress)
+	if err == nil {
+		res.Entries = len(files)
+		err = c.PackWithComment(ctx, name, files, c.Comment(name, reader, res.BytesIn), counted, progress)
+	}
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err == nil {
+		err = hackpadfs.Rename(dst, part, res.Output)
+	}
+	if err != nil {
+		hackpadfs.Remove(dst, part)
+		return res, err
+	}
+
+	res.BytesOut = counted.n
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// openReaderAt opens name for random access, reading it into memory when
+// fsys can't seek.
+func openReaderAt(fsys fs.FS, name string) (io.ReaderAt, int64, func() error, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, nil, errors.Wrap(err, "opening source")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, errors.Wrap(err, "stating source")
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, 0, nil, errors.New("is a directory")
+	}
+	if ra, ok := f.(io.ReaderAt); ok {
+		return ra, info.Size(), f.Close, nil
+	}
+
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, 0, nil, errors.Wrap(err, "reading source")
+	}
+	return bytes.NewReader(data), int64(len(data)), func() error { return nil }, nil
+}
+
+// Repack reads the rar, 7z, tar, zip or pdf in src and writes it out to dst as a
+// zip, applying the entry filtering and ComicInfo.xml options along the way.
+// name is only used for messages and guessing ComicInfo.xml.
+func (c *Converter) Repack(ctx context.Context, name string, src io.ReaderAt, size int64, dst io.Writer, progress *Progress) error {
+	files, err := c.Entries(ctx, name, src, size, progress)
+	if err != nil {
+		return err
+	}
+	return c.PackWithComment(ctx, name, files, c.Comment(name, src, size), dst, progress)
+}
+
+// Entries lists what goes into the cbz from the archive or pdf in src, in
+// page order. Each is only read once it gets packed, rars and tars in one
+// pass as long as they are packed in the order they are stored.
+func (c *Converter) Entries(ctx context.Context, name string, src io.ReaderAt, size int64, progress *Progress) ([]archiver.File, error) {
+	budget := c.Limits.forArchive(size)
+	if IsPDF(src) {
+		return c.pdfPages(ctx, name, src, size, budget, progress)
+	}
+	return c.archiveEntries(ctx, name, src, size, budget, progress)
+}
+
+// Pack writes files to dst as a zip, with the cover, page name, image and
+// ComicInfo.xml options applied.
+func (c *Converter) Pack(ctx context.Context, name string, files []archiver.File, dst io.Writer, progress *Progress) error {
+	return c.PackWithComment(ctx, name, files, "", dst, progress)
+}
+
+// PackWithComment is Pack, giving the zip comment as its archive comment,
+// see Comment.
+func (c *Converter) PackWithComment(ctx context.Context, name string, files []archiver.File, comment string, dst io.Writer, progress *Progress) error {
+	if c.opts.DropDuplicatePages {
+		var err error
+		files, err = c.dropDuplicatePages(ctx, name, files)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.opts.StripWrappers {
+		files = c.stripWrappers(name, files)
+	}
+
+	var expected uint64
+	for _, f := range files {
+		expected += uint64(f.Size())
+	}
+	progress.Expected.Store(expected)
+	progress.Entries.Store(int64(len(files)))
+
+	if c.opts.CoverFirst {
+		files = c.coverToFront(files)
+	}
+	if c.opts.Renumber {
+		files = c.renumberPages(name, files)
+	} else if c.opts.PadNumbers {
+		files = c.padPageNumbers(name, files)
+	}
+	files = c.processPages(name, files)
+
+	files, err := c.updateComicInfo(name, files)
+	if err != nil {
+		return errors.Wrap(err, "updating ComicInfo.xml")
+	}
+	if c.opts.Flatten {
+		// after ComicInfo.xml, so chapters are still found from folders
+		files = c.flattenEntries(name, files)
+	}
+
+	// create the archive
+	if c.opts.SkipBadEntries {
+		err = c.archiveSkipping(ctx, name, dst, files, comment)
+	} else {
+		err = archiveZip(ctx, dst, files, comment, c.opts.Compression)
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to archive zip")
+	}
+	return nil
+}
+
+// archiveZip writes files to dst as a zip the way archiver.Zip does, with
+// comment as the archive comment, compressed as compression says.
+func archiveZip(ctx context.Context, dst io.Writer, files []archiver.File, comment string, compression string) error {
+	zw := newZipWriter(dst, compression)
+	defer zw.Close()
+	err := zw.SetComment(comment)
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		hdr, err := zip.FileInfoHeader(f)
+		if err != nil {
+			return errors.Wrapf(err, "getting info for file %d: %s", i, f.Name())
+		}
+		hdr.Name = f.NameInArchive
+		hdr.Method = zipMethod(compression)
+		if f.IsDir() {
+			if !strings.HasSuffix(hdr.Name, "/") {
+				hdr.Name += "/"
+			}
+			hdr.Method = zip.Store
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return errors.Wrapf(err, "creating header for file %d: %s", i, f.Name())
+		}
+		if f.IsDir() {
+			continue
+		}
+		err = copyEntry(ctx, f, w)
+		if err != nil {
+			return errors.Wrapf(err, "writing file %d: %s", i, f.Name())
+		}
+	}
+	return zw.Close()
+}
+
+func copyEntry(ctx context.Context, f archiver.File, w io.Writer) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// checked while copying too, a single huge entry can take a while
+	_, err = io.Copy(w, contextReader{ctx: ctx, r: rc})
+	return err
+}
+
+// contextReader stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// archiveEntries lists the entries of the rar, 7z, tar or zip in src that
+// go into the cbz, opening each lazily when it gets archived. Only the headers are
+// kept, see entryCursor.
+func (c *Converter) archiveEntries(ctx context.Context, cbrFile string, src io.ReaderAt, size int64, budget *archiveBudget, progress *Progress) ([]archiver.File, error) {
+	return c.listArchive(ctx, cbrFile, src, size, budget, progress, 0)
+}
+
+// listArchive is archiveEntries for an archive depth archives deep.
+func (c *Converter) listArchive(ctx context.Context, cbrFile string, src io.ReaderAt, size int64, budget *archiveBudget, progress *Progress, depth int) ([]archiver.File, error) {
+	// by contents, the name may be a temp file or belong to a wrapped archive
+	identified, _, err := archiver.Identify("", io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to identify")
+	}
+	format, ok := SourceFormat(identified)
+	if zipFormat, isZip := identified.(archiver.Zip); isZip {
+		format, ok = zipFormat, true
+	}
+	if !ok {
+		return nil, errors.Errorf("can't repack %s archives", identified.Name())
+	}
+	if v, isVolumes := src.(*Volumes); isVolumes && depth == 0 {
+		if rar, isRar := format.(archiver.Rar); isRar {
+			format = rarVolumes{Rar: rar, fs: v.FS, name: v.Name}
+		}
+	}
+
+	inputStream := io.NewSectionReader(src, 0, size)
+	rarFS := archiver.ArchiveFS{Stream: inputStream, Format: format, Context: ctx}
+
+	var files []archiver.File
+	var nested map[string]fs.DirEntry
+	if _, isZip := format.(archiver.Zip); isZip {
+		files, nested, err = c.zipEntries(cbrFile, src, size, budget, progress, depth)
+	} else if cursor := newEntryCursor(ctx, format, src, size); cursor != nil {
+		files, nested, err = c.streamEntries(cbrFile, cursor, budget, progress, depth)
+	} else {
+		files, nested, err = c.walkEntries(cbrFile, rarFS, budget, progress, depth)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "walking rar file")
+	}
+
+	err = sortEntries(ctx, c.opts.PageOrder, files, format, src, size)
+	if err != nil {
+		return nil, err
+	}
+	c.decodeNames(cbrFile, files, nested)
+	c.sanitizeNames(cbrFile, files, nested)
+	return c.expandNested(ctx, cbrFile, files, nested, budget, progress, depth)
+}
+
+// DirEntries is Entries for an archive something else unpacked into dir,
+// like the unrar command. The order entries were stored in is lost by then,
+// so the archive page order sorts them folder by folder instead. size is
+// the size of the archive, for the limits.
+func (c *Converter) DirEntries(ctx context.Context, name string, dir fs.FS, size int64, progress *Progress) ([]archiver.File, error) {
+	budget := c.Limits.forArchive(size)
+	files, nested, err := c.walkEntries(name, dir, budget, progress, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "walking unpacked files")
+	}
+
+	order := c.opts.PageOrder
+	if order == "archive" {
+		order = "folder"
+	}
+	err = sortEntries(ctx, order, files, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	c.decodeNames(name, files, nested)
+	c.sanitizeNames(name, files, nested)
+	return c.expandNested(ctx, name, files, nested, budget, progress, 0)
+}
+
+// ZipEntries is Entries for a zip, a cbz being repacked rather than
+// converted. Entries only ever renames zips to cbz.
+func (c *Converter) ZipEntries(ctx context.Context, name string, src io.ReaderAt, size int64, progress *Progress) ([]archiver.File, error) {
+	budget := c.Limits.forArchive(size)
+	format := archiver.Zip{}
+	files, nested, err := c.zipEntries(name, src, size, budget, progress, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "walking zip file")
+	}
+
+	err = sortEntries(ctx, c.opts.PageOrder, files, format, src, size)
+	if err != nil {
+		return nil, err
+	}
+	c.decodeNames(name, files, nested)
+	c.sanitizeNames(name, files, nested)
+	return c.expandNested(ctx, name, files, nested, budget, progress, 0)
+}
+
+// ZipUnchanged reports whether repacking the zip in src would give back the
+// same entries in the same order with nothing rewritten, pages, ComicInfo.xml
+// and compression included, so it may as well be used as it is.
+func (c *Converter) ZipUnchanged(ctx context.Context, name string, src io.ReaderAt, size int64) bool {
+	o := c.opts
+	if o.Bookmarks || o.MarkCover || o.GenerateInfo || o.CoverFirst || o.PadNumbers || o.Renumber ||
+		o.Flatten || o.StripWrappers || o.DropDuplicatePages || o.Compression != "" || o.Images != nil {
+		return false
+	}
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return false
+	}
+	stored := []string{}
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			stored = append(stored, f.Name)
+		}
+	}
+
+	// listed quietly, whatever is wrong gets warned about when repacking
+	quiet := *c
+	quiet.Logger, quiet.OnWarning = nil, nil
+	files, err := quiet.ZipEntries(ctx, name, src, size, &Progress{})
+	if err != nil || len(files) != len(stored) {
+		return false
+	}
+	for i, f := range files {
+		if f.NameInArchive != stored[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// zipEntries is walkEntries for the zip in src, read through archive/zip
+// as walking it as a filesystem fails on entries like ../001.jpg, which
+// sanitizeNames rewrites instead.
+func (c *Converter) zipEntries(cbrFile string, src io.ReaderAt, size int64, budget *archiveBudget, progress *Progress, depth int) ([]archiver.File, map[string]fs.DirEntry, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := &entryList{nested: map[string]fs.DirEntry{}}
+	seen := map[string]bool{}
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(strings.Trim(f.Name, "/"), "./")
+		info := f.FileInfo()
+		if name == "" || name == "." || info.IsDir() || !info.Mode().IsRegular() || seen[name] {
+			continue
+		}
+		seen[name] = true
+		err := c.addEntry(cbrFile, name, fs.FileInfoToDirEntry(info), f.Open, budget, progress, depth, list)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return list.files, list.nested, nil
+}
+
+// walkEntries lists the entries of fsys, the contents of cbrFile, that go
+// into the cbz. Archives inside it to unpack are listed by name only and
+// returned in nested too, see expandNested.
+func (c *Converter) walkEntries(cbrFile string, fsys fs.FS, budget *archiveBudget, progress *Progress, depth int) ([]archiver.File, map[string]fs.DirEntry, error) {
+	list := &entryList{nested: map[string]fs.DirEntry{}}
+	err := fs.WalkDir(fsys, ".", func(pathName string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if de.IsDir() {
+			// nothing to do
+			return nil
+		}
+
+		return c.addEntry(cbrFile, pathName, de, func() (io.ReadCloser, error) {
+			return fsys.Open(pathName)
+		}, budget, progress, depth, list)
+	})
+	return list.files, list.nested, err
+}
+
+// entryList is what walkEntries and streamEntries found so far.
+type entryList struct {
+	files []archiver.File
+	// entries of files that are archives to unpack, see nestedEntries
+	nested map[string]fs.DirEntry
+}
+
+// addEntry adds the file pathName in cbrFile to list if it goes into the
+// cbz, read with open once it gets packed.
+func (c *Converter) addEntry(cbrFile string, pathName string, de fs.DirEntry, open func() (io.ReadCloser, error), budget *archiveBudget, progress *Progress, depth int, list *entryList) error {
+	if c.opts.RecurseArchives && depth < maxNesting && isNestedArchive(pathName) {
+		list.nested[pathName] = de
+		list.files = append(list.files, archiver.File{NameInArchive: pathName, Open: open})
+		return nil
+	}
+
+	if kind := c.entries.Classify(pathName); kind == EntryDropped {
+		if c.entries.stripJunk && isJunk(pathName) {
+			c.warn(CodeJunkRemoved, cbrFile, "Dropping %s from %s, it is junk", pathName, cbrFile)
+			return nil
+		}
+		if isNestedArchive(pathName) && !c.opts.RecurseArchives {
+			c.warn(CodeEntryDropped, cbrFile, "Dropping %s from %s, it is an archive, --recurse-archives unpacks it", pathName, cbrFile)
+			return nil
+		}
+		c.warn(CodeEntryDropped, cbrFile, "Dropping %s from %s, not an image or kept file", pathName, cbrFile)
+		return nil
+	}
+
+	info, err := de.Info()
+	if err != nil {
+		return errors.Wrap(err, "unable to look up file")
+	}
+	if c.entries.stripJunk && info.Size() == 0 {
+		c.warn(CodeJunkRemoved, cbrFile, "Dropping %s from %s, it is empty", pathName, cbrFile)
+		return nil
+	}
+
+	err = budget.declare(pathName, info.Size())
+	if err != nil {
+		return err
+	}
+
+	list.files = append(list.files, archiver.File{
+		FileInfo:      info,
+		NameInArchive: pathName,
+		Open: func() (io.ReadCloser, error) {
+			f, err := open()
+			if err != nil {
+				return nil, err
+			}
+			progress.setEntry(pathName)
+			return countingReadCloser{ReadCloser: budget.guard(pathName, info.Size(), f), n: &progress.Read}, nil
+		},
+	})
+	return nil
+}
+
+// expandNested puts the entries of each nested archive in files in its
+// place, so its pages stay where it sorted.
+func (c *Converter) expandNested(ctx context.Context, cbrFile string, files []archiver.File, nested map[string]fs.DirEntry, budget *archiveBudget, progress *Progress, depth int) ([]archiver.File, error) {
+	if len(nested) == 0 {
+		return files, nil
+	}
+
+	expanded := make([]archiver.File, 0, len(files))
+	for _, f := range files {
+		de, ok := nested[f.NameInArchive]
+		if !ok {
+			expanded = append(expanded, f)
+			continue
+		}
+		inner, err := c.nestedEntries(ctx, cbrFile, f, de, budget, progress, depth)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, inner...)
+	}
+	return expanded, nil
+}
+
+// SourceFormat returns format if it is one a Converter can repack, rar, 7z
+// or tar. Zip is left to the caller since it only needs renaming.
+func SourceFormat(format archiver.Format) (archiver.Archival, bool) {
+	switch format.(type) {
+	case archiver.Rar, archiver.SevenZip, archiver.Tar:
+		return format.(archiver.Archival), true
+	}
+	return nil, false
+}
+
+// warn logs a warning about file prefixed with its code and hands it to
+// OnWarning.
+func (c *Converter) warn(code string, file string, format string, args ...any) {
+	c.emitWarning(Warning{Code: code, File: file, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *Converter) emitWarning(w Warning) {
+	if c.Logger != nil {
+		c.Logger.Printf("[%s] %s\n", w.Code, w.Message)
+	}
+	if c.OnWarning != nil {
+		c.OnWarning(w)
+	}
+}
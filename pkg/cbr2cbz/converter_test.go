@@ -0,0 +1,235 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hack-pad/hackpadfs"
+	memfs "github.com/hack-pad/hackpadfs/mem"
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Printf(format string, v ...any) {
+	l.t.Helper()
+	l.t.Logf(format, v...)
+}
+
+// newTestConverter makes a Converter for opts that logs to the test.
+func newTestConverter(t *testing.T, opts Options) *Converter {
+	t.Helper()
+	c, err := New(opts)
+	require.NoError(t, err)
+	c.Logger = testLogger{t}
+	return c
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "fixtures", name))
+	require.NoError(t, err)
+	return data
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "page order", opts: Options{PageOrder: "sideways"}, wantErr: `unknown page order "sideways"`},
+		{name: "placeholders", opts: Options{Placeholders: true}, wantErr: "placeholder pages need bad entries to be skipped"},
+		{name: "images", opts: Options{Images: &ImageOptions{Format: "gif"}}, wantErr: "gif"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(tt.opts)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.ElementsMatch(t, DefaultImageExtensions, c.Options().ImageExtensions)
+			require.Equal(t, DefaultKeepFiles, c.Options().KeepFiles)
+		})
+	}
+}
+
+func Test_Converter_Convert(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		wantErr error
+	}{
+		{name: "rar", fixture: "test.cbr"},
+		{name: "tar", fixture: "test.cbt"},
+		{name: "zip", fixture: "is-zip.cbr", wantErr: ErrNotArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, err := memfs.NewFS()
+			require.NoError(t, err)
+			require.NoError(t, hackpadfs.MkdirAll(fsys, "comics", 0o755))
+			require.NoError(t, hackpadfs.WriteFullFile(fsys, "comics/"+tt.fixture, readFixture(t, tt.fixture), 0o644))
+
+			var warnings []Warning
+			var progressed []string
+			// the fixtures hold text pages
+			c := newTestConverter(t, Options{KeepFiles: []string{"*.txt"}})
+			c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+			c.OnProgress = func(file string, p *Progress) { progressed = append(progressed, p.Entry()) }
+
+			res, err := c.Convert(context.Background(), fsys, fsys, "comics/"+tt.fixture)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				_, statErr := hackpadfs.Stat(fsys, res.Output)
+				require.ErrorIs(t, statErr, os.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "comics/"+tt.fixture[:len(tt.fixture)-4]+".cbz", res.Output)
+			require.NotZero(t, res.Entries)
+			require.Len(t, progressed, res.Entries)
+			require.Empty(t, warnings)
+
+			data, err := hackpadfs.ReadFile(fsys, res.Output)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), res.BytesOut)
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			require.NoError(t, err)
+			require.Len(t, zr.File, res.Entries)
+			names := []string{}
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			require.True(t, sort.SliceIsSorted(names, func(i, j int) bool { return compareNatural(names[i], names[j]) < 0 }), names)
+		})
+	}
+}
+
+func Test_Converter_Convert_warnings(t *testing.T) {
+	fsys, err := memfs.NewFS()
+	require.NoError(t, err)
+	require.NoError(t, hackpadfs.WriteFullFile(fsys, "test.cbr", readFixture(t, "test.cbr"), 0o644))
+
+	var warnings []Warning
+	c := newTestConverter(t, Options{})
+	c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+
+	res, err := c.Convert(context.Background(), fsys, fsys, "test.cbr")
+	require.NoError(t, err)
+	require.Zero(t, res.Entries)
+	require.NotEmpty(t, warnings)
+	for _, w := range warnings {
+		require.Equal(t, CodeEntryDropped, w.Code)
+		require.Equal(t, "test.cbr", w.File)
+	}
+}
+
+func Test_Converter_DirEntries(t *testing.T) {
+	dir := fstest.MapFS{
+		"Saga/page10.jpg": {Data: []byte("10")},
+		"Saga/page2.jpg":  {Data: []byte("2")},
+		"Thumbs.db":       {Data: []byte("junk")},
+		"notes.txt":       {Data: []byte("notes")},
+	}
+	c := newTestConverter(t, Options{PageOrder: "archive", StripJunk: true})
+
+	files, err := c.DirEntries(context.Background(), "Saga.cbr", dir, 100, &Progress{})
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.NameInArchive)
+	}
+	require.Equal(t, []string{"Saga/page10.jpg", "Saga/page2.jpg"}, names)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.Pack(context.Background(), "Saga.cbr", files, buf, &Progress{}))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+}
+
+func Test_Converter_ZipUnchanged(t *testing.T) {
+	zipOf := func(names ...string) []byte {
+		buf := &bytes.Buffer{}
+		w := zip.NewWriter(buf)
+		for _, name := range names {
+			f, err := w.Create(name)
+			require.NoError(t, err)
+			_, err = f.Write([]byte(name))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	unchanged := func(c *Converter, data []byte) bool {
+		return c.ZipUnchanged(context.Background(), "Saga.cbr", bytes.NewReader(data), int64(len(data)))
+	}
+
+	c := newTestConverter(t, Options{})
+	require.True(t, unchanged(c, zipOf("ComicInfo.xml", "Saga/001.jpg", "Saga/002.jpg")))
+	require.False(t, unchanged(c, zipOf("002.jpg", "001.jpg")), "reordered")
+	require.False(t, unchanged(c, zipOf("../001.jpg")), "rewritten")
+	require.False(t, unchanged(c, zipOf("001.jpg", "notes.txt")), "dropped")
+	require.False(t, unchanged(newTestConverter(t, Options{Renumber: true}), zipOf("001.jpg")))
+	require.False(t, unchanged(c, []byte("not a zip")))
+}
+
+// FuzzConverter_Repack feeds malformed archives to Repack, which should
+// fail them and never panic. go test -fuzz=FuzzConverter_Repack ./pkg/cbr2cbz
+// runs it for real, crashes land in testdata/fuzz.
+func FuzzConverter_Repack(f *testing.F) {
+	for _, name := range []string{"test.cbr", "test.cbt", "is-zip.cbr"} {
+		data, err := os.ReadFile(filepath.Join("..", "..", "fixtures", name))
+		require.NoError(f, err)
+		f.Add(data)
+	}
+	f.Add([]byte("%PDF-1.4\n"))
+	c, err := New(Options{})
+	require.NoError(f, err)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = c.Repack(ctx, "fuzz.cbr", bytes.NewReader(data), int64(len(data)), io.Discard, &Progress{})
+	})
+}
+
+// Test_archiveZip_manyEntries packs an omnibus past the 65535 entries a
+// plain zip can list.
+func Test_archiveZip_manyEntries(t *testing.T) {
+	files := []archiver.File{}
+	for i := 1; i <= 70000; i++ {
+		name := fmt.Sprintf("omnibus/%05d.jpg", i)
+		files = append(files, datedVirtualFile(name, []byte(name), time.Now()))
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, archiveZip(context.Background(), buf, files, "", ""))
+	// the zip64 end of central directory record
+	require.Contains(t, buf.String(), "PK\x06\x06")
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, len(files))
+	last := zr.File[len(zr.File)-1]
+	rc, err := last.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, last.Name, string(data))
+}
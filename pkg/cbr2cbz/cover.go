@@ -0,0 +1,77 @@
+package cbr2cbz
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+var zeroPagePattern = regexp.MustCompile(`^0+$`)
+
+// findCover guesses which of the pages (in reading order) is the front cover.
+// Names mentioning "cover" win, then Windows style folder art, then a page
+// numbered zero, and otherwise it's the first page.
+func findCover(pages []string) int {
+	if len(pages) == 0 {
+		return -1
+	}
+
+	stems := make([]string, len(pages))
+	for i, page := range pages {
+		base := strings.ToLower(path.Base(page))
+		stems[i] = strings.TrimSuffix(base, path.Ext(base))
+	}
+
+	for i, page := range pages {
+		if strings.Contains(strings.ToLower(page), "cover") {
+			return i
+		}
+	}
+	for i, stem := range stems {
+		if stem == "folder" || stem == "front" {
+			return i
+		}
+	}
+	for i, stem := range stems {
+		if zeroPagePattern.MatchString(stem) {
+			return i
+		}
+	}
+	return 0
+}
+
+// CoverPage returns which of pages, in reading order, is the front cover,
+// the way Options.MarkCover and CoverFirst guess it. It is "" without pages.
+func CoverPage(pages []string) string {
+	i := findCover(pages)
+	if i < 0 {
+		return ""
+	}
+	return pages[i]
+}
+
+// coverToFront moves the detected cover ahead of all other pages in files.
+func (c *Converter) coverToFront(files []archiver.File) []archiver.File {
+	pages := c.pageIndexes(files)
+	if len(pages) == 0 {
+		return files
+	}
+
+	cover := pages[findCover(c.entryNames(files, pages))]
+	first := pages[0]
+	if cover == first {
+		return files
+	}
+
+	out := make([]archiver.File, 0, len(files))
+	out = append(out, files[:first]...)
+	out = append(out, files[cover])
+	for i := first; i < len(files); i++ {
+		if i != cover {
+			out = append(out, files[i])
+		}
+	}
+	return out
+}
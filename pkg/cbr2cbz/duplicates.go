@@ -0,0 +1,64 @@
+package cbr2cbz
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// dropDuplicatePages leaves out pages with exactly the contents of an
+// earlier page, like a credits page or cover included twice. Only pages the
+// same size as another are read to compare them, so most archives aren't
+// read any more than packing them does. Pages that can't be read are kept,
+// packing them deals with the error.
+func (c *Converter) dropDuplicatePages(ctx context.Context, cbrFile string, files []archiver.File) ([]archiver.File, error) {
+	sizes := map[int64]int{}
+	for _, f := range files {
+		if c.entries.Classify(f.NameInArchive) == EntryPage {
+			sizes[f.Size()]++
+		}
+	}
+
+	// the first page with each contents
+	seen := map[[sha256.Size]byte]string{}
+	out := make([]archiver.File, 0, len(files))
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if c.entries.Classify(f.NameInArchive) != EntryPage || sizes[f.Size()] < 2 {
+			out = append(out, f)
+			continue
+		}
+		sum, err := entrySum(f)
+		if err != nil {
+			out = append(out, f)
+			continue
+		}
+		if first, ok := seen[sum]; ok {
+			c.warn(CodeDuplicatePage, cbrFile, "Dropping %s from %s, it is the same as %s", f.NameInArchive, cbrFile, first)
+			continue
+		}
+		seen[sum] = f.NameInArchive
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func entrySum(f archiver.File) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	rc, err := f.Open()
+	if err != nil {
+		return sum, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, rc)
+	if err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
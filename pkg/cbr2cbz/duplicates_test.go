@@ -0,0 +1,54 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Converter_dropDuplicatePages(t *testing.T) {
+	dir := fstest.MapFS{
+		"001.jpg":       {Data: []byte("cover")},
+		"002.jpg":       {Data: []byte("page two")},
+		"003.jpg":       {Data: []byte("cover")},
+		"004.jpg":       {Data: []byte("page 4!!")},
+		"005.jpg":       {Data: []byte("credits")},
+		"006.jpg":       {Data: []byte("credits")},
+		"ComicInfo.xml": {Data: []byte("cover")},
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{name: "off", opts: Options{}, want: []string{"001.jpg", "002.jpg", "003.jpg", "004.jpg", "005.jpg", "006.jpg", "ComicInfo.xml"}},
+		{name: "on", opts: Options{DropDuplicatePages: true}, want: []string{"001.jpg", "002.jpg", "004.jpg", "005.jpg", "ComicInfo.xml"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var warnings []Warning
+			c := newTestConverter(t, tt.opts)
+			c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+
+			files, err := c.DirEntries(context.Background(), "Saga.cbr", dir, 100, &Progress{})
+			require.NoError(t, err)
+			buf := &bytes.Buffer{}
+			require.NoError(t, c.Pack(context.Background(), "Saga.cbr", files, buf, &Progress{}))
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			names := []string{}
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			require.Equal(t, tt.want, names)
+			require.Len(t, warnings, len(files)-len(tt.want))
+			for _, w := range warnings {
+				require.Equal(t, CodeDuplicatePage, w.Code)
+			}
+		})
+	}
+}
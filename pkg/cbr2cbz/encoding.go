@@ -0,0 +1,121 @@
+package cbr2cbz
+
+import (
+	"io/fs"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mholt/archiver/v4"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// EntryEncodings are the values --entry-encoding accepts, the encodings
+// entry names that aren't UTF-8 can be read as. auto guesses one for each
+// archive from autoEncodings.
+var EntryEncodings = map[string]encoding.Encoding{
+	"auto":         nil,
+	"cp437":        charmap.CodePage437,
+	"cp850":        charmap.CodePage850,
+	"cp866":        charmap.CodePage866,
+	"windows-1250": charmap.Windows1250,
+	"windows-1251": charmap.Windows1251,
+	"windows-1252": charmap.Windows1252,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"koi8-r":       charmap.KOI8R,
+	"shift-jis":    japanese.ShiftJIS,
+	"euc-jp":       japanese.EUCJP,
+	"gbk":          simplifiedchinese.GBK,
+	"gb18030":      simplifiedchinese.GB18030,
+	"big5":         traditionalchinese.Big5,
+	"euc-kr":       korean.EUCKR,
+}
+
+// autoEncodings are what auto tries, ties going to the first. common
+// reports whether a character, stored as b, is one names are likely to
+// have: kana and level 1 kanji, GB2312, Big5's frequent hanzi or latin
+// letters. Latin and Cyrillic single byte encodings read each other's names
+// as letters, and EUC-KR and GBK each other's, so only the DOS and Windows
+// latin ones are tried; the others have to be asked for.
+var autoEncodings = []struct {
+	name   string
+	common func(r rune, b string) bool
+}{
+	{"shift-jis", func(r rune, b string) bool { return len(b) == 2 && b[0] <= 0x9f }},
+	{"gbk", func(r rune, b string) bool { return len(b) == 2 && b[0] >= 0xa1 && b[1] >= 0xa1 }},
+	{"big5", func(r rune, b string) bool { return len(b) == 2 && b >= "\xa4\x40" && b <= "\xc6\x7e" }},
+	{"cp437", latinLetter},
+	{"windows-1252", latinLetter},
+}
+
+func latinLetter(r rune, _ string) bool {
+	return unicode.Is(unicode.Latin, r)
+}
+
+// decodeNames renames the files of cbrFile whose names aren't UTF-8 to
+// what they are read as in Options.EntryEncoding, or the encoding guessed
+// for the archive. Nested archives are renamed in nested too.
+func (c *Converter) decodeNames(cbrFile string, files []archiver.File, nested map[string]fs.DirEntry) {
+	raw := []string{}
+	for _, f := range files {
+		if !utf8.ValidString(f.NameInArchive) {
+			raw = append(raw, f.NameInArchive)
+		}
+	}
+	if len(raw) == 0 {
+		return
+	}
+
+	name := c.opts.EntryEncoding
+	if name == "" {
+		name = guessEncoding(raw)
+	}
+	c.warn(CodeNamesDecoded, cbrFile, "Reading %d entry names in %s as %s, they aren't UTF-8", len(raw), cbrFile, name)
+
+	decoder := EntryEncodings[name].NewDecoder()
+	for i, f := range files {
+		if utf8.ValidString(f.NameInArchive) {
+			continue
+		}
+		decoded, err := decoder.String(f.NameInArchive)
+		if err != nil {
+			decoded = strings.ToValidUTF8(f.NameInArchive, string(utf8.RuneError))
+		}
+		renameFile(files, i, nested, decoded)
+	}
+}
+
+// guessEncoding returns the one of autoEncodings names read most like
+// file names in, all of them read without errors.
+func guessEncoding(names []string) string {
+	best, fewest := "cp437", -1
+	for _, candidate := range autoEncodings {
+		enc := EntryEncodings[candidate.name]
+		decoder, encoder := enc.NewDecoder(), enc.NewEncoder()
+		odd := 0
+		for _, name := range names {
+			decoded, err := decoder.String(name)
+			if err != nil || strings.ContainsRune(decoded, utf8.RuneError) {
+				odd = -1
+				break
+			}
+			for _, r := range decoded {
+				if r < utf8.RuneSelf {
+					continue
+				}
+				if b, err := encoder.String(string(r)); err != nil || !candidate.common(r, b) {
+					odd++
+				}
+			}
+		}
+		if odd >= 0 && (fewest < 0 || odd < fewest) {
+			best, fewest = candidate.name, odd
+		}
+	}
+	return best
+}
@@ -0,0 +1,72 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encode(t *testing.T, encoding string, name string) string {
+	t.Helper()
+	encoded, err := EntryEncodings[encoding].NewEncoder().String(name)
+	require.NoError(t, err)
+	return encoded
+}
+
+func Test_guessEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		encoding string
+		names    []string
+	}{
+		{"shift-jis", []string{"ページ01.jpg", "表紙.jpg"}},
+		{"gbk", []string{"第一页.jpg", "封面.jpg"}},
+		{"big5", []string{"封面.jpg", "第一頁.jpg"}},
+		{"cp437", []string{"Café/page01.jpg"}},
+		{"windows-1252", []string{"Édition spéciale/01.jpg"}},
+	} {
+		t.Run(tt.encoding, func(t *testing.T) {
+			raw := []string{}
+			for _, name := range tt.names {
+				raw = append(raw, encode(t, tt.encoding, name))
+			}
+			require.Equal(t, tt.encoding, guessEncoding(raw))
+		})
+	}
+}
+
+func Test_Converter_Repack_entryEncoding(t *testing.T) {
+	chapter, err := io.ReadAll(nestedTar(t, encode(t, "shift-jis", "ページ01.jpg"), []byte("one")))
+	require.NoError(t, err)
+	src := nestedTar(t,
+		encode(t, "shift-jis", "表紙.jpg"), []byte("cover"),
+		encode(t, "shift-jis", "第1話.cbt"), chapter,
+	)
+	repack := func(opts Options) ([]string, []Warning) {
+		c := newTestConverter(t, opts)
+		warnings := []Warning{}
+		c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+		buf := &bytes.Buffer{}
+		require.NoError(t, c.Repack(context.Background(), "test.cbt", src, src.Size(), buf, &Progress{}))
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		names := []string{}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return names, warnings
+	}
+
+	names, warnings := repack(Options{RecurseArchives: true, PageOrder: "archive"})
+	require.Equal(t, []string{"表紙.jpg", "第1話/ページ01.jpg"}, names)
+	require.Len(t, warnings, 2, "one for the archive, one for the nested one")
+	require.Equal(t, CodeNamesDecoded, warnings[0].Code)
+
+	names, _ = repack(Options{EntryEncoding: "cp437"})
+	asked, err := EntryEncodings["cp437"].NewDecoder().String(encode(t, "shift-jis", "表紙.jpg"))
+	require.NoError(t, err)
+	require.Equal(t, []string{safeEntryName(asked)}, names, "as asked, right or not")
+}
@@ -0,0 +1,131 @@
+package cbr2cbz
+
+import (
+	"path"
+	"strings"
+)
+
+// EntryKind is what happens to an entry from the source archive when it gets
+// packed into the cbz.
+type EntryKind int
+
+const (
+	// EntryPage is an image that becomes a page of the comic.
+	EntryPage EntryKind = iota
+	// EntryPassthrough is copied over untouched, like ComicInfo.xml.
+	EntryPassthrough
+	// EntryDropped is left out of the cbz.
+	EntryDropped
+)
+
+func (k EntryKind) String() string {
+	switch k {
+	case EntryPage:
+		return "page"
+	case EntryPassthrough:
+		return "passthrough"
+	default:
+		return "dropped"
+	}
+}
+
+var (
+	DefaultImageExtensions = []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "avif"}
+	DefaultKeepFiles       = []string{"ComicInfo.xml"}
+)
+
+// junkNames are files operating systems leave behind that are never part of
+// a comic, compared lower case.
+var junkNames = map[string]bool{"thumbs.db": true, ".ds_store": true, "desktop.ini": true}
+
+// EntryFilter decides what kind of entry each file in the source archive is.
+// The zero value uses DefaultImageExtensions and DefaultKeepFiles.
+type EntryFilter struct {
+	imageExts map[string]bool
+	keepFiles []string
+	// stripJunk drops OS leftovers, even ones that look like pages or match
+	// a keep pattern
+	stripJunk bool
+}
+
+func NewEntryFilter(imageExts []string, keepFiles []string) EntryFilter {
+	f := EntryFilter{imageExts: map[string]bool{}, keepFiles: keepFiles}
+	for _, ext := range imageExts {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			f.imageExts[ext] = true
+		}
+	}
+	return f
+}
+
+func (f EntryFilter) isImage(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if ext == "" {
+		return false
+	}
+
+	if len(f.imageExts) == 0 {
+		for _, e := range DefaultImageExtensions {
+			if e == ext {
+				return true
+			}
+		}
+		return false
+	}
+	return f.imageExts[ext]
+}
+
+func (f EntryFilter) Classify(name string) EntryKind {
+	if f.stripJunk && isJunk(name) {
+		return EntryDropped
+	}
+
+	if f.isImage(name) {
+		return EntryPage
+	}
+
+	if f.isKept(name) {
+		return EntryPassthrough
+	}
+
+	return EntryDropped
+}
+
+// isKept reports whether name matches one of the keep patterns. Patterns
+// without a slash match the base name anywhere in the archive, otherwise the
+// full path in the archive. Matching ignores case.
+func (f EntryFilter) isKept(name string) bool {
+	patterns := f.keepFiles
+	if patterns == nil {
+		patterns = DefaultKeepFiles
+	}
+
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isJunk reports whether name is an OS leftover: Thumbs.db, .DS_Store,
+// desktop.ini, or anything macOS put under __MACOSX or in a ._ resource file.
+func isJunk(name string) bool {
+	base := path.Base(name)
+	if junkNames[strings.ToLower(base)] || strings.HasPrefix(base, "._") {
+		return true
+	}
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if strings.EqualFold(dir, "__MACOSX") {
+			return true
+		}
+	}
+	return false
+}
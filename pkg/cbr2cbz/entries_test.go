@@ -0,0 +1,42 @@
+package cbr2cbz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_entryFilter_classify(t *testing.T) {
+	tests := []struct {
+		name      string
+		imageExts []string
+		keepFiles []string
+		stripJunk bool
+		entry     string
+		want      EntryKind
+	}{
+		{name: "default jpg", entry: "Comic/001.JPG", want: EntryPage},
+		{name: "default text", entry: "Comic/notes.txt", want: EntryDropped},
+		{name: "comicinfo", entry: "ComicInfo.xml", want: EntryPassthrough},
+		{name: "custom bmp", imageExts: []string{".BMP"}, entry: "001.bmp", want: EntryPage},
+		{name: "custom excludes png", imageExts: []string{"jpg"}, entry: "001.png", want: EntryDropped},
+		{name: "keep nfo", keepFiles: []string{"*.nfo"}, entry: "Comic/credits.NFO", want: EntryPassthrough},
+		{name: "keep replaces default", keepFiles: []string{"*.nfo"}, entry: "ComicInfo.xml", want: EntryDropped},
+		{name: "keep full path", keepFiles: []string{"comic/*.txt"}, entry: "Other/notes.txt", want: EntryDropped},
+		{name: "macos resource fork", stripJunk: true, entry: "__MACOSX/Comic/._001.jpg", want: EntryDropped},
+		{name: "resource fork kept without strip", entry: "__MACOSX/Comic/._001.jpg", want: EntryPage},
+		{name: "thumbs.db kept by pattern", stripJunk: true, keepFiles: []string{"*.db"}, entry: "Comic/Thumbs.db", want: EntryDropped},
+		{name: "ds_store", stripJunk: true, keepFiles: []string{"*"}, entry: ".DS_Store", want: EntryDropped},
+		{name: "page with strip", stripJunk: true, entry: "Comic/001.jpg", want: EntryPage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := EntryFilter{}
+			if tt.imageExts != nil || tt.keepFiles != nil {
+				f = NewEntryFilter(tt.imageExts, tt.keepFiles)
+			}
+			f.stripJunk = tt.stripJunk
+			require.Equal(t, tt.want, f.Classify(tt.entry))
+		})
+	}
+}
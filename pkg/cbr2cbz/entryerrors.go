@@ -0,0 +1,121 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// placeholderSize is used for placeholder pages when no readable page came
+// before them to take the size from.
+var placeholderSize = image.Pt(1000, 1500)
+
+// archiveSkipping writes the cbz like archiver.Zip does, except each entry
+// is read in full before its header goes out, so one that can't be read is
+// left out instead of failing the archive. Unreadable pages are replaced by
+// a placeholder when c.opts.Placeholders is set, keeping later pages where the
+// reader expects them. Decompression limits and cancellation still abort.
+func (c *Converter) archiveSkipping(ctx context.Context, cbrFile string, dst io.Writer, files []archiver.File, comment string) error {
+	zw := newZipWriter(dst, c.opts.Compression)
+	defer zw.Close()
+	err := zw.SetComment(comment)
+	if err != nil {
+		return err
+	}
+
+	size := placeholderSize
+	page := 0
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if f.IsDir() {
+			continue
+		}
+		isPage := c.entries.Classify(f.NameInArchive) == EntryPage
+		if isPage {
+			page++
+		}
+
+		name := f.NameInArchive
+		data, err := readEntry(f)
+		if errors.Is(err, ErrDecompressionLimit) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		switch {
+		case err != nil && isPage && c.opts.Placeholders:
+			c.warn(CodePlaceholder, cbrFile, "Replacing unreadable page %d of %s (%s) with a placeholder: %s", page, cbrFile, name, err)
+			name, data, err = placeholderPage(name, page, size)
+			if err != nil {
+				return errors.Wrapf(err, "drawing placeholder for %s", f.NameInArchive)
+			}
+		case err != nil:
+			c.warn(CodeEntryUnreadable, cbrFile, "Skipping unreadable %s in %s: %s", name, cbrFile, err)
+			continue
+		case isPage:
+			if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				size = image.Pt(cfg.Width, cfg.Height)
+			}
+		}
+
+		hdr, err := zip.FileInfoHeader(f)
+		if err != nil {
+			return errors.Wrapf(err, "getting info for %s", name)
+		}
+		hdr.Name = name
+		hdr.Method = zipMethod(c.opts.Compression)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return errors.Wrapf(err, "creating header for %s", name)
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return errors.Wrapf(err, "writing %s", name)
+		}
+	}
+	return zw.Close()
+}
+
+func readEntry(f archiver.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// placeholderPage draws a page of the given size saying which page couldn't
+// be read, encoded to match name's extension where we can write that format.
+func placeholderPage(name string, page int, size image.Point) (string, []byte, error) {
+	text := fmt.Sprintf("page %d unreadable in source", page)
+	face := basicfont.Face7x13
+
+	// draw the text small, then scale it up to about half the page width
+	small := image.NewRGBA(image.Rect(0, 0, font.MeasureString(face, text).Ceil()+8, 21))
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+	d := &font.Drawer{Dst: small, Src: image.NewUniform(color.Gray{Y: 64}), Face: face, Dot: fixed.P(4, 15)}
+	d.DrawString(text)
+
+	img := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	scale := max(1, size.X/2/small.Bounds().Dx())
+	w, h := small.Bounds().Dx()*scale, small.Bounds().Dy()*scale
+	at := image.Rect((size.X-w)/2, (size.Y-h)/2, (size.X+w)/2, (size.Y+h)/2)
+	draw.NearestNeighbor.Scale(img, at, small, small.Bounds(), draw.Src, nil)
+
+	format := formatForName(name)
+	data, err := encodeImage(img, format, 0)
+	return renameExt(name, imageFormatExtensions[format]), data, err
+}
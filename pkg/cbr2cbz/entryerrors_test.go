@@ -0,0 +1,71 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_archiveSkipping(t *testing.T) {
+	broken := func(name string, err error) archiver.File {
+		f := virtualFile(name, []byte("lost"))
+		f.Open = func() (io.ReadCloser, error) { return nil, err }
+		return f
+	}
+	page := makePNG(t, 40, 60)
+
+	tests := []struct {
+		name         string
+		placeholders bool
+		bad          error
+		want         []string
+		wantErr      bool
+	}{
+		{name: "skipped", bad: errors.New("crc mismatch"), want: []string{"001.png", "003.png"}},
+		{name: "placeholder", placeholders: true, bad: errors.New("crc mismatch"), want: []string{"001.png", "002.png", "003.png"}},
+		{name: "limits still abort", bad: ErrDecompressionLimit, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []archiver.File{virtualFile("001.png", page), broken("002.jpg", tt.bad), virtualFile("003.png", page)}
+			if tt.placeholders {
+				files[1].NameInArchive = "002.png"
+			}
+
+			c := newTestConverter(t, Options{SkipBadEntries: true, Placeholders: tt.placeholders})
+			buf := &bytes.Buffer{}
+			err := c.Pack(context.Background(), "test.cbr", files, buf, &Progress{})
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrDecompressionLimit)
+				return
+			}
+			require.NoError(t, err)
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			names := []string{}
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			require.Equal(t, tt.want, names)
+
+			if tt.placeholders {
+				rc, err := zr.File[1].Open()
+				require.NoError(t, err)
+				defer rc.Close()
+				cfg, format, err := image.DecodeConfig(rc)
+				require.NoError(t, err)
+				require.Equal(t, "png", format)
+				// sized like the page before it
+				require.Equal(t, image.Pt(40, 60), image.Pt(cfg.Width, cfg.Height))
+			}
+		})
+	}
+}
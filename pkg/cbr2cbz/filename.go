@@ -0,0 +1,90 @@
+package cbr2cbz
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	filenameYear   = regexp.MustCompile(`[(\[](\d{4})[)\]]`)
+	filenameTags   = regexp.MustCompile(`\([^)]*\)|\[[^\]]*\]|\{[^}]*\}`)
+	filenameVolume = regexp.MustCompile(`(?i)\b(?:v|vol\.?|volume)\s*(\d+)\b`)
+	filenameIssue  = regexp.MustCompile(`#\s*(\d+(?:\.\d+)?)|\b(\d+(?:\.\d+)?)\s*$`)
+	filenameSpaces = regexp.MustCompile(`\s+`)
+	filenameTag    = regexp.MustCompile(`[(\[]([^)\]]*)[)\]]`)
+	filenameOf     = regexp.MustCompile(`(?i)^(?:\d+\s+)?of\s+\d+$`)
+)
+
+// scanDescriptors are tags that describe a scan rather than name who made it.
+var scanDescriptors = map[string]bool{
+	"digital": true, "webrip": true, "web": true, "c2c": true, "f": true, "fixed": true,
+	"hd": true, "hq": true, "lq": true, "scan": true, "noads": true, "no ads": true,
+}
+
+// ComicInfoFromFilename guesses series, issue number, volume and year from
+// the usual scene style names, e.g. "Saga v02 #014 (2013) (Digital).cbr".
+func ComicInfoFromFilename(name string) *ComicInfo {
+	stem := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	stem = strings.ReplaceAll(stem, "_", " ")
+
+	info := &ComicInfo{}
+	if m := filenameYear.FindStringSubmatch(stem); m != nil {
+		info.Year, _ = strconv.Atoi(m[1])
+	}
+	stem = filenameTags.ReplaceAllString(stem, " ")
+
+	if m := filenameVolume.FindStringSubmatchIndex(stem); m != nil {
+		info.Volume, _ = strconv.Atoi(stem[m[2]:m[3]])
+		stem = stem[:m[0]] + " " + stem[m[1]:]
+	}
+
+	stem = strings.TrimSpace(stem)
+	if m := filenameIssue.FindStringSubmatchIndex(stem); m != nil {
+		number := ""
+		if m[2] >= 0 {
+			number = stem[m[2]:m[3]]
+		} else {
+			number = stem[m[4]:m[5]]
+		}
+		// a lone number is more likely the title than an issue, e.g. "1984"
+		if m[0] > 0 {
+			info.Number = TrimIssueNumber(number)
+			stem = stem[:m[0]] + " " + stem[m[1]:]
+		}
+	}
+
+	info.Series = strings.Trim(filenameSpaces.ReplaceAllString(stem, " "), " -.,")
+	return info
+}
+
+// ScanGroupFromFilename guesses who made the scan from the tags of a scene
+// style name, the last one that isn't a year or describes the scan, e.g.
+// "Zone-Empire" for "Saga 001 (2012) (Digital) (Zone-Empire).cbr". The
+// "digital-" of tags like "(digital-Empire)" is dropped.
+func ScanGroupFromFilename(name string) string {
+	stem := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	tags := filenameTag.FindAllStringSubmatch(stem, -1)
+	for i := len(tags) - 1; i >= 0; i-- {
+		tag := strings.TrimSpace(tags[i][1])
+		lower := strings.ToLower(tag)
+		if group, ok := strings.CutPrefix(lower, "digital-"); ok && group != "" {
+			return tag[len(tag)-len(group):]
+		}
+		if tag == "" || scanDescriptors[lower] || filenameYear.MatchString(tags[i][0]) || filenameOf.MatchString(tag) {
+			continue
+		}
+		return tag
+	}
+	return ""
+}
+
+// TrimIssueNumber drops leading zeros, "007" is issue 7.
+func TrimIssueNumber(number string) string {
+	trimmed := strings.TrimLeft(number, "0")
+	if trimmed == "" || trimmed[0] == '.' {
+		trimmed = "0" + trimmed
+	}
+	return trimmed
+}
@@ -0,0 +1,42 @@
+package cbr2cbz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ComicInfoFromFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want ComicInfo
+	}{
+		{name: "Saga 001 (2012) (Digital) (Group).cbr", want: ComicInfo{Series: "Saga", Number: "1", Year: 2012}},
+		{name: "/comics/Saga v02 #014 (2013).cbr", want: ComicInfo{Series: "Saga", Number: "14", Volume: 2, Year: 2013}},
+		{name: "The_Walking_Dead_-_Vol._3_-_018.cbr", want: ComicInfo{Series: "The Walking Dead", Number: "18", Volume: 3}},
+		{name: "Invincible 000.cbr", want: ComicInfo{Series: "Invincible", Number: "0"}},
+		{name: "Spawn 12.5 [c2c].cbr", want: ComicInfo{Series: "Spawn", Number: "12.5"}},
+		{name: "Watchmen.cbr", want: ComicInfo{Series: "Watchmen"}},
+		{name: "1984.cbr", want: ComicInfo{Series: "1984"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, &tt.want, ComicInfoFromFilename(tt.name))
+		})
+	}
+}
+
+func Test_ScanGroupFromFilename(t *testing.T) {
+	tests := map[string]string{
+		"Saga 001 (2012) (Digital) (Zone-Empire).cbr": "Zone-Empire",
+		"Saga 001 (2012) (digital-Empire).cbr":        "Empire",
+		"Saga 001 [Group] (2012) (c2c).cbr":           "Group",
+		"Saga 01 (of 06) (2012) (Digital).cbr":        "",
+		"Saga 001.cbr":                                "",
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, want, ScanGroupFromFilename(name))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package cbr2cbz
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// flattenSeparator joins what is left of a nested path when entries can't
+// all go to the top of the cbz with just their base names.
+const flattenSeparator = " - "
+
+// flattenEntries moves every entry to the top of the cbz. Folders every
+// nested entry shares, like Comic Name/pages/, are simply dropped. Deeper folders,
+// like one per chapter, are kept in the name joined by flattenSeparator so
+// pages still sort chapter by chapter and can't clash. A name that is taken
+// anyway gets a number added.
+func (c *Converter) flattenEntries(cbrFile string, files []archiver.File) []archiver.File {
+	// entries already at the top, like a generated ComicInfo.xml, don't
+	// count towards the shared folders
+	prefix := ""
+	for _, f := range files {
+		if !strings.Contains(f.NameInArchive, "/") {
+			continue
+		}
+		if prefix == "" {
+			prefix = path.Dir(f.NameInArchive)
+		}
+		for prefix != "." && !strings.HasPrefix(f.NameInArchive, prefix+"/") {
+			prefix = path.Dir(prefix)
+		}
+	}
+
+	taken := map[string]bool{}
+	out := make([]archiver.File, len(files))
+	for i, f := range files {
+		out[i] = f
+		name := f.NameInArchive
+		if prefix != "" && prefix != "." {
+			name = strings.TrimPrefix(name, prefix+"/")
+		}
+		name = strings.ReplaceAll(name, "/", flattenSeparator)
+
+		if taken[strings.ToLower(name)] {
+			ext := path.Ext(name)
+			stem := strings.TrimSuffix(name, ext)
+			for n := 2; taken[strings.ToLower(name)]; n++ {
+				name = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+			}
+			c.warn(CodeEntryRenamed, cbrFile, "Flattening %s in %s to %s, the name was already taken", f.NameInArchive, cbrFile, name)
+		}
+		taken[strings.ToLower(name)] = true
+		out[i].NameInArchive = name
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package cbr2cbz
+
+import (
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_flattenEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "shared folders dropped",
+			files: []string{"Comic Name/pages/001.jpg", "Comic Name/pages/002.jpg", "ComicInfo.xml"},
+			want:  []string{"001.jpg", "002.jpg", "ComicInfo.xml"},
+		},
+		{
+			name:  "chapter folders kept in the name",
+			files: []string{"Series/ch1/001.jpg", "Series/ch1/002.jpg", "Series/ch2/001.jpg"},
+			want:  []string{"ch1 - 001.jpg", "ch1 - 002.jpg", "ch2 - 001.jpg"},
+		},
+		{
+			name:  "clash numbered",
+			files: []string{"001.jpg", "extra/001.jpg", "other/x.jpg"},
+			want:  []string{"001.jpg", "extra - 001.jpg", "other - x.jpg"},
+		},
+		{
+			name:  "taken after dropping the shared folder",
+			files: []string{"001.jpg", "Comic/001.jpg", "Comic/002.jpg"},
+			want:  []string{"001.jpg", "001 (2).jpg", "002.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []archiver.File{}
+			for _, name := range tt.files {
+				files = append(files, virtualFile(name, nil))
+			}
+			c := newTestConverter(t, Options{})
+			got := []string{}
+			for _, f := range c.flattenEntries("test.cbr", files) {
+				got = append(got, f.NameInArchive)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strings"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+	"github.com/pkg/errors"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+)
+
+// imageFormatExtensions maps the formats we can write to the extension the
+// page should end up with.
+var imageFormatExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+	"avif": ".avif",
+}
+
+// NormalizeFormats are the formats pages can be normalized to, the ones
+// every reader shows.
+var NormalizeFormats = map[string]bool{"jpeg": true, "png": true, "webp": true}
+
+// ImageOptions controls the per-page image pipeline. The zero value leaves
+// every page untouched.
+type ImageOptions struct {
+	// Format to re-encode pages as, empty keeps each page's own format.
+	Format string `json:"format,omitempty"`
+	// Normalize re-encodes only the pages that aren't in this format
+	// already, one of NormalizeFormats, so they all end up in it. Pages in
+	// it are left as they are.
+	Normalize string `json:"normalize,omitempty"`
+	// Quality for lossy formats, 1-100.
+	Quality int `json:"quality,omitempty"`
+	// MaxWidth and MaxHeight downscale pages to fit, zero means no limit.
+	MaxWidth  int `json:"max_width,omitempty"`
+	MaxHeight int `json:"max_height,omitempty"`
+	// Strips slices webtoon strips into pages ("slice") or joins pages into
+	// strips ("stitch"), empty leaves the layout alone.
+	Strips string `json:"strips,omitempty"`
+	// AutoRotate turns pages upright by their EXIF orientation.
+	AutoRotate bool `json:"auto_rotate,omitempty"`
+	// Grayscale drops the colour of pages, for e-ink screens that can't
+	// show it anyway.
+	Grayscale bool `json:"grayscale,omitempty"`
+	// Contrast stretches levels away from the middle grey by this factor
+	// and Gamma darkens midtones above 1 and lightens them below it. Zero
+	// or 1 leaves pages as they are.
+	Contrast float64 `json:"contrast,omitempty"`
+	Gamma    float64 `json:"gamma,omitempty"`
+}
+
+func (o ImageOptions) Enabled() bool {
+	return o.Format != "" || o.Normalize != "" || o.MaxWidth > 0 || o.MaxHeight > 0 || o.Strips != "" || o.AutoRotate || o.toned()
+}
+
+func (o ImageOptions) Validate() error {
+	if o.Format != "" {
+		if _, ok := imageFormatExtensions[o.Format]; !ok {
+			return errors.Errorf("unsupported image format %q", o.Format)
+		}
+	}
+	if o.Normalize != "" {
+		if !NormalizeFormats[o.Normalize] {
+			return errors.Errorf("pages can only be normalized to jpeg, png or webp, got %q", o.Normalize)
+		}
+		if o.Format != "" {
+			return errors.New("normalizing pages to a format and re-encoding them all as one can't be combined")
+		}
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return errors.Errorf("quality must be between 1 and 100, got %d", o.Quality)
+	}
+	if o.MaxWidth < 0 || o.MaxHeight < 0 {
+		return errors.New("max width and height can't be negative")
+	}
+	if o.Strips != "" && !stripLayouts[o.Strips] {
+		return errors.Errorf("strips must be slice or stitch, got %q", o.Strips)
+	}
+	if o.Contrast < 0 || o.Gamma < 0 {
+		return errors.New("contrast and gamma can't be negative")
+	}
+	return nil
+}
+
+// process runs a single page through the pipeline. It returns the page's new
+// name and contents, or the originals if there was nothing worth changing.
+func (o ImageOptions) Process(name string, data []byte) (string, []byte, error) {
+	if !o.Enabled() {
+		return name, data, nil
+	}
+
+	img, srcFormat, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return name, data, errors.Wrapf(err, "decoding %s", name)
+	}
+
+	// changed is whether the page looks any different now
+	changed := false
+	if o.AutoRotate {
+		if upright := orient(img, exifOrientation(data)); upright != img {
+			img = upright
+			changed = true
+		}
+	}
+
+	maxHeight := o.MaxHeight
+	if o.Strips == "stitch" && isStrip(img.Bounds()) {
+		// strips get scrolled, only their width has to fit the screen
+		maxHeight = 0
+	}
+
+	if scaled := fitWithin(img, o.MaxWidth, maxHeight); scaled != img {
+		img = scaled
+		changed = true
+	}
+	if toned := o.tone(img); toned != img {
+		img = toned
+		changed = true
+	}
+
+	format := o.Format
+	if format == "" {
+		format = srcFormat
+	}
+	if o.Normalize != "" {
+		format = o.Normalize
+	}
+	if _, ok := imageFormatExtensions[format]; !ok {
+		if !changed {
+			// nothing we can write it as and nothing changed, leave it be
+			return name, data, nil
+		}
+		format = "png"
+	}
+	if format == srcFormat && !changed && o.Format == "" {
+		return name, data, nil
+	}
+
+	out, err := encodeImage(img, format, o.Quality)
+	if err != nil {
+		return name, data, errors.Wrapf(err, "encoding %s", name)
+	}
+
+	if format == srcFormat && !changed && len(out) >= len(data) {
+		// re-encoding didn't buy us anything
+		return name, data, nil
+	}
+
+	return renameExt(name, imageFormatExtensions[format]), out, nil
+}
+
+func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var err error
+	switch format {
+	case "jpeg":
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(buf, img)
+	case "webp":
+		err = webp.Encode(buf, img, webp.Options{Quality: quality})
+	case "avif":
+		err = avif.Encode(buf, img, avif.Options{Quality: quality, QualityAlpha: quality})
+	default:
+		err = errors.Errorf("unsupported image format %q", format)
+	}
+	return buf.Bytes(), err
+}
+
+// fitWithin scales img down to fit in maxWidth x maxHeight, keeping its
+// aspect ratio. Images that already fit are returned as is.
+func fitWithin(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return img
+	}
+
+	scale := 1.0
+	if maxWidth > 0 && w > maxWidth {
+		scale = float64(maxWidth) / float64(w)
+	}
+	if maxHeight > 0 && float64(h)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(h)
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	dw := max(1, int(float64(w)*scale+0.5))
+	dh := max(1, int(float64(h)*scale+0.5))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func renameExt(name string, ext string) string {
+	current := path.Ext(name)
+	if strings.EqualFold(current, ext) || (ext == ".jpg" && strings.EqualFold(current, ".jpeg")) {
+		return name
+	}
+	return strings.TrimSuffix(name, current) + ext
+}
+
+// ImageProfiles are built in image settings for common reading devices.
+var ImageProfiles = map[string]ImageOptions{
+	"kobo":   {Format: "jpeg", Quality: 80, MaxWidth: 1264, MaxHeight: 1680, Strips: "slice"},
+	"kindle": {Format: "jpeg", Quality: 80, MaxWidth: 1236, MaxHeight: 1648, Strips: "slice"},
+	"tablet": {Format: "jpeg", Quality: 85, MaxWidth: 1600, MaxHeight: 2560, Strips: "stitch"},
+	// 6" e-ink readers: grayscale jpegs, darkened as e-ink shows them light
+	"eink": {Format: "jpeg", Quality: 80, MaxWidth: 1072, MaxHeight: 1448, Strips: "slice", Grayscale: true, Contrast: 1.1, Gamma: 1.8},
+}
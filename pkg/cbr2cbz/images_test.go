@@ -0,0 +1,130 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+)
+
+func makePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func Test_imageOptions_process(t *testing.T) {
+	page := makePNG(t, 200, 100)
+
+	tests := []struct {
+		name     string
+		opts     ImageOptions
+		wantName string
+		wantSize image.Point
+	}{
+		{name: "untouched", opts: ImageOptions{}, wantName: "001.png", wantSize: image.Pt(200, 100)},
+		{name: "downscale", opts: ImageOptions{MaxWidth: 100}, wantName: "001.png", wantSize: image.Pt(100, 50)},
+		{name: "fits already", opts: ImageOptions{MaxWidth: 400, MaxHeight: 400}, wantName: "001.png", wantSize: image.Pt(200, 100)},
+		{name: "to jpeg", opts: ImageOptions{Format: "jpeg", Quality: 80, MaxHeight: 50}, wantName: "001.jpg", wantSize: image.Pt(100, 50)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, out, err := tt.opts.Process("001.png", page)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantName, name)
+
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(out))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSize, image.Pt(cfg.Width, cfg.Height))
+		})
+	}
+}
+
+func Test_imageOptions_normalize(t *testing.T) {
+	img, _, err := image.Decode(bytes.NewReader(makePNG(t, 20, 10)))
+	require.NoError(t, err)
+	gifPage, bmpPage := &bytes.Buffer{}, &bytes.Buffer{}
+	require.NoError(t, gif.Encode(gifPage, img, nil))
+	require.NoError(t, bmp.Encode(bmpPage, img))
+	pngPage := makePNG(t, 20, 10)
+
+	opts := ImageOptions{Normalize: "png"}
+	require.NoError(t, opts.Validate())
+	for _, page := range []struct {
+		name string
+		data []byte
+	}{{"001.gif", gifPage.Bytes()}, {"002.bmp", bmpPage.Bytes()}, {"003.png", pngPage}} {
+		name, out, err := opts.Process(page.name, page.data)
+		require.NoError(t, err)
+		require.Equal(t, page.name[:4]+"png", name)
+		_, format, err := image.DecodeConfig(bytes.NewReader(out))
+		require.NoError(t, err)
+		require.Equal(t, "png", format)
+	}
+	// pages in the format already are left as they were
+	_, out, err := opts.Process("003.png", pngPage)
+	require.NoError(t, err)
+	require.Equal(t, pngPage, out)
+
+	require.ErrorContains(t, ImageOptions{Normalize: "avif"}.Validate(), "jpeg, png or webp")
+	require.ErrorContains(t, ImageOptions{Normalize: "png", Format: "jpeg"}.Validate(), "can't be combined")
+}
+
+func Test_arrangeStrips(t *testing.T) {
+	size := func(t *testing.T, p PageImage) image.Point {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(p.Data))
+		require.NoError(t, err)
+		return image.Pt(cfg.Width, cfg.Height)
+	}
+
+	t.Run("slice", func(t *testing.T) {
+		pages := []PageImage{{Name: "001.png", Data: makePNG(t, 100, 150)}, {Name: "002.png", Data: makePNG(t, 100, 1000)}}
+		out, err := ImageOptions{Strips: "slice"}.ArrangeStrips(pages)
+		require.NoError(t, err)
+		require.Len(t, out, 8)
+		require.Equal(t, "001.png", out[0].Name)
+		require.Equal(t, "002-1.png", out[1].Name)
+		total := 0
+		for _, p := range out[1:] {
+			s := size(t, p)
+			require.Equal(t, 100, s.X)
+			require.InDelta(t, 143, s.Y, 30, "cuts move at most a tenth of a slice")
+			total += s.Y
+		}
+		require.Equal(t, 1000, total)
+	})
+
+	t.Run("stitch", func(t *testing.T) {
+		pages := []PageImage{
+			{Name: "001.png", Data: makePNG(t, 100, 400)},
+			{Name: "002.png", Data: makePNG(t, 100, 150)},
+			{Name: "003.png", Data: makePNG(t, 80, 150)},
+		}
+		out, err := ImageOptions{Strips: "stitch"}.ArrangeStrips(pages)
+		require.NoError(t, err)
+		require.Len(t, out, 2)
+		require.Equal(t, "001.png", out[0].Name)
+		require.Equal(t, image.Pt(100, 550), size(t, out[0]))
+		require.Equal(t, "003.png", out[1].Name)
+	})
+
+	t.Run("stitch leaves regular comics alone", func(t *testing.T) {
+		pages := []PageImage{{Name: "001.png", Data: makePNG(t, 100, 150)}, {Name: "002.png", Data: makePNG(t, 100, 150)}}
+		out, err := ImageOptions{Strips: "stitch"}.ArrangeStrips(pages)
+		require.NoError(t, err)
+		require.Equal(t, pages, out)
+	})
+}
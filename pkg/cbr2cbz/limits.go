@@ -0,0 +1,93 @@
+package cbr2cbz
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+// ErrDecompressionLimit marks archives that expand more than they should,
+// like zip bombs or entries lying about their size.
+var ErrDecompressionLimit = errors.New("archive exceeds decompression limits")
+
+// Limits bounds how much data a single archive may decompress to.
+// Zero values disable the corresponding check.
+type Limits struct {
+	// MaxEntry is the most any one entry may decompress to.
+	MaxEntry uint64
+	// MaxRatio is the most the whole archive may decompress to, as a
+	// multiple of its size on disk.
+	MaxRatio float64
+}
+
+// archiveBudget tracks decompressed bytes for one archive against the limits.
+type archiveBudget struct {
+	limits      Limits
+	archiveSize uint64
+	declared    uint64
+	total       atomic.Uint64
+}
+
+func (l Limits) forArchive(size int64) *archiveBudget {
+	return &archiveBudget{limits: l, archiveSize: uint64(size)}
+}
+
+// MaxTotal is the most an archive of size may decompress to, 0 for no
+// limit.
+func (l Limits) MaxTotal(size int64) uint64 {
+	return l.forArchive(size).maxTotal()
+}
+
+func (b *archiveBudget) maxTotal() uint64 {
+	if b.limits.MaxRatio <= 0 {
+		return 0
+	}
+	return uint64(float64(b.archiveSize) * b.limits.MaxRatio)
+}
+
+// declare checks an entry's declared size before anything is extracted.
+func (b *archiveBudget) declare(name string, size int64) error {
+	if size < 0 {
+		return errors.Wrapf(ErrDecompressionLimit, "%s declares a negative size", name)
+	}
+	if b.limits.MaxEntry > 0 && uint64(size) > b.limits.MaxEntry {
+		return errors.Wrapf(ErrDecompressionLimit, "%s declares %s, over the %s per entry limit",
+			name, humanize.Bytes(uint64(size)), humanize.Bytes(b.limits.MaxEntry))
+	}
+	b.declared += uint64(size)
+	if max := b.maxTotal(); max > 0 && b.declared > max {
+		return errors.Wrapf(ErrDecompressionLimit, "entries declare %s, over %.0fx the archive size",
+			humanize.Bytes(b.declared), b.limits.MaxRatio)
+	}
+	return nil
+}
+
+// guard wraps an entry's reader so it fails once the entry produces more
+// than it declared, or the archive as a whole goes over budget.
+func (b *archiveBudget) guard(name string, declared int64, rc io.ReadCloser) io.ReadCloser {
+	return &guardedReader{ReadCloser: rc, budget: b, name: name, declared: uint64(declared)}
+}
+
+type guardedReader struct {
+	io.ReadCloser
+	budget   *archiveBudget
+	name     string
+	declared uint64
+	read     uint64
+}
+
+func (r *guardedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += uint64(n)
+	total := r.budget.total.Add(uint64(n))
+
+	if r.read > r.declared {
+		return n, errors.Wrapf(ErrDecompressionLimit, "%s decompressed to more than its declared %s", r.name, humanize.Bytes(r.declared))
+	}
+	if max := r.budget.maxTotal(); max > 0 && total > max {
+		return n, errors.Wrapf(ErrDecompressionLimit, "archive decompressed to more than %.0fx its size", r.budget.limits.MaxRatio)
+	}
+	return n, err
+}
@@ -0,0 +1,28 @@
+package cbr2cbz
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_archiveBudget(t *testing.T) {
+	limits := Limits{MaxEntry: 100, MaxRatio: 2}
+
+	b := limits.forArchive(75)
+	require.NoError(t, b.declare("001.jpg", 100))
+	require.ErrorIs(t, b.declare("002.jpg", 101), ErrDecompressionLimit, "entry over the per entry limit")
+	require.ErrorIs(t, b.declare("003.jpg", 100), ErrDecompressionLimit, "archive over the expansion ratio")
+
+	b = limits.forArchive(100)
+	liar := b.guard("001.jpg", 5, io.NopCloser(strings.NewReader("much more than five bytes")))
+	_, err := io.ReadAll(liar)
+	require.ErrorIs(t, err, ErrDecompressionLimit, "entry bigger than it declared")
+
+	b = limits.forArchive(100)
+	honest := b.guard("001.jpg", 5, io.NopCloser(strings.NewReader("five!")))
+	_, err = io.ReadAll(honest)
+	require.NoError(t, err)
+}
@@ -0,0 +1,156 @@
+package cbr2cbz
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// Issue is one of the archives Merge puts together, its entries as Entries
+// or ZipEntries list them.
+type Issue struct {
+	Name  string
+	Files []archiver.File
+}
+
+// Merge puts the pages of issues together as the entries of one volume,
+// named volume, in the order given. Each page is renamed after its issue
+// and its place in it, c01_001.jpg, c01_002.jpg, c02_001.jpg and so on, so
+// they sort that way whatever the scanners called them. Entries that aren't
+// pages are left out. With info the volume gets a ComicInfo.xml made from
+// those of the issues, with a bookmark where each one starts.
+func (c *Converter) Merge(volume string, issues []Issue, info bool) ([]archiver.File, error) {
+	chapterDigits := max(len(fmt.Sprint(len(issues))), 2)
+	merged := []archiver.File{}
+	infos := make([]*ComicInfo, len(issues))
+	starts := make([]int, len(issues))
+	for n, issue := range issues {
+		pages := c.pageIndexes(issue.Files)
+		digits := max(len(fmt.Sprint(len(pages))), 3)
+		starts[n] = len(merged)
+		for i, idx := range pages {
+			f := issue.Files[idx]
+			f.NameInArchive = fmt.Sprintf("c%0*d_%0*d%s", chapterDigits, n+1, digits, i+1, strings.ToLower(path.Ext(f.NameInArchive)))
+			merged = append(merged, f)
+		}
+
+		for _, f := range issue.Files {
+			if !IsComicInfo(f.NameInArchive) {
+				continue
+			}
+			if parsed, err := readComicInfo(f); err == nil {
+				infos[n] = parsed
+			} else {
+				c.warn(CodeComicInfoIgnored, issue.Name, "Ignoring the ComicInfo.xml of %s: %s", issue.Name, err)
+			}
+			break
+		}
+	}
+	if !info {
+		return merged, nil
+	}
+
+	data, err := mergeComicInfo(volume, issues, infos, starts, merged).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(merged, datedVirtualFile(ComicInfoName, data, newestModTime(merged))), nil
+}
+
+func readComicInfo(f archiver.File) (*ComicInfo, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ParseComicInfo(rc)
+}
+
+// mergeComicInfo is the ComicInfo.xml of a volume of issues, infos being
+// theirs where they had one and starts where their pages begin in pages.
+// What the volume's name doesn't say comes from the first issue that does,
+// the credits of all of them are put together.
+func mergeComicInfo(volume string, issues []Issue, infos []*ComicInfo, starts []int, pages []archiver.File) *ComicInfo {
+	merged := ComicInfoFromFilename(volume)
+	merged.PageCount = len(pages)
+	for i, f := range pages {
+		merged.page(i).ImageSize = f.Size()
+	}
+
+	numbers := []string{}
+	summaries := []string{}
+	for n, info := range infos {
+		if starts[n] < len(pages) && (n+1 == len(starts) || starts[n+1] > starts[n]) {
+			merged.page(starts[n]).Bookmark = issueTitle(issues[n].Name, info)
+		}
+		if info == nil {
+			continue
+		}
+		firstOf(&merged.Series, info.Series)
+		firstOf(&merged.Publisher, info.Publisher)
+		firstOf(&merged.Genre, info.Genre)
+		firstOf(&merged.LanguageISO, info.LanguageISO)
+		firstOf(&merged.Manga, info.Manga)
+		if merged.Year == 0 && info.Year != 0 {
+			merged.Year, merged.Month, merged.Day = info.Year, info.Month, info.Day
+		}
+		merged.Writer = joinNames(merged.Writer, info.Writer)
+		merged.Penciller = joinNames(merged.Penciller, info.Penciller)
+		merged.Inker = joinNames(merged.Inker, info.Inker)
+		merged.Colorist = joinNames(merged.Colorist, info.Colorist)
+		merged.Letterer = joinNames(merged.Letterer, info.Letterer)
+		merged.CoverArtist = joinNames(merged.CoverArtist, info.CoverArtist)
+		merged.Editor = joinNames(merged.Editor, info.Editor)
+		if info.Number != "" {
+			numbers = append(numbers, info.Number)
+		}
+		if info.Summary != "" {
+			summaries = append(summaries, info.Summary)
+		}
+	}
+	if merged.Number == "" && len(numbers) > 0 {
+		merged.Number = numbers[0]
+		if len(numbers) > 1 {
+			merged.Number += "-" + numbers[len(numbers)-1]
+		}
+	}
+	merged.Summary = strings.Join(summaries, "\n\n")
+	return merged
+}
+
+// issueTitle is what an issue's bookmark says, its title, its number or
+// failing those its file name.
+func issueTitle(name string, info *ComicInfo) string {
+	switch {
+	case info != nil && info.Title != "":
+		return info.Title
+	case info != nil && info.Number != "":
+		return "Issue " + info.Number
+	}
+	return strings.TrimSuffix(path.Base(name), path.Ext(name))
+}
+
+// firstOf sets to to value unless it has one already.
+func firstOf(to *string, value string) {
+	if *to == "" {
+		*to = value
+	}
+}
+
+// joinNames adds the comma separated names in more to those in names,
+// leaving out any it has already.
+func joinNames(names string, more string) string {
+	all := []string{}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names+","+more, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		all = append(all, name)
+	}
+	return strings.Join(all, ", ")
+}
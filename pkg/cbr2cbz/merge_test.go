@@ -0,0 +1,85 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Merge(t *testing.T) {
+	issue := func(name string, info string, pages ...string) Issue {
+		files := []archiver.File{}
+		for _, page := range pages {
+			files = append(files, virtualFile(page, []byte(page)))
+		}
+		if info != "" {
+			files = append(files, virtualFile(ComicInfoName, []byte(info)))
+		}
+		return Issue{Name: name, Files: files}
+	}
+	issues := []Issue{
+		issue("Saga 001.cbz", "<ComicInfo><Title>Chapter One</Title><Series>Saga</Series><Number>1</Number><Publisher>Image</Publisher><Year>2012</Year><Month>3</Month><Writer>Brian K. Vaughan</Writer><Summary>It begins.</Summary></ComicInfo>",
+			"Saga 001/cover.JPG", "Saga 001/page2.png", "notes.txt"),
+		issue("Saga 002.cbr", "<ComicInfo><Series>Saga</Series><Number>2</Number><Writer>Brian K. Vaughan, Fiona Staples</Writer></ComicInfo>",
+			"p1.jpg"),
+		issue("Saga 003.cbr", "", "p1.jpg", "p2.jpg"),
+	}
+
+	c := newTestConverter(t, Options{})
+	merged, err := c.Merge("Saga Vol 1.cbz", issues, false)
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range merged {
+		names = append(names, f.NameInArchive)
+	}
+	require.Equal(t, []string{"c01_001.jpg", "c01_002.png", "c02_001.jpg", "c03_001.jpg", "c03_002.jpg"}, names)
+	// the issues are left alone
+	require.Equal(t, "Saga 001/cover.JPG", issues[0].Files[0].NameInArchive)
+
+	merged, err = c.Merge("Saga Vol 1.cbz", issues, true)
+	require.NoError(t, err)
+	require.Len(t, merged, 6)
+	require.Equal(t, ComicInfoName, merged[5].NameInArchive)
+	rc, err := merged[5].Open()
+	require.NoError(t, err)
+	info, err := ParseComicInfo(rc)
+	require.NoError(t, err)
+
+	require.Equal(t, "Saga", info.Series)
+	require.Equal(t, 1, info.Volume)
+	require.Equal(t, "1-2", info.Number)
+	require.Equal(t, "Image", info.Publisher)
+	require.Equal(t, 2012, info.Year)
+	require.Equal(t, 3, info.Month)
+	require.Equal(t, "Brian K. Vaughan, Fiona Staples", info.Writer)
+	require.Equal(t, "It begins.", info.Summary)
+	require.Equal(t, 5, info.PageCount)
+	bookmarks := map[int]string{}
+	for _, p := range info.Pages.Page {
+		if p.Bookmark != "" {
+			bookmarks[p.Image] = p.Bookmark
+		}
+	}
+	require.Equal(t, map[int]string{0: "Chapter One", 2: "Issue 2", 3: "Saga 003"}, bookmarks)
+}
+
+func Test_Merge_badComicInfo(t *testing.T) {
+	warnings := []string{}
+	c := newTestConverter(t, Options{})
+	c.OnWarning = func(w Warning) { warnings = append(warnings, w.Code) }
+
+	files := []archiver.File{virtualFile("001.jpg", nil), virtualFile(ComicInfoName, []byte("<ComicInfo"))}
+	merged, err := c.Merge("volume.cbz", []Issue{{Name: "a.cbz", Files: files}, {Name: "b.cbz", Files: files}}, true)
+	require.NoError(t, err)
+	require.Len(t, merged, 3)
+	require.Equal(t, []string{CodeComicInfoIgnored, CodeComicInfoIgnored}, warnings)
+
+	rc, err := merged[2].Open()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(rc)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `Bookmark="b"`)
+}
@@ -0,0 +1,76 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+// nestedExtensions are the entries Options.RecurseArchives unpacks, compared
+// lower case.
+var nestedExtensions = map[string]bool{
+	".cbz": true, ".cbr": true, ".cb7": true, ".cbt": true,
+	".zip": true, ".rar": true, ".7z": true, ".tar": true,
+}
+
+// maxNesting is how many archives deep nested archives are unpacked.
+const maxNesting = 3
+
+func isNestedArchive(name string) bool {
+	return nestedExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// nestedEntries lists the entries of the archive f inside cbrFile, one
+// level below depth, as if they were in a folder named after it: the pages
+// of "Chapter 01.cbz" become "Chapter 01/001.jpg" and so on. The nested
+// archive is read into memory, its entries are opened from there. One that
+// can't be read fails the archive, or is left out with a warning when bad
+// entries are skipped.
+func (c *Converter) nestedEntries(ctx context.Context, cbrFile string, f archiver.File, de fs.DirEntry, budget *archiveBudget, progress *Progress, depth int) ([]archiver.File, error) {
+	name := f.NameInArchive
+	info, err := de.Info()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to look up file")
+	}
+	err = budget.declare(name, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	data, err := readNested(f.Open, budget, name, info.Size())
+	var files []archiver.File
+	if err == nil {
+		files, err = c.listArchive(ctx, cbrFile, bytes.NewReader(data), int64(len(data)), budget, progress, depth+1)
+	}
+	if errors.Is(err, ErrDecompressionLimit) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	if err != nil && c.opts.SkipBadEntries {
+		c.warn(CodeNestedDropped, cbrFile, "Dropping nested %s from %s, it can't be read: %s", name, cbrFile, err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading nested %s", name)
+	}
+
+	prefix := strings.TrimSuffix(name, path.Ext(name)) + "/"
+	for i := range files {
+		files[i].NameInArchive = prefix + files[i].NameInArchive
+	}
+	return files, nil
+}
+
+func readNested(open func() (io.ReadCloser, error), budget *archiveBudget, name string, size int64) ([]byte, error) {
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+	rc := budget.guard(name, size, f)
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,101 @@
+package cbr2cbz
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func nestedZip(t *testing.T, names ...string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// nestedTar is a tar of entries in the order given, names and contents.
+func nestedTar(t *testing.T, entries ...any) *bytes.Reader {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for i := 0; i < len(entries); i += 2 {
+		data := entries[i+1].([]byte)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: entries[i].(string), Mode: 0o644, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+func Test_Converter_Entries_nested(t *testing.T) {
+	src := nestedTar(t,
+		"cover.jpg", []byte("cover"),
+		"Chapter 10.zip", nestedZip(t, "002.jpg", "001.jpg"),
+		"Chapter 2.cbz", nestedZip(t, "001.jpg", "notes.nfo"),
+	)
+
+	for _, order := range []string{"natural", "archive"} {
+		t.Run(order, func(t *testing.T) {
+			c := newTestConverter(t, Options{RecurseArchives: true, PageOrder: order})
+			files, err := c.Entries(context.Background(), "omnibus.cbt", src, src.Size(), &Progress{})
+			require.NoError(t, err)
+			names := []string{}
+			for _, f := range files {
+				names = append(names, f.NameInArchive)
+			}
+			want := []string{"Chapter 2/001.jpg", "Chapter 10/001.jpg", "Chapter 10/002.jpg", "cover.jpg"}
+			if order == "archive" {
+				want = []string{"cover.jpg", "Chapter 10/002.jpg", "Chapter 10/001.jpg", "Chapter 2/001.jpg"}
+			}
+			require.Equal(t, want, names)
+
+			rc, err := files[1].Open()
+			require.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			require.Equal(t, want[1][len("Chapter 10/"):], string(data))
+		})
+	}
+}
+
+func Test_Converter_Entries_nestedBroken(t *testing.T) {
+	src := nestedTar(t,
+		"001.jpg", []byte("page"),
+		"extras.zip", []byte("not a zip"),
+	)
+
+	c := newTestConverter(t, Options{RecurseArchives: true})
+	_, err := c.Entries(context.Background(), "omnibus.cbt", src, src.Size(), &Progress{})
+	require.ErrorContains(t, err, "reading nested extras.zip")
+
+	var warnings []Warning
+	c = newTestConverter(t, Options{RecurseArchives: true, SkipBadEntries: true})
+	c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+	files, err := c.Entries(context.Background(), "omnibus.cbt", src, src.Size(), &Progress{})
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Len(t, warnings, 1)
+	require.Equal(t, CodeNestedDropped, warnings[0].Code)
+
+	warnings = nil
+	c = newTestConverter(t, Options{})
+	c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+	files, err = c.Entries(context.Background(), "omnibus.cbt", src, src.Size(), &Progress{})
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Len(t, warnings, 1)
+	require.Equal(t, CodeEntryDropped, warnings[0].Code)
+	require.Contains(t, warnings[0].Message, "--recurse-archives")
+}
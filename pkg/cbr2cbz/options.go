@@ -0,0 +1,101 @@
+package cbr2cbz
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// Options is every setting that changes what ends up inside a cbz. The
+// command records them next to each converted file so a later run can tell
+// how an output was produced and redo only what needs it. The zero value
+// packs pages as they are, folder by folder.
+type Options struct {
+	// ImageExtensions are the entries packed as pages, DefaultImageExtensions
+	// when empty.
+	ImageExtensions []string `json:"image_extensions"`
+	// KeepFiles are glob patterns of other entries to keep, DefaultKeepFiles
+	// when nil.
+	KeepFiles    []string `json:"keep_files"`
+	Bookmarks    bool     `json:"bookmarks,omitempty"`
+	MarkCover    bool     `json:"mark_cover,omitempty"`
+	GenerateInfo bool     `json:"generate_comicinfo,omitempty"`
+	CoverFirst   bool     `json:"cover_first,omitempty"`
+	PadNumbers   bool     `json:"pad_numbers,omitempty"`
+	Renumber     bool     `json:"renumber,omitempty"`
+	StripJunk    bool     `json:"strip_junk,omitempty"`
+	Flatten      bool     `json:"flatten,omitempty"`
+	// StripWrappers takes out folders that only wrap another level, like
+	// Comic Name/Comic Name/
+	StripWrappers bool `json:"strip_wrappers,omitempty"`
+	// DropDuplicatePages leaves out pages with exactly the contents of an
+	// earlier one
+	DropDuplicatePages bool `json:"drop_duplicate_pages,omitempty"`
+	// RecurseArchives unpacks archives inside the archive into a folder each
+	RecurseArchives bool `json:"recurse_archives,omitempty"`
+	// SkipBadEntries leaves out entries that can't be read instead of
+	// failing the archive, Placeholders puts a page in place of each
+	SkipBadEntries bool `json:"skip_bad_entries,omitempty"`
+	Placeholders   bool `json:"placeholder_pages,omitempty"`
+	// StripComments leaves the archive comment out of the cbz instead of
+	// carrying it over
+	StripComments bool `json:"strip_comments,omitempty"`
+	// PageOrder is one of PageOrders, empty for folder order. It is left
+	// out for folder order, the only one before it existed, so fingerprints
+	// from back then still match
+	PageOrder string `json:"page_order,omitempty"`
+	// Compression is one of Compressions, empty for default. Like
+	// PageOrder, the default is left out
+	Compression string `json:"compression,omitempty"`
+	// EntryEncoding is one of EntryEncodings, what entry names that aren't
+	// UTF-8 are read as, empty to guess it for each archive like auto
+	EntryEncoding string `json:"entry_encoding,omitempty"`
+	// Images is how pages get re-encoded and scaled, nil when they are
+	// packed as is
+	Images *ImageOptions `json:"images,omitempty"`
+}
+
+// normalize fills in the defaults the way entries and images ended up,
+// so equivalent options record the same.
+func (o Options) normalize(entries EntryFilter, images ImageOptions) Options {
+	imageExts := []string{}
+	for ext := range entries.imageExts {
+		imageExts = append(imageExts, ext)
+	}
+	if len(imageExts) == 0 {
+		imageExts = append(imageExts, DefaultImageExtensions...)
+	}
+	sort.Strings(imageExts)
+	o.ImageExtensions = imageExts
+
+	if o.KeepFiles == nil {
+		o.KeepFiles = DefaultKeepFiles
+	}
+	if o.PageOrder == "folder" {
+		o.PageOrder = ""
+	}
+	if o.Compression == "default" {
+		o.Compression = ""
+	}
+	if o.EntryEncoding == "auto" {
+		o.EntryEncoding = ""
+	}
+	o.Images = nil
+	if images.Enabled() {
+		o.Images = &images
+	}
+	return o
+}
+
+func (o Options) String() string {
+	out, _ := json.Marshal(o)
+	return string(out)
+}
+
+// Fingerprint is a short stable id for the option set, handy for comparing
+// runs without diffing the whole thing.
+func (o Options) Fingerprint() string {
+	sum := sha256.Sum256([]byte(o.String()))
+	return hex.EncodeToString(sum[:6])
+}
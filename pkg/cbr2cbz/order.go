@@ -0,0 +1,101 @@
+package cbr2cbz
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+// PageOrders are the values --page-order accepts. folder is the order
+// entries used to be packed in: folder by folder, names compared byte by
+// byte, the way fs.WalkDir visits them. An empty order means folder too.
+var PageOrders = map[string]bool{"natural": true, "byte": true, "folder": true, "archive": true}
+
+// DefaultPageOrder reads badly named scans right, page2 before page10.
+const DefaultPageOrder = "natural"
+
+// sortEntries puts files in the order named by order. archive keeps the
+// order entries are stored in the source, which has to be read from src.
+func sortEntries(ctx context.Context, order string, files []archiver.File, format archiver.Archival, src io.ReaderAt, size int64) error {
+	var less func(a, b string) bool
+	switch order {
+	case "", "folder":
+		less = func(a, b string) bool { return compareComponents(a, b, strings.Compare) < 0 }
+	case "natural":
+		less = func(a, b string) bool { return compareComponents(a, b, compareNatural) < 0 }
+	case "byte":
+		less = func(a, b string) bool { return a < b }
+	case "archive":
+		positions, err := archiveOrder(ctx, format, src, size)
+		if err != nil {
+			return err
+		}
+		less = func(a, b string) bool { return positions[a] < positions[b] }
+	default:
+		return errors.Errorf("unknown page order %q", order)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return less(files[i].NameInArchive, files[j].NameInArchive)
+	})
+	return nil
+}
+
+// archiveOrder returns the position of every entry as stored in the archive.
+func archiveOrder(ctx context.Context, format archiver.Archival, src io.ReaderAt, size int64) (map[string]int, error) {
+	positions := map[string]int{}
+	err := format.Extract(ctx, io.NewSectionReader(src, 0, size), nil, func(_ context.Context, f archiver.File) error {
+		positions[strings.TrimPrefix(f.NameInArchive, "./")] = len(positions)
+		return nil
+	})
+	return positions, errors.Wrap(err, "reading entry order")
+}
+
+// compareComponents compares paths a folder at a time, so everything in a
+// folder sorts together.
+func compareComponents(a, b string, cmp func(a, b string) int) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := cmp(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// compareNatural compares case insensitively with runs of digits compared by
+// value, so page2 comes before page10.
+func compareNatural(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) - len(nb)
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return int(a[0]) - int(b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
@@ -0,0 +1,56 @@
+package cbr2cbz
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sortEntries(t *testing.T) {
+	stored := []string{"page10.jpg", "Page2.jpg", "b/001.jpg", "page1.jpg", "a b/001.jpg"}
+
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	for _, name := range stored {
+		require.NoError(t, w.WriteHeader(&tar.Header{Name: name, Mode: 0644}))
+	}
+	require.NoError(t, w.Close())
+	src := bytes.NewReader(buf.Bytes())
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{order: "folder", want: []string{"Page2.jpg", "a b/001.jpg", "b/001.jpg", "page1.jpg", "page10.jpg"}},
+		{order: "byte", want: []string{"Page2.jpg", "a b/001.jpg", "b/001.jpg", "page1.jpg", "page10.jpg"}},
+		{order: "natural", want: []string{"a b/001.jpg", "b/001.jpg", "page1.jpg", "Page2.jpg", "page10.jpg"}},
+		{order: "archive", want: stored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			files := []archiver.File{}
+			for _, name := range []string{"page1.jpg", "b/001.jpg", "page10.jpg", "a b/001.jpg", "Page2.jpg"} {
+				files = append(files, archiver.File{NameInArchive: name})
+			}
+			require.NoError(t, sortEntries(context.Background(), tt.order, files, archiver.Tar{}, src, src.Size()))
+
+			names := []string{}
+			for _, f := range files {
+				names = append(names, f.NameInArchive)
+			}
+			require.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func Test_compareComponents(t *testing.T) {
+	// the order fs.WalkDir visits entries in
+	require.Negative(t, compareComponents("a/b.jpg", "a.jpg", strings.Compare))
+	require.Positive(t, compareComponents("a/b/c.jpg", "a/b", strings.Compare))
+	require.Zero(t, compareNatural("Page002", "page2"))
+}
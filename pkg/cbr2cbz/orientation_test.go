@@ -0,0 +1,103 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// makeSideways is a 64x32 jpeg with a dark top left corner and the given
+// EXIF orientation, 0 for none.
+func makeSideways(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, 64, 32))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 32; y++ {
+			if x >= 16 || y >= 16 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(buf, img, &jpeg.Options{Quality: 95}))
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+
+	// a little endian TIFF with one IFD holding only the orientation
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	app1 := []byte{0xff, 0xe1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(2+6+len(tiff)))
+	app1 = append(app1, "Exif\x00\x00"...)
+	app1 = append(app1, tiff...)
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func Test_exifOrientation(t *testing.T) {
+	require.Equal(t, 6, exifOrientation(makeSideways(t, 6)))
+	require.Equal(t, 3, exifOrientation(makeSideways(t, 3)))
+	require.Equal(t, 1, exifOrientation(makeSideways(t, 0)))
+	require.Equal(t, 1, exifOrientation(makeSideways(t, 9)))
+	require.Equal(t, 1, exifOrientation(makePNG(t, 10, 10)))
+	require.Equal(t, 1, exifOrientation([]byte{0xff, 0xd8, 0xff, 0xe1, 0xff}))
+}
+
+func Test_orient(t *testing.T) {
+	dark := func(img image.Image, x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r < 0x4000
+	}
+	tests := []struct {
+		orientation int
+		size        image.Point
+		// where the dark corner ends up
+		corner image.Point
+	}{
+		{orientation: 1, size: image.Pt(64, 32), corner: image.Pt(0, 0)},
+		{orientation: 2, size: image.Pt(64, 32), corner: image.Pt(63, 0)},
+		{orientation: 3, size: image.Pt(64, 32), corner: image.Pt(63, 31)},
+		{orientation: 4, size: image.Pt(64, 32), corner: image.Pt(0, 31)},
+		{orientation: 5, size: image.Pt(32, 64), corner: image.Pt(0, 0)},
+		{orientation: 6, size: image.Pt(32, 64), corner: image.Pt(31, 0)},
+		{orientation: 7, size: image.Pt(32, 64), corner: image.Pt(31, 63)},
+		{orientation: 8, size: image.Pt(32, 64), corner: image.Pt(0, 63)},
+	}
+	src, err := jpeg.Decode(bytes.NewReader(makeSideways(t, 0)))
+	require.NoError(t, err)
+	for _, tt := range tests {
+		img := orient(src, tt.orientation)
+		require.Equal(t, tt.size, img.Bounds().Size(), "orientation %d", tt.orientation)
+		require.True(t, dark(img, tt.corner.X, tt.corner.Y), "orientation %d", tt.orientation)
+		require.False(t, dark(img, tt.size.X/2, tt.size.Y/2), "orientation %d", tt.orientation)
+	}
+}
+
+func Test_imageOptions_autoRotate(t *testing.T) {
+	opts := ImageOptions{Quality: 85, AutoRotate: true}
+
+	name, out, err := opts.Process("001.jpg", makeSideways(t, 6))
+	require.NoError(t, err)
+	require.Equal(t, "001.jpg", name)
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(out))
+	require.NoError(t, err)
+	require.Equal(t, image.Pt(32, 64), image.Pt(cfg.Width, cfg.Height))
+
+	upright := makeSideways(t, 0)
+	_, out, err = opts.Process("001.jpg", upright)
+	require.NoError(t, err)
+	require.Equal(t, upright, out)
+}
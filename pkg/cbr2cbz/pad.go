@@ -0,0 +1,63 @@
+package cbr2cbz
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+var digitRuns = regexp.MustCompile(`[0-9]+`)
+
+// padPageNumbers zero-pads the runs of digits in page names so they sort by
+// byte the way they read, 2.jpg becoming 02.jpg next to 10.jpg. Everything
+// else in the name, like scanner credits, is left as it is. Runs are padded
+// per folder, the nth run of every name to the widest nth run among its
+// siblings, so a year in every name doesn't stretch the page number.
+func (c *Converter) padPageNumbers(cbrFile string, files []archiver.File) []archiver.File {
+	widths := map[string][]int{}
+	for _, f := range files {
+		if c.entries.Classify(f.NameInArchive) != EntryPage {
+			continue
+		}
+		dir := path.Dir(f.NameInArchive)
+		for i, run := range digitRuns.FindAllString(path.Base(f.NameInArchive), -1) {
+			if i == len(widths[dir]) {
+				widths[dir] = append(widths[dir], 0)
+			}
+			widths[dir][i] = max(widths[dir][i], len(run))
+		}
+	}
+
+	taken := map[string]bool{}
+	for _, f := range files {
+		taken[f.NameInArchive] = true
+	}
+
+	out := make([]archiver.File, len(files))
+	for i, f := range files {
+		out[i] = f
+		if c.entries.Classify(f.NameInArchive) != EntryPage {
+			continue
+		}
+		dir := path.Dir(f.NameInArchive)
+		n := 0
+		base := digitRuns.ReplaceAllStringFunc(path.Base(f.NameInArchive), func(run string) string {
+			width := widths[dir][n]
+			n++
+			return strings.Repeat("0", width-len(run)) + run
+		})
+		name := path.Join(dir, base)
+		if name == f.NameInArchive {
+			continue
+		}
+		if taken[name] {
+			c.warn(CodeRenameSkipped, cbrFile, "Not padding %s in %s, %s is already in the archive", f.NameInArchive, cbrFile, name)
+			continue
+		}
+		taken[name] = true
+		out[i].NameInArchive = name
+	}
+	return out
+}
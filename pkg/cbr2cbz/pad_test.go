@@ -0,0 +1,51 @@
+package cbr2cbz
+
+import (
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_padPageNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "page numbers",
+			files: []string{"1.jpg", "2.jpg", "10.jpg"},
+			want:  []string{"01.jpg", "02.jpg", "10.jpg"},
+		},
+		{
+			name:  "credits kept",
+			files: []string{"Saga 2019 p5 [scanner].jpg", "Saga 2019 p12 [scanner].jpg"},
+			want:  []string{"Saga 2019 p05 [scanner].jpg", "Saga 2019 p12 [scanner].jpg"},
+		},
+		{
+			name:  "per folder",
+			files: []string{"ch1/1.jpg", "ch1/2.jpg", "ch2/1.jpg", "ch2/100.jpg"},
+			want:  []string{"ch1/1.jpg", "ch1/2.jpg", "ch2/001.jpg", "ch2/100.jpg"},
+		},
+		{
+			name:  "non-pages and clashes left alone",
+			files: []string{"1.jpg", "01.jpg", "2.jpg", "notes 1.txt", "ComicInfo.xml"},
+			want:  []string{"1.jpg", "01.jpg", "02.jpg", "notes 1.txt", "ComicInfo.xml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []archiver.File{}
+			for _, name := range tt.files {
+				files = append(files, virtualFile(name, nil))
+			}
+			c := newTestConverter(t, Options{KeepFiles: []string{"*.txt", "ComicInfo.xml"}})
+			got := []string{}
+			for _, f := range c.padPageNumbers("test.cbr", files) {
+				got = append(got, f.NameInArchive)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// pageHeadSize is how much of a page is kept to look for its resolution,
+// more than the headers that come before it.
+const pageHeadSize = 64 << 10
+
+// PageInfo is the size and format of a page, for reports.
+type PageInfo struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// DPI is the resolution the page says it was scanned at, 0 when it
+	// doesn't say
+	DPI int `json:"dpi,omitempty"`
+}
+
+// ReadPageInfo reads the size, format and resolution from the start of the
+// page in r, without decoding the rest of it.
+func ReadPageInfo(name string, r io.Reader) (PageInfo, error) {
+	head := make([]byte, pageHeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return PageInfo{}, err
+	}
+	head = head[:n]
+	cfg, format, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head), r))
+	if err != nil {
+		return PageInfo{}, err
+	}
+	info := PageInfo{Name: name, Format: format, Width: cfg.Width, Height: cfg.Height}
+	switch format {
+	case "jpeg":
+		info.DPI = jfifDPI(head)
+	case "png":
+		info.DPI = pngDPI(head)
+	}
+	return info, nil
+}
+
+// Pages is the PageInfo of every page of the cbz in zr, in the order they
+// are stored. Pages that can't be read are left out.
+func (c *Converter) Pages(zr *zip.Reader) []PageInfo {
+	pages := []PageInfo{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || c.entries.Classify(f.Name) != EntryPage {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		info, err := ReadPageInfo(f.Name, rc)
+		rc.Close()
+		if err == nil {
+			pages = append(pages, info)
+		}
+	}
+	return pages
+}
+
+// jfifDPI is the density in the JFIF header of a jpeg, in dots per inch.
+func jfifDPI(data []byte) int {
+	// SOI, then the APP0 marker, its length and "JFIF\x00", the version,
+	// the unit and the horizontal density
+	if len(data) < 20 || !bytes.Equal(data[2:4], []byte{0xff, 0xe0}) || string(data[6:11]) != "JFIF\x00" {
+		return 0
+	}
+	density := float64(binary.BigEndian.Uint16(data[14:16]))
+	switch data[13] {
+	case 1:
+		return int(density)
+	case 2:
+		return int(math.Round(density * 2.54))
+	}
+	return 0
+}
+
+// pngDPI is the density in the pHYs chunk of a png, in dots per inch.
+func pngDPI(data []byte) int {
+	// after the signature, chunks of length, type, data and CRC
+	for off := 8; off+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[off:]))
+		kind := string(data[off+4 : off+8])
+		if kind == "IDAT" {
+			return 0
+		}
+		if kind == "pHYs" && size == 9 && off+17 <= len(data) {
+			// pixels per unit, the unit being the meter when it is 1
+			if data[off+16] != 1 {
+				return 0
+			}
+			return int(math.Round(float64(binary.BigEndian.Uint32(data[off+8:])) * 0.0254))
+		}
+		off += 12 + size
+	}
+	return 0
+}
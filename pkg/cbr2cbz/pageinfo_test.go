@@ -0,0 +1,84 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withPHYs is the png in data with a pHYs chunk of ppm pixels per meter.
+func withPHYs(data []byte, ppm uint32) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, 9)
+	body := []byte("pHYs")
+	body = binary.BigEndian.AppendUint32(body, ppm)
+	body = binary.BigEndian.AppendUint32(body, ppm)
+	body = append(body, 1)
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(body))
+	// after the signature and IHDR
+	out := append([]byte{}, data[:33]...)
+	out = append(out, chunk...)
+	return append(out, data[33:]...)
+}
+
+// makeJFIF is a jpeg with a JFIF header giving density in unit.
+func makeJFIF(t *testing.T, width, height int, unit byte, density uint16) []byte {
+	buf := &bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, width, height)), nil))
+	app0 := []byte{0xff, 0xe0, 0, 16}
+	app0 = append(app0, "JFIF\x00"...)
+	app0 = append(app0, 1, 2, unit)
+	app0 = binary.BigEndian.AppendUint16(app0, density)
+	app0 = binary.BigEndian.AppendUint16(app0, density)
+	app0 = append(app0, 0, 0)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app0...), data[2:]...)
+}
+
+func Test_ReadPageInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want PageInfo
+	}{
+		{name: "png", data: makePNG(t, 30, 40), want: PageInfo{Format: "png", Width: 30, Height: 40}},
+		{name: "png 300 dpi", data: withPHYs(makePNG(t, 30, 40), 11811), want: PageInfo{Format: "png", Width: 30, Height: 40, DPI: 300}},
+		{name: "jpeg 600 dpi", data: makeJFIF(t, 20, 10, 1, 600), want: PageInfo{Format: "jpeg", Width: 20, Height: 10, DPI: 600}},
+		{name: "jpeg per cm", data: makeJFIF(t, 20, 10, 2, 118), want: PageInfo{Format: "jpeg", Width: 20, Height: 10, DPI: 300}},
+		{name: "jpeg aspect only", data: makeJFIF(t, 20, 10, 0, 1), want: PageInfo{Format: "jpeg", Width: 20, Height: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Name = "001.img"
+			info, err := ReadPageInfo("001.img", bytes.NewReader(tt.data))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, info)
+		})
+	}
+
+	_, err := ReadPageInfo("notes.txt", bytes.NewReader([]byte("hello")))
+	require.Error(t, err)
+}
+
+func Test_Converter_Pages(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, data := range map[string][]byte{"01.png": makePNG(t, 30, 40), "02.png": []byte("broken"), "notes.txt": []byte("hi")} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	c := newTestConverter(t, Options{})
+	require.Equal(t, []PageInfo{{Name: "01.png", Format: "png", Width: 30, Height: 40}}, c.Pages(zr))
+}
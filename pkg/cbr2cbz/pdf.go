@@ -0,0 +1,100 @@
+package cbr2cbz
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	// pdfcpu otherwise writes its own config.yml into the user's config dir
+	model.ConfigPath = "disable"
+}
+
+// IsPDF reports whether src starts like a PDF document.
+func IsPDF(src io.ReaderAt) bool {
+	header := make([]byte, 5)
+	_, err := src.ReadAt(header, 0)
+	return err == nil && string(header) == "%PDF-"
+}
+
+// pdfPages extracts the images embedded in each page of the PDF in src as
+// the entries of the cbz, named by page number so they sort in order. Comic
+// PDFs are scans with one image per page; pages that are drawn rather than
+// embedded have nothing to extract and are left out with a warning.
+func (c *Converter) pdfPages(ctx context.Context, cbrFile string, src io.ReaderAt, size int64, budget *archiveBudget, progress *Progress) ([]archiver.File, error) {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	conf.Cmd = model.EXTRACTIMAGES
+
+	doc, err := api.ReadValidateAndOptimize(io.NewSectionReader(src, 0, size), conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading pdf")
+	}
+
+	digits := max(len(fmt.Sprint(doc.PageCount)), 3)
+	files := []archiver.File{}
+	for page := 1; page <= doc.PageCount; page++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		images, err := pdfcpu.ExtractPageImages(doc, page, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extracting images from page %d", page)
+		}
+		objNrs := []int{}
+		for objNr, img := range images {
+			if !img.Thumb && !img.IsImgMask {
+				objNrs = append(objNrs, objNr)
+			}
+		}
+		sort.Ints(objNrs)
+		if len(objNrs) == 0 {
+			c.warn(CodePDFPageEmpty, cbrFile, "Page %d of %s has no images, leaving it out", page, cbrFile)
+			continue
+		}
+
+		for i, objNr := range objNrs {
+			img := images[objNr]
+			name := fmt.Sprintf("%0*d", digits, page)
+			if len(objNrs) > 1 {
+				name += fmt.Sprintf("-%d", i+1)
+			}
+			name += "." + img.FileType
+
+			data, err := io.ReadAll(img)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading image on page %d", page)
+			}
+			err = budget.declare(name, int64(len(data)))
+			if err != nil {
+				return nil, err
+			}
+
+			f := virtualFile(name, data)
+			open := f.Open
+			f.Open = func() (io.ReadCloser, error) {
+				rc, err := open()
+				if err != nil {
+					return nil, err
+				}
+				progress.setEntry(name)
+				return countingReadCloser{ReadCloser: rc, n: &progress.Read}, nil
+			}
+			files = append(files, f)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no page images found in pdf")
+	}
+	return files, nil
+}
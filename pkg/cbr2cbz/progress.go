@@ -0,0 +1,77 @@
+package cbr2cbz
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Progress keeps running totals for a file being converted so they can be
+// reported while the conversion is still going. It is safe to read from
+// other goroutines.
+type Progress struct {
+	// Read is how much has been read from the entries so far, Written is
+	// left to whoever writes the cbz out
+	Read    atomic.Uint64
+	Written atomic.Uint64
+	// Expected is the total size of the entries being packed, and Entries
+	// how many there are, both zero until known
+	Expected atomic.Uint64
+	Entries  atomic.Int64
+	// Opened is how many entries have been started on
+	Opened atomic.Int64
+	// OnEntry, if set, is called each time another entry is started on,
+	// from the goroutine packing it. Set it before the conversion starts.
+	OnEntry func()
+
+	mu    sync.Mutex
+	entry string
+}
+
+func (p *Progress) setEntry(name string) {
+	p.Opened.Add(1)
+	p.mu.Lock()
+	p.entry = name
+	p.mu.Unlock()
+	if p.OnEntry != nil {
+		p.OnEntry()
+	}
+}
+
+// Fraction is how far along the file is, 0 if that isn't known yet.
+func (p *Progress) Fraction() float64 {
+	expected := p.Expected.Load()
+	if expected == 0 {
+		return 0
+	}
+	return min(float64(p.Read.Load())/float64(expected), 1)
+}
+
+// Entry is the entry currently being packed.
+func (p *Progress) Entry() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.entry
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *atomic.Uint64
+}
+
+func (r countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,116 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+// processPages runs every page in files through c.images while it is
+// packed, re-encoding it as c.images.Format and downscaling it to fit. The
+// new name has to be known before the entry is written, so pages are renamed
+// up front and only decoded when the zip gets to them. Without a format a
+// page keeps its own, or becomes a png if it's in one we can't write. A page
+// whose new name is already taken is left as it was. With c.images.Normalize
+// only the pages in another format are re-encoded, as that one.
+func (c *Converter) processPages(cbrFile string, files []archiver.File) []archiver.File {
+	if c.images.Format == "" && c.images.Normalize == "" && c.images.MaxWidth == 0 && c.images.MaxHeight == 0 && !c.images.AutoRotate && !c.images.toned() {
+		return files
+	}
+
+	taken := map[string]bool{}
+	for _, f := range files {
+		taken[f.NameInArchive] = true
+	}
+
+	out := make([]archiver.File, len(files))
+	for i, f := range files {
+		out[i] = f
+		if c.entries.Classify(f.NameInArchive) != EntryPage {
+			continue
+		}
+		format := c.images.Format
+		if format == "" {
+			format = formatForName(f.NameInArchive)
+		}
+		if c.images.Normalize != "" {
+			format = c.images.Normalize
+		}
+		name := renameExt(f.NameInArchive, imageFormatExtensions[format])
+		if name != f.NameInArchive && taken[name] {
+			c.warn(CodeRenameSkipped, cbrFile, "Not processing %s in %s, %s is already in the archive", f.NameInArchive, cbrFile, name)
+			continue
+		}
+		taken[name] = true
+
+		oldName, open := f.NameInArchive, f.Open
+		out[i].NameInArchive = name
+		out[i].Open = func() (io.ReadCloser, error) {
+			rc, err := open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading %s", oldName)
+			}
+			data, err = c.images.transcode(oldName, data, format)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+	return out
+}
+
+// formatForName is the format a page named name should be written as when
+// it keeps its own, png for anything we can't write.
+func formatForName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".jpeg" {
+		return "jpeg"
+	}
+	for format, formatExt := range imageFormatExtensions {
+		if ext == formatExt {
+			return format
+		}
+	}
+	return "png"
+}
+
+// transcode re-encodes a page as format, turned upright if asked to,
+// downscaled to fit and toned. Unlike process the result is always in format. A page
+// already in that format is kept as it was when it didn't need turning or
+// scaling and either no format was asked for or re-encoding didn't make it
+// any smaller.
+func (o ImageOptions) transcode(name string, data []byte, format string) ([]byte, error) {
+	img, srcFormat, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding %s", name)
+	}
+	upright := img
+	if o.AutoRotate {
+		upright = orient(img, exifOrientation(data))
+	}
+	scaled := o.tone(fitWithin(upright, o.MaxWidth, o.MaxHeight))
+	unchanged := srcFormat == format && scaled == img
+	if unchanged && o.Format == "" {
+		return data, nil
+	}
+
+	out, err := encodeImage(scaled, format, o.Quality)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encoding %s", name)
+	}
+	if unchanged && len(out) >= len(data) {
+		return data, nil
+	}
+	return out, nil
+}
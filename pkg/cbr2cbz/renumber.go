@@ -0,0 +1,36 @@
+package cbr2cbz
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// renumberPages renames pages to their position in files, 001.jpg, 002.png
+// and so on, keeping their folder and extension. files is expected in
+// reading order already, so the names end up sorting that way whatever the
+// scanner called them.
+func (c *Converter) renumberPages(cbrFile string, files []archiver.File) []archiver.File {
+	pages := c.pageIndexes(files)
+	digits := max(len(fmt.Sprint(len(pages))), 3)
+
+	taken := map[string]bool{}
+	for _, f := range files {
+		if c.entries.Classify(f.NameInArchive) != EntryPage {
+			taken[f.NameInArchive] = true
+		}
+	}
+
+	out := append([]archiver.File{}, files...)
+	for n, idx := range pages {
+		f := files[idx]
+		name := path.Join(path.Dir(f.NameInArchive), fmt.Sprintf("%0*d%s", digits, n+1, path.Ext(f.NameInArchive)))
+		if taken[name] {
+			c.warn(CodeRenameSkipped, cbrFile, "Not renumbering %s in %s, %s is already in the archive", f.NameInArchive, cbrFile, name)
+			continue
+		}
+		out[idx].NameInArchive = name
+	}
+	return out
+}
@@ -0,0 +1,24 @@
+package cbr2cbz
+
+import (
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_renumberPages(t *testing.T) {
+	files := []archiver.File{}
+	for _, name := range []string{"Saga/cover [scanner].jpg", "Saga/page2.png", "ComicInfo.xml", "Saga/page10.jpg"} {
+		files = append(files, virtualFile(name, nil))
+	}
+
+	c := newTestConverter(t, Options{})
+	got := []string{}
+	for _, f := range c.renumberPages("test.cbr", files) {
+		got = append(got, f.NameInArchive)
+	}
+	require.Equal(t, []string{"Saga/001.jpg", "Saga/002.png", "ComicInfo.xml", "Saga/003.jpg"}, got)
+	// the input is left alone
+	require.Equal(t, "Saga/page2.png", files[1].NameInArchive)
+}
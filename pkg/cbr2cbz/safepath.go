@@ -0,0 +1,110 @@
+package cbr2cbz
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+var (
+	// drivePrefix is a Windows drive, C: or C:\
+	drivePrefix = regexp.MustCompile(`^[A-Za-z]:`)
+	// reservedName is a Windows device name, which can't be a file whatever
+	// its extension
+	reservedName = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])(\.|$)`)
+)
+
+// safeEntryName returns name as a relative path that stays inside the
+// folder the cbz is extracted to on any system: backslashes become
+// folders, drives, leading slashes and .. that climb out are dropped,
+// characters Windows doesn't allow become _ and device names like CON get
+// a _ in front.
+func safeEntryName(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = drivePrefix.ReplaceAllString(name, "")
+
+	parts := []string{}
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+			continue
+		}
+		parts = append(parts, SafeFileName(part))
+	}
+	if len(parts) == 0 {
+		return "_"
+	}
+	return strings.Join(parts, "/")
+}
+
+// SafeFileName returns name, a single path element, as one Windows can
+// create: characters it doesn't allow become _, trailing dots and spaces
+// are dropped and device names like CON get a _ in front.
+func SafeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// Windows drops trailing dots and spaces, so "a." and "a" clash
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		name = "_"
+	}
+	if reservedName.MatchString(name) {
+		name = "_" + name
+	}
+	return name
+}
+
+// sanitizeNames rewrites the names of files in cbrFile that aren't safe
+// paths, see safeEntryName, with a warning for each. A safe name that is
+// taken already gets a number added.
+func (c *Converter) sanitizeNames(cbrFile string, files []archiver.File, nested map[string]fs.DirEntry) {
+	taken := map[string]bool{}
+	for _, f := range files {
+		taken[strings.ToLower(f.NameInArchive)] = true
+	}
+	for i, f := range files {
+		name := safeEntryName(f.NameInArchive)
+		if name == f.NameInArchive {
+			continue
+		}
+		if taken[strings.ToLower(name)] {
+			ext := path.Ext(name)
+			stem := strings.TrimSuffix(name, ext)
+			for n := 2; taken[strings.ToLower(name)]; n++ {
+				name = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+			}
+		}
+		taken[strings.ToLower(name)] = true
+		c.emitWarning(Warning{
+			Code:      CodePathSanitized,
+			File:      cbrFile,
+			Message:   fmt.Sprintf("Rewriting %q in %s to %s, it isn't a safe path", f.NameInArchive, cbrFile, name),
+			Entry:     f.NameInArchive,
+			RenamedTo: name,
+		})
+		renameFile(files, i, nested, name)
+	}
+}
+
+// renameFile renames files[i] to name, in nested too if it is a nested
+// archive.
+func renameFile(files []archiver.File, i int, nested map[string]fs.DirEntry, name string) {
+	if de, ok := nested[files[i].NameInArchive]; ok {
+		delete(nested, files[i].NameInArchive)
+		nested[name] = de
+	}
+	files[i].NameInArchive = name
+}
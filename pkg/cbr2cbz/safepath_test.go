@@ -0,0 +1,76 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_safeEntryName(t *testing.T) {
+	for name, want := range map[string]string{
+		"001.jpg":              "001.jpg",
+		"ch1/001.jpg":          "ch1/001.jpg",
+		"../../etc/001.jpg":    "etc/001.jpg",
+		"ch1/../../001.jpg":    "001.jpg",
+		"/abs/001.jpg":         "abs/001.jpg",
+		`C:\Scans\ch1\001.jpg`: "Scans/ch1/001.jpg",
+		"c:001.jpg":            "001.jpg",
+		"CON.jpg":              "_CON.jpg",
+		"ch1/lpt1":             "ch1/_lpt1",
+		"console.jpg":          "console.jpg",
+		`what?<"page">|*.jpg`:  "what___page____.jpg",
+		"ch1./001.jpg ":        "ch1/001.jpg",
+		"...":                  "_",
+		"../":                  "_",
+		"tab\there.jpg":        "tab_here.jpg",
+		"日本/ページ01.jpg":         "日本/ページ01.jpg",
+		"./ch1/./001.jpg":      "ch1/001.jpg",
+	} {
+		require.Equal(t, want, safeEntryName(name), name)
+	}
+}
+
+func Test_SafeFileName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Chapter 1":   "Chapter 1",
+		"NUL":         "_NUL",
+		"com1.cbz":    "_com1.cbz",
+		"Aux Pages":   "Aux Pages",
+		"What: Now?.": "What_ Now_",
+		" ":           "_",
+	} {
+		require.Equal(t, want, SafeFileName(name), name)
+	}
+}
+
+func Test_Converter_Repack_unsafePaths(t *testing.T) {
+	src := nestedTar(t,
+		"001.jpg", []byte("one"),
+		"../001.jpg", []byte("climbing"),
+		`C:\pages\002.jpg`, []byte("two"),
+		"NUL.jpg", []byte("device"),
+	)
+	c := newTestConverter(t, Options{PageOrder: "archive"})
+	warnings := []Warning{}
+	c.OnWarning = func(w Warning) { warnings = append(warnings, w) }
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.Repack(context.Background(), "test.cbt", src, src.Size(), buf, &Progress{}))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"001.jpg", "001 (2).jpg", "pages/002.jpg", "_NUL.jpg"}, names)
+
+	rewritten := map[string]string{}
+	for _, w := range warnings {
+		require.Equal(t, CodePathSanitized, w.Code)
+		rewritten[w.Entry] = w.RenamedTo
+	}
+	require.Equal(t, map[string]string{"../001.jpg": "001 (2).jpg", `C:\pages\002.jpg`: "pages/002.jpg", "NUL.jpg": "_NUL.jpg"}, rewritten)
+}
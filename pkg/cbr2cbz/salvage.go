@@ -0,0 +1,393 @@
+package cbr2cbz
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+// Salvaged is what Salvage got out of a damaged archive.
+type Salvaged struct {
+	// Files are the entries found, in page order. Those in Unreadable fail
+	// to open, for PackWithComment to skip or put a placeholder in place of
+	// with Options.SkipBadEntries.
+	Files []archiver.File
+	// Unreadable are the names of the entries that were found but couldn't
+	// be read in full
+	Unreadable []string
+	// Cut is why the archive couldn't be read to its end, nil if it could.
+	// Entries past that point are lost without even their names.
+	Cut error
+}
+
+// Salvage reads what it can of the rar, 7z, tar or zip in src, entry by
+// entry in the order they are stored, keeping each entry that reads in
+// full and noting those that don't rather than failing. Zips are read from
+// the header in front of each entry, so one whose central directory is
+// damaged or missing still gives up what it holds. Entries are read into
+// memory. Only cancellation and the decompression limits fail it.
+func (c *Converter) Salvage(ctx context.Context, name string, src io.ReaderAt, size int64) (*Salvaged, error) {
+	identified, _, err := archiver.Identify("", io.NewSectionReader(src, 0, size))
+	if err != nil && !errors.Is(err, archiver.ErrNoMatch) {
+		return nil, errors.Wrap(err, "unable to identify")
+	}
+	budget := c.Limits.forArchive(size)
+	s := &Salvaged{}
+
+	_, isZip := identified.(archiver.Zip)
+	format, ok := SourceFormat(identified)
+	switch {
+	case isZip || (!ok && bytes.HasPrefix(readHead(src, 4), []byte(localHeaderSig))):
+		err = c.salvageZip(ctx, src, size, budget, s)
+	case ok:
+		err = c.salvageStream(ctx, format, src, size, budget, s)
+	default:
+		return nil, ErrNotArchive
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.opts.PageOrder != "archive" {
+		// stored order is archive order already
+		err = sortEntries(ctx, c.opts.PageOrder, s.Files, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.decodeNames(name, s.Files, nil)
+	c.sanitizeNames(name, s.Files, nil)
+	return s, nil
+}
+
+// salvageStream reads the entries of format one after another until it
+// can't go any further.
+func (c *Converter) salvageStream(ctx context.Context, format archiver.Archival, src io.ReaderAt, size int64, budget *archiveBudget, s *Salvaged) error {
+	err := format.Extract(ctx, io.NewSectionReader(src, 0, size), nil, func(ctx context.Context, f archiver.File) error {
+		if f.IsDir() {
+			return nil
+		}
+		err := budget.declare(f.NameInArchive, f.Size())
+		if err != nil {
+			return err
+		}
+		data, err := readSalvaged(f.Open, budget, f.NameInArchive, f.Size())
+		if errors.Is(err, ErrDecompressionLimit) {
+			return err
+		}
+		s.add(f.NameInArchive, data, f.ModTime(), err)
+		return nil
+	})
+	return s.cutShort(ctx, err)
+}
+
+// readSalvaged reads an entry in full through the budget.
+func readSalvaged(open func() (io.ReadCloser, error), budget *archiveBudget, name string, size int64) ([]byte, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(budget.guard(name, size, rc))
+	if err == nil && int64(len(data)) != size && size != math.MaxInt64 {
+		err = errors.Errorf("read %d of its %d bytes", len(data), size)
+	}
+	return data, err
+}
+
+// add adds the entry name read as data, or that failed to read with err.
+func (s *Salvaged) add(name string, data []byte, modTime time.Time, err error) {
+	if err == nil {
+		s.Files = append(s.Files, datedVirtualFile(name, data, modTime))
+		return
+	}
+	s.Unreadable = append(s.Unreadable, name)
+	s.Files = append(s.Files, archiver.File{
+		FileInfo:      virtualFileInfo{name: name, modTime: modTime},
+		NameInArchive: name,
+		Open:          func() (io.ReadCloser, error) { return nil, err },
+	})
+}
+
+// cutShort records err as where reading the archive stopped, unless it is
+// one that should fail the salvage.
+func (s *Salvaged) cutShort(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, ErrDecompressionLimit):
+		return err
+	}
+	s.Cut = err
+	return nil
+}
+
+const (
+	localHeaderSig = "PK\x03\x04"
+	descriptorSig  = "PK\x07\x08"
+	localHeaderLen = 30
+	// zipDescriptor is the flag saying the crc and sizes follow the data
+	// rather than being in the local header
+	zipDescriptor = 0x8
+	zipEncrypted  = 0x1
+)
+
+// salvageZip reads a zip by the local header in front of each entry rather
+// than its central directory, skipping over the bytes between entries it
+// can't make sense of.
+func (c *Converter) salvageZip(ctx context.Context, src io.ReaderAt, size int64, budget *archiveBudget, s *Salvaged) error {
+	offset := int64(0)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		at := findSignature(src, offset, size, localHeaderSig)
+		if at < 0 {
+			return nil
+		}
+		h, err := readLocalHeader(src, at, size)
+		if err != nil {
+			return s.cutShort(ctx, err)
+		}
+		// past this header at least, whatever the entry turns out to be
+		offset = h.dataStart
+		if strings.HasSuffix(h.name, "/") {
+			continue
+		}
+
+		if h.sizeKnown() {
+			if err := budget.declare(h.name, h.size); err != nil {
+				return err
+			}
+			if h.dataStart+h.compressedSize > size {
+				s.add(h.name, nil, h.modTime, io.ErrUnexpectedEOF)
+				return s.cutShort(ctx, errors.Errorf("the archive ends partway through %s", h.name))
+			}
+		}
+		data, next, err := readZipEntry(src, size, h, budget)
+		if errors.Is(err, ErrDecompressionLimit) {
+			return err
+		}
+		s.add(h.name, data, h.modTime, err)
+		if next > offset {
+			offset = next
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) && findSignature(src, offset, size, localHeaderSig) < 0 {
+			return s.cutShort(ctx, errors.Errorf("the archive ends partway through %s", h.name))
+		}
+	}
+}
+
+// localHeader is the header in front of each entry of a zip.
+type localHeader struct {
+	name           string
+	flags          uint16
+	method         uint16
+	crc            uint32
+	compressedSize int64
+	size           int64
+	modTime        time.Time
+	dataStart      int64
+}
+
+// sizeKnown reports whether the header has the sizes, rather than a data
+// descriptor after the data.
+func (h localHeader) sizeKnown() bool {
+	return h.flags&zipDescriptor == 0 || h.compressedSize > 0
+}
+
+func readLocalHeader(src io.ReaderAt, at int64, size int64) (localHeader, error) {
+	buf := make([]byte, localHeaderLen)
+	if _, err := src.ReadAt(buf, at); err != nil {
+		return localHeader{}, errors.Wrap(err, "reading a local header")
+	}
+	h := localHeader{
+		flags:          binary.LittleEndian.Uint16(buf[6:]),
+		method:         binary.LittleEndian.Uint16(buf[8:]),
+		crc:            binary.LittleEndian.Uint32(buf[14:]),
+		compressedSize: int64(binary.LittleEndian.Uint32(buf[18:])),
+		size:           int64(binary.LittleEndian.Uint32(buf[22:])),
+		modTime:        msDosTime(binary.LittleEndian.Uint16(buf[12:]), binary.LittleEndian.Uint16(buf[10:])),
+	}
+	nameLen, extraLen := int64(binary.LittleEndian.Uint16(buf[26:])), int64(binary.LittleEndian.Uint16(buf[28:]))
+	h.dataStart = at + localHeaderLen + nameLen + extraLen
+	if h.dataStart > size {
+		return localHeader{}, errors.New("the archive ends inside a local header")
+	}
+	rest := make([]byte, nameLen+extraLen)
+	if _, err := src.ReadAt(rest, at+localHeaderLen); err != nil {
+		return localHeader{}, errors.Wrap(err, "reading a local header")
+	}
+	h.name = string(rest[:nameLen])
+	zip64Sizes(&h, rest[nameLen:])
+	return h, nil
+}
+
+// zip64Sizes fills in the sizes of h from its zip64 extra field, when the
+// header says they are there.
+func zip64Sizes(h *localHeader, extra []byte) {
+	if h.size != math.MaxUint32 && h.compressedSize != math.MaxUint32 {
+		return
+	}
+	for len(extra) >= 4 {
+		id, n := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if n > len(extra) {
+			return
+		}
+		if id == 0x0001 && n >= 16 {
+			h.size = int64(binary.LittleEndian.Uint64(extra))
+			h.compressedSize = int64(binary.LittleEndian.Uint64(extra[8:]))
+			return
+		}
+		extra = extra[n:]
+	}
+}
+
+// readZipEntry reads the entry h is the header of, checking its crc, and
+// returns where the next one may start.
+func readZipEntry(src io.ReaderAt, size int64, h localHeader, budget *archiveBudget) ([]byte, int64, error) {
+	if h.flags&zipEncrypted != 0 {
+		return nil, h.dataStart, errors.New("encrypted")
+	}
+	if h.method != 0 && h.method != 8 {
+		return nil, h.dataStart, errors.Errorf("unsupported compression method %d", h.method)
+	}
+	declared := h.size
+	if !h.sizeKnown() {
+		declared = math.MaxInt64
+	}
+
+	var data []byte
+	var next int64
+	var err error
+	switch {
+	case h.sizeKnown():
+		next = h.dataStart + h.compressedSize
+		data, err = readSalvaged(func() (io.ReadCloser, error) {
+			return zipDecompressor(h.method, io.NewSectionReader(src, h.dataStart, h.compressedSize)), nil
+		}, budget, h.name, declared)
+	case h.method == 8:
+		// deflate ends itself, its length is how much of the stream it took
+		counted := &countingByteReader{r: bufio.NewReader(io.NewSectionReader(src, h.dataStart, size-h.dataStart))}
+		data, err = readSalvaged(func() (io.ReadCloser, error) { return flate.NewReader(counted), nil }, budget, h.name, declared)
+		next = h.dataStart + counted.n
+	default:
+		next = findDescriptor(src, h.dataStart, size)
+		if next < 0 {
+			return nil, h.dataStart, io.ErrUnexpectedEOF
+		}
+		data, err = readSalvaged(func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(src, h.dataStart, next-h.dataStart)), nil
+		}, budget, h.name, declared)
+	}
+	if err != nil {
+		return nil, next, err
+	}
+
+	crc := h.crc
+	if h.flags&zipDescriptor != 0 {
+		descriptor := make([]byte, 8)
+		if _, err := src.ReadAt(descriptor, next); err != nil {
+			return nil, next, io.ErrUnexpectedEOF
+		}
+		if string(descriptor[:4]) == descriptorSig {
+			crc = binary.LittleEndian.Uint32(descriptor[4:])
+		} else {
+			crc = binary.LittleEndian.Uint32(descriptor)
+		}
+	}
+	if crc32.ChecksumIEEE(data) != crc {
+		return nil, next, errors.New("checksum mismatch")
+	}
+	return data, next, nil
+}
+
+func zipDecompressor(method uint16, r io.Reader) io.ReadCloser {
+	if method == 8 {
+		return flate.NewReader(r)
+	}
+	return io.NopCloser(r)
+}
+
+// findDescriptor finds the data descriptor after stored data starting at
+// dataStart, the one whose compressed size is how far it is from there,
+// returning where it starts or -1.
+func findDescriptor(src io.ReaderAt, dataStart int64, size int64) int64 {
+	for at := dataStart; ; at++ {
+		at = findSignature(src, at, size, descriptorSig)
+		if at < 0 {
+			return -1
+		}
+		fields := make([]byte, 20)
+		n, _ := src.ReadAt(fields, at+4)
+		length := at - dataStart
+		if n >= 8 && int64(binary.LittleEndian.Uint32(fields[4:])) == length {
+			return at
+		}
+		if n >= 12 && int64(binary.LittleEndian.Uint64(fields[4:])) == length {
+			return at
+		}
+	}
+}
+
+// findSignature returns the offset of the first sig in src at or after
+// from, or -1.
+func findSignature(src io.ReaderAt, from int64, size int64, sig string) int64 {
+	const chunk = 64 << 10
+	buf := make([]byte, chunk+len(sig)-1)
+	for at := from; at < size; at += chunk {
+		n, _ := src.ReadAt(buf[:min(int64(len(buf)), size-at)], at)
+		if i := bytes.Index(buf[:n], []byte(sig)); i >= 0 {
+			return at + int64(i)
+		}
+	}
+	return -1
+}
+
+func readHead(src io.ReaderAt, n int) []byte {
+	buf := make([]byte, n)
+	read, _ := src.ReadAt(buf, 0)
+	return buf[:read]
+}
+
+// countingByteReader counts the bytes read through it, as a byte reader so
+// flate takes no more than it needs.
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingByteReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// msDosTime is the time of a zip header, which has no time zone.
+func msDosTime(date uint16, t uint16) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(int(date>>9)+1980, time.Month(date>>5&0xf), int(date&0x1f), int(t>>11), int(t>>5&0x3f), int(t&0x1f)*2, 0, time.Local)
+}
@@ -0,0 +1,106 @@
+package cbr2cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// zipOf is a zip of the entries given, names and contents, packed with
+// method and a data descriptor after each the way zip.Writer does.
+func zipOf(t *testing.T, method uint16, entries ...string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for i := 0; i < len(entries); i += 2 {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entries[i], Method: method})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(entries[i+1]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// salvagedContents reads every entry salvaged, "" for the unreadable ones.
+func salvagedContents(t *testing.T, files []archiver.File) map[string]string {
+	contents := map[string]string{}
+	for _, f := range files {
+		rc, err := f.Open()
+		if err != nil {
+			contents[f.NameInArchive] = ""
+			continue
+		}
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		contents[f.NameInArchive] = string(data)
+	}
+	return contents
+}
+
+func Test_Salvage_zip(t *testing.T) {
+	c := newTestConverter(t, Options{})
+	for name, method := range map[string]uint16{"stored": zip.Store, "deflated": zip.Deflate} {
+		t.Run(name, func(t *testing.T) {
+			data := zipOf(t, method, "001.jpg", "first page", "002.jpg", "second page", "003.jpg", "third page")
+			// without its central directory
+			data = data[:bytes.Index(data, []byte("PK\x01\x02"))]
+
+			s, err := c.Salvage(context.Background(), "book.cbz", bytes.NewReader(data), int64(len(data)))
+			require.NoError(t, err)
+			require.NoError(t, s.Cut)
+			require.Empty(t, s.Unreadable)
+			require.Equal(t, map[string]string{"001.jpg": "first page", "002.jpg": "second page", "003.jpg": "third page"}, salvagedContents(t, s.Files))
+		})
+	}
+
+	t.Run("damaged entry", func(t *testing.T) {
+		data := zipOf(t, zip.Store, "001.jpg", "first page", "002.jpg", "second page", "003.jpg", "third page")
+		data = bytes.Replace(data, []byte("second"), []byte("SECOND"), 1)
+
+		s, err := c.Salvage(context.Background(), "book.cbz", bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		require.Equal(t, []string{"002.jpg"}, s.Unreadable)
+		require.Equal(t, map[string]string{"001.jpg": "first page", "002.jpg": "", "003.jpg": "third page"}, salvagedContents(t, s.Files))
+	})
+
+	t.Run("cut short", func(t *testing.T) {
+		data := zipOf(t, zip.Deflate, "001.jpg", "first page", "002.jpg", "second page")
+		data = data[:bytes.Index(data, []byte("002.jpg"))+len("002.jpg")+3]
+
+		s, err := c.Salvage(context.Background(), "book.cbz", bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		require.ErrorContains(t, s.Cut, "ends partway through 002.jpg")
+		require.Equal(t, []string{"002.jpg"}, s.Unreadable)
+		require.Equal(t, map[string]string{"001.jpg": "first page", "002.jpg": ""}, salvagedContents(t, s.Files))
+	})
+}
+
+func Test_Salvage_tar(t *testing.T) {
+	c := newTestConverter(t, Options{})
+	src := nestedTar(t, "002.jpg", []byte("second page"), "001.jpg", bytes.Repeat([]byte("first page"), 100))
+	data, err := io.ReadAll(src)
+	require.NoError(t, err)
+	// partway through the second entry
+	data = data[:1024+512+100]
+
+	s, err := c.Salvage(context.Background(), "book.cbt", bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Error(t, s.Cut)
+	require.Equal(t, []string{"001.jpg"}, s.Unreadable)
+	// in page order
+	require.Equal(t, "001.jpg", s.Files[0].NameInArchive)
+	require.Equal(t, map[string]string{"001.jpg": "", "002.jpg": "second page"}, salvagedContents(t, s.Files))
+}
+
+func Test_Salvage_notArchive(t *testing.T) {
+	c := newTestConverter(t, Options{})
+	data := []byte("just some text")
+	_, err := c.Salvage(context.Background(), "book.cbz", bytes.NewReader(data), int64(len(data)))
+	require.ErrorIs(t, err, ErrNotArchive)
+}
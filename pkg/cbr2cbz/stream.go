@@ -0,0 +1,270 @@
+package cbr2cbz
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/nwaples/rardecode/v2"
+	"github.com/pkg/errors"
+)
+
+// headerReader is a rar or tar read front to back: next moves on to the
+// following entry, which Read then reads.
+type headerReader interface {
+	next() (name string, info fs.FileInfo, err error)
+	io.ReadCloser
+}
+
+type rarHeaders struct {
+	*rardecode.Reader
+}
+
+func (r rarHeaders) next() (string, fs.FileInfo, error) {
+	hdr, err := r.Next()
+	if err != nil {
+		return "", nil, err
+	}
+	return hdr.Name, volumeFileInfo{hdr}, nil
+}
+
+func (r rarHeaders) Close() error { return nil }
+
+type rarVolumeHeaders struct {
+	*rardecode.ReadCloser
+}
+
+func (r rarVolumeHeaders) next() (string, fs.FileInfo, error) {
+	hdr, err := r.Next()
+	if err != nil {
+		return "", nil, err
+	}
+	return hdr.Name, volumeFileInfo{hdr}, nil
+}
+
+type tarHeaders struct {
+	*tar.Reader
+}
+
+func (r tarHeaders) next() (string, fs.FileInfo, error) {
+	hdr, err := r.Next()
+	if err != nil {
+		return "", nil, err
+	}
+	return hdr.Name, hdr.FileInfo(), nil
+}
+
+func (r tarHeaders) Close() error { return nil }
+
+// storedEntry is a file in an archive, pos being how many entries come
+// before it.
+type storedEntry struct {
+	name string
+	info fs.FileInfo
+	pos  int
+}
+
+// entryCursor hands out the entries of a rar or tar as one pass over it
+// gets to them, so packing them in the order they are stored decompresses
+// the archive once, a page at a time, however many pages there are. An
+// entry the pass is already past starts it over, one asked for while
+// another is still being read gets a pass of its own.
+type entryCursor struct {
+	ctx   context.Context
+	start func() (headerReader, error)
+	// last is the position of the last file, the pass ends there
+	last int
+
+	mu      sync.Mutex
+	r       headerReader
+	pos     int
+	reading bool
+	stop    func() bool
+}
+
+// newEntryCursor returns a cursor over the archive in src, nil for formats
+// read some other way.
+func newEntryCursor(ctx context.Context, format archiver.Archival, src io.ReaderAt, size int64) *entryCursor {
+	c := &entryCursor{ctx: ctx}
+	switch f := format.(type) {
+	case archiver.Rar:
+		c.start = func() (headerReader, error) {
+			rr, err := rardecode.NewReader(io.NewSectionReader(src, 0, size), rarOptions(f)...)
+			return rarHeaders{rr}, err
+		}
+	case rarVolumes:
+		c.start = func() (headerReader, error) {
+			rr, err := rardecode.OpenReader(f.name, append(rarOptions(f.Rar), rardecode.FileSystem(f.fs))...)
+			if err != nil {
+				return nil, err
+			}
+			return rarVolumeHeaders{rr}, nil
+		}
+	case archiver.Tar:
+		c.start = func() (headerReader, error) {
+			return tarHeaders{tar.NewReader(io.NewSectionReader(src, 0, size))}, nil
+		}
+	default:
+		return nil
+	}
+	return c
+}
+
+func rarOptions(r archiver.Rar) []rardecode.Option {
+	if r.Password == "" {
+		return nil
+	}
+	return []rardecode.Option{rardecode.Password(r.Password)}
+}
+
+// list reads the headers of the archive, returning its files in the order
+// they are stored. Directories, links and names stored twice are left out,
+// the way they are when the archive is walked as a filesystem.
+func (c *entryCursor) list() ([]storedEntry, error) {
+	r, err := c.start()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	files := []storedEntry{}
+	seen := map[string]bool{}
+	for pos := 0; ; pos++ {
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, info, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name = strings.TrimPrefix(strings.Trim(name, "/"), "./")
+		if name == "" || name == "." || info.IsDir() || !info.Mode().IsRegular() || seen[name] {
+			continue
+		}
+		seen[name] = true
+		files = append(files, storedEntry{name: name, info: info, pos: pos})
+		c.last = pos
+	}
+	return files, nil
+}
+
+// open returns a reader for the entry at pos.
+func (c *entryCursor) open(pos int) (io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reading {
+		r, err := c.start()
+		if err != nil {
+			return nil, err
+		}
+		if err := skipTo(r, 0, pos); err != nil {
+			r.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+
+	if c.r == nil || pos < c.pos {
+		c.closeReader()
+		r, err := c.start()
+		if err != nil {
+			return nil, err
+		}
+		c.r, c.pos = r, 0
+		// an abandoned pass still gets its volumes closed
+		c.stop = context.AfterFunc(c.ctx, c.release)
+	}
+	if err := skipTo(c.r, c.pos, pos); err != nil {
+		c.closeReader()
+		return nil, err
+	}
+	c.pos = pos + 1
+	c.reading = true
+	return &cursorEntry{c: c, pos: pos}, nil
+}
+
+// skipTo moves r, at the entry at from, on to the entry at pos.
+func skipTo(r headerReader, from int, pos int) error {
+	for ; from <= pos; from++ {
+		_, _, err := r.next()
+		if err == io.EOF {
+			return errors.Wrap(fs.ErrNotExist, "entry missing on a second read")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeReader ends the pass, c.mu held.
+func (c *entryCursor) closeReader() {
+	if c.stop != nil {
+		c.stop()
+		c.stop = nil
+	}
+	if c.r != nil {
+		c.r.Close()
+		c.r = nil
+	}
+}
+
+func (c *entryCursor) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeReader()
+}
+
+// cursorEntry is the entry the cursor is at, until it is closed.
+type cursorEntry struct {
+	c   *entryCursor
+	pos int
+}
+
+func (e *cursorEntry) Read(p []byte) (int, error) {
+	e.c.mu.Lock()
+	r := e.c.r
+	e.c.mu.Unlock()
+	if r == nil {
+		return 0, errors.New("archive closed while reading")
+	}
+	return r.Read(p)
+}
+
+func (e *cursorEntry) Close() error {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	e.c.reading = false
+	if e.pos == e.c.last {
+		e.c.closeReader()
+	}
+	return nil
+}
+
+// streamEntries is walkEntries for a rar or tar read through cursor. The
+// nested archives are still read through fsys.
+func (c *Converter) streamEntries(cbrFile string, cursor *entryCursor, budget *archiveBudget, progress *Progress, depth int) ([]archiver.File, map[string]fs.DirEntry, error) {
+	stored, err := cursor.list()
+	if err != nil {
+		return nil, nil, err
+	}
+	list := &entryList{nested: map[string]fs.DirEntry{}}
+	for _, e := range stored {
+		pos := e.pos
+		err := c.addEntry(cbrFile, e.name, fs.FileInfoToDirEntry(e.info), func() (io.ReadCloser, error) {
+			return cursor.open(pos)
+		}, budget, progress, depth, list)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return list.files, list.nested, nil
+}
@@ -0,0 +1,187 @@
+package cbr2cbz
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// generatedTar is a tar of pages pages of pageSize bytes each, made up as
+// it is read so archives of any size cost no memory. Page i is filled with
+// byte i.
+type generatedTar struct {
+	pages    int
+	pageSize int64
+	read     atomic.Int64
+}
+
+func (g *generatedTar) stride() int64 {
+	return 512 + (g.pageSize+511)/512*512
+}
+
+func (g *generatedTar) Size() int64 {
+	return int64(g.pages)*g.stride() + 1024
+}
+
+func (g *generatedTar) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= g.Size() {
+			g.read.Add(int64(n))
+			return n, io.EOF
+		}
+		page, within := pos/g.stride(), pos%g.stride()
+		switch {
+		case page < int64(g.pages) && within < 512:
+			n += copy(p[n:], g.header(int(page))[within:])
+		case page < int64(g.pages) && within-512 < g.pageSize:
+			fill := p[n:min(len(p), n+int(g.pageSize-(within-512)))]
+			for i := range fill {
+				fill[i] = byte(page)
+			}
+			n += len(fill)
+		default:
+			// padding and the end of archive blocks
+			end := g.Size()
+			if page < int64(g.pages) {
+				end = (page + 1) * g.stride()
+			}
+			zeros := p[n:min(len(p), n+int(end-pos))]
+			clear(zeros)
+			n += len(zeros)
+		}
+	}
+	g.read.Add(int64(n))
+	return n, nil
+}
+
+func (g *generatedTar) header(page int) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("%05d.jpg", page+1), Mode: 0o644, Size: g.pageSize, Typeflag: tar.TypeReg})
+	return buf.Bytes()[:512]
+}
+
+// repackMeasured repacks src, returning how far the heap grew at most
+// while it did.
+func repackMeasured(tb testing.TB, c *Converter, src *generatedTar, dst io.Writer) uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	base, peak := stats.HeapAlloc, stats.HeapAlloc
+
+	progress := &Progress{}
+	progress.OnEntry = func() {
+		runtime.ReadMemStats(&stats)
+		peak = max(peak, stats.HeapAlloc)
+	}
+	require.NoError(tb, c.Repack(context.Background(), "omnibus.cbt", src, src.Size(), dst, progress))
+	return peak - base
+}
+
+// Test_Converter_Repack_streams packs a 256 MB archive of a thousand pages,
+// checking it is read through once and never held in memory.
+func Test_Converter_Repack_streams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("packs 256 MB")
+	}
+	src := &generatedTar{pages: 1000, pageSize: 256 << 10}
+	c := newTestConverter(t, Options{Compression: "store"})
+
+	out := &countingWriter{Writer: io.Discard}
+	grew := repackMeasured(t, c, src, out)
+	require.Greater(t, out.n, int64(1000)*src.pageSize)
+	require.Less(t, grew, uint64(32<<20), "heap grew by %d bytes", grew)
+	require.Less(t, src.read.Load(), 2*src.Size(), "read %d bytes of %d", src.read.Load(), src.Size())
+}
+
+func Test_entryCursor(t *testing.T) {
+	src := nestedTar(t, "001.jpg", []byte("one"), "dir/", []byte{}, "./002.jpg", []byte("two"), "003.jpg", []byte("three"), "001.jpg", []byte("again"))
+	cursor := newEntryCursor(context.Background(), archiver.Tar{}, src, src.Size())
+
+	stored, err := cursor.list()
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range stored {
+		names = append(names, e.name)
+	}
+	require.Equal(t, []string{"001.jpg", "002.jpg", "003.jpg"}, names, "no folders or names stored twice")
+
+	read := func(pos int) string {
+		rc, err := cursor.open(pos)
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		return string(data)
+	}
+	require.Equal(t, "two", read(stored[1].pos))
+	require.Equal(t, "three", read(stored[2].pos))
+	require.Nil(t, cursor.r, "the pass ends with the last file")
+	require.Equal(t, "one", read(stored[0].pos), "starting over for one already passed")
+
+	first, err := cursor.open(stored[0].pos)
+	require.NoError(t, err)
+	require.Equal(t, "three", read(stored[2].pos), "a pass of its own while another entry is open")
+	data, err := io.ReadAll(first)
+	require.NoError(t, err)
+	require.Equal(t, "one", string(data))
+	require.NoError(t, first.Close())
+}
+
+func Test_Converter_Repack_outOfOrder(t *testing.T) {
+	src := nestedTar(t, "page10.jpg", []byte("ten"), "page2.jpg", []byte("two"), "page1.jpg", []byte("one"))
+	c := newTestConverter(t, Options{PageOrder: "natural"})
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.Repack(context.Background(), "test.cbt", src, src.Size(), buf, &Progress{}))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	contents := []string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		contents = append(contents, f.Name+"="+string(data))
+	}
+	require.Equal(t, []string{"page1.jpg=one", "page2.jpg=two", "page10.jpg=ten"}, contents)
+}
+
+// BenchmarkConverter_Repack packs archives up to 2 GB, reporting the most
+// the heap grew by while packing, which should stay flat however large the
+// archive is.
+func BenchmarkConverter_Repack(b *testing.B) {
+	for _, size := range []struct {
+		pages    int
+		pageSize int64
+	}{
+		{100, 1 << 20},
+		{1000, 1 << 20},
+		{4000, 512 << 10},
+	} {
+		src := &generatedTar{pages: size.pages, pageSize: size.pageSize}
+		b.Run(fmt.Sprintf("%dx%dKB", size.pages, size.pageSize>>10), func(b *testing.B) {
+			c, err := New(Options{Compression: "store"})
+			require.NoError(b, err)
+			b.SetBytes(src.Size())
+			b.ReportAllocs()
+			var grew uint64
+			for i := 0; i < b.N; i++ {
+				grew = max(grew, repackMeasured(b, c, src, io.Discard))
+			}
+			b.ReportMetric(float64(grew)/(1<<20), "peak-heap-MB")
+		})
+	}
+}
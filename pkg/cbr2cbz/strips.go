@@ -0,0 +1,192 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// stripRatio is how many times taller than wide a page has to be to
+	// count as a webtoon strip.
+	stripRatio = 3
+	// stripMaxHeight caps stitched strips, past this readers and decoders
+	// start struggling.
+	stripMaxHeight = 20000
+	// defaultSliceAspect is the height to width of slices when there is no
+	// max-width and max-height to take the screen shape from.
+	defaultSliceAspect = 1.5
+)
+
+// stripLayouts are the values --strips accepts.
+var stripLayouts = map[string]bool{"slice": true, "stitch": true}
+
+// PageImage is a page's name and encoded contents.
+type PageImage struct {
+	Name string
+	Data []byte
+}
+
+// arrangeStrips turns webtoon pages into the layout o asks for. Slicing cuts
+// pages over stripRatio tall into screen shaped pages; stitching joins runs
+// of same width pages into long strips, for archives that already have at
+// least one strip in them so regular comics are left alone. pages are in
+// reading order.
+func (o ImageOptions) ArrangeStrips(pages []PageImage) ([]PageImage, error) {
+	decoded := make([]image.Image, len(pages))
+	formats := make([]string, len(pages))
+	anyStrip := false
+	for i, p := range pages {
+		img, format, err := image.Decode(bytes.NewReader(p.Data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding %s", p.Name)
+		}
+		decoded[i], formats[i] = img, format
+		if isStrip(img.Bounds()) {
+			anyStrip = true
+		}
+	}
+
+	switch o.Strips {
+	case "slice":
+		out := []PageImage{}
+		for i, p := range pages {
+			if !isStrip(decoded[i].Bounds()) {
+				out = append(out, p)
+				continue
+			}
+			slices, err := o.slicePage(p.Name, decoded[i], formats[i])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, slices...)
+		}
+		return out, nil
+	case "stitch":
+		if !anyStrip {
+			return pages, nil
+		}
+		return o.stitchPages(pages, decoded, formats)
+	}
+	return pages, nil
+}
+
+func isStrip(b image.Rectangle) bool {
+	return b.Dx() > 0 && b.Dy() >= b.Dx()*stripRatio
+}
+
+// sliceAspect is the height to width of the screen slices are cut for.
+func (o ImageOptions) sliceAspect() float64 {
+	if o.MaxWidth > 0 && o.MaxHeight > 0 {
+		return float64(o.MaxHeight) / float64(o.MaxWidth)
+	}
+	return defaultSliceAspect
+}
+
+// slicePage cuts a strip into slices about a screen tall, moving each cut to
+// the most uniform row nearby so it falls between panels where it can.
+func (o ImageOptions) slicePage(name string, img image.Image, format string) ([]PageImage, error) {
+	b := img.Bounds()
+	count := (b.Dy() + int(float64(b.Dx())*o.sliceAspect()) - 1) / int(float64(b.Dx())*o.sliceAspect())
+	height := (b.Dy() + count - 1) / count
+
+	cuts := []int{b.Min.Y}
+	for i := 1; i < count; i++ {
+		cuts = append(cuts, quietRow(img, b.Min.Y+i*height, height/10))
+	}
+	cuts = append(cuts, b.Max.Y)
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	digits := len(fmt.Sprint(count))
+	out := []PageImage{}
+	for i := 0; i+1 < len(cuts); i++ {
+		rect := image.Rect(b.Min.X, cuts[i], b.Max.X, cuts[i+1])
+		slice := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		draw.Draw(slice, slice.Bounds(), img, rect.Min, draw.Src)
+
+		data, sliceName, err := o.encodeIntermediate(slice, format, fmt.Sprintf("%s-%0*d%s", stem, digits, i+1, ext))
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding slice of %s", name)
+		}
+		out = append(out, PageImage{Name: sliceName, Data: data})
+	}
+	return out, nil
+}
+
+// quietRow finds the row within window of y with the least variation across
+// it, like the gutter between two panels.
+func quietRow(img image.Image, y int, window int) int {
+	b := img.Bounds()
+	best, bestScore := y, -1.0
+	for row := max(b.Min.Y+1, y-window); row <= min(b.Max.Y-1, y+window); row++ {
+		var sum, sumSq float64
+		step := max(1, b.Dx()/200)
+		n := 0.0
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, _ := img.At(x, row).RGBA()
+			v := float64(r+g+bl) / 3
+			sum += v
+			sumSq += v * v
+			n++
+		}
+		score := sumSq/n - (sum/n)*(sum/n)
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = row, score
+		}
+	}
+	return best
+}
+
+// stitchPages joins consecutive pages of the same width into strips up to
+// stripMaxHeight tall, named after the first page in each.
+func (o ImageOptions) stitchPages(pages []PageImage, decoded []image.Image, formats []string) ([]PageImage, error) {
+	out := []PageImage{}
+	for start := 0; start < len(pages); {
+		width := decoded[start].Bounds().Dx()
+		height := decoded[start].Bounds().Dy()
+		end := start + 1
+		for end < len(pages) && decoded[end].Bounds().Dx() == width && height+decoded[end].Bounds().Dy() <= stripMaxHeight {
+			height += decoded[end].Bounds().Dy()
+			end++
+		}
+		if end-start == 1 {
+			out = append(out, pages[start])
+			start = end
+			continue
+		}
+
+		strip := image.NewRGBA(image.Rect(0, 0, width, height))
+		y := 0
+		for _, img := range decoded[start:end] {
+			b := img.Bounds()
+			draw.Draw(strip, image.Rect(0, y, width, y+b.Dy()), img, b.Min, draw.Src)
+			y += b.Dy()
+		}
+
+		data, name, err := o.encodeIntermediate(strip, formats[start], pages[start].Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding strip from %s", pages[start].Name)
+		}
+		out = append(out, PageImage{Name: name, Data: data})
+		start = end
+	}
+	return out, nil
+}
+
+// encodeIntermediate encodes a slice or strip. With a target format set it
+// or normalized to is kept lossless, since process re-encodes it once more
+// anyway.
+func (o ImageOptions) encodeIntermediate(img image.Image, srcFormat string, name string) ([]byte, string, error) {
+	format := srcFormat
+	if _, ok := imageFormatExtensions[format]; !ok || o.Format != "" || o.Normalize != "" {
+		format = "png"
+	}
+	data, err := encodeImage(img, format, o.Quality)
+	return data, renameExt(name, imageFormatExtensions[format]), err
+}
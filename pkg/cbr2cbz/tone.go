@@ -0,0 +1,60 @@
+package cbr2cbz
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// toned is whether the options change the colours of pages.
+func (o ImageOptions) toned() bool {
+	return o.Grayscale || (o.Contrast != 0 && o.Contrast != 1) || (o.Gamma != 0 && o.Gamma != 1)
+}
+
+// tone applies grayscale, contrast and gamma to img. It is returned as it
+// is when none of them are set.
+func (o ImageOptions) tone(img image.Image) image.Image {
+	if !o.toned() {
+		return img
+	}
+	curve := o.toneCurve()
+	b := img.Bounds()
+	if o.Grayscale {
+		// transparent parts end up white, as they'd show on paper
+		gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(gray, gray.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(gray, gray.Bounds(), img, b.Min, draw.Over)
+		for i, v := range gray.Pix {
+			gray.Pix[i] = curve[v]
+		}
+		return gray
+	}
+	rgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	for i, v := range rgba.Pix {
+		if i%4 != 3 {
+			rgba.Pix[i] = curve[v]
+		}
+	}
+	return rgba
+}
+
+// toneCurve maps every 8 bit level through the contrast, stretched around
+// the middle grey, and then the gamma.
+func (o ImageOptions) toneCurve() [256]uint8 {
+	contrast, gamma := o.Contrast, o.Gamma
+	if contrast == 0 {
+		contrast = 1
+	}
+	if gamma == 0 {
+		gamma = 1
+	}
+	var curve [256]uint8
+	for level := range curve {
+		v := (float64(level)/255-0.5)*contrast + 0.5
+		v = math.Pow(min(max(v, 0), 1), gamma)
+		curve[level] = uint8(math.Round(v * 255))
+	}
+	return curve
+}
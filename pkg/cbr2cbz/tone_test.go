@@ -0,0 +1,51 @@
+package cbr2cbz
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_toneCurve(t *testing.T) {
+	curve := ImageOptions{}.toneCurve()
+	for level, v := range curve {
+		require.Equal(t, uint8(level), v)
+	}
+
+	curve = ImageOptions{Contrast: 2}.toneCurve()
+	require.Equal(t, uint8(0), curve[60])
+	require.InDelta(t, 128, int(curve[128]), 1)
+	require.Equal(t, uint8(255), curve[200])
+
+	curve = ImageOptions{Gamma: 2}.toneCurve()
+	require.Equal(t, uint8(64), curve[128])
+	require.Equal(t, uint8(255), curve[255])
+}
+
+func Test_imageOptions_tone(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	img.Set(1, 0, color.NRGBA{A: 0})
+
+	require.Same(t, image.Image(img), ImageOptions{Contrast: 1, Gamma: 1}.tone(img))
+
+	gray, ok := ImageOptions{Grayscale: true}.tone(img).(*image.Gray)
+	require.True(t, ok)
+	require.Equal(t, uint8(76), gray.GrayAt(0, 0).Y)
+	require.Equal(t, uint8(255), gray.GrayAt(1, 0).Y, "transparent is white")
+}
+
+func Test_imageOptions_eink(t *testing.T) {
+	name, out, err := ImageProfiles["eink"].Process("001.png", makePNG(t, 2000, 3000))
+	require.NoError(t, err)
+	require.Equal(t, "001.jpg", name)
+
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	require.Equal(t, image.Pt(965, 1448), img.Bounds().Size())
+	require.IsType(t, &image.Gray{}, img, "a single channel jpeg")
+}
@@ -0,0 +1,99 @@
+package cbr2cbz
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/nwaples/rardecode/v2"
+	"github.com/pkg/errors"
+)
+
+// Volumes is a rar split across several files, Saga.part1.cbr,
+// Saga.part2.cbr and so on, passed to Entries and Repack in place of a
+// single archive, with the size of all of them. As an io.ReaderAt it is the
+// first volume, where the archive and its comment start, the rest are
+// opened from FS as the entries get read.
+type Volumes struct {
+	io.ReaderAt
+	FS fs.FS
+	// Name is the first volume in FS, the others are named after it
+	Name string
+}
+
+// rarVolumes is archiver.Rar reading the volumes from FS instead of the
+// stream it is given, which only holds the first.
+type rarVolumes struct {
+	archiver.Rar
+	fs   fs.FS
+	name string
+}
+
+func (r rarVolumes) Extract(ctx context.Context, _ io.Reader, pathsInArchive []string, handleFile archiver.FileHandler) error {
+	var options []rardecode.Option
+	if r.Password != "" {
+		options = append(options, rardecode.Password(r.Password))
+	}
+	rr, err := rardecode.OpenReader(r.name, append(options, rardecode.FileSystem(r.fs))...)
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !volumeEntryIncluded(pathsInArchive, hdr.Name) {
+			continue
+		}
+		err = handleFile(ctx, archiver.File{
+			FileInfo:      volumeFileInfo{hdr},
+			Header:        hdr,
+			NameInArchive: hdr.Name,
+			Open:          func() (io.ReadCloser, error) { return io.NopCloser(rr), nil },
+		})
+		if errors.Is(err, fs.SkipDir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// volumeEntryIncluded is whether name is one of paths or in one of them,
+// or paths is nil, the way archiver picks the entries to extract.
+func volumeEntryIncluded(paths []string, name string) bool {
+	if paths == nil {
+		return true
+	}
+	for _, p := range paths {
+		if name == p || strings.HasPrefix(name, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type volumeFileInfo struct {
+	hdr *rardecode.FileHeader
+}
+
+func (i volumeFileInfo) Name() string       { return path.Base(i.hdr.Name) }
+func (i volumeFileInfo) Size() int64        { return i.hdr.UnPackedSize }
+func (i volumeFileInfo) Mode() fs.FileMode  { return i.hdr.Mode() }
+func (i volumeFileInfo) ModTime() time.Time { return i.hdr.ModificationTime }
+func (i volumeFileInfo) IsDir() bool        { return i.hdr.IsDir }
+func (i volumeFileInfo) Sys() any           { return nil }
@@ -0,0 +1,94 @@
+package cbr2cbz
+
+import (
+	"path"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// stripWrappers takes out folders that only ever hold one other level:
+// the folder every nested entry shares, like Comic Name/, and a folder
+// holding nothing but a folder of the same name, like the second Comic Name
+// of Comic Name/Comic Name/001.jpg. Readers show each of those as a level to
+// click through before any page. Folders of chapters sitting side by side
+// are kept. The shared folder is kept when taking it out would make a nested
+// entry clash with one at the top.
+func (c *Converter) stripWrappers(cbrFile string, files []archiver.File) []archiver.File {
+	out := append([]archiver.File{}, files...)
+
+	// entries already at the top, like a ComicInfo.xml, don't count
+	// towards the shared folder, as with flattenEntries
+	prefix := ""
+	top := map[string]bool{}
+	for _, f := range out {
+		if !strings.Contains(f.NameInArchive, "/") {
+			top[strings.ToLower(f.NameInArchive)] = true
+			continue
+		}
+		if prefix == "" {
+			prefix = path.Dir(f.NameInArchive)
+		}
+		for prefix != "." && !strings.HasPrefix(f.NameInArchive, prefix+"/") {
+			prefix = path.Dir(prefix)
+		}
+	}
+	if prefix != "" && prefix != "." {
+		clash := ""
+		for _, f := range out {
+			if name := strings.TrimPrefix(f.NameInArchive, prefix+"/"); name != f.NameInArchive && top[strings.ToLower(name)] {
+				clash = name
+				break
+			}
+		}
+		if clash != "" {
+			c.warn(CodeWrapperKept, cbrFile, "Keeping the %s folder of %s, %s is already at the top", prefix, cbrFile, clash)
+		} else {
+			for i := range out {
+				out[i].NameInArchive = strings.TrimPrefix(out[i].NameInArchive, prefix+"/")
+			}
+		}
+	}
+
+	for {
+		doubled := doubledFolder(out)
+		if doubled == "" {
+			return out
+		}
+		// everything under it is in the inner folder, so nothing can clash
+		inner := doubled + "/" + path.Base(doubled) + "/"
+		for i, f := range out {
+			if strings.HasPrefix(f.NameInArchive, inner) {
+				out[i].NameInArchive = doubled + "/" + strings.TrimPrefix(f.NameInArchive, inner)
+			}
+		}
+	}
+}
+
+// doubledFolder finds a folder of files that holds nothing but a folder of
+// the same name, returning the outer one or "".
+func doubledFolder(files []archiver.File) string {
+	for _, f := range files {
+		parts := strings.Split(f.NameInArchive, "/")
+		for i := 0; i+2 < len(parts); i++ {
+			if parts[i] != parts[i+1] {
+				continue
+			}
+			outer := strings.Join(parts[:i+1], "/")
+			if onlyHolds(files, outer+"/", outer+"/"+parts[i]+"/") {
+				return outer
+			}
+		}
+	}
+	return ""
+}
+
+// onlyHolds reports whether every entry under dir is under inner.
+func onlyHolds(files []archiver.File, dir string, inner string) bool {
+	for _, f := range files {
+		if strings.HasPrefix(f.NameInArchive, dir) && !strings.HasPrefix(f.NameInArchive, inner) {
+			return false
+		}
+	}
+	return true
+}
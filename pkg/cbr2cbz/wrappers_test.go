@@ -0,0 +1,72 @@
+package cbr2cbz
+
+import (
+	"testing"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_stripWrappers(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+		warns int
+	}{
+		{
+			name:  "doubled wrapper",
+			files: []string{"Saga/Saga/001.jpg", "Saga/Saga/002.jpg"},
+			want:  []string{"001.jpg", "002.jpg"},
+		},
+		{
+			name:  "wrapper around chapters",
+			files: []string{"ComicInfo.xml", "Saga/Ch 1/001.jpg", "Saga/Ch 2/001.jpg"},
+			want:  []string{"ComicInfo.xml", "Ch 1/001.jpg", "Ch 2/001.jpg"},
+		},
+		{
+			name:  "doubled chapter",
+			files: []string{"Ch 1/Ch 1/001.jpg", "Ch 2/001.jpg"},
+			want:  []string{"Ch 1/001.jpg", "Ch 2/001.jpg"},
+		},
+		{
+			name:  "not only a folder",
+			files: []string{"Ch 1/Ch 1/001.jpg", "Ch 1/credits.jpg", "Ch 2/001.jpg"},
+			want:  []string{"Ch 1/Ch 1/001.jpg", "Ch 1/credits.jpg", "Ch 2/001.jpg"},
+		},
+		{
+			name:  "clash with the top",
+			files: []string{"001.jpg", "Saga/001.jpg"},
+			want:  []string{"001.jpg", "Saga/001.jpg"},
+			warns: 1,
+		},
+		{
+			name:  "already flat",
+			files: []string{"001.jpg", "002.jpg"},
+			want:  []string{"001.jpg", "002.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []archiver.File{}
+			for _, name := range tt.files {
+				files = append(files, virtualFile(name, nil))
+			}
+			warns := 0
+			c := newTestConverter(t, Options{StripWrappers: true})
+			c.OnWarning = func(w Warning) {
+				require.Equal(t, CodeWrapperKept, w.Code)
+				warns++
+			}
+
+			got := []string{}
+			for _, f := range c.stripWrappers("test.cbr", files) {
+				got = append(got, f.NameInArchive)
+			}
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.warns, warns)
+			// the input is left alone
+			require.Equal(t, tt.files[0], files[0].NameInArchive)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// cbr2cbz.js loads cbr2cbz.wasm and wraps the converter it sets up. Go's
+// wasm_exec.js has to be loaded before it.
+//
+//   const cbz = await cbr2cbz.convert(file, { strip_junk: true });
+//   // cbz.name, cbz.blob, cbz.entries and cbz.warnings
+(function (global) {
+  let ready = null;
+
+  // load starts the converter, from url or cbr2cbz.wasm next to the page.
+  // convert calls it, calling it early saves the wait on the first file.
+  function load(url) {
+    if (!ready) {
+      const go = new global.Go();
+      ready = fetch(url || "cbr2cbz.wasm")
+        .then((res) => {
+          if (!res.ok) {
+            throw new Error(`loading cbr2cbz.wasm: ${res.status} ${res.statusText}`);
+          }
+          return res.arrayBuffer();
+        })
+        .then((bytes) => WebAssembly.instantiate(bytes, go.importObject))
+        .then((result) => {
+          // runs for as long as the page does
+          go.run(result.instance);
+        });
+    }
+    return ready;
+  }
+
+  // convert turns file, a File or Blob with a name, into a cbz. options are
+  // those of the engine under their JSON names, e.g. strip_junk or
+  // page_order.
+  async function convert(file, options) {
+    await load();
+    const data = new Uint8Array(await file.arrayBuffer());
+    const out = await global.cbr2cbzConvert(file.name, data, options || {});
+    return {
+      name: out.name,
+      blob: new Blob([out.data], { type: "application/vnd.comicbook+zip" }),
+      entries: out.entries,
+      warnings: out.warnings,
+    };
+  }
+
+  global.cbr2cbz = { load, convert };
+})(globalThis);
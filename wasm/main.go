@@ -0,0 +1,131 @@
+//go:build js && wasm
+
+// Command wasm is the converter built for the browser. It converts one
+// archive at a time, entirely in memory, for pages that want one-off
+// conversions without uploading anything. cbr2cbz.js loads it and wraps
+// the function it sets up, see the README for building it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sync"
+	"syscall/js"
+
+	"github.com/hack-pad/hackpadfs"
+	"github.com/hack-pad/hackpadfs/mem"
+	"github.com/halkeye/cbr2cbz/pkg/cbr2cbz"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	js.Global().Set("cbr2cbzConvert", js.FuncOf(convert))
+	// the function has to outlive main
+	select {}
+}
+
+// converted is what cbr2cbzConvert resolves to.
+type converted struct {
+	Name     string
+	Data     []byte
+	Entries  int
+	Warnings []cbr2cbz.Warning
+}
+
+// convert is cbr2cbzConvert(name, data, options), data being the archive as
+// a Uint8Array and options the engine's options as their JSON keys. It
+// returns a promise of the cbz's name, bytes, entry count and warnings.
+func convert(_ js.Value, args []js.Value) any {
+	if len(args) < 2 {
+		return rejected(errors.New("cbr2cbzConvert needs the name and contents of an archive"))
+	}
+	name := path.Base(args[0].String())
+	data := make([]byte, args[1].Get("length").Int())
+	js.CopyBytesToGo(data, args[1])
+	opts := cbr2cbz.Options{}
+	if len(args) > 2 && args[2].Truthy() {
+		encoded := js.Global().Get("JSON").Call("stringify", args[2]).String()
+		if err := json.Unmarshal([]byte(encoded), &opts); err != nil {
+			return rejected(errors.Wrap(err, "parsing options"))
+		}
+	}
+
+	// the conversion can't block the callback, the promise is settled once
+	// it is done
+	var settle func(out *converted, err error)
+	promise := js.Global().Get("Promise").New(js.FuncOf(func(_ js.Value, cb []js.Value) any {
+		resolve, reject := cb[0], cb[1]
+		settle = func(out *converted, err error) {
+			if err != nil {
+				reject.Invoke(jsError(err))
+				return
+			}
+			resolve.Invoke(out.value())
+		}
+		return nil
+	}))
+	go func() {
+		settle(convertBytes(context.Background(), name, data, opts))
+	}()
+	return promise
+}
+
+// convertBytes converts the archive data, called name, to a cbz.
+func convertBytes(ctx context.Context, name string, data []byte, opts cbr2cbz.Options) (*converted, error) {
+	c, err := cbr2cbz.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	out := &converted{Warnings: []cbr2cbz.Warning{}}
+	var mu sync.Mutex
+	c.OnWarning = func(w cbr2cbz.Warning) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.Warnings = append(out.Warnings, w)
+	}
+
+	fsys, err := mem.NewFS()
+	if err != nil {
+		return nil, err
+	}
+	err = hackpadfs.WriteFullFile(fsys, name, data, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "storing archive")
+	}
+	res, err := c.Convert(ctx, fsys, fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	out.Name, out.Entries = res.Output, res.Entries
+	out.Data, err = hackpadfs.ReadFile(fsys, res.Output)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cbz")
+	}
+	return out, nil
+}
+
+// value is out as a JS object, the cbz as a Uint8Array.
+func (out *converted) value() js.Value {
+	data := js.Global().Get("Uint8Array").New(len(out.Data))
+	js.CopyBytesToJS(data, out.Data)
+	warnings := js.Global().Get("Array").New()
+	for _, w := range out.Warnings {
+		warnings.Call("push", map[string]any{"code": w.Code, "message": w.Message})
+	}
+	return js.ValueOf(map[string]any{
+		"name":     out.Name,
+		"data":     data,
+		"entries":  out.Entries,
+		"warnings": warnings,
+	})
+}
+
+func jsError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}
+
+// rejected is a promise already rejected with err.
+func rejected(err error) js.Value {
+	return js.Global().Get("Promise").Call("reject", jsError(err))
+}